@@ -0,0 +1,196 @@
+package device
+
+import (
+	"encoding/binary"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Device capability types (USB 3.2 Table 9-14).
+const (
+	DeviceCapabilityWireless       = 0x01 // Wireless USB
+	DeviceCapabilityUSB20Extension = 0x02 // USB 2.0 extension
+	DeviceCapabilitySuperSpeed     = 0x03 // SuperSpeed USB
+	DeviceCapabilityContainerID    = 0x04 // Container ID
+	DeviceCapabilityPlatform       = 0x05 // Platform specific
+)
+
+// Descriptor sizes for the BOS and device capability descriptors.
+const (
+	BOSDescriptorSize            = 5  // BOS descriptor header
+	USB20ExtensionSize           = 7  // USB 2.0 extension capability
+	PlatformCapabilityHeaderSize = 20 // Platform capability, excluding data
+)
+
+// USB 2.0 extension attributes.
+const (
+	USB20ExtensionLPM  = 1 << 1 // Link Power Management supported
+	USB20ExtensionBESL = 1 << 2 // BESL and alternate HIRD definitions supported
+)
+
+// BOSDescriptor represents the header of a Binary device Object Store
+// descriptor (5 bytes). The device capability descriptors follow it.
+type BOSDescriptor struct {
+	Length         uint8  // Size of this descriptor (5)
+	DescriptorType uint8  // BOS descriptor type (0x0F)
+	TotalLength    uint16 // Total length including capability descriptors
+	NumDeviceCaps  uint8  // Number of device capability descriptors
+}
+
+// MarshalTo serializes the BOS descriptor header to buf.
+// Returns the number of bytes written (always 5 if buf is large enough).
+func (d *BOSDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < BOSDescriptorSize {
+		return 0
+	}
+	buf[0] = BOSDescriptorSize
+	buf[1] = DescriptorTypeBOS
+	binary.LittleEndian.PutUint16(buf[2:4], d.TotalLength)
+	buf[4] = d.NumDeviceCaps
+	return BOSDescriptorSize
+}
+
+// ParseBOSDescriptor parses a BOS descriptor header from data into out.
+// Returns an error if the data is too short or the descriptor type is wrong.
+func ParseBOSDescriptor(data []byte, out *BOSDescriptor) error {
+	if len(data) < BOSDescriptorSize {
+		return pkg.ErrDescriptorTooShort
+	}
+	if data[1] != DescriptorTypeBOS {
+		return pkg.ErrDescriptorTypeMismatch
+	}
+	out.Length = data[0]
+	out.DescriptorType = data[1]
+	out.TotalLength = binary.LittleEndian.Uint16(data[2:4])
+	out.NumDeviceCaps = data[4]
+	return nil
+}
+
+// DeviceCapability is a device capability descriptor reported in the BOS
+// descriptor.
+type DeviceCapability interface {
+	// MarshalTo serializes the capability descriptor to buf.
+	// Returns the number of bytes written, or 0 if buf is too small.
+	MarshalTo(buf []byte) int
+}
+
+// USB20ExtensionDescriptor represents a USB 2.0 extension device
+// capability (7 bytes).
+type USB20ExtensionDescriptor struct {
+	Attributes uint32 // USB20Extension* bits
+}
+
+// MarshalTo serializes the USB 2.0 extension capability to buf.
+// Returns the number of bytes written (always 7 if buf is large enough).
+func (d *USB20ExtensionDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < USB20ExtensionSize {
+		return 0
+	}
+	buf[0] = USB20ExtensionSize
+	buf[1] = DescriptorTypeDeviceCapability
+	buf[2] = DeviceCapabilityUSB20Extension
+	binary.LittleEndian.PutUint32(buf[3:7], d.Attributes)
+	return USB20ExtensionSize
+}
+
+// ParseUSB20ExtensionDescriptor parses a USB 2.0 extension capability from
+// data into out.
+// Returns an error if the data is too short or the descriptor type is wrong.
+func ParseUSB20ExtensionDescriptor(data []byte, out *USB20ExtensionDescriptor) error {
+	if len(data) < USB20ExtensionSize {
+		return pkg.ErrDescriptorTooShort
+	}
+	if data[1] != DescriptorTypeDeviceCapability || data[2] != DeviceCapabilityUSB20Extension {
+		return pkg.ErrDescriptorTypeMismatch
+	}
+	out.Attributes = binary.LittleEndian.Uint32(data[3:7])
+	return nil
+}
+
+// PlatformCapabilityDescriptor represents a platform device capability,
+// identified by a UUID, with platform-specific data.
+type PlatformCapabilityDescriptor struct {
+	UUID [16]byte // Platform capability UUID, in USB (little-endian) byte order
+	Data []byte   // Capability data
+}
+
+// MarshalTo serializes the platform capability to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *PlatformCapabilityDescriptor) MarshalTo(buf []byte) int {
+	length := PlatformCapabilityHeaderSize + len(d.Data)
+	if length > 255 || len(buf) < length {
+		return 0
+	}
+	buf[0] = uint8(length)
+	buf[1] = DescriptorTypeDeviceCapability
+	buf[2] = DeviceCapabilityPlatform
+	buf[3] = 0 // Reserved
+	copy(buf[4:20], d.UUID[:])
+	copy(buf[20:], d.Data)
+	return length
+}
+
+// ParsePlatformCapabilityDescriptor parses a platform capability from data
+// into out. The Data field references data (not copied).
+// Returns an error if the data is too short or the descriptor type is wrong.
+func ParsePlatformCapabilityDescriptor(data []byte, out *PlatformCapabilityDescriptor) error {
+	if len(data) < PlatformCapabilityHeaderSize || int(data[0]) < PlatformCapabilityHeaderSize ||
+		len(data) < int(data[0]) {
+		return pkg.ErrDescriptorTooShort
+	}
+	if data[1] != DescriptorTypeDeviceCapability || data[2] != DeviceCapabilityPlatform {
+		return pkg.ErrDescriptorTypeMismatch
+	}
+	copy(out.UUID[:], data[4:20])
+	out.Data = data[PlatformCapabilityHeaderSize:data[0]]
+	return nil
+}
+
+// AddDeviceCapability adds a device capability to the BOS descriptor.
+// Returns pkg.ErrNoResources if MaxDeviceCapabilities are added.
+func (d *Device) AddDeviceCapability(c DeviceCapability) error {
+	if c == nil {
+		return pkg.ErrInvalidParameter
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.capabilityCount >= MaxDeviceCapabilities {
+		return pkg.ErrNoResources
+	}
+	d.capabilities[d.capabilityCount] = c
+	d.capabilityCount++
+	return nil
+}
+
+// BOSTo writes the BOS descriptor, followed by the device capability
+// descriptors, to buf.
+// Returns the number of bytes written, or 0 if the device has no
+// capabilities or buf is too small.
+func (d *Device) BOSTo(buf []byte) int {
+	d.mutex.RLock()
+	caps := d.capabilities
+	count := d.capabilityCount
+	d.mutex.RUnlock()
+
+	if count == 0 || len(buf) < BOSDescriptorSize {
+		return 0
+	}
+
+	offset := BOSDescriptorSize
+	for idx := 0; idx < count; idx++ {
+		n := caps[idx].MarshalTo(buf[offset:])
+		if n == 0 {
+			return 0
+		}
+		offset += n
+	}
+
+	bos := BOSDescriptor{
+		TotalLength:   uint16(offset),
+		NumDeviceCaps: uint8(count),
+	}
+	bos.MarshalTo(buf)
+	return offset
+}
@@ -0,0 +1,154 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+func TestBOSDescriptorRoundTrip(t *testing.T) {
+	desc := BOSDescriptor{TotalLength: 33, NumDeviceCaps: 2}
+	var buf [BOSDescriptorSize]byte
+	if n := desc.MarshalTo(buf[:]); n != BOSDescriptorSize {
+		t.Fatalf("MarshalTo() = %d, want %d", n, BOSDescriptorSize)
+	}
+	want := []byte{0x05, DescriptorTypeBOS, 0x21, 0x00, 0x02}
+	if !bytes.Equal(buf[:], want) {
+		t.Errorf("MarshalTo() = % X, want % X", buf[:], want)
+	}
+
+	var parsed BOSDescriptor
+	if err := ParseBOSDescriptor(buf[:], &parsed); err != nil {
+		t.Fatalf("ParseBOSDescriptor() error = %v", err)
+	}
+	if parsed.TotalLength != 33 || parsed.NumDeviceCaps != 2 {
+		t.Errorf("parsed = %+v, want TotalLength 33, NumDeviceCaps 2", parsed)
+	}
+
+	if err := ParseBOSDescriptor(buf[:4], &parsed); err != pkg.ErrDescriptorTooShort {
+		t.Errorf("ParseBOSDescriptor(short) error = %v, want %v", err, pkg.ErrDescriptorTooShort)
+	}
+	buf[1] = DescriptorTypeDevice
+	if err := ParseBOSDescriptor(buf[:], &parsed); err != pkg.ErrDescriptorTypeMismatch {
+		t.Errorf("ParseBOSDescriptor(type) error = %v, want %v", err, pkg.ErrDescriptorTypeMismatch)
+	}
+	if n := desc.MarshalTo(buf[:4]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
+func TestUSB20ExtensionDescriptorRoundTrip(t *testing.T) {
+	desc := USB20ExtensionDescriptor{Attributes: USB20ExtensionLPM}
+	var buf [USB20ExtensionSize]byte
+	if n := desc.MarshalTo(buf[:]); n != USB20ExtensionSize {
+		t.Fatalf("MarshalTo() = %d, want %d", n, USB20ExtensionSize)
+	}
+	want := []byte{0x07, DescriptorTypeDeviceCapability, DeviceCapabilityUSB20Extension, 0x02, 0x00, 0x00, 0x00}
+	if !bytes.Equal(buf[:], want) {
+		t.Errorf("MarshalTo() = % X, want % X", buf[:], want)
+	}
+
+	var parsed USB20ExtensionDescriptor
+	if err := ParseUSB20ExtensionDescriptor(buf[:], &parsed); err != nil {
+		t.Fatalf("ParseUSB20ExtensionDescriptor() error = %v", err)
+	}
+	if parsed.Attributes != USB20ExtensionLPM {
+		t.Errorf("Attributes = 0x%X, want 0x%X", parsed.Attributes, USB20ExtensionLPM)
+	}
+}
+
+func TestPlatformCapabilityDescriptorRoundTrip(t *testing.T) {
+	desc := PlatformCapabilityDescriptor{UUID: MSOS20PlatformUUID, Data: []byte{1, 2, 3}}
+	var buf [32]byte
+	n := desc.MarshalTo(buf[:])
+	if n != PlatformCapabilityHeaderSize+3 {
+		t.Fatalf("MarshalTo() = %d, want %d", n, PlatformCapabilityHeaderSize+3)
+	}
+	if buf[0] != uint8(n) || buf[2] != DeviceCapabilityPlatform || buf[3] != 0 {
+		t.Errorf("header = % X", buf[:4])
+	}
+
+	var parsed PlatformCapabilityDescriptor
+	if err := ParsePlatformCapabilityDescriptor(buf[:n], &parsed); err != nil {
+		t.Fatalf("ParsePlatformCapabilityDescriptor() error = %v", err)
+	}
+	if parsed.UUID != MSOS20PlatformUUID || !bytes.Equal(parsed.Data, []byte{1, 2, 3}) {
+		t.Errorf("parsed = %+v", parsed)
+	}
+	if err := ParsePlatformCapabilityDescriptor(buf[:n-1], &parsed); err != pkg.ErrDescriptorTooShort {
+		t.Errorf("ParsePlatformCapabilityDescriptor(short) error = %v, want %v", err, pkg.ErrDescriptorTooShort)
+	}
+	if n := desc.MarshalTo(buf[:PlatformCapabilityHeaderSize]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
+func TestDeviceBOSTo(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	var buf [64]byte
+	if n := dev.BOSTo(buf[:]); n != 0 {
+		t.Errorf("BOSTo(no capabilities) = %d, want 0", n)
+	}
+
+	if err := dev.AddDeviceCapability(&USB20ExtensionDescriptor{Attributes: USB20ExtensionLPM}); err != nil {
+		t.Fatalf("AddDeviceCapability() error = %v", err)
+	}
+	if err := dev.AddDeviceCapability(&PlatformCapabilityDescriptor{UUID: MSOS20PlatformUUID}); err != nil {
+		t.Fatalf("AddDeviceCapability() error = %v", err)
+	}
+
+	n := dev.BOSTo(buf[:])
+	want := BOSDescriptorSize + USB20ExtensionSize + PlatformCapabilityHeaderSize
+	if n != want {
+		t.Fatalf("BOSTo() = %d, want %d", n, want)
+	}
+	var bos BOSDescriptor
+	if err := ParseBOSDescriptor(buf[:n], &bos); err != nil {
+		t.Fatalf("ParseBOSDescriptor() error = %v", err)
+	}
+	if int(bos.TotalLength) != want || bos.NumDeviceCaps != 2 {
+		t.Errorf("BOS = %+v, want TotalLength %d, NumDeviceCaps 2", bos, want)
+	}
+	if buf[BOSDescriptorSize+2] != DeviceCapabilityUSB20Extension {
+		t.Errorf("first capability type = 0x%02X, want 0x%02X", buf[BOSDescriptorSize+2], DeviceCapabilityUSB20Extension)
+	}
+
+	if n := dev.BOSTo(buf[:want-1]); n != 0 {
+		t.Errorf("BOSTo(short) = %d, want 0", n)
+	}
+}
+
+func TestDeviceAddDeviceCapabilityLimits(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	if err := dev.AddDeviceCapability(nil); err != pkg.ErrInvalidParameter {
+		t.Errorf("AddDeviceCapability(nil) error = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+	for i := 0; i < MaxDeviceCapabilities; i++ {
+		if err := dev.AddDeviceCapability(&USB20ExtensionDescriptor{}); err != nil {
+			t.Fatalf("AddDeviceCapability(%d) error = %v", i, err)
+		}
+	}
+	if err := dev.AddDeviceCapability(&USB20ExtensionDescriptor{}); err != pkg.ErrNoResources {
+		t.Errorf("AddDeviceCapability() error = %v, want %v", err, pkg.ErrNoResources)
+	}
+}
+
+func TestDeviceBuilderWithDeviceCapability(t *testing.T) {
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		WithDeviceCapability(&USB20ExtensionDescriptor{Attributes: USB20ExtensionLPM}).
+		AddConfiguration(1).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if dev.Descriptor.USBVersion != 0x0210 {
+		t.Errorf("USBVersion = 0x%04X, want 0x0210", dev.Descriptor.USBVersion)
+	}
+	var buf [32]byte
+	if n := dev.BOSTo(buf[:]); n != BOSDescriptorSize+USB20ExtensionSize {
+		t.Errorf("BOSTo() = %d, want %d", n, BOSDescriptorSize+USB20ExtensionSize)
+	}
+}
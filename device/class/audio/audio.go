@@ -0,0 +1,687 @@
+package audio
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Stream identifies an audio stream.
+type Stream int
+
+// Audio streams.
+const (
+	Speaker    Stream = iota // Playback, host to device (isochronous OUT)
+	Microphone               // Capture, device to host (isochronous IN)
+)
+
+// String returns the stream name.
+func (s Stream) String() string {
+	switch s {
+	case Speaker:
+		return "speaker"
+	case Microphone:
+		return "microphone"
+	default:
+		return "unknown"
+	}
+}
+
+// stream holds the state of one AudioStreaming interface.
+type stream struct {
+	enabled  bool
+	format   Format
+	ifaceNum uint8
+	epAddr   uint8
+
+	// Interface and isochronous endpoint (in alternate setting 1)
+	iface *device.Interface
+	ep    *device.Endpoint
+
+	// Controls
+	active     bool
+	sampleRate uint32
+	volume     int16
+	mute       bool
+
+	// Isochronous transfer, reused by each Read or Write
+	xfer      *device.Transfer
+	done      chan struct{}
+	sizes     [MaxPacketsPerTransfer]int
+	xferMutex sync.Mutex
+}
+
+// Audio implements a USB Audio Class 1.0 class driver with an optional
+// speaker (playback) stream and an optional microphone (capture) stream.
+type Audio struct {
+	// Interfaces
+	controlIface    *device.Interface
+	controlIfaceNum uint8
+
+	// Streams, indexed by Stream
+	streams [MaxStreams]stream
+
+	// Stack reference for data transfer
+	stack *device.Stack
+
+	// Volume range, in 1/256 dB steps
+	volumeMin int16
+	volumeMax int16
+	volumeRes int16
+
+	// Callbacks
+	onVolumeChange     func(s Stream, volume int16)
+	onMuteChange       func(s Stream, mute bool)
+	onSampleRateChange func(s Stream, rate uint32)
+	onStreamingChange  func(s Stream, active bool)
+
+	// Buffers (zero-allocation)
+	controlDesc [MaxControlDescriptorSize]byte
+	responseBuf [MaxResponseSize]byte
+
+	// State
+	mutex      sync.RWMutex
+	configured bool
+}
+
+// New creates a new audio class driver. A nil format omits that stream;
+// at least one of speaker and microphone should be given. Each stream starts
+// at the first sample rate of its format, unmuted, at 0 dB.
+func New(speaker, microphone *Format) *Audio {
+	a := &Audio{
+		volumeMin: DefaultVolumeMin,
+		volumeMax: DefaultVolumeMax,
+		volumeRes: DefaultVolumeRes,
+	}
+	for s, f := range [MaxStreams]*Format{speaker, microphone} {
+		if f == nil {
+			continue
+		}
+		st := &a.streams[s]
+		st.enabled = true
+		st.format = *f
+		st.sampleRate = f.SampleRates[0]
+	}
+	return a
+}
+
+// SetStack sets the device stack reference for data transfer.
+func (a *Audio) SetStack(stack *device.Stack) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.stack = stack
+}
+
+// SetVolumeRange sets the volume range reported to the host, in 1/256 dB
+// steps. Must be called before the host reads the range.
+func (a *Audio) SetVolumeRange(min, max, res int16) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.volumeMin = min
+	a.volumeMax = max
+	a.volumeRes = res
+}
+
+// SetOnVolumeChange sets the callback for volume changes.
+func (a *Audio) SetOnVolumeChange(cb func(s Stream, volume int16)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.onVolumeChange = cb
+}
+
+// SetOnMuteChange sets the callback for mute changes.
+func (a *Audio) SetOnMuteChange(cb func(s Stream, mute bool)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.onMuteChange = cb
+}
+
+// SetOnSampleRateChange sets the callback for sample rate changes.
+func (a *Audio) SetOnSampleRateChange(cb func(s Stream, rate uint32)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.onSampleRateChange = cb
+}
+
+// SetOnStreamingChange sets the callback invoked when the host starts or
+// stops a stream by selecting its alternate setting.
+func (a *Audio) SetOnStreamingChange(cb func(s Stream, active bool)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.onStreamingChange = cb
+}
+
+// Volume returns the current volume of a stream, in 1/256 dB steps.
+func (a *Audio) Volume(s Stream) int16 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if !a.validStream(s) {
+		return 0
+	}
+	return a.streams[s].volume
+}
+
+// Mute returns true if a stream is muted.
+func (a *Audio) Mute(s Stream) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if !a.validStream(s) {
+		return false
+	}
+	return a.streams[s].mute
+}
+
+// SampleRate returns the current sample rate of a stream in Hz.
+func (a *Audio) SampleRate(s Stream) uint32 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if !a.validStream(s) {
+		return 0
+	}
+	return a.streams[s].sampleRate
+}
+
+// Streaming returns true if the host has selected the streaming alternate
+// setting of a stream.
+func (a *Audio) Streaming(s Stream) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if !a.validStream(s) {
+		return false
+	}
+	return a.streams[s].active
+}
+
+// Format returns the format of a stream.
+func (a *Audio) Format(s Stream) Format {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if !a.validStream(s) {
+		return Format{}
+	}
+	return a.streams[s].format
+}
+
+// Init initializes the class driver for the given interface.
+// This is called by the device stack when the class driver is attached.
+func (a *Audio) Init(iface *device.Interface) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch iface.SubClass {
+	case SubclassAudioControl:
+		a.controlIface = iface
+
+	case SubclassAudioStreaming:
+		st := a.streamForInterfaceLocked(iface.Number)
+		if st == nil {
+			return pkg.ErrInvalidRequest
+		}
+
+		// The data endpoint lives in the streaming alternate setting
+		alt := iface.Alternate(1)
+		if alt == nil {
+			return pkg.ErrInvalidEndpoint
+		}
+		for _, ep := range alt.Endpoints() {
+			if ep.IsIsochronous() {
+				st.ep = ep
+				break
+			}
+		}
+		if st.ep == nil {
+			return pkg.ErrInvalidEndpoint
+		}
+
+		st.iface = iface
+		st.done = make(chan struct{}, 1)
+		st.xfer = device.NewIsochronousTransfer(st.ep, nil, 0)
+		st.xfer.WithCallback(func(*device.Transfer) {
+			st.done <- struct{}{}
+		})
+	}
+
+	// Check if fully configured
+	a.configured = a.controlIface != nil
+	for s := range a.streams {
+		if a.streams[s].enabled && a.streams[s].ep == nil {
+			a.configured = false
+		}
+	}
+	if a.configured {
+		pkg.LogDebug(pkg.ComponentDevice, "audio configured",
+			"speaker", a.streams[Speaker].enabled,
+			"microphone", a.streams[Microphone].enabled)
+	}
+
+	return nil
+}
+
+// HandleSetup processes class-specific SETUP requests: feature unit
+// controls on the AudioControl interface and sampling frequency controls on
+// the streaming endpoints.
+func (a *Audio) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+	if !a.isAudioRequest(setup) {
+		return nil, false, nil
+	}
+
+	n, err := a.handleRequest(setup, data)
+	if err != nil {
+		return nil, true, err
+	}
+	return a.responseBuf[:n], true, nil
+}
+
+// SetAlternate handles alternate setting changes. Alternate setting 1 of an
+// AudioStreaming interface starts the stream; setting 0 stops it.
+func (a *Audio) SetAlternate(iface *device.Interface, alt uint8) error {
+	a.mutex.Lock()
+	st := a.streamForInterfaceLocked(iface.Number)
+	if st == nil {
+		a.mutex.Unlock()
+		return nil
+	}
+	s := a.streamIndexLocked(st)
+	active := alt != 0
+	changed := st.active != active
+	st.active = active
+	if changed && st.ep != nil {
+		st.ep.SetFrameNumber(0)
+	}
+	cb := a.onStreamingChange
+	a.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentDevice, "audio alternate setting",
+		"interface", iface.Number,
+		"stream", s,
+		"alt", alt)
+
+	if changed && cb != nil {
+		cb(s, active)
+	}
+	return nil
+}
+
+// Close releases resources held by the class driver.
+func (a *Audio) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.controlIface = nil
+	for s := range a.streams {
+		st := &a.streams[s]
+		st.iface = nil
+		st.ep = nil
+		st.active = false
+	}
+	a.stack = nil
+	a.configured = false
+
+	return nil
+}
+
+// Read receives speaker audio from the host (blocking).
+// The host sends one packet per frame; buf is divided into up to
+// MaxPacketsPerTransfer packets of the endpoint's maximum packet size, and
+// the received samples are packed at the start of buf.
+// Returns the number of bytes received.
+func (a *Audio) Read(ctx context.Context, buf []byte) (int, error) {
+	st, stack, err := a.streamForTransfer(Speaker)
+	if err != nil {
+		return 0, err
+	}
+
+	st.xferMutex.Lock()
+	defer st.xferMutex.Unlock()
+
+	maxPacket := int(st.ep.MaxPacketSize)
+	numPackets := len(buf) / maxPacket
+	if numPackets == 0 {
+		return 0, pkg.ErrBufferTooSmall
+	}
+	if numPackets > MaxPacketsPerTransfer {
+		numPackets = MaxPacketsPerTransfer
+	}
+	for i := 0; i < numPackets; i++ {
+		st.sizes[i] = maxPacket
+	}
+
+	if err := st.transfer(ctx, stack, buf, numPackets); err != nil {
+		return 0, err
+	}
+
+	// Pack received samples; short and failed packets leave gaps
+	n := 0
+	for i := 0; i < numPackets; i++ {
+		p := st.xfer.IsoPacket(i)
+		if p.Status != pkg.TransferStatusSuccess {
+			continue
+		}
+		n += copy(buf[n:], buf[p.Offset:p.Offset+p.ActualLength])
+	}
+	return n, nil
+}
+
+// Write sends microphone audio to the host (blocking).
+// data is divided into one packet per frame, sized from the current sample
+// rate and the endpoint frame number so that fractional rates such as
+// 44.1 kHz average out. Up to MaxPacketsPerTransfer frames are sent per call.
+// Returns the number of bytes sent.
+func (a *Audio) Write(ctx context.Context, data []byte) (int, error) {
+	st, stack, err := a.streamForTransfer(Microphone)
+	if err != nil {
+		return 0, err
+	}
+
+	st.xferMutex.Lock()
+	defer st.xferMutex.Unlock()
+
+	a.mutex.RLock()
+	rate := st.sampleRate
+	a.mutex.RUnlock()
+
+	frameSize := st.format.FrameSize()
+	frame := st.ep.FrameNumber()
+	numPackets := 0
+	offset := 0
+	for numPackets < MaxPacketsPerTransfer && offset < len(data) {
+		size := SamplesPerFrame(rate, frame+uint16(numPackets)) * frameSize
+		if size > len(data)-offset {
+			size = len(data) - offset
+		}
+		st.sizes[numPackets] = size
+		offset += size
+		numPackets++
+	}
+	if numPackets == 0 {
+		return 0, nil
+	}
+
+	if err := st.transfer(ctx, stack, data[:offset], numPackets); err != nil {
+		return 0, err
+	}
+	return st.xfer.Length, nil
+}
+
+// ConfigureDevice adds the AudioControl interface and one AudioStreaming
+// interface per stream to a device builder. Call this after AddConfiguration.
+//
+// controlIfaceNum is the number the builder assigns to the AudioControl
+// interface (the number of interfaces already in the configuration); the
+// streaming interfaces follow it, speaker first. outEPAddr is the speaker
+// data endpoint and inEPAddr the microphone data endpoint; the address of an
+// omitted stream is ignored.
+func (a *Audio) ConfigureDevice(builder *device.DeviceBuilder, controlIfaceNum, outEPAddr, inEPAddr uint8) *device.DeviceBuilder {
+	a.mutex.Lock()
+	a.assignInterfacesLocked(controlIfaceNum)
+	a.streams[Speaker].epAddr = outEPAddr & 0x0F
+	a.streams[Microphone].epAddr = inEPAddr | device.EndpointDirectionIn
+	n := a.marshalControlLocked(a.controlDesc[:])
+	a.mutex.Unlock()
+
+	// AudioControl interface with the terminal and feature unit topology
+	builder.AddInterface(ClassAudio, SubclassAudioControl, ProtocolUndefined)
+	builder.AddClassDescriptor(a.controlDesc[:n])
+
+	for s := range a.streams {
+		st := &a.streams[s]
+		if !st.enabled {
+			continue
+		}
+
+		// Alternate setting 0: zero bandwidth
+		builder.AddInterface(ClassAudio, SubclassAudioStreaming, ProtocolUndefined)
+
+		// Alternate setting 1: streaming
+		builder.AddAlternateSetting()
+		var buf [ASGeneralDescriptorSize + 8 + 3*MaxSampleRates]byte
+		general := ASGeneralDescriptor{
+			TerminalLink: streamTerminalLink(Stream(s)),
+			Delay:        1,
+			FormatTag:    FormatPCM,
+		}
+		n := general.MarshalTo(buf[:])
+		n += st.format.MarshalTo(buf[n:])
+		builder.AddClassDescriptor(buf[:n])
+
+		syncType := uint8(device.IsoSyncAdaptive)
+		if Stream(s) == Microphone {
+			syncType = device.IsoSyncAsync
+		}
+		builder.AddEndpointDescriptor(&device.EndpointDescriptor{
+			Length:          device.EndpointDescriptorSize,
+			DescriptorType:  device.DescriptorTypeEndpoint,
+			EndpointAddress: st.epAddr,
+			Attributes:      device.EndpointTypeIsochronous | syncType,
+			MaxPacketSize:   uint16(st.format.MaxPacketSize()),
+			Interval:        1,
+		})
+		// Audio 1.0 endpoints extend the standard descriptor to 9 bytes with
+		// bRefresh and bSynchAddress (no synchronization endpoint)
+		builder.WithEndpointExtension([]byte{0, 0})
+
+		epGeneral := EndpointGeneralDescriptor{Attributes: EndpointSamplingFreq}
+		n = epGeneral.MarshalTo(buf[:])
+		builder.AddClassDescriptor(buf[:n])
+	}
+
+	return builder
+}
+
+// AttachToInterfaces attaches this class driver to the audio interfaces.
+// configValue is the configuration value (e.g., 1), controlIfaceNum is the
+// AudioControl interface number given to ConfigureDevice.
+func (a *Audio) AttachToInterfaces(dev *device.Device, configValue, controlIfaceNum uint8) error {
+	config := dev.GetConfiguration(configValue)
+	if config == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	controlIface := config.GetInterface(controlIfaceNum)
+	if controlIface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	a.mutex.Lock()
+	a.assignInterfacesLocked(controlIfaceNum)
+	a.mutex.Unlock()
+
+	if err := controlIface.SetClassDriver(a); err != nil {
+		return err
+	}
+
+	for s := range a.streams {
+		st := &a.streams[s]
+		if !st.enabled {
+			continue
+		}
+		iface := config.GetInterface(st.ifaceNum)
+		if iface == nil {
+			return pkg.ErrInvalidRequest
+		}
+		if err := iface.SetClassDriver(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SamplesPerFrame returns the number of samples carried in a frame at the
+// given sample rate. Frames in each run of 1000 carry rate/1000 samples on
+// average, so a 44.1 kHz stream sends nine 44-sample frames and then one
+// 45-sample frame.
+func SamplesPerFrame(rate uint32, frame uint16) int {
+	f := uint64(frame % 1000)
+	r := uint64(rate)
+	return int(r*(f+1)/1000 - r*f/1000)
+}
+
+// transfer submits the reusable isochronous transfer and waits for it to
+// complete. The caller must hold xferMutex and have filled sizes.
+func (st *stream) transfer(ctx context.Context, stack *device.Stack, buf []byte, numPackets int) error {
+	t := st.xfer
+	t.Reset()
+	t.Buffer = buf
+	t.NumIsoPackets = numPackets
+	t.WithContext(ctx)
+	t.SetupIsoPacketsVariable(st.sizes[:numPackets])
+
+	if err := stack.SubmitTransfer(t); err != nil {
+		return err
+	}
+	<-st.done
+	return t.Error
+}
+
+// streamForTransfer returns a stream ready for data transfer.
+func (a *Audio) streamForTransfer(s Stream) (*stream, *device.Stack, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	st := &a.streams[s]
+	if !a.configured || a.stack == nil || !st.enabled || st.ep == nil {
+		return nil, nil, pkg.ErrNotConfigured
+	}
+	if !st.active {
+		return nil, nil, pkg.ErrInvalidState
+	}
+	return st, a.stack, nil
+}
+
+// validStream returns true if s is a configured stream.
+func (a *Audio) validStream(s Stream) bool {
+	return s >= 0 && int(s) < MaxStreams && a.streams[s].enabled
+}
+
+// assignInterfacesLocked numbers the streaming interfaces after the
+// AudioControl interface (caller must hold a.mutex).
+func (a *Audio) assignInterfacesLocked(controlIfaceNum uint8) {
+	a.controlIfaceNum = controlIfaceNum
+	num := controlIfaceNum
+	for s := range a.streams {
+		if a.streams[s].enabled {
+			num++
+			a.streams[s].ifaceNum = num
+		}
+	}
+}
+
+// streamForInterfaceLocked returns the stream using an interface number
+// (caller must hold a.mutex).
+func (a *Audio) streamForInterfaceLocked(num uint8) *stream {
+	for s := range a.streams {
+		if a.streams[s].enabled && a.streams[s].ifaceNum == num {
+			return &a.streams[s]
+		}
+	}
+	return nil
+}
+
+// streamForEndpointLocked returns the stream using an endpoint address
+// (caller must hold a.mutex).
+func (a *Audio) streamForEndpointLocked(addr uint8) (Stream, *stream) {
+	for s := range a.streams {
+		if a.streams[s].enabled && a.streams[s].epAddr == addr {
+			return Stream(s), &a.streams[s]
+		}
+	}
+	return 0, nil
+}
+
+// streamIndexLocked returns the Stream identifying st.
+func (a *Audio) streamIndexLocked(st *stream) Stream {
+	if st == &a.streams[Microphone] {
+		return Microphone
+	}
+	return Speaker
+}
+
+// marshalControlLocked writes the AudioControl class-specific descriptors
+// (caller must hold a.mutex). Returns the number of bytes written.
+func (a *Audio) marshalControlLocked(buf []byte) int {
+	header := HeaderDescriptor{ADCRelease: ADCRelease}
+	total := 0
+	for s := range a.streams {
+		st := &a.streams[s]
+		if !st.enabled {
+			continue
+		}
+		header.InterfaceNumbers[header.NumInterfaces] = st.ifaceNum
+		header.NumInterfaces++
+		fu := FeatureUnitDescriptor{NumChannels: int(st.format.Channels)}
+		total += InputTerminalDescriptorSize + fu.Size() + OutputTerminalDescriptorSize
+	}
+	header.TotalLength = uint16(header.Size() + total)
+
+	offset := header.MarshalTo(buf)
+	if offset == 0 {
+		return 0
+	}
+
+	for s := range a.streams {
+		st := &a.streams[s]
+		if !st.enabled {
+			continue
+		}
+
+		it := InputTerminalDescriptor{
+			NrChannels:    st.format.Channels,
+			ChannelConfig: channelConfig(st.format.Channels),
+		}
+		fu := FeatureUnitDescriptor{NumChannels: int(st.format.Channels)}
+		fu.Controls[0] = FeatureMute | FeatureVolume
+		ot := OutputTerminalDescriptor{}
+
+		if Stream(s) == Speaker {
+			it.TerminalID, it.TerminalType = IDSpeakerInput, TerminalUSBStreaming
+			fu.UnitID, fu.SourceID = IDSpeakerFeature, IDSpeakerInput
+			ot.TerminalID, ot.TerminalType, ot.SourceID = IDSpeakerOutput, TerminalSpeaker, IDSpeakerFeature
+		} else {
+			it.TerminalID, it.TerminalType = IDMicrophoneInput, TerminalMicrophone
+			fu.UnitID, fu.SourceID = IDMicrophoneFeature, IDMicrophoneInput
+			ot.TerminalID, ot.TerminalType, ot.SourceID = IDMicrophoneOutput, TerminalUSBStreaming, IDMicrophoneFeature
+		}
+
+		n := it.MarshalTo(buf[offset:])
+		if n == 0 {
+			return 0
+		}
+		offset += n
+
+		n = fu.MarshalTo(buf[offset:])
+		if n == 0 {
+			return 0
+		}
+		offset += n
+
+		n = ot.MarshalTo(buf[offset:])
+		if n == 0 {
+			return 0
+		}
+		offset += n
+	}
+	return offset
+}
+
+// channelConfig returns the spatial locations of the logical channels.
+// Mono and multichannel streams report no predefined locations.
+func channelConfig(channels uint8) uint16 {
+	if channels == 2 {
+		return ChannelLeftFront | ChannelRightFront
+	}
+	return 0
+}
+
+// streamTerminalLink returns the ID of the terminal connected to a stream's
+// data endpoint.
+func streamTerminalLink(s Stream) uint8 {
+	if s == Microphone {
+		return IDMicrophoneOutput
+	}
+	return IDSpeakerInput
+}
+
+// Compile-time interface check
+var _ device.ClassDriver = (*Audio)(nil)
@@ -0,0 +1,451 @@
+package audio
+
+// Audio class codes.
+const (
+	ClassAudio = 0x01 // Audio Class
+)
+
+// Audio subclass codes.
+const (
+	SubclassUndefined      = 0x00 // Undefined
+	SubclassAudioControl   = 0x01 // AudioControl Interface
+	SubclassAudioStreaming = 0x02 // AudioStreaming Interface
+	SubclassMIDIStreaming  = 0x03 // MIDIStreaming Interface
+)
+
+// Audio protocol codes.
+const (
+	ProtocolUndefined = 0x00 // UAC 1.0 (no protocol)
+)
+
+// Audio class-specific descriptor types.
+const (
+	DescriptorTypeCSInterface = 0x24 // Class-specific Interface
+	DescriptorTypeCSEndpoint  = 0x25 // Class-specific Endpoint
+)
+
+// AudioControl interface descriptor subtypes (UAC 1.0 Table A-5).
+const (
+	SubtypeHeader         = 0x01 // Class-specific AC Interface Header
+	SubtypeInputTerminal  = 0x02 // Input Terminal
+	SubtypeOutputTerminal = 0x03 // Output Terminal
+	SubtypeMixerUnit      = 0x04 // Mixer Unit
+	SubtypeSelectorUnit   = 0x05 // Selector Unit
+	SubtypeFeatureUnit    = 0x06 // Feature Unit
+	SubtypeProcessingUnit = 0x07 // Processing Unit
+	SubtypeExtensionUnit  = 0x08 // Extension Unit
+)
+
+// AudioStreaming interface descriptor subtypes (UAC 1.0 Table A-6).
+const (
+	SubtypeASGeneral      = 0x01 // AS General
+	SubtypeFormatType     = 0x02 // Format Type
+	SubtypeFormatSpecific = 0x03 // Format Specific
+)
+
+// Class-specific endpoint descriptor subtypes (UAC 1.0 Table A-8).
+const (
+	SubtypeEndpointGeneral = 0x01 // EP General
+)
+
+// Audio class request codes (UAC 1.0 Table A-9).
+const (
+	RequestSetCur  = 0x01
+	RequestSetMin  = 0x02
+	RequestSetMax  = 0x03
+	RequestSetRes  = 0x04
+	RequestSetMem  = 0x05
+	RequestGetCur  = 0x81
+	RequestGetMin  = 0x82
+	RequestGetMax  = 0x83
+	RequestGetRes  = 0x84
+	RequestGetMem  = 0x85
+	RequestGetStat = 0xFF
+)
+
+// Feature unit control selectors (UAC 1.0 Table A-11).
+const (
+	ControlMute             = 0x01
+	ControlVolume           = 0x02
+	ControlBass             = 0x03
+	ControlMid              = 0x04
+	ControlTreble           = 0x05
+	ControlGraphicEqualizer = 0x06
+	ControlAutomaticGain    = 0x07
+	ControlDelay            = 0x08
+	ControlBassBoost        = 0x09
+	ControlLoudness         = 0x0A
+)
+
+// Feature unit bmaControls bits.
+const (
+	FeatureMute   = 1 << 0
+	FeatureVolume = 1 << 1
+)
+
+// Endpoint control selectors (UAC 1.0 Table A-19).
+const (
+	ControlSamplingFreq = 0x01
+	ControlPitch        = 0x02
+)
+
+// Class-specific isochronous endpoint bmAttributes bits.
+const (
+	EndpointSamplingFreq   = 1 << 0 // Sampling frequency control supported
+	EndpointPitch          = 1 << 1 // Pitch control supported
+	EndpointMaxPacketsOnly = 1 << 7 // Packets must be wMaxPacketSize long
+)
+
+// Terminal types (USB Audio Terminal Types 1.0).
+const (
+	TerminalUSBStreaming = 0x0101 // USB streaming
+	TerminalMicrophone   = 0x0201 // Microphone
+	TerminalSpeaker      = 0x0301 // Speaker
+	TerminalHeadphones   = 0x0302 // Headphones
+)
+
+// Audio data format tags (USB Audio Data Formats 1.0).
+const (
+	FormatPCM       = 0x0001 // PCM
+	FormatPCM8      = 0x0002 // 8-bit unsigned PCM
+	FormatIEEEFloat = 0x0003 // IEEE float
+)
+
+// Audio Device Class release number.
+const ADCRelease = 0x0100
+
+// Format type codes.
+const (
+	FormatTypeI = 0x01 // Type I (PCM-like, one sample per channel per subframe)
+)
+
+// Spatial channel locations (wChannelConfig).
+const (
+	ChannelLeftFront  = 1 << 0
+	ChannelRightFront = 1 << 1
+)
+
+// Volume control values, in 1/256 dB steps.
+const (
+	VolumeSilence    = -0x8000 // Negative infinity
+	DefaultVolumeMin = -0x3C00 // -60 dB
+	DefaultVolumeMax = 0x0000  // 0 dB
+	DefaultVolumeRes = 0x0100  // 1 dB
+)
+
+// Terminal and unit IDs used in the AudioControl topology.
+// The speaker path is USB streaming -> feature unit -> speaker.
+// The microphone path is microphone -> feature unit -> USB streaming.
+const (
+	IDSpeakerInput      = 1
+	IDSpeakerFeature    = 2
+	IDSpeakerOutput     = 3
+	IDMicrophoneInput   = 4
+	IDMicrophoneFeature = 5
+	IDMicrophoneOutput  = 6
+)
+
+// Limits.
+const (
+	// MaxChannels is the maximum number of channels in a stream.
+	MaxChannels = 8
+
+	// MaxSampleRates is the maximum number of discrete sample rates per format.
+	MaxSampleRates = 8
+
+	// MaxPacketsPerTransfer is the maximum number of frames moved by one
+	// call to Read or Write.
+	MaxPacketsPerTransfer = 32
+
+	// MaxStreams is the maximum number of AudioStreaming interfaces
+	// (one speaker stream and one microphone stream).
+	MaxStreams = 2
+
+	// MaxControlDescriptorSize is the maximum size of the AudioControl
+	// class-specific descriptors.
+	MaxControlDescriptorSize = 128
+)
+
+// Control request payload sizes.
+const (
+	MuteSize        = 1 // Mute control (bMute)
+	VolumeSize      = 2 // Volume control (wVolume, signed)
+	SampleFreqSize  = 3 // Sampling frequency control (tSampleFreq)
+	MaxResponseSize = 3 // Largest control request payload
+)
+
+// HeaderDescriptor is the class-specific AudioControl interface header.
+type HeaderDescriptor struct {
+	ADCRelease       uint16            // Audio Device Class release number (0x0100)
+	TotalLength      uint16            // Total length of the AC class-specific descriptors
+	InterfaceNumbers [MaxStreams]uint8 // AudioStreaming interface numbers
+	NumInterfaces    int               // Number of AudioStreaming interfaces
+}
+
+// Size returns the size of the header descriptor in bytes.
+func (d *HeaderDescriptor) Size() int {
+	return 8 + d.NumInterfaces
+}
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *HeaderDescriptor) MarshalTo(buf []byte) int {
+	size := d.Size()
+	if len(buf) < size || d.NumInterfaces > MaxStreams {
+		return 0
+	}
+	buf[0] = byte(size)
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeHeader
+	buf[3] = byte(d.ADCRelease)
+	buf[4] = byte(d.ADCRelease >> 8)
+	buf[5] = byte(d.TotalLength)
+	buf[6] = byte(d.TotalLength >> 8)
+	buf[7] = uint8(d.NumInterfaces)
+	copy(buf[8:size], d.InterfaceNumbers[:d.NumInterfaces])
+	return size
+}
+
+// InputTerminalDescriptor describes an input terminal.
+type InputTerminalDescriptor struct {
+	TerminalID    uint8  // Unique terminal ID
+	TerminalType  uint16 // Terminal type (TerminalUSBStreaming, TerminalMicrophone, ...)
+	AssocTerminal uint8  // Associated output terminal ID
+	NrChannels    uint8  // Number of logical output channels
+	ChannelConfig uint16 // Spatial location of the logical channels
+	ChannelNames  uint8  // String index of the first channel name
+	Terminal      uint8  // String index of the terminal name
+}
+
+// InputTerminalDescriptorSize is the size of the Input Terminal Descriptor.
+const InputTerminalDescriptorSize = 12
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *InputTerminalDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < InputTerminalDescriptorSize {
+		return 0
+	}
+	buf[0] = InputTerminalDescriptorSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeInputTerminal
+	buf[3] = d.TerminalID
+	buf[4] = byte(d.TerminalType)
+	buf[5] = byte(d.TerminalType >> 8)
+	buf[6] = d.AssocTerminal
+	buf[7] = d.NrChannels
+	buf[8] = byte(d.ChannelConfig)
+	buf[9] = byte(d.ChannelConfig >> 8)
+	buf[10] = d.ChannelNames
+	buf[11] = d.Terminal
+	return InputTerminalDescriptorSize
+}
+
+// OutputTerminalDescriptor describes an output terminal.
+type OutputTerminalDescriptor struct {
+	TerminalID    uint8  // Unique terminal ID
+	TerminalType  uint16 // Terminal type (TerminalUSBStreaming, TerminalSpeaker, ...)
+	AssocTerminal uint8  // Associated input terminal ID
+	SourceID      uint8  // ID of the unit or terminal connected to this terminal
+	Terminal      uint8  // String index of the terminal name
+}
+
+// OutputTerminalDescriptorSize is the size of the Output Terminal Descriptor.
+const OutputTerminalDescriptorSize = 9
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *OutputTerminalDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < OutputTerminalDescriptorSize {
+		return 0
+	}
+	buf[0] = OutputTerminalDescriptorSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeOutputTerminal
+	buf[3] = d.TerminalID
+	buf[4] = byte(d.TerminalType)
+	buf[5] = byte(d.TerminalType >> 8)
+	buf[6] = d.AssocTerminal
+	buf[7] = d.SourceID
+	buf[8] = d.Terminal
+	return OutputTerminalDescriptorSize
+}
+
+// FeatureUnitDescriptor describes a feature unit with one-byte control bitmaps.
+type FeatureUnitDescriptor struct {
+	UnitID      uint8                  // Unique unit ID
+	SourceID    uint8                  // ID of the unit or terminal connected to this unit
+	Controls    [MaxChannels + 1]uint8 // Control bitmaps: master channel, then logical channels
+	NumChannels int                    // Number of logical channels
+	Name        uint8                  // String index of the unit name
+}
+
+// Size returns the size of the feature unit descriptor in bytes.
+func (d *FeatureUnitDescriptor) Size() int {
+	return 7 + d.NumChannels + 1
+}
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *FeatureUnitDescriptor) MarshalTo(buf []byte) int {
+	size := d.Size()
+	if len(buf) < size || d.NumChannels > MaxChannels {
+		return 0
+	}
+	buf[0] = byte(size)
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeFeatureUnit
+	buf[3] = d.UnitID
+	buf[4] = d.SourceID
+	buf[5] = 1 // bControlSize
+	copy(buf[6:size-1], d.Controls[:d.NumChannels+1])
+	buf[size-1] = d.Name
+	return size
+}
+
+// ASGeneralDescriptor is the class-specific AudioStreaming general descriptor.
+type ASGeneralDescriptor struct {
+	TerminalLink uint8  // ID of the terminal connected to the endpoint
+	Delay        uint8  // Delay introduced by the data path, in frames
+	FormatTag    uint16 // Audio data format (FormatPCM, ...)
+}
+
+// ASGeneralDescriptorSize is the size of the AS General Descriptor.
+const ASGeneralDescriptorSize = 7
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *ASGeneralDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < ASGeneralDescriptorSize {
+		return 0
+	}
+	buf[0] = ASGeneralDescriptorSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeASGeneral
+	buf[3] = d.TerminalLink
+	buf[4] = d.Delay
+	buf[5] = byte(d.FormatTag)
+	buf[6] = byte(d.FormatTag >> 8)
+	return ASGeneralDescriptorSize
+}
+
+// Format describes a Type I audio stream format.
+type Format struct {
+	Channels       uint8                  // Number of channels (1 to MaxChannels)
+	SubframeSize   uint8                  // Bytes per sample (1 to 4)
+	BitResolution  uint8                  // Significant bits per sample
+	SampleRates    [MaxSampleRates]uint32 // Supported sample rates in Hz
+	NumSampleRates int                    // Number of supported sample rates
+}
+
+// NewFormat returns a PCM format with the given channel count, sample size
+// in bits, and supported sample rates. The first rate is the default.
+// Rates beyond MaxSampleRates are ignored.
+func NewFormat(channels, bits uint8, rates ...uint32) Format {
+	f := Format{
+		Channels:      channels,
+		SubframeSize:  (bits + 7) / 8,
+		BitResolution: bits,
+	}
+	for _, rate := range rates {
+		if f.NumSampleRates >= MaxSampleRates {
+			break
+		}
+		f.SampleRates[f.NumSampleRates] = rate
+		f.NumSampleRates++
+	}
+	return f
+}
+
+// FrameSize returns the size of one sample for all channels, in bytes.
+func (f *Format) FrameSize() int {
+	return int(f.Channels) * int(f.SubframeSize)
+}
+
+// Supports returns true if rate is one of the format's sample rates.
+func (f *Format) Supports(rate uint32) bool {
+	for i := 0; i < f.NumSampleRates; i++ {
+		if f.SampleRates[i] == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxPacketSize returns the largest packet needed to carry one frame (1 ms)
+// of audio at the format's highest sample rate.
+func (f *Format) MaxPacketSize() int {
+	var maxRate uint32
+	for i := 0; i < f.NumSampleRates; i++ {
+		if f.SampleRates[i] > maxRate {
+			maxRate = f.SampleRates[i]
+		}
+	}
+	samples := (int(maxRate) + 999) / 1000
+	return samples * f.FrameSize()
+}
+
+// formatTypeISize returns the size of the Type I format descriptor in bytes.
+func (f *Format) formatTypeISize() int {
+	return 8 + 3*f.NumSampleRates
+}
+
+// MarshalTo writes the Type I format descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (f *Format) MarshalTo(buf []byte) int {
+	size := f.formatTypeISize()
+	if len(buf) < size || f.NumSampleRates > MaxSampleRates {
+		return 0
+	}
+	buf[0] = byte(size)
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeFormatType
+	buf[3] = FormatTypeI
+	buf[4] = f.Channels
+	buf[5] = f.SubframeSize
+	buf[6] = f.BitResolution
+	buf[7] = uint8(f.NumSampleRates)
+	for i := 0; i < f.NumSampleRates; i++ {
+		putSampleFreq(buf[8+3*i:], f.SampleRates[i])
+	}
+	return size
+}
+
+// EndpointGeneralDescriptor is the class-specific isochronous audio data
+// endpoint descriptor.
+type EndpointGeneralDescriptor struct {
+	Attributes     uint8  // EndpointSamplingFreq, EndpointPitch, EndpointMaxPacketsOnly
+	LockDelayUnits uint8  // Units of LockDelay: 0=undefined, 1=ms, 2=samples
+	LockDelay      uint16 // Time to lock the internal clock recovery circuitry
+}
+
+// EndpointGeneralDescriptorSize is the size of the EP General Descriptor.
+const EndpointGeneralDescriptorSize = 7
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *EndpointGeneralDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < EndpointGeneralDescriptorSize {
+		return 0
+	}
+	buf[0] = EndpointGeneralDescriptorSize
+	buf[1] = DescriptorTypeCSEndpoint
+	buf[2] = SubtypeEndpointGeneral
+	buf[3] = d.Attributes
+	buf[4] = d.LockDelayUnits
+	buf[5] = byte(d.LockDelay)
+	buf[6] = byte(d.LockDelay >> 8)
+	return EndpointGeneralDescriptorSize
+}
+
+// putSampleFreq writes a 3-byte sample frequency to buf.
+func putSampleFreq(buf []byte, rate uint32) {
+	buf[0] = byte(rate)
+	buf[1] = byte(rate >> 8)
+	buf[2] = byte(rate >> 16)
+}
+
+// sampleFreq reads a 3-byte sample frequency from data.
+func sampleFreq(data []byte) uint32 {
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+}
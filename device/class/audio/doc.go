@@ -0,0 +1,80 @@
+// Package audio implements the USB Audio Class 1.0 (UAC1) for the softusb
+// device stack.
+//
+// This package provides a speaker (playback) stream, a microphone (capture)
+// stream, or both, using Type I PCM formats and isochronous endpoints.
+//
+// # Architecture
+//
+// An audio device consists of an AudioControl interface followed by one
+// AudioStreaming interface per stream:
+//
+//   - AudioControl Interface: Class-specific header, input and output
+//     terminals, and a feature unit per stream with master mute and volume
+//     controls
+//   - AudioStreaming Interfaces: Alternate setting 0 has no endpoints (zero
+//     bandwidth); alternate setting 1 carries the AS general and Type I format
+//     descriptors and a 9-byte isochronous audio data endpoint
+//
+// The terminal topology is fixed:
+//
+//	Speaker:    USB streaming (1) -> feature unit (2) -> speaker (3)
+//	Microphone: microphone (4) -> feature unit (5) -> USB streaming (6)
+//
+// The host starts a stream by selecting alternate setting 1 with
+// SET_INTERFACE and stops it by selecting alternate setting 0.
+//
+// Feature unit requests (SET_CUR, GET_CUR, GET_MIN, GET_MAX, GET_RES for
+// mute and volume) are addressed to the AudioControl interface with the unit
+// ID in the high byte of wIndex. Sampling frequency requests (SET_CUR,
+// GET_CUR) are addressed to the data endpoints. The stack routes both, with
+// their data stages, to [Audio.HandleSetup].
+//
+// # Isochronous Streaming
+//
+// [Audio.Read] and [Audio.Write] move audio as a single isochronous transfer
+// of up to [MaxPacketsPerTransfer] packets, one packet per frame (1 ms).
+// Microphone packets are sized from the current sample rate and the
+// endpoint frame number (see [SamplesPerFrame]), so fractional rates such as
+// 44.1 kHz are delivered at the correct average rate.
+//
+// # Zero-Allocation Design
+//
+// This implementation follows zero-allocation patterns:
+//
+//   - Fixed-size buffers for descriptors and control responses
+//   - One reusable isochronous transfer per stream
+//   - Caller-provided buffers for audio data
+//
+// # Usage
+//
+// To create a USB speaker with a microphone:
+//
+//	speaker := audio.NewFormat(2, 16, 48000, 44100)
+//	microphone := audio.NewFormat(1, 16, 48000)
+//	a := audio.New(&speaker, &microphone)
+//
+//	a.SetOnVolumeChange(func(s audio.Stream, volume int16) {
+//	    // volume is in 1/256 dB steps
+//	})
+//
+//	builder := device.NewDeviceBuilder().
+//	    WithVendorProduct(0xCAFE, 0xBABE).
+//	    WithStrings("Manufacturer", "USB Audio", "12345").
+//	    AddConfiguration(1)
+//
+//	// AudioControl interface 0, speaker OUT EP 0x01, microphone IN EP 0x82
+//	a.ConfigureDevice(builder, 0, 0x01, 0x82)
+//
+//	dev, _ := builder.Build(ctx)
+//	a.AttachToInterfaces(dev, 1, 0)
+//
+//	// Create the stack
+//	stack := device.NewStack(dev, hal)
+//	a.SetStack(stack)
+//	stack.Start(ctx)
+//
+//	// Once the host starts the streams
+//	n, _ := a.Read(ctx, playback)
+//	a.Write(ctx, capture)
+package audio
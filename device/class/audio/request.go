@@ -0,0 +1,199 @@
+package audio
+
+import (
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// isAudioRequest returns true for class requests directed at the
+// AudioControl interface or at a streaming endpoint.
+func (a *Audio) isAudioRequest(setup *device.SetupPacket) bool {
+	if !setup.IsClass() {
+		return false
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if !a.configured {
+		return false
+	}
+
+	switch {
+	case setup.IsInterfaceRecipient():
+		return setup.InterfaceNumber() == a.controlIfaceNum
+	case setup.IsEndpointRecipient():
+		_, st := a.streamForEndpointLocked(setup.EndpointAddress())
+		return st != nil
+	default:
+		return false
+	}
+}
+
+// handleRequest processes an audio class request. data holds the OUT data
+// stage. For IN requests, the response is written to responseBuf and its
+// length returned.
+func (a *Audio) handleRequest(setup *device.SetupPacket, data []byte) (int, error) {
+	if setup.IsEndpointRecipient() {
+		return a.handleEndpointRequest(setup, data)
+	}
+	return a.handleFeatureRequest(setup, data)
+}
+
+// handleFeatureRequest handles a feature unit control request.
+// Only master channel (channel 0) mute and volume controls are supported.
+func (a *Audio) handleFeatureRequest(setup *device.SetupPacket, data []byte) (int, error) {
+	entity := uint8(setup.Index >> 8)
+	selector := uint8(setup.Value >> 8)
+	channel := uint8(setup.Value)
+
+	var s Stream
+	switch entity {
+	case IDSpeakerFeature:
+		s = Speaker
+	case IDMicrophoneFeature:
+		s = Microphone
+	default:
+		return 0, pkg.ErrInvalidRequest
+	}
+
+	a.mutex.Lock()
+	if !a.validStream(s) || channel != 0 {
+		a.mutex.Unlock()
+		return 0, pkg.ErrInvalidRequest
+	}
+	st := &a.streams[s]
+
+	switch selector {
+	case ControlMute:
+		switch setup.Request {
+		case RequestSetCur:
+			if len(data) < MuteSize {
+				a.mutex.Unlock()
+				return 0, pkg.ErrBufferTooSmall
+			}
+			mute := data[0] != 0
+			changed := st.mute != mute
+			st.mute = mute
+			cb := a.onMuteChange
+			a.mutex.Unlock()
+
+			pkg.LogDebug(pkg.ComponentDevice, "audio mute set",
+				"stream", s,
+				"mute", mute)
+
+			if changed && cb != nil {
+				cb(s, mute)
+			}
+			return 0, nil
+
+		case RequestGetCur:
+			a.responseBuf[0] = 0
+			if st.mute {
+				a.responseBuf[0] = 1
+			}
+			a.mutex.Unlock()
+			return MuteSize, nil
+		}
+
+	case ControlVolume:
+		var v int16
+		switch setup.Request {
+		case RequestSetCur:
+			if len(data) < VolumeSize {
+				a.mutex.Unlock()
+				return 0, pkg.ErrBufferTooSmall
+			}
+			volume := int16(uint16(data[0]) | uint16(data[1])<<8)
+			if volume != VolumeSilence {
+				if volume < a.volumeMin {
+					volume = a.volumeMin
+				}
+				if volume > a.volumeMax {
+					volume = a.volumeMax
+				}
+			}
+			changed := st.volume != volume
+			st.volume = volume
+			cb := a.onVolumeChange
+			a.mutex.Unlock()
+
+			pkg.LogDebug(pkg.ComponentDevice, "audio volume set",
+				"stream", s,
+				"volume", volume)
+
+			if changed && cb != nil {
+				cb(s, volume)
+			}
+			return 0, nil
+
+		case RequestGetCur:
+			v = st.volume
+		case RequestGetMin:
+			v = a.volumeMin
+		case RequestGetMax:
+			v = a.volumeMax
+		case RequestGetRes:
+			v = a.volumeRes
+		default:
+			a.mutex.Unlock()
+			return 0, pkg.ErrInvalidRequest
+		}
+		a.responseBuf[0] = byte(v)
+		a.responseBuf[1] = byte(uint16(v) >> 8)
+		a.mutex.Unlock()
+		return VolumeSize, nil
+	}
+
+	a.mutex.Unlock()
+	return 0, pkg.ErrInvalidRequest
+}
+
+// handleEndpointRequest handles a sampling frequency control request on a
+// streaming endpoint.
+func (a *Audio) handleEndpointRequest(setup *device.SetupPacket, data []byte) (int, error) {
+	if uint8(setup.Value>>8) != ControlSamplingFreq {
+		return 0, pkg.ErrInvalidRequest
+	}
+
+	a.mutex.Lock()
+	s, st := a.streamForEndpointLocked(setup.EndpointAddress())
+	if st == nil {
+		a.mutex.Unlock()
+		return 0, pkg.ErrInvalidRequest
+	}
+
+	switch setup.Request {
+	case RequestSetCur:
+		if len(data) < SampleFreqSize {
+			a.mutex.Unlock()
+			return 0, pkg.ErrBufferTooSmall
+		}
+		rate := sampleFreq(data)
+		if !st.format.Supports(rate) {
+			a.mutex.Unlock()
+			return 0, pkg.ErrInvalidRequest
+		}
+		changed := st.sampleRate != rate
+		st.sampleRate = rate
+		cb := a.onSampleRateChange
+		a.mutex.Unlock()
+
+		pkg.LogDebug(pkg.ComponentDevice, "audio sample rate set",
+			"stream", s,
+			"rate", rate)
+
+		if changed && cb != nil {
+			cb(s, rate)
+		}
+		return 0, nil
+
+	case RequestGetCur:
+		putSampleFreq(a.responseBuf[:], st.sampleRate)
+		a.mutex.Unlock()
+		return SampleFreqSize, nil
+	}
+
+	a.mutex.Unlock()
+	return 0, pkg.ErrInvalidRequest
+}
@@ -146,9 +146,9 @@ func (a *ACM) Init(iface *device.Interface) error {
 }
 
 // HandleSetup processes class-specific SETUP requests.
-func (a *ACM) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) (bool, error) {
+func (a *ACM) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
 	if !setup.IsClass() {
-		return false, nil
+		return nil, false, nil
 	}
 
 	switch setup.Request {
@@ -165,20 +165,21 @@ func (a *ACM) HandleSetup(iface *device.Interface, setup *device.SetupPacket, da
 		return a.handleSendBreak(setup)
 
 	default:
-		return false, nil
+		return nil, false, nil
 	}
 }
 
-// handleSetLineCoding handles the SET_LINE_CODING request.
-func (a *ACM) handleSetLineCoding(setup *device.SetupPacket, data []byte) (bool, error) {
+// handleSetLineCoding handles the SET_LINE_CODING request with the line
+// coding in the data stage.
+func (a *ACM) handleSetLineCoding(setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
 	if len(data) < LineCodingSize {
-		return true, pkg.ErrBufferTooSmall
+		return nil, true, pkg.ErrBufferTooSmall
 	}
 
 	a.mutex.Lock()
 	if !ParseLineCoding(data, &a.lineCoding) {
 		a.mutex.Unlock()
-		return true, pkg.ErrBufferTooSmall
+		return nil, true, pkg.ErrBufferTooSmall
 	}
 	cb := a.onLineCodingChange
 	lc := a.lineCoding
@@ -194,26 +195,23 @@ func (a *ACM) handleSetLineCoding(setup *device.SetupPacket, data []byte) (bool,
 		cb(&lc)
 	}
 
-	return true, nil
+	return nil, true, nil
 }
 
 // handleGetLineCoding handles the GET_LINE_CODING request.
-func (a *ACM) handleGetLineCoding(setup *device.SetupPacket) (bool, error) {
+func (a *ACM) handleGetLineCoding(setup *device.SetupPacket) ([]byte, bool, error) {
 	a.mutex.RLock()
 	n := a.lineCoding.MarshalTo(a.responseBuf[:])
 	a.mutex.RUnlock()
 
 	if n == 0 {
-		return true, pkg.ErrBufferTooSmall
+		return nil, true, pkg.ErrBufferTooSmall
 	}
-
-	// The standard request handler will send this data
-	// For now, we just indicate we handled it
-	return true, nil
+	return a.responseBuf[:n], true, nil
 }
 
 // handleSetControlLineState handles the SET_CONTROL_LINE_STATE request.
-func (a *ACM) handleSetControlLineState(setup *device.SetupPacket) (bool, error) {
+func (a *ACM) handleSetControlLineState(setup *device.SetupPacket) ([]byte, bool, error) {
 	a.mutex.Lock()
 	a.controlState = setup.Value
 	cb := a.onControlStateChange
@@ -229,11 +227,11 @@ func (a *ACM) handleSetControlLineState(setup *device.SetupPacket) (bool, error)
 		cb(dtr, rts)
 	}
 
-	return true, nil
+	return nil, true, nil
 }
 
 // handleSendBreak handles the SEND_BREAK request.
-func (a *ACM) handleSendBreak(setup *device.SetupPacket) (bool, error) {
+func (a *ACM) handleSendBreak(setup *device.SetupPacket) ([]byte, bool, error) {
 	millis := setup.Value
 
 	a.mutex.RLock()
@@ -247,7 +245,7 @@ func (a *ACM) handleSendBreak(setup *device.SetupPacket) (bool, error) {
 		cb(millis)
 	}
 
-	return true, nil
+	return nil, true, nil
 }
 
 // SetAlternate handles alternate setting changes.
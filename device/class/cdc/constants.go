@@ -25,6 +25,7 @@ const (
 	SubtypeCAPI            = 0x0E // CAPI Control Management Functional Descriptor
 	SubtypeEthernet        = 0x0F // Ethernet Networking Functional Descriptor
 	SubtypeATMNetworking   = 0x10 // ATM Networking Functional Descriptor
+	SubtypeNCM             = 0x1A // NCM Functional Descriptor
 )
 
 // CDC Class codes.
@@ -43,6 +44,7 @@ const (
 	SubclassCAPI = 0x05 // CAPI Control Model
 	SubclassECM  = 0x06 // Ethernet Networking Control Model
 	SubclassATM  = 0x07 // ATM Networking Control Model
+	SubclassNCM  = 0x0D // Network Control Model
 )
 
 // CDC Protocol codes.
@@ -52,6 +54,12 @@ const (
 	ProtocolVendor = 0xFF // Vendor-specific
 )
 
+// CDC Data Class protocol codes.
+const (
+	ProtocolDataNone = 0x00 // No class-specific protocol
+	ProtocolDataNTB  = 0x01 // Network Transfer Block (NCM)
+)
+
 // CDC Request codes.
 const (
 	RequestSendEncapsulatedCommand = 0x00
@@ -65,13 +73,56 @@ const (
 	RequestSendBreak               = 0x23
 )
 
+// CDC Ethernet (ECM) request codes.
+const (
+	RequestSetEthernetMulticastFilters = 0x40
+	RequestSetEthernetPowerFilter      = 0x41
+	RequestGetEthernetPowerFilter      = 0x42
+	RequestSetEthernetPacketFilter     = 0x43
+	RequestGetEthernetStatistic        = 0x44
+)
+
+// CDC NCM request codes.
+const (
+	RequestGetNTBParameters   = 0x80
+	RequestGetNetAddress      = 0x81
+	RequestSetNetAddress      = 0x82
+	RequestGetNTBFormat       = 0x83
+	RequestSetNTBFormat       = 0x84
+	RequestGetNTBInputSize    = 0x85
+	RequestSetNTBInputSize    = 0x86
+	RequestGetMaxDatagramSize = 0x87
+	RequestSetMaxDatagramSize = 0x88
+	RequestGetCRCMode         = 0x89
+	RequestSetCRCMode         = 0x8A
+)
+
 // CDC Notification codes.
 const (
 	NotificationNetworkConnection = 0x00
 	NotificationResponseAvailable = 0x01
 	NotificationSerialState       = 0x20
+	NotificationSpeedChange       = 0x2A
 )
 
+// Notification sizes.
+const (
+	NotificationHeaderSize      = 8                          // Notification header without data
+	NotificationSpeedChangeSize = NotificationHeaderSize + 8 // CONNECTION_SPEED_CHANGE with bit rates
+)
+
+// Ethernet packet filter bits (for SET_ETHERNET_PACKET_FILTER).
+const (
+	PacketFilterPromiscuous  = 1 << 0 // All packets
+	PacketFilterAllMulticast = 1 << 1 // All multicast packets
+	PacketFilterDirected     = 1 << 2 // Packets addressed to this device
+	PacketFilterBroadcast    = 1 << 3 // Broadcast packets
+	PacketFilterMulticast    = 1 << 4 // Multicast packets matching the filters
+)
+
+// DefaultPacketFilter is the packet filter before the host sets one.
+const DefaultPacketFilter = PacketFilterDirected | PacketFilterBroadcast | PacketFilterAllMulticast
+
 // LineCoding represents the serial line configuration.
 type LineCoding struct {
 	DTERate    uint32 // Data terminal rate (baud rate)
@@ -263,3 +314,227 @@ func (d *UnionDescriptor) MarshalTo(buf []byte) int {
 	buf[4] = d.SlaveInterface0
 	return UnionDescriptorSize
 }
+
+// EthernetDescriptor is the Ethernet Networking Functional Descriptor.
+type EthernetDescriptor struct {
+	Length             uint8  // Size of this descriptor (13)
+	DescriptorType     uint8  // CS_INTERFACE (0x24)
+	SubType            uint8  // Ethernet Networking (0x0F)
+	MACAddress         uint8  // String index of the MAC address
+	EthernetStatistics uint32 // Supported statistics bitmap
+	MaxSegmentSize     uint16 // Maximum segment size (typically 1514)
+	NumberMCFilters    uint16 // Number of multicast filters
+	NumberPowerFilters uint8  // Number of wake-up pattern filters
+}
+
+// EthernetDescriptorSize is the size of the Ethernet Networking Descriptor.
+const EthernetDescriptorSize = 13
+
+// MarshalTo writes the descriptor to buf.
+func (d *EthernetDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < EthernetDescriptorSize {
+		return 0
+	}
+	buf[0] = EthernetDescriptorSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeEthernet
+	buf[3] = d.MACAddress
+	buf[4] = byte(d.EthernetStatistics)
+	buf[5] = byte(d.EthernetStatistics >> 8)
+	buf[6] = byte(d.EthernetStatistics >> 16)
+	buf[7] = byte(d.EthernetStatistics >> 24)
+	buf[8] = byte(d.MaxSegmentSize)
+	buf[9] = byte(d.MaxSegmentSize >> 8)
+	buf[10] = byte(d.NumberMCFilters)
+	buf[11] = byte(d.NumberMCFilters >> 8)
+	buf[12] = d.NumberPowerFilters
+	return EthernetDescriptorSize
+}
+
+// NCMDescriptor is the NCM Functional Descriptor.
+type NCMDescriptor struct {
+	Length              uint8  // Size of this descriptor (6)
+	DescriptorType      uint8  // CS_INTERFACE (0x24)
+	SubType             uint8  // NCM (0x1A)
+	NCMVersion          uint16 // NCM specification release number (0x0100 for 1.0)
+	NetworkCapabilities uint8  // Supported optional requests
+}
+
+// NCMDescriptorSize is the size of the NCM Functional Descriptor.
+const NCMDescriptorSize = 6
+
+// NCM network capability bits.
+const (
+	NCMCapPacketFilter   = 1 << 0 // Supports SET_ETHERNET_PACKET_FILTER
+	NCMCapNetAddress     = 1 << 1 // Supports GET/SET_NET_ADDRESS
+	NCMCapEncapsulated   = 1 << 2 // Supports encapsulated commands
+	NCMCapMaxDatagram    = 1 << 3 // Supports GET/SET_MAX_DATAGRAM_SIZE
+	NCMCapCRCMode        = 1 << 4 // Supports GET/SET_CRC_MODE
+	NCMCapNTBInputSize8B = 1 << 5 // Supports 8-byte GET/SET_NTB_INPUT_SIZE
+)
+
+// MarshalTo writes the descriptor to buf.
+func (d *NCMDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < NCMDescriptorSize {
+		return 0
+	}
+	buf[0] = NCMDescriptorSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeNCM
+	buf[3] = byte(d.NCMVersion)
+	buf[4] = byte(d.NCMVersion >> 8)
+	buf[5] = d.NetworkCapabilities
+	return NCMDescriptorSize
+}
+
+// NTBParameters is the response to GET_NTB_PARAMETERS.
+type NTBParameters struct {
+	NTBFormatsSupported    uint16 // Bit 0: NTB16, bit 1: NTB32
+	NTBInMaxSize           uint32 // Maximum IN NTB size in bytes
+	NDPInDivisor           uint16 // IN datagram alignment divisor
+	NDPInPayloadRemainder  uint16 // IN datagram alignment remainder
+	NDPInAlignment         uint16 // IN NDP alignment
+	NTBOutMaxSize          uint32 // Maximum OUT NTB size in bytes
+	NDPOutDivisor          uint16 // OUT datagram alignment divisor
+	NDPOutPayloadRemainder uint16 // OUT datagram alignment remainder
+	NDPOutAlignment        uint16 // OUT NDP alignment
+	NTBOutMaxDatagrams     uint16 // Maximum datagrams per OUT NTB (0 = no limit)
+}
+
+// NTBParametersSize is the size of the NTB parameter structure.
+const NTBParametersSize = 28
+
+// NTB format bits.
+const (
+	NTBFormat16 = 1 << 0 // 16-bit NTB
+	NTBFormat32 = 1 << 1 // 32-bit NTB
+)
+
+// MarshalTo writes the parameters to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (p *NTBParameters) MarshalTo(buf []byte) int {
+	if len(buf) < NTBParametersSize {
+		return 0
+	}
+	putUint16(buf[0:], NTBParametersSize)
+	putUint16(buf[2:], p.NTBFormatsSupported)
+	putUint32(buf[4:], p.NTBInMaxSize)
+	putUint16(buf[8:], p.NDPInDivisor)
+	putUint16(buf[10:], p.NDPInPayloadRemainder)
+	putUint16(buf[12:], p.NDPInAlignment)
+	putUint16(buf[14:], 0) // Reserved
+	putUint32(buf[16:], p.NTBOutMaxSize)
+	putUint16(buf[20:], p.NDPOutDivisor)
+	putUint16(buf[22:], p.NDPOutPayloadRemainder)
+	putUint16(buf[24:], p.NDPOutAlignment)
+	putUint16(buf[26:], p.NTBOutMaxDatagrams)
+	return NTBParametersSize
+}
+
+// putUint16 writes v to buf in little-endian order.
+func putUint16(buf []byte, v uint16) {
+	buf[0] = byte(v)
+	buf[1] = byte(v >> 8)
+}
+
+// putUint32 writes v to buf in little-endian order.
+func putUint32(buf []byte, v uint32) {
+	buf[0] = byte(v)
+	buf[1] = byte(v >> 8)
+	buf[2] = byte(v >> 16)
+	buf[3] = byte(v >> 24)
+}
+
+// getUint16 reads a little-endian uint16 from buf.
+func getUint16(buf []byte) uint16 {
+	return uint16(buf[0]) | uint16(buf[1])<<8
+}
+
+// getUint32 reads a little-endian uint32 from buf.
+func getUint32(buf []byte) uint32 {
+	return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
+}
+
+// RNDIS class codes. The function is announced with the wireless controller
+// class, which Windows binds to its built-in RNDIS driver.
+const (
+	ClassWirelessController = 0xE0 // Wireless Controller
+	SubclassRF              = 0x01 // Radio Frequency
+	ProtocolRNDIS           = 0x03 // Remote NDIS
+)
+
+// RNDIS message types.
+const (
+	RNDISPacketMsg            = 0x00000001
+	RNDISInitializeMsg        = 0x00000002
+	RNDISHaltMsg              = 0x00000003
+	RNDISQueryMsg             = 0x00000004
+	RNDISSetMsg               = 0x00000005
+	RNDISResetMsg             = 0x00000006
+	RNDISIndicateStatusMsg    = 0x00000007
+	RNDISKeepaliveMsg         = 0x00000008
+	RNDISInitializeCmplt      = 0x80000002
+	RNDISQueryCmplt           = 0x80000004
+	RNDISSetCmplt             = 0x80000005
+	RNDISResetCmplt           = 0x80000006
+	RNDISKeepaliveCmplt       = 0x80000008
+	RNDISResponseAvailable    = 0x00000001 // RESPONSE_AVAILABLE notification
+	RNDISMajorVersion         = 1
+	RNDISMinorVersion         = 0
+	RNDISDeviceConnectionless = 0x00000001
+	RNDISMedium8023           = 0x00000000
+)
+
+// RNDIS status codes.
+const (
+	RNDISStatusSuccess         = 0x00000000
+	RNDISStatusFailure         = 0xC0000001
+	RNDISStatusInvalidData     = 0xC0010015
+	RNDISStatusNotSupported    = 0xC00000BB
+	RNDISStatusMediaConnect    = 0x4001000B
+	RNDISStatusMediaDisconnect = 0x4001000C
+)
+
+// RNDIS object identifiers (OIDs) supported by the driver.
+const (
+	OIDGenSupportedList        = 0x00010101
+	OIDGenHardwareStatus       = 0x00010102
+	OIDGenMediaSupported       = 0x00010103
+	OIDGenMediaInUse           = 0x00010104
+	OIDGenMaximumFrameSize     = 0x00010106
+	OIDGenLinkSpeed            = 0x00010107
+	OIDGenTransmitBlockSize    = 0x0001010A
+	OIDGenReceiveBlockSize     = 0x0001010B
+	OIDGenVendorID             = 0x0001010C
+	OIDGenVendorDescription    = 0x0001010D
+	OIDGenCurrentPacketFilter  = 0x0001010E
+	OIDGenMaximumTotalSize     = 0x00010111
+	OIDGenMediaConnectStatus   = 0x00010114
+	OIDGenPhysicalMedium       = 0x00010202
+	OIDGenRNDISConfigParameter = 0x0001021B
+	OIDGenXmitOK               = 0x00020101
+	OIDGenRcvOK                = 0x00020102
+	OIDGenXmitError            = 0x00020103
+	OIDGenRcvError             = 0x00020104
+	OIDGenRcvNoBuffer          = 0x00020105
+	OID8023PermanentAddress    = 0x01010101
+	OID8023CurrentAddress      = 0x01010102
+	OID8023MulticastList       = 0x01010103
+	OID8023MaximumListSize     = 0x01010104
+	OID8023RcvErrorAlignment   = 0x01020101
+	OID8023XmitOneCollision    = 0x01020102
+	OID8023XmitMoreCollisions  = 0x01020103
+)
+
+// RNDIS message sizes.
+const (
+	RNDISMessageHeaderSize    = 8  // MessageType and MessageLength
+	RNDISPacketHeaderSize     = 44 // REMOTE_NDIS_PACKET_MSG header
+	RNDISInitializeCmpltSize  = 52
+	RNDISQueryCmpltHeaderSize = 24
+	RNDISSetCmpltSize         = 16
+	RNDISResetCmpltSize       = 16
+	RNDISKeepaliveCmpltSize   = 16
+	RNDISIndicateStatusSize   = 20
+	RNDISNotificationSize     = 8
+)
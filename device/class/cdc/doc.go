@@ -5,6 +5,10 @@
 // implementing USB serial devices. CDC-ACM is the standard class for USB
 // to serial adapters and virtual COM ports.
 //
+// It also provides CDC-ECM (Ethernet Control Model), CDC-NCM (Network
+// Control Model), and RNDIS (Remote NDIS) functionality for implementing USB
+// Ethernet adapters.
+//
 // # Architecture
 //
 // A CDC-ACM device consists of two interfaces:
@@ -14,6 +18,60 @@
 //   - Data Interface (Data Class): Handles bulk data transfer via IN and OUT
 //     endpoints
 //
+// # Ethernet Networking
+//
+// [ECM] and [NCM] devices consist of an interface association followed by
+// two interfaces:
+//
+//   - Control Interface (Communications Class): Header, Union, and Ethernet
+//     Networking functional descriptors (plus the NCM functional descriptor
+//     for NCM) and an interrupt IN endpoint for notifications
+//   - Data Interface (Data Class): Alternate setting 0 has no endpoints;
+//     alternate setting 1 has the bulk IN and OUT endpoints
+//
+// The host enables the network function by selecting alternate setting 1 of
+// the data interface. Both models exchange Ethernet frames (without FCS)
+// through ReadFrame and WriteFrame, and report link state with
+// SetConnected (NETWORK_CONNECTION) and SetSpeed (CONNECTION_SPEED_CHANGE).
+// The MAC address given to NewECM or NewNCM is reported to the host in
+// string descriptor [MACStringIndex].
+//
+// ECM carries one frame per bulk transfer. NCM carries frames in 16-bit
+// Network Transfer Blocks (NTB16) and answers GET_NTB_PARAMETERS and the
+// NTB format and input size requests.
+//
+// To create a CDC-NCM device:
+//
+//	ncm := cdc.NewNCM([6]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01})
+//
+//	// Communications interface 0, notify EP 0x81, data EP 0x82/0x02
+//	ncm.ConfigureDevice(builder, 0, 0x81, 0x82, 0x02)
+//
+//	dev, _ := builder.Build(ctx)
+//	ncm.AttachToInterfaces(dev, 1, 0)
+//
+//	stack := device.NewStack(dev, hal)
+//	ncm.SetStack(stack)
+//	stack.Start(ctx)
+//
+//	// Once the host enables the data interface
+//	ncm.SetConnected(ctx, true)
+//	n, _ := ncm.ReadFrame(ctx, frame)
+//
+// # RNDIS
+//
+// [RNDIS] devices use the CDC-ACM interface layout with a vendor protocol,
+// grouped by an interface association with the wireless controller class
+// so that Windows binds its built-in driver. RNDIS control messages
+// (INITIALIZE, QUERY, SET, RESET, KEEPALIVE, HALT) are carried by
+// SEND_ENCAPSULATED_COMMAND; the device notifies the host with
+// RESPONSE_AVAILABLE on the interrupt endpoint and the host collects each
+// reply with GET_ENCAPSULATED_RESPONSE.
+//
+// Each Ethernet frame on the bulk endpoints is wrapped in a
+// REMOTE_NDIS_PACKET_MSG header. The data path runs once the host has
+// initialized the device and set a nonzero packet filter.
+//
 // # Zero-Allocation Design
 //
 // This implementation follows zero-allocation patterns:
@@ -67,4 +125,9 @@
 //   - Call Management Functional Descriptor
 //   - ACM Functional Descriptor
 //   - Union Functional Descriptor
+//
+// and the functional descriptors required by CDC-ECM and CDC-NCM:
+//
+//   - Ethernet Networking Functional Descriptor
+//   - NCM Functional Descriptor
 package cdc
@@ -0,0 +1,71 @@
+package cdc
+
+import (
+	"context"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// ECM implements a CDC-ECM (Ethernet Control Model) class driver.
+// It provides a USB Ethernet adapter that carries one Ethernet frame per
+// bulk transfer.
+type ECM struct {
+	ethernet
+}
+
+// NewECM creates a new CDC-ECM class driver. hostMAC is the MAC address
+// reported to the host for its network interface.
+func NewECM(hostMAC [6]byte) *ECM {
+	e := &ECM{}
+	e.init("CDC-ECM", hostMAC)
+	return e
+}
+
+// ReadFrame reads an Ethernet frame from the host (blocking).
+// Returns the frame length, or pkg.ErrInvalidState while the host has the
+// data interface disabled.
+func (e *ECM) ReadFrame(ctx context.Context, buf []byte) (int, error) {
+	stack, ep, err := e.dataEndpoint(false)
+	if err != nil {
+		return 0, err
+	}
+	return stack.Read(ctx, ep, buf)
+}
+
+// WriteFrame sends an Ethernet frame to the host (blocking).
+// Returns pkg.ErrInvalidState while the host has the data interface
+// disabled.
+func (e *ECM) WriteFrame(ctx context.Context, frame []byte) error {
+	if len(frame) > MaxSegmentSize {
+		return pkg.ErrInvalidParameter
+	}
+	stack, ep, err := e.dataEndpoint(true)
+	if err != nil {
+		return err
+	}
+	_, err = stack.Write(ctx, ep, frame)
+	return err
+}
+
+// ConfigureDevice adds an interface association and the CDC-ECM interfaces
+// to a device builder. Call this after AddConfiguration.
+//
+// controlIfaceNum is the number the builder assigns to the communications
+// interface (the number of interfaces already in the configuration); the
+// data interface follows it.
+func (e *ECM) ConfigureDevice(builder *device.DeviceBuilder, controlIfaceNum, notifyEPAddr, dataInEPAddr, dataOutEPAddr uint8) *device.DeviceBuilder {
+	return e.configureDevice(builder, SubclassECM, ProtocolDataNone, nil,
+		controlIfaceNum, notifyEPAddr, dataInEPAddr, dataOutEPAddr)
+}
+
+// AttachToInterfaces attaches this class driver to the CDC-ECM interfaces
+// and installs the MAC address string descriptor.
+// configValue is the configuration value (e.g., 1), controlIfaceNum is the
+// communications interface number given to ConfigureDevice.
+func (e *ECM) AttachToInterfaces(dev *device.Device, configValue, controlIfaceNum uint8) error {
+	return e.attachToInterfaces(e, dev, configValue, controlIfaceNum)
+}
+
+// Compile-time interface check
+var _ device.ClassDriver = (*ECM)(nil)
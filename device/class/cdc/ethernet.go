@@ -0,0 +1,433 @@
+package cdc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Ethernet function defaults.
+const (
+	// MaxSegmentSize is the maximum Ethernet frame size without the FCS.
+	MaxSegmentSize = 1514
+
+	// MACStringIndex is the string descriptor index of the MAC address.
+	MACStringIndex = 4
+
+	// NotifyMaxPacketSize is the maximum packet size of the notification
+	// endpoint of the networking models.
+	NotifyMaxPacketSize = 16
+
+	// NotifyInterval is the polling interval of the notification endpoint
+	// in frames.
+	NotifyInterval = 32
+
+	// DataMaxPacketSize is the maximum packet size of the bulk data
+	// endpoints of the networking models.
+	DataMaxPacketSize = 64
+
+	// maxFunctionalSize is the maximum size of the networking control
+	// interface functional descriptors.
+	maxFunctionalSize = HeaderDescriptorSize + UnionDescriptorSize +
+		EthernetDescriptorSize + NCMDescriptorSize
+
+	// macStringSize is the size of the MAC address string descriptor
+	// (12 hexadecimal digits).
+	macStringSize = 2 + 12*2
+)
+
+// ethernet holds the state shared by the Ethernet networking models (ECM
+// and NCM): a communications interface with a notification endpoint and a
+// data interface whose alternate setting 1 has the bulk endpoints.
+type ethernet struct {
+	name string // Model name for log messages
+
+	// Interfaces
+	controlIface    *device.Interface
+	dataIface       *device.Interface
+	controlIfaceNum uint8
+	dataIfaceNum    uint8
+
+	// Endpoints
+	notifyEP      *device.Endpoint // Interrupt IN for notifications
+	dataInEP      *device.Endpoint // Bulk IN for frames to host
+	dataOutEP     *device.Endpoint // Bulk OUT for frames from host
+	notifyEPAddr  uint8
+	dataInEPAddr  uint8
+	dataOutEPAddr uint8
+
+	// Stack reference for data transfer
+	stack *device.Stack
+
+	// Network state
+	hostMAC      [6]byte
+	packetFilter uint16
+	active       bool // Data interface in alternate setting 1
+	connected    bool
+	downlink     uint32
+	uplink       uint32
+
+	// Callbacks
+	onActiveChange       func(active bool)
+	onPacketFilterChange func(filter uint16)
+
+	// Buffers (zero-allocation)
+	functionalDesc [maxFunctionalSize]byte
+	macString      [macStringSize]byte
+	notifyBuf      [NotificationSpeedChangeSize]byte
+	notifyMutex    sync.Mutex
+
+	// State
+	mutex      sync.RWMutex
+	configured bool
+}
+
+// init initializes the shared state.
+func (e *ethernet) init(name string, hostMAC [6]byte) {
+	e.name = name
+	e.hostMAC = hostMAC
+	e.packetFilter = DefaultPacketFilter
+}
+
+// SetStack sets the device stack reference for data transfer.
+func (e *ethernet) SetStack(stack *device.Stack) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.stack = stack
+}
+
+// SetOnActiveChange sets the callback invoked when the host enables or
+// disables the data interface. The callback runs on the control transfer
+// path, so notifications must be sent from another goroutine.
+func (e *ethernet) SetOnActiveChange(cb func(active bool)) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.onActiveChange = cb
+}
+
+// SetOnPacketFilterChange sets the callback for packet filter changes.
+func (e *ethernet) SetOnPacketFilterChange(cb func(filter uint16)) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.onPacketFilterChange = cb
+}
+
+// HostMAC returns the MAC address reported to the host for its network
+// interface.
+func (e *ethernet) HostMAC() [6]byte {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.hostMAC
+}
+
+// PacketFilter returns the packet filter set by the host.
+func (e *ethernet) PacketFilter() uint16 {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.packetFilter
+}
+
+// Active returns true if the host has enabled the data interface.
+func (e *ethernet) Active() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.active
+}
+
+// Connected returns the network connection state last reported to the host.
+func (e *ethernet) Connected() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.connected
+}
+
+// SetConnected sends a NETWORK_CONNECTION notification reporting the link
+// as connected or disconnected.
+func (e *ethernet) SetConnected(ctx context.Context, connected bool) error {
+	e.mutex.Lock()
+	e.connected = connected
+	e.mutex.Unlock()
+
+	var value uint16
+	if connected {
+		value = 1
+	}
+	return e.notify(ctx, NotificationNetworkConnection, value, 0, 0)
+}
+
+// SetSpeed sends a CONNECTION_SPEED_CHANGE notification with the downlink
+// (device to host) and uplink (host to device) bit rates in bits per second.
+func (e *ethernet) SetSpeed(ctx context.Context, downlink, uplink uint32) error {
+	e.mutex.Lock()
+	e.downlink = downlink
+	e.uplink = uplink
+	e.mutex.Unlock()
+
+	return e.notify(ctx, NotificationSpeedChange, 0, downlink, uplink)
+}
+
+// Speed returns the downlink and uplink bit rates last reported to the host.
+func (e *ethernet) Speed() (downlink, uplink uint32) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.downlink, e.uplink
+}
+
+// Init initializes the class driver for the given interface.
+// This is called by the device stack when the class driver is attached.
+func (e *ethernet) Init(iface *device.Interface) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	switch iface.Class {
+	case ClassCDC:
+		e.controlIface = iface
+		e.notifyEP = iface.GetEndpoint(e.notifyEPAddr)
+
+	case ClassCDCData:
+		// The bulk endpoints are in alternate setting 1
+		alt := iface.Alternate(1)
+		if alt == nil {
+			return pkg.ErrInvalidEndpoint
+		}
+		e.dataIface = iface
+		e.dataInEP = alt.GetEndpoint(e.dataInEPAddr)
+		e.dataOutEP = alt.GetEndpoint(e.dataOutEPAddr)
+	}
+
+	if e.controlIface != nil && e.dataIface != nil && e.notifyEP != nil &&
+		e.dataInEP != nil && e.dataOutEP != nil {
+		e.configured = true
+		pkg.LogDebug(pkg.ComponentDevice, e.name+" configured",
+			"notify", e.notifyEP.Address,
+			"dataIn", e.dataInEP.Address,
+			"dataOut", e.dataOutEP.Address)
+	}
+
+	return nil
+}
+
+// HandleSetup processes class-specific SETUP requests.
+func (e *ethernet) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+	if !setup.IsClass() {
+		return nil, false, nil
+	}
+
+	switch setup.Request {
+	case RequestSetEthernetPacketFilter:
+		return e.handleSetPacketFilter(setup)
+
+	default:
+		return nil, false, nil
+	}
+}
+
+// handleSetPacketFilter handles the SET_ETHERNET_PACKET_FILTER request.
+func (e *ethernet) handleSetPacketFilter(setup *device.SetupPacket) ([]byte, bool, error) {
+	e.mutex.Lock()
+	e.packetFilter = setup.Value
+	cb := e.onPacketFilterChange
+	e.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentDevice, "packet filter set",
+		"filter", setup.Value)
+
+	if cb != nil {
+		cb(setup.Value)
+	}
+
+	return nil, true, nil
+}
+
+// SetAlternate handles alternate setting changes. Alternate setting 1 of
+// the data interface enables the bulk endpoints; setting 0 disables them.
+func (e *ethernet) SetAlternate(iface *device.Interface, alt uint8) error {
+	e.mutex.Lock()
+	if iface.Class != ClassCDCData {
+		e.mutex.Unlock()
+		return nil
+	}
+	active := alt == 1
+	changed := e.active != active
+	e.active = active
+	cb := e.onActiveChange
+	e.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentDevice, e.name+" alternate setting",
+		"interface", iface.Number,
+		"alt", alt)
+
+	if changed && cb != nil {
+		cb(active)
+	}
+	return nil
+}
+
+// Close releases resources held by the class driver.
+func (e *ethernet) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.controlIface = nil
+	e.dataIface = nil
+	e.notifyEP = nil
+	e.dataInEP = nil
+	e.dataOutEP = nil
+	e.stack = nil
+	e.active = false
+	e.configured = false
+
+	return nil
+}
+
+// dataEndpoint returns the stack and a data endpoint if the data interface
+// is enabled.
+func (e *ethernet) dataEndpoint(in bool) (*device.Stack, *device.Endpoint, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	ep := e.dataOutEP
+	if in {
+		ep = e.dataInEP
+	}
+	if !e.configured || e.stack == nil || ep == nil {
+		return nil, nil, pkg.ErrNotConfigured
+	}
+	if !e.active {
+		return nil, nil, pkg.ErrInvalidState
+	}
+	return e.stack, ep, nil
+}
+
+// notify sends a notification on the interrupt endpoint. Bit rates are
+// sent only with CONNECTION_SPEED_CHANGE.
+func (e *ethernet) notify(ctx context.Context, code uint8, value uint16, downlink, uplink uint32) error {
+	e.mutex.RLock()
+	stack := e.stack
+	ep := e.notifyEP
+	iface := e.controlIfaceNum
+	e.mutex.RUnlock()
+
+	if stack == nil || ep == nil {
+		return pkg.ErrNotConfigured
+	}
+
+	e.notifyMutex.Lock()
+	defer e.notifyMutex.Unlock()
+
+	buf := e.notifyBuf[:]
+	buf[0] = 0xA1 // bmRequestType: device-to-host, class, interface
+	buf[1] = code
+	putUint16(buf[2:], value)
+	putUint16(buf[4:], uint16(iface))
+	n := NotificationHeaderSize
+	if code == NotificationSpeedChange {
+		putUint16(buf[6:], 8)
+		putUint32(buf[8:], downlink)
+		putUint32(buf[12:], uplink)
+		n = NotificationSpeedChangeSize
+	} else {
+		putUint16(buf[6:], 0)
+	}
+
+	_, err := stack.Write(ctx, ep, buf[:n])
+	return err
+}
+
+// configureDevice adds an interface association, the communications
+// interface with its functional descriptors and notification endpoint, and
+// the data interface to a device builder. extra holds model-specific
+// functional descriptors appended after the Ethernet descriptor.
+func (e *ethernet) configureDevice(builder *device.DeviceBuilder, subclass, dataProtocol uint8, extra []byte,
+	controlIfaceNum, notifyEPAddr, dataInEPAddr, dataOutEPAddr uint8) *device.DeviceBuilder {
+	e.mutex.Lock()
+	e.controlIfaceNum = controlIfaceNum
+	e.dataIfaceNum = controlIfaceNum + 1
+	e.notifyEPAddr = notifyEPAddr | device.EndpointDirectionIn
+	e.dataInEPAddr = dataInEPAddr | device.EndpointDirectionIn
+	e.dataOutEPAddr = dataOutEPAddr &^ device.EndpointDirectionIn
+
+	header := HeaderDescriptor{CDCVersion: 0x0110}
+	union := UnionDescriptor{
+		MasterInterface: e.controlIfaceNum,
+		SlaveInterface0: e.dataIfaceNum,
+	}
+	eth := EthernetDescriptor{
+		MACAddress:     MACStringIndex,
+		MaxSegmentSize: MaxSegmentSize,
+	}
+	n := header.MarshalTo(e.functionalDesc[:])
+	n += union.MarshalTo(e.functionalDesc[n:])
+	n += eth.MarshalTo(e.functionalDesc[n:])
+	n += copy(e.functionalDesc[n:], extra)
+	functional := e.functionalDesc[:n]
+	notifyAddr, inAddr, outAddr := e.notifyEPAddr, e.dataInEPAddr, e.dataOutEPAddr
+	e.mutex.Unlock()
+
+	builder.AddInterfaceAssociation(2, ClassCDC, subclass, ProtocolNone)
+
+	// Communications interface
+	builder.AddInterface(ClassCDC, subclass, ProtocolNone)
+	builder.AddClassDescriptor(functional)
+	builder.AddEndpointDescriptor(&device.EndpointDescriptor{
+		Length:          device.EndpointDescriptorSize,
+		DescriptorType:  device.DescriptorTypeEndpoint,
+		EndpointAddress: notifyAddr,
+		Attributes:      device.EndpointTypeInterrupt,
+		MaxPacketSize:   NotifyMaxPacketSize,
+		Interval:        NotifyInterval,
+	})
+
+	// Data interface: alternate setting 0 has no endpoints, so the host
+	// resets the function by selecting it
+	builder.AddInterface(ClassCDCData, SubclassNone, dataProtocol)
+	builder.AddAlternateSetting()
+	builder.AddEndpoint(inAddr, device.EndpointTypeBulk, DataMaxPacketSize)
+	builder.AddEndpoint(outAddr, device.EndpointTypeBulk, DataMaxPacketSize)
+
+	return builder
+}
+
+// attachToInterfaces attaches drv to the networking interfaces, sets the
+// device class to use interface association descriptors, and installs the
+// MAC address string descriptor.
+func (e *ethernet) attachToInterfaces(drv device.ClassDriver, dev *device.Device, configValue, controlIfaceNum uint8) error {
+	config := dev.GetConfiguration(configValue)
+	if config == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	controlIface := config.GetInterface(controlIfaceNum)
+	if controlIface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	dataIface := config.GetInterface(controlIfaceNum + 1)
+	if dataIface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	// MAC address as 12 uppercase hexadecimal digits
+	const hexDigits = "0123456789ABCDEF"
+	var mac [12]byte
+	e.mutex.RLock()
+	for i, b := range e.hostMAC {
+		mac[2*i] = hexDigits[b>>4]
+		mac[2*i+1] = hexDigits[b&0x0F]
+	}
+	e.mutex.RUnlock()
+	if dev.SetStringFrom(MACStringIndex, e.macString[:], string(mac[:])) == 0 {
+		return pkg.ErrBufferTooSmall
+	}
+
+	dev.Descriptor.DeviceClass = device.ClassMisc
+	dev.Descriptor.DeviceSubClass = 0x02 // Common Class
+	dev.Descriptor.DeviceProtocol = 0x01 // Interface Association Descriptor
+
+	if err := controlIface.SetClassDriver(drv); err != nil {
+		return err
+	}
+	return dataIface.SetClassDriver(drv)
+}
@@ -0,0 +1,226 @@
+package cdc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// NCM implements a CDC-NCM (Network Control Model) class driver.
+// It provides a USB Ethernet adapter that carries Ethernet frames in 16-bit
+// Network Transfer Blocks (NTB16). Frames from the host may be batched
+// several per NTB; frames to the host are sent one per NTB.
+type NCM struct {
+	ethernet
+
+	// NTB state
+	ntbInputSize uint32 // Maximum IN NTB size set by the host
+	sequence     uint16 // Next IN NTB sequence number
+
+	// Receive state (guarded by rxMutex)
+	rxBuf       [NTBMaxSize]byte
+	rxDatagrams [MaxDatagramsPerNTB]datagram
+	rxCount     int
+	rxNext      int
+	rxMutex     sync.Mutex
+
+	// Transmit buffer (guarded by txMutex)
+	txBuf   [NTBMaxSize]byte
+	txMutex sync.Mutex
+
+	// Control response buffer
+	responseBuf [NTBParametersSize]byte
+}
+
+// NewNCM creates a new CDC-NCM class driver. hostMAC is the MAC address
+// reported to the host for its network interface.
+func NewNCM(hostMAC [6]byte) *NCM {
+	n := &NCM{ntbInputSize: NTBMaxSize}
+	n.init("CDC-NCM", hostMAC)
+	return n
+}
+
+// NTBParameters returns the NTB parameters reported to the host.
+func (n *NCM) NTBParameters() NTBParameters {
+	return NTBParameters{
+		NTBFormatsSupported: NTBFormat16,
+		NTBInMaxSize:        NTBMaxSize,
+		NDPInDivisor:        NTBAlignment,
+		NDPInAlignment:      NTBAlignment,
+		NTBOutMaxSize:       NTBMaxSize,
+		NDPOutDivisor:       NTBAlignment,
+		NDPOutAlignment:     NTBAlignment,
+		NTBOutMaxDatagrams:  MaxDatagramsPerNTB,
+	}
+}
+
+// ReadFrame reads an Ethernet frame from the host (blocking). Frames
+// batched in one NTB are returned by successive calls.
+// Returns the frame length, pkg.ErrBufferTooSmall if buf cannot hold the
+// frame (the frame is dropped), or pkg.ErrInvalidState while the host has
+// the data interface disabled.
+func (n *NCM) ReadFrame(ctx context.Context, buf []byte) (int, error) {
+	n.rxMutex.Lock()
+	defer n.rxMutex.Unlock()
+
+	for n.rxNext >= n.rxCount {
+		stack, ep, err := n.dataEndpoint(false)
+		if err != nil {
+			return 0, err
+		}
+		length, err := stack.Read(ctx, ep, n.rxBuf[:])
+		if err != nil {
+			return 0, err
+		}
+		n.rxNext = 0
+		n.rxCount, err = parseNTB16(n.rxBuf[:length], n.rxDatagrams[:])
+		if err != nil {
+			pkg.LogDebug(pkg.ComponentDevice, "CDC-NCM malformed NTB dropped",
+				"length", length)
+		}
+	}
+
+	d := n.rxDatagrams[n.rxNext]
+	n.rxNext++
+	if int(d.length) > len(buf) {
+		return 0, pkg.ErrBufferTooSmall
+	}
+	return copy(buf, n.rxBuf[d.offset:d.offset+d.length]), nil
+}
+
+// WriteFrame sends an Ethernet frame to the host in its own NTB (blocking).
+// Returns pkg.ErrInvalidState while the host has the data interface
+// disabled.
+func (n *NCM) WriteFrame(ctx context.Context, frame []byte) error {
+	if len(frame) > MaxSegmentSize {
+		return pkg.ErrInvalidParameter
+	}
+	stack, ep, err := n.dataEndpoint(true)
+	if err != nil {
+		return err
+	}
+
+	n.txMutex.Lock()
+	defer n.txMutex.Unlock()
+
+	n.mutex.Lock()
+	size := n.ntbInputSize
+	sequence := n.sequence
+	n.sequence++
+	n.mutex.Unlock()
+
+	length := marshalNTB16(n.txBuf[:size], sequence, frame)
+	if length == 0 {
+		return pkg.ErrBufferTooSmall
+	}
+	_, err = stack.Write(ctx, ep, n.txBuf[:length])
+	return err
+}
+
+// HandleSetup processes class-specific SETUP requests.
+func (n *NCM) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+	if !setup.IsClass() {
+		return nil, false, nil
+	}
+
+	switch setup.Request {
+	case RequestSetNTBFormat:
+		// Only NTB16 is supported
+		if setup.Value != 0 {
+			return nil, true, pkg.ErrNotSupported
+		}
+		return nil, true, nil
+
+	case RequestGetNTBParameters, RequestGetNTBFormat,
+		RequestGetNTBInputSize, RequestSetNTBInputSize:
+		length, err := n.handleRequest(setup, data)
+		if err != nil {
+			return nil, true, err
+		}
+		return n.responseBuf[:length], true, nil
+	}
+	return n.ethernet.HandleSetup(iface, setup, data)
+}
+
+// SetAlternate handles alternate setting changes. Selecting alternate
+// setting 0 of the data interface also resets the NTB input size and
+// sequence number.
+func (n *NCM) SetAlternate(iface *device.Interface, alt uint8) error {
+	if iface.Class == ClassCDCData && alt == 0 {
+		n.mutex.Lock()
+		n.ntbInputSize = NTBMaxSize
+		n.sequence = 0
+		n.mutex.Unlock()
+	}
+	return n.ethernet.SetAlternate(iface, alt)
+}
+
+// ConfigureDevice adds an interface association and the CDC-NCM interfaces
+// to a device builder. Call this after AddConfiguration.
+//
+// controlIfaceNum is the number the builder assigns to the communications
+// interface (the number of interfaces already in the configuration); the
+// data interface follows it.
+func (n *NCM) ConfigureDevice(builder *device.DeviceBuilder, controlIfaceNum, notifyEPAddr, dataInEPAddr, dataOutEPAddr uint8) *device.DeviceBuilder {
+	var buf [NCMDescriptorSize]byte
+	ncm := NCMDescriptor{
+		NCMVersion:          0x0100,
+		NetworkCapabilities: NCMCapPacketFilter,
+	}
+	ncm.MarshalTo(buf[:])
+	return n.configureDevice(builder, SubclassNCM, ProtocolDataNTB, buf[:],
+		controlIfaceNum, notifyEPAddr, dataInEPAddr, dataOutEPAddr)
+}
+
+// AttachToInterfaces attaches this class driver to the CDC-NCM interfaces
+// and installs the MAC address string descriptor.
+// configValue is the configuration value (e.g., 1), controlIfaceNum is the
+// communications interface number given to ConfigureDevice.
+func (n *NCM) AttachToInterfaces(dev *device.Device, configValue, controlIfaceNum uint8) error {
+	return n.attachToInterfaces(n, dev, configValue, controlIfaceNum)
+}
+
+// handleRequest processes an NCM request with a data stage. data holds the
+// OUT data stage. For IN requests, the response is written to responseBuf
+// and its length returned.
+func (n *NCM) handleRequest(setup *device.SetupPacket, data []byte) (int, error) {
+	switch setup.Request {
+	case RequestGetNTBParameters:
+		params := n.NTBParameters()
+		return params.MarshalTo(n.responseBuf[:]), nil
+
+	case RequestGetNTBFormat:
+		putUint16(n.responseBuf[:], 0) // NTB16
+		return 2, nil
+
+	case RequestGetNTBInputSize:
+		n.mutex.RLock()
+		putUint32(n.responseBuf[:], n.ntbInputSize)
+		n.mutex.RUnlock()
+		return 4, nil
+
+	case RequestSetNTBInputSize:
+		if len(data) < 4 {
+			return 0, pkg.ErrBufferTooSmall
+		}
+		size := getUint32(data)
+		if size < NTBMinInputSize || size > NTBMaxSize {
+			return 0, pkg.ErrInvalidParameter
+		}
+		n.mutex.Lock()
+		n.ntbInputSize = size
+		n.mutex.Unlock()
+
+		pkg.LogDebug(pkg.ComponentDevice, "NTB input size set",
+			"size", size)
+		return 0, nil
+
+	default:
+		return 0, pkg.ErrInvalidRequest
+	}
+}
+
+// Compile-time interface check
+var _ device.ClassDriver = (*NCM)(nil)
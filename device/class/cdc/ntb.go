@@ -0,0 +1,126 @@
+package cdc
+
+import "github.com/ardnew/softusb/pkg"
+
+// NTB16 structure signatures and sizes (NCM 1.0 section 3).
+const (
+	NTH16Signature     = 0x484D434E // "NCMH"
+	NDP16Signature     = 0x304D434E // "NCM0", datagrams without CRC
+	NDP16CRCSignature  = 0x314D434E // "NCM1", datagrams with CRC
+	NTH16Size          = 12         // NTB header
+	NDP16HeaderSize    = 8          // Datagram pointer table header
+	NDP16EntrySize     = 4          // Datagram index and length
+	NTBAlignment       = 4          // Alignment of NDPs and datagrams
+	MaxDatagramsPerNTB = 16         // Datagrams accepted per OUT NTB
+	NTBMaxSize         = 8192       // Maximum NTB size in each direction
+	NTBMinInputSize    = 2048       // Smallest IN NTB size accepted from the host
+)
+
+// datagram locates a datagram within an NTB.
+type datagram struct {
+	offset uint16
+	length uint16
+}
+
+// marshalNTB16 writes an NTB16 carrying a single datagram to buf: the NTH16,
+// an NDP16 with one entry, and the aligned datagram.
+// Returns the NTB length, or 0 if buf is too small.
+func marshalNTB16(buf []byte, sequence uint16, frame []byte) int {
+	const ndpIndex = NTH16Size
+	const ndpLength = NDP16HeaderSize + 2*NDP16EntrySize // Entry and terminator
+	const datagramIndex = (ndpIndex + ndpLength + NTBAlignment - 1) &^ (NTBAlignment - 1)
+
+	length := datagramIndex + len(frame)
+	if len(buf) < length || length > 0xFFFF {
+		return 0
+	}
+
+	// NTH16
+	putUint32(buf[0:], NTH16Signature)
+	putUint16(buf[4:], NTH16Size)
+	putUint16(buf[6:], sequence)
+	putUint16(buf[8:], uint16(length))
+	putUint16(buf[10:], ndpIndex)
+
+	// NDP16
+	ndp := buf[ndpIndex:]
+	putUint32(ndp[0:], NDP16Signature)
+	putUint16(ndp[4:], ndpLength)
+	putUint16(ndp[6:], 0) // wNextNdpIndex
+	putUint16(ndp[8:], datagramIndex)
+	putUint16(ndp[10:], uint16(len(frame)))
+	putUint16(ndp[12:], 0) // Terminator
+	putUint16(ndp[14:], 0)
+
+	for i := ndpIndex + ndpLength; i < datagramIndex; i++ {
+		buf[i] = 0
+	}
+	copy(buf[datagramIndex:], frame)
+	return length
+}
+
+// parseNTB16 validates the NTB16 in data and records the location of each
+// datagram in out, following the chain of NDP16s.
+// Returns the number of datagrams, or pkg.ErrProtocol if the NTB is
+// malformed. Datagrams beyond len(out) are dropped.
+func parseNTB16(data []byte, out []datagram) (int, error) {
+	if len(data) < NTH16Size ||
+		getUint32(data[0:]) != NTH16Signature ||
+		getUint16(data[4:]) != NTH16Size {
+		return 0, pkg.ErrProtocol
+	}
+	blockLength := int(getUint16(data[8:]))
+	if blockLength == 0 {
+		// Zero means the NTB extends to the end of the transfer
+		blockLength = len(data)
+	}
+	if blockLength > len(data) {
+		return 0, pkg.ErrProtocol
+	}
+	data = data[:blockLength]
+
+	count := 0
+	ndpIndex := int(getUint16(data[10:]))
+	for tables := 0; ndpIndex != 0; tables++ {
+		// A chain longer than the NTB could hold must contain a loop
+		if tables >= blockLength/(NDP16HeaderSize+NDP16EntrySize) ||
+			ndpIndex%NTBAlignment != 0 ||
+			ndpIndex+NDP16HeaderSize > blockLength {
+			return 0, pkg.ErrProtocol
+		}
+		ndp := data[ndpIndex:]
+		signature := getUint32(ndp[0:])
+		if signature != NDP16Signature && signature != NDP16CRCSignature {
+			return 0, pkg.ErrProtocol
+		}
+		ndpLength := int(getUint16(ndp[4:]))
+		if ndpLength < NDP16HeaderSize+2*NDP16EntrySize || ndpIndex+ndpLength > blockLength {
+			return 0, pkg.ErrProtocol
+		}
+
+		for entry := NDP16HeaderSize; entry+NDP16EntrySize <= ndpLength; entry += NDP16EntrySize {
+			offset := getUint16(ndp[entry:])
+			length := getUint16(ndp[entry+2:])
+			if offset == 0 || length == 0 {
+				break
+			}
+			if int(offset)+int(length) > blockLength {
+				return 0, pkg.ErrProtocol
+			}
+			if signature == NDP16CRCSignature {
+				if length < 4 {
+					return 0, pkg.ErrProtocol
+				}
+				length -= 4 // Strip the CRC-32
+			}
+			if count < len(out) {
+				out[count] = datagram{offset: offset, length: length}
+				count++
+			}
+		}
+
+		ndpIndex = int(getUint16(ndp[6:]))
+	}
+
+	return count, nil
+}
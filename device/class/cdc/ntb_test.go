@@ -0,0 +1,161 @@
+package cdc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Offsets within the NTB written by marshalNTB16.
+const (
+	testNDPIndex      = NTH16Size
+	testDatagramIndex = 28
+)
+
+// testFrame returns a frame of n bytes with distinct contents.
+func testFrame(n int) []byte {
+	frame := make([]byte, n)
+	for i := range frame {
+		frame[i] = byte(i + 1)
+	}
+	return frame
+}
+
+func TestNTB16RoundTrip(t *testing.T) {
+	frame := testFrame(60)
+	buf := make([]byte, 128)
+	n := marshalNTB16(buf, 7, frame)
+	if n != testDatagramIndex+len(frame) {
+		t.Fatalf("marshalNTB16() = %d, want %d", n, testDatagramIndex+len(frame))
+	}
+	if seq := getUint16(buf[6:]); seq != 7 {
+		t.Errorf("sequence = %d, want 7", seq)
+	}
+
+	var out [MaxDatagramsPerNTB]datagram
+	count, err := parseNTB16(buf[:n], out[:])
+	if err != nil || count != 1 {
+		t.Fatalf("parseNTB16() = %d, %v, want 1, nil", count, err)
+	}
+	d := out[0]
+	if got := buf[d.offset : d.offset+d.length]; !bytes.Equal(got, frame) {
+		t.Errorf("datagram = % X, want % X", got, frame)
+	}
+
+	if n := marshalNTB16(buf[:testDatagramIndex+len(frame)-1], 0, frame); n != 0 {
+		t.Errorf("marshalNTB16(short) = %d, want 0", n)
+	}
+}
+
+func TestParseNTB16Chain(t *testing.T) {
+	// NTH16, an NDP16 with two datagrams chained to an NDP16 with CRCs
+	// holding one datagram
+	buf := make([]byte, 128)
+	putUint32(buf[0:], NTH16Signature)
+	putUint16(buf[4:], NTH16Size)
+	putUint16(buf[8:], 0) // Extends to the end of the transfer
+	putUint16(buf[10:], 12)
+
+	putUint32(buf[12:], NDP16Signature)
+	putUint16(buf[16:], NDP16HeaderSize+3*NDP16EntrySize)
+	putUint16(buf[18:], 32)
+	putUint16(buf[20:], 64)
+	putUint16(buf[22:], 10)
+	putUint16(buf[24:], 80)
+	putUint16(buf[26:], 6)
+
+	putUint32(buf[32:], NDP16CRCSignature)
+	putUint16(buf[36:], NDP16HeaderSize+2*NDP16EntrySize)
+	putUint16(buf[40:], 96)
+	putUint16(buf[42:], 14) // Including the CRC-32
+
+	var out [MaxDatagramsPerNTB]datagram
+	count, err := parseNTB16(buf, out[:])
+	if err != nil {
+		t.Fatalf("parseNTB16() error = %v", err)
+	}
+	want := []datagram{{64, 10}, {80, 6}, {96, 10}}
+	if count != len(want) {
+		t.Fatalf("parseNTB16() = %d, want %d", count, len(want))
+	}
+	for i := range want {
+		if out[i] != want[i] {
+			t.Errorf("datagram %d = %+v, want %+v", i, out[i], want[i])
+		}
+	}
+
+	// Datagrams beyond the output are dropped
+	count, err = parseNTB16(buf, out[:2])
+	if err != nil || count != 2 {
+		t.Errorf("parseNTB16(2 datagrams) = %d, %v, want 2, nil", count, err)
+	}
+}
+
+func TestParseNTB16Malformed(t *testing.T) {
+	frame := testFrame(20)
+	valid := make([]byte, testDatagramIndex+len(frame))
+	marshalNTB16(valid, 0, frame)
+
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{"empty", func(b []byte) []byte { return b[:0] }},
+		{"short header", func(b []byte) []byte { return b[:NTH16Size-1] }},
+		{"header signature", func(b []byte) []byte { b[0] = 0; return b }},
+		{"header length", func(b []byte) []byte { putUint16(b[4:], NTH16Size+4); return b }},
+		{"block length past end", func(b []byte) []byte { putUint16(b[8:], uint16(len(b)+1)); return b }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"unaligned table", func(b []byte) []byte { putUint16(b[10:], testNDPIndex+2); return b }},
+		{"table past end", func(b []byte) []byte { putUint16(b[10:], uint16(len(b)-4)); return b }},
+		{"table index past end", func(b []byte) []byte { putUint16(b[10:], 0xFFFC); return b }},
+		{"table signature", func(b []byte) []byte { b[testNDPIndex] = 0; return b }},
+		{"table length short", func(b []byte) []byte {
+			putUint16(b[testNDPIndex+4:], NDP16HeaderSize+NDP16EntrySize)
+			return b
+		}},
+		{"table length past end", func(b []byte) []byte { putUint16(b[testNDPIndex+4:], 0xFFFF); return b }},
+		{"datagram past end", func(b []byte) []byte {
+			putUint16(b[testNDPIndex+10:], uint16(len(frame)+1))
+			return b
+		}},
+		{"datagram offset past end", func(b []byte) []byte { putUint16(b[testNDPIndex+8:], 0xFFFF); return b }},
+		{"short CRC datagram", func(b []byte) []byte {
+			putUint32(b[testNDPIndex:], NDP16CRCSignature)
+			putUint16(b[testNDPIndex+10:], 3)
+			return b
+		}},
+		{"table loop", func(b []byte) []byte { putUint16(b[testNDPIndex+6:], testNDPIndex); return b }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(append([]byte{}, valid...))
+			var out [MaxDatagramsPerNTB]datagram
+			if count, err := parseNTB16(data, out[:]); err != pkg.ErrProtocol {
+				t.Errorf("parseNTB16() = %d, %v, want %v", count, err, pkg.ErrProtocol)
+			}
+		})
+	}
+}
+
+func TestParseNTB16Empty(t *testing.T) {
+	// A header without tables, and a table without datagrams
+	buf := make([]byte, 32)
+	putUint32(buf[0:], NTH16Signature)
+	putUint16(buf[4:], NTH16Size)
+	putUint16(buf[8:], uint16(len(buf)))
+
+	var out [MaxDatagramsPerNTB]datagram
+	if count, err := parseNTB16(buf, out[:]); count != 0 || err != nil {
+		t.Errorf("parseNTB16(no tables) = %d, %v, want 0, nil", count, err)
+	}
+
+	putUint16(buf[10:], 12)
+	putUint32(buf[12:], NDP16Signature)
+	putUint16(buf[16:], NDP16HeaderSize+2*NDP16EntrySize)
+	if count, err := parseNTB16(buf, out[:]); count != 0 || err != nil {
+		t.Errorf("parseNTB16(no datagrams) = %d, %v, want 0, nil", count, err)
+	}
+}
@@ -0,0 +1,831 @@
+package cdc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// RNDIS limits.
+const (
+	// RNDISMaxTransferSize is the largest data transfer in either direction:
+	// one packet message carrying a full Ethernet frame.
+	RNDISMaxTransferSize = RNDISPacketHeaderSize + MaxSegmentSize
+
+	// MaxRNDISResponseSize is the largest encapsulated response.
+	MaxRNDISResponseSize = 256
+
+	// RNDISVendorDescription is reported for OID_GEN_VENDOR_DESCRIPTION.
+	RNDISVendorDescription = "softusb RNDIS"
+
+	// rndisDefaultLinkSpeed is the link speed before SetSpeed is called,
+	// in bits per second.
+	rndisDefaultLinkSpeed = 100000000
+)
+
+// rndisSupportedOIDs lists the OIDs reported for OID_GEN_SUPPORTED_LIST.
+var rndisSupportedOIDs = [...]uint32{
+	OIDGenSupportedList,
+	OIDGenHardwareStatus,
+	OIDGenMediaSupported,
+	OIDGenMediaInUse,
+	OIDGenMaximumFrameSize,
+	OIDGenLinkSpeed,
+	OIDGenTransmitBlockSize,
+	OIDGenReceiveBlockSize,
+	OIDGenVendorID,
+	OIDGenVendorDescription,
+	OIDGenCurrentPacketFilter,
+	OIDGenMaximumTotalSize,
+	OIDGenMediaConnectStatus,
+	OIDGenPhysicalMedium,
+	OIDGenXmitOK,
+	OIDGenRcvOK,
+	OIDGenXmitError,
+	OIDGenRcvError,
+	OIDGenRcvNoBuffer,
+	OID8023PermanentAddress,
+	OID8023CurrentAddress,
+	OID8023MulticastList,
+	OID8023MaximumListSize,
+	OID8023RcvErrorAlignment,
+	OID8023XmitOneCollision,
+	OID8023XmitMoreCollisions,
+}
+
+// RNDISStatistics holds the frame counters reported through the
+// statistics OIDs.
+type RNDISStatistics struct {
+	XmitOK      uint32 // Frames sent to the host
+	RcvOK       uint32 // Frames received from the host
+	XmitError   uint32 // Frames that failed to send
+	RcvError    uint32 // Malformed packet messages received
+	RcvNoBuffer uint32 // Frames dropped because the read buffer was too small
+}
+
+// RNDIS implements a Remote NDIS class driver.
+// It provides a USB Ethernet adapter supported by the built-in Windows
+// driver, carrying one Ethernet frame per packet message on the bulk
+// endpoints.
+//
+// Control messages are carried by the data stages of
+// SEND_ENCAPSULATED_COMMAND and GET_ENCAPSULATED_RESPONSE.
+type RNDIS struct {
+	// Interfaces
+	controlIface    *device.Interface
+	dataIface       *device.Interface
+	controlIfaceNum uint8
+
+	// Endpoints
+	notifyEP      *device.Endpoint // Interrupt IN for RESPONSE_AVAILABLE
+	dataInEP      *device.Endpoint // Bulk IN for packets to host
+	dataOutEP     *device.Endpoint // Bulk OUT for packets from host
+	notifyEPAddr  uint8
+	dataInEPAddr  uint8
+	dataOutEPAddr uint8
+
+	// Stack reference for data transfer
+	stack *device.Stack
+
+	// Network state
+	hostMAC      [6]byte
+	initialized  bool // Host sent REMOTE_NDIS_INITIALIZE_MSG
+	packetFilter uint32
+	connected    bool
+	linkSpeed    uint32 // Bits per second
+	stats        RNDISStatistics
+
+	// Callbacks
+	onActiveChange       func(active bool)
+	onPacketFilterChange func(filter uint32)
+
+	// Encapsulated responses waiting for GET_ENCAPSULATED_RESPONSE: the
+	// reply to the last command, then a status indication
+	response    [MaxRNDISResponseSize]byte
+	responseLen int
+	status      [RNDISIndicateStatusSize]byte
+	statusLen   int
+
+	// RESPONSE_AVAILABLE notification, sent asynchronously
+	notifyBuf     [RNDISNotificationSize]byte
+	notifyXfer    *device.Transfer
+	notifyPending bool
+
+	// Receive state (guarded by rxMutex)
+	rxBuf    [RNDISMaxTransferSize + 1]byte // One pad byte for short packets
+	rxOffset int
+	rxLen    int
+	rxMutex  sync.Mutex
+
+	// Transmit buffer (guarded by txMutex)
+	txBuf   [RNDISMaxTransferSize]byte
+	txMutex sync.Mutex
+
+	// Buffers (zero-allocation)
+	functionalDesc [HeaderDescriptorSize + CallManagementDescriptorSize + ACMDescriptorSize + UnionDescriptorSize]byte
+	responseBuf    [MaxRNDISResponseSize]byte
+
+	// State
+	mutex      sync.RWMutex
+	configured bool
+}
+
+// NewRNDIS creates a new RNDIS class driver. hostMAC is the MAC address
+// reported to the host for its network interface.
+func NewRNDIS(hostMAC [6]byte) *RNDIS {
+	return &RNDIS{
+		hostMAC:   hostMAC,
+		linkSpeed: rndisDefaultLinkSpeed,
+	}
+}
+
+// SetStack sets the device stack reference for data transfer.
+func (r *RNDIS) SetStack(stack *device.Stack) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stack = stack
+}
+
+// SetOnActiveChange sets the callback invoked when the host starts or stops
+// the data path (by initializing the device and setting a nonzero packet
+// filter, or by halting or clearing the filter).
+func (r *RNDIS) SetOnActiveChange(cb func(active bool)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onActiveChange = cb
+}
+
+// SetOnPacketFilterChange sets the callback for packet filter changes.
+func (r *RNDIS) SetOnPacketFilterChange(cb func(filter uint32)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onPacketFilterChange = cb
+}
+
+// HostMAC returns the MAC address reported to the host for its network
+// interface.
+func (r *RNDIS) HostMAC() [6]byte {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.hostMAC
+}
+
+// PacketFilter returns the packet filter set by the host.
+func (r *RNDIS) PacketFilter() uint32 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.packetFilter
+}
+
+// Active returns true if the host has initialized the device and set a
+// nonzero packet filter.
+func (r *RNDIS) Active() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.activeLocked()
+}
+
+// Connected returns the media connect state reported to the host.
+func (r *RNDIS) Connected() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.connected
+}
+
+// Statistics returns the frame counters.
+func (r *RNDIS) Statistics() RNDISStatistics {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.stats
+}
+
+// SetConnected sets the media connect state. If the host has initialized
+// the device, a media connect or disconnect status indication is queued and
+// the host is notified.
+func (r *RNDIS) SetConnected(ctx context.Context, connected bool) error {
+	r.mutex.Lock()
+	r.connected = connected
+	if !r.initialized {
+		r.mutex.Unlock()
+		return nil
+	}
+	status := uint32(RNDISStatusMediaDisconnect)
+	if connected {
+		status = RNDISStatusMediaConnect
+	}
+	putUint32(r.status[0:], RNDISIndicateStatusMsg)
+	putUint32(r.status[4:], RNDISIndicateStatusSize)
+	putUint32(r.status[8:], status)
+	putUint32(r.status[12:], 0) // StatusBufferLength
+	putUint32(r.status[16:], 0) // StatusBufferOffset
+	r.statusLen = RNDISIndicateStatusSize
+	r.mutex.Unlock()
+
+	return r.notify(ctx)
+}
+
+// SetSpeed sets the link speed reported for OID_GEN_LINK_SPEED, in bits per
+// second.
+func (r *RNDIS) SetSpeed(speed uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.linkSpeed = speed
+}
+
+// Init initializes the class driver for the given interface.
+// This is called by the device stack when the class driver is attached.
+func (r *RNDIS) Init(iface *device.Interface) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch iface.Class {
+	case ClassCDC:
+		r.controlIface = iface
+		r.notifyEP = iface.GetEndpoint(r.notifyEPAddr)
+		if r.notifyEP != nil {
+			r.notifyXfer = device.NewInterruptTransfer(r.notifyEP, r.notifyBuf[:])
+			r.notifyXfer.WithCallback(func(*device.Transfer) {
+				r.mutex.Lock()
+				r.notifyPending = false
+				r.mutex.Unlock()
+			})
+		}
+
+	case ClassCDCData:
+		r.dataIface = iface
+		r.dataInEP = iface.GetEndpoint(r.dataInEPAddr)
+		r.dataOutEP = iface.GetEndpoint(r.dataOutEPAddr)
+	}
+
+	if r.controlIface != nil && r.dataIface != nil && r.notifyEP != nil &&
+		r.dataInEP != nil && r.dataOutEP != nil {
+		r.configured = true
+		pkg.LogDebug(pkg.ComponentDevice, "RNDIS configured",
+			"notify", r.notifyEP.Address,
+			"dataIn", r.dataInEP.Address,
+			"dataOut", r.dataOutEP.Address)
+	}
+
+	return nil
+}
+
+// HandleSetup processes SEND_ENCAPSULATED_COMMAND, whose data stage holds
+// an RNDIS control message, and GET_ENCAPSULATED_RESPONSE, which returns
+// the next queued response.
+func (r *RNDIS) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+	if !setup.IsClass() {
+		return nil, false, nil
+	}
+
+	switch {
+	case setup.Request == RequestSendEncapsulatedCommand && setup.IsHostToDevice():
+		// The RESPONSE_AVAILABLE notification completes asynchronously and
+		// is cancelled when the stack stops
+		if err := r.handleCommand(context.Background(), data); err != nil {
+			return nil, true, err
+		}
+		return nil, true, nil
+
+	case setup.Request == RequestGetEncapsulatedResponse && setup.IsDeviceToHost():
+		n := r.nextResponse()
+		return r.responseBuf[:n], true, nil
+
+	default:
+		return nil, false, nil
+	}
+}
+
+// SetAlternate handles alternate setting changes.
+func (r *RNDIS) SetAlternate(iface *device.Interface, alt uint8) error {
+	pkg.LogDebug(pkg.ComponentDevice, "RNDIS alternate setting",
+		"interface", iface.Number,
+		"alt", alt)
+	return nil
+}
+
+// Close releases resources held by the class driver.
+func (r *RNDIS) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.controlIface = nil
+	r.dataIface = nil
+	r.notifyEP = nil
+	r.dataInEP = nil
+	r.dataOutEP = nil
+	r.notifyXfer = nil
+	r.stack = nil
+	r.initialized = false
+	r.packetFilter = 0
+	r.responseLen = 0
+	r.statusLen = 0
+	r.configured = false
+
+	return nil
+}
+
+// ReadFrame reads an Ethernet frame from the host (blocking).
+// Returns the frame length, pkg.ErrBufferTooSmall if buf cannot hold the
+// frame (the frame is dropped), or pkg.ErrInvalidState while the data path
+// is stopped.
+func (r *RNDIS) ReadFrame(ctx context.Context, buf []byte) (int, error) {
+	r.rxMutex.Lock()
+	defer r.rxMutex.Unlock()
+
+	for {
+		// A trailing pad byte or partial header ends the transfer
+		if r.rxLen-r.rxOffset < RNDISPacketHeaderSize {
+			stack, ep, err := r.dataEndpoint(false)
+			if err != nil {
+				return 0, err
+			}
+			n, err := stack.Read(ctx, ep, r.rxBuf[:])
+			if err != nil {
+				return 0, err
+			}
+			r.rxOffset = 0
+			r.rxLen = n
+			continue
+		}
+
+		msg := r.rxBuf[r.rxOffset:r.rxLen]
+		frame, msgLen, err := parseRNDISPacket(msg)
+		if err != nil {
+			// Drop the rest of the transfer
+			r.rxOffset = r.rxLen
+			r.count(&r.stats.RcvError)
+			pkg.LogDebug(pkg.ComponentDevice, "RNDIS malformed packet dropped",
+				"type", getUint32(msg[0:]),
+				"length", getUint32(msg[4:]))
+			continue
+		}
+		r.rxOffset += msgLen
+
+		if len(frame) > len(buf) {
+			r.count(&r.stats.RcvNoBuffer)
+			return 0, pkg.ErrBufferTooSmall
+		}
+		r.count(&r.stats.RcvOK)
+		return copy(buf, frame), nil
+	}
+}
+
+// WriteFrame sends an Ethernet frame to the host in a packet message
+// (blocking). Returns pkg.ErrInvalidState while the data path is stopped.
+func (r *RNDIS) WriteFrame(ctx context.Context, frame []byte) error {
+	if len(frame) > MaxSegmentSize {
+		return pkg.ErrInvalidParameter
+	}
+	stack, ep, err := r.dataEndpoint(true)
+	if err != nil {
+		return err
+	}
+
+	r.txMutex.Lock()
+	defer r.txMutex.Unlock()
+
+	length := RNDISPacketHeaderSize + len(frame)
+	buf := r.txBuf[:length]
+	putUint32(buf[0:], RNDISPacketMsg)
+	putUint32(buf[4:], uint32(length))
+	putUint32(buf[8:], RNDISPacketHeaderSize-RNDISMessageHeaderSize) // DataOffset
+	putUint32(buf[12:], uint32(len(frame)))                          // DataLength
+	for i := 16; i < RNDISPacketHeaderSize; i++ {
+		buf[i] = 0 // No out-of-band data or per-packet info
+	}
+	copy(buf[RNDISPacketHeaderSize:], frame)
+
+	if _, err := stack.Write(ctx, ep, buf); err != nil {
+		r.count(&r.stats.XmitError)
+		return err
+	}
+	r.count(&r.stats.XmitOK)
+	return nil
+}
+
+// ConfigureDevice adds an interface association and the RNDIS interfaces
+// to a device builder. Call this after AddConfiguration.
+//
+// controlIfaceNum is the number the builder assigns to the communications
+// interface (the number of interfaces already in the configuration); the
+// data interface follows it.
+func (r *RNDIS) ConfigureDevice(builder *device.DeviceBuilder, controlIfaceNum, notifyEPAddr, dataInEPAddr, dataOutEPAddr uint8) *device.DeviceBuilder {
+	r.mutex.Lock()
+	r.controlIfaceNum = controlIfaceNum
+	r.notifyEPAddr = notifyEPAddr | device.EndpointDirectionIn
+	r.dataInEPAddr = dataInEPAddr | device.EndpointDirectionIn
+	r.dataOutEPAddr = dataOutEPAddr &^ device.EndpointDirectionIn
+
+	header := HeaderDescriptor{CDCVersion: 0x0110}
+	callMgmt := CallManagementDescriptor{DataInterface: controlIfaceNum + 1}
+	acm := ACMDescriptor{}
+	union := UnionDescriptor{
+		MasterInterface: controlIfaceNum,
+		SlaveInterface0: controlIfaceNum + 1,
+	}
+	n := header.MarshalTo(r.functionalDesc[:])
+	n += callMgmt.MarshalTo(r.functionalDesc[n:])
+	n += acm.MarshalTo(r.functionalDesc[n:])
+	n += union.MarshalTo(r.functionalDesc[n:])
+	functional := r.functionalDesc[:n]
+	notifyAddr, inAddr, outAddr := r.notifyEPAddr, r.dataInEPAddr, r.dataOutEPAddr
+	r.mutex.Unlock()
+
+	builder.AddInterfaceAssociation(2, ClassWirelessController, SubclassRF, ProtocolRNDIS)
+
+	// Communications interface
+	builder.AddInterface(ClassCDC, SubclassACM, ProtocolVendor)
+	builder.AddClassDescriptor(functional)
+	builder.AddEndpointDescriptor(&device.EndpointDescriptor{
+		Length:          device.EndpointDescriptorSize,
+		DescriptorType:  device.DescriptorTypeEndpoint,
+		EndpointAddress: notifyAddr,
+		Attributes:      device.EndpointTypeInterrupt,
+		MaxPacketSize:   RNDISNotificationSize,
+		Interval:        NotifyInterval,
+	})
+
+	// Data interface
+	builder.AddInterface(ClassCDCData, SubclassNone, ProtocolDataNone)
+	builder.AddEndpoint(inAddr, device.EndpointTypeBulk, DataMaxPacketSize)
+	builder.AddEndpoint(outAddr, device.EndpointTypeBulk, DataMaxPacketSize)
+
+	return builder
+}
+
+// AttachToInterfaces attaches this class driver to the RNDIS interfaces and
+// sets the device class to use interface association descriptors.
+// configValue is the configuration value (e.g., 1), controlIfaceNum is the
+// communications interface number given to ConfigureDevice.
+func (r *RNDIS) AttachToInterfaces(dev *device.Device, configValue, controlIfaceNum uint8) error {
+	config := dev.GetConfiguration(configValue)
+	if config == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	controlIface := config.GetInterface(controlIfaceNum)
+	if controlIface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	dataIface := config.GetInterface(controlIfaceNum + 1)
+	if dataIface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	dev.Descriptor.DeviceClass = device.ClassMisc
+	dev.Descriptor.DeviceSubClass = 0x02 // Common Class
+	dev.Descriptor.DeviceProtocol = 0x01 // Interface Association Descriptor
+
+	if err := controlIface.SetClassDriver(r); err != nil {
+		return err
+	}
+	return dataIface.SetClassDriver(r)
+}
+
+// activeLocked returns true if the data path is running
+// (caller must hold r.mutex).
+func (r *RNDIS) activeLocked() bool {
+	return r.initialized && r.packetFilter != 0
+}
+
+// count increments a statistics counter.
+func (r *RNDIS) count(counter *uint32) {
+	r.mutex.Lock()
+	*counter++
+	r.mutex.Unlock()
+}
+
+// dataEndpoint returns the stack and a data endpoint if the data path is
+// running.
+func (r *RNDIS) dataEndpoint(in bool) (*device.Stack, *device.Endpoint, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	ep := r.dataOutEP
+	if in {
+		ep = r.dataInEP
+	}
+	if !r.configured || r.stack == nil || ep == nil {
+		return nil, nil, pkg.ErrNotConfigured
+	}
+	if !r.activeLocked() {
+		return nil, nil, pkg.ErrInvalidState
+	}
+	return r.stack, ep, nil
+}
+
+// notify sends a RESPONSE_AVAILABLE notification without blocking. If a
+// notification is already in flight, the host collects the queued
+// responses with it.
+func (r *RNDIS) notify(ctx context.Context) error {
+	r.mutex.Lock()
+	stack := r.stack
+	t := r.notifyXfer
+	if stack == nil || t == nil {
+		r.mutex.Unlock()
+		return pkg.ErrNotConfigured
+	}
+	if r.notifyPending {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.notifyPending = true
+	r.mutex.Unlock()
+
+	putUint32(r.notifyBuf[0:], RNDISResponseAvailable)
+	putUint32(r.notifyBuf[4:], 0) // Reserved
+	t.Reset()
+	t.WithContext(ctx)
+	if err := stack.SubmitTransfer(t); err != nil {
+		r.mutex.Lock()
+		r.notifyPending = false
+		r.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// nextResponse moves the next queued response into responseBuf and
+// returns its length. With nothing queued, the response is a single zero
+// byte.
+func (r *RNDIS) nextResponse() int {
+	r.mutex.Lock()
+	var n int
+	switch {
+	case r.responseLen > 0:
+		n = copy(r.responseBuf[:], r.response[:r.responseLen])
+		r.responseLen = 0
+	case r.statusLen > 0:
+		n = copy(r.responseBuf[:], r.status[:r.statusLen])
+		r.statusLen = 0
+	default:
+		r.responseBuf[0] = 0
+		n = 1
+	}
+	more := r.responseLen > 0 || r.statusLen > 0
+	r.mutex.Unlock()
+
+	if more {
+		r.notify(context.Background())
+	}
+	return n
+}
+
+// handleCommand processes an RNDIS control message, queues its response,
+// and notifies the host.
+func (r *RNDIS) handleCommand(ctx context.Context, msg []byte) error {
+	if len(msg) < RNDISMessageHeaderSize+4 {
+		return pkg.ErrBufferTooSmall
+	}
+	msgType := getUint32(msg[0:])
+	if msgLen := getUint32(msg[4:]); uint64(msgLen) > uint64(len(msg)) {
+		return pkg.ErrBufferTooSmall
+	}
+
+	r.mutex.Lock()
+	wasActive := r.activeLocked()
+	oldFilter := r.packetFilter
+	var n int
+	switch msgType {
+	case RNDISInitializeMsg:
+		n = r.initializeLocked(msg)
+
+	case RNDISHaltMsg:
+		r.initialized = false
+		r.packetFilter = 0
+		r.responseLen = 0
+		r.statusLen = 0
+
+	case RNDISQueryMsg:
+		n = r.queryLocked(msg)
+
+	case RNDISSetMsg:
+		n = r.setLocked(msg)
+
+	case RNDISResetMsg:
+		putUint32(r.response[8:], RNDISStatusSuccess)
+		putUint32(r.response[12:], 0) // AddressingReset
+		n = r.completeLocked(RNDISResetCmplt, RNDISResetCmpltSize)
+
+	case RNDISKeepaliveMsg:
+		putUint32(r.response[8:], getUint32(msg[8:])) // RequestID
+		putUint32(r.response[12:], RNDISStatusSuccess)
+		n = r.completeLocked(RNDISKeepaliveCmplt, RNDISKeepaliveCmpltSize)
+
+	default:
+		pkg.LogDebug(pkg.ComponentDevice, "RNDIS message ignored",
+			"type", msgType)
+	}
+	r.responseLen = n
+	active := r.activeLocked()
+	activeCb := r.onActiveChange
+	filter := r.packetFilter
+	filterCb := r.onPacketFilterChange
+	r.mutex.Unlock()
+
+	if filter != oldFilter && filterCb != nil {
+		filterCb(filter)
+	}
+	if active != wasActive && activeCb != nil {
+		activeCb(active)
+	}
+
+	if n == 0 {
+		return nil
+	}
+	if err := r.notify(ctx); err != nil {
+		pkg.LogDebug(pkg.ComponentDevice, "RNDIS notification failed",
+			"error", err)
+	}
+	return nil
+}
+
+// completeLocked writes the message header of a response already filled in
+// from offset 8 (caller must hold r.mutex). Returns the response length.
+func (r *RNDIS) completeLocked(msgType uint32, length int) int {
+	putUint32(r.response[0:], msgType)
+	putUint32(r.response[4:], uint32(length))
+	return length
+}
+
+// initializeLocked handles REMOTE_NDIS_INITIALIZE_MSG
+// (caller must hold r.mutex).
+func (r *RNDIS) initializeLocked(msg []byte) int {
+	r.initialized = true
+	r.statusLen = 0
+
+	resp := r.response[:]
+	putUint32(resp[8:], getUint32(msg[8:])) // RequestID
+	putUint32(resp[12:], RNDISStatusSuccess)
+	putUint32(resp[16:], RNDISMajorVersion)
+	putUint32(resp[20:], RNDISMinorVersion)
+	putUint32(resp[24:], RNDISDeviceConnectionless)
+	putUint32(resp[28:], RNDISMedium8023)
+	putUint32(resp[32:], 1) // MaxPacketsPerTransfer
+	putUint32(resp[36:], RNDISMaxTransferSize)
+	putUint32(resp[40:], 0) // PacketAlignmentFactor
+	putUint32(resp[44:], 0) // AFListOffset
+	putUint32(resp[48:], 0) // AFListSize
+
+	pkg.LogDebug(pkg.ComponentDevice, "RNDIS initialized",
+		"hostMaxTransfer", msgUint32(msg, 20))
+	return r.completeLocked(RNDISInitializeCmplt, RNDISInitializeCmpltSize)
+}
+
+// queryLocked handles REMOTE_NDIS_QUERY_MSG (caller must hold r.mutex).
+func (r *RNDIS) queryLocked(msg []byte) int {
+	oid := msgUint32(msg, 12)
+	data := r.response[RNDISQueryCmpltHeaderSize:]
+	n, status := r.queryOIDLocked(oid, data)
+
+	resp := r.response[:]
+	putUint32(resp[8:], msgUint32(msg, 8)) // RequestID
+	putUint32(resp[12:], status)
+	putUint32(resp[16:], uint32(n)) // InformationBufferLength
+	if n > 0 {
+		putUint32(resp[20:], RNDISQueryCmpltHeaderSize-RNDISMessageHeaderSize)
+	} else {
+		putUint32(resp[20:], 0)
+	}
+	return r.completeLocked(RNDISQueryCmplt, RNDISQueryCmpltHeaderSize+n)
+}
+
+// queryOIDLocked writes the value of oid to buf (caller must hold r.mutex).
+// Returns the value length and the RNDIS status.
+func (r *RNDIS) queryOIDLocked(oid uint32, buf []byte) (int, uint32) {
+	var value uint32
+	switch oid {
+	case OIDGenSupportedList:
+		for i, id := range rndisSupportedOIDs {
+			putUint32(buf[4*i:], id)
+		}
+		return 4 * len(rndisSupportedOIDs), RNDISStatusSuccess
+
+	case OIDGenVendorDescription:
+		n := copy(buf, RNDISVendorDescription)
+		buf[n] = 0
+		return n + 1, RNDISStatusSuccess
+
+	case OID8023PermanentAddress, OID8023CurrentAddress:
+		return copy(buf, r.hostMAC[:]), RNDISStatusSuccess
+
+	case OID8023MulticastList:
+		return 0, RNDISStatusSuccess
+
+	case OIDGenHardwareStatus, OIDGenMediaSupported, OIDGenMediaInUse, OIDGenPhysicalMedium,
+		OID8023RcvErrorAlignment, OID8023XmitOneCollision, OID8023XmitMoreCollisions:
+		value = 0 // Ready, 802.3, unspecified medium, no collisions
+
+	case OIDGenMaximumFrameSize:
+		value = MaxSegmentSize - 14 // Without the Ethernet header
+	case OIDGenLinkSpeed:
+		value = r.linkSpeed / 100 // In units of 100 bps
+	case OIDGenTransmitBlockSize, OIDGenReceiveBlockSize:
+		value = MaxSegmentSize
+	case OIDGenVendorID:
+		value = 0x00FFFFFF // No IEEE OUI
+	case OIDGenCurrentPacketFilter:
+		value = r.packetFilter
+	case OIDGenMaximumTotalSize:
+		value = RNDISMaxTransferSize
+	case OIDGenMediaConnectStatus:
+		value = 1 // Disconnected
+		if r.connected {
+			value = 0
+		}
+	case OIDGenXmitOK:
+		value = r.stats.XmitOK
+	case OIDGenRcvOK:
+		value = r.stats.RcvOK
+	case OIDGenXmitError:
+		value = r.stats.XmitError
+	case OIDGenRcvError:
+		value = r.stats.RcvError
+	case OIDGenRcvNoBuffer:
+		value = r.stats.RcvNoBuffer
+	case OID8023MaximumListSize:
+		value = 1
+
+	default:
+		pkg.LogDebug(pkg.ComponentDevice, "RNDIS query not supported",
+			"oid", oid)
+		return 0, RNDISStatusNotSupported
+	}
+
+	putUint32(buf, value)
+	return 4, RNDISStatusSuccess
+}
+
+// setLocked handles REMOTE_NDIS_SET_MSG (caller must hold r.mutex).
+func (r *RNDIS) setLocked(msg []byte) int {
+	oid := msgUint32(msg, 12)
+	length := uint64(msgUint32(msg, 16))
+	offset := RNDISMessageHeaderSize + uint64(msgUint32(msg, 20))
+
+	status := uint32(RNDISStatusSuccess)
+	if offset+length > uint64(len(msg)) {
+		status = RNDISStatusInvalidData
+	} else {
+		status = r.setOIDLocked(oid, msg[offset:offset+length])
+	}
+
+	putUint32(r.response[8:], msgUint32(msg, 8)) // RequestID
+	putUint32(r.response[12:], status)
+	return r.completeLocked(RNDISSetCmplt, RNDISSetCmpltSize)
+}
+
+// setOIDLocked sets the value of oid from data (caller must hold r.mutex).
+// Returns the RNDIS status.
+func (r *RNDIS) setOIDLocked(oid uint32, data []byte) uint32 {
+	switch oid {
+	case OIDGenCurrentPacketFilter:
+		if len(data) < 4 {
+			return RNDISStatusInvalidData
+		}
+		r.packetFilter = getUint32(data)
+		pkg.LogDebug(pkg.ComponentDevice, "RNDIS packet filter set",
+			"filter", r.packetFilter)
+		return RNDISStatusSuccess
+
+	case OID8023MulticastList, OIDGenRNDISConfigParameter:
+		return RNDISStatusSuccess // Accepted and ignored
+
+	default:
+		pkg.LogDebug(pkg.ComponentDevice, "RNDIS set not supported",
+			"oid", oid)
+		return RNDISStatusNotSupported
+	}
+}
+
+// parseRNDISPacket validates the REMOTE_NDIS_PACKET_MSG at the start of msg.
+// Returns the Ethernet frame it carries and the message length, or
+// pkg.ErrProtocol if the message is malformed. Lengths are compared as
+// uint64 so that no field can overflow int on 32-bit targets.
+func parseRNDISPacket(msg []byte) ([]byte, int, error) {
+	if len(msg) < RNDISPacketHeaderSize || getUint32(msg[0:]) != RNDISPacketMsg {
+		return nil, 0, pkg.ErrProtocol
+	}
+	msgLen := uint64(getUint32(msg[4:]))
+	dataStart := RNDISMessageHeaderSize + uint64(getUint32(msg[8:]))
+	dataLen := uint64(getUint32(msg[12:]))
+	if msgLen < RNDISPacketHeaderSize || msgLen > uint64(len(msg)) ||
+		dataStart < RNDISPacketHeaderSize || dataStart+dataLen > msgLen {
+		return nil, 0, pkg.ErrProtocol
+	}
+	return msg[dataStart : dataStart+dataLen], int(msgLen), nil
+}
+
+// msgUint32 reads a little-endian uint32 at offset in msg, or 0 if msg is
+// too short.
+func msgUint32(msg []byte, offset int) uint32 {
+	if offset+4 > len(msg) {
+		return 0
+	}
+	return getUint32(msg[offset:])
+}
+
+// Compile-time interface check
+var _ device.ClassDriver = (*RNDIS)(nil)
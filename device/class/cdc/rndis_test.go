@@ -0,0 +1,215 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// rndisPacket returns a packet message carrying frame.
+func rndisPacket(frame []byte) []byte {
+	msg := make([]byte, RNDISPacketHeaderSize+len(frame))
+	putUint32(msg[0:], RNDISPacketMsg)
+	putUint32(msg[4:], uint32(len(msg)))
+	putUint32(msg[8:], RNDISPacketHeaderSize-RNDISMessageHeaderSize)
+	putUint32(msg[12:], uint32(len(frame)))
+	copy(msg[RNDISPacketHeaderSize:], frame)
+	return msg
+}
+
+// rndisMessage returns a control message of the given type with the
+// request ID and fields that follow it.
+func rndisMessage(msgType uint32, fields ...uint32) []byte {
+	msg := make([]byte, RNDISMessageHeaderSize+4*len(fields))
+	putUint32(msg[0:], msgType)
+	putUint32(msg[4:], uint32(len(msg)))
+	for i, v := range fields {
+		putUint32(msg[RNDISMessageHeaderSize+4*i:], v)
+	}
+	return msg
+}
+
+// rndisResponse returns the next queued response.
+func rndisResponse(r *RNDIS) []byte {
+	n := r.nextResponse()
+	return append([]byte{}, r.responseBuf[:n]...)
+}
+
+func TestParseRNDISPacket(t *testing.T) {
+	frame := testFrame(60)
+	msg := rndisPacket(frame)
+
+	got, n, err := parseRNDISPacket(append(msg, 0)) // Trailing pad byte
+	if err != nil {
+		t.Fatalf("parseRNDISPacket() error = %v", err)
+	}
+	if n != len(msg) || !bytes.Equal(got, frame) {
+		t.Errorf("parseRNDISPacket() = % X, %d, want % X, %d", got, n, frame, len(msg))
+	}
+
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{"empty", func(b []byte) []byte { return b[:0] }},
+		{"short header", func(b []byte) []byte { return b[:RNDISPacketHeaderSize-1] }},
+		{"type", func(b []byte) []byte { putUint32(b[0:], RNDISInitializeMsg); return b }},
+		{"message length short", func(b []byte) []byte { putUint32(b[4:], RNDISPacketHeaderSize-1); return b }},
+		{"message length past end", func(b []byte) []byte { putUint32(b[4:], uint32(len(b)+1)); return b }},
+		{"message length overflow", func(b []byte) []byte { putUint32(b[4:], 0xFFFFFFFF); return b }},
+		{"data in header", func(b []byte) []byte { putUint32(b[8:], 0); return b }},
+		{"data past message", func(b []byte) []byte { putUint32(b[12:], uint32(len(frame)+1)); return b }},
+		{"data offset overflow", func(b []byte) []byte { putUint32(b[8:], 0xFFFFFFFC); return b }},
+		{"data length overflow", func(b []byte) []byte { putUint32(b[12:], 0xFFFFFFFF); return b }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(append([]byte{}, msg...))
+			if _, _, err := parseRNDISPacket(data); err != pkg.ErrProtocol {
+				t.Errorf("parseRNDISPacket() error = %v, want %v", err, pkg.ErrProtocol)
+			}
+		})
+	}
+}
+
+func TestRNDISReadFrameMalformed(t *testing.T) {
+	r := NewRNDIS([6]byte{0x02, 0, 0, 0, 0, 1})
+
+	// A valid packet, a malformed packet, and another valid packet in one
+	// transfer: the rest of the transfer is dropped at the malformed one
+	first := testFrame(20)
+	bad := rndisPacket(testFrame(8))
+	putUint32(bad[12:], 0xFFFFFFFF)
+	transfer := append(append(rndisPacket(first), bad...), rndisPacket(testFrame(4))...)
+	r.rxLen = copy(r.rxBuf[:], transfer)
+
+	buf := make([]byte, MaxSegmentSize)
+	n, err := r.ReadFrame(context.Background(), buf)
+	if err != nil || !bytes.Equal(buf[:n], first) {
+		t.Fatalf("ReadFrame() = % X, %v, want % X", buf[:n], err, first)
+	}
+
+	// The data path is not configured, so the next read fails once the
+	// malformed packet is dropped
+	if _, err := r.ReadFrame(context.Background(), buf); err != pkg.ErrNotConfigured {
+		t.Errorf("ReadFrame() error = %v, want %v", err, pkg.ErrNotConfigured)
+	}
+	stats := r.Statistics()
+	if stats.RcvOK != 1 || stats.RcvError != 1 {
+		t.Errorf("statistics = %+v, want 1 received and 1 error", stats)
+	}
+
+	// A frame larger than the buffer is dropped
+	r.rxOffset, r.rxLen = 0, copy(r.rxBuf[:], rndisPacket(first))
+	if _, err := r.ReadFrame(context.Background(), buf[:len(first)-1]); err != pkg.ErrBufferTooSmall {
+		t.Errorf("ReadFrame(short) error = %v, want %v", err, pkg.ErrBufferTooSmall)
+	}
+	if stats := r.Statistics(); stats.RcvNoBuffer != 1 {
+		t.Errorf("RcvNoBuffer = %d, want 1", stats.RcvNoBuffer)
+	}
+}
+
+func TestRNDISHandleCommandMalformed(t *testing.T) {
+	r := NewRNDIS([6]byte{0x02, 0, 0, 0, 0, 1})
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"short", rndisMessage(RNDISKeepaliveMsg)},
+		{"length past end", func() []byte {
+			msg := rndisMessage(RNDISKeepaliveMsg, 1)
+			putUint32(msg[4:], uint32(len(msg)+1))
+			return msg
+		}()},
+		{"length overflow", func() []byte {
+			msg := rndisMessage(RNDISKeepaliveMsg, 1)
+			putUint32(msg[4:], 0xFFFFFFFF)
+			return msg
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.handleCommand(context.Background(), tt.msg); err != pkg.ErrBufferTooSmall {
+				t.Errorf("handleCommand() error = %v, want %v", err, pkg.ErrBufferTooSmall)
+			}
+			if resp := rndisResponse(r); len(resp) != 1 || resp[0] != 0 {
+				t.Errorf("response = % X, want none", resp)
+			}
+		})
+	}
+}
+
+func TestRNDISHandleCommand(t *testing.T) {
+	r := NewRNDIS([6]byte{0x02, 0, 0, 0, 0, 1})
+
+	// Initialize with only the request ID
+	if err := r.handleCommand(context.Background(), rndisMessage(RNDISInitializeMsg, 1)); err != nil {
+		t.Fatalf("handleCommand(initialize) error = %v", err)
+	}
+	resp := rndisResponse(r)
+	if len(resp) != RNDISInitializeCmpltSize || getUint32(resp[0:]) != RNDISInitializeCmplt ||
+		getUint32(resp[8:]) != 1 || getUint32(resp[12:]) != RNDISStatusSuccess {
+		t.Errorf("initialize response = % X", resp)
+	}
+
+	// A query truncated before the OID queries OID 0, which is unsupported
+	if err := r.handleCommand(context.Background(), rndisMessage(RNDISQueryMsg, 2)); err != nil {
+		t.Fatalf("handleCommand(query) error = %v", err)
+	}
+	resp = rndisResponse(r)
+	if getUint32(resp[0:]) != RNDISQueryCmplt || getUint32(resp[12:]) != RNDISStatusNotSupported {
+		t.Errorf("query response = % X", resp)
+	}
+
+	if err := r.handleCommand(context.Background(), rndisMessage(RNDISQueryMsg, 3, OID8023CurrentAddress)); err != nil {
+		t.Fatalf("handleCommand(query) error = %v", err)
+	}
+	resp = rndisResponse(r)
+	if len(resp) != RNDISQueryCmpltHeaderSize+6 || getUint32(resp[16:]) != 6 ||
+		!bytes.Equal(resp[RNDISQueryCmpltHeaderSize:], []byte{0x02, 0, 0, 0, 0, 1}) {
+		t.Errorf("query response = % X", resp)
+	}
+
+	// Set requests whose information buffer is not within the message
+	const infoOffset = 20 // From the RequestID field
+	sets := []struct {
+		name   string
+		length uint32
+		offset uint32
+		want   uint32
+	}{
+		{"buffer past end", 8, infoOffset, RNDISStatusInvalidData},
+		{"offset past end", 4, 0xFFFFFFF8, RNDISStatusInvalidData},
+		{"length overflow", 0xFFFFFFFF, infoOffset, RNDISStatusInvalidData},
+		{"short filter", 2, infoOffset, RNDISStatusInvalidData},
+		{"filter", 4, infoOffset, RNDISStatusSuccess},
+	}
+	for _, tt := range sets {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := rndisMessage(RNDISSetMsg, 4, OIDGenCurrentPacketFilter, tt.length, tt.offset, 0, PacketFilterDirected)
+			if err := r.handleCommand(context.Background(), msg); err != nil {
+				t.Fatalf("handleCommand(set) error = %v", err)
+			}
+			resp := rndisResponse(r)
+			if len(resp) != RNDISSetCmpltSize || getUint32(resp[12:]) != tt.want {
+				t.Errorf("set response = % X, want status 0x%08X", resp, tt.want)
+			}
+		})
+	}
+	if r.PacketFilter() != PacketFilterDirected || !r.Active() {
+		t.Errorf("packet filter = 0x%X, active = %v, want 0x%X, true", r.PacketFilter(), r.Active(), PacketFilterDirected)
+	}
+
+	if err := r.handleCommand(context.Background(), rndisMessage(RNDISHaltMsg, 5)); err != nil {
+		t.Fatalf("handleCommand(halt) error = %v", err)
+	}
+	if r.Active() {
+		t.Error("Active() should be false after halt")
+	}
+}
@@ -48,6 +48,79 @@ const (
 	ProtocolReport = 0x01 // Report protocol
 )
 
+// Input, Output, and Feature item flags. The zero value of each bit is
+// Data, Array, Absolute, No Wrap, Linear, Preferred State, No Null
+// Position, Non Volatile, and Bit Field.
+const (
+	FlagConstant      = 1 << 0 // Constant (vs. Data)
+	FlagVariable      = 1 << 1 // Variable (vs. Array)
+	FlagRelative      = 1 << 2 // Relative (vs. Absolute)
+	FlagWrap          = 1 << 3 // Wrap
+	FlagNonLinear     = 1 << 4 // Non-linear
+	FlagNoPreferred   = 1 << 5 // No preferred state
+	FlagNullState     = 1 << 6 // Null state
+	FlagVolatile      = 1 << 7 // Volatile (Output and Feature only)
+	FlagBufferedBytes = 1 << 8 // Buffered bytes (vs. Bit field)
+)
+
+// Collection types.
+const (
+	CollectionPhysical      = 0x00
+	CollectionApplication   = 0x01
+	CollectionLogical       = 0x02
+	CollectionReport        = 0x03
+	CollectionNamedArray    = 0x04
+	CollectionUsageSwitch   = 0x05
+	CollectionUsageModifier = 0x06
+)
+
+// Usage pages (HID Usage Tables).
+const (
+	UsagePageGenericDesktop = 0x01
+	UsagePageSimulation     = 0x02
+	UsagePageGame           = 0x05
+	UsagePageGenericDevice  = 0x06
+	UsagePageKeyboard       = 0x07
+	UsagePageLED            = 0x08
+	UsagePageButton         = 0x09
+	UsagePageOrdinal        = 0x0A
+	UsagePageConsumer       = 0x0C
+	UsagePageDigitizer      = 0x0D
+	UsagePagePID            = 0x0F
+	UsagePageVendorDefined  = 0xFF00 // First vendor-defined page
+)
+
+// Generic Desktop page usages.
+const (
+	UsagePointer         = 0x01
+	UsageMouse           = 0x02
+	UsageJoystick        = 0x04
+	UsageGamepad         = 0x05
+	UsageKeyboard        = 0x06
+	UsageKeypad          = 0x07
+	UsageMultiAxis       = 0x08
+	UsageX               = 0x30
+	UsageY               = 0x31
+	UsageZ               = 0x32
+	UsageRx              = 0x33
+	UsageRy              = 0x34
+	UsageRz              = 0x35
+	UsageSlider          = 0x36
+	UsageDial            = 0x37
+	UsageWheel           = 0x38
+	UsageHatSwitch       = 0x39
+	UsageSystemControl   = 0x80
+	UsageSystemPowerDown = 0x81
+	UsageSystemSleep     = 0x82
+	UsageSystemWakeUp    = 0x83
+)
+
+// Consumer page usages.
+const (
+	UsageConsumerControl = 0x01
+	UsageACPan           = 0x238
+)
+
 // HIDDescriptor is the HID class descriptor.
 type HIDDescriptor struct {
 	Length         uint8  // Size of this descriptor (9)
@@ -189,6 +262,7 @@ const (
 	KeyLeftBrace   = 0x2F
 	KeyRightBrace  = 0x30
 	KeyBackslash   = 0x31
+	KeyNonUSHash   = 0x32
 	KeySemicolon   = 0x33
 	KeyQuote       = 0x34
 	KeyGrave       = 0x35
@@ -221,6 +295,8 @@ const (
 	KeyLeft        = 0x50
 	KeyDown        = 0x51
 	KeyUp          = 0x52
+
+	KeyNonUSBackslash = 0x64
 )
 
 // Mouse button bits.
@@ -230,6 +306,47 @@ const (
 	MouseButtonMiddle = 1 << 2
 )
 
+// Consumer page usages for media keys (used in ConsumerReport).
+const (
+	ConsumerNone           = 0x000
+	ConsumerPower          = 0x030
+	ConsumerBrightnessUp   = 0x06F
+	ConsumerBrightnessDown = 0x070
+	ConsumerScanNext       = 0x0B5
+	ConsumerScanPrevious   = 0x0B6
+	ConsumerStop           = 0x0B7
+	ConsumerEject          = 0x0B8
+	ConsumerPlayPause      = 0x0CD
+	ConsumerMute           = 0x0E2
+	ConsumerVolumeUp       = 0x0E9
+	ConsumerVolumeDown     = 0x0EA
+	ConsumerMediaSelect    = 0x183
+	ConsumerMail           = 0x18A
+	ConsumerCalculator     = 0x192
+	ConsumerBrowser        = 0x196
+	ConsumerSearch         = 0x221
+	ConsumerHome           = 0x223
+	ConsumerBack           = 0x224
+	ConsumerForward        = 0x225
+	ConsumerRefresh        = 0x227
+	ConsumerBookmarks      = 0x22A
+)
+
+// System control bits (used in SystemControlReport).
+const (
+	SystemPowerDown = 1 << 0
+	SystemSleep     = 1 << 1
+	SystemWakeUp    = 1 << 2
+)
+
+// Report IDs used by CompositeReportDescriptor.
+const (
+	ReportIDKeyboard      = 0x01
+	ReportIDMouse         = 0x02
+	ReportIDConsumer      = 0x03
+	ReportIDSystemControl = 0x04
+)
+
 // KeyboardReportDescriptor is a standard 8-byte keyboard report descriptor.
 // Report format: [modifiers, reserved, key1, key2, key3, key4, key5, key6]
 var KeyboardReportDescriptor = []byte{
@@ -391,3 +508,154 @@ func (r *MouseReport) Clear() {
 	r.Y = 0
 	r.Wheel = 0
 }
+
+// ConsumerReport is a 2-byte consumer control (media key) input report.
+type ConsumerReport struct {
+	Usage uint16 // Consumer page usage of the pressed key (Consumer*), or 0
+}
+
+// ConsumerReportSize is the size of a consumer control report in bytes.
+const ConsumerReportSize = 2
+
+// MarshalTo writes the consumer control report to buf.
+func (r *ConsumerReport) MarshalTo(buf []byte) int {
+	if len(buf) < ConsumerReportSize {
+		return 0
+	}
+	buf[0] = byte(r.Usage)
+	buf[1] = byte(r.Usage >> 8)
+	return ConsumerReportSize
+}
+
+// Clear resets the consumer control report to no key pressed.
+func (r *ConsumerReport) Clear() {
+	r.Usage = ConsumerNone
+}
+
+// SystemControlReport is a 1-byte system control input report.
+type SystemControlReport struct {
+	Buttons uint8 // System control bits (System*)
+}
+
+// SystemControlReportSize is the size of a system control report in bytes.
+const SystemControlReportSize = 1
+
+// MarshalTo writes the system control report to buf.
+func (r *SystemControlReport) MarshalTo(buf []byte) int {
+	if len(buf) < SystemControlReportSize {
+		return 0
+	}
+	buf[0] = r.Buttons
+	return SystemControlReportSize
+}
+
+// Clear resets the system control report to no button pressed.
+func (r *SystemControlReport) Clear() {
+	r.Buttons = 0
+}
+
+// CompositeReportDescriptor describes a keyboard, a mouse, a consumer
+// control (media keys), and a system control on one interface, each
+// prefixed with its report ID (ReportID*). The keyboard and mouse reports
+// follow the KeyboardReport and MouseReport formats.
+// Input reports:
+//
+//	ReportIDKeyboard:      [id, modifiers, reserved, key1, ..., key6]
+//	ReportIDMouse:         [id, buttons, X, Y, wheel]
+//	ReportIDConsumer:      [id, usage low, usage high]
+//	ReportIDSystemControl: [id, buttons]
+//
+// Output report ReportIDKeyboard carries the keyboard LED state.
+var CompositeReportDescriptor = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x06, // Usage (Keyboard)
+	0xA1, 0x01, // Collection (Application)
+	0x85, ReportIDKeyboard, // Report ID (1)
+	0x05, 0x07, //   Usage Page (Keyboard/Keypad)
+	0x19, 0xE0, //   Usage Minimum (Left Control)
+	0x29, 0xE7, //   Usage Maximum (Right GUI)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x75, 0x01, //   Report Size (1)
+	0x95, 0x08, //   Report Count (8)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - Modifier byte
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x08, //   Report Size (8)
+	0x81, 0x01, //   Input (Constant) - Reserved byte
+	0x95, 0x05, //   Report Count (5)
+	0x75, 0x01, //   Report Size (1)
+	0x05, 0x08, //   Usage Page (LEDs)
+	0x19, 0x01, //   Usage Minimum (Num Lock)
+	0x29, 0x05, //   Usage Maximum (Kana)
+	0x91, 0x02, //   Output (Data, Variable, Absolute) - LED report
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x03, //   Report Size (3)
+	0x91, 0x01, //   Output (Constant) - Padding
+	0x95, 0x06, //   Report Count (6)
+	0x75, 0x08, //   Report Size (8)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x26, 0xFF, 0x00, // Logical Maximum (255)
+	0x05, 0x07, //   Usage Page (Keyboard/Keypad)
+	0x19, 0x00, //   Usage Minimum (0)
+	0x2A, 0xFF, 0x00, // Usage Maximum (255)
+	0x81, 0x00, //   Input (Data, Array) - Key array
+	0xC0, // End Collection
+
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x02, // Usage (Mouse)
+	0xA1, 0x01, // Collection (Application)
+	0x85, ReportIDMouse, // Report ID (2)
+	0x09, 0x01, //   Usage (Pointer)
+	0xA1, 0x00, //   Collection (Physical)
+	0x05, 0x09, //     Usage Page (Button)
+	0x19, 0x01, //     Usage Minimum (Button 1)
+	0x29, 0x03, //     Usage Maximum (Button 3)
+	0x15, 0x00, //     Logical Minimum (0)
+	0x25, 0x01, //     Logical Maximum (1)
+	0x95, 0x03, //     Report Count (3)
+	0x75, 0x01, //     Report Size (1)
+	0x81, 0x02, //     Input (Data, Variable, Absolute) - Button bits
+	0x95, 0x01, //     Report Count (1)
+	0x75, 0x05, //     Report Size (5)
+	0x81, 0x01, //     Input (Constant) - Padding
+	0x05, 0x01, //     Usage Page (Generic Desktop)
+	0x09, 0x30, //     Usage (X)
+	0x09, 0x31, //     Usage (Y)
+	0x09, 0x38, //     Usage (Wheel)
+	0x15, 0x81, //     Logical Minimum (-127)
+	0x25, 0x7F, //     Logical Maximum (127)
+	0x75, 0x08, //     Report Size (8)
+	0x95, 0x03, //     Report Count (3)
+	0x81, 0x06, //     Input (Data, Variable, Relative) - X, Y, Wheel
+	0xC0, //   End Collection
+	0xC0, // End Collection
+
+	0x05, 0x0C, // Usage Page (Consumer)
+	0x09, 0x01, // Usage (Consumer Control)
+	0xA1, 0x01, // Collection (Application)
+	0x85, ReportIDConsumer, // Report ID (3)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x26, 0xFF, 0x03, // Logical Maximum (1023)
+	0x19, 0x00, //   Usage Minimum (0)
+	0x2A, 0xFF, 0x03, // Usage Maximum (1023)
+	0x75, 0x10, //   Report Size (16)
+	0x95, 0x01, //   Report Count (1)
+	0x81, 0x00, //   Input (Data, Array, Absolute) - Consumer usage
+	0xC0, // End Collection
+
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x80, // Usage (System Control)
+	0xA1, 0x01, // Collection (Application)
+	0x85, ReportIDSystemControl, // Report ID (4)
+	0x19, 0x81, //   Usage Minimum (System Power Down)
+	0x29, 0x83, //   Usage Maximum (System Wake Up)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x75, 0x01, //   Report Size (1)
+	0x95, 0x03, //   Report Count (3)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - System control bits
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x05, //   Report Size (5)
+	0x81, 0x01, //   Input (Constant) - Padding
+	0xC0, // End Collection
+}
@@ -0,0 +1,607 @@
+package hid
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Report descriptor validation errors, wrapped in a [DescriptorError].
+var (
+	// ErrItemTruncated is returned when an item extends past the end of
+	// the report descriptor.
+	ErrItemTruncated = errors.New("item truncated")
+
+	// ErrCollectionNesting is returned for an End Collection without a
+	// matching Collection, a collection left open, a top-level collection
+	// that is not an Application collection, or an Input, Output, or
+	// Feature item outside any collection.
+	ErrCollectionNesting = errors.New("unbalanced collection")
+
+	// ErrReportID is returned for a Report ID of 0, or when some reports
+	// have a report ID and others do not.
+	ErrReportID = errors.New("inconsistent report ID")
+
+	// ErrReportSize is returned for an Input, Output, or Feature item with
+	// a Report Size or Report Count of 0, a data field wider than 32 bits,
+	// or a report longer than MaxReportBits.
+	ErrReportSize = errors.New("invalid report size or count")
+
+	// ErrLogicalRange is returned when Logical Minimum exceeds Logical
+	// Maximum, or the logical range does not fit in Report Size bits.
+	ErrLogicalRange = errors.New("invalid logical range")
+
+	// ErrItemStack is returned for a Pop without a Push, more than
+	// MaxItemStackDepth nested Push items, or a Push without a Pop.
+	ErrItemStack = errors.New("unbalanced push or pop")
+
+	// ErrUsageRange is returned for a Usage Minimum without a Usage
+	// Maximum (or the reverse), a minimum greater than its maximum, or an
+	// unbalanced Delimiter.
+	ErrUsageRange = errors.New("invalid usage range")
+
+	// ErrTooManyReports is returned when a descriptor declares more than
+	// MaxReports reports.
+	ErrTooManyReports = errors.New("too many reports")
+)
+
+// DescriptorError describes an invalid report descriptor.
+type DescriptorError struct {
+	Offset int   // Byte offset of the offending item
+	Err    error // One of the Err* validation errors
+}
+
+// Error returns the error message.
+func (e *DescriptorError) Error() string {
+	return "report descriptor offset " + strconv.Itoa(e.Offset) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying validation error.
+func (e *DescriptorError) Unwrap() error {
+	return e.Err
+}
+
+// Report descriptor limits.
+const (
+	MaxReports        = 32      // Reports described by a ReportLayout
+	MaxItemStackDepth = 16      // Nested Push items
+	MaxReportBits     = 1 << 16 // Size of a single report in bits
+)
+
+// Item types (bits 3-2 of the item prefix).
+const (
+	itemTypeMain   = 0x00
+	itemTypeGlobal = 0x01
+	itemTypeLocal  = 0x02
+)
+
+// Main item tags.
+const (
+	tagInput         = 0x08
+	tagOutput        = 0x09
+	tagCollection    = 0x0A
+	tagFeature       = 0x0B
+	tagEndCollection = 0x0C
+)
+
+// Global item tags.
+const (
+	tagUsagePage       = 0x00
+	tagLogicalMinimum  = 0x01
+	tagLogicalMaximum  = 0x02
+	tagPhysicalMinimum = 0x03
+	tagPhysicalMaximum = 0x04
+	tagUnitExponent    = 0x05
+	tagUnit            = 0x06
+	tagReportSize      = 0x07
+	tagReportID        = 0x08
+	tagReportCount     = 0x09
+	tagPush            = 0x0A
+	tagPop             = 0x0B
+)
+
+// Local item tags.
+const (
+	tagUsage        = 0x00
+	tagUsageMinimum = 0x01
+	tagUsageMaximum = 0x02
+	tagStringIndex  = 0x07
+	tagDelimiter    = 0x0A
+)
+
+// Item prefix encoding.
+const (
+	itemPrefixLong   = 0xFE // Prefix of a long item
+	itemSizeMask     = 0x03 // Data size bits of a short item prefix
+	itemSizeFourByte = 0x03 // Data size code for 4 bytes
+)
+
+// ReportDescriptorBuilder provides a fluent API for building report
+// descriptors. Each method appends one item in its shortest encoding;
+// Build validates the result.
+//
+//	desc, err := hid.NewReportDescriptorBuilder().
+//	    UsagePage(hid.UsagePageGenericDesktop).
+//	    Usage(hid.UsageMouse).
+//	    Collection(hid.CollectionApplication).
+//	    // ...
+//	    EndCollection().
+//	    Build()
+type ReportDescriptorBuilder struct {
+	buf []byte
+}
+
+// NewReportDescriptorBuilder creates a new report descriptor builder.
+func NewReportDescriptorBuilder() *ReportDescriptorBuilder {
+	return &ReportDescriptorBuilder{}
+}
+
+// UsagePage appends a Usage Page item.
+func (b *ReportDescriptorBuilder) UsagePage(page uint16) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeGlobal, tagUsagePage, uint32(page))
+}
+
+// LogicalMinimum appends a Logical Minimum item.
+func (b *ReportDescriptorBuilder) LogicalMinimum(v int32) *ReportDescriptorBuilder {
+	return b.signed(itemTypeGlobal, tagLogicalMinimum, v)
+}
+
+// LogicalMaximum appends a Logical Maximum item.
+func (b *ReportDescriptorBuilder) LogicalMaximum(v int32) *ReportDescriptorBuilder {
+	return b.signed(itemTypeGlobal, tagLogicalMaximum, v)
+}
+
+// PhysicalMinimum appends a Physical Minimum item.
+func (b *ReportDescriptorBuilder) PhysicalMinimum(v int32) *ReportDescriptorBuilder {
+	return b.signed(itemTypeGlobal, tagPhysicalMinimum, v)
+}
+
+// PhysicalMaximum appends a Physical Maximum item.
+func (b *ReportDescriptorBuilder) PhysicalMaximum(v int32) *ReportDescriptorBuilder {
+	return b.signed(itemTypeGlobal, tagPhysicalMaximum, v)
+}
+
+// UnitExponent appends a Unit Exponent item. exp must be in the range -8
+// to 7.
+func (b *ReportDescriptorBuilder) UnitExponent(exp int8) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeGlobal, tagUnitExponent, uint32(exp)&0x0F)
+}
+
+// Unit appends a Unit item.
+func (b *ReportDescriptorBuilder) Unit(unit uint32) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeGlobal, tagUnit, unit)
+}
+
+// ReportSize appends a Report Size item: the size of each field element in
+// bits.
+func (b *ReportDescriptorBuilder) ReportSize(bits uint32) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeGlobal, tagReportSize, bits)
+}
+
+// ReportCount appends a Report Count item: the number of field elements.
+func (b *ReportDescriptorBuilder) ReportCount(count uint32) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeGlobal, tagReportCount, count)
+}
+
+// ReportID appends a Report ID item. Reports that follow are prefixed with
+// id, which must not be 0.
+func (b *ReportDescriptorBuilder) ReportID(id uint8) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeGlobal, tagReportID, uint32(id))
+}
+
+// Push appends a Push item, saving the global item state.
+func (b *ReportDescriptorBuilder) Push() *ReportDescriptorBuilder {
+	return b.empty(itemTypeGlobal, tagPush)
+}
+
+// Pop appends a Pop item, restoring the global item state saved by Push.
+func (b *ReportDescriptorBuilder) Pop() *ReportDescriptorBuilder {
+	return b.empty(itemTypeGlobal, tagPop)
+}
+
+// Usage appends a Usage item on the current usage page.
+func (b *ReportDescriptorBuilder) Usage(id uint16) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeLocal, tagUsage, uint32(id))
+}
+
+// ExtendedUsage appends a Usage item that names its usage page.
+func (b *ReportDescriptorBuilder) ExtendedUsage(page, id uint16) *ReportDescriptorBuilder {
+	return b.item(itemTypeLocal, tagUsage, uint32(page)<<16|uint32(id), 4)
+}
+
+// UsageMinimum appends a Usage Minimum item.
+func (b *ReportDescriptorBuilder) UsageMinimum(id uint16) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeLocal, tagUsageMinimum, uint32(id))
+}
+
+// UsageMaximum appends a Usage Maximum item.
+func (b *ReportDescriptorBuilder) UsageMaximum(id uint16) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeLocal, tagUsageMaximum, uint32(id))
+}
+
+// StringIndex appends a String Index item.
+func (b *ReportDescriptorBuilder) StringIndex(index uint8) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeLocal, tagStringIndex, uint32(index))
+}
+
+// Collection appends a Collection item (see Collection* types). Each
+// Collection must be closed with EndCollection.
+func (b *ReportDescriptorBuilder) Collection(kind uint8) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeMain, tagCollection, uint32(kind))
+}
+
+// EndCollection appends an End Collection item.
+func (b *ReportDescriptorBuilder) EndCollection() *ReportDescriptorBuilder {
+	return b.empty(itemTypeMain, tagEndCollection)
+}
+
+// Input appends an Input item with the given Flag* bits.
+func (b *ReportDescriptorBuilder) Input(flags uint16) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeMain, tagInput, uint32(flags))
+}
+
+// Output appends an Output item with the given Flag* bits.
+func (b *ReportDescriptorBuilder) Output(flags uint16) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeMain, tagOutput, uint32(flags))
+}
+
+// Feature appends a Feature item with the given Flag* bits.
+func (b *ReportDescriptorBuilder) Feature(flags uint16) *ReportDescriptorBuilder {
+	return b.unsigned(itemTypeMain, tagFeature, uint32(flags))
+}
+
+// Bytes returns the report descriptor built so far without validating it.
+func (b *ReportDescriptorBuilder) Bytes() []byte {
+	return b.buf
+}
+
+// Build validates the report descriptor and returns it.
+// Returns a *DescriptorError if the descriptor is invalid.
+func (b *ReportDescriptorBuilder) Build() ([]byte, error) {
+	if err := ValidateReportDescriptor(b.buf, nil); err != nil {
+		return nil, err
+	}
+	return b.buf, nil
+}
+
+// empty appends an item without data.
+func (b *ReportDescriptorBuilder) empty(typ, tag uint8) *ReportDescriptorBuilder {
+	b.buf = append(b.buf, tag<<4|typ<<2)
+	return b
+}
+
+// unsigned appends an item with the shortest encoding of v.
+func (b *ReportDescriptorBuilder) unsigned(typ, tag uint8, v uint32) *ReportDescriptorBuilder {
+	switch {
+	case v <= 0xFF:
+		return b.item(typ, tag, v, 1)
+	case v <= 0xFFFF:
+		return b.item(typ, tag, v, 2)
+	default:
+		return b.item(typ, tag, v, 4)
+	}
+}
+
+// signed appends an item with the shortest two's complement encoding of v.
+func (b *ReportDescriptorBuilder) signed(typ, tag uint8, v int32) *ReportDescriptorBuilder {
+	switch {
+	case v >= -0x80 && v <= 0x7F:
+		return b.item(typ, tag, uint32(v), 1)
+	case v >= -0x8000 && v <= 0x7FFF:
+		return b.item(typ, tag, uint32(v), 2)
+	default:
+		return b.item(typ, tag, uint32(v), 4)
+	}
+}
+
+// item appends an item with size (1, 2, or 4) bytes of little-endian data.
+func (b *ReportDescriptorBuilder) item(typ, tag uint8, v uint32, size int) *ReportDescriptorBuilder {
+	code := uint8(size)
+	if size == 4 {
+		code = itemSizeFourByte
+	}
+	b.buf = append(b.buf, tag<<4|typ<<2|code)
+	for i := 0; i < size; i++ {
+		b.buf = append(b.buf, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// ReportInfo describes one report declared by a report descriptor.
+type ReportInfo struct {
+	ID   uint8 // Report ID, or 0 if the descriptor uses none
+	Type uint8 // ReportTypeInput, ReportTypeOutput, or ReportTypeFeature
+	Bits int   // Size of the report data in bits, excluding the report ID
+}
+
+// Length returns the size of the report in bytes, including the report ID
+// if there is one.
+func (r *ReportInfo) Length() int {
+	n := (r.Bits + 7) / 8
+	if r.ID != 0 {
+		n++
+	}
+	return n
+}
+
+// ReportLayout lists the reports declared by a report descriptor.
+type ReportLayout struct {
+	Reports    [MaxReports]ReportInfo
+	NumReports int
+	ReportIDs  bool // Reports are prefixed with a report ID
+}
+
+// Report returns the report of the given type and ID. id is 0 if the
+// descriptor uses no report IDs.
+// Returns false if the descriptor declares no such report.
+func (l *ReportLayout) Report(reportType, id uint8) (ReportInfo, bool) {
+	for i := 0; i < l.NumReports; i++ {
+		if l.Reports[i].Type == reportType && l.Reports[i].ID == id {
+			return l.Reports[i], true
+		}
+	}
+	return ReportInfo{}, false
+}
+
+// Length returns the size in bytes of the report of the given type and ID,
+// including the report ID, or 0 if the descriptor declares no such report.
+func (l *ReportLayout) Length(reportType, id uint8) int {
+	r, ok := l.Report(reportType, id)
+	if !ok {
+		return 0
+	}
+	return r.Length()
+}
+
+// validatorState holds the global items tracked by the validator.
+type validatorState struct {
+	logicalMin  int32
+	logicalMax  int32
+	logicalMaxU uint32
+	reportSize  uint32
+	reportCount uint32
+	reportID    uint8
+}
+
+// ValidateReportDescriptor checks the structure of a report descriptor and,
+// if out is not nil, stores the layout of its reports in out. It checks
+// collection nesting, Push/Pop balance, usage ranges, report ID
+// consistency, report sizes, and that each logical range fits its field.
+// Returns a *DescriptorError wrapping one of the Err* validation errors if
+// the descriptor is invalid.
+func ValidateReportDescriptor(data []byte, out *ReportLayout) error {
+	var layout ReportLayout
+	var state validatorState
+	var stack [MaxItemStackDepth]validatorState
+	depth := 0   // Push depth
+	nesting := 0 // Collection depth
+	delimiter := false
+	usageMin, usageMax := int64(-1), int64(-1) // Pending usage range
+	idOffset, noIDOffset := -1, -1
+
+	fail := func(offset int, err error) error {
+		return &DescriptorError{Offset: offset, Err: err}
+	}
+
+	off := 0
+	for off < len(data) {
+		prefix := data[off]
+		if prefix == itemPrefixLong {
+			if off+3 > len(data) || off+3+int(data[off+1]) > len(data) {
+				return fail(off, ErrItemTruncated)
+			}
+			off += 3 + int(data[off+1])
+			continue
+		}
+
+		size := int(prefix & itemSizeMask)
+		if size == itemSizeFourByte {
+			size = 4
+		}
+		if off+1+size > len(data) {
+			return fail(off, ErrItemTruncated)
+		}
+		item := data[off+1 : off+1+size]
+		value := itemUnsigned(item)
+		typ := (prefix >> 2) & 0x03
+		tag := prefix >> 4
+
+		switch typ {
+		case itemTypeMain:
+			switch tag {
+			case tagInput, tagOutput, tagFeature:
+				if nesting == 0 {
+					return fail(off, ErrCollectionNesting)
+				}
+				if err := checkUsageRange(usageMin, usageMax); err != nil {
+					return fail(off, err)
+				}
+				if err := checkField(&state, value&FlagConstant != 0); err != nil {
+					return fail(off, err)
+				}
+				if state.reportID == 0 && noIDOffset < 0 {
+					noIDOffset = off
+				}
+				reportType := uint8(ReportTypeInput)
+				if tag == tagOutput {
+					reportType = ReportTypeOutput
+				} else if tag == tagFeature {
+					reportType = ReportTypeFeature
+				}
+				if err := layout.add(reportType, state.reportID, int(state.reportSize*state.reportCount)); err != nil {
+					return fail(off, err)
+				}
+
+			case tagCollection:
+				if nesting == 0 && value != CollectionApplication {
+					return fail(off, ErrCollectionNesting)
+				}
+				if err := checkUsageRange(usageMin, usageMax); err != nil {
+					return fail(off, err)
+				}
+				nesting++
+
+			case tagEndCollection:
+				if nesting == 0 {
+					return fail(off, ErrCollectionNesting)
+				}
+				nesting--
+			}
+			if delimiter {
+				return fail(off, ErrUsageRange)
+			}
+			usageMin, usageMax = -1, -1
+
+		case itemTypeGlobal:
+			switch tag {
+			case tagLogicalMinimum:
+				state.logicalMin = itemSigned(item)
+			case tagLogicalMaximum:
+				state.logicalMax = itemSigned(item)
+				state.logicalMaxU = value
+			case tagReportSize:
+				state.reportSize = value
+			case tagReportCount:
+				state.reportCount = value
+			case tagReportID:
+				if value == 0 || value > 0xFF {
+					return fail(off, ErrReportID)
+				}
+				state.reportID = uint8(value)
+				if idOffset < 0 {
+					idOffset = off
+				}
+			case tagPush:
+				if depth == MaxItemStackDepth {
+					return fail(off, ErrItemStack)
+				}
+				stack[depth] = state
+				depth++
+			case tagPop:
+				if depth == 0 {
+					return fail(off, ErrItemStack)
+				}
+				depth--
+				state = stack[depth]
+			}
+
+		case itemTypeLocal:
+			switch tag {
+			case tagUsageMinimum:
+				usageMin = int64(value)
+			case tagUsageMaximum:
+				usageMax = int64(value)
+			case tagDelimiter:
+				open := value == 1
+				if open == delimiter {
+					return fail(off, ErrUsageRange)
+				}
+				delimiter = open
+			}
+		}
+
+		off += 1 + size
+	}
+
+	switch {
+	case nesting != 0:
+		return fail(len(data), ErrCollectionNesting)
+	case depth != 0:
+		return fail(len(data), ErrItemStack)
+	case delimiter:
+		return fail(len(data), ErrUsageRange)
+	case idOffset >= 0 && noIDOffset >= 0:
+		return fail(max(idOffset, noIDOffset), ErrReportID)
+	}
+
+	layout.ReportIDs = idOffset >= 0
+	if out != nil {
+		*out = layout
+	}
+	return nil
+}
+
+// add accumulates bits into the report of the given type and ID.
+func (l *ReportLayout) add(reportType, id uint8, bits int) error {
+	for i := 0; i < l.NumReports; i++ {
+		r := &l.Reports[i]
+		if r.Type == reportType && r.ID == id {
+			if r.Bits+bits > MaxReportBits {
+				return ErrReportSize
+			}
+			r.Bits += bits
+			return nil
+		}
+	}
+	if l.NumReports == MaxReports {
+		return ErrTooManyReports
+	}
+	l.Reports[l.NumReports] = ReportInfo{ID: id, Type: reportType, Bits: bits}
+	l.NumReports++
+	return nil
+}
+
+// checkField checks the report size, report count, and logical range of an
+// Input, Output, or Feature item.
+func checkField(s *validatorState, constant bool) error {
+	if s.reportSize == 0 || s.reportCount == 0 ||
+		uint64(s.reportSize)*uint64(s.reportCount) > MaxReportBits {
+		return ErrReportSize
+	}
+	if constant {
+		return nil
+	}
+	if s.reportSize > 32 {
+		return ErrReportSize
+	}
+
+	lo := int64(s.logicalMin)
+	hi := int64(s.logicalMax)
+	if lo >= 0 && hi < lo {
+		// A maximum with its sign bit set is unsigned when the minimum is not
+		// negative (such as 0x25 0xFF for 255)
+		hi = int64(s.logicalMaxU)
+	}
+	if lo > hi {
+		return ErrLogicalRange
+	}
+
+	bits := s.reportSize
+	if lo < 0 {
+		if lo < -(1<<(bits-1)) || hi > 1<<(bits-1)-1 {
+			return ErrLogicalRange
+		}
+	} else if hi > 1<<bits-1 {
+		return ErrLogicalRange
+	}
+	return nil
+}
+
+// checkUsageRange checks that a pending Usage Minimum and Usage Maximum
+// (-1 if absent) form a complete range.
+func checkUsageRange(lo, hi int64) error {
+	if (lo < 0) != (hi < 0) || lo > hi {
+		return ErrUsageRange
+	}
+	return nil
+}
+
+// itemUnsigned returns item data as an unsigned little-endian value.
+func itemUnsigned(data []byte) uint32 {
+	var v uint32
+	for i := len(data) - 1; i >= 0; i-- {
+		v = v<<8 | uint32(data[i])
+	}
+	return v
+}
+
+// itemSigned returns item data as a sign-extended little-endian value.
+func itemSigned(data []byte) int32 {
+	switch len(data) {
+	case 1:
+		return int32(int8(data[0]))
+	case 2:
+		return int32(int16(uint16(data[0]) | uint16(data[1])<<8))
+	default:
+		return int32(itemUnsigned(data))
+	}
+}
@@ -0,0 +1,251 @@
+package hid
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testApplication wraps items in an Application collection.
+func testApplication(items ...byte) []byte {
+	out := []byte{0x05, 0x01, 0x09, 0x00, 0xA1, 0x01}
+	out = append(out, items...)
+	return append(out, 0xC0)
+}
+
+// testField is one 8-bit variable input field.
+var testField = []byte{
+	0x15, 0x00, // Logical Minimum (0)
+	0x26, 0xFF, 0x00, // Logical Maximum (255)
+	0x75, 0x08, // Report Size (8)
+	0x95, 0x01, // Report Count (1)
+	0x81, 0x02, // Input (Data, Variable, Absolute)
+}
+
+func TestValidateReportDescriptorLayouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		desc    []byte
+		ids     bool
+		reports []ReportInfo
+	}{
+		{
+			name: "keyboard",
+			desc: KeyboardReportDescriptor,
+			reports: []ReportInfo{
+				{Type: ReportTypeInput, Bits: 8 * KeyboardReportSize},
+				{Type: ReportTypeOutput, Bits: 8},
+			},
+		},
+		{
+			name:    "mouse",
+			desc:    MouseReportDescriptor,
+			reports: []ReportInfo{{Type: ReportTypeInput, Bits: 8 * MouseReportSize}},
+		},
+		{
+			name: "composite",
+			desc: CompositeReportDescriptor,
+			ids:  true,
+			reports: []ReportInfo{
+				{ID: ReportIDKeyboard, Type: ReportTypeInput, Bits: 8 * KeyboardReportSize},
+				{ID: ReportIDKeyboard, Type: ReportTypeOutput, Bits: 8},
+				{ID: ReportIDMouse, Type: ReportTypeInput, Bits: 8 * MouseReportSize},
+				{ID: ReportIDConsumer, Type: ReportTypeInput, Bits: 8 * ConsumerReportSize},
+				{ID: ReportIDSystemControl, Type: ReportTypeInput, Bits: 8 * SystemControlReportSize},
+			},
+		},
+		{
+			name: "gamepad",
+			desc: GamepadReportDescriptor,
+			reports: []ReportInfo{
+				{Type: ReportTypeInput, Bits: 8 * GamepadReportSize},
+				{Type: ReportTypeOutput, Bits: 8 * RumbleReportSize},
+			},
+		},
+		{
+			name:    "joystick",
+			desc:    JoystickReportDescriptor,
+			reports: []ReportInfo{{Type: ReportTypeInput, Bits: 8 * JoystickReportSize}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layout ReportLayout
+			if err := ValidateReportDescriptor(tt.desc, &layout); err != nil {
+				t.Fatalf("ValidateReportDescriptor() error = %v", err)
+			}
+			if layout.ReportIDs != tt.ids || layout.NumReports != len(tt.reports) {
+				t.Fatalf("layout = %d reports, IDs %v, want %d reports, IDs %v",
+					layout.NumReports, layout.ReportIDs, len(tt.reports), tt.ids)
+			}
+			for i, want := range tt.reports {
+				if layout.Reports[i] != want {
+					t.Errorf("report %d = %+v, want %+v", i, layout.Reports[i], want)
+				}
+				wantLength := want.Bits / 8
+				if tt.ids {
+					wantLength++
+				}
+				if n := layout.Length(want.Type, want.ID); n != wantLength {
+					t.Errorf("Length(%d, %d) = %d, want %d", want.Type, want.ID, n, wantLength)
+				}
+			}
+			if _, ok := layout.Report(ReportTypeFeature, 0); ok {
+				t.Error("Report() found an undeclared feature report")
+			}
+			if n := layout.Length(ReportTypeInput, 0xFF); n != 0 {
+				t.Errorf("Length(undeclared) = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestReportInfoLength(t *testing.T) {
+	tests := []struct {
+		info ReportInfo
+		want int
+	}{
+		{ReportInfo{Bits: 1}, 1},
+		{ReportInfo{Bits: 8}, 1},
+		{ReportInfo{Bits: 9}, 2},
+		{ReportInfo{ID: 1, Bits: 4}, 2},
+		{ReportInfo{ID: 1, Bits: 16}, 3},
+	}
+	for _, tt := range tests {
+		if n := tt.info.Length(); n != tt.want {
+			t.Errorf("%+v Length() = %d, want %d", tt.info, n, tt.want)
+		}
+	}
+}
+
+func TestReportDescriptorBuilder(t *testing.T) {
+	// The builder reproduces the mouse descriptor item for item
+	desc, err := NewReportDescriptorBuilder().
+		UsagePage(UsagePageGenericDesktop).
+		Usage(UsageMouse).
+		Collection(CollectionApplication).
+		Usage(UsagePointer).
+		Collection(CollectionPhysical).
+		UsagePage(UsagePageButton).
+		UsageMinimum(1).
+		UsageMaximum(3).
+		LogicalMinimum(0).
+		LogicalMaximum(1).
+		ReportCount(3).
+		ReportSize(1).
+		Input(FlagVariable).
+		ReportCount(1).
+		ReportSize(5).
+		Input(FlagConstant).
+		UsagePage(UsagePageGenericDesktop).
+		Usage(UsageX).
+		Usage(UsageY).
+		Usage(UsageWheel).
+		LogicalMinimum(-127).
+		LogicalMaximum(127).
+		ReportSize(8).
+		ReportCount(3).
+		Input(FlagVariable | FlagRelative).
+		EndCollection().
+		EndCollection().
+		Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if !bytes.Equal(desc, MouseReportDescriptor) {
+		t.Errorf("Build() = % X\nwant % X", desc, MouseReportDescriptor)
+	}
+
+	// Items use the shortest encoding of their value
+	encodings := []struct {
+		name string
+		b    *ReportDescriptorBuilder
+		want []byte
+	}{
+		{"unsigned byte", NewReportDescriptorBuilder().LogicalMaximum(127), []byte{0x25, 0x7F}},
+		{"unsigned word", NewReportDescriptorBuilder().LogicalMaximum(255), []byte{0x26, 0xFF, 0x00}},
+		{"negative byte", NewReportDescriptorBuilder().LogicalMinimum(-128), []byte{0x15, 0x80}},
+		{"negative word", NewReportDescriptorBuilder().LogicalMinimum(-32767), []byte{0x16, 0x01, 0x80}},
+		{"negative dword", NewReportDescriptorBuilder().PhysicalMinimum(-32769), []byte{0x37, 0xFF, 0x7F, 0xFF, 0xFF}},
+		{"large count", NewReportDescriptorBuilder().ReportCount(0x10000), []byte{0x97, 0x00, 0x00, 0x01, 0x00}},
+		{"unit exponent", NewReportDescriptorBuilder().UnitExponent(-2), []byte{0x55, 0x0E}},
+		{"extended usage", NewReportDescriptorBuilder().ExtendedUsage(UsagePageButton, 1), []byte{0x0B, 0x01, 0x00, 0x09, 0x00}},
+		{"push and pop", NewReportDescriptorBuilder().Push().Pop(), []byte{0xA4, 0xB4}},
+		{"report ID", NewReportDescriptorBuilder().ReportID(2), []byte{0x85, 0x02}},
+		{"feature", NewReportDescriptorBuilder().Feature(FlagVariable | FlagVolatile), []byte{0xB1, 0x82}},
+		{"buffered bytes", NewReportDescriptorBuilder().Output(FlagBufferedBytes), []byte{0x92, 0x00, 0x01}},
+	}
+	for _, tt := range encodings {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.Bytes(); !bytes.Equal(got, tt.want) {
+				t.Errorf("Bytes() = % X, want % X", got, tt.want)
+			}
+		})
+	}
+
+	// Build rejects what the validator rejects
+	b := NewReportDescriptorBuilder().
+		UsagePage(UsagePageGenericDesktop).
+		Collection(CollectionApplication)
+	desc, err = b.Build()
+	if !errors.Is(err, ErrCollectionNesting) || desc != nil {
+		t.Errorf("Build(unclosed) = % X, %v, want %v", desc, err, ErrCollectionNesting)
+	}
+}
+
+func TestValidateReportDescriptorErrors(t *testing.T) {
+	// More than MaxReports input reports, each with its own ID
+	var tooMany []byte
+	for id := 1; id <= MaxReports+1; id++ {
+		tooMany = append(tooMany, 0x85, byte(id))
+		tooMany = append(tooMany, testField...)
+	}
+
+	tests := []struct {
+		name   string
+		desc   []byte
+		err    error
+		offset int
+	}{
+		{"end without collection", []byte{0xC0}, ErrCollectionNesting, 0},
+		{"unclosed collection", []byte{0x05, 0x01, 0xA1, 0x01}, ErrCollectionNesting, 4},
+		{"top-level physical collection", []byte{0xA1, 0x00, 0xC0}, ErrCollectionNesting, 0},
+		{"input outside collection", testField, ErrCollectionNesting, 9},
+		{"report ID 0", testApplication(0x85, 0x00), ErrReportID, 6},
+		{"report ID above 255", testApplication(0x86, 0x00, 0x01), ErrReportID, 6},
+		{"reports with and without IDs", testApplication(append(append([]byte{}, testField...),
+			append([]byte{0x85, 0x01}, testField...)...)...), ErrReportID, 17},
+		{"too many reports", testApplication(tooMany...), ErrTooManyReports, 6 + MaxReports*13 + 11},
+		{"truncated item", []byte{0x05, 0x01, 0x26, 0xFF}, ErrItemTruncated, 2},
+		{"truncated four-byte item", []byte{0x27, 0x00, 0x00, 0x01}, ErrItemTruncated, 0},
+		{"truncated long item", []byte{0xFE, 0x04, 0x00, 0x00}, ErrItemTruncated, 0},
+		{"pop without push", []byte{0xB4}, ErrItemStack, 0},
+		{"push without pop", []byte{0xA4}, ErrItemStack, 1},
+		{"usage minimum only", testApplication(0x19, 0x01, 0x75, 0x01, 0x95, 0x01, 0x25, 0x01, 0x81, 0x02), ErrUsageRange, 14},
+		{"report size 0", testApplication(0x95, 0x01, 0x81, 0x01), ErrReportSize, 8},
+		{"field above 32 bits", testApplication(0x75, 0x21, 0x95, 0x01, 0x81, 0x02), ErrReportSize, 10},
+		{"logical range too wide", testApplication(0x25, 0x7F, 0x75, 0x04, 0x95, 0x01, 0x81, 0x02), ErrLogicalRange, 12},
+		{"logical minimum above maximum", testApplication(0x15, 0x05, 0x25, 0x01, 0x75, 0x04, 0x95, 0x01, 0x81, 0x02), ErrLogicalRange, 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReportDescriptor(tt.desc, nil)
+			var de *DescriptorError
+			if !errors.As(err, &de) || !errors.Is(err, tt.err) {
+				t.Fatalf("ValidateReportDescriptor() error = %v, want %v", err, tt.err)
+			}
+			if de.Offset != tt.offset {
+				t.Errorf("offset = %d, want %d", de.Offset, tt.offset)
+			}
+		})
+	}
+
+	// A failed validation leaves the layout unchanged
+	layout := ReportLayout{NumReports: 1}
+	ValidateReportDescriptor([]byte{0xC0}, &layout)
+	if layout.NumReports != 1 {
+		t.Error("ValidateReportDescriptor() changed the layout on error")
+	}
+}
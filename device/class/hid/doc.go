@@ -55,13 +55,51 @@
 //	// Send keyboard reports
 //	keyboard.SendReport(ctx, keyboardReport)
 //
+// # Typing Text
+//
+// [Typist] types strings as key press and release reports using a
+// keyboard [Layout] (LayoutUS, LayoutUK, LayoutDE, or a custom [Keymap]):
+//
+//	typist := hid.NewTypist(keyboard, hid.LayoutUS)
+//	typist.Type(ctx, "Hello, World!\n")
+//
+// Reports are paced to the polling interval of the keyboard endpoint, and
+// letters follow the host's Caps Lock state reported by [HID.LEDs].
+//
 // # Report Descriptors
 //
 // The package includes common report descriptors:
 //
 //   - KeyboardReportDescriptor: Standard 8-byte keyboard report
 //   - MouseReportDescriptor: Standard 4-byte mouse report (3 buttons, X/Y/wheel)
-//
-// Custom report descriptors can be created using the HID report descriptor
-// specification and passed to [New].
+//   - GamepadReportDescriptor: 16 buttons, hat switch, two sticks, two
+//     triggers, and a rumble output report (see [ParseRumbleReport])
+//   - JoystickReportDescriptor: Flight stick with 16-bit X/Y, twist,
+//     throttle, hat switch, and 8 buttons
+//   - CompositeReportDescriptor: Keyboard, mouse, consumer control, and
+//     system control on one interface, distinguished by report ID
+//
+// With a composite descriptor, the typed Send*Report methods prepend the
+// report ID (ReportID*), and [HID.SendReportID] sends any payload with a
+// given report ID. GET_REPORT is answered per report type and ID from the
+// last report sent, received, or set with [HID.SetReport].
+//
+// Custom report descriptors can be built with [ReportDescriptorBuilder],
+// which emits typed items in their shortest encoding:
+//
+//	desc, err := hid.NewReportDescriptorBuilder().
+//	    UsagePage(hid.UsagePageGenericDesktop).
+//	    Usage(hid.UsageJoystick).
+//	    Collection(hid.CollectionApplication).
+//	    Usage(hid.UsageX).Usage(hid.UsageY).
+//	    LogicalMinimum(-127).LogicalMaximum(127).
+//	    ReportSize(8).ReportCount(2).
+//	    Input(hid.FlagVariable).
+//	    EndCollection().
+//	    Build()
+//
+// [ValidateReportDescriptor] checks collection nesting, Push/Pop balance,
+// report ID consistency, and logical ranges, and computes the length of
+// each report. [New] validates its descriptor, and [HID.SendReport]
+// rejects input reports whose length does not match it.
 package hid
@@ -0,0 +1,308 @@
+package hid
+
+// Simulation Controls page usages.
+const (
+	UsageThrottle = 0xBB
+)
+
+// Physical Interface Device (force feedback) page usages.
+const (
+	UsageSetEffectReport = 0x21
+	UsageDuration        = 0x50
+	UsageMagnitude       = 0x70
+)
+
+// Hat switch directions, clockwise from up. HatCentered is the null
+// state, so the zero value of a report has the hat centered.
+const (
+	HatCentered  = 0
+	HatUp        = 1
+	HatUpRight   = 2
+	HatRight     = 3
+	HatDownRight = 4
+	HatDown      = 5
+	HatDownLeft  = 6
+	HatLeft      = 7
+	HatUpLeft    = 8
+)
+
+// Gamepad button numbers (Button page usages) in the conventional order
+// used by GamepadReportDescriptor.
+const (
+	GamepadButtonA      = 1
+	GamepadButtonB      = 2
+	GamepadButtonX      = 3
+	GamepadButtonY      = 4
+	GamepadButtonL1     = 5
+	GamepadButtonR1     = 6
+	GamepadButtonL2     = 7
+	GamepadButtonR2     = 8
+	GamepadButtonSelect = 9
+	GamepadButtonStart  = 10
+	GamepadButtonL3     = 11
+	GamepadButtonR3     = 12
+	GamepadButtonHome   = 13
+)
+
+// GamepadReportDescriptor is a gamepad report descriptor with 16 buttons,
+// a hat switch, two analog sticks, two analog triggers, and a rumble
+// (force feedback) output report.
+// Input report (9 bytes): [buttons low, buttons high, hat, X, Y, Z, Rz, Rx, Ry]
+// Output report (3 bytes): [left motor, right motor, duration]
+var GamepadReportDescriptor = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x05, // Usage (Gamepad)
+	0xA1, 0x01, // Collection (Application)
+	0x05, 0x09, //   Usage Page (Button)
+	0x19, 0x01, //   Usage Minimum (Button 1)
+	0x29, 0x10, //   Usage Maximum (Button 16)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x75, 0x01, //   Report Size (1)
+	0x95, 0x10, //   Report Count (16)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - Buttons
+	0x05, 0x01, //   Usage Page (Generic Desktop)
+	0xA4,       //   Push
+	0x09, 0x39, //   Usage (Hat Switch)
+	0x15, 0x01, //   Logical Minimum (1)
+	0x25, 0x08, //   Logical Maximum (8)
+	0x46, 0x3B, 0x01, // Physical Maximum (315)
+	0x65, 0x14, //   Unit (Degrees)
+	0x75, 0x04, //   Report Size (4)
+	0x95, 0x01, //   Report Count (1)
+	0x81, 0x42, //   Input (Data, Variable, Absolute, Null State) - Hat
+	0xB4,       //   Pop
+	0x75, 0x04, //   Report Size (4)
+	0x95, 0x01, //   Report Count (1)
+	0x81, 0x01, //   Input (Constant) - Padding
+	0x09, 0x30, //   Usage (X)
+	0x09, 0x31, //   Usage (Y)
+	0x09, 0x32, //   Usage (Z)
+	0x09, 0x35, //   Usage (Rz)
+	0x15, 0x81, //   Logical Minimum (-127)
+	0x25, 0x7F, //   Logical Maximum (127)
+	0x75, 0x08, //   Report Size (8)
+	0x95, 0x04, //   Report Count (4)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - Sticks
+	0x09, 0x33, //   Usage (Rx)
+	0x09, 0x34, //   Usage (Ry)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x26, 0xFF, 0x00, // Logical Maximum (255)
+	0x95, 0x02, //   Report Count (2)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - Triggers
+	0x05, 0x0F, //   Usage Page (Physical Interface Device)
+	0x09, 0x21, //   Usage (Set Effect Report)
+	0xA1, 0x02, //   Collection (Logical)
+	0x09, 0x70, //     Usage (Magnitude) - Left motor
+	0x09, 0x70, //     Usage (Magnitude) - Right motor
+	0x09, 0x50, //     Usage (Duration)
+	0x95, 0x03, //     Report Count (3)
+	0x91, 0x02, //     Output (Data, Variable, Absolute) - Rumble
+	0xC0, //   End Collection
+	0xC0, // End Collection
+}
+
+// JoystickReportDescriptor is a flight stick report descriptor with
+// 16-bit X/Y axes, a twist axis, a throttle, a hat switch, and 8 buttons.
+// Input report (8 bytes): [X low, X high, Y low, Y high, Rz, throttle, hat, buttons]
+var JoystickReportDescriptor = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x04, // Usage (Joystick)
+	0xA1, 0x01, // Collection (Application)
+	0x09, 0x01, //   Usage (Pointer)
+	0xA1, 0x00, //   Collection (Physical)
+	0x09, 0x30, //     Usage (X)
+	0x09, 0x31, //     Usage (Y)
+	0x16, 0x01, 0x80, // Logical Minimum (-32767)
+	0x26, 0xFF, 0x7F, // Logical Maximum (32767)
+	0x75, 0x10, //     Report Size (16)
+	0x95, 0x02, //     Report Count (2)
+	0x81, 0x02, //     Input (Data, Variable, Absolute) - X, Y
+	0x09, 0x35, //     Usage (Rz)
+	0x15, 0x81, //     Logical Minimum (-127)
+	0x25, 0x7F, //     Logical Maximum (127)
+	0x75, 0x08, //     Report Size (8)
+	0x95, 0x01, //     Report Count (1)
+	0x81, 0x02, //     Input (Data, Variable, Absolute) - Twist
+	0xC0,       //   End Collection
+	0x05, 0x02, //   Usage Page (Simulation Controls)
+	0x09, 0xBB, //   Usage (Throttle)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x26, 0xFF, 0x00, // Logical Maximum (255)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - Throttle
+	0x05, 0x01, //   Usage Page (Generic Desktop)
+	0xA4,       //   Push
+	0x09, 0x39, //   Usage (Hat Switch)
+	0x15, 0x01, //   Logical Minimum (1)
+	0x25, 0x08, //   Logical Maximum (8)
+	0x46, 0x3B, 0x01, // Physical Maximum (315)
+	0x65, 0x14, //   Unit (Degrees)
+	0x75, 0x04, //   Report Size (4)
+	0x81, 0x42, //   Input (Data, Variable, Absolute, Null State) - Hat
+	0xB4,       //   Pop
+	0x75, 0x04, //   Report Size (4)
+	0x81, 0x01, //   Input (Constant) - Padding
+	0x05, 0x09, //   Usage Page (Button)
+	0x19, 0x01, //   Usage Minimum (Button 1)
+	0x29, 0x08, //   Usage Maximum (Button 8)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x75, 0x01, //   Report Size (1)
+	0x95, 0x08, //   Report Count (8)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - Buttons
+	0xC0, // End Collection
+}
+
+// GamepadReport is a 9-byte gamepad input report matching
+// GamepadReportDescriptor.
+type GamepadReport struct {
+	Buttons uint16 // Button bits (bit 0 = button 1)
+	Hat     uint8  // Hat switch direction (Hat*)
+	X       int8   // Left stick X (-127 to 127)
+	Y       int8   // Left stick Y (-127 to 127)
+	Z       int8   // Right stick X (-127 to 127)
+	Rz      int8   // Right stick Y (-127 to 127)
+	Rx      uint8  // Left trigger (0 to 255)
+	Ry      uint8  // Right trigger (0 to 255)
+}
+
+// GamepadReportSize is the size of a gamepad report in bytes.
+const GamepadReportSize = 9
+
+// MarshalTo writes the gamepad report to buf.
+func (r *GamepadReport) MarshalTo(buf []byte) int {
+	if len(buf) < GamepadReportSize {
+		return 0
+	}
+	buf[0] = byte(r.Buttons)
+	buf[1] = byte(r.Buttons >> 8)
+	buf[2] = r.Hat & 0x0F
+	buf[3] = byte(r.X)
+	buf[4] = byte(r.Y)
+	buf[5] = byte(r.Z)
+	buf[6] = byte(r.Rz)
+	buf[7] = r.Rx
+	buf[8] = r.Ry
+	return GamepadReportSize
+}
+
+// Clear resets the gamepad report to all buttons released, the hat
+// centered, and all axes at rest.
+func (r *GamepadReport) Clear() {
+	*r = GamepadReport{}
+}
+
+// SetButton presses or releases a button (1 to 16).
+func (r *GamepadReport) SetButton(button uint8, pressed bool) {
+	if button < 1 || button > 16 {
+		return
+	}
+	if pressed {
+		r.Buttons |= 1 << (button - 1)
+	} else {
+		r.Buttons &^= 1 << (button - 1)
+	}
+}
+
+// Button reports whether a button (1 to 16) is pressed.
+func (r *GamepadReport) Button(button uint8) bool {
+	if button < 1 || button > 16 {
+		return false
+	}
+	return r.Buttons&(1<<(button-1)) != 0
+}
+
+// JoystickReport is an 8-byte joystick input report matching
+// JoystickReportDescriptor.
+type JoystickReport struct {
+	X        int16 // X axis (-32767 to 32767)
+	Y        int16 // Y axis (-32767 to 32767)
+	Rz       int8  // Twist (-127 to 127)
+	Throttle uint8 // Throttle (0 to 255)
+	Hat      uint8 // Hat switch direction (Hat*)
+	Buttons  uint8 // Button bits (bit 0 = button 1)
+}
+
+// JoystickReportSize is the size of a joystick report in bytes.
+const JoystickReportSize = 8
+
+// MarshalTo writes the joystick report to buf.
+func (r *JoystickReport) MarshalTo(buf []byte) int {
+	if len(buf) < JoystickReportSize {
+		return 0
+	}
+	buf[0] = byte(r.X)
+	buf[1] = byte(uint16(r.X) >> 8)
+	buf[2] = byte(r.Y)
+	buf[3] = byte(uint16(r.Y) >> 8)
+	buf[4] = byte(r.Rz)
+	buf[5] = r.Throttle
+	buf[6] = r.Hat & 0x0F
+	buf[7] = r.Buttons
+	return JoystickReportSize
+}
+
+// Clear resets the joystick report to all buttons released, the hat
+// centered, and all axes at rest.
+func (r *JoystickReport) Clear() {
+	*r = JoystickReport{}
+}
+
+// SetButton presses or releases a button (1 to 8).
+func (r *JoystickReport) SetButton(button uint8, pressed bool) {
+	if button < 1 || button > 8 {
+		return
+	}
+	if pressed {
+		r.Buttons |= 1 << (button - 1)
+	} else {
+		r.Buttons &^= 1 << (button - 1)
+	}
+}
+
+// Button reports whether a button (1 to 8) is pressed.
+func (r *JoystickReport) Button(button uint8) bool {
+	if button < 1 || button > 8 {
+		return false
+	}
+	return r.Buttons&(1<<(button-1)) != 0
+}
+
+// RumbleReport is a 3-byte force feedback output report matching
+// GamepadReportDescriptor.
+type RumbleReport struct {
+	Left     uint8 // Left (strong) motor magnitude (0 to 255)
+	Right    uint8 // Right (weak) motor magnitude (0 to 255)
+	Duration uint8 // Effect duration in 10ms units (0 = until changed)
+}
+
+// RumbleReportSize is the size of a rumble report in bytes.
+const RumbleReportSize = 3
+
+// ParseRumbleReport parses a rumble output report, such as the data passed
+// to the callback set with SetOnOutputReport.
+// Returns false if data is too short.
+func ParseRumbleReport(data []byte, out *RumbleReport) bool {
+	if len(data) < RumbleReportSize {
+		return false
+	}
+	out.Left = data[0]
+	out.Right = data[1]
+	out.Duration = data[2]
+	return true
+}
+
+// MarshalTo writes the rumble report to buf.
+func (r *RumbleReport) MarshalTo(buf []byte) int {
+	if len(buf) < RumbleReportSize {
+		return 0
+	}
+	buf[0] = r.Left
+	buf[1] = r.Right
+	buf[2] = r.Duration
+	return RumbleReportSize
+}
+
+// Active reports whether either motor is running.
+func (r *RumbleReport) Active() bool {
+	return r.Left != 0 || r.Right != 0
+}
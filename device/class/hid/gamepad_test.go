@@ -0,0 +1,391 @@
+package hid
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ardnew/softusb/device"
+)
+
+// reportField is a data field of a report, located by walking the report
+// descriptor.
+type reportField struct {
+	page   uint16
+	usage  uint16
+	offset int // Bit offset within the report
+	size   int // Bits
+	signed bool
+}
+
+// reportFields returns the data fields of the report of the given main
+// item type (0x80 Input, 0x90 Output) in a report descriptor without
+// report IDs. Constant fields are skipped but advance the bit offset.
+func reportFields(t *testing.T, desc []byte, mainTag byte) (fields []reportField, bits int) {
+	t.Helper()
+	type globals struct {
+		page        uint16
+		logicalMin  int32
+		size, count int
+	}
+	var g globals
+	var stack []globals
+	var usages []uint16
+	var usageMin, usageMax uint16
+	for i := 0; i < len(desc); {
+		prefix := desc[i]
+		n := int(prefix & 0x03)
+		if n == 3 {
+			n = 4
+		}
+		if i+1+n > len(desc) {
+			t.Fatalf("item at %d truncated", i)
+		}
+		var value uint32
+		for j := n - 1; j >= 0; j-- {
+			value = value<<8 | uint32(desc[i+1+j])
+		}
+		signed := int32(value)
+		if n > 0 && n < 4 {
+			shift := 32 - 8*n
+			signed = int32(value<<shift) >> shift
+		}
+		i += 1 + n
+
+		switch prefix &^ 0x03 {
+		case 0x04: // Usage Page
+			g.page = uint16(value)
+		case 0x14: // Logical Minimum
+			g.logicalMin = signed
+		case 0x74: // Report Size
+			g.size = int(value)
+		case 0x94: // Report Count
+			g.count = int(value)
+		case 0xA4: // Push
+			stack = append(stack, g)
+		case 0xB4: // Pop
+			g = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case 0x08: // Usage
+			usages = append(usages, uint16(value))
+		case 0x18: // Usage Minimum
+			usageMin = uint16(value)
+		case 0x28: // Usage Maximum
+			usageMax = uint16(value)
+		case 0x80, 0x90, 0xB0: // Input, Output, Feature
+			if prefix&^0x03 == mainTag {
+				for k := range g.count {
+					if value&FlagConstant == 0 {
+						f := reportField{page: g.page, offset: bits, size: g.size, signed: g.logicalMin < 0}
+						switch {
+						case usageMax != 0:
+							f.usage = usageMin + uint16(k)
+						case k < len(usages):
+							f.usage = usages[k]
+						default:
+							f.usage = usages[len(usages)-1]
+						}
+						fields = append(fields, f)
+					}
+					bits += g.size
+				}
+			}
+			usages, usageMin, usageMax = nil, 0, 0
+		case 0xA0, 0xC0: // Collection, End Collection
+			usages, usageMin, usageMax = nil, 0, 0
+		}
+	}
+	return fields, bits
+}
+
+// extract returns the value of field f in report.
+func (f reportField) extract(report []byte) int64 {
+	var v uint64
+	for b := range f.size {
+		bit := f.offset + b
+		v |= uint64(report[bit/8]>>(bit%8)&1) << b
+	}
+	if f.signed && v&(1<<(f.size-1)) != 0 {
+		return int64(v) - 1<<f.size
+	}
+	return int64(v)
+}
+
+// usageKey identifies a field by usage page and usage.
+type usageKey struct{ page, usage uint16 }
+
+// checkFields compares each data field of report against want, which must
+// name every field declared by the descriptor.
+func checkFields(t *testing.T, fields []reportField, report []byte, want map[usageKey]int64) {
+	t.Helper()
+	if len(fields) != len(want) {
+		t.Errorf("descriptor declares %d fields, want %d", len(fields), len(want))
+	}
+	for _, f := range fields {
+		key := usageKey{f.page, f.usage}
+		w, ok := want[key]
+		if !ok {
+			t.Errorf("unexpected field %+v", f)
+			continue
+		}
+		if v := f.extract(report); v != w {
+			t.Errorf("field %04X:%04X (bit %d) = %d, want %d", f.page, f.usage, f.offset, v, w)
+		}
+	}
+}
+
+// buttonFields adds want entries for buttons 1 to n from a button bitmap.
+func buttonFields(want map[usageKey]int64, buttons uint16, n int) {
+	for b := range n {
+		want[usageKey{UsagePageButton, uint16(b + 1)}] = int64(buttons >> b & 1)
+	}
+}
+
+func TestGamepadReportMarshalTo(t *testing.T) {
+	fields, bits := reportFields(t, GamepadReportDescriptor, 0x80)
+	if bits != 8*GamepadReportSize {
+		t.Fatalf("descriptor input report = %d bits, want %d", bits, 8*GamepadReportSize)
+	}
+
+	reports := []GamepadReport{
+		{},
+		{Buttons: 0xA55A, Hat: HatDownLeft, X: -100, Y: 50, Z: -1, Rz: 127, Rx: 200, Ry: 1},
+		{Buttons: 0xFFFF, Hat: HatUpLeft, X: -127, Y: -127, Z: 127, Rz: -127, Rx: 255, Ry: 255},
+	}
+	for _, r := range reports {
+		var buf [GamepadReportSize]byte
+		if n := r.MarshalTo(buf[:]); n != GamepadReportSize {
+			t.Fatalf("MarshalTo() = %d, want %d", n, GamepadReportSize)
+		}
+		want := map[usageKey]int64{
+			{UsagePageGenericDesktop, UsageHatSwitch}: int64(r.Hat),
+			{UsagePageGenericDesktop, UsageX}:         int64(r.X),
+			{UsagePageGenericDesktop, UsageY}:         int64(r.Y),
+			{UsagePageGenericDesktop, UsageZ}:         int64(r.Z),
+			{UsagePageGenericDesktop, UsageRz}:        int64(r.Rz),
+			{UsagePageGenericDesktop, UsageRx}:        int64(r.Rx),
+			{UsagePageGenericDesktop, UsageRy}:        int64(r.Ry),
+		}
+		buttonFields(want, r.Buttons, 16)
+		checkFields(t, fields, buf[:], want)
+	}
+
+	var r GamepadReport
+	if n := r.MarshalTo(make([]byte, GamepadReportSize-1)); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
+func TestJoystickReportMarshalTo(t *testing.T) {
+	fields, bits := reportFields(t, JoystickReportDescriptor, 0x80)
+	if bits != 8*JoystickReportSize {
+		t.Fatalf("descriptor input report = %d bits, want %d", bits, 8*JoystickReportSize)
+	}
+
+	reports := []JoystickReport{
+		{},
+		{X: -30000, Y: 12345, Rz: -5, Throttle: 250, Hat: HatRight, Buttons: 0x81},
+		{X: 32767, Y: -32767, Rz: 127, Throttle: 255, Hat: HatUpLeft, Buttons: 0xFF},
+	}
+	for _, r := range reports {
+		var buf [JoystickReportSize]byte
+		if n := r.MarshalTo(buf[:]); n != JoystickReportSize {
+			t.Fatalf("MarshalTo() = %d, want %d", n, JoystickReportSize)
+		}
+		want := map[usageKey]int64{
+			{UsagePageGenericDesktop, UsageX}:         int64(r.X),
+			{UsagePageGenericDesktop, UsageY}:         int64(r.Y),
+			{UsagePageGenericDesktop, UsageRz}:        int64(r.Rz),
+			{UsagePageSimulation, UsageThrottle}:      int64(r.Throttle),
+			{UsagePageGenericDesktop, UsageHatSwitch}: int64(r.Hat),
+		}
+		buttonFields(want, uint16(r.Buttons), 8)
+		checkFields(t, fields, buf[:], want)
+	}
+
+	var r JoystickReport
+	if n := r.MarshalTo(make([]byte, JoystickReportSize-1)); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
+func TestReportButtons(t *testing.T) {
+	var g GamepadReport
+	g.SetButton(GamepadButtonA, true)
+	g.SetButton(16, true)
+	g.SetButton(0, true)
+	g.SetButton(17, true)
+	if g.Buttons != 0x8001 {
+		t.Errorf("gamepad Buttons = %#04x, want 0x8001", g.Buttons)
+	}
+	if !g.Button(GamepadButtonA) || !g.Button(16) || g.Button(GamepadButtonB) || g.Button(0) || g.Button(17) {
+		t.Errorf("gamepad Button() mismatch for Buttons %#04x", g.Buttons)
+	}
+	g.SetButton(GamepadButtonA, false)
+	if g.Buttons != 0x8000 {
+		t.Errorf("gamepad Buttons = %#04x, want 0x8000", g.Buttons)
+	}
+
+	var j JoystickReport
+	j.SetButton(1, true)
+	j.SetButton(8, true)
+	j.SetButton(9, true)
+	if j.Buttons != 0x81 {
+		t.Errorf("joystick Buttons = %#02x, want 0x81", j.Buttons)
+	}
+	if !j.Button(8) || j.Button(2) || j.Button(9) {
+		t.Errorf("joystick Button() mismatch for Buttons %#02x", j.Buttons)
+	}
+	j.Clear()
+	if j != (JoystickReport{}) {
+		t.Errorf("Clear() = %+v, want zero report", j)
+	}
+}
+
+func TestRumbleReport(t *testing.T) {
+	fields, bits := reportFields(t, GamepadReportDescriptor, 0x90)
+	if bits != 8*RumbleReportSize || len(fields) != 3 {
+		t.Fatalf("descriptor output report = %d bits in %d fields, want %d bits in 3",
+			bits, len(fields), 8*RumbleReportSize)
+	}
+	wantUsages := []uint16{UsageMagnitude, UsageMagnitude, UsageDuration}
+	for i, f := range fields {
+		if f.page != UsagePagePID || f.usage != wantUsages[i] {
+			t.Errorf("field %d usage = %04X:%04X, want %04X:%04X",
+				i, f.page, f.usage, UsagePagePID, wantUsages[i])
+		}
+	}
+
+	in := RumbleReport{Left: 0xC0, Right: 0x40, Duration: 50}
+	var buf [RumbleReportSize]byte
+	if n := in.MarshalTo(buf[:]); n != RumbleReportSize {
+		t.Fatalf("MarshalTo() = %d, want %d", n, RumbleReportSize)
+	}
+	for i, want := range []uint8{in.Left, in.Right, in.Duration} {
+		if v := fields[i].extract(buf[:]); v != int64(want) {
+			t.Errorf("field %d = %d, want %d", i, v, want)
+		}
+	}
+
+	var out RumbleReport
+	if !ParseRumbleReport(buf[:], &out) || out != in {
+		t.Errorf("ParseRumbleReport() = %+v, want %+v", out, in)
+	}
+	if !out.Active() {
+		t.Error("Active() = false, want true")
+	}
+	if ParseRumbleReport(buf[:RumbleReportSize-1], &out) {
+		t.Error("ParseRumbleReport(short) = true, want false")
+	}
+	if (&RumbleReport{Duration: 10}).Active() {
+		t.Error("Active() with both motors off = true, want false")
+	}
+}
+
+// setReport sends SET_REPORT to interface 0 and reports whether it stalled.
+func setReport(t *testing.T, fake *fakeHAL, reportType, id uint8, data []byte) bool {
+	t.Helper()
+	return fake.control(t, device.RequestTypeClass|device.RequestRecipientInterface,
+		RequestSetReport, uint16(reportType)<<8|uint16(id), 0, uint16(len(data)), data).stalled
+}
+
+// getReport sends GET_REPORT to interface 0.
+func getReport(t *testing.T, fake *fakeHAL, reportType, id uint8, length uint16) ep0Result {
+	t.Helper()
+	return fake.control(t, device.RequestDirectionDeviceToHost|device.RequestTypeClass|device.RequestRecipientInterface,
+		RequestGetReport, uint16(reportType)<<8|uint16(id), 0, length, nil)
+}
+
+func TestGamepadOutputReport(t *testing.T) {
+	h, fake := startHID(t, GamepadReportDescriptor, true)
+	outputs := make(chan []byte, 1)
+	features := make(chan []byte, 1)
+	h.SetOnOutputReport(func(data []byte) { outputs <- append([]byte{}, data...) })
+	h.SetOnFeatureReport(func(id uint8, data []byte) { features <- append([]byte{}, data...) })
+
+	// SET_REPORT(Output) reaches the output callback and GET_REPORT
+	rumble := []byte{0xFF, 0x20, 0x0A}
+	if setReport(t, fake, ReportTypeOutput, 0, rumble) {
+		t.Fatal("SET_REPORT(Output) stalled")
+	}
+	select {
+	case data := <-outputs:
+		var r RumbleReport
+		if !ParseRumbleReport(data, &r) || r != (RumbleReport{Left: 0xFF, Right: 0x20, Duration: 0x0A}) {
+			t.Errorf("output callback data = % X", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("output callback not called")
+	}
+	select {
+	case data := <-features:
+		t.Errorf("feature callback called with % X", data)
+	default:
+	}
+	if r := getReport(t, fake, ReportTypeOutput, 0, RumbleReportSize); r.stalled || !bytes.Equal(r.data, rumble) {
+		t.Errorf("GET_REPORT(Output) = % X, stalled %v, want % X", r.data, r.stalled, rumble)
+	}
+	if leds := h.LEDs(); leds != 0 {
+		t.Errorf("LEDs() = %#02x after rumble report, want 0", leds)
+	}
+
+	// Output reports on the interrupt OUT endpoint are returned by
+	// ReceiveReport
+	fake.out <- []byte{0x00, 0x80, 0x00}
+	var buf [RumbleReportSize]byte
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := h.ReceiveReport(ctx, buf[:])
+	var r RumbleReport
+	if err != nil || !ParseRumbleReport(buf[:n], &r) || r != (RumbleReport{Right: 0x80}) {
+		t.Errorf("ReceiveReport() = % X, %v", buf[:n], err)
+	}
+
+	// The input report is sent as marshaled, without a report ID
+	report := GamepadReport{Buttons: 1 << (GamepadButtonStart - 1), Hat: HatUp, X: -1}
+	if err := h.SendGamepadReport(ctx, &report); err != nil {
+		t.Fatalf("SendGamepadReport() error = %v", err)
+	}
+	want := []byte{0x00, 0x02, HatUp, 0xFF, 0, 0, 0, 0, 0}
+	if got := fake.nextReport(t); !bytes.Equal(got, want) {
+		t.Errorf("SendGamepadReport() sent % X, want % X", got, want)
+	}
+}
+
+func TestCompositeOutputReport(t *testing.T) {
+	h, fake := startHID(t, CompositeReportDescriptor, false)
+	outputs := make(chan []byte, 1)
+	h.SetOnOutputReport(func(data []byte) { outputs <- append([]byte{}, data...) })
+
+	// Keyboard LED reports update LEDs; other report IDs do not
+	tests := []struct {
+		name string
+		id   uint8
+		data []byte
+		leds uint8
+	}{
+		{"keyboard LEDs", ReportIDKeyboard, []byte{ReportIDKeyboard, LEDCapsLock | LEDNumLock}, LEDCapsLock | LEDNumLock},
+		{"mismatched report ID", ReportIDMouse, []byte{ReportIDKeyboard, LEDScrollLock}, LEDCapsLock | LEDNumLock},
+		{"missing report ID", ReportIDKeyboard, []byte{LEDScrollLock}, LEDCapsLock | LEDNumLock},
+		{"keyboard LEDs cleared", ReportIDKeyboard, []byte{ReportIDKeyboard, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if setReport(t, fake, ReportTypeOutput, tt.id, tt.data) {
+				t.Fatal("SET_REPORT(Output) stalled")
+			}
+			select {
+			case data := <-outputs:
+				if !bytes.Equal(data, tt.data) {
+					t.Errorf("output callback data = % X, want % X", data, tt.data)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("output callback not called")
+			}
+			if leds := h.LEDs(); leds != tt.leds {
+				t.Errorf("LEDs() = %#02x, want %#02x", leds, tt.leds)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package hid
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/ardnew/softusb/device"
 	"github.com/ardnew/softusb/pkg"
@@ -23,8 +24,10 @@ type HID struct {
 	// Stack reference for data transfer
 	stack *device.Stack
 
-	// Report descriptor (stored by reference)
+	// Report descriptor (stored by reference) and its report layout
 	reportDescriptor []byte
+	layout           ReportLayout
+	layoutValid      bool
 
 	// HID descriptor
 	hidDescriptor HIDDescriptor
@@ -32,6 +35,7 @@ type HID struct {
 	// State
 	protocol uint8 // 0 = boot, 1 = report
 	idleRate uint8 // Idle rate in 4ms units (0 = infinite)
+	leds     uint8 // Keyboard LED state from the last output report (LED*)
 
 	// Callbacks
 	onOutputReport  func(data []byte)
@@ -40,18 +44,25 @@ type HID struct {
 	onSetIdle       func(rate uint8, reportID uint8)
 
 	// Buffers (zero-allocation)
-	reportBuf   [MaxReportSize]byte
+	reportBuf   [MaxReportSize]byte // Guarded by sendMutex
 	responseBuf [MaxReportSize]byte
 
+	// Current state of each report in layout, returned by GET_REPORT
+	reports [MaxReports][MaxReportSize]byte
+
 	// State
 	mutex      sync.RWMutex
+	sendMutex  sync.Mutex // Serializes senders sharing reportBuf
 	configured bool
 }
 
 // New creates a new HID class driver with the given report descriptor.
 // The report descriptor is stored by reference.
+//
+// If the report descriptor is valid (see [ValidateReportDescriptor]),
+// SendReport checks the length of each input report against it.
 func New(reportDescriptor []byte) *HID {
-	return &HID{
+	h := &HID{
 		reportDescriptor: reportDescriptor,
 		hidDescriptor: HIDDescriptor{
 			Length:         HIDDescriptorSize,
@@ -64,6 +75,14 @@ func New(reportDescriptor []byte) *HID {
 		},
 		protocol: ProtocolReport,
 	}
+
+	if err := ValidateReportDescriptor(reportDescriptor, &h.layout); err != nil {
+		pkg.LogWarn(pkg.ComponentDevice, "invalid HID report descriptor",
+			"error", err)
+	} else {
+		h.layoutValid = true
+	}
+	return h
 }
 
 // SetStack sets the device stack reference for data transfer.
@@ -108,6 +127,49 @@ func (h *HID) Protocol() uint8 {
 	return h.protocol
 }
 
+// LEDs returns the keyboard LED state (LED*) from the last keyboard output
+// report received from the host, by SET_REPORT or ReceiveReport.
+func (h *HID) LEDs() uint8 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.leds
+}
+
+// updateLEDs records the keyboard LED state if data is a keyboard LED
+// output report: a single LED byte, prefixed with ReportIDKeyboard if
+// reports carry report IDs. The caller must hold h.mutex.
+func (h *HID) updateLEDs(id uint8, data []byte) {
+	if h.protocol != ProtocolBoot && h.layoutValid && h.layout.ReportIDs {
+		if len(data) != 2 || data[0] != ReportIDKeyboard || (id != 0 && id != data[0]) {
+			return
+		}
+		data = data[1:]
+	}
+	if len(data) == 1 {
+		h.leds = data[0]
+	}
+}
+
+// PollInterval returns the polling interval of the interrupt IN endpoint
+// at the negotiated speed, or 0 if the driver is not configured. An
+// endpoint interval of 0 is treated as 1.
+func (h *HID) PollInterval() time.Duration {
+	h.mutex.RLock()
+	stack := h.stack
+	ep := h.inEP
+	h.mutex.RUnlock()
+
+	if stack == nil || ep == nil {
+		return 0
+	}
+	interval := max(ep.Interval, 1)
+	if stack.Speed() == device.SpeedHigh {
+		// 2^(bInterval-1) microframes
+		return 125 * time.Microsecond << (min(interval, 16) - 1)
+	}
+	return time.Duration(interval) * time.Millisecond
+}
+
 // IdleRate returns the current idle rate.
 func (h *HID) IdleRate() uint8 {
 	h.mutex.RLock()
@@ -150,15 +212,16 @@ func (h *HID) Init(iface *device.Interface) error {
 	return nil
 }
 
-// HandleSetup processes class-specific SETUP requests.
-func (h *HID) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) (bool, error) {
+// HandleSetup processes class-specific SETUP requests and GET_DESCRIPTOR
+// requests for the HID and report descriptors.
+func (h *HID) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
 	// Handle standard requests for HID descriptors
 	if setup.IsStandard() && setup.Request == device.RequestGetDescriptor {
 		return h.handleGetDescriptor(setup)
 	}
 
 	if !setup.IsClass() {
-		return false, nil
+		return nil, false, nil
 	}
 
 	switch setup.Request {
@@ -181,39 +244,35 @@ func (h *HID) HandleSetup(iface *device.Interface, setup *device.SetupPacket, da
 		return h.handleSetProtocol(setup)
 
 	default:
-		return false, nil
+		return nil, false, nil
 	}
 }
 
 // handleGetDescriptor handles GET_DESCRIPTOR for HID and Report descriptors.
-func (h *HID) handleGetDescriptor(setup *device.SetupPacket) (bool, error) {
+func (h *HID) handleGetDescriptor(setup *device.SetupPacket) ([]byte, bool, error) {
 	descType := setup.DescriptorType()
 
 	switch descType {
 	case DescriptorTypeHID:
-		// Return HID descriptor
 		h.mutex.RLock()
 		n := h.hidDescriptor.MarshalTo(h.responseBuf[:])
 		h.mutex.RUnlock()
 
 		if n == 0 {
-			return true, pkg.ErrBufferTooSmall
+			return nil, true, pkg.ErrBufferTooSmall
 		}
-		// The stack should send this data
-		return true, nil
+		return h.responseBuf[:n], true, nil
 
 	case DescriptorTypeReport:
-		// Return Report descriptor
-		// The stack should send h.reportDescriptor
-		return true, nil
+		return h.reportDescriptor, true, nil
 
 	default:
-		return false, nil
+		return nil, false, nil
 	}
 }
 
 // handleGetReport handles GET_REPORT request.
-func (h *HID) handleGetReport(setup *device.SetupPacket) (bool, error) {
+func (h *HID) handleGetReport(setup *device.SetupPacket) ([]byte, bool, error) {
 	reportType := uint8(setup.Value >> 8)
 	reportID := uint8(setup.Value & 0xFF)
 
@@ -221,13 +280,28 @@ func (h *HID) handleGetReport(setup *device.SetupPacket) (bool, error) {
 		"type", reportType,
 		"id", reportID)
 
-	// For now, return zeros
-	// A real implementation would get the current report state
-	return true, nil
+	if !h.layoutValid {
+		// Without a report layout, return zeros
+		h.mutex.Lock()
+		n := min(int(setup.Length), MaxReportSize)
+		clear(h.responseBuf[:n])
+		h.mutex.Unlock()
+		return h.responseBuf[:n], true, nil
+	}
+
+	h.mutex.Lock()
+	n := h.getReport(reportType, reportID, h.responseBuf[:])
+	h.mutex.Unlock()
+
+	if n == 0 {
+		return nil, true, pkg.ErrInvalidRequest
+	}
+	return h.responseBuf[:n], true, nil
 }
 
-// handleSetReport handles SET_REPORT request.
-func (h *HID) handleSetReport(setup *device.SetupPacket, data []byte) (bool, error) {
+// handleSetReport handles SET_REPORT request with the report in the data
+// stage.
+func (h *HID) handleSetReport(setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
 	reportType := uint8(setup.Value >> 8)
 	reportID := uint8(setup.Value & 0xFF)
 
@@ -241,6 +315,13 @@ func (h *HID) handleSetReport(setup *device.SetupPacket, data []byte) (bool, err
 	featureCb := h.onFeatureReport
 	h.mutex.RUnlock()
 
+	h.mutex.Lock()
+	h.storeReport(reportType, reportID, data)
+	if reportType == ReportTypeOutput {
+		h.updateLEDs(reportID, data)
+	}
+	h.mutex.Unlock()
+
 	switch reportType {
 	case ReportTypeOutput:
 		if outputCb != nil {
@@ -252,20 +333,20 @@ func (h *HID) handleSetReport(setup *device.SetupPacket, data []byte) (bool, err
 		}
 	}
 
-	return true, nil
+	return nil, true, nil
 }
 
 // handleGetIdle handles GET_IDLE request.
-func (h *HID) handleGetIdle(setup *device.SetupPacket) (bool, error) {
+func (h *HID) handleGetIdle(setup *device.SetupPacket) ([]byte, bool, error) {
 	h.mutex.RLock()
 	h.responseBuf[0] = h.idleRate
 	h.mutex.RUnlock()
 
-	return true, nil
+	return h.responseBuf[:1], true, nil
 }
 
 // handleSetIdle handles SET_IDLE request.
-func (h *HID) handleSetIdle(setup *device.SetupPacket) (bool, error) {
+func (h *HID) handleSetIdle(setup *device.SetupPacket) ([]byte, bool, error) {
 	rate := uint8(setup.Value >> 8)
 	reportID := uint8(setup.Value & 0xFF)
 
@@ -282,20 +363,20 @@ func (h *HID) handleSetIdle(setup *device.SetupPacket) (bool, error) {
 		cb(rate, reportID)
 	}
 
-	return true, nil
+	return nil, true, nil
 }
 
 // handleGetProtocol handles GET_PROTOCOL request.
-func (h *HID) handleGetProtocol(setup *device.SetupPacket) (bool, error) {
+func (h *HID) handleGetProtocol(setup *device.SetupPacket) ([]byte, bool, error) {
 	h.mutex.RLock()
 	h.responseBuf[0] = h.protocol
 	h.mutex.RUnlock()
 
-	return true, nil
+	return h.responseBuf[:1], true, nil
 }
 
 // handleSetProtocol handles SET_PROTOCOL request.
-func (h *HID) handleSetProtocol(setup *device.SetupPacket) (bool, error) {
+func (h *HID) handleSetProtocol(setup *device.SetupPacket) ([]byte, bool, error) {
 	protocol := uint8(setup.Value & 0xFF)
 
 	h.mutex.Lock()
@@ -310,7 +391,7 @@ func (h *HID) handleSetProtocol(setup *device.SetupPacket) (bool, error) {
 		cb(protocol)
 	}
 
-	return true, nil
+	return nil, true, nil
 }
 
 // SetAlternate handles alternate setting changes.
@@ -335,38 +416,214 @@ func (h *HID) Close() error {
 	return nil
 }
 
-// SendReport sends an input report to the host.
+// ReportLength returns the size in bytes of the report of the given type
+// and ID declared by the report descriptor, including the report ID, or 0
+// if the report is not declared or the descriptor is invalid.
+func (h *HID) ReportLength(reportType, id uint8) int {
+	if !h.layoutValid {
+		return 0
+	}
+	return h.layout.Length(reportType, id)
+}
+
+// usesReportIDs reports whether reports are currently prefixed with a
+// report ID. Boot protocol reports never carry a report ID.
+func (h *HID) usesReportIDs() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.layoutValid && h.layout.ReportIDs && h.protocol != ProtocolBoot
+}
+
+// reportIndex returns the index in layout of the report of the given type
+// and ID, or -1 if the report descriptor declares no such report.
+func (h *HID) reportIndex(reportType, id uint8) int {
+	if !h.layoutValid {
+		return -1
+	}
+	if !h.layout.ReportIDs {
+		id = 0
+	}
+	for i := 0; i < h.layout.NumReports; i++ {
+		if r := &h.layout.Reports[i]; r.Type == reportType && r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// getReport copies the current report of the given type and ID to buf.
+// The caller must hold h.mutex.
+// Returns the number of bytes copied, or 0 if there is no such report.
+func (h *HID) getReport(reportType, id uint8, buf []byte) int {
+	i := h.reportIndex(reportType, id)
+	if i < 0 {
+		return 0
+	}
+	n := min(h.layout.Reports[i].Length(), MaxReportSize)
+	if h.layout.ReportIDs {
+		// The stored report may not have been set yet
+		h.reports[i][0] = h.layout.Reports[i].ID
+	}
+	return copy(buf, h.reports[i][:n])
+}
+
+// storeReport records data as the current report of the given type and ID.
+// The caller must hold h.mutex.
+func (h *HID) storeReport(reportType, id uint8, data []byte) {
+	if i := h.reportIndex(reportType, id); i >= 0 {
+		n := copy(h.reports[i][:], data)
+		clear(h.reports[i][n:])
+	}
+}
+
+// GetReport copies the current report of the given type and ID to buf, as
+// returned to the host by GET_REPORT. Input reports are those last sent
+// with SendReport; output and feature reports are those last received from
+// the host or set with SetReport. Reports not yet sent or set are zero.
+// If the report descriptor uses report IDs, the report begins with its ID.
+// Returns the number of bytes copied, or 0 if the report descriptor
+// declares no such report.
+func (h *HID) GetReport(reportType, id uint8, buf []byte) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.getReport(reportType, id, buf)
+}
+
+// SetReport sets the current report of the given type returned by
+// GET_REPORT, without sending it to the host. This is typically used for
+// feature reports. If the report descriptor uses report IDs, data begins
+// with the report ID.
+// Returns pkg.ErrInvalidParameter if the length of data does not match the
+// report declared by the report descriptor.
+func (h *HID) SetReport(reportType uint8, data []byte) error {
+	var id uint8
+	if h.layoutValid && h.layout.ReportIDs && len(data) > 0 {
+		id = data[0]
+	}
+	i := h.reportIndex(reportType, id)
+	if i < 0 || h.layout.Reports[i].Length() != len(data) {
+		return pkg.ErrInvalidParameter
+	}
+
+	h.mutex.Lock()
+	h.storeReport(reportType, id, data)
+	h.mutex.Unlock()
+	return nil
+}
+
+// SendReport sends an input report to the host. If the report descriptor
+// uses report IDs, data begins with the report ID.
+// Returns pkg.ErrInvalidParameter if the length of data does not match the
+// input report declared by the report descriptor. The check is skipped
+// under boot protocol, where reports follow the boot format instead.
 func (h *HID) SendReport(ctx context.Context, data []byte) error {
 	h.mutex.RLock()
 	stack := h.stack
 	ep := h.inEP
 	configured := h.configured
+	boot := h.protocol == ProtocolBoot
 	h.mutex.RUnlock()
 
+	var id uint8
+	if h.layoutValid && !boot {
+		if h.layout.ReportIDs && len(data) > 0 {
+			id = data[0]
+		}
+		if n := h.layout.Length(ReportTypeInput, id); n == 0 || n != len(data) {
+			return pkg.ErrInvalidParameter
+		}
+	}
+
 	if !configured || stack == nil || ep == nil {
 		return pkg.ErrNotConfigured
 	}
 
 	_, err := stack.Write(ctx, ep, data)
+	if err == nil && !boot {
+		h.mutex.Lock()
+		h.storeReport(ReportTypeInput, id, data)
+		h.mutex.Unlock()
+	}
 	return err
 }
 
-// SendKeyboardReport sends a keyboard report to the host.
-func (h *HID) SendKeyboardReport(ctx context.Context, report *KeyboardReport) error {
-	n := report.MarshalTo(h.reportBuf[:])
-	if n == 0 {
+// SendReportID sends the input report payload with the given report ID.
+// The report ID is prepended to payload if the report descriptor uses
+// report IDs and the device is not in boot protocol.
+func (h *HID) SendReportID(ctx context.Context, id uint8, payload []byte) error {
+	if !h.usesReportIDs() {
+		return h.SendReport(ctx, payload)
+	}
+	if len(payload)+1 > MaxReportSize {
 		return pkg.ErrBufferTooSmall
 	}
-	return h.SendReport(ctx, h.reportBuf[:n])
+	h.sendMutex.Lock()
+	defer h.sendMutex.Unlock()
+	h.reportBuf[0] = id
+	n := copy(h.reportBuf[1:], payload)
+	return h.SendReport(ctx, h.reportBuf[:1+n])
 }
 
-// SendMouseReport sends a mouse report to the host.
-func (h *HID) SendMouseReport(ctx context.Context, report *MouseReport) error {
-	n := report.MarshalTo(h.reportBuf[:])
+// marshaler is implemented by the report types in this package.
+type marshaler interface {
+	MarshalTo(buf []byte) int
+}
+
+// sendMarshaled marshals report into the report buffer, prefixed with id
+// if it is not 0 and reports carry report IDs, and sends it.
+func (h *HID) sendMarshaled(ctx context.Context, id uint8, report marshaler) error {
+	h.sendMutex.Lock()
+	defer h.sendMutex.Unlock()
+	off := 0
+	if id != 0 && h.usesReportIDs() {
+		h.reportBuf[0] = id
+		off = 1
+	}
+	n := report.MarshalTo(h.reportBuf[off:])
 	if n == 0 {
 		return pkg.ErrBufferTooSmall
 	}
-	return h.SendReport(ctx, h.reportBuf[:n])
+	return h.SendReport(ctx, h.reportBuf[:off+n])
+}
+
+// SendKeyboardReport sends a keyboard report to the host. If the report
+// descriptor uses report IDs, the report is sent with ReportIDKeyboard.
+func (h *HID) SendKeyboardReport(ctx context.Context, report *KeyboardReport) error {
+	return h.sendMarshaled(ctx, ReportIDKeyboard, report)
+}
+
+// SendMouseReport sends a mouse report to the host. If the report
+// descriptor uses report IDs, the report is sent with ReportIDMouse.
+func (h *HID) SendMouseReport(ctx context.Context, report *MouseReport) error {
+	return h.sendMarshaled(ctx, ReportIDMouse, report)
+}
+
+// SendConsumerReport sends a consumer control report to the host. If the
+// report descriptor uses report IDs, the report is sent with
+// ReportIDConsumer.
+func (h *HID) SendConsumerReport(ctx context.Context, report *ConsumerReport) error {
+	return h.sendMarshaled(ctx, ReportIDConsumer, report)
+}
+
+// SendSystemControlReport sends a system control report to the host. If
+// the report descriptor uses report IDs, the report is sent with
+// ReportIDSystemControl.
+func (h *HID) SendSystemControlReport(ctx context.Context, report *SystemControlReport) error {
+	return h.sendMarshaled(ctx, ReportIDSystemControl, report)
+}
+
+// SendGamepadReport sends a gamepad report to the host. The report is sent
+// without a report ID; use SendReportID with a descriptor that declares
+// report IDs.
+func (h *HID) SendGamepadReport(ctx context.Context, report *GamepadReport) error {
+	return h.sendMarshaled(ctx, 0, report)
+}
+
+// SendJoystickReport sends a joystick report to the host. The report is
+// sent without a report ID; use SendReportID with a descriptor that
+// declares report IDs.
+func (h *HID) SendJoystickReport(ctx context.Context, report *JoystickReport) error {
+	return h.sendMarshaled(ctx, 0, report)
 }
 
 // ReceiveReport receives an output report from the host (if OUT endpoint exists).
@@ -385,7 +642,13 @@ func (h *HID) ReceiveReport(ctx context.Context, buf []byte) (int, error) {
 		return 0, pkg.ErrInvalidEndpoint
 	}
 
-	return stack.Read(ctx, ep, buf)
+	n, err := stack.Read(ctx, ep, buf)
+	if err == nil {
+		h.mutex.Lock()
+		h.updateLEDs(0, buf[:n])
+		h.mutex.Unlock()
+	}
+	return n, err
 }
 
 // ConfigureDevice adds the HID interface to a device builder.
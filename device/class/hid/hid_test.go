@@ -0,0 +1,241 @@
+package hid
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/device/hal"
+)
+
+// Endpoints used by the tests.
+const (
+	testInEP  = 0x81
+	testOutEP = 0x02
+)
+
+// ep0Result is the outcome of a control transfer seen by fakeHAL.
+type ep0Result struct {
+	data    []byte // IN data stage, nil for OUT requests
+	stalled bool
+}
+
+// fakeHAL is a device HAL that feeds SETUP packets and OUT reports to the
+// stack and records the input reports written to the IN endpoint.
+type fakeHAL struct {
+	setups  chan hal.SetupPacket
+	ep0Data chan []byte // OUT data stage of the next control transfer
+	results chan ep0Result
+	in      chan []byte // Writes to the interrupt IN endpoint
+	out     chan []byte // Reads from the interrupt OUT endpoint
+
+	// If gate is set, writes to the IN endpoint signal entered and block
+	// until gate is closed before recording the report.
+	gate    chan struct{}
+	entered chan struct{}
+}
+
+func newFakeHAL() *fakeHAL {
+	return &fakeHAL{
+		setups:  make(chan hal.SetupPacket, 1),
+		ep0Data: make(chan []byte, 1),
+		results: make(chan ep0Result, 1),
+		in:      make(chan []byte, 64),
+		out:     make(chan []byte, 1),
+	}
+}
+
+func (f *fakeHAL) Init(ctx context.Context) error                    { return nil }
+func (f *fakeHAL) Start() error                                      { return nil }
+func (f *fakeHAL) Stop() error                                       { return nil }
+func (f *fakeHAL) SetAddress(address uint8) error                    { return nil }
+func (f *fakeHAL) ConfigureEndpoints(eps []hal.EndpointConfig) error { return nil }
+func (f *fakeHAL) Stall(address uint8) error                         { return nil }
+func (f *fakeHAL) ClearStall(address uint8) error                    { return nil }
+func (f *fakeHAL) IsConnected() bool                                 { return true }
+func (f *fakeHAL) GetSpeed() hal.Speed                               { return hal.SpeedFull }
+func (f *fakeHAL) WaitConnect(ctx context.Context) error             { return nil }
+func (f *fakeHAL) WaitDisconnect(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeHAL) ReadSetup(ctx context.Context, out *hal.SetupPacket) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case *out = <-f.setups:
+		return nil
+	}
+}
+
+func (f *fakeHAL) ReadEP0(ctx context.Context, buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	select {
+	case data := <-f.ep0Data:
+		return copy(buf, data), nil
+	default:
+		return 0, nil
+	}
+}
+
+func (f *fakeHAL) WriteEP0(ctx context.Context, data []byte) error {
+	f.results <- ep0Result{data: append([]byte{}, data...)}
+	return nil
+}
+
+func (f *fakeHAL) StallEP0() error {
+	f.results <- ep0Result{stalled: true}
+	return nil
+}
+
+func (f *fakeHAL) AckEP0() error {
+	f.results <- ep0Result{}
+	return nil
+}
+
+func (f *fakeHAL) Read(ctx context.Context, address uint8, buf []byte) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case data := <-f.out:
+		return copy(buf, data), nil
+	}
+}
+
+func (f *fakeHAL) Write(ctx context.Context, address uint8, data []byte) (int, error) {
+	if address == testInEP {
+		if f.gate != nil {
+			f.entered <- struct{}{}
+			<-f.gate
+		}
+		f.in <- append([]byte{}, data...)
+	}
+	return len(data), nil
+}
+
+// control sends a SETUP packet, with data as the OUT data stage, and waits
+// for the control transfer to complete.
+func (f *fakeHAL) control(t *testing.T, requestType, request uint8, value, index uint16, length uint16, data []byte) ep0Result {
+	t.Helper()
+	if data != nil {
+		f.ep0Data <- data
+	}
+	f.setups <- hal.SetupPacket{
+		RequestType: requestType,
+		Request:     request,
+		Value:       value,
+		Index:       index,
+		Length:      length,
+	}
+	select {
+	case r := <-f.results:
+		return r
+	case <-time.After(time.Second):
+		t.Fatalf("request 0x%02X 0x%02X timed out", requestType, request)
+		return ep0Result{}
+	}
+}
+
+// nextReport waits for an input report on the IN endpoint.
+func (f *fakeHAL) nextReport(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case b := <-f.in:
+		return b
+	case <-time.After(time.Second):
+		t.Fatal("no input report")
+		return nil
+	}
+}
+
+// startHID starts a configured HID device with the given report
+// descriptor, and an interrupt OUT endpoint if withOut is set.
+func startHID(t *testing.T, reportDescriptor []byte, withOut bool) (*HID, *fakeHAL) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	h := New(reportDescriptor)
+	builder := device.NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1)
+	if withOut {
+		h.ConfigureDeviceWithOutEP(builder, testInEP&^device.EndpointDirectionIn, testOutEP, SubclassNone, ProtocolNone)
+	} else {
+		h.ConfigureDevice(builder, testInEP&^device.EndpointDirectionIn, SubclassNone, ProtocolNone)
+	}
+	dev, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if err := h.AttachToInterface(dev, 1, 0); err != nil {
+		t.Fatalf("AttachToInterface() error = %v", err)
+	}
+
+	dev.Reset()
+	dev.SetAddress(1)
+
+	fake := newFakeHAL()
+	stack := device.NewStack(dev, fake)
+	h.SetStack(stack)
+	if err := stack.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { stack.Stop() })
+
+	if r := fake.control(t, device.RequestTypeStandard|device.RequestRecipientDevice,
+		device.RequestSetConfiguration, 1, 0, 0, nil); r.stalled {
+		t.Fatal("SET_CONFIGURATION stalled")
+	}
+	return h, fake
+}
+
+func TestHIDConcurrentSends(t *testing.T) {
+	h, fake := startHID(t, CompositeReportDescriptor, false)
+	fake.gate = make(chan struct{})
+	fake.entered = make(chan struct{}, 2)
+	ctx := context.Background()
+
+	keyboard := KeyboardReport{Modifiers: ModLeftShift, Keys: [6]uint8{KeyA, KeyB, KeyC, KeyD, KeyE, KeyF}}
+	mouse := MouseReport{Buttons: MouseButtonLeft, X: -1, Y: 1, Wheel: -1}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := h.SendKeyboardReport(ctx, &keyboard); err != nil {
+			t.Errorf("SendKeyboardReport() error = %v", err)
+		}
+	}()
+
+	// Send the mouse report while the keyboard report is still in flight
+	<-fake.entered
+	go func() {
+		defer wg.Done()
+		if err := h.SendMouseReport(ctx, &mouse); err != nil {
+			t.Errorf("SendMouseReport() error = %v", err)
+		}
+	}()
+	select {
+	case <-fake.entered:
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(fake.gate)
+	wg.Wait()
+
+	want := map[uint8][]byte{
+		ReportIDKeyboard: {ReportIDKeyboard, ModLeftShift, 0, KeyA, KeyB, KeyC, KeyD, KeyE, KeyF},
+		ReportIDMouse:    {ReportIDMouse, MouseButtonLeft, 0xFF, 0x01, 0xFF},
+	}
+	for range 2 {
+		report := fake.nextReport(t)
+		if string(report) != string(want[report[0]]) {
+			t.Errorf("report = % X, want % X", report, want[report[0]])
+		}
+	}
+}
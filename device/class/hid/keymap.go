@@ -0,0 +1,193 @@
+package hid
+
+// MaxKeystrokes is the maximum number of keystrokes a Layout may use to
+// type a single character.
+const MaxKeystrokes = 2
+
+// Keystroke is a key pressed together with a set of modifiers.
+type Keystroke struct {
+	Modifiers uint8 // Modifier bits (Mod*)
+	Key       uint8 // Keycode (Key*)
+}
+
+// Layout maps characters to the keystrokes that type them on a keyboard
+// layout configured on the host.
+type Layout interface {
+	// Keystrokes stores the keystrokes that type r in out and returns
+	// their number, or 0 if r cannot be typed with this layout.
+	Keystrokes(r rune, out *[MaxKeystrokes]Keystroke) int
+}
+
+// Keymap is a table-driven Layout.
+//
+// Characters in Keys are typed with a single keystroke. Characters in
+// DeadKeys are typed with a dead key followed by Space. Tab, newline,
+// backspace, escape, and space are typed with their usual keys if they
+// are not in Keys.
+type Keymap struct {
+	Name     string
+	Keys     map[rune]Keystroke
+	DeadKeys map[rune]Keystroke
+}
+
+// Keystrokes implements Layout.
+func (m *Keymap) Keystrokes(r rune, out *[MaxKeystrokes]Keystroke) int {
+	if ks, ok := m.Keys[r]; ok {
+		out[0] = ks
+		return 1
+	}
+	if ks, ok := m.DeadKeys[r]; ok {
+		out[0] = ks
+		out[1] = Keystroke{Key: KeySpace}
+		return 2
+	}
+	if key := controlKey(r); key != KeyNone {
+		out[0] = Keystroke{Key: key}
+		return 1
+	}
+	return 0
+}
+
+// controlKey returns the key for a whitespace or control character common
+// to all layouts, or KeyNone.
+func controlKey(r rune) uint8 {
+	switch r {
+	case '\t':
+		return KeyTab
+	case '\n', '\r':
+		return KeyEnter
+	case '\b':
+		return KeyBackspace
+	case 0x1B:
+		return KeyEscape
+	case ' ':
+		return KeySpace
+	}
+	return KeyNone
+}
+
+// keyDef defines the characters typed by one key. A zero rune means the
+// key types nothing at that level.
+type keyDef struct {
+	key   uint8
+	base  rune // Without modifiers
+	shift rune // With Shift
+	altGr rune // With AltGr (Right Alt)
+}
+
+// newKeymap builds a Keymap from key definitions. Letters a-z are mapped
+// to keys A-Z unless redefined, so only layout differences are listed.
+func newKeymap(name string, defs []keyDef, dead []keyDef) *Keymap {
+	m := &Keymap{
+		Name:     name,
+		Keys:     make(map[rune]Keystroke),
+		DeadKeys: make(map[rune]Keystroke),
+	}
+	for i := uint8(0); i < 26; i++ {
+		m.Keys['a'+rune(i)] = Keystroke{Key: KeyA + i}
+		m.Keys['A'+rune(i)] = Keystroke{Modifiers: ModLeftShift, Key: KeyA + i}
+	}
+	add := func(keys map[rune]Keystroke, d keyDef) {
+		if d.base != 0 {
+			keys[d.base] = Keystroke{Key: d.key}
+		}
+		if d.shift != 0 {
+			keys[d.shift] = Keystroke{Modifiers: ModLeftShift, Key: d.key}
+		}
+		if d.altGr != 0 {
+			keys[d.altGr] = Keystroke{Modifiers: ModRightAlt, Key: d.key}
+		}
+	}
+	for _, d := range defs {
+		add(m.Keys, d)
+	}
+	for _, d := range dead {
+		add(m.DeadKeys, d)
+	}
+	return m
+}
+
+// LayoutUS is the US English (QWERTY) layout.
+var LayoutUS = newKeymap("US", []keyDef{
+	{Key1, '1', '!', 0},
+	{Key2, '2', '@', 0},
+	{Key3, '3', '#', 0},
+	{Key4, '4', '$', 0},
+	{Key5, '5', '%', 0},
+	{Key6, '6', '^', 0},
+	{Key7, '7', '&', 0},
+	{Key8, '8', '*', 0},
+	{Key9, '9', '(', 0},
+	{Key0, '0', ')', 0},
+	{KeyMinus, '-', '_', 0},
+	{KeyEqual, '=', '+', 0},
+	{KeyLeftBrace, '[', '{', 0},
+	{KeyRightBrace, ']', '}', 0},
+	{KeyBackslash, '\\', '|', 0},
+	{KeySemicolon, ';', ':', 0},
+	{KeyQuote, '\'', '"', 0},
+	{KeyGrave, '`', '~', 0},
+	{KeyComma, ',', '<', 0},
+	{KeyDot, '.', '>', 0},
+	{KeySlash, '/', '?', 0},
+}, nil)
+
+// LayoutUK is the UK English (QWERTY) layout.
+var LayoutUK = newKeymap("UK", []keyDef{
+	{Key1, '1', '!', 0},
+	{Key2, '2', '"', 0},
+	{Key3, '3', '£', 0},
+	{Key4, '4', '$', '€'},
+	{Key5, '5', '%', 0},
+	{Key6, '6', '^', 0},
+	{Key7, '7', '&', 0},
+	{Key8, '8', '*', 0},
+	{Key9, '9', '(', 0},
+	{Key0, '0', ')', 0},
+	{KeyMinus, '-', '_', 0},
+	{KeyEqual, '=', '+', 0},
+	{KeyLeftBrace, '[', '{', 0},
+	{KeyRightBrace, ']', '}', 0},
+	{KeyNonUSHash, '#', '~', 0},
+	{KeySemicolon, ';', ':', 0},
+	{KeyQuote, '\'', '@', 0},
+	{KeyGrave, '`', '¬', 0},
+	{KeyComma, ',', '<', 0},
+	{KeyDot, '.', '>', 0},
+	{KeySlash, '/', '?', 0},
+	{KeyNonUSBackslash, '\\', '|', 0},
+}, nil)
+
+// LayoutDE is the German (QWERTZ) layout. The accents ^, ´, and ` are
+// dead keys on this layout.
+var LayoutDE = newKeymap("DE", []keyDef{
+	{KeyY, 'z', 'Z', 0},
+	{KeyZ, 'y', 'Y', 0},
+	{KeyQ, 'q', 'Q', '@'},
+	{KeyE, 'e', 'E', '€'},
+	{KeyM, 'm', 'M', 'µ'},
+	{Key1, '1', '!', 0},
+	{Key2, '2', '"', '²'},
+	{Key3, '3', '§', '³'},
+	{Key4, '4', '$', 0},
+	{Key5, '5', '%', 0},
+	{Key6, '6', '&', 0},
+	{Key7, '7', '/', '{'},
+	{Key8, '8', '(', '['},
+	{Key9, '9', ')', ']'},
+	{Key0, '0', '=', '}'},
+	{KeyMinus, 'ß', '?', '\\'},
+	{KeyLeftBrace, 'ü', 'Ü', 0},
+	{KeyRightBrace, '+', '*', '~'},
+	{KeyNonUSHash, '#', '\'', 0},
+	{KeySemicolon, 'ö', 'Ö', 0},
+	{KeyQuote, 'ä', 'Ä', 0},
+	{KeyGrave, 0, '°', 0},
+	{KeyComma, ',', ';', 0},
+	{KeyDot, '.', ':', 0},
+	{KeySlash, '-', '_', 0},
+	{KeyNonUSBackslash, '<', '>', '|'},
+}, []keyDef{
+	{KeyGrave, '^', 0, 0},
+	{KeyEqual, '´', '`', 0},
+})
@@ -0,0 +1,52 @@
+package hid
+
+import "testing"
+
+func TestKeymapKeystrokes(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout Layout
+		r      rune
+		want   []Keystroke
+	}{
+		{"US letter", LayoutUS, 'q', []Keystroke{{Key: KeyQ}}},
+		{"US capital", LayoutUS, 'Q', []Keystroke{{ModLeftShift, KeyQ}}},
+		{"US shifted symbol", LayoutUS, '@', []Keystroke{{ModLeftShift, Key2}}},
+		{"US backslash", LayoutUS, '\\', []Keystroke{{Key: KeyBackslash}}},
+		{"US unmapped", LayoutUS, '€', nil},
+		{"UK shifted quote", LayoutUK, '@', []Keystroke{{ModLeftShift, KeyQuote}}},
+		{"UK hash", LayoutUK, '#', []Keystroke{{Key: KeyNonUSHash}}},
+		{"UK pound", LayoutUK, '£', []Keystroke{{ModLeftShift, Key3}}},
+		{"UK AltGr", LayoutUK, '€', []Keystroke{{ModRightAlt, Key4}}},
+		{"UK backslash", LayoutUK, '\\', []Keystroke{{Key: KeyNonUSBackslash}}},
+		{"DE swapped y", LayoutDE, 'y', []Keystroke{{Key: KeyZ}}},
+		{"DE swapped Z", LayoutDE, 'Z', []Keystroke{{ModLeftShift, KeyY}}},
+		{"DE umlaut", LayoutDE, 'ö', []Keystroke{{Key: KeySemicolon}}},
+		{"DE AltGr", LayoutDE, '{', []Keystroke{{ModRightAlt, Key7}}},
+		{"DE degree", LayoutDE, '°', []Keystroke{{ModLeftShift, KeyGrave}}},
+		{"DE dead circumflex", LayoutDE, '^', []Keystroke{{Key: KeyGrave}, {Key: KeySpace}}},
+		{"DE dead grave", LayoutDE, '`', []Keystroke{{ModLeftShift, KeyEqual}, {Key: KeySpace}}},
+		{"tab", LayoutDE, '\t', []Keystroke{{Key: KeyTab}}},
+		{"newline", LayoutUS, '\n', []Keystroke{{Key: KeyEnter}}},
+		{"carriage return", LayoutUS, '\r', []Keystroke{{Key: KeyEnter}}},
+		{"backspace", LayoutUK, '\b', []Keystroke{{Key: KeyBackspace}}},
+		{"escape", LayoutUS, 0x1B, []Keystroke{{Key: KeyEscape}}},
+		{"space", LayoutUS, ' ', []Keystroke{{Key: KeySpace}}},
+		{"control unmapped", LayoutUS, 0x07, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out [MaxKeystrokes]Keystroke
+			n := tt.layout.Keystrokes(tt.r, &out)
+			if n != len(tt.want) {
+				t.Fatalf("Keystrokes(%q) = %d, want %d", tt.r, n, len(tt.want))
+			}
+			for i, ks := range tt.want {
+				if out[i] != ks {
+					t.Errorf("Keystrokes(%q)[%d] = %+v, want %+v", tt.r, i, out[i], ks)
+				}
+			}
+		})
+	}
+}
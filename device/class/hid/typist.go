@@ -0,0 +1,120 @@
+package hid
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode"
+)
+
+// ErrUnmappedRune is returned by Typist when a character cannot be typed
+// with the current layout.
+var ErrUnmappedRune = errors.New("character not in keyboard layout")
+
+// Typist types strings on a HID keyboard by sending key press and release
+// reports through SendKeyboardReport, so it works with both boot keyboard
+// and composite report descriptors.
+//
+// Letters are typed with Shift inverted while the host has Caps Lock on
+// (see HID.LEDs), so the host receives the intended case.
+//
+// A Typist is not safe for concurrent use.
+type Typist struct {
+	hid      *HID
+	layout   Layout
+	interval time.Duration
+	report   KeyboardReport
+	strokes  [MaxKeystrokes]Keystroke
+}
+
+// NewTypist creates a Typist that types on h using layout. The layout must
+// match the keyboard layout configured on the host.
+func NewTypist(h *HID, layout Layout) *Typist {
+	return &Typist{
+		hid:    h,
+		layout: layout,
+	}
+}
+
+// SetLayout sets the keyboard layout.
+func (t *Typist) SetLayout(layout Layout) {
+	t.layout = layout
+}
+
+// SetInterval sets the delay after each report. If d is 0, the polling
+// interval of the keyboard endpoint is used, so the host sees every key
+// press and release.
+func (t *Typist) SetInterval(d time.Duration) {
+	t.interval = d
+}
+
+// Type types s. It stops at the first character that cannot be typed
+// with the current layout and returns ErrUnmappedRune, having typed the
+// characters before it.
+func (t *Typist) Type(ctx context.Context, s string) error {
+	for _, r := range s {
+		if err := t.TypeRune(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TypeRune types a single character.
+func (t *Typist) TypeRune(ctx context.Context, r rune) error {
+	n := t.layout.Keystrokes(r, &t.strokes)
+	if n == 0 {
+		return ErrUnmappedRune
+	}
+
+	// Caps Lock inverts Shift for letters, but not for AltGr levels
+	capsLock := t.hid.LEDs()&LEDCapsLock != 0 &&
+		unicode.ToUpper(r) != unicode.ToLower(r)
+
+	for _, ks := range t.strokes[:n] {
+		if capsLock && ks.Modifiers&ModRightAlt == 0 {
+			ks.Modifiers ^= ModLeftShift
+		}
+		if err := t.Tap(ctx, ks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tap presses and releases a keystroke.
+func (t *Typist) Tap(ctx context.Context, ks Keystroke) error {
+	t.report.Clear()
+	t.report.Modifiers = ks.Modifiers
+	t.report.Keys[0] = ks.Key
+	if err := t.send(ctx); err != nil {
+		return err
+	}
+
+	t.report.Clear()
+	return t.send(ctx)
+}
+
+// send sends the current report and waits for the report interval.
+func (t *Typist) send(ctx context.Context) error {
+	if err := t.hid.SendKeyboardReport(ctx, &t.report); err != nil {
+		return err
+	}
+
+	d := t.interval
+	if d == 0 {
+		d = t.hid.PollInterval()
+	}
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
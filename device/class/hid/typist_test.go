@@ -0,0 +1,156 @@
+package hid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// keyReport returns the boot keyboard report pressing key with modifiers.
+func keyReport(modifiers, key uint8) []byte {
+	return []byte{modifiers, 0, key, 0, 0, 0, 0, 0}
+}
+
+// taps returns the reports that press and release each keystroke.
+func taps(strokes ...Keystroke) [][]byte {
+	var reports [][]byte
+	for _, ks := range strokes {
+		reports = append(reports, keyReport(ks.Modifiers, ks.Key), keyReport(0, KeyNone))
+	}
+	return reports
+}
+
+// checkReports compares the reports sent on the IN endpoint with want.
+func checkReports(t *testing.T, fake *fakeHAL, want [][]byte) {
+	t.Helper()
+	for i, w := range want {
+		if got := fake.nextReport(t); !bytes.Equal(got, w) {
+			t.Errorf("report %d = % X, want % X", i, got, w)
+		}
+	}
+	select {
+	case got := <-fake.in:
+		t.Errorf("unexpected report % X", got)
+	default:
+	}
+}
+
+func TestTypist(t *testing.T) {
+	tests := []struct {
+		name     string
+		layout   Layout
+		capsLock bool
+		s        string
+		want     [][]byte
+	}{
+		{
+			name:   "US",
+			layout: LayoutUS,
+			s:      "aB1!",
+			want: taps(
+				Keystroke{Key: KeyA},
+				Keystroke{ModLeftShift, KeyB},
+				Keystroke{Key: Key1},
+				Keystroke{ModLeftShift, Key1},
+			),
+		},
+		{
+			name:     "US with Caps Lock",
+			layout:   LayoutUS,
+			capsLock: true,
+			s:        "aB1!\n",
+			want: taps(
+				Keystroke{ModLeftShift, KeyA},
+				Keystroke{Key: KeyB},
+				Keystroke{Key: Key1},
+				Keystroke{ModLeftShift, Key1},
+				Keystroke{Key: KeyEnter},
+			),
+		},
+		{
+			name:   "DE dead keys",
+			layout: LayoutDE,
+			s:      "^`z",
+			want: taps(
+				Keystroke{Key: KeyGrave}, Keystroke{Key: KeySpace},
+				Keystroke{ModLeftShift, KeyEqual}, Keystroke{Key: KeySpace},
+				Keystroke{Key: KeyY},
+			),
+		},
+		{
+			// Caps Lock inverts Shift for letters at the base and Shift
+			// levels only; AltGr letters and dead keys are unaffected
+			name:     "DE with Caps Lock",
+			layout:   LayoutDE,
+			capsLock: true,
+			s:        "üÄµ€`",
+			want: taps(
+				Keystroke{ModLeftShift, KeyLeftBrace},
+				Keystroke{Key: KeyQuote},
+				Keystroke{ModRightAlt, KeyM},
+				Keystroke{ModRightAlt, KeyE},
+				Keystroke{ModLeftShift, KeyEqual}, Keystroke{Key: KeySpace},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, fake := startHID(t, KeyboardReportDescriptor, false)
+			if tt.capsLock {
+				if setReport(t, fake, ReportTypeOutput, 0, []byte{LEDCapsLock}) {
+					t.Fatal("SET_REPORT(Output) stalled")
+				}
+			}
+
+			typist := NewTypist(h, tt.layout)
+			typist.SetInterval(time.Microsecond)
+			if err := typist.Type(context.Background(), tt.s); err != nil {
+				t.Fatalf("Type(%q) error = %v", tt.s, err)
+			}
+			checkReports(t, fake, tt.want)
+		})
+	}
+}
+
+func TestTypistUnmappedRune(t *testing.T) {
+	h, fake := startHID(t, KeyboardReportDescriptor, false)
+	typist := NewTypist(h, LayoutUS)
+	typist.SetInterval(time.Microsecond)
+
+	// Characters before the unmapped one are typed
+	if err := typist.Type(context.Background(), "ab€c"); !errors.Is(err, ErrUnmappedRune) {
+		t.Fatalf("Type() error = %v, want %v", err, ErrUnmappedRune)
+	}
+	checkReports(t, fake, taps(Keystroke{Key: KeyA}, Keystroke{Key: KeyB}))
+
+	// The same character types after switching layouts
+	typist.SetLayout(LayoutUK)
+	if err := typist.TypeRune(context.Background(), '€'); err != nil {
+		t.Fatalf("TypeRune() error = %v", err)
+	}
+	checkReports(t, fake, taps(Keystroke{ModRightAlt, Key4}))
+}
+
+func TestTypistComposite(t *testing.T) {
+	h, fake := startHID(t, CompositeReportDescriptor, false)
+	if setReport(t, fake, ReportTypeOutput, ReportIDKeyboard, []byte{ReportIDKeyboard, LEDCapsLock}) {
+		t.Fatal("SET_REPORT(Output) stalled")
+	}
+
+	typist := NewTypist(h, LayoutUS)
+	typist.SetInterval(time.Microsecond)
+	if err := typist.TypeRune(context.Background(), 'x'); err != nil {
+		t.Fatalf("TypeRune() error = %v", err)
+	}
+
+	// Reports carry the keyboard report ID
+	for i, w := range taps(Keystroke{ModLeftShift, KeyX}) {
+		w = append([]byte{ReportIDKeyboard}, w...)
+		if got := fake.nextReport(t); !bytes.Equal(got, w) {
+			t.Errorf("report %d = % X, want % X", i, got, w)
+		}
+	}
+}
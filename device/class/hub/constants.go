@@ -0,0 +1,145 @@
+package hub
+
+// Hub class codes.
+const (
+	ClassHub = 0x09 // Hub Class
+)
+
+// Hub protocol codes (device and interface protocol).
+const (
+	ProtocolFullSpeed = 0x00 // Full-speed hub (no transaction translator)
+	ProtocolSingleTT  = 0x01 // High-speed hub with a single TT
+	ProtocolMultiTT   = 0x02 // High-speed hub with multiple TTs
+)
+
+// Hub descriptor types.
+const (
+	DescriptorTypeHub = 0x29 // Hub descriptor
+)
+
+// Hub class request codes (USB 2.0 Table 11-16).
+const (
+	RequestGetStatus     = 0x00
+	RequestClearFeature  = 0x01
+	RequestSetFeature    = 0x03
+	RequestGetDescriptor = 0x06
+	RequestSetDescriptor = 0x07
+	RequestClearTTBuffer = 0x08
+	RequestResetTT       = 0x09
+	RequestGetTTState    = 0x0A
+	RequestStopTT        = 0x0B
+)
+
+// Hub feature selectors (USB 2.0 Table 11-17).
+const (
+	FeatureCHubLocalPower  = 0
+	FeatureCHubOverCurrent = 1
+)
+
+// Port feature selectors (USB 2.0 Table 11-17).
+const (
+	FeaturePortConnection   = 0
+	FeaturePortEnable       = 1
+	FeaturePortSuspend      = 2
+	FeaturePortOverCurrent  = 3
+	FeaturePortReset        = 4
+	FeaturePortPower        = 8
+	FeaturePortLowSpeed     = 9
+	FeatureCPortConnection  = 16
+	FeatureCPortEnable      = 17
+	FeatureCPortSuspend     = 18
+	FeatureCPortOverCurrent = 19
+	FeatureCPortReset       = 20
+	FeaturePortTest         = 21
+	FeaturePortIndicator    = 22
+)
+
+// Port status bits (wPortStatus, USB 2.0 Table 11-21).
+const (
+	PortStatusConnection  = 1 << 0
+	PortStatusEnable      = 1 << 1
+	PortStatusSuspend     = 1 << 2
+	PortStatusOverCurrent = 1 << 3
+	PortStatusReset       = 1 << 4
+	PortStatusPower       = 1 << 8
+	PortStatusLowSpeed    = 1 << 9
+	PortStatusHighSpeed   = 1 << 10
+	PortStatusTest        = 1 << 11
+	PortStatusIndicator   = 1 << 12
+)
+
+// Port status change bits (wPortChange, USB 2.0 Table 11-22).
+const (
+	PortChangeConnection  = 1 << 0
+	PortChangeEnable      = 1 << 1
+	PortChangeSuspend     = 1 << 2
+	PortChangeOverCurrent = 1 << 3
+	PortChangeReset       = 1 << 4
+)
+
+// Hub status and change bits (wHubStatus/wHubChange, USB 2.0 Tables 11-19, 11-20).
+const (
+	HubStatusLocalPower  = 1 << 0
+	HubStatusOverCurrent = 1 << 1
+
+	HubChangeLocalPower  = 1 << 0
+	HubChangeOverCurrent = 1 << 1
+)
+
+// Hub characteristics bits (wHubCharacteristics).
+const (
+	CharPowerGanged        = 0x0000 // Ganged power switching
+	CharPowerIndividual    = 0x0001 // Individual port power switching
+	CharPowerNone          = 0x0002 // No power switching
+	CharCompound           = 0x0004 // Hub is part of a compound device
+	CharOverCurrentGlobal  = 0x0000 // Global over-current protection
+	CharOverCurrentPerPort = 0x0008 // Individual port over-current protection
+	CharOverCurrentNone    = 0x0010 // No over-current protection
+	CharPortIndicators     = 0x0080 // Port indicators supported
+)
+
+// MaxPorts is the maximum number of downstream ports supported.
+const MaxPorts = 15
+
+// HubDescriptorMaxSize is the size of a hub descriptor for MaxPorts ports.
+const HubDescriptorMaxSize = 7 + 2*((MaxPorts+8)/8)
+
+// HubDescriptor is the hub class descriptor (USB 2.0 Section 11.23.2.1).
+type HubDescriptor struct {
+	NumPorts           uint8  // Number of downstream ports
+	Characteristics    uint16 // Hub characteristics
+	PowerOnToPowerGood uint8  // Power-on to power-good time in 2 ms units
+	ControllerCurrent  uint8  // Maximum hub controller current in mA
+	DeviceRemovable    uint16 // Bitmap of non-removable devices (bit N = port N)
+}
+
+// Size returns the encoded size of the descriptor.
+// The variable-length fields hold one bit per port plus a reserved bit 0.
+func (d *HubDescriptor) Size() int {
+	return 7 + 2*((int(d.NumPorts)+8)/8)
+}
+
+// MarshalTo writes the hub descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *HubDescriptor) MarshalTo(buf []byte) int {
+	size := d.Size()
+	if len(buf) < size {
+		return 0
+	}
+	n := (size - 7) / 2
+	buf[0] = byte(size)
+	buf[1] = DescriptorTypeHub
+	buf[2] = d.NumPorts
+	buf[3] = byte(d.Characteristics)
+	buf[4] = byte(d.Characteristics >> 8)
+	buf[5] = d.PowerOnToPowerGood
+	buf[6] = d.ControllerCurrent
+	for i := 0; i < n; i++ {
+		buf[7+i] = byte(d.DeviceRemovable >> (8 * i))
+		buf[7+n+i] = 0xFF // PortPwrCtrlMask, all bits set for USB 1.0 compatibility
+	}
+	return size
+}
+
+// PortStatusSize is the size of the GET_STATUS response for a hub or port.
+const PortStatusSize = 4
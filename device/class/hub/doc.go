@@ -0,0 +1,63 @@
+// Package hub implements the USB hub class for the softusb device stack.
+//
+// This package lets a softusb device present itself as an external hub that
+// fronts other softusb device stacks attached to its downstream ports,
+// enabling multi-tier bus topologies to be emulated.
+//
+// # Architecture
+//
+// A hub device consists of a single hub interface with:
+//
+//   - An Interrupt IN status change endpoint reporting a bitmap of hub and
+//     port changes (bit 0 = hub, bit N = port N)
+//   - The hub class descriptor, returned by GET_DESCRIPTOR
+//   - Hub and port requests: GET_STATUS, SET_FEATURE and CLEAR_FEATURE
+//
+// Hub class requests are addressed to the device or to a port ("other"
+// recipient) rather than to an interface. [Hub.AttachToInterface] therefore
+// registers the hub with [device.Device.AddRequestHandler] for class
+// requests to the device and other recipients.
+//
+// Child devices are ordinary [device.Stack] instances with their own HAL.
+// Attaching a child to a port sets the port's connection status once the
+// host powers the port, and notifies the host on the status change endpoint.
+// The host then resets and enumerates the child through the child's own
+// link; with the FIFO HAL, the child's bus directory is a subdirectory of
+// the hub's device directory.
+//
+// # Zero-Allocation Design
+//
+// This implementation follows zero-allocation patterns:
+//
+//   - Fixed-size port state array sized for [MaxPorts]
+//   - Fixed-size buffers for the hub descriptor and status responses
+//   - Status changes are coalesced into a single pending bitmap
+//
+// # Usage
+//
+// To create a hub device with a child on port 1:
+//
+//	// Create the hub class driver
+//	h := hub.New(4)
+//
+//	builder := device.NewDeviceBuilder().
+//	    WithVendorProduct(0xCAFE, 0xBABE).
+//	    WithStrings("Manufacturer", "USB Hub", "12345").
+//	    AddConfiguration(1)
+//
+//	// Add hub interface with status change endpoint 0x81
+//	h.ConfigureDevice(builder, 0x81)
+//
+//	dev, _ := builder.Build(ctx)
+//
+//	// Attach hub driver to interface 0 in configuration 1
+//	h.AttachToInterface(dev, 1, 0)
+//
+//	stack := device.NewStack(dev, hal)
+//	h.SetStack(stack)
+//	stack.Start(ctx)
+//
+//	// Start a child device stack and attach it to port 1
+//	childStack.Start(ctx)
+//	h.AttachPort(1, childStack)
+package hub
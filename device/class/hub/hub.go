@@ -0,0 +1,619 @@
+package hub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Default hub descriptor values.
+const (
+	defaultPowerOnToPowerGood = 10  // 20 ms
+	defaultControllerCurrent  = 100 // mA
+)
+
+// portEvent identifies an action to apply to a child device after a port
+// request completes.
+type portEvent uint8
+
+const (
+	portEventNone portEvent = iota
+	portEventReset
+	portEventSuspend
+	portEventResume
+)
+
+// port holds the state of a downstream port.
+type port struct {
+	status uint16
+	change uint16
+	child  *device.Stack
+}
+
+// Hub implements a hub class driver.
+//
+// Hub class requests are addressed to the device or to a port rather than to
+// an interface, so [Hub.AttachToInterface] registers the hub as a
+// device-level request handler for them.
+type Hub struct {
+	// Interface
+	iface *device.Interface
+
+	// Endpoints
+	statusEP *device.Endpoint // Interrupt IN for status change bitmaps
+
+	// Stack reference for data transfer
+	stack *device.Stack
+
+	// Context for status change notifications, cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Hub descriptor
+	descriptor HubDescriptor
+
+	// Downstream ports (1-indexed by port number, stored 0-indexed)
+	ports     [MaxPorts]port
+	hubChange uint16
+
+	// Pending status change bitmap (bit 0 = hub, bit N = port N)
+	pending   uint16
+	notifying bool
+
+	// Callbacks
+	onPortReset func(port int)
+
+	// Buffers (zero-allocation)
+	responseBuf [HubDescriptorMaxSize]byte
+	bitmapBuf   [2]byte
+
+	// State
+	mutex      sync.Mutex
+	configured bool
+}
+
+// New creates a new hub class driver with the given number of downstream
+// ports. The port count is clamped to the range 1 to [MaxPorts].
+func New(numPorts int) *Hub {
+	if numPorts < 1 {
+		numPorts = 1
+	}
+	if numPorts > MaxPorts {
+		numPorts = MaxPorts
+	}
+	return &Hub{
+		descriptor: HubDescriptor{
+			NumPorts:           uint8(numPorts),
+			Characteristics:    CharPowerIndividual | CharOverCurrentPerPort,
+			PowerOnToPowerGood: defaultPowerOnToPowerGood,
+			ControllerCurrent:  defaultControllerCurrent,
+		},
+	}
+}
+
+// SetStack sets the device stack reference for data transfer.
+func (h *Hub) SetStack(stack *device.Stack) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.stack = stack
+}
+
+// SetOnPortReset sets the callback invoked when the host resets a port
+// with an attached device.
+func (h *Hub) SetOnPortReset(cb func(port int)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.onPortReset = cb
+}
+
+// SetRemovable sets whether the device attached to a port is removable.
+// Must be called before the host reads the hub descriptor.
+func (h *Hub) SetRemovable(port int, removable bool) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.validPort(port) {
+		return pkg.ErrInvalidParameter
+	}
+	if removable {
+		h.descriptor.DeviceRemovable &^= 1 << port
+	} else {
+		h.descriptor.DeviceRemovable |= 1 << port
+	}
+	return nil
+}
+
+// Descriptor returns the hub descriptor.
+func (h *Hub) Descriptor() HubDescriptor {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.descriptor
+}
+
+// NumPorts returns the number of downstream ports.
+func (h *Hub) NumPorts() int {
+	return int(h.descriptor.NumPorts)
+}
+
+// PortStatus returns the status and change bits of a downstream port.
+func (h *Hub) PortStatus(port int) (status, change uint16) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.validPort(port) {
+		return 0, 0
+	}
+	p := &h.ports[port-1]
+	return p.status, p.change
+}
+
+// Child returns the device stack attached to a port, or nil if none.
+func (h *Hub) Child(port int) *device.Stack {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.validPort(port) {
+		return nil
+	}
+	return h.ports[port-1].child
+}
+
+// AttachPort attaches a child device stack to a downstream port.
+// If the port is powered, the host is notified of the connection.
+func (h *Hub) AttachPort(port int, child *device.Stack) error {
+	if child == nil {
+		return pkg.ErrInvalidParameter
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.validPort(port) {
+		return pkg.ErrInvalidParameter
+	}
+	p := &h.ports[port-1]
+	if p.child != nil {
+		return pkg.ErrBusy
+	}
+	p.child = child
+
+	pkg.LogDebug(pkg.ComponentDevice, "hub port attached", "port", port)
+
+	if p.status&PortStatusPower != 0 {
+		h.connectLocked(port)
+	}
+	return nil
+}
+
+// DetachPort detaches the child device stack from a downstream port.
+// The host is notified of the disconnection. The child stack is not stopped.
+func (h *Hub) DetachPort(port int) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.validPort(port) {
+		return pkg.ErrInvalidParameter
+	}
+	p := &h.ports[port-1]
+	if p.child == nil {
+		return pkg.ErrNoDevice
+	}
+	p.child = nil
+
+	pkg.LogDebug(pkg.ComponentDevice, "hub port detached", "port", port)
+
+	if p.status&PortStatusConnection != 0 {
+		p.status &^= PortStatusConnection | PortStatusEnable | PortStatusSuspend |
+			PortStatusLowSpeed | PortStatusHighSpeed
+		p.change |= PortChangeConnection
+		h.signalLocked(port)
+	}
+	return nil
+}
+
+// SetOverCurrent sets or clears an over-current condition on a port.
+// An over-current condition removes power from the port.
+func (h *Hub) SetOverCurrent(port int, active bool) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.validPort(port) {
+		return pkg.ErrInvalidParameter
+	}
+	p := &h.ports[port-1]
+	if active {
+		p.status &^= PortStatusPower | PortStatusConnection | PortStatusEnable |
+			PortStatusSuspend | PortStatusLowSpeed | PortStatusHighSpeed
+		p.status |= PortStatusOverCurrent
+	} else {
+		p.status &^= PortStatusOverCurrent
+	}
+	p.change |= PortChangeOverCurrent
+	h.signalLocked(port)
+	return nil
+}
+
+// Init initializes the class driver for the given interface.
+func (h *Hub) Init(iface *device.Interface) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.iface = iface
+
+	// Find status change endpoint
+	for _, ep := range iface.Endpoints() {
+		if ep.IsInterrupt() && ep.IsIn() {
+			h.statusEP = ep
+			break
+		}
+	}
+
+	if h.statusEP == nil {
+		return pkg.ErrInvalidEndpoint
+	}
+
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.configured = true
+	pkg.LogDebug(pkg.ComponentDevice, "hub configured",
+		"statusEP", h.statusEP.Address,
+		"ports", h.descriptor.NumPorts)
+
+	return nil
+}
+
+// HandleSetup processes class-specific SETUP requests.
+// Hub requests are not directed at the interface and are handled by the
+// request handler registered by [Hub.AttachToInterface], so this always
+// reports unhandled.
+func (h *Hub) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+// SetAlternate handles alternate setting changes.
+func (h *Hub) SetAlternate(iface *device.Interface, alt uint8) error {
+	pkg.LogDebug(pkg.ComponentDevice, "hub alternate setting",
+		"interface", iface.Number,
+		"alt", alt)
+	return nil
+}
+
+// Close releases resources held by the class driver.
+func (h *Hub) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.cancel != nil {
+		h.cancel()
+	}
+	h.iface = nil
+	h.statusEP = nil
+	h.stack = nil
+	h.ctx, h.cancel = nil, nil
+	h.configured = false
+
+	return nil
+}
+
+// ConfigureDevice adds the hub interface and status change endpoint to a
+// device builder.
+func (h *Hub) ConfigureDevice(builder *device.DeviceBuilder, statusEPAddr uint8) *device.DeviceBuilder {
+	builder.AddInterface(ClassHub, 0x00, ProtocolFullSpeed)
+	builder.AddEndpoint(statusEPAddr|device.EndpointDirectionIn, device.EndpointTypeInterrupt,
+		uint16(h.bitmapSize()))
+	return builder
+}
+
+// AttachToInterface attaches this class driver to the hub interface, sets
+// the device class to hub, as required for hub devices, and registers the
+// hub as the handler of hub class requests to the device and its ports.
+// configValue is the configuration value (e.g., 1), ifaceNum is the interface number
+// within that configuration.
+func (h *Hub) AttachToInterface(dev *device.Device, configValue, ifaceNum uint8) error {
+	config := dev.GetConfiguration(configValue)
+	if config == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	iface := config.GetInterface(ifaceNum)
+	if iface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	dev.Descriptor.DeviceClass = ClassHub
+	dev.Descriptor.DeviceSubClass = 0x00
+	dev.Descriptor.DeviceProtocol = iface.Protocol
+
+	handler := device.RequestHandlerFunc(h.handleSetup)
+	if err := dev.AddRequestHandler(device.RequestTypeClass, device.RequestRecipientDevice, handler); err != nil {
+		return err
+	}
+	if err := dev.AddRequestHandler(device.RequestTypeClass, device.RequestRecipientOther, handler); err != nil {
+		return err
+	}
+
+	return iface.SetClassDriver(h)
+}
+
+// validPort returns true if port is a valid downstream port number.
+func (h *Hub) validPort(port int) bool {
+	return port >= 1 && port <= int(h.descriptor.NumPorts)
+}
+
+// bitmapSize returns the size of the status change bitmap in bytes.
+func (h *Hub) bitmapSize() int {
+	return (int(h.descriptor.NumPorts) + 8) / 8
+}
+
+// connectLocked reports the attached child of a powered port as connected.
+func (h *Hub) connectLocked(port int) {
+	p := &h.ports[port-1]
+	if p.child == nil || p.status&PortStatusConnection != 0 {
+		return
+	}
+
+	p.status |= PortStatusConnection
+	switch p.child.Speed() {
+	case device.SpeedLow:
+		p.status |= PortStatusLowSpeed
+	case device.SpeedHigh:
+		p.status |= PortStatusHighSpeed
+	}
+	p.change |= PortChangeConnection
+	h.signalLocked(port)
+}
+
+// signalLocked records a status change for the hub (bit 0) or a port and
+// starts delivering the bitmap on the status change endpoint.
+func (h *Hub) signalLocked(bit int) {
+	h.pending |= 1 << bit
+	if h.notifying || !h.configured || h.stack == nil || h.ctx == nil {
+		return
+	}
+	h.notifying = true
+	go h.notifyLoop()
+}
+
+// notifyLoop writes pending status change bitmaps to the host until none
+// remain. Changes that arrive while a write is in progress are coalesced.
+func (h *Hub) notifyLoop() {
+	for {
+		h.mutex.Lock()
+		bitmap := h.pending
+		stack := h.stack
+		ep := h.statusEP
+		ctx := h.ctx
+		if bitmap == 0 || stack == nil || ep == nil {
+			h.notifying = false
+			h.mutex.Unlock()
+			return
+		}
+		h.pending = 0
+		n := h.bitmapSize()
+		h.bitmapBuf[0] = byte(bitmap)
+		h.bitmapBuf[1] = byte(bitmap >> 8)
+		h.mutex.Unlock()
+
+		if _, err := stack.Write(ctx, ep, h.bitmapBuf[:n]); err != nil {
+			pkg.LogDebug(pkg.ComponentDevice, "hub status change write failed",
+				"error", err)
+			h.mutex.Lock()
+			h.pending |= bitmap
+			h.notifying = false
+			h.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// handleSetup answers hub class requests directed at the hub device or one
+// of its ports.
+func (h *Hub) handleSetup(setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+	n, err := h.handleRequest(setup)
+	if err != nil {
+		return nil, true, err
+	}
+	return h.responseBuf[:n], true, nil
+}
+
+// handleRequest processes a hub class request and returns the length of the
+// response written to responseBuf for IN requests.
+func (h *Hub) handleRequest(setup *device.SetupPacket) (int, error) {
+	h.mutex.Lock()
+
+	var n int
+	var err error
+	if setup.IsDeviceRecipient() {
+		n, err = h.handleHubRequest(setup)
+		h.mutex.Unlock()
+		return n, err
+	}
+
+	num := int(setup.Index & 0xFF)
+	if !h.validPort(num) {
+		h.mutex.Unlock()
+		return 0, pkg.ErrInvalidRequest
+	}
+
+	event := portEventNone
+	switch setup.Request {
+	case RequestGetStatus:
+		p := &h.ports[num-1]
+		n = putStatus(h.responseBuf[:], p.status, p.change)
+	case RequestSetFeature:
+		event, err = h.setPortFeature(num, setup.Value, setup.Index>>8)
+	case RequestClearFeature:
+		event, err = h.clearPortFeature(num, setup.Value)
+	default:
+		err = pkg.ErrInvalidRequest
+	}
+
+	child := h.ports[num-1].child
+	resetCb := h.onPortReset
+	h.mutex.Unlock()
+
+	// Apply side effects outside the lock, as device callbacks may call back
+	// into the hub
+	switch event {
+	case portEventReset:
+		if resetCb != nil {
+			resetCb(num)
+		}
+	case portEventSuspend:
+		if child != nil {
+			child.Device().Suspend()
+		}
+	case portEventResume:
+		if child != nil {
+			child.Device().Resume()
+		}
+	}
+
+	return n, err
+}
+
+// handleHubRequest processes a hub class request directed at the hub.
+// Must be called with the mutex held.
+func (h *Hub) handleHubRequest(setup *device.SetupPacket) (int, error) {
+	switch setup.Request {
+	case RequestGetDescriptor:
+		if setup.Value>>8 != DescriptorTypeHub {
+			return 0, pkg.ErrInvalidRequest
+		}
+		n := h.descriptor.MarshalTo(h.responseBuf[:])
+		if n == 0 {
+			return 0, pkg.ErrBufferTooSmall
+		}
+		return n, nil
+
+	case RequestGetStatus:
+		// Local power supply good, no over-current
+		return putStatus(h.responseBuf[:], 0, h.hubChange), nil
+
+	case RequestClearFeature:
+		switch setup.Value {
+		case FeatureCHubLocalPower:
+			h.hubChange &^= HubChangeLocalPower
+		case FeatureCHubOverCurrent:
+			h.hubChange &^= HubChangeOverCurrent
+		default:
+			return 0, pkg.ErrInvalidRequest
+		}
+		return 0, nil
+
+	case RequestClearTTBuffer, RequestResetTT, RequestGetTTState, RequestStopTT:
+		// Full-speed hubs have no transaction translator
+		return 0, pkg.ErrNotSupported
+	}
+
+	return 0, pkg.ErrInvalidRequest
+}
+
+// setPortFeature handles SET_FEATURE for a port.
+// Must be called with the mutex held.
+func (h *Hub) setPortFeature(num int, feature, selector uint16) (portEvent, error) {
+	p := &h.ports[num-1]
+
+	switch feature {
+	case FeaturePortPower:
+		if p.status&PortStatusOverCurrent != 0 {
+			return portEventNone, nil
+		}
+		p.status |= PortStatusPower
+		h.connectLocked(num)
+
+	case FeaturePortReset:
+		if p.status&PortStatusConnection == 0 {
+			return portEventNone, nil
+		}
+		// Reset completes immediately; the downstream device observes the
+		// reset through its own link
+		p.status |= PortStatusEnable
+		p.status &^= PortStatusSuspend
+		p.change |= PortChangeReset
+		h.signalLocked(num)
+		pkg.LogDebug(pkg.ComponentDevice, "hub port reset", "port", num)
+		return portEventReset, nil
+
+	case FeaturePortSuspend:
+		if p.status&PortStatusEnable == 0 || p.status&PortStatusSuspend != 0 {
+			return portEventNone, nil
+		}
+		p.status |= PortStatusSuspend
+		return portEventSuspend, nil
+
+	case FeaturePortTest:
+		p.status |= PortStatusTest
+
+	case FeaturePortIndicator:
+		if selector != 0 {
+			p.status |= PortStatusIndicator
+		} else {
+			p.status &^= PortStatusIndicator
+		}
+
+	case FeaturePortEnable:
+		// Ports are enabled only by reset
+
+	default:
+		return portEventNone, pkg.ErrInvalidRequest
+	}
+
+	return portEventNone, nil
+}
+
+// clearPortFeature handles CLEAR_FEATURE for a port.
+// Must be called with the mutex held.
+func (h *Hub) clearPortFeature(num int, feature uint16) (portEvent, error) {
+	p := &h.ports[num-1]
+
+	switch feature {
+	case FeaturePortEnable:
+		p.status &^= PortStatusEnable | PortStatusSuspend
+
+	case FeaturePortSuspend:
+		if p.status&PortStatusSuspend == 0 {
+			return portEventNone, nil
+		}
+		p.status &^= PortStatusSuspend
+		p.change |= PortChangeSuspend
+		h.signalLocked(num)
+		return portEventResume, nil
+
+	case FeaturePortPower:
+		p.status &^= PortStatusPower | PortStatusConnection | PortStatusEnable |
+			PortStatusSuspend | PortStatusLowSpeed | PortStatusHighSpeed
+
+	case FeaturePortIndicator:
+		p.status &^= PortStatusIndicator
+
+	case FeatureCPortConnection:
+		p.change &^= PortChangeConnection
+	case FeatureCPortEnable:
+		p.change &^= PortChangeEnable
+	case FeatureCPortSuspend:
+		p.change &^= PortChangeSuspend
+	case FeatureCPortOverCurrent:
+		p.change &^= PortChangeOverCurrent
+	case FeatureCPortReset:
+		p.change &^= PortChangeReset
+
+	default:
+		return portEventNone, pkg.ErrInvalidRequest
+	}
+
+	return portEventNone, nil
+}
+
+// putStatus writes a status/change pair in wire format.
+func putStatus(buf []byte, status, change uint16) int {
+	buf[0] = byte(status)
+	buf[1] = byte(status >> 8)
+	buf[2] = byte(change)
+	buf[3] = byte(change >> 8)
+	return PortStatusSize
+}
+
+// Compile-time interface check
+var _ device.ClassDriver = (*Hub)(nil)
@@ -0,0 +1,377 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/device/hal"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Port and status endpoint used by the tests.
+const (
+	testPort     = 1
+	testStatusEP = 0x81
+)
+
+// ep0Result is the outcome of a control transfer seen by fakeHAL.
+type ep0Result struct {
+	data    []byte // IN data stage, nil for OUT requests
+	stalled bool
+}
+
+// fakeHAL is a device HAL that feeds SETUP packets to the stack and reports
+// how each control transfer completed.
+type fakeHAL struct {
+	speed   hal.Speed
+	setups  chan hal.SetupPacket
+	results chan ep0Result
+	status  chan []byte // Writes to the status change endpoint
+}
+
+func newFakeHAL(speed hal.Speed) *fakeHAL {
+	return &fakeHAL{
+		speed:   speed,
+		setups:  make(chan hal.SetupPacket, 1),
+		results: make(chan ep0Result, 1),
+		status:  make(chan []byte, 8),
+	}
+}
+
+func (f *fakeHAL) Init(ctx context.Context) error                       { return nil }
+func (f *fakeHAL) Start() error                                         { return nil }
+func (f *fakeHAL) Stop() error                                          { return nil }
+func (f *fakeHAL) SetAddress(address uint8) error                       { return nil }
+func (f *fakeHAL) ConfigureEndpoints(eps []hal.EndpointConfig) error    { return nil }
+func (f *fakeHAL) ReadEP0(ctx context.Context, buf []byte) (int, error) { return 0, nil }
+func (f *fakeHAL) Read(ctx context.Context, address uint8, buf []byte) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+func (f *fakeHAL) Stall(address uint8) error             { return nil }
+func (f *fakeHAL) ClearStall(address uint8) error        { return nil }
+func (f *fakeHAL) IsConnected() bool                     { return true }
+func (f *fakeHAL) GetSpeed() hal.Speed                   { return f.speed }
+func (f *fakeHAL) WaitConnect(ctx context.Context) error { return nil }
+func (f *fakeHAL) WaitDisconnect(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeHAL) ReadSetup(ctx context.Context, out *hal.SetupPacket) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case *out = <-f.setups:
+		return nil
+	}
+}
+
+func (f *fakeHAL) WriteEP0(ctx context.Context, data []byte) error {
+	f.results <- ep0Result{data: append([]byte{}, data...)}
+	return nil
+}
+
+func (f *fakeHAL) StallEP0() error {
+	f.results <- ep0Result{stalled: true}
+	return nil
+}
+
+func (f *fakeHAL) AckEP0() error {
+	f.results <- ep0Result{}
+	return nil
+}
+
+func (f *fakeHAL) Write(ctx context.Context, address uint8, data []byte) (int, error) {
+	if address == testStatusEP {
+		f.status <- append([]byte{}, data...)
+	}
+	return len(data), nil
+}
+
+// control sends a SETUP packet and waits for the control transfer to
+// complete.
+func (f *fakeHAL) control(t *testing.T, requestType, request uint8, value, index, length uint16) ep0Result {
+	t.Helper()
+	f.setups <- hal.SetupPacket{
+		RequestType: requestType,
+		Request:     request,
+		Value:       value,
+		Index:       index,
+		Length:      length,
+	}
+	select {
+	case r := <-f.results:
+		return r
+	case <-time.After(time.Second):
+		t.Fatalf("request 0x%02X 0x%02X timed out", requestType, request)
+		return ep0Result{}
+	}
+}
+
+// portStatus reads the status and change bits of a port with GET_STATUS.
+func (f *fakeHAL) portStatus(t *testing.T, port int) (status, change uint16) {
+	t.Helper()
+	r := f.control(t, device.RequestDirectionDeviceToHost|device.RequestTypeClass|device.RequestRecipientOther,
+		RequestGetStatus, 0, uint16(port), PortStatusSize)
+	if r.stalled || len(r.data) != PortStatusSize {
+		t.Fatalf("GET_STATUS(port %d) = %+v", port, r)
+	}
+	return uint16(r.data[0]) | uint16(r.data[1])<<8, uint16(r.data[2]) | uint16(r.data[3])<<8
+}
+
+// setPortFeature sends SET_FEATURE to a port and reports whether it stalled.
+func (f *fakeHAL) setPortFeature(t *testing.T, port int, feature uint16) bool {
+	t.Helper()
+	return f.control(t, device.RequestTypeClass|device.RequestRecipientOther,
+		RequestSetFeature, feature, uint16(port), 0).stalled
+}
+
+// clearPortFeature sends CLEAR_FEATURE to a port and reports whether it
+// stalled.
+func (f *fakeHAL) clearPortFeature(t *testing.T, port int, feature uint16) bool {
+	t.Helper()
+	return f.control(t, device.RequestTypeClass|device.RequestRecipientOther,
+		RequestClearFeature, feature, uint16(port), 0).stalled
+}
+
+// nextBitmap waits for a status change bitmap on the status endpoint.
+func (f *fakeHAL) nextBitmap(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case b := <-f.status:
+		return b
+	case <-time.After(time.Second):
+		t.Fatal("no status change bitmap")
+		return nil
+	}
+}
+
+// startHub starts a configured hub with the given number of ports.
+func startHub(t *testing.T, numPorts int) (*Hub, *fakeHAL) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	h := New(numPorts)
+	builder := device.NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5680).
+		AddConfiguration(1)
+	h.ConfigureDevice(builder, testStatusEP&^device.EndpointDirectionIn)
+	dev, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if err := h.AttachToInterface(dev, 1, 0); err != nil {
+		t.Fatalf("AttachToInterface() error = %v", err)
+	}
+
+	dev.Reset()
+	dev.SetAddress(1)
+
+	fake := newFakeHAL(hal.SpeedFull)
+	stack := device.NewStack(dev, fake)
+	h.SetStack(stack)
+	if err := stack.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { stack.Stop() })
+
+	if r := fake.control(t, device.RequestTypeStandard|device.RequestRecipientDevice,
+		device.RequestSetConfiguration, 1, 0, 0); r.stalled {
+		t.Fatal("SET_CONFIGURATION stalled")
+	}
+	return h, fake
+}
+
+// newChild returns an unstarted device stack for a downstream device.
+func newChild(t *testing.T, speed hal.Speed) *device.Stack {
+	t.Helper()
+	dev, err := device.NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	return device.NewStack(dev, newFakeHAL(speed))
+}
+
+func TestHubPortStateMachine(t *testing.T) {
+	h, fake := startHub(t, 2)
+
+	var resets []int
+	h.SetOnPortReset(func(port int) { resets = append(resets, port) })
+
+	// An attached device is not connected until the port is powered
+	child := newChild(t, hal.SpeedLow)
+	if err := h.AttachPort(testPort, child); err != nil {
+		t.Fatalf("AttachPort() error = %v", err)
+	}
+	if status, change := fake.portStatus(t, testPort); status != 0 || change != 0 {
+		t.Fatalf("unpowered port = 0x%04X/0x%04X, want 0/0", status, change)
+	}
+
+	if fake.setPortFeature(t, testPort, FeaturePortPower) {
+		t.Fatal("SET_FEATURE(PORT_POWER) stalled")
+	}
+	if b := fake.nextBitmap(t); !bytes.Equal(b, []byte{1 << testPort}) {
+		t.Errorf("bitmap = % X, want %02X", b, 1<<testPort)
+	}
+	status, change := fake.portStatus(t, testPort)
+	if want := uint16(PortStatusPower | PortStatusConnection | PortStatusLowSpeed); status != want ||
+		change != PortChangeConnection {
+		t.Fatalf("powered port = 0x%04X/0x%04X, want 0x%04X/0x%04X", status, change, want, PortChangeConnection)
+	}
+
+	// Change bits stay set until cleared
+	if fake.clearPortFeature(t, testPort, FeatureCPortConnection) {
+		t.Fatal("CLEAR_FEATURE(C_PORT_CONNECTION) stalled")
+	}
+	if _, change := fake.portStatus(t, testPort); change != 0 {
+		t.Errorf("change after clear = 0x%04X, want 0", change)
+	}
+
+	// A port is suspended only once it is enabled by a reset
+	fake.setPortFeature(t, testPort, FeaturePortSuspend)
+	if status, _ := fake.portStatus(t, testPort); status&PortStatusSuspend != 0 {
+		t.Error("disabled port suspended")
+	}
+	fake.setPortFeature(t, testPort, FeaturePortEnable)
+	if status, _ := fake.portStatus(t, testPort); status&PortStatusEnable != 0 {
+		t.Error("port enabled without a reset")
+	}
+
+	if fake.setPortFeature(t, testPort, FeaturePortReset) {
+		t.Fatal("SET_FEATURE(PORT_RESET) stalled")
+	}
+	fake.nextBitmap(t)
+	status, change = fake.portStatus(t, testPort)
+	if status&PortStatusEnable == 0 || change != PortChangeReset {
+		t.Errorf("reset port = 0x%04X/0x%04X, want enabled with C_PORT_RESET", status, change)
+	}
+	if len(resets) != 1 || resets[0] != testPort {
+		t.Errorf("reset callbacks = %v, want [%d]", resets, testPort)
+	}
+	fake.clearPortFeature(t, testPort, FeatureCPortReset)
+
+	fake.setPortFeature(t, testPort, FeaturePortSuspend)
+	if status, _ := fake.portStatus(t, testPort); status&PortStatusSuspend == 0 {
+		t.Error("port not suspended")
+	}
+	if !child.Device().IsSuspended() {
+		t.Error("child device not suspended")
+	}
+
+	// Resume completes immediately and reports C_PORT_SUSPEND
+	fake.clearPortFeature(t, testPort, FeaturePortSuspend)
+	fake.nextBitmap(t)
+	status, change = fake.portStatus(t, testPort)
+	if status&PortStatusSuspend != 0 || change != PortChangeSuspend {
+		t.Errorf("resumed port = 0x%04X/0x%04X, want C_PORT_SUSPEND", status, change)
+	}
+	if child.Device().IsSuspended() {
+		t.Error("child device still suspended")
+	}
+	fake.clearPortFeature(t, testPort, FeatureCPortSuspend)
+
+	// Detaching leaves the port powered and reports the disconnection
+	if err := h.DetachPort(testPort); err != nil {
+		t.Fatalf("DetachPort() error = %v", err)
+	}
+	fake.nextBitmap(t)
+	status, change = fake.portStatus(t, testPort)
+	if status != PortStatusPower || change != PortChangeConnection {
+		t.Errorf("detached port = 0x%04X/0x%04X, want 0x%04X/0x%04X",
+			status, change, PortStatusPower, PortChangeConnection)
+	}
+	if err := h.DetachPort(testPort); err != pkg.ErrNoDevice {
+		t.Errorf("DetachPort(empty) error = %v, want %v", err, pkg.ErrNoDevice)
+	}
+}
+
+func TestHubOverCurrent(t *testing.T) {
+	h, fake := startHub(t, 2)
+
+	if err := h.AttachPort(testPort, newChild(t, hal.SpeedFull)); err != nil {
+		t.Fatalf("AttachPort() error = %v", err)
+	}
+	fake.setPortFeature(t, testPort, FeaturePortPower)
+	fake.nextBitmap(t)
+	fake.clearPortFeature(t, testPort, FeatureCPortConnection)
+
+	// Over-current removes power, and power cannot be restored until the
+	// condition clears
+	if err := h.SetOverCurrent(testPort, true); err != nil {
+		t.Fatalf("SetOverCurrent() error = %v", err)
+	}
+	fake.nextBitmap(t)
+	status, change := fake.portStatus(t, testPort)
+	if status != PortStatusOverCurrent || change != PortChangeOverCurrent {
+		t.Errorf("over-current port = 0x%04X/0x%04X, want 0x%04X/0x%04X",
+			status, change, PortStatusOverCurrent, PortChangeOverCurrent)
+	}
+	fake.setPortFeature(t, testPort, FeaturePortPower)
+	if status, _ := fake.portStatus(t, testPort); status&PortStatusPower != 0 {
+		t.Error("port powered during over-current")
+	}
+
+	h.SetOverCurrent(testPort, false)
+	fake.nextBitmap(t)
+	fake.clearPortFeature(t, testPort, FeatureCPortOverCurrent)
+	fake.setPortFeature(t, testPort, FeaturePortPower)
+	fake.nextBitmap(t)
+	status, change = fake.portStatus(t, testPort)
+	if status != PortStatusPower|PortStatusConnection || change != PortChangeConnection {
+		t.Errorf("repowered port = 0x%04X/0x%04X, want connected", status, change)
+	}
+
+	// Removing power disconnects the device without a change bit
+	fake.clearPortFeature(t, testPort, FeatureCPortConnection)
+	fake.clearPortFeature(t, testPort, FeaturePortPower)
+	if status, change := fake.portStatus(t, testPort); status != 0 || change != 0 {
+		t.Errorf("unpowered port = 0x%04X/0x%04X, want 0/0", status, change)
+	}
+}
+
+func TestHubRequests(t *testing.T) {
+	_, fake := startHub(t, 2)
+
+	in := uint8(device.RequestDirectionDeviceToHost | device.RequestTypeClass)
+	r := fake.control(t, in|device.RequestRecipientDevice, RequestGetDescriptor,
+		DescriptorTypeHub<<8, 0, HubDescriptorMaxSize)
+	if r.stalled || len(r.data) != 9 || r.data[1] != DescriptorTypeHub || r.data[2] != 2 {
+		t.Errorf("GET_DESCRIPTOR(hub) = %+v", r)
+	}
+	r = fake.control(t, in|device.RequestRecipientDevice, RequestGetStatus, 0, 0, PortStatusSize)
+	if r.stalled || !bytes.Equal(r.data, []byte{0, 0, 0, 0}) {
+		t.Errorf("GET_STATUS(hub) = %+v", r)
+	}
+
+	stalls := []struct {
+		name        string
+		requestType uint8
+		request     uint8
+		value       uint16
+		index       uint16
+		length      uint16
+	}{
+		{"port 0", in | device.RequestRecipientOther, RequestGetStatus, 0, 0, PortStatusSize},
+		{"port past end", in | device.RequestRecipientOther, RequestGetStatus, 0, 3, PortStatusSize},
+		{"unknown port feature", device.RequestTypeClass | device.RequestRecipientOther, RequestSetFeature, 0xFF, testPort, 0},
+		{"clear port connection", device.RequestTypeClass | device.RequestRecipientOther, RequestClearFeature, FeaturePortConnection, testPort, 0},
+		{"unknown hub feature", device.RequestTypeClass | device.RequestRecipientDevice, RequestClearFeature, 0xFF, 0, 0},
+		{"other descriptor", in | device.RequestRecipientDevice, RequestGetDescriptor, 0x0100, 0, 18},
+		{"transaction translator", device.RequestTypeClass | device.RequestRecipientDevice, RequestResetTT, 0, 1, 0},
+	}
+	for _, tt := range stalls {
+		t.Run(tt.name, func(t *testing.T) {
+			if r := fake.control(t, tt.requestType, tt.request, tt.value, tt.index, tt.length); !r.stalled {
+				t.Errorf("request not stalled: %+v", r)
+			}
+		})
+	}
+}
@@ -17,84 +17,141 @@ func (m *MSC) handleSCSICommand(ctx context.Context, cbw *CommandBlockWrapper) (
 		"lun", cbw.LUN)
 
 	// Check LUN
-	if cbw.LUN > m.maxLUN {
-		m.setSense(SenseIllegalRequest, ASCInvalidFieldInCDB, 0)
-		return CSWStatusFailed, cbw.DataTransferLength
+	m.mutex.RLock()
+	maxLUN := m.maxLUN
+	m.mutex.RUnlock()
+	if cbw.LUN > maxLUN {
+		return m.handleUnsupportedLUN(ctx, cbw)
 	}
+	lu := &m.luns[cbw.LUN]
 
 	// Dispatch to command handler
 	switch opcode {
 	case SCSITestUnitReady:
-		return m.handleTestUnitReady(cbw)
+		return m.handleTestUnitReady(lu, cbw)
 
 	case SCSIRequestSense:
-		return m.handleRequestSense(ctx, cbw)
+		return m.handleRequestSense(ctx, lu, cbw)
 
 	case SCSIInquiry:
-		return m.handleInquiry(ctx, cbw)
+		return m.handleInquiry(ctx, lu, cbw)
 
 	case SCSIReadCapacity10:
-		return m.handleReadCapacity10(ctx, cbw)
+		return m.handleReadCapacity10(ctx, lu, cbw)
 
 	case SCSIRead10:
-		return m.handleRead10(ctx, cbw)
+		return m.handleRead10(ctx, lu, cbw)
+
+	case SCSIRead12:
+		return m.handleRead12(ctx, lu, cbw)
+
+	case SCSIRead16:
+		return m.handleRead16(ctx, lu, cbw)
 
 	case SCSIWrite10:
-		return m.handleWrite10(ctx, cbw)
+		return m.handleWrite10(ctx, lu, cbw)
+
+	case SCSIWrite12:
+		return m.handleWrite12(ctx, lu, cbw)
+
+	case SCSIWrite16:
+		return m.handleWrite16(ctx, lu, cbw)
+
+	case SCSIWriteSame10:
+		return m.handleWriteSame10(ctx, lu, cbw)
+
+	case SCSIWriteSame16:
+		return m.handleWriteSame16(ctx, lu, cbw)
+
+	case SCSIUnmap:
+		return m.handleUnmap(ctx, lu, cbw)
 
 	case SCSIModeSense6:
-		return m.handleModeSense6(ctx, cbw)
+		return m.handleModeSense6(ctx, lu, cbw)
+
+	case SCSIModeSense10:
+		return m.handleModeSense10(ctx, lu, cbw)
 
 	case SCSIPreventAllowRemoval:
-		return m.handlePreventAllowRemoval(cbw)
+		return m.handlePreventAllowRemoval(lu, cbw)
 
 	case SCSIStartStopUnit:
-		return m.handleStartStopUnit(cbw)
+		return m.handleStartStopUnit(lu, cbw)
 
 	case SCSISynchronizeCache10:
-		return m.handleSynchronizeCache10(cbw)
+		return m.handleSynchronizeCache10(lu, cbw)
 
 	case SCSIVerify10:
-		return m.handleVerify10(cbw)
+		return m.handleVerify10(lu, cbw)
 
 	case SCSIReadFormatCapacities:
-		return m.handleReadFormatCapacities(ctx, cbw)
+		return m.handleReadFormatCapacities(ctx, lu, cbw)
 
 	case SCSIServiceActionIn16:
 		// Check service action
 		serviceAction := cbw.CB[1] & 0x1F
 		if serviceAction == ServiceActionReadCapacity16 {
-			return m.handleReadCapacity16(ctx, cbw)
+			return m.handleReadCapacity16(ctx, lu, cbw)
 		}
 		fallthrough
 
 	default:
 		pkg.LogWarn(pkg.ComponentDevice, "unsupported SCSI command",
 			"opcode", opcode)
-		m.setSense(SenseIllegalRequest, ASCInvalidCommand, 0)
+		m.setSense(lu, SenseIllegalRequest, ASCInvalidCommand, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+}
+
+// handleUnsupportedLUN processes a command addressed to a LUN above the
+// maximum LUN. INQUIRY reports that no logical unit is present, REQUEST
+// SENSE reports LOGICAL UNIT NOT SUPPORTED, and other commands fail.
+func (m *MSC) handleUnsupportedLUN(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
+	var n int
+	switch cbw.CB[0] {
+	case SCSIInquiry:
+		resp := InquiryResponse{DeviceType: InquiryNoLogicalUnit}
+		n = min(resp.MarshalTo(m.dataBuf[:]), int(parseU16BE(cbw.CB[:], 3)))
+
+	case SCSIRequestSense:
+		allocLength := int(cbw.CB[4])
+		if allocLength == 0 {
+			allocLength = 18
+		}
+		resp := NewRequestSenseResponse(SenseIllegalRequest, ASCLogicalUnitNotSupported, 0)
+		n = min(resp.MarshalTo(m.dataBuf[:]), allocLength)
+
+	default:
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	if err := m.sendData(ctx, m.dataBuf[:n]); err != nil {
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
+	return CSWStatusGood, cbw.DataTransferLength - uint32(n)
 }
 
 // handleTestUnitReady processes TEST UNIT READY command.
-func (m *MSC) handleTestUnitReady(cbw *CommandBlockWrapper) (uint8, uint32) {
-	if !m.storage.IsPresent() {
-		m.setSense(SenseNotReady, ASCMediumNotPresent, 0)
+func (m *MSC) handleTestUnitReady(lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
 		return CSWStatusFailed, 0
 	}
 
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	m.setSense(lu, SenseNoSense, ASCNoAdditionalInfo, 0)
 	return CSWStatusGood, 0
 }
 
 // handleRequestSense processes REQUEST SENSE command.
-func (m *MSC) handleRequestSense(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
+func (m *MSC) handleRequestSense(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
 	allocLength := cbw.CB[4]
 	if allocLength == 0 {
 		allocLength = 18
 	}
 
-	resp := NewRequestSenseResponse(m.senseKey, m.asc, m.ascq)
+	m.mutex.RLock()
+	resp := NewRequestSenseResponse(lu.senseKey, lu.asc, lu.ascq)
+	m.mutex.RUnlock()
 	n := resp.MarshalTo(m.senseBuf[:])
 
 	// Send data
@@ -108,20 +165,36 @@ func (m *MSC) handleRequestSense(ctx context.Context, cbw *CommandBlockWrapper)
 	}
 
 	// Clear sense data after successful REQUEST SENSE
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	m.setSense(lu, SenseNoSense, ASCNoAdditionalInfo, 0)
 
 	residue := cbw.DataTransferLength - uint32(sendLen)
 	return CSWStatusGood, residue
 }
 
 // handleInquiry processes INQUIRY command.
-func (m *MSC) handleInquiry(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
+func (m *MSC) handleInquiry(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	evpd := cbw.CB[1]&InquiryEVPD != 0
+	pageCode := cbw.CB[2]
+	if !evpd && pageCode != 0 {
+		m.setSense(lu, SenseIllegalRequest, ASCInvalidFieldInCDB, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
 	allocLength := parseU16BE(cbw.CB[:], 3)
 	if allocLength == 0 {
 		return CSWStatusGood, 0
 	}
 
-	n := m.inquiry.MarshalTo(m.dataBuf[:])
+	var n int
+	if evpd {
+		n = lu.marshalVPD(pageCode, m.dataBuf[:])
+		if n == 0 {
+			m.setSense(lu, SenseIllegalRequest, ASCInvalidFieldInCDB, 0)
+			return CSWStatusFailed, cbw.DataTransferLength
+		}
+	} else {
+		n = lu.inquiry.MarshalTo(m.dataBuf[:])
+	}
 
 	// Send data
 	sendLen := int(allocLength)
@@ -130,7 +203,7 @@ func (m *MSC) handleInquiry(ctx context.Context, cbw *CommandBlockWrapper) (uint
 	}
 
 	if err := m.sendData(ctx, m.dataBuf[:sendLen]); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
@@ -139,14 +212,14 @@ func (m *MSC) handleInquiry(ctx context.Context, cbw *CommandBlockWrapper) (uint
 }
 
 // handleReadCapacity10 processes READ CAPACITY (10) command.
-func (m *MSC) handleReadCapacity10(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
-	if !m.storage.IsPresent() {
-		m.setSense(SenseNotReady, ASCMediumNotPresent, 0)
+func (m *MSC) handleReadCapacity10(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
-	blockCount := m.storage.BlockCount()
-	blockSize := m.storage.BlockSize()
+	blockCount := lu.storage.BlockCount()
+	blockSize := lu.storage.BlockSize()
 
 	// READ CAPACITY (10) returns last LBA (max 0xFFFFFFFF)
 	lastLBA := uint32(blockCount - 1)
@@ -162,7 +235,7 @@ func (m *MSC) handleReadCapacity10(ctx context.Context, cbw *CommandBlockWrapper
 	n := resp.MarshalTo(m.dataBuf[:])
 
 	if err := m.sendData(ctx, m.dataBuf[:n]); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
@@ -171,19 +244,22 @@ func (m *MSC) handleReadCapacity10(ctx context.Context, cbw *CommandBlockWrapper
 }
 
 // handleReadCapacity16 processes READ CAPACITY (16) command.
-func (m *MSC) handleReadCapacity16(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
-	if !m.storage.IsPresent() {
-		m.setSense(SenseNotReady, ASCMediumNotPresent, 0)
+func (m *MSC) handleReadCapacity16(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
-	blockCount := m.storage.BlockCount()
-	blockSize := m.storage.BlockSize()
+	blockCount := lu.storage.BlockCount()
+	blockSize := lu.storage.BlockSize()
 
 	resp := ReadCapacity16Response{
 		LastLBA:     blockCount - 1,
 		BlockLength: blockSize,
 	}
+	if _, ok := lu.storage.(Discarder); ok {
+		resp.Provisioning = ReadCapacityLBPME | ReadCapacityLBPRZ
+	}
 
 	n := resp.MarshalTo(m.dataBuf[:])
 
@@ -194,7 +270,7 @@ func (m *MSC) handleReadCapacity16(ctx context.Context, cbw *CommandBlockWrapper
 	}
 
 	if err := m.sendData(ctx, m.dataBuf[:sendLen]); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
@@ -203,105 +279,210 @@ func (m *MSC) handleReadCapacity16(ctx context.Context, cbw *CommandBlockWrapper
 }
 
 // handleRead10 processes READ (10) command.
-func (m *MSC) handleRead10(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
-	if !m.storage.IsPresent() {
-		m.setSense(SenseNotReady, ASCMediumNotPresent, 0)
-		return CSWStatusFailed, cbw.DataTransferLength
-	}
+func (m *MSC) handleRead10(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	lba := parseU32BE(cbw.CB[:], 2)
+	transferBlocks := parseU16BE(cbw.CB[:], 7)
+	return m.readBlocks(ctx, lu, cbw, uint64(lba), uint32(transferBlocks))
+}
+
+// handleRead12 processes READ (12) command.
+func (m *MSC) handleRead12(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	lba := parseU32BE(cbw.CB[:], 2)
+	transferBlocks := parseU32BE(cbw.CB[:], 6)
+	return m.readBlocks(ctx, lu, cbw, uint64(lba), transferBlocks)
+}
+
+// handleRead16 processes READ (16) command.
+func (m *MSC) handleRead16(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	lba := parseU64BE(cbw.CB[:], 2)
+	transferBlocks := parseU32BE(cbw.CB[:], 10)
+	return m.readBlocks(ctx, lu, cbw, lba, transferBlocks)
+}
 
+// handleWrite10 processes WRITE (10) command.
+func (m *MSC) handleWrite10(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
 	lba := parseU32BE(cbw.CB[:], 2)
 	transferBlocks := parseU16BE(cbw.CB[:], 7)
+	return m.writeBlocks(ctx, lu, cbw, uint64(lba), uint32(transferBlocks))
+}
 
-	if transferBlocks == 0 {
-		return CSWStatusGood, 0
+// handleWrite12 processes WRITE (12) command.
+func (m *MSC) handleWrite12(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	lba := parseU32BE(cbw.CB[:], 2)
+	transferBlocks := parseU32BE(cbw.CB[:], 6)
+	return m.writeBlocks(ctx, lu, cbw, uint64(lba), transferBlocks)
+}
+
+// handleWrite16 processes WRITE (16) command.
+func (m *MSC) handleWrite16(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	lba := parseU64BE(cbw.CB[:], 2)
+	transferBlocks := parseU32BE(cbw.CB[:], 10)
+	return m.writeBlocks(ctx, lu, cbw, lba, transferBlocks)
+}
+
+// checkRange checks that blocks starting at lba lie within the storage of
+// lu, and returns the number of blocks that fit in the data buffer. It sets
+// the sense data and returns 0 if the range or block size is invalid.
+func (m *MSC) checkRange(lu *logicalUnit, lba uint64, blocks uint64) uint32 {
+	blockCount := lu.storage.BlockCount()
+	if lba > blockCount || blocks > blockCount-lba {
+		m.setSense(lu, SenseIllegalRequest, ASCLBAOutOfRange, 0)
+		return 0
 	}
 
-	blockSize := m.storage.BlockSize()
-	transferLength := uint32(transferBlocks) * blockSize
+	chunk := uint32(len(m.dataBuf)) / lu.storage.BlockSize()
+	if chunk == 0 {
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
+		return 0
+	}
+	return chunk
+}
 
-	// Check LBA range
-	if uint64(lba)+uint64(transferBlocks) > m.storage.BlockCount() {
-		m.setSense(SenseIllegalRequest, ASCLBAOutOfRange, 0)
+// dataPhaseTooShort reports whether the host expects fewer than length
+// bytes in the data phase of cbw, which the Bulk-Only Transport answers
+// with a phase error (cases 2, 3, 7, and 13). USB Attached SCSI has no data
+// transfer length, so the check does not apply.
+func (m *MSC) dataPhaseTooShort(cbw *CommandBlockWrapper, length uint64) bool {
+	m.mutex.RLock()
+	uas := m.uasActive
+	m.mutex.RUnlock()
+	return !uas && length > uint64(cbw.DataTransferLength)
+}
+
+// dataResidue returns the difference between the data transfer length of
+// cbw and the number of bytes transferred.
+func dataResidue(cbw *CommandBlockWrapper, transferred uint64) uint32 {
+	if transferred >= uint64(cbw.DataTransferLength) {
+		return 0
+	}
+	return uint32(uint64(cbw.DataTransferLength) - transferred)
+}
+
+// readBlocks reads blocks starting at lba and sends them to the host,
+// in chunks no larger than the data buffer.
+func (m *MSC) readBlocks(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper, lba uint64, blocks uint32) (uint8, uint32) {
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
-	pkg.LogDebug(pkg.ComponentDevice, "READ(10)",
-		"lba", lba,
-		"blocks", transferBlocks)
+	if blocks == 0 {
+		return CSWStatusGood, cbw.DataTransferLength
+	}
 
-	// Read blocks
-	blocksRead, err := m.storage.Read(uint64(lba), uint32(transferBlocks), m.dataBuf[:transferLength])
-	if err != nil {
-		pkg.LogWarn(pkg.ComponentDevice, "read error", "error", err)
-		m.setSense(SenseMediumError, ASCNoAdditionalInfo, 0)
+	blockSize := uint64(lu.storage.BlockSize())
+	if m.dataPhaseTooShort(cbw, uint64(blocks)*blockSize) {
+		pkg.LogWarn(pkg.ComponentDevice, "READ longer than data transfer length",
+			"blocks", blocks,
+			"dataLen", cbw.DataTransferLength)
+		return CSWStatusPhaseError, cbw.DataTransferLength
+	}
+
+	chunk := m.checkRange(lu, lba, uint64(blocks))
+	if chunk == 0 {
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
-	actualLength := blocksRead * blockSize
+	pkg.LogDebug(pkg.ComponentDevice, "READ",
+		"lba", lba,
+		"blocks", blocks)
 
-	// Send data
-	if err := m.sendData(ctx, m.dataBuf[:actualLength]); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
-		return CSWStatusFailed, cbw.DataTransferLength
+	var sent uint64
+	for blocks > 0 {
+		n := min(blocks, chunk)
+
+		// Read blocks
+		blocksRead, err := lu.storage.Read(lba, n, m.dataBuf[:uint64(n)*blockSize])
+		if err != nil {
+			pkg.LogWarn(pkg.ComponentDevice, "read error", "error", err)
+			m.setSense(lu, SenseMediumError, ASCNoAdditionalInfo, 0)
+			return CSWStatusFailed, dataResidue(cbw, sent)
+		}
+
+		// Send data
+		length := uint64(blocksRead) * blockSize
+		if err := m.sendData(ctx, m.dataBuf[:length]); err != nil {
+			m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
+			return CSWStatusFailed, dataResidue(cbw, sent)
+		}
+
+		sent += length
+		if blocksRead < n {
+			break
+		}
+		lba += uint64(n)
+		blocks -= n
 	}
 
-	residue := cbw.DataTransferLength - actualLength
-	return CSWStatusGood, residue
+	return CSWStatusGood, dataResidue(cbw, sent)
 }
 
-// handleWrite10 processes WRITE (10) command.
-func (m *MSC) handleWrite10(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
-	if !m.storage.IsPresent() {
-		m.setSense(SenseNotReady, ASCMediumNotPresent, 0)
+// writeBlocks receives blocks from the host and writes them starting at
+// lba, in chunks no larger than the data buffer.
+func (m *MSC) writeBlocks(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper, lba uint64, blocks uint32) (uint8, uint32) {
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
-	if m.storage.IsReadOnly() {
-		m.setSense(SenseDataProtect, ASCWriteProtected, 0)
+	if lu.storage.IsReadOnly() {
+		m.setSense(lu, SenseDataProtect, ASCWriteProtected, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
-	lba := parseU32BE(cbw.CB[:], 2)
-	transferBlocks := parseU16BE(cbw.CB[:], 7)
-
-	if transferBlocks == 0 {
-		return CSWStatusGood, 0
+	if blocks == 0 {
+		return CSWStatusGood, cbw.DataTransferLength
 	}
 
-	blockSize := m.storage.BlockSize()
-	transferLength := uint32(transferBlocks) * blockSize
+	blockSize := uint64(lu.storage.BlockSize())
+	if m.dataPhaseTooShort(cbw, uint64(blocks)*blockSize) {
+		pkg.LogWarn(pkg.ComponentDevice, "WRITE longer than data transfer length",
+			"blocks", blocks,
+			"dataLen", cbw.DataTransferLength)
+		return CSWStatusPhaseError, cbw.DataTransferLength
+	}
 
-	// Check LBA range
-	if uint64(lba)+uint64(transferBlocks) > m.storage.BlockCount() {
-		m.setSense(SenseIllegalRequest, ASCLBAOutOfRange, 0)
+	chunk := m.checkRange(lu, lba, uint64(blocks))
+	if chunk == 0 {
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
-	pkg.LogDebug(pkg.ComponentDevice, "WRITE(10)",
+	pkg.LogDebug(pkg.ComponentDevice, "WRITE",
 		"lba", lba,
-		"blocks", transferBlocks)
+		"blocks", blocks)
 
-	// Receive data from host
-	if err := m.receiveData(ctx, m.dataBuf[:transferLength]); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
-		return CSWStatusFailed, cbw.DataTransferLength
-	}
+	var written uint64
+	for blocks > 0 {
+		n := min(blocks, chunk)
+		length := uint64(n) * blockSize
 
-	// Write blocks
-	blocksWritten, err := m.storage.Write(uint64(lba), uint32(transferBlocks), m.dataBuf[:transferLength])
-	if err != nil {
-		pkg.LogWarn(pkg.ComponentDevice, "write error", "error", err)
-		m.setSense(SenseMediumError, ASCNoAdditionalInfo, 0)
-		return CSWStatusFailed, cbw.DataTransferLength
+		// Receive data from host
+		if err := m.receiveData(ctx, m.dataBuf[:length]); err != nil {
+			m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
+			return CSWStatusFailed, dataResidue(cbw, written)
+		}
+
+		// Write blocks
+		blocksWritten, err := lu.storage.Write(lba, n, m.dataBuf[:length])
+		if err != nil {
+			pkg.LogWarn(pkg.ComponentDevice, "write error", "error", err)
+			m.setSense(lu, SenseMediumError, ASCNoAdditionalInfo, 0)
+			return CSWStatusFailed, dataResidue(cbw, written)
+		}
+
+		written += uint64(blocksWritten) * blockSize
+		if blocksWritten < n {
+			break
+		}
+		lba += uint64(n)
+		blocks -= n
 	}
 
-	actualLength := blocksWritten * blockSize
-	residue := cbw.DataTransferLength - actualLength
-	return CSWStatusGood, residue
+	return CSWStatusGood, dataResidue(cbw, written)
 }
 
 // handleModeSense6 processes MODE SENSE (6) command.
-func (m *MSC) handleModeSense6(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
+func (m *MSC) handleModeSense6(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
 	allocLength := cbw.CB[4]
 	if allocLength == 0 {
 		return CSWStatusGood, 0
@@ -315,8 +496,8 @@ func (m *MSC) handleModeSense6(ctx context.Context, cbw *CommandBlockWrapper) (u
 		BlockDescLen:   0,
 	}
 
-	if m.storage.IsReadOnly() {
-		resp.DeviceParam = 0x80 // Write protect bit
+	if lu.storage.IsReadOnly() {
+		resp.DeviceParam = ModeSenseWriteProtect
 	}
 
 	n := resp.MarshalTo(m.dataBuf[:])
@@ -327,7 +508,36 @@ func (m *MSC) handleModeSense6(ctx context.Context, cbw *CommandBlockWrapper) (u
 	}
 
 	if err := m.sendData(ctx, m.dataBuf[:sendLen]); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	residue := cbw.DataTransferLength - uint32(sendLen)
+	return CSWStatusGood, residue
+}
+
+// handleModeSense10 processes MODE SENSE (10) command.
+func (m *MSC) handleModeSense10(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	allocLength := parseU16BE(cbw.CB[:], 7)
+	if allocLength == 0 {
+		return CSWStatusGood, 0
+	}
+
+	// Simple response with no mode pages
+	resp := ModeSense10Response{
+		ModeDataLength: 6, // Header only (excluding this field)
+	}
+
+	if lu.storage.IsReadOnly() {
+		resp.DeviceParam = ModeSenseWriteProtect
+	}
+
+	n := resp.MarshalTo(m.dataBuf[:])
+
+	sendLen := min(int(allocLength), n)
+
+	if err := m.sendData(ctx, m.dataBuf[:sendLen]); err != nil {
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
@@ -336,18 +546,18 @@ func (m *MSC) handleModeSense6(ctx context.Context, cbw *CommandBlockWrapper) (u
 }
 
 // handlePreventAllowRemoval processes PREVENT/ALLOW MEDIUM REMOVAL command.
-func (m *MSC) handlePreventAllowRemoval(cbw *CommandBlockWrapper) (uint8, uint32) {
+func (m *MSC) handlePreventAllowRemoval(lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
 	prevent := cbw.CB[4] & 0x01
 	pkg.LogDebug(pkg.ComponentDevice, "PREVENT/ALLOW MEDIUM REMOVAL",
 		"prevent", prevent)
 
 	// We don't actually prevent removal, just acknowledge the command
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	m.setSense(lu, SenseNoSense, ASCNoAdditionalInfo, 0)
 	return CSWStatusGood, 0
 }
 
 // handleStartStopUnit processes START/STOP UNIT command.
-func (m *MSC) handleStartStopUnit(cbw *CommandBlockWrapper) (uint8, uint32) {
+func (m *MSC) handleStartStopUnit(lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
 	start := cbw.CB[4]&0x01 != 0
 	loej := cbw.CB[4]&0x02 != 0
 
@@ -357,40 +567,40 @@ func (m *MSC) handleStartStopUnit(cbw *CommandBlockWrapper) (uint8, uint32) {
 
 	// Handle eject if requested
 	if loej && !start {
-		if m.storage.IsRemovable() {
-			if err := m.storage.Eject(); err != nil {
-				m.setSense(SenseIllegalRequest, ASCInvalidFieldInCDB, 0)
+		if lu.storage.IsRemovable() {
+			if err := lu.storage.Eject(); err != nil {
+				m.setSense(lu, SenseIllegalRequest, ASCInvalidFieldInCDB, 0)
 				return CSWStatusFailed, 0
 			}
 		}
 	}
 
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	m.setSense(lu, SenseNoSense, ASCNoAdditionalInfo, 0)
 	return CSWStatusGood, 0
 }
 
 // handleSynchronizeCache10 processes SYNCHRONIZE CACHE (10) command.
-func (m *MSC) handleSynchronizeCache10(cbw *CommandBlockWrapper) (uint8, uint32) {
-	if err := m.storage.Sync(); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
+func (m *MSC) handleSynchronizeCache10(lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	if err := lu.storage.Sync(); err != nil {
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
 		return CSWStatusFailed, 0
 	}
 
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	m.setSense(lu, SenseNoSense, ASCNoAdditionalInfo, 0)
 	return CSWStatusGood, 0
 }
 
 // handleVerify10 processes VERIFY (10) command.
-func (m *MSC) handleVerify10(cbw *CommandBlockWrapper) (uint8, uint32) {
+func (m *MSC) handleVerify10(lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
 	// We don't actually verify, just acknowledge success
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	m.setSense(lu, SenseNoSense, ASCNoAdditionalInfo, 0)
 	return CSWStatusGood, 0
 }
 
 // handleReadFormatCapacities processes READ FORMAT CAPACITIES command.
-func (m *MSC) handleReadFormatCapacities(ctx context.Context, cbw *CommandBlockWrapper) (uint8, uint32) {
-	if !m.storage.IsPresent() {
-		m.setSense(SenseNotReady, ASCMediumNotPresent, 0)
+func (m *MSC) handleReadFormatCapacities(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
@@ -399,8 +609,8 @@ func (m *MSC) handleReadFormatCapacities(ctx context.Context, cbw *CommandBlockW
 		return CSWStatusGood, 0
 	}
 
-	blockCount := m.storage.BlockCount()
-	blockSize := m.storage.BlockSize()
+	blockCount := lu.storage.BlockCount()
+	blockSize := lu.storage.BlockSize()
 
 	// Build response
 	offset := 0
@@ -425,7 +635,7 @@ func (m *MSC) handleReadFormatCapacities(ctx context.Context, cbw *CommandBlockW
 	}
 
 	if err := m.sendData(ctx, m.dataBuf[:sendLen]); err != nil {
-		m.setSense(SenseHardwareError, ASCNoAdditionalInfo, 0)
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
 		return CSWStatusFailed, cbw.DataTransferLength
 	}
 
@@ -433,32 +643,54 @@ func (m *MSC) handleReadFormatCapacities(ctx context.Context, cbw *CommandBlockW
 	return CSWStatusGood, residue
 }
 
-// sendData sends data to the host via bulk IN endpoint.
+// sendData sends data to the host via bulk IN endpoint, or the data-in
+// pipe with USB Attached SCSI.
 func (m *MSC) sendData(ctx context.Context, data []byte) error {
 	m.mutex.RLock()
 	stack := m.stack
 	ep := m.bulkInEP
+	uas := m.uasActive
+	if uas {
+		ep = m.dataInEP
+	}
 	m.mutex.RUnlock()
 
 	if stack == nil || ep == nil {
 		return pkg.ErrNotConfigured
 	}
 
+	if uas {
+		if err := m.startDataPhase(ctx, IUIDReadReady); err != nil {
+			return err
+		}
+	}
+
 	_, err := stack.Write(ctx, ep, data)
 	return err
 }
 
-// receiveData receives data from the host via bulk OUT endpoint.
+// receiveData receives data from the host via bulk OUT endpoint, or the
+// data-out pipe with USB Attached SCSI.
 func (m *MSC) receiveData(ctx context.Context, buf []byte) error {
 	m.mutex.RLock()
 	stack := m.stack
 	ep := m.bulkOutEP
+	uas := m.uasActive
+	if uas {
+		ep = m.dataOutEP
+	}
 	m.mutex.RUnlock()
 
 	if stack == nil || ep == nil {
 		return pkg.ErrNotConfigured
 	}
 
+	if uas {
+		if err := m.startDataPhase(ctx, IUIDWriteReady); err != nil {
+			return err
+		}
+	}
+
 	totalRead := 0
 	for totalRead < len(buf) {
 		n, err := stack.Read(ctx, ep, buf[totalRead:])
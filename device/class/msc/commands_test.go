@@ -0,0 +1,88 @@
+package msc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// read12 returns a READ (12) command block.
+func read12(lba, blocks uint32) []byte {
+	cb := []byte{SCSIRead12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(cb[2:], lba)
+	binary.BigEndian.PutUint32(cb[6:], blocks)
+	return cb
+}
+
+// read16 returns a READ (16) command block.
+func read16(lba uint64, blocks uint32) []byte {
+	cb := []byte{SCSIRead16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(cb[2:], lba)
+	binary.BigEndian.PutUint32(cb[10:], blocks)
+	return cb
+}
+
+func TestMSCReadWriteDataLength(t *testing.T) {
+	write12 := func(lba, blocks uint32) []byte {
+		cb := read12(lba, blocks)
+		cb[0] = SCSIWrite12
+		return cb
+	}
+	write16 := func(lba uint64, blocks uint32) []byte {
+		cb := read16(lba, blocks)
+		cb[0] = SCSIWrite16
+		return cb
+	}
+	block := bytes.Repeat([]byte{0x5A}, testBlockSize)
+
+	tests := []struct {
+		name    string
+		cmd     botCommand
+		status  uint8
+		residue uint32
+		data    int // Bytes of data sent by the device
+	}{
+		// Hi > Di and Ho > Do (cases 5 and 11): the residue is reported
+		{"read shorter than expected", botCommand{cb: read10(0, 1), length: 2 * testBlockSize, in: true}, CSWStatusGood, testBlockSize, testBlockSize},
+		{"write shorter than expected", botCommand{cb: write10(0, 1), length: 2 * testBlockSize, data: block}, CSWStatusGood, testBlockSize, 0},
+
+		// Hi > Dn (case 4): the whole transfer length is the residue
+		{"read of no blocks", botCommand{cb: read10(0, 0), length: testBlockSize, in: true}, CSWStatusGood, testBlockSize, 0},
+
+		// Hi < Di and Ho < Do (cases 7 and 13): phase error without a
+		// data phase
+		{"read longer than expected", botCommand{cb: read12(0, 2), length: testBlockSize, in: true}, CSWStatusPhaseError, testBlockSize, 0},
+		{"write longer than expected", botCommand{cb: write12(0, 2), length: testBlockSize}, CSWStatusPhaseError, testBlockSize, 0},
+
+		// Hn < Di and Hn < Do (cases 2 and 3)
+		{"read without data phase", botCommand{cb: read10(0, 1)}, CSWStatusPhaseError, 0, 0},
+		{"write without data phase", botCommand{cb: write10(0, 1)}, CSWStatusPhaseError, 0, 0},
+
+		// Transfers of 4 GiB or more do not wrap around
+		{"read of 4 GiB", botCommand{cb: read16(0, 1<<32/testBlockSize), length: 0xFFFFFFFF, in: true}, CSWStatusPhaseError, 0xFFFFFFFF, 0},
+		{"write of 4 GiB", botCommand{cb: write16(0, 1<<32/testBlockSize), length: 0xFFFFFFFF}, CSWStatusPhaseError, 0xFFFFFFFF, 0},
+		{"read of 4 GiB with no length", botCommand{cb: read16(0, 1<<32/testBlockSize), in: true}, CSWStatusPhaseError, 0, 0},
+	}
+
+	m := New(newTestStorage(4, 0xA0), "VENDOR", "PRODUCT")
+	fake := startMSC(t, m, false)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, csw := tt.cmd.run(t, fake, uint32(i+1))
+			if csw.Status != tt.status || csw.DataResidue != tt.residue {
+				t.Errorf("CSW status %d, residue %d, want status %d, residue %d",
+					csw.Status, csw.DataResidue, tt.status, tt.residue)
+			}
+			if len(data) != tt.data {
+				t.Errorf("device sent %d bytes, want %d", len(data), tt.data)
+			}
+		})
+	}
+
+	// The phase errors left the storage unchanged
+	buf := make([]byte, testBlockSize)
+	m.Storage(0).Read(1, 1, buf)
+	if buf[0] != 0xA0 {
+		t.Errorf("block 1 = %02X, want A0", buf[0])
+	}
+}
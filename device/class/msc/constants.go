@@ -47,6 +47,85 @@ const (
 	CSWStatusPhaseError = 0x02       // Phase error occurred
 )
 
+// USB Attached SCSI (UAS) information unit (IU) IDs.
+const (
+	IUIDCommand    = 0x01 // Command IU (host to device)
+	IUIDSense      = 0x03 // Sense IU (device to host)
+	IUIDResponse   = 0x04 // Response IU (device to host)
+	IUIDTaskMgmt   = 0x05 // Task Management IU (host to device)
+	IUIDReadReady  = 0x06 // Read Ready IU (device to host)
+	IUIDWriteReady = 0x07 // Write Ready IU (device to host)
+)
+
+// UAS information unit sizes.
+const (
+	CommandIUSize     = 32 // Command IU with a 16-byte CDB
+	TaskMgmtIUSize    = 16 // Task Management IU
+	ResponseIUSize    = 8  // Response IU
+	ReadyIUSize       = 4  // Read Ready and Write Ready IUs
+	SenseIUHeaderSize = 16 // Sense IU without sense data
+	SenseIUMaxSize    = SenseIUHeaderSize + 18
+)
+
+// UAS pipe usage descriptor constants.
+const (
+	DescriptorTypePipeUsage = 0x24 // Pipe usage class-specific descriptor
+	PipeUsageDescriptorSize = 4    // Pipe usage descriptor length
+)
+
+// UAS pipe IDs (pipe usage descriptor bPipeID).
+const (
+	PipeIDCommand = 0x01 // Command pipe (bulk OUT)
+	PipeIDStatus  = 0x02 // Status pipe (bulk IN)
+	PipeIDDataIn  = 0x03 // Data-in pipe (bulk IN)
+	PipeIDDataOut = 0x04 // Data-out pipe (bulk OUT)
+)
+
+// UAS task attributes (Command IU byte 4, bits 0-2).
+const (
+	TaskAttrSimple  = 0x00 // Simple task
+	TaskAttrHead    = 0x01 // Head of queue task
+	TaskAttrOrdered = 0x02 // Ordered task
+	TaskAttrACA     = 0x04 // ACA task
+)
+
+// UAS task management functions.
+const (
+	TaskMgmtAbortTask       = 0x01 // Abort one task
+	TaskMgmtAbortTaskSet    = 0x02 // Abort all tasks of the LUN
+	TaskMgmtClearTaskSet    = 0x04 // Clear all tasks of the LUN
+	TaskMgmtLUReset         = 0x08 // Logical unit reset
+	TaskMgmtITNexusReset    = 0x10 // I_T nexus reset
+	TaskMgmtClearACA        = 0x40 // Clear auto contingent allegiance
+	TaskMgmtQueryTask       = 0x80 // Query whether a task exists
+	TaskMgmtQueryTaskSet    = 0x81 // Query whether the LUN has tasks
+	TaskMgmtQueryAsyncEvent = 0x82 // Query asynchronous event
+)
+
+// UAS response codes (Response IU).
+const (
+	ResponseComplete      = 0x00 // Task management function complete
+	ResponseInvalidIU     = 0x02 // Invalid information unit
+	ResponseNotSupported  = 0x04 // Task management function not supported
+	ResponseFailed        = 0x05 // Task management function failed
+	ResponseSucceeded     = 0x08 // Task management function succeeded
+	ResponseIncorrectLUN  = 0x09 // Incorrect logical unit number
+	ResponseOverlappedTag = 0x0A // Overlapped tag attempted
+)
+
+// SCSI status codes (Sense IU).
+const (
+	StatusGood           = 0x00 // Command completed
+	StatusCheckCondition = 0x02 // Sense data is available
+	StatusBusy           = 0x08 // Logical unit is busy
+	StatusTaskSetFull    = 0x28 // Task set is full
+	StatusTaskAborted    = 0x40 // Task was aborted
+)
+
+// UASQueueDepth is the number of commands the UAS transport accepts before
+// reporting TASK SET FULL.
+const UASQueueDepth = 32
+
 // SCSI operation codes (commonly used subset).
 const (
 	SCSITestUnitReady        = 0x00 // Test if unit is ready
@@ -62,9 +141,14 @@ const (
 	SCSIWrite10              = 0x2A // Write blocks (10-byte)
 	SCSIVerify10             = 0x2F // Verify blocks (10-byte)
 	SCSISynchronizeCache10   = 0x35 // Synchronize cache (10-byte)
+	SCSIWriteSame10          = 0x41 // Write same block (10-byte)
+	SCSIUnmap                = 0x42 // Unmap (discard) blocks
 	SCSIRead16               = 0x88 // Read blocks (16-byte)
 	SCSIWrite16              = 0x8A // Write blocks (16-byte)
+	SCSIWriteSame16          = 0x93 // Write same block (16-byte)
 	SCSIServiceActionIn16    = 0x9E // Service action in (16-byte)
+	SCSIRead12               = 0xA8 // Read blocks (12-byte)
+	SCSIWrite12              = 0xAA // Write blocks (12-byte)
 )
 
 // Service action codes for SCSI_SERVICE_ACTION_IN_16.
@@ -88,13 +172,16 @@ const (
 
 // Additional Sense Codes (ASC).
 const (
-	ASCNoAdditionalInfo      = 0x00 // No additional sense information
-	ASCInvalidCommand        = 0x20 // Invalid command operation code
-	ASCLBAOutOfRange         = 0x21 // Logical block address out of range
-	ASCInvalidFieldInCDB     = 0x24 // Invalid field in CDB
-	ASCWriteProtected        = 0x27 // Write protected
-	ASCNotReadyToReadyChange = 0x28 // Not ready to ready change
-	ASCMediumNotPresent      = 0x3A // Medium not present
+	ASCNoAdditionalInfo        = 0x00 // No additional sense information
+	ASCParameterListLength     = 0x1A // Parameter list length error
+	ASCInvalidCommand          = 0x20 // Invalid command operation code
+	ASCLBAOutOfRange           = 0x21 // Logical block address out of range
+	ASCInvalidFieldInCDB       = 0x24 // Invalid field in CDB
+	ASCLogicalUnitNotSupported = 0x25 // Logical unit not supported
+	ASCInvalidFieldInParamList = 0x26 // Invalid field in parameter list
+	ASCWriteProtected          = 0x27 // Write protected
+	ASCNotReadyToReadyChange   = 0x28 // Not ready to ready change
+	ASCMediumNotPresent        = 0x3A // Medium not present
 )
 
 // SCSI device types (peripheral device type).
@@ -122,6 +209,10 @@ const (
 	InquiryVersionSPC4  = 0x06 // SPC-4 version
 )
 
+// InquiryNoLogicalUnit is the peripheral byte reported by INQUIRY for a LUN
+// that is not supported (qualifier 011b, device type 1Fh).
+const InquiryNoLogicalUnit = 0x7F
+
 // INQUIRY response data format.
 const (
 	InquiryResponseFormatSPC = 0x02 // SPC-compliant response format
@@ -129,7 +220,33 @@ const (
 
 // INQUIRY flags.
 const (
-	InquiryRMB = 0x80 // Removable media bit
+	InquiryRMB  = 0x80 // Removable media bit
+	InquiryEVPD = 0x01 // Enable vital product data (CDB byte 1)
+)
+
+// Vital product data (VPD) page codes.
+const (
+	VPDSupportedPages           = 0x00 // Supported VPD pages
+	VPDUnitSerialNumber         = 0x80 // Unit serial number
+	VPDDeviceIdentification     = 0x83 // Device identification
+	VPDBlockLimits              = 0xB0 // Block limits
+	VPDLogicalBlockProvisioning = 0xB2 // Logical block provisioning
+)
+
+// READ CAPACITY (16) logical block provisioning flags (byte 14).
+const (
+	ReadCapacityLBPME = 0x80 // Logical block provisioning management enabled
+	ReadCapacityLBPRZ = 0x40 // Unmapped blocks read as zeros
+)
+
+// WriteSameUnmap is the UNMAP bit of WRITE SAME (CDB byte 1).
+const WriteSameUnmap = 0x08
+
+// UNMAP parameter list constants.
+const (
+	UnmapHeaderSize     = 8    // Parameter list header
+	UnmapDescriptorSize = 16   // Block descriptor
+	MaxUnmapDescriptors = 4095 // Descriptors in the largest parameter list
 )
 
 // Mode page codes.
@@ -143,8 +260,15 @@ const (
 	ModeSenseDBD = 0x08 // Disable block descriptors
 )
 
+// ModeSenseWriteProtect is the write protect bit of the device-specific
+// parameter in the MODE SENSE header.
+const ModeSenseWriteProtect = 0x80
+
 // Default block size (512 bytes for most disks).
 const DefaultBlockSize = 512
 
+// MaxLUNs is the maximum number of logical units (LUN 0-15).
+const MaxLUNs = 16
+
 // Maximum transfer size (64 KB).
 const MaxTransferSize = 65536
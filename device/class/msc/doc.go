@@ -1,5 +1,6 @@
 // Package msc implements the USB Mass Storage Class (MSC) device driver
-// using Bulk-Only Transport (BOT) protocol with SCSI transparent command set.
+// using Bulk-Only Transport (BOT) or USB Attached SCSI (UAS) protocol with
+// SCSI transparent command set.
 //
 // The MSC class allows a USB device to appear as a standard disk drive,
 // USB flash drive, or other mass storage device to the host system.
@@ -8,7 +9,7 @@
 //
 // The MSC driver consists of three main components:
 //
-//  1. BOT Protocol Handler - Processes CBW/CSW packets
+//  1. BOT and UAS Protocol Handlers - Process CBW/CSW packets or IUs
 //  2. SCSI Command Processor - Handles SCSI commands
 //  3. Storage Backend - Provides block-level storage
 //
@@ -20,18 +21,38 @@
 //  2. Data Phase - Optional bidirectional data transfer
 //  3. Status Phase - Device sends Command Status Wrapper (CSW)
 //
+// # USB Attached SCSI (UAS) Protocol
+//
+// [MSC.ConfigureDeviceUAS] adds UAS as alternate setting 1 of the MSC
+// interface, alongside BOT in alternate setting 0. UAS uses four pipes
+// and exchanges information units (IUs) instead of CBWs and CSWs:
+//
+//  1. Command pipe - Host sends Command and Task Management IUs
+//  2. Status pipe - Device sends Read Ready, Write Ready, Sense, and
+//     Response IUs
+//  3. Data-in and data-out pipes - Data transfers
+//
+// The host may queue up to [UASQueueDepth] commands, identified by tag.
+// Commands are executed one at a time in queue order, head of queue
+// commands first, and each completes with a Sense IU carrying its status
+// and sense data. Task management functions abort or query queued
+// commands while another command executes. Bulk streams are not used, so
+// the device sends a Read Ready or Write Ready IU before each data phase.
+//
 // # SCSI Command Support
 //
 // The driver implements a subset of SCSI commands sufficient for
 // disk operation:
 //
-//   - INQUIRY - Device identification
-//   - READ CAPACITY - Get disk size
-//   - READ (10/16) - Read blocks
-//   - WRITE (10/16) - Write blocks
+//   - INQUIRY - Device identification and VPD pages (0x00, 0x80, 0x83,
+//     0xB0, 0xB2)
+//   - READ CAPACITY (10/16) - Get disk size
+//   - READ (10/12/16) - Read blocks
+//   - WRITE (10/12/16) - Write blocks
+//   - WRITE SAME (10/16) and UNMAP - Thin provisioning
 //   - TEST UNIT READY - Check if ready
 //   - REQUEST SENSE - Get error information
-//   - MODE SENSE - Get device parameters
+//   - MODE SENSE (6/10) - Get device parameters
 //   - PREVENT/ALLOW MEDIUM REMOVAL - Media lock control
 //
 // # Storage Backend
@@ -40,9 +61,31 @@
 // different backend implementations:
 //
 //   - MemoryStorage - In-memory RAM disk
+//   - SparseStorage - In-memory RAM disk allocated on write
 //   - FileStorage - File-backed disk image
+//   - OverlayStorage - Copy-on-write overlay over another Storage
+//   - VHDStorage - Read-only fixed or dynamic VHD image
+//   - FATStorage - FAT16/FAT32 volume synthesized from an fs.FS
 //   - Custom implementations - Any block device
 //
+// An OverlayStorage lets several devices or tests share one golden image
+// without modifying it:
+//
+//	golden, _ := msc.OpenVHD("golden.vhd", 512)
+//	overlay := msc.NewOverlayStorage(golden)
+//	disk := msc.New(overlay, "softusb", "Scratch Disk")
+//
+// Backends that also implement [Discarder] support UNMAP and WRITE SAME
+// with the UNMAP bit.
+//
+// # Logical Units
+//
+// [New] creates LUN 0, and [MSC.AddLUN] adds further logical units. Each
+// LUN has its own storage backend, INQUIRY data, and sense data:
+//
+//	disk := msc.New(firmware, "softusb", "Firmware")
+//	disk.AddLUN(data, "softusb", "Data") // LUN 1
+//
 // # Usage Example
 //
 //	// Create 1MB in-memory storage
@@ -60,6 +103,10 @@
 //	// Add MSC interface (bulkIn=0x81, bulkOut=0x01)
 //	disk.ConfigureDevice(builder, 0x81, 0x01)
 //
+//	// Or offer UAS as well (dataIn=0x81, dataOut=0x01, status=0x82,
+//	// command=0x02, high speed)
+//	disk.ConfigureDeviceUAS(builder, 0x81, 0x01, 0x82, 0x02, 512)
+//
 //	// Build device and attach driver
 //	dev, _ := builder.Build(ctx)
 //	disk.AttachToInterface(dev, 1, 0)
@@ -76,6 +123,8 @@
 //
 //   - USB Mass Storage Class Specification 1.0
 //   - USB Mass Storage Bulk-Only Transport 1.0
+//   - USB Attached SCSI Protocol (UASP) 1.0
+//   - SCSI Architecture Model (SAM-5)
 //   - SCSI Primary Commands (SPC-4)
 //   - SCSI Block Commands (SBC-3)
 package msc
@@ -0,0 +1,948 @@
+package msc
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// ErrVolumeFull is returned when the files of a synthesized FAT volume do
+// not fit in its size, or a file is too large for FAT.
+var ErrVolumeFull = errors.New("files do not fit in volume")
+
+// FAT volume constants.
+const (
+	FATSectorSize     = 512     // Sector size of synthesized FAT volumes
+	FATPartitionStart = 2048    // First sector of the partition (1 MiB aligned)
+	FATMinSize        = 4 << 20 // Smallest volume size in bytes
+)
+
+// FAT layout constants.
+const (
+	fat16MinClusters  = 4085       // Fewer clusters is FAT12
+	fat16MaxClusters  = 65524      // More clusters is FAT32
+	fat16RootEntries  = 512        // Fixed root directory entries
+	fat16Reserved     = 1          // Boot sector
+	fat32Reserved     = 32         // Boot sector, FSInfo, and backups
+	fat32FSInfo       = 1          // FSInfo sector
+	fat32BackupBoot   = 6          // Backup boot sector
+	fatCount          = 2          // Number of FATs
+	fatMedia          = 0xF8       // Fixed disk media descriptor
+	fatEOC            = 0xFFFFFFFF // End of chain, as returned by fatNext
+	fatMaxDepth       = 32         // Deepest directory walked in written volumes
+	fatPartitionFAT16 = 0x0E       // MBR partition type of FAT16 (LBA)
+	fatPartitionFAT32 = 0x0C       // MBR partition type of FAT32 (LBA)
+)
+
+// FATStorage implements Storage interface as a FAT16 or FAT32 volume
+// synthesized from an fs.FS. The disk holds an MBR with one partition; the
+// boot sector, FATs, and directories are generated from the file tree when
+// the storage is created, and file data is read from the fs.FS as the host
+// reads it.
+//
+// The volume is read-only by default. When writable, sectors written by the
+// host are kept in memory over the synthesized volume, and Sync reports the
+// files the host created or modified to the OnFileWritten callback, e.g. to
+// flash firmware dropped on the drive.
+type FATStorage struct {
+	fsys     fs.FS
+	label    [11]byte
+	volumeID uint32
+	fat32    bool
+
+	// Layout, in sectors relative to FATPartitionStart
+	volSectors uint32 // Sectors in the partition
+	reserved   uint32 // Reserved sectors before the first FAT
+	fatSectors uint32 // Sectors per FAT
+	rootStart  uint32 // First sector of the FAT16 root directory
+	dataStart  uint32 // First sector of cluster 2
+	spc        uint32 // Sectors per cluster
+	clusters   uint32 // Number of data clusters
+	nextFree   uint32 // First cluster not used by the file tree
+
+	nodes   []fatNode // Files and directories, root first
+	extents []int     // Indices of nodes with clusters, by first cluster
+
+	readOnly      bool
+	sectors       map[uint64][]byte   // Sectors written by the host
+	dirty         map[uint64]struct{} // Sectors written since the last Sync
+	files         map[string]fatFile  // Files as of the last Sync
+	lastWrite     time.Time
+	onFileWritten func(name string, data []byte)
+	mutex         sync.RWMutex
+}
+
+// fatNode is a file or directory of the synthesized volume.
+type fatNode struct {
+	path     string   // Path in the fs.FS
+	dir      bool     // Directory
+	parent   int      // Index of the parent directory
+	children []int    // Indices of the directory's children
+	count    int      // Directory entries used by the directory
+	entries  []byte   // Directory entries of the directory
+	entry    fatEntry // Entry in the parent directory
+	first    uint32   // First cluster, or 0 if none
+	length   uint32   // Number of clusters
+}
+
+// fatFile is the location of a file in the volume.
+type fatFile struct {
+	first uint32
+	size  uint32
+}
+
+// fatWritten is a file created or modified by the host.
+type fatWritten struct {
+	name string
+	data []byte
+}
+
+// NewFATStorage creates a FAT volume of the given size in bytes holding the
+// files of fsys. The volume is FAT16 up to 2 GiB and FAT32 above. If size
+// is 0, the volume is sized to fit the files with room to spare. label is
+// the volume label, up to 11 characters.
+// Returns ErrVolumeFull if the files do not fit.
+func NewFATStorage(fsys fs.FS, size uint64, label string) (*FATStorage, error) {
+	s := &FATStorage{
+		fsys:     fsys,
+		label:    fatLabel(label),
+		readOnly: true,
+		sectors:  make(map[uint64][]byte),
+		dirty:    make(map[uint64]struct{}),
+		files:    make(map[string]fatFile),
+	}
+
+	s.nodes = append(s.nodes, fatNode{path: ".", dir: true})
+	if label != "" {
+		s.nodes[0].count = 1 // Volume label entry
+	}
+	if err := s.addDir(0); err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		size = s.fitSize()
+	}
+	if err := s.layout(size); err != nil {
+		return nil, err
+	}
+	if err := s.allocate(); err != nil {
+		return nil, err
+	}
+	s.marshalDirs(label != "")
+
+	h := fnv.New32a()
+	h.Write(s.label[:])
+	for i := range s.nodes {
+		n := &s.nodes[i]
+		h.Write([]byte(n.path))
+		if !n.dir {
+			s.files[n.path] = fatFile{first: n.first, size: n.entry.size}
+		}
+	}
+	s.volumeID = h.Sum32()
+
+	pkg.LogDebug(pkg.ComponentDevice, "FAT volume created",
+		"fat32", s.fat32,
+		"sectors", s.BlockCount(),
+		"clusterSize", s.spc*FATSectorSize,
+		"nodes", len(s.nodes))
+
+	return s, nil
+}
+
+// fatLabel returns label as a volume label, or "NO NAME" if empty.
+func fatLabel(label string) [11]byte {
+	out := fatBlankName
+	if label == "" {
+		copy(out[:], "NO NAME")
+		return out
+	}
+	i := 0
+	for _, r := range strings.ToUpper(label) {
+		if i == len(out) {
+			break
+		}
+		switch {
+		case r == ' ' || r < 0x80 && fatValidShort(string(r)):
+			out[i] = byte(r)
+		default:
+			out[i] = '_'
+		}
+		i++
+	}
+	return out
+}
+
+// addDir adds the children of directory node i, recursively.
+func (s *FATStorage) addDir(i int) error {
+	dir := s.nodes[i].path
+	list, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	if i != 0 {
+		s.nodes[i].count = 2 // Dot and dot-dot entries
+	}
+	used := make(map[[11]byte]bool)
+	for _, de := range list {
+		info, err := de.Info()
+		if err != nil {
+			return err
+		}
+		if !de.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+
+		name := de.Name()
+		if len(utf16.Encode([]rune(name))) > fatMaxNameLen {
+			return pkg.ErrInvalidParameter
+		}
+		if !de.IsDir() && info.Size() > math.MaxUint32 {
+			return ErrVolumeFull
+		}
+
+		n := fatNode{
+			path:   path.Join(dir, name),
+			dir:    de.IsDir(),
+			parent: i,
+			entry: fatEntry{
+				name:    name,
+				attr:    fatAttrArchive,
+				modTime: info.ModTime(),
+			},
+		}
+		n.entry.short, n.entry.lfn = fatShortName(name, used)
+		if n.dir {
+			n.entry.attr = fatAttrDirectory
+		} else {
+			n.entry.size = uint32(info.Size())
+		}
+
+		s.nodes[i].count += n.entry.entries()
+		s.nodes[i].children = append(s.nodes[i].children, len(s.nodes))
+		s.nodes = append(s.nodes, n)
+		if n.dir {
+			if err := s.addDir(len(s.nodes) - 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fitSize returns a volume size that holds the file tree with 4 KiB
+// clusters and a quarter of free space, and is at least FATMinSize.
+func (s *FATStorage) fitSize() uint64 {
+	const cluster = 4096
+	need := uint64(1 << 20) // Partition offset, FATs, and directories
+	for i := range s.nodes {
+		n := &s.nodes[i]
+		bytes := uint64(n.entry.size)
+		if n.dir {
+			bytes = uint64(n.count) * fatDirEntrySize
+		}
+		need += (bytes + cluster - 1) / cluster * cluster
+	}
+	need += need / 4
+	return max(need, FATMinSize)
+}
+
+// fatFit returns the sectors per FAT and the number of clusters of a
+// volume, where each FAT entry takes entrySize bytes.
+func fatFit(volSectors, reserved, rootSectors, spc, entrySize uint32) (fatSectors, clusters uint32) {
+	for {
+		meta := uint64(reserved) + fatCount*uint64(fatSectors) + uint64(rootSectors)
+		if meta >= uint64(volSectors) {
+			return fatSectors, 0
+		}
+		clusters = (volSectors - uint32(meta)) / spc
+		need := uint32((uint64(clusters+2)*uint64(entrySize) + FATSectorSize - 1) / FATSectorSize)
+		if need <= fatSectors {
+			return fatSectors, clusters
+		}
+		fatSectors = need
+	}
+}
+
+// layout computes the volume layout for a disk of size bytes.
+func (s *FATStorage) layout(size uint64) error {
+	sectors := size / FATSectorSize
+	if size < FATMinSize || sectors-FATPartitionStart > math.MaxUint32 {
+		return pkg.ErrInvalidParameter
+	}
+	s.volSectors = uint32(sectors - FATPartitionStart)
+
+	// FAT16 with the smallest clusters that keep the count in range
+	rootSectors := uint32(fat16RootEntries * fatDirEntrySize / FATSectorSize)
+	for spc := uint32(1); spc <= 64; spc <<= 1 {
+		fatSectors, clusters := fatFit(s.volSectors, fat16Reserved, rootSectors, spc, 2)
+		if clusters > fat16MaxClusters {
+			continue
+		}
+		if clusters < fat16MinClusters {
+			return pkg.ErrInvalidParameter
+		}
+		s.reserved = fat16Reserved
+		s.fatSectors = fatSectors
+		s.rootStart = s.reserved + fatCount*fatSectors
+		s.dataStart = s.rootStart + rootSectors
+		s.spc = spc
+		s.clusters = clusters
+		return nil
+	}
+
+	// FAT32 above 2 GiB, with cluster sizes as chosen by common formatters
+	var spc uint32
+	switch {
+	case s.volSectors <= 16<<20: // 8 GiB
+		spc = 8
+	case s.volSectors <= 32<<20: // 16 GiB
+		spc = 16
+	case s.volSectors <= 64<<20: // 32 GiB
+		spc = 32
+	default:
+		spc = 64
+	}
+	fatSectors, clusters := fatFit(s.volSectors, fat32Reserved, 0, spc, 4)
+	s.fat32 = true
+	s.reserved = fat32Reserved
+	s.fatSectors = fatSectors
+	s.rootStart = 0
+	s.dataStart = s.reserved + fatCount*fatSectors
+	s.spc = spc
+	s.clusters = clusters
+	return nil
+}
+
+// allocate assigns consecutive clusters to the directories and files, in
+// tree order.
+func (s *FATStorage) allocate() error {
+	if !s.fat32 && s.nodes[0].count > fat16RootEntries {
+		return ErrVolumeFull
+	}
+
+	clusterSize := uint64(s.spc) * FATSectorSize
+	next := uint32(2)
+	for i := range s.nodes {
+		n := &s.nodes[i]
+		bytes := uint64(n.entry.size)
+		if n.dir {
+			if i == 0 && !s.fat32 {
+				continue // FAT16 root directory has its own region
+			}
+			bytes = max(uint64(n.count)*fatDirEntrySize, 1)
+		}
+		length := (bytes + clusterSize - 1) / clusterSize
+		if length == 0 {
+			continue // Empty files have no clusters
+		}
+		if length > uint64(s.clusters+2-next) {
+			return ErrVolumeFull
+		}
+
+		n.first = next
+		n.length = uint32(length)
+		next += n.length
+		s.extents = append(s.extents, i)
+	}
+	s.nextFree = next
+	return nil
+}
+
+// marshalDirs generates the directory entries of all directories.
+func (s *FATStorage) marshalDirs(labeled bool) {
+	for i := range s.nodes {
+		n := &s.nodes[i]
+		if !n.dir {
+			continue
+		}
+
+		n.entries = make([]byte, n.count*fatDirEntrySize)
+		off := 0
+		if i == 0 {
+			if labeled {
+				label := fatEntry{short: s.label, attr: fatAttrVolumeID}
+				off += label.marshalTo(n.entries[off:])
+			}
+		} else {
+			dot := fatEntry{short: fatBlankName, attr: fatAttrDirectory,
+				cluster: n.first, modTime: n.entry.modTime}
+			dot.short[0] = '.'
+			off += dot.marshalTo(n.entries[off:])
+
+			// Dot-dot of a directory in the root refers to cluster 0
+			dot.short[1] = '.'
+			dot.cluster = 0
+			if n.parent != 0 {
+				dot.cluster = s.nodes[n.parent].first
+			}
+			off += dot.marshalTo(n.entries[off:])
+		}
+
+		for _, c := range n.children {
+			child := &s.nodes[c]
+			child.entry.cluster = child.first
+			off += child.entry.marshalTo(n.entries[off:])
+		}
+	}
+}
+
+// BlockSize returns the sector size.
+func (s *FATStorage) BlockSize() uint32 {
+	return FATSectorSize
+}
+
+// BlockCount returns the number of sectors of the disk.
+func (s *FATStorage) BlockCount() uint64 {
+	return FATPartitionStart + uint64(s.volSectors)
+}
+
+// IsFAT32 returns whether the volume is FAT32 rather than FAT16.
+func (s *FATStorage) IsFAT32() bool {
+	return s.fat32
+}
+
+// Read reads sectors of the volume, generating them from the file tree
+// except where written by the host.
+func (s *FATStorage) Read(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if lba+uint64(blocks) > s.BlockCount() {
+		return 0, io.EOF
+	}
+
+	if uint64(len(buf)) < uint64(blocks)*FATSectorSize {
+		return 0, io.ErrShortBuffer
+	}
+
+	return s.readLocked(lba, blocks, buf)
+}
+
+// readLocked reads sectors of the volume. The caller must hold s.mutex.
+func (s *FATStorage) readLocked(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	for i := uint32(0); i < blocks; {
+		n, err := s.readRun(lba+uint64(i), buf[i*FATSectorSize:blocks*FATSectorSize])
+		if err != nil {
+			return i, err
+		}
+		i += n
+	}
+
+	// Sectors written by the host take precedence
+	if len(s.sectors) > 0 {
+		for i := uint32(0); i < blocks; i++ {
+			if sector, ok := s.sectors[lba+uint64(i)]; ok {
+				copy(buf[i*FATSectorSize:(i+1)*FATSectorSize], sector)
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// readRun generates sector lba and the following sectors of the same
+// region or node into buf, whose length is a multiple of the sector size.
+// Returns the number of sectors generated.
+func (s *FATStorage) readRun(lba uint64, buf []byte) (uint32, error) {
+	want := uint32(len(buf) / FATSectorSize)
+	sector := buf[:FATSectorSize]
+
+	if lba < FATPartitionStart {
+		if lba == 0 {
+			s.marshalMBR(sector)
+			return 1, nil
+		}
+		n := min(want, uint32(FATPartitionStart-lba))
+		clear(buf[:n*FATSectorSize])
+		return n, nil
+	}
+
+	v := uint32(lba - FATPartitionStart)
+	switch {
+	case v < s.reserved:
+		switch {
+		case v == 0 || s.fat32 && v == fat32BackupBoot:
+			s.marshalBootSector(sector)
+		case s.fat32 && (v == fat32FSInfo || v == fat32BackupBoot+fat32FSInfo):
+			s.marshalFSInfo(sector)
+		default:
+			clear(sector)
+		}
+		return 1, nil
+
+	case v < s.reserved+fatCount*s.fatSectors:
+		s.marshalFATSector((v-s.reserved)%s.fatSectors, sector)
+		return 1, nil
+
+	case v < s.dataStart:
+		s.copyEntries(&s.nodes[0], uint64(v-s.rootStart)*FATSectorSize, sector)
+		return 1, nil
+	}
+
+	// Data region
+	c := (v-s.dataStart)/s.spc + 2
+	within := (v - s.dataStart) % s.spc
+	if c >= s.clusters+2 {
+		clear(sector) // Sectors past the last cluster
+		return 1, nil
+	}
+
+	node := s.nodeAt(c)
+	if node == nil {
+		n := min(want, s.spc-within)
+		clear(buf[:n*FATSectorSize])
+		return n, nil
+	}
+
+	n := min(want, (node.first+node.length-c)*s.spc-within)
+	data := buf[:n*FATSectorSize]
+	off := (uint64(c-node.first)*uint64(s.spc) + uint64(within)) * FATSectorSize
+	if node.dir {
+		s.copyEntries(node, off, data)
+		return n, nil
+	}
+	return n, s.readFile(node, off, data)
+}
+
+// nodeAt returns the node holding cluster c, or nil if the cluster is
+// free.
+func (s *FATStorage) nodeAt(c uint32) *fatNode {
+	i := sort.Search(len(s.extents), func(i int) bool {
+		n := &s.nodes[s.extents[i]]
+		return n.first+n.length > c
+	})
+	if i == len(s.extents) || s.nodes[s.extents[i]].first > c {
+		return nil
+	}
+	return &s.nodes[s.extents[i]]
+}
+
+// copyEntries copies the directory entries of node at offset off to buf,
+// with zeros past the last entry.
+func (s *FATStorage) copyEntries(node *fatNode, off uint64, buf []byte) {
+	clear(buf)
+	if off < uint64(len(node.entries)) {
+		copy(buf, node.entries[off:])
+	}
+}
+
+// readFile reads the data of a file at offset off into buf, with zeros
+// past the end of the file.
+func (s *FATStorage) readFile(node *fatNode, off uint64, buf []byte) error {
+	clear(buf)
+	size := uint64(node.entry.size)
+	if off >= size {
+		return nil
+	}
+	buf = buf[:min(uint64(len(buf)), size-off)]
+
+	f, err := s.fsys.Open(node.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch r := f.(type) {
+	case io.ReaderAt:
+		_, err = r.ReadAt(buf, int64(off))
+	case io.Seeker:
+		if _, err = r.Seek(int64(off), io.SeekStart); err == nil {
+			_, err = io.ReadFull(f, buf)
+		}
+	default:
+		if _, err = io.CopyN(io.Discard, f, int64(off)); err == nil {
+			_, err = io.ReadFull(f, buf)
+		}
+	}
+
+	// Files that shrank since the volume was created read as zeros
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
+
+// marshalMBR writes the master boot record to buf.
+func (s *FATStorage) marshalMBR(buf []byte) {
+	clear(buf[:FATSectorSize])
+	binary.LittleEndian.PutUint32(buf[440:444], s.volumeID) // Disk signature
+
+	p := buf[446:462]                      // First partition entry
+	copy(p[1:4], []byte{0xFE, 0xFF, 0xFF}) // CHS not used, LBA only
+	p[4] = fatPartitionFAT16
+	if s.fat32 {
+		p[4] = fatPartitionFAT32
+	}
+	copy(p[5:8], []byte{0xFE, 0xFF, 0xFF})
+	binary.LittleEndian.PutUint32(p[8:12], FATPartitionStart)
+	binary.LittleEndian.PutUint32(p[12:16], s.volSectors)
+
+	buf[510], buf[511] = 0x55, 0xAA
+}
+
+// marshalBootSector writes the boot sector with the BIOS parameter block
+// to buf.
+func (s *FATStorage) marshalBootSector(buf []byte) {
+	clear(buf[:FATSectorSize])
+	copy(buf[0:3], []byte{0xEB, 0x3C, 0x90}) // Jump over the BPB
+	copy(buf[3:11], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(buf[11:13], FATSectorSize)
+	buf[13] = uint8(s.spc)
+	binary.LittleEndian.PutUint16(buf[14:16], uint16(s.reserved))
+	buf[16] = fatCount
+	buf[21] = fatMedia
+	binary.LittleEndian.PutUint16(buf[24:26], 63)  // Sectors per track
+	binary.LittleEndian.PutUint16(buf[26:28], 255) // Heads
+	binary.LittleEndian.PutUint32(buf[28:32], FATPartitionStart)
+
+	ext := buf[36:]
+	fsType := "FAT16   "
+	if s.fat32 {
+		buf[1] = 0x58
+		binary.LittleEndian.PutUint32(buf[32:36], s.volSectors)
+		binary.LittleEndian.PutUint32(buf[36:40], s.fatSectors)
+		binary.LittleEndian.PutUint32(buf[44:48], s.nodes[0].first)
+		binary.LittleEndian.PutUint16(buf[48:50], fat32FSInfo)
+		binary.LittleEndian.PutUint16(buf[50:52], fat32BackupBoot)
+		ext = buf[64:]
+		fsType = "FAT32   "
+	} else {
+		binary.LittleEndian.PutUint16(buf[17:19], fat16RootEntries)
+		if s.volSectors <= math.MaxUint16 {
+			binary.LittleEndian.PutUint16(buf[19:21], uint16(s.volSectors))
+		} else {
+			binary.LittleEndian.PutUint32(buf[32:36], s.volSectors)
+		}
+		binary.LittleEndian.PutUint16(buf[22:24], uint16(s.fatSectors))
+	}
+
+	ext[0] = 0x80 // Drive number
+	ext[2] = 0x29 // Extended boot signature
+	binary.LittleEndian.PutUint32(ext[3:7], s.volumeID)
+	copy(ext[7:18], s.label[:])
+	copy(ext[18:26], fsType)
+
+	buf[510], buf[511] = 0x55, 0xAA
+}
+
+// marshalFSInfo writes the FAT32 FSInfo sector to buf.
+func (s *FATStorage) marshalFSInfo(buf []byte) {
+	clear(buf[:FATSectorSize])
+	binary.LittleEndian.PutUint32(buf[0:4], 0x41615252)
+	binary.LittleEndian.PutUint32(buf[484:488], 0x61417272)
+	binary.LittleEndian.PutUint32(buf[488:492], s.clusters+2-s.nextFree)
+	binary.LittleEndian.PutUint32(buf[492:496], s.nextFree)
+	binary.LittleEndian.PutUint32(buf[508:512], 0xAA550000)
+}
+
+// marshalFATSector writes sector n of a FAT to buf.
+func (s *FATStorage) marshalFATSector(n uint32, buf []byte) {
+	clear(buf[:FATSectorSize])
+
+	entrySize := uint32(2)
+	eoc := uint32(0xFFFF)
+	if s.fat32 {
+		entrySize = 4
+		eoc = 0x0FFFFFFF
+	}
+
+	perSector := FATSectorSize / entrySize
+	for i := uint32(0); i < perSector; i++ {
+		c := n*perSector + i
+		if c >= s.clusters+2 {
+			break
+		}
+
+		var value uint32
+		switch c {
+		case 0:
+			value = eoc&^0xFF | fatMedia
+		case 1:
+			value = eoc
+		default:
+			if node := s.nodeAt(c); node != nil {
+				value = c + 1
+				if c == node.first+node.length-1 {
+					value = eoc
+				}
+			}
+		}
+
+		if s.fat32 {
+			binary.LittleEndian.PutUint32(buf[i*4:], value)
+		} else {
+			binary.LittleEndian.PutUint16(buf[i*2:], uint16(value))
+		}
+	}
+}
+
+// Write keeps sectors written by the host in memory.
+// Returns os.ErrPermission if the volume is read-only.
+func (s *FATStorage) Write(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readOnly {
+		return 0, os.ErrPermission
+	}
+
+	if lba+uint64(blocks) > s.BlockCount() {
+		return 0, io.EOF
+	}
+
+	if uint64(len(buf)) < uint64(blocks)*FATSectorSize {
+		return 0, io.ErrShortBuffer
+	}
+
+	for i := uint32(0); i < blocks; i++ {
+		n := lba + uint64(i)
+		sector, ok := s.sectors[n]
+		if !ok {
+			sector = make([]byte, FATSectorSize)
+			s.sectors[n] = sector
+		}
+		copy(sector, buf[i*FATSectorSize:(i+1)*FATSectorSize])
+		s.dirty[n] = struct{}{}
+	}
+	s.lastWrite = time.Now()
+	return blocks, nil
+}
+
+// LastWrite returns the time of the last write by the host, or the zero
+// time if none.
+func (s *FATStorage) LastWrite() time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.lastWrite
+}
+
+// SetOnFileWritten sets the callback invoked by Sync for each file the host
+// created or modified, with its path in the volume and its contents.
+func (s *FATStorage) SetOnFileWritten(cb func(name string, data []byte)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onFileWritten = cb
+}
+
+// Sync walks the directories of the volume as written by the host and
+// reports the files created or modified since the last Sync to the
+// OnFileWritten callback. Files whose clusters are not all allocated yet
+// are reported by a later Sync.
+//
+// Sync runs on SYNCHRONIZE CACHE, but hosts rarely send it, since the
+// device reports no write cache. Applications can also call Sync once
+// writes have stopped for a while (see LastWrite).
+func (s *FATStorage) Sync() error {
+	s.mutex.Lock()
+	if s.onFileWritten == nil || len(s.dirty) == 0 {
+		s.mutex.Unlock()
+		return nil
+	}
+	written, err := s.scanLocked()
+	cb := s.onFileWritten
+	s.mutex.Unlock()
+
+	for _, f := range written {
+		cb(f.name, f.data)
+	}
+	return err
+}
+
+// scanLocked walks the written volume and returns the files created or
+// modified since the last scan. The caller must hold s.mutex.
+func (s *FATStorage) scanLocked() ([]fatWritten, error) {
+	fat := make([]byte, s.fatSectors*FATSectorSize)
+	if _, err := s.readLocked(FATPartitionStart+uint64(s.reserved), s.fatSectors, fat); err != nil {
+		return nil, err
+	}
+
+	// Clusters with sectors written since the last scan
+	dataStart := FATPartitionStart + uint64(s.dataStart)
+	dirty := make(map[uint32]bool)
+	for n := range s.dirty {
+		if n >= dataStart {
+			dirty[uint32((n-dataStart)/uint64(s.spc))+2] = true
+		}
+	}
+
+	var root []byte
+	if s.fat32 {
+		first := s.nodes[0].first
+		var err error
+		if root, err = s.readClustersLocked(s.chain(fat, first, 0)); err != nil {
+			return nil, err
+		}
+	} else {
+		rootSectors := s.dataStart - s.rootStart
+		root = make([]byte, rootSectors*FATSectorSize)
+		if _, err := s.readLocked(FATPartitionStart+uint64(s.rootStart), rootSectors, root); err != nil {
+			return nil, err
+		}
+	}
+
+	files := make(map[string]fatFile)
+	var written []fatWritten
+	if err := s.walkLocked(fat, ".", root, 0, dirty, files, &written); err != nil {
+		return nil, err
+	}
+
+	s.files = files
+	clear(s.dirty)
+	return written, nil
+}
+
+// walkLocked walks the directory at dir with the given entries, adding its
+// files to files and the changed ones to written. The caller must hold
+// s.mutex.
+func (s *FATStorage) walkLocked(fat []byte, dir string, entries []byte, depth int,
+	dirty map[uint32]bool, files map[string]fatFile, written *[]fatWritten) error {
+	var p fatDirParser
+	for off := 0; off+fatDirEntrySize <= len(entries); off += fatDirEntrySize {
+		name, attr, first, size, ok, done := p.parse(entries[off : off+fatDirEntrySize])
+		if done {
+			break
+		}
+		if !ok {
+			continue
+		}
+		if !s.fat32 {
+			first &= 0xFFFF
+		}
+		name = path.Join(dir, name)
+
+		if attr&fatAttrDirectory != 0 {
+			if depth == fatMaxDepth {
+				continue
+			}
+			data, err := s.readClustersLocked(s.chain(fat, first, 0))
+			if err != nil {
+				return err
+			}
+			if err := s.walkLocked(fat, name, data, depth+1, dirty, files, written); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var chain []uint32
+		if size > 0 {
+			clusterSize := s.spc * FATSectorSize
+			chain = s.chain(fat, first, (size+clusterSize-1)/clusterSize)
+			if chain == nil {
+				continue // Incomplete, report later
+			}
+		}
+
+		file := fatFile{first: first, size: size}
+		files[name] = file
+		prev, ok := s.files[name]
+		changed := !ok || prev != file
+		for _, c := range chain {
+			changed = changed || dirty[c]
+		}
+		if !changed {
+			continue
+		}
+
+		data, err := s.readClustersLocked(chain)
+		if err != nil {
+			return err
+		}
+		*written = append(*written, fatWritten{name: name, data: data[:size]})
+	}
+	return nil
+}
+
+// chain returns the clusters of the chain starting at first, following
+// fat. If length is 0 the whole chain is returned; otherwise its first
+// length clusters. Returns nil if the chain is invalid or too short.
+func (s *FATStorage) chain(fat []byte, first uint32, length uint32) []uint32 {
+	var out []uint32
+	for c := first; c != fatEOC; c = s.fatNext(fat, c) {
+		if c < 2 || c >= s.clusters+2 || uint32(len(out)) > s.clusters {
+			return nil
+		}
+		out = append(out, c)
+		if length > 0 && uint32(len(out)) == length {
+			return out
+		}
+	}
+	if length > 0 {
+		return nil
+	}
+	return out
+}
+
+// fatNext returns the cluster after c in fat, or fatEOC at the end of the
+// chain.
+func (s *FATStorage) fatNext(fat []byte, c uint32) uint32 {
+	if s.fat32 {
+		next := binary.LittleEndian.Uint32(fat[c*4:]) & 0x0FFFFFFF
+		if next >= 0x0FFFFFF8 {
+			return fatEOC
+		}
+		return next
+	}
+	next := uint32(binary.LittleEndian.Uint16(fat[c*2:]))
+	if next >= 0xFFF8 {
+		return fatEOC
+	}
+	return next
+}
+
+// readClustersLocked reads the given clusters. The caller must hold
+// s.mutex.
+func (s *FATStorage) readClustersLocked(clusters []uint32) ([]byte, error) {
+	clusterSize := s.spc * FATSectorSize
+	data := make([]byte, len(clusters)*int(clusterSize))
+	for i, c := range clusters {
+		lba := FATPartitionStart + uint64(s.dataStart) + uint64(c-2)*uint64(s.spc)
+		if _, err := s.readLocked(lba, s.spc, data[uint32(i)*clusterSize:]); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// IsReadOnly returns whether the volume is read-only.
+func (s *FATStorage) IsReadOnly() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.readOnly
+}
+
+// SetReadOnly sets the read-only flag. The volume is read-only by default.
+func (s *FATStorage) SetReadOnly(readOnly bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readOnly = readOnly
+}
+
+// IsRemovable returns false; the volume is not removable.
+func (s *FATStorage) IsRemovable() bool {
+	return false
+}
+
+// IsPresent returns true; the volume is always present.
+func (s *FATStorage) IsPresent() bool {
+	return true
+}
+
+// Eject returns os.ErrPermission, as the volume is not removable.
+func (s *FATStorage) Eject() error {
+	return os.ErrPermission
+}
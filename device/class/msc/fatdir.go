@@ -0,0 +1,289 @@
+package msc
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// FAT directory entry attributes.
+const (
+	fatAttrReadOnly  = 0x01
+	fatAttrVolumeID  = 0x08
+	fatAttrDirectory = 0x10
+	fatAttrArchive   = 0x20
+	fatAttrLongName  = 0x0F // Long file name entry
+)
+
+// FAT directory entry constants.
+const (
+	fatDirEntrySize = 32   // Size of a directory entry
+	fatLFNChars     = 13   // UTF-16 characters per long name entry
+	fatLFNLast      = 0x40 // Sequence number flag of the last long name entry
+	fatMaxNameLen   = 255  // Longest long name in UTF-16 characters
+	fatDeleted      = 0xE5 // First name byte of a deleted entry
+	fatLowerBase    = 0x08 // Short name base is lowercase (NT flags)
+	fatLowerExt     = 0x10 // Short name extension is lowercase (NT flags)
+)
+
+// fatShortNameChars are the punctuation characters allowed in short names.
+const fatShortNameChars = "!#$%&'()-@^_`{}~"
+
+// fatBlankName is an empty short name, padded with spaces.
+var fatBlankName = [11]byte{' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' '}
+
+// fatShortName returns the short (8.3) name for name and whether name
+// needs long name entries. Short names generated for long names get a
+// numeric tail that is unique among the names in used, which is updated.
+func fatShortName(name string, used map[[11]byte]bool) (short [11]byte, lfn bool) {
+	base, ext := name, ""
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+
+	// Names that are valid uppercase 8.3 names are stored as is
+	if name == strings.ToUpper(name) && len(base) <= 8 && len(ext) <= 3 &&
+		fatValidShort(base) && fatValidShort(ext) && base != "" {
+		short = fatBlankName
+		copy(short[:8], base)
+		copy(short[8:], ext)
+		if !used[short] {
+			used[short] = true
+			return short, false
+		}
+	}
+
+	// Otherwise derive a basis name and add a numeric tail (~1, ~2, ...)
+	basis := fatBasisName(strings.TrimLeft(base, "."))
+	extBasis := fatBasisName(ext)
+	for n := 1; ; n++ {
+		tail := "~" + strconv.Itoa(n)
+		b := basis
+		if len(b)+len(tail) > 8 {
+			b = b[:8-len(tail)]
+		}
+		short = fatBlankName
+		copy(short[:8], b+tail)
+		copy(short[8:], extBasis)
+		if !used[short] {
+			used[short] = true
+			return short, true
+		}
+	}
+}
+
+// fatValidShort reports whether s contains only characters allowed in
+// short names, other than lowercase letters.
+func fatValidShort(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.IndexByte(fatShortNameChars, c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// fatBasisName converts s to uppercase short name characters, dropping
+// spaces and dots and replacing other invalid characters with '_'.
+func fatBasisName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		switch {
+		case r == ' ' || r == '.':
+			continue
+		case r < 0x80 && fatValidShort(string(r)):
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// fatShortNameString returns the name stored in a short name, applying the
+// NT lowercase flags.
+func fatShortNameString(short []byte, flags uint8) string {
+	base := strings.TrimRight(string(short[:8]), " ")
+	ext := strings.TrimRight(string(short[8:11]), " ")
+	if flags&fatLowerBase != 0 {
+		base = strings.ToLower(base)
+	}
+	if flags&fatLowerExt != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// fatChecksum returns the checksum of a short name stored in long name
+// entries.
+func fatChecksum(short []byte) uint8 {
+	var sum uint8
+	for _, c := range short[:11] {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+	return sum
+}
+
+// fatLFNEntries returns the number of long name entries needed for name.
+func fatLFNEntries(name string) int {
+	n := len(utf16.Encode([]rune(name)))
+	return (n + fatLFNChars - 1) / fatLFNChars
+}
+
+// fatLFNOffsets are the offsets of the UTF-16 characters in a long name
+// entry.
+var fatLFNOffsets = [fatLFNChars]int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30}
+
+// fatDateTime returns t as a FAT date and time. Times before 1980 are
+// stored as 1980-01-01.
+func fatDateTime(t time.Time) (date, tm uint16) {
+	if t.Year() < 1980 {
+		return 1<<5 | 1, 0
+	}
+	date = uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day())
+	tm = uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2)
+	return date, tm
+}
+
+// fatEntry describes one directory entry to marshal.
+type fatEntry struct {
+	name    string   // Long name, used if lfn is set
+	short   [11]byte // Short name
+	lfn     bool     // Precede the entry with long name entries
+	attr    uint8    // Attributes (fatAttr*)
+	cluster uint32   // First cluster
+	size    uint32   // File size in bytes
+	modTime time.Time
+}
+
+// entries returns the number of directory entries used by e.
+func (e *fatEntry) entries() int {
+	if e.lfn {
+		return fatLFNEntries(e.name) + 1
+	}
+	return 1
+}
+
+// marshalTo writes the long name entries, if any, and the directory entry
+// to buf. Returns the number of bytes written.
+func (e *fatEntry) marshalTo(buf []byte) int {
+	off := 0
+	if e.lfn {
+		name := utf16.Encode([]rune(e.name))
+		count := fatLFNEntries(e.name)
+		sum := fatChecksum(e.short[:])
+		for seq := count; seq >= 1; seq-- {
+			ent := buf[off : off+fatDirEntrySize]
+			clear(ent)
+			ent[0] = uint8(seq)
+			if seq == count {
+				ent[0] |= fatLFNLast
+			}
+			ent[11] = fatAttrLongName
+			ent[13] = sum
+			for i, o := range fatLFNOffsets {
+				c := uint16(0xFFFF) // Padding after the terminator
+				switch k := (seq-1)*fatLFNChars + i; {
+				case k < len(name):
+					c = name[k]
+				case k == len(name):
+					c = 0
+				}
+				binary.LittleEndian.PutUint16(ent[o:], c)
+			}
+			off += fatDirEntrySize
+		}
+	}
+
+	ent := buf[off : off+fatDirEntrySize]
+	clear(ent)
+	copy(ent[0:11], e.short[:])
+	ent[11] = e.attr
+	date, tm := fatDateTime(e.modTime)
+	binary.LittleEndian.PutUint16(ent[14:16], tm)   // Creation time
+	binary.LittleEndian.PutUint16(ent[16:18], date) // Creation date
+	binary.LittleEndian.PutUint16(ent[18:20], date) // Last access date
+	binary.LittleEndian.PutUint16(ent[20:22], uint16(e.cluster>>16))
+	binary.LittleEndian.PutUint16(ent[22:24], tm)
+	binary.LittleEndian.PutUint16(ent[24:26], date)
+	binary.LittleEndian.PutUint16(ent[26:28], uint16(e.cluster))
+	binary.LittleEndian.PutUint32(ent[28:32], e.size)
+
+	return off + fatDirEntrySize
+}
+
+// fatDirParser reassembles names from the directory entries of a
+// directory, one entry at a time.
+type fatDirParser struct {
+	name [fatMaxNameLen + fatLFNChars]uint16
+	sum  uint8
+	seq  int // Expected sequence number of the next long name entry, or 0
+	long bool
+}
+
+// parse processes one directory entry. It returns the name, attributes,
+// first cluster, and size of the file or directory it describes, and false
+// for long name, deleted, volume label, and dot entries. done is set at
+// the end of the directory.
+func (p *fatDirParser) parse(ent []byte) (name string, attr uint8, cluster, size uint32, ok, done bool) {
+	switch {
+	case ent[0] == 0:
+		return "", 0, 0, 0, false, true
+
+	case ent[0] == fatDeleted:
+		p.long = false
+		return "", 0, 0, 0, false, false
+
+	case ent[11]&0x3F == fatAttrLongName:
+		seq := int(ent[0] & 0x1F)
+		if seq == 0 || seq*fatLFNChars > len(p.name) {
+			// Malformed sequence number
+			p.long = false
+			return "", 0, 0, 0, false, false
+		}
+		if ent[0]&fatLFNLast != 0 {
+			p.seq = seq
+			p.sum = ent[13]
+			p.long = true
+			clear(p.name[:])
+		}
+		if !p.long || seq != p.seq || ent[13] != p.sum {
+			p.long = false
+			return "", 0, 0, 0, false, false
+		}
+		for i, o := range fatLFNOffsets {
+			p.name[(seq-1)*fatLFNChars+i] = binary.LittleEndian.Uint16(ent[o:])
+		}
+		p.seq--
+		return "", 0, 0, 0, false, false
+	}
+
+	long := p.long && p.seq == 0 && fatChecksum(ent[0:11]) == p.sum
+	p.long = false
+
+	attr = ent[11]
+	if attr&fatAttrVolumeID != 0 || ent[0] == '.' {
+		return "", 0, 0, 0, false, false
+	}
+
+	if long {
+		n := 0
+		for n < len(p.name) && p.name[n] != 0 && p.name[n] != 0xFFFF {
+			n++
+		}
+		name = string(utf16.Decode(p.name[:n]))
+	} else {
+		name = fatShortNameString(ent[0:11], ent[12])
+	}
+	cluster = uint32(binary.LittleEndian.Uint16(ent[20:22]))<<16 |
+		uint32(binary.LittleEndian.Uint16(ent[26:28]))
+	size = binary.LittleEndian.Uint32(ent[28:32])
+	return name, attr, cluster, size, true, false
+}
@@ -0,0 +1,219 @@
+package msc
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// lfnEntry returns a long name entry with the given sequence byte and
+// checksum.
+func lfnEntry(seq, sum uint8) []byte {
+	ent := make([]byte, fatDirEntrySize)
+	ent[0] = seq
+	ent[11] = fatAttrLongName
+	ent[13] = sum
+	for _, o := range fatLFNOffsets {
+		ent[o] = 'x'
+	}
+	return ent
+}
+
+func TestFATDirParserRoundTrip(t *testing.T) {
+	used := make(map[[11]byte]bool)
+	names := []string{"README.TXT", "a long file name.txt", "Übersicht.md", "x"}
+
+	var buf []byte
+	for _, name := range names {
+		short, lfn := fatShortName(name, used)
+		e := fatEntry{name: name, short: short, lfn: lfn, cluster: 0x12345, size: 42}
+		ent := make([]byte, e.entries()*fatDirEntrySize)
+		if n := e.marshalTo(ent); n != len(ent) {
+			t.Fatalf("marshalTo(%q) = %d, want %d", name, n, len(ent))
+		}
+		buf = append(buf, ent...)
+	}
+	buf = append(buf, make([]byte, fatDirEntrySize)...) // End of directory
+
+	var p fatDirParser
+	var got []string
+	for off := 0; off < len(buf); off += fatDirEntrySize {
+		name, _, cluster, size, ok, done := p.parse(buf[off : off+fatDirEntrySize])
+		if done {
+			break
+		}
+		if !ok {
+			continue
+		}
+		if cluster != 0x12345 || size != 42 {
+			t.Errorf("%q: cluster = 0x%X, size = %d, want 0x12345, 42", name, cluster, size)
+		}
+		got = append(got, name)
+	}
+
+	if len(got) != len(names) {
+		t.Fatalf("parsed %v, want %v", got, names)
+	}
+	for i := range names {
+		if got[i] != names[i] {
+			t.Errorf("name %d = %q, want %q", i, got[i], names[i])
+		}
+	}
+}
+
+func TestFATDirParserMalformedLongName(t *testing.T) {
+	short := [11]byte{'F', 'I', 'L', 'E', ' ', ' ', ' ', ' ', 'T', 'X', 'T'}
+	sum := fatChecksum(short[:])
+
+	tests := []struct {
+		name    string
+		entries [][]byte
+	}{
+		{"sequence 31", [][]byte{lfnEntry(fatLFNLast|0x1F, sum)}},
+		{"sequence 21", [][]byte{lfnEntry(fatLFNLast|21, sum)}},
+		{"sequence 0", [][]byte{lfnEntry(fatLFNLast, sum)}},
+		{"sequence 31 not last", [][]byte{lfnEntry(0x1F, sum)}},
+		{"missing last flag", [][]byte{lfnEntry(1, sum)}},
+		{"out of order", [][]byte{lfnEntry(fatLFNLast|2, sum), lfnEntry(2, sum)}},
+		{"incomplete", [][]byte{lfnEntry(fatLFNLast|2, sum)}},
+		{"checksum mismatch", [][]byte{lfnEntry(fatLFNLast|1, sum+1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p fatDirParser
+			for _, ent := range tt.entries {
+				if _, _, _, _, ok, done := p.parse(ent); ok || done {
+					t.Fatalf("long name entry: ok = %v, done = %v", ok, done)
+				}
+			}
+
+			// The short entry that follows falls back to its short name
+			ent := make([]byte, fatDirEntrySize)
+			copy(ent, short[:])
+			ent[11] = fatAttrArchive
+			name, _, _, _, ok, _ := p.parse(ent)
+			if !ok || name != "FILE.TXT" {
+				t.Errorf("parse() = %q, %v, want %q, true", name, ok, "FILE.TXT")
+			}
+		})
+	}
+}
+
+func TestFATDirParserSkippedEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		ent  []byte
+		done bool
+	}{
+		{"end of directory", make([]byte, fatDirEntrySize), true},
+		{"deleted", append([]byte{fatDeleted, 'A', 'B'}, make([]byte, 29)...), false},
+		{"volume label", append([]byte("LABEL      "), append([]byte{fatAttrVolumeID}, make([]byte, 20)...)...), false},
+		{"dot", append([]byte(".          "), append([]byte{fatAttrDirectory}, make([]byte, 20)...)...), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p fatDirParser
+			_, _, _, _, ok, done := p.parse(tt.ent)
+			if ok || done != tt.done {
+				t.Errorf("parse() ok = %v, done = %v, want false, %v", ok, done, tt.done)
+			}
+		})
+	}
+}
+
+// newWritableFAT returns a writable FAT volume holding hello.txt, and the
+// LBA of its root directory.
+func newWritableFAT(t *testing.T) (*FATStorage, uint64) {
+	t.Helper()
+	fsys := fstest.MapFS{"hello.txt": {Data: []byte("hello")}}
+	s, err := NewFATStorage(fsys, 0, "TEST")
+	if err != nil {
+		t.Fatalf("NewFATStorage() error = %v", err)
+	}
+	s.SetReadOnly(false)
+	if s.IsFAT32() {
+		t.Fatal("expected a FAT16 volume")
+	}
+	return s, FATPartitionStart + uint64(s.rootStart)
+}
+
+func TestFATStorageSyncMalformedDirectory(t *testing.T) {
+	s, rootLBA := newWritableFAT(t)
+
+	var reported []string
+	s.SetOnFileWritten(func(name string, data []byte) {
+		reported = append(reported, name)
+	})
+
+	sector := make([]byte, FATSectorSize)
+	if _, err := s.Read(rootLBA, 1, sector); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	// Fill the directory with long name entries carrying invalid sequence
+	// numbers, then a cluster number far out of range
+	for off := 0; off+fatDirEntrySize <= len(sector)-fatDirEntrySize; off += fatDirEntrySize {
+		copy(sector[off:], lfnEntry(fatLFNLast|0x1F, 0))
+	}
+	ent := sector[len(sector)-fatDirEntrySize:]
+	copy(ent, "BAD     TXT")
+	ent[11] = fatAttrArchive
+	for i := 20; i < 32; i++ {
+		ent[i] = 0xFF
+	}
+
+	if _, err := s.Write(rootLBA, 1, sector); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(reported) != 0 {
+		t.Errorf("reported %v, want none", reported)
+	}
+}
+
+func TestFATStorageSyncModifiedFile(t *testing.T) {
+	s, rootLBA := newWritableFAT(t)
+
+	var data []byte
+	s.SetOnFileWritten(func(name string, d []byte) {
+		if name == "hello.txt" {
+			data = append([]byte{}, d...)
+		}
+	})
+
+	// Locate the file's first cluster from the root directory
+	root := make([]byte, FATSectorSize)
+	if _, err := s.Read(rootLBA, 1, root); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	var p fatDirParser
+	var first uint32
+	for off := 0; off < len(root); off += fatDirEntrySize {
+		name, _, cluster, _, ok, done := p.parse(root[off : off+fatDirEntrySize])
+		if done {
+			break
+		}
+		if ok && name == "hello.txt" {
+			first = cluster
+		}
+	}
+	if first < 2 {
+		t.Fatal("hello.txt not found in the root directory")
+	}
+
+	lba := FATPartitionStart + uint64(s.dataStart) + uint64(first-2)*uint64(s.spc)
+	sector := make([]byte, FATSectorSize)
+	copy(sector, "HELLO")
+	if _, err := s.Write(lba, 1, sector); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if string(data) != "HELLO" {
+		t.Errorf("reported data = %q, want %q", data, "HELLO")
+	}
+}
@@ -0,0 +1,196 @@
+package msc
+
+import "encoding/binary"
+
+// CommandIU represents a Command IU in USB Attached SCSI.
+type CommandIU struct {
+	Tag           uint16   // Command tag, unique among outstanding commands
+	Priority      uint8    // Command priority (0-15)
+	TaskAttribute uint8    // Task attribute (TaskAttr*)
+	LUN           uint64   // Logical Unit Number (SAM-5 format)
+	CDB           [16]byte // SCSI CDB
+}
+
+// ParseCommandIU parses a Command IU from raw bytes.
+// Returns false if data is too short or is not a Command IU.
+// CDBs longer than 16 bytes are not supported.
+func ParseCommandIU(data []byte, out *CommandIU) bool {
+	if len(data) < CommandIUSize || data[0] != IUIDCommand {
+		return false
+	}
+
+	out.Tag = binary.BigEndian.Uint16(data[2:4])
+	out.Priority = (data[4] >> 3) & 0x0F
+	out.TaskAttribute = data[4] & 0x07
+	out.LUN = binary.BigEndian.Uint64(data[8:16])
+	copy(out.CDB[:], data[16:32])
+
+	return true
+}
+
+// MarshalTo writes the Command IU to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (iu *CommandIU) MarshalTo(buf []byte) int {
+	if len(buf) < CommandIUSize {
+		return 0
+	}
+
+	clear(buf[:CommandIUSize])
+	buf[0] = IUIDCommand
+	binary.BigEndian.PutUint16(buf[2:4], iu.Tag)
+	buf[4] = (iu.Priority&0x0F)<<3 | iu.TaskAttribute&0x07
+	binary.BigEndian.PutUint64(buf[8:16], iu.LUN)
+	copy(buf[16:32], iu.CDB[:])
+
+	return CommandIUSize
+}
+
+// TaskMgmtIU represents a Task Management IU in USB Attached SCSI.
+type TaskMgmtIU struct {
+	Tag        uint16 // Tag of this task management function
+	Function   uint8  // Task management function (TaskMgmt*)
+	ManagedTag uint16 // Tag of the task to manage (ABORT TASK, QUERY TASK)
+	LUN        uint64 // Logical Unit Number (SAM-5 format)
+}
+
+// ParseTaskMgmtIU parses a Task Management IU from raw bytes.
+// Returns false if data is too short or is not a Task Management IU.
+func ParseTaskMgmtIU(data []byte, out *TaskMgmtIU) bool {
+	if len(data) < TaskMgmtIUSize || data[0] != IUIDTaskMgmt {
+		return false
+	}
+
+	out.Tag = binary.BigEndian.Uint16(data[2:4])
+	out.Function = data[4]
+	out.ManagedTag = binary.BigEndian.Uint16(data[6:8])
+	out.LUN = binary.BigEndian.Uint64(data[8:16])
+
+	return true
+}
+
+// MarshalTo writes the Task Management IU to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (iu *TaskMgmtIU) MarshalTo(buf []byte) int {
+	if len(buf) < TaskMgmtIUSize {
+		return 0
+	}
+
+	clear(buf[:TaskMgmtIUSize])
+	buf[0] = IUIDTaskMgmt
+	binary.BigEndian.PutUint16(buf[2:4], iu.Tag)
+	buf[4] = iu.Function
+	binary.BigEndian.PutUint16(buf[6:8], iu.ManagedTag)
+	binary.BigEndian.PutUint64(buf[8:16], iu.LUN)
+
+	return TaskMgmtIUSize
+}
+
+// SenseIU represents a Sense IU in USB Attached SCSI, which completes a
+// command with its status and, on CHECK CONDITION, its sense data.
+type SenseIU struct {
+	Tag             uint16                // Tag of the completed command
+	StatusQualifier uint16                // Status qualifier
+	Status          uint8                 // SCSI status (Status*)
+	Sense           *RequestSenseResponse // Sense data, or nil for none
+}
+
+// MarshalTo writes the Sense IU to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (iu *SenseIU) MarshalTo(buf []byte) int {
+	if len(buf) < SenseIUHeaderSize {
+		return 0
+	}
+
+	clear(buf[:SenseIUHeaderSize])
+	n := 0
+	if iu.Sense != nil {
+		n = iu.Sense.MarshalTo(buf[SenseIUHeaderSize:])
+		if n == 0 {
+			return 0
+		}
+	}
+
+	buf[0] = IUIDSense
+	binary.BigEndian.PutUint16(buf[2:4], iu.Tag)
+	binary.BigEndian.PutUint16(buf[4:6], iu.StatusQualifier)
+	buf[6] = iu.Status
+	binary.BigEndian.PutUint16(buf[14:16], uint16(n))
+
+	return SenseIUHeaderSize + n
+}
+
+// ResponseIU represents a Response IU in USB Attached SCSI, which completes
+// a task management function or rejects an information unit.
+type ResponseIU struct {
+	Tag            uint16  // Tag of the IU being answered
+	AdditionalInfo [3]byte // Additional response information
+	Code           uint8   // Response code (Response*)
+}
+
+// MarshalTo writes the Response IU to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (iu *ResponseIU) MarshalTo(buf []byte) int {
+	if len(buf) < ResponseIUSize {
+		return 0
+	}
+
+	buf[0] = IUIDResponse
+	buf[1] = 0
+	binary.BigEndian.PutUint16(buf[2:4], iu.Tag)
+	copy(buf[4:7], iu.AdditionalInfo[:])
+	buf[7] = iu.Code
+
+	return ResponseIUSize
+}
+
+// MarshalReadyIU writes a Read Ready or Write Ready IU to buf, telling the
+// host to start the data phase of the command with the given tag.
+// Returns the number of bytes written, or 0 if buf is too small.
+func MarshalReadyIU(buf []byte, id uint8, tag uint16) int {
+	if len(buf) < ReadyIUSize {
+		return 0
+	}
+
+	buf[0] = id
+	buf[1] = 0
+	binary.BigEndian.PutUint16(buf[2:4], tag)
+
+	return ReadyIUSize
+}
+
+// PipeUsageDescriptor represents the UAS pipe usage descriptor that follows
+// each endpoint descriptor of the UAS alternate setting.
+type PipeUsageDescriptor struct {
+	PipeID uint8 // Pipe ID (PipeID*)
+}
+
+// MarshalTo writes the pipe usage descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *PipeUsageDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < PipeUsageDescriptorSize {
+		return 0
+	}
+
+	buf[0] = PipeUsageDescriptorSize
+	buf[1] = DescriptorTypePipeUsage
+	buf[2] = d.PipeID
+	buf[3] = 0 // Reserved
+
+	return PipeUsageDescriptorSize
+}
+
+// NewLUN returns the SAM-5 single level LUN structure for a Logical Unit
+// Number, as carried in Command and Task Management IUs.
+func NewLUN(lun uint8) uint64 {
+	return uint64(lun) << 48
+}
+
+// lunNumber returns the Logical Unit Number addressed by a SAM-5 LUN
+// structure, or 0xFF if it does not use peripheral device addressing with
+// a single level.
+func lunNumber(lun uint64) uint8 {
+	if lun&^(0xFF<<48) != 0 {
+		return 0xFF
+	}
+	return uint8(lun >> 48)
+}
@@ -0,0 +1,115 @@
+package msc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCommandIU(t *testing.T) {
+	data := []byte{
+		IUIDCommand, 0x00, 0x12, 0x34, // IU ID, reserved, tag
+		0x7A, 0x00, 0x00, 0x00, // Priority 15, ordered task, reserved, CDB length
+		0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUN 3
+		SCSIRead10, 0, 0, 0, 0, 1, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, // CDB
+	}
+	var iu CommandIU
+	if !ParseCommandIU(data, &iu) {
+		t.Fatal("ParseCommandIU() = false")
+	}
+	want := CommandIU{
+		Tag:           0x1234,
+		Priority:      15,
+		TaskAttribute: TaskAttrOrdered,
+		LUN:           NewLUN(3),
+		CDB:           [16]byte{SCSIRead10, 0, 0, 0, 0, 1, 0, 0, 2},
+	}
+	if iu != want {
+		t.Errorf("ParseCommandIU() = %+v, want %+v", iu, want)
+	}
+
+	var buf [CommandIUSize]byte
+	if n := iu.MarshalTo(buf[:]); n != CommandIUSize || !bytes.Equal(buf[:], data) {
+		t.Errorf("MarshalTo() = %d, % X, want % X", n, buf[:n], data)
+	}
+
+	for _, bad := range [][]byte{data[:CommandIUSize-1], append([]byte{IUIDTaskMgmt}, data[1:]...)} {
+		if ParseCommandIU(bad, &iu) {
+			t.Errorf("ParseCommandIU(% X) = true, want false", bad)
+		}
+	}
+}
+
+func TestTaskMgmtIU(t *testing.T) {
+	data := []byte{
+		IUIDTaskMgmt, 0x00, 0x00, 0x09, // IU ID, reserved, tag
+		TaskMgmtAbortTask, 0x00, 0x00, 0x01, // Function, reserved, managed tag
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // LUN 1
+	}
+	var iu TaskMgmtIU
+	if !ParseTaskMgmtIU(data, &iu) {
+		t.Fatal("ParseTaskMgmtIU() = false")
+	}
+	want := TaskMgmtIU{Tag: 9, Function: TaskMgmtAbortTask, ManagedTag: 1, LUN: NewLUN(1)}
+	if iu != want {
+		t.Errorf("ParseTaskMgmtIU() = %+v, want %+v", iu, want)
+	}
+
+	var buf [TaskMgmtIUSize]byte
+	if n := iu.MarshalTo(buf[:]); n != TaskMgmtIUSize || !bytes.Equal(buf[:], data) {
+		t.Errorf("MarshalTo() = %d, % X, want % X", n, buf[:n], data)
+	}
+
+	var cmd CommandIU
+	if ParseTaskMgmtIU(data[:TaskMgmtIUSize-1], &iu) || ParseCommandIU(data, &cmd) {
+		t.Error("parsed a short or mistyped Task Management IU")
+	}
+}
+
+func TestStatusIUs(t *testing.T) {
+	var buf [SenseIUMaxSize]byte
+
+	sense := SenseIU{Tag: 0x0102, Status: StatusCheckCondition,
+		Sense: NewRequestSenseResponse(SenseIllegalRequest, ASCLBAOutOfRange, 0)}
+	n := sense.MarshalTo(buf[:])
+	header := []byte{IUIDSense, 0, 0x01, 0x02, 0, 0, StatusCheckCondition, 0, 0, 0, 0, 0, 0, 0, 0, 18}
+	if n != SenseIUHeaderSize+18 || !bytes.Equal(buf[:SenseIUHeaderSize], header) {
+		t.Errorf("Sense IU = % X, want header % X and 18 bytes of sense", buf[:n], header)
+	}
+	if buf[SenseIUHeaderSize+2] != SenseIllegalRequest || buf[SenseIUHeaderSize+12] != ASCLBAOutOfRange {
+		t.Errorf("Sense IU sense data = % X", buf[SenseIUHeaderSize:n])
+	}
+
+	good := SenseIU{Tag: 7, Status: StatusGood}
+	if n := good.MarshalTo(buf[:]); n != SenseIUHeaderSize || buf[15] != 0 {
+		t.Errorf("Sense IU without sense = % X", buf[:n])
+	}
+	if n := sense.MarshalTo(buf[:SenseIUHeaderSize]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+
+	resp := ResponseIU{Tag: 9, Code: ResponseOverlappedTag}
+	if n := resp.MarshalTo(buf[:]); !bytes.Equal(buf[:n], []byte{IUIDResponse, 0, 0, 9, 0, 0, 0, ResponseOverlappedTag}) {
+		t.Errorf("Response IU = % X", buf[:n])
+	}
+
+	if n := MarshalReadyIU(buf[:], IUIDWriteReady, 0xABCD); !bytes.Equal(buf[:n], []byte{IUIDWriteReady, 0, 0xAB, 0xCD}) {
+		t.Errorf("Write Ready IU = % X", buf[:n])
+	}
+}
+
+func TestLUNNumber(t *testing.T) {
+	tests := []struct {
+		lun  uint64
+		want uint8
+	}{
+		{NewLUN(0), 0},
+		{NewLUN(15), 15},
+		{0x4001 << 48, 0xFF},      // Flat space addressing
+		{NewLUN(1) | 1<<32, 0xFF}, // Second level
+	}
+	for _, tt := range tests {
+		if got := lunNumber(tt.lun); got != tt.want {
+			t.Errorf("lunNumber(%#016x) = %d, want %d", tt.lun, got, tt.want)
+		}
+	}
+}
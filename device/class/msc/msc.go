@@ -9,58 +9,91 @@ import (
 	"github.com/ardnew/softusb/pkg"
 )
 
-// MSC implements the Mass Storage Class Bulk-Only Transport driver.
+// MSC implements the Mass Storage Class driver with the Bulk-Only Transport
+// and, if configured with ConfigureDeviceUAS, the USB Attached SCSI
+// transport.
 type MSC struct {
 	// Interface
 	iface *device.Interface
 
-	// Endpoints
+	// Bulk-Only Transport endpoints (alternate setting 0)
 	bulkInEP  *device.Endpoint // Bulk IN (device to host)
 	bulkOutEP *device.Endpoint // Bulk OUT (host to device)
 
+	// USB Attached SCSI pipes (alternate setting 1)
+	commandEP *device.Endpoint // Command pipe (bulk OUT)
+	statusEP  *device.Endpoint // Status pipe (bulk IN)
+	dataInEP  *device.Endpoint // Data-in pipe (bulk IN)
+	dataOutEP *device.Endpoint // Data-out pipe (bulk OUT)
+
+	// USB Attached SCSI transport state
+	uas       uasTransport
+	uasActive bool // UAS alternate setting selected
+
+	// Interrupts Run when the transport changes
+	transportCancel context.CancelFunc
+
 	// Stack reference for data transfer
 	stack *device.Stack
 
-	// Storage backend
-	storage Storage
-
-	// Device information
-	inquiry InquiryResponse
+	// Logical units (storage, INQUIRY data, and sense data per LUN)
+	luns [MaxLUNs]logicalUnit
 
 	// Current command state
 	currentCBW  CommandBlockWrapper
 	currentTag  uint32
 	dataResidue uint32
 
-	// Sense data (for REQUEST SENSE)
-	senseKey uint8
-	asc      uint8
-	ascq     uint8
-
 	// Buffers (zero-allocation pattern)
-	cbwBuf  [CBWSize]byte
-	cswBuf  [CSWSize]byte
-	dataBuf [MaxTransferSize]byte
+	cbwBuf   [CBWSize]byte
+	cswBuf   [CSWSize]byte
+	dataBuf  [MaxTransferSize]byte
 	senseBuf [18]byte
+	lunBuf   [1]byte // GET_MAX_LUN response
 
 	// State
 	mutex      sync.RWMutex
 	configured bool
 
-	// Logical Unit Number (typically 0)
+	// Highest Logical Unit Number (0 for a single LUN)
 	maxLUN uint8
 }
 
-// New creates a new MSC class driver with the given storage backend.
-// vendorID and productID are 8 and 16 character strings respectively.
+// logicalUnit is the state of one Logical Unit.
+type logicalUnit struct {
+	// Storage backend
+	storage Storage
+
+	// Device information
+	inquiry InquiryResponse
+	serial  string // Unit serial number (VPD page 0x80)
+
+	// Sense data (for REQUEST SENSE)
+	senseKey uint8
+	asc      uint8
+	ascq     uint8
+}
+
+// New creates a new MSC class driver with the given storage backend as
+// LUN 0. vendorID and productID are 8 and 16 character strings
+// respectively. Use AddLUN to add more logical units.
 func New(storage Storage, vendorID, productID string) *MSC {
 	m := &MSC{
-		storage: storage,
-		maxLUN:  0, // Single LUN by default
+		maxLUN: 0, // Single LUN by default
 	}
+	m.uas.queued = make(chan struct{}, 1)
+	m.luns[0].init(storage, vendorID, productID)
+	return m
+}
+
+// init initializes the logical unit with storage and a disk INQUIRY
+// response, and clears its sense data.
+func (lu *logicalUnit) init(storage Storage, vendorID, productID string) {
+	lu.storage = storage
+	lu.serial = ""
 
 	// Initialize INQUIRY response
-	m.inquiry = *NewInquiryResponse(
+	lu.inquiry = *NewInquiryResponse(
 		DeviceTypeDisk,
 		storage.IsRemovable(),
 		vendorID,
@@ -69,9 +102,88 @@ func New(storage Storage, vendorID, productID string) *MSC {
 	)
 
 	// Clear sense data (no error)
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	lu.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+}
 
-	return m
+// AddLUN adds a logical unit with its own storage backend and INQUIRY
+// data, and returns its LUN. Each LUN also has its own sense data.
+// Returns pkg.ErrNoResources if MaxLUNs logical units already exist.
+func (m *MSC) AddLUN(storage Storage, vendorID, productID string) (uint8, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if int(m.maxLUN)+1 >= MaxLUNs {
+		return 0, pkg.ErrNoResources
+	}
+	m.maxLUN++
+	m.luns[m.maxLUN].init(storage, vendorID, productID)
+
+	pkg.LogDebug(pkg.ComponentDevice, "MSC LUN added",
+		"lun", m.maxLUN,
+		"blocks", storage.BlockCount())
+
+	return m.maxLUN, nil
+}
+
+// SetInquiry replaces the INQUIRY data of a LUN.
+// Returns pkg.ErrInvalidParameter if lun is above the maximum LUN.
+func (m *MSC) SetInquiry(lun uint8, inquiry *InquiryResponse) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if lun > m.maxLUN {
+		return pkg.ErrInvalidParameter
+	}
+	m.luns[lun].inquiry = *inquiry
+	return nil
+}
+
+// SetSerialNumber sets the unit serial number of a LUN, reported in the
+// Unit Serial Number and Device Identification VPD pages.
+// Returns pkg.ErrInvalidParameter if lun is above the maximum LUN.
+func (m *MSC) SetSerialNumber(lun uint8, serial string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if lun > m.maxLUN {
+		return pkg.ErrInvalidParameter
+	}
+	m.luns[lun].serial = serial
+	return nil
+}
+
+// SetSense sets the sense data of a LUN returned by the next REQUEST SENSE,
+// for example SenseUnitAttention with ASCNotReadyToReadyChange after
+// replacing the medium.
+// Returns pkg.ErrInvalidParameter if lun is above the maximum LUN.
+func (m *MSC) SetSense(lun uint8, key, asc, ascq uint8) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if lun > m.maxLUN {
+		return pkg.ErrInvalidParameter
+	}
+	m.luns[lun].setSense(key, asc, ascq)
+	return nil
+}
+
+// Storage returns the storage backend of a LUN, or nil if lun is above the
+// maximum LUN.
+func (m *MSC) Storage(lun uint8) Storage {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if lun > m.maxLUN {
+		return nil
+	}
+	return m.luns[lun].storage
+}
+
+// MaxLUN returns the highest Logical Unit Number.
+func (m *MSC) MaxLUN() uint8 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.maxLUN
 }
 
 // SetStack sets the device stack reference for data transfer.
@@ -81,13 +193,24 @@ func (m *MSC) SetStack(stack *device.Stack) {
 	m.stack = stack
 }
 
-// SetMaxLUN sets the maximum Logical Unit Number (0-15).
+// SetMaxLUN sets the maximum Logical Unit Number (0-15). LUNs not added
+// with AddLUN share the storage and INQUIRY data of LUN 0, but have their
+// own sense data.
 func (m *MSC) SetMaxLUN(lun uint8) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	if lun <= 15 {
-		m.maxLUN = lun
+	if lun >= MaxLUNs {
+		return
 	}
+	for i := 1; i <= int(lun); i++ {
+		if m.luns[i].storage == nil {
+			m.luns[i].storage = m.luns[0].storage
+			m.luns[i].inquiry = m.luns[0].inquiry
+			m.luns[i].serial = m.luns[0].serial
+			m.luns[i].setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+		}
+	}
+	m.maxLUN = lun
 }
 
 // Init initializes the class driver for the given interface.
@@ -112,18 +235,26 @@ func (m *MSC) Init(iface *device.Interface) error {
 		return pkg.ErrInvalidEndpoint
 	}
 
+	// Find UAS pipes if the UAS alternate setting is present
+	if alt := iface.Alternate(1); alt != nil && alt.Protocol == ProtocolUAS {
+		if err := m.findUASPipes(alt); err != nil {
+			return err
+		}
+	}
+
 	m.configured = true
 	pkg.LogDebug(pkg.ComponentDevice, "MSC configured",
 		"bulkIn", m.bulkInEP.Address,
-		"bulkOut", m.bulkOutEP.Address)
+		"bulkOut", m.bulkOutEP.Address,
+		"uas", m.commandEP != nil)
 
 	return nil
 }
 
 // HandleSetup processes class-specific SETUP requests.
-func (m *MSC) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) (bool, error) {
+func (m *MSC) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
 	if !setup.IsClass() {
-		return false, nil
+		return nil, false, nil
 	}
 
 	switch setup.Request {
@@ -131,48 +262,60 @@ func (m *MSC) HandleSetup(iface *device.Interface, setup *device.SetupPacket, da
 		return m.handleReset(setup)
 
 	case RequestGetMaxLUN:
-		return m.handleGetMaxLUN(setup, data)
+		return m.handleGetMaxLUN(setup)
 
 	default:
-		return false, nil
+		return nil, false, nil
 	}
 }
 
 // handleReset handles the Bulk-Only Mass Storage Reset request.
-func (m *MSC) handleReset(setup *device.SetupPacket) (bool, error) {
+func (m *MSC) handleReset(setup *device.SetupPacket) ([]byte, bool, error) {
 	pkg.LogDebug(pkg.ComponentDevice, "MSC reset requested")
 
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// Reset sense data
-	m.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	// Reset sense data of every LUN
+	for i := range m.luns {
+		m.luns[i].setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	}
 
 	// Clear any stalled endpoints (would be done by stack)
-	return true, nil
+	return nil, true, nil
 }
 
 // handleGetMaxLUN handles the Get Max LUN request.
-func (m *MSC) handleGetMaxLUN(setup *device.SetupPacket, data []byte) (bool, error) {
-	m.mutex.RLock()
-	maxLUN := m.maxLUN
-	m.mutex.RUnlock()
+func (m *MSC) handleGetMaxLUN(setup *device.SetupPacket) ([]byte, bool, error) {
+	m.mutex.Lock()
+	m.lunBuf[0] = m.maxLUN
+	m.mutex.Unlock()
 
 	pkg.LogDebug(pkg.ComponentDevice, "Get Max LUN",
-		"maxLUN", maxLUN)
-
-	if len(data) > 0 {
-		data[0] = maxLUN
-	}
+		"maxLUN", m.lunBuf[0])
 
-	return true, nil
+	return m.lunBuf[:], true, nil
 }
 
-// SetAlternate handles alternate setting changes.
+// SetAlternate handles alternate setting changes. Alternate setting 1
+// selects the USB Attached SCSI transport, if configured; setting 0 selects
+// the Bulk-Only Transport. Commands in progress are abandoned.
 func (m *MSC) SetAlternate(iface *device.Interface, alt uint8) error {
+	m.mutex.Lock()
+	uas := alt == 1 && m.commandEP != nil
+	changed := uas != m.uasActive
+	m.uasActive = uas
+	cancel := m.transportCancel
+	m.mutex.Unlock()
+
 	pkg.LogDebug(pkg.ComponentDevice, "MSC alternate setting",
 		"interface", iface.Number,
-		"alt", alt)
+		"alt", alt,
+		"uas", uas)
+
+	if changed && cancel != nil {
+		cancel()
+	}
 	return nil
 }
 
@@ -184,17 +327,50 @@ func (m *MSC) Close() error {
 	m.iface = nil
 	m.bulkInEP = nil
 	m.bulkOutEP = nil
+	m.commandEP = nil
+	m.statusEP = nil
+	m.dataInEP = nil
+	m.dataOutEP = nil
+	m.uasActive = false
 	m.stack = nil
 	m.configured = false
 
+	if m.transportCancel != nil {
+		m.transportCancel()
+	}
+
 	return nil
 }
 
 // setSense sets sense data for the next REQUEST SENSE command.
-func (m *MSC) setSense(key, asc, ascq uint8) {
-	m.senseKey = key
-	m.asc = asc
-	m.ascq = ascq
+// The caller must hold m.mutex once the LUN is in use.
+func (lu *logicalUnit) setSense(key, asc, ascq uint8) {
+	lu.senseKey = key
+	lu.asc = asc
+	lu.ascq = ascq
+}
+
+// setSense sets the sense data of lu while holding m.mutex, since task
+// management may reset the LUN concurrently with USB Attached SCSI.
+func (m *MSC) setSense(lu *logicalUnit, key, asc, ascq uint8) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	lu.setSense(key, asc, ascq)
+}
+
+// takeSense returns the sense data of lun and clears it, or LOGICAL UNIT
+// NOT SUPPORTED if lun is above the maximum LUN.
+func (m *MSC) takeSense(lun uint8) *RequestSenseResponse {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if lun > m.maxLUN {
+		return NewRequestSenseResponse(SenseIllegalRequest, ASCLogicalUnitNotSupported, 0)
+	}
+	lu := &m.luns[lun]
+	sense := NewRequestSenseResponse(lu.senseKey, lu.asc, lu.ascq)
+	lu.setSense(SenseNoSense, ASCNoAdditionalInfo, 0)
+	return sense
 }
 
 // ConfigureDevice adds the MSC interface to a device builder.
@@ -205,6 +381,42 @@ func (m *MSC) ConfigureDevice(builder *device.DeviceBuilder, bulkInEPAddr, bulkO
 	return builder
 }
 
+// ConfigureDeviceUAS adds an MSC interface offering both transports to a
+// device builder: the Bulk-Only Transport in alternate setting 0, using the
+// data-in and data-out endpoints, and USB Attached SCSI in alternate
+// setting 1, using all four endpoints. Hosts that support UAS select
+// alternate setting 1. Use a maxPacketSize of 512 for high speed.
+func (m *MSC) ConfigureDeviceUAS(builder *device.DeviceBuilder, dataInEPAddr, dataOutEPAddr, statusEPAddr, commandEPAddr uint8, maxPacketSize uint16) *device.DeviceBuilder {
+	dataIn := dataInEPAddr | device.EndpointDirectionIn
+	dataOut := dataOutEPAddr & 0x0F
+
+	// Alternate setting 0: Bulk-Only Transport
+	builder.AddInterface(ClassMSC, SubclassSCSI, ProtocolBulkOnly)
+	builder.AddEndpoint(dataIn, device.EndpointTypeBulk, maxPacketSize)
+	builder.AddEndpoint(dataOut, device.EndpointTypeBulk, maxPacketSize)
+
+	// Alternate setting 1: USB Attached SCSI, each pipe followed by its
+	// pipe usage descriptor
+	builder.AddAlternateInterface(ClassMSC, SubclassSCSI, ProtocolUAS)
+	pipes := [...]struct {
+		addr uint8
+		id   uint8
+	}{
+		{commandEPAddr & 0x0F, PipeIDCommand},
+		{statusEPAddr | device.EndpointDirectionIn, PipeIDStatus},
+		{dataIn, PipeIDDataIn},
+		{dataOut, PipeIDDataOut},
+	}
+	var buf [PipeUsageDescriptorSize]byte
+	for _, p := range pipes {
+		builder.AddEndpoint(p.addr, device.EndpointTypeBulk, maxPacketSize)
+		desc := PipeUsageDescriptor{PipeID: p.id}
+		n := desc.MarshalTo(buf[:])
+		builder.AddClassDescriptor(buf[:n])
+	}
+	return builder
+}
+
 // AttachToInterface attaches this class driver to the MSC interface.
 func (m *MSC) AttachToInterface(dev *device.Device, configValue, ifaceNum uint8) error {
 	config := dev.GetConfiguration(configValue)
@@ -221,21 +433,41 @@ func (m *MSC) AttachToInterface(dev *device.Device, configValue, ifaceNum uint8)
 }
 
 // Run is the main processing loop for MSC.
-// It reads CBWs, processes SCSI commands, and sends CSWs.
+// With the Bulk-Only Transport, it reads CBWs, processes SCSI commands, and
+// sends CSWs. With USB Attached SCSI, it reads Command IUs, processes queued
+// SCSI commands, and sends Sense IUs. Run follows alternate setting changes
+// between the two transports.
 // This should be called in a goroutine after the device is configured.
 func (m *MSC) Run(ctx context.Context) error {
 	for {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return ctx.Err()
-		default:
 		}
 
+		m.mutex.Lock()
+		uas := m.uasActive
+		transportCtx, cancel := context.WithCancel(ctx)
+		m.transportCancel = cancel
+		m.mutex.Unlock()
+
+		if uas {
+			m.runUAS(transportCtx)
+		} else {
+			m.runBOT(transportCtx)
+		}
+		cancel()
+	}
+}
+
+// runBOT is the processing loop of the Bulk-Only Transport.
+// It returns when ctx is cancelled.
+func (m *MSC) runBOT(ctx context.Context) {
+	for ctx.Err() == nil {
 		// Process one command
 		if err := m.processCBW(ctx); err != nil {
 			// Check if context was cancelled
 			if ctx.Err() != nil {
-				return ctx.Err()
+				return
 			}
 			// Log error and continue
 			pkg.LogWarn(pkg.ComponentDevice, "CBW processing error",
@@ -0,0 +1,490 @@
+package msc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/device/hal"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Endpoints used by the tests. The Bulk-Only Transport uses the data-in
+// and data-out endpoints.
+const (
+	testDataInEP  = 0x81
+	testDataOutEP = 0x02
+	testStatusEP  = 0x83
+	testCommandEP = 0x04
+	testBlockSize = 512
+)
+
+// ep0Result is the outcome of a control transfer seen by fakeHAL.
+type ep0Result struct {
+	data    []byte // IN data stage, nil for OUT requests
+	stalled bool
+}
+
+// fakeHAL is a device HAL that passes bulk transfers through one channel
+// per endpoint. Writes block until the test receives them.
+type fakeHAL struct {
+	setups  chan hal.SetupPacket
+	results chan ep0Result
+	pipes   map[uint8]chan []byte
+}
+
+func newFakeHAL() *fakeHAL {
+	f := &fakeHAL{
+		setups:  make(chan hal.SetupPacket, 1),
+		results: make(chan ep0Result, 1),
+		pipes:   make(map[uint8]chan []byte),
+	}
+	for _, addr := range []uint8{testDataInEP, testStatusEP} {
+		f.pipes[addr] = make(chan []byte)
+	}
+	for _, addr := range []uint8{testDataOutEP, testCommandEP} {
+		f.pipes[addr] = make(chan []byte, 4)
+	}
+	return f
+}
+
+func (f *fakeHAL) Init(ctx context.Context) error                       { return nil }
+func (f *fakeHAL) Start() error                                         { return nil }
+func (f *fakeHAL) Stop() error                                          { return nil }
+func (f *fakeHAL) SetAddress(address uint8) error                       { return nil }
+func (f *fakeHAL) ConfigureEndpoints(eps []hal.EndpointConfig) error    { return nil }
+func (f *fakeHAL) ReadEP0(ctx context.Context, buf []byte) (int, error) { return 0, nil }
+func (f *fakeHAL) Stall(address uint8) error                            { return nil }
+func (f *fakeHAL) ClearStall(address uint8) error                       { return nil }
+func (f *fakeHAL) IsConnected() bool                                    { return true }
+func (f *fakeHAL) GetSpeed() hal.Speed                                  { return hal.SpeedHigh }
+func (f *fakeHAL) WaitConnect(ctx context.Context) error                { return nil }
+func (f *fakeHAL) WaitDisconnect(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeHAL) ReadSetup(ctx context.Context, out *hal.SetupPacket) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case *out = <-f.setups:
+		return nil
+	}
+}
+
+func (f *fakeHAL) WriteEP0(ctx context.Context, data []byte) error {
+	f.results <- ep0Result{data: append([]byte{}, data...)}
+	return nil
+}
+
+func (f *fakeHAL) StallEP0() error {
+	f.results <- ep0Result{stalled: true}
+	return nil
+}
+
+func (f *fakeHAL) AckEP0() error {
+	f.results <- ep0Result{}
+	return nil
+}
+
+func (f *fakeHAL) Read(ctx context.Context, address uint8, buf []byte) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case data := <-f.pipes[address]:
+		return copy(buf, data), nil
+	}
+}
+
+func (f *fakeHAL) Write(ctx context.Context, address uint8, data []byte) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case f.pipes[address] <- append([]byte{}, data...):
+		return len(data), nil
+	}
+}
+
+// control sends a SETUP packet and waits for the control transfer to
+// complete.
+func (f *fakeHAL) control(t *testing.T, requestType, request uint8, value, index, length uint16) ep0Result {
+	t.Helper()
+	f.setups <- hal.SetupPacket{
+		RequestType: requestType,
+		Request:     request,
+		Value:       value,
+		Index:       index,
+		Length:      length,
+	}
+	select {
+	case r := <-f.results:
+		return r
+	case <-time.After(time.Second):
+		t.Fatalf("request 0x%02X 0x%02X timed out", requestType, request)
+		return ep0Result{}
+	}
+}
+
+// send queues data on an OUT endpoint.
+func (f *fakeHAL) send(t *testing.T, address uint8, data []byte) {
+	t.Helper()
+	select {
+	case f.pipes[address] <- data:
+	case <-time.After(time.Second):
+		t.Fatalf("endpoint 0x%02X not read", address)
+	}
+}
+
+// receive waits for a write to an IN endpoint.
+func (f *fakeHAL) receive(t *testing.T, address uint8) []byte {
+	t.Helper()
+	select {
+	case data := <-f.pipes[address]:
+		return data
+	case <-time.After(time.Second):
+		t.Fatalf("no data on endpoint 0x%02X", address)
+		return nil
+	}
+}
+
+// startMSC configures m on a device and runs it. With uas set, the
+// interface offers both transports and USB Attached SCSI is selected.
+func startMSC(t *testing.T, m *MSC, uas bool) *fakeHAL {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	builder := device.NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1)
+	if uas {
+		m.ConfigureDeviceUAS(builder, testDataInEP&0x0F, testDataOutEP, testStatusEP&0x0F, testCommandEP, 512)
+	} else {
+		m.ConfigureDevice(builder, testDataInEP&0x0F, testDataOutEP)
+	}
+	dev, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if err := m.AttachToInterface(dev, 1, 0); err != nil {
+		t.Fatalf("AttachToInterface() error = %v", err)
+	}
+
+	dev.Reset()
+	dev.SetAddress(1)
+
+	fake := newFakeHAL()
+	stack := device.NewStack(dev, fake)
+	m.SetStack(stack)
+	if err := stack.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { stack.Stop() })
+
+	if r := fake.control(t, device.RequestTypeStandard|device.RequestRecipientDevice,
+		device.RequestSetConfiguration, 1, 0, 0); r.stalled {
+		t.Fatal("SET_CONFIGURATION stalled")
+	}
+	if uas {
+		if r := fake.control(t, device.RequestTypeStandard|device.RequestRecipientInterface,
+			device.RequestSetInterface, 1, 0, 0); r.stalled {
+			t.Fatal("SET_INTERFACE stalled")
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return fake
+}
+
+// newTestStorage returns in-memory storage of the given number of blocks,
+// with every byte of the storage set to fill.
+func newTestStorage(blocks uint64, fill byte) *MemoryStorage {
+	s := NewMemoryStorage(blocks*testBlockSize, testBlockSize)
+	buf := bytes.Repeat([]byte{fill}, int(blocks*testBlockSize))
+	s.Write(0, uint32(blocks), buf)
+	return s
+}
+
+// botCommand is a SCSI command sent with the Bulk-Only Transport.
+type botCommand struct {
+	lun    uint8
+	cb     []byte
+	length uint32 // dCBWDataTransferLength
+	in     bool   // Data-In (device to host)
+	data   []byte // Data-Out sent after the CBW
+}
+
+// run sends the command and returns the data sent by the device and the
+// CSW.
+func (c botCommand) run(t *testing.T, fake *fakeHAL, tag uint32) ([]byte, CommandStatusWrapper) {
+	t.Helper()
+	var cbw [CBWSize]byte
+	binary.LittleEndian.PutUint32(cbw[0:4], CBWSignature)
+	binary.LittleEndian.PutUint32(cbw[4:8], tag)
+	binary.LittleEndian.PutUint32(cbw[8:12], c.length)
+	if c.in {
+		cbw[12] = CBWFlagDataIn
+	}
+	cbw[13] = c.lun
+	cbw[14] = uint8(len(c.cb))
+	copy(cbw[15:], c.cb)
+	fake.send(t, testDataOutEP, cbw[:])
+	if c.data != nil {
+		fake.send(t, testDataOutEP, c.data)
+	}
+
+	var data []byte
+	for {
+		b := fake.receive(t, testDataInEP)
+		if len(b) == CSWSize && binary.LittleEndian.Uint32(b[0:4]) == CSWSignature {
+			csw := CommandStatusWrapper{
+				Signature:   CSWSignature,
+				Tag:         binary.LittleEndian.Uint32(b[4:8]),
+				DataResidue: binary.LittleEndian.Uint32(b[8:12]),
+				Status:      b[12],
+			}
+			if csw.Tag != tag {
+				t.Fatalf("CSW tag = %d, want %d", csw.Tag, tag)
+			}
+			return data, csw
+		}
+		data = append(data, b...)
+	}
+}
+
+// read10 returns a READ (10) command block.
+func read10(lba uint32, blocks uint16) []byte {
+	cb := []byte{SCSIRead10, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(cb[2:], lba)
+	binary.BigEndian.PutUint16(cb[7:], blocks)
+	return cb
+}
+
+// write10 returns a WRITE (10) command block.
+func write10(lba uint32, blocks uint16) []byte {
+	cb := read10(lba, blocks)
+	cb[0] = SCSIWrite10
+	return cb
+}
+
+// requestSense returns a REQUEST SENSE command block.
+func requestSense() []byte {
+	return []byte{SCSIRequestSense, 0, 0, 0, 18, 0}
+}
+
+// checkSense checks the key, ASC, and ASCQ of fixed format sense data.
+func checkSense(t *testing.T, sense []byte, key, asc, ascq uint8) {
+	t.Helper()
+	if len(sense) < 14 {
+		t.Fatalf("sense data = % X, too short", sense)
+	}
+	if sense[2]&0x0F != key || sense[12] != asc || sense[13] != ascq {
+		t.Errorf("sense = %02X/%02X/%02X, want %02X/%02X/%02X",
+			sense[2]&0x0F, sense[12], sense[13], key, asc, ascq)
+	}
+}
+
+func TestMSCAddLUN(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	for want := uint8(1); want < MaxLUNs; want++ {
+		lun, err := m.AddLUN(newTestStorage(1, want), "VENDOR", "PRODUCT")
+		if err != nil || lun != want {
+			t.Fatalf("AddLUN() = %d, %v, want %d", lun, err, want)
+		}
+	}
+	if _, err := m.AddLUN(newTestStorage(1, 0), "VENDOR", "PRODUCT"); !errors.Is(err, pkg.ErrNoResources) {
+		t.Errorf("AddLUN() error = %v, want %v", err, pkg.ErrNoResources)
+	}
+	if lun := m.MaxLUN(); lun != MaxLUNs-1 {
+		t.Errorf("MaxLUN() = %d, want %d", lun, MaxLUNs-1)
+	}
+}
+
+func TestMSCSetMaxLUN(t *testing.T) {
+	lun0 := newTestStorage(4, 0)
+	lun1 := newTestStorage(2, 1)
+	m := New(lun0, "VENDOR", "PRODUCT")
+	if _, err := m.AddLUN(lun1, "OTHER", "DISK"); err != nil {
+		t.Fatalf("AddLUN() error = %v", err)
+	}
+
+	// LUNs not added share the storage of LUN 0
+	m.SetMaxLUN(3)
+	want := []Storage{lun0, lun1, lun0, lun0, nil}
+	for lun, s := range want {
+		if got := m.Storage(uint8(lun)); got != s {
+			t.Errorf("Storage(%d) = %p, want %p", lun, got, s)
+		}
+	}
+
+	// Out of range values are ignored
+	m.SetMaxLUN(MaxLUNs)
+	if lun := m.MaxLUN(); lun != 3 {
+		t.Errorf("MaxLUN() = %d, want 3", lun)
+	}
+	if err := m.SetSense(4, SenseIllegalRequest, ASCInvalidCommand, 0); !errors.Is(err, pkg.ErrInvalidParameter) {
+		t.Errorf("SetSense(4) error = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+}
+
+func TestMSCTakeSense(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	m.SetMaxLUN(1)
+	if err := m.SetSense(1, SenseUnitAttention, ASCNotReadyToReadyChange, 0); err != nil {
+		t.Fatalf("SetSense() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		lun      uint8
+		key, asc uint8
+	}{
+		{"other LUN unaffected", 0, SenseNoSense, ASCNoAdditionalInfo},
+		{"LUN sense", 1, SenseUnitAttention, ASCNotReadyToReadyChange},
+		{"LUN sense cleared", 1, SenseNoSense, ASCNoAdditionalInfo},
+		{"LUN not supported", 2, SenseIllegalRequest, ASCLogicalUnitNotSupported},
+		{"LUN not supported again", 2, SenseIllegalRequest, ASCLogicalUnitNotSupported},
+	}
+	for _, tt := range tests {
+		sense := m.takeSense(tt.lun)
+		if sense.SenseKey != tt.key || sense.ASC != tt.asc || sense.ASCQ != 0 {
+			t.Errorf("%s: takeSense(%d) = %02X/%02X/%02X, want %02X/%02X/00",
+				tt.name, tt.lun, sense.SenseKey, sense.ASC, sense.ASCQ, tt.key, tt.asc)
+		}
+	}
+}
+
+func TestMSCLUNRouting(t *testing.T) {
+	m := New(newTestStorage(4, 0xA0), "VENDOR", "LUN0")
+	lun, err := m.AddLUN(newTestStorage(2, 0xB1), "VENDOR", "LUN1")
+	if err != nil {
+		t.Fatalf("AddLUN() error = %v", err)
+	}
+	fake := startMSC(t, m, false)
+
+	r := fake.control(t, device.RequestDirectionDeviceToHost|device.RequestTypeClass|device.RequestRecipientInterface,
+		RequestGetMaxLUN, 0, 0, 1)
+	if r.stalled || !bytes.Equal(r.data, []byte{lun}) {
+		t.Fatalf("GET_MAX_LUN = % X, stalled %v, want %02X", r.data, r.stalled, lun)
+	}
+
+	// Each LUN reads its own storage
+	for _, tt := range []struct {
+		lun  uint8
+		fill byte
+	}{{0, 0xA0}, {1, 0xB1}} {
+		data, csw := botCommand{lun: tt.lun, cb: read10(1, 1), length: testBlockSize, in: true}.run(t, fake, 1)
+		if csw.Status != CSWStatusGood || csw.DataResidue != 0 {
+			t.Fatalf("READ(10) LUN %d CSW = %+v", tt.lun, csw)
+		}
+		if !bytes.Equal(data, bytes.Repeat([]byte{tt.fill}, testBlockSize)) {
+			t.Errorf("READ(10) LUN %d data = % X..., want %02X", tt.lun, data[:4], tt.fill)
+		}
+	}
+
+	// A write to LUN 1 reaches only its storage
+	block := bytes.Repeat([]byte{0x5A}, testBlockSize)
+	if _, csw := (botCommand{lun: 1, cb: write10(0, 1), length: testBlockSize, data: block}).run(t, fake, 2); csw.Status != CSWStatusGood {
+		t.Fatalf("WRITE(10) LUN 1 CSW = %+v", csw)
+	}
+	buf := make([]byte, testBlockSize)
+	for lun, want := range []byte{0xA0, 0x5A} {
+		m.Storage(uint8(lun)).Read(0, 1, buf)
+		if buf[0] != want || buf[testBlockSize-1] != want {
+			t.Errorf("LUN %d block 0 = %02X, want %02X", lun, buf[0], want)
+		}
+	}
+
+	// Each LUN has its own capacity
+	if _, csw := (botCommand{lun: 1, cb: read10(2, 1), length: testBlockSize, in: true}).run(t, fake, 3); csw.Status != CSWStatusFailed {
+		t.Errorf("READ(10) past LUN 1 CSW = %+v, want failed", csw)
+	}
+	if data, csw := (botCommand{lun: 0, cb: read10(2, 1), length: testBlockSize, in: true}).run(t, fake, 4); csw.Status != CSWStatusGood || len(data) != testBlockSize {
+		t.Errorf("READ(10) LUN 0 CSW = %+v, %d bytes", csw, len(data))
+	}
+
+	// INQUIRY returns the data of each LUN
+	for lun, want := range []string{"LUN0", "LUN1"} {
+		data, csw := botCommand{lun: uint8(lun), cb: []byte{SCSIInquiry, 0, 0, 0, 36, 0}, length: 36, in: true}.run(t, fake, 5)
+		if csw.Status != CSWStatusGood || len(data) < 32 {
+			t.Fatalf("INQUIRY LUN %d = % X, CSW %+v", lun, data, csw)
+		}
+		if product := string(bytes.TrimRight(data[16:32], " ")); product != want {
+			t.Errorf("INQUIRY LUN %d product = %q, want %q", lun, product, want)
+		}
+	}
+}
+
+func TestMSCUnsupportedLUN(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	fake := startMSC(t, m, false)
+
+	if _, csw := (botCommand{lun: 2, cb: []byte{SCSITestUnitReady, 0, 0, 0, 0, 0}}).run(t, fake, 1); csw.Status != CSWStatusFailed {
+		t.Errorf("TEST UNIT READY CSW = %+v, want failed", csw)
+	}
+	if _, csw := (botCommand{lun: 2, cb: read10(0, 1), length: testBlockSize, in: true}).run(t, fake, 2); csw.Status != CSWStatusFailed || csw.DataResidue != testBlockSize {
+		t.Errorf("READ(10) CSW = %+v, want failed with residue %d", csw, testBlockSize)
+	}
+
+	// REQUEST SENSE succeeds with LOGICAL UNIT NOT SUPPORTED, every time
+	for tag := uint32(3); tag < 5; tag++ {
+		sense, csw := botCommand{lun: 2, cb: requestSense(), length: 18, in: true}.run(t, fake, tag)
+		if csw.Status != CSWStatusGood {
+			t.Fatalf("REQUEST SENSE CSW = %+v", csw)
+		}
+		checkSense(t, sense, SenseIllegalRequest, ASCLogicalUnitNotSupported, 0)
+	}
+
+	data, csw := botCommand{lun: 2, cb: []byte{SCSIInquiry, 0, 0, 0, 36, 0}, length: 36, in: true}.run(t, fake, 5)
+	if csw.Status != CSWStatusGood || len(data) == 0 || data[0] != InquiryNoLogicalUnit {
+		t.Errorf("INQUIRY = % X, CSW %+v, want peripheral %02X", data, csw, InquiryNoLogicalUnit)
+	}
+
+	// LUN 0 is unaffected
+	sense, _ := botCommand{lun: 0, cb: requestSense(), length: 18, in: true}.run(t, fake, 6)
+	checkSense(t, sense, SenseNoSense, ASCNoAdditionalInfo, 0)
+}
+
+func TestMSCSenseIsolation(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	m.SetMaxLUN(2)
+	fake := startMSC(t, m, false)
+
+	// A failed command on LUN 1 sets only its sense data
+	if _, csw := (botCommand{lun: 1, cb: read10(4, 1), length: testBlockSize, in: true}).run(t, fake, 1); csw.Status != CSWStatusFailed {
+		t.Fatalf("READ(10) CSW = %+v, want failed", csw)
+	}
+	if _, csw := (botCommand{lun: 2, cb: []byte{0xFF, 0, 0, 0, 0, 0}}).run(t, fake, 2); csw.Status != CSWStatusFailed {
+		t.Fatalf("unsupported command CSW = %+v, want failed", csw)
+	}
+
+	tests := []struct {
+		lun      uint8
+		key, asc uint8
+	}{
+		{0, SenseNoSense, ASCNoAdditionalInfo},
+		{1, SenseIllegalRequest, ASCLBAOutOfRange},
+		{2, SenseIllegalRequest, ASCInvalidCommand},
+		{1, SenseNoSense, ASCNoAdditionalInfo}, // Cleared by REQUEST SENSE
+	}
+	for i, tt := range tests {
+		sense, csw := botCommand{lun: tt.lun, cb: requestSense(), length: 18, in: true}.run(t, fake, uint32(3+i))
+		if csw.Status != CSWStatusGood {
+			t.Fatalf("REQUEST SENSE LUN %d CSW = %+v", tt.lun, csw)
+		}
+		checkSense(t, sense, tt.key, tt.asc, 0)
+	}
+}
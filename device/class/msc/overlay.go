@@ -0,0 +1,306 @@
+package msc
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// OverlayStorage implements Storage interface as a copy-on-write overlay
+// over a base Storage. Writes and discards are kept in memory and never
+// reach the base, so several overlays can share one golden image without
+// mutating it. Commit writes the changes to the base, and Revert drops
+// them.
+//
+// The base must not be written by others while the overlay has changes.
+type OverlayStorage struct {
+	base      Storage
+	blocks    map[uint64][]byte // Written blocks
+	discarded []extent          // Discarded block ranges, sorted and disjoint
+	readOnly  bool
+	mutex     sync.RWMutex
+}
+
+// extent is a range of blocks [start, end).
+type extent struct {
+	start uint64
+	end   uint64
+}
+
+// NewOverlayStorage creates a copy-on-write overlay over base. The base may
+// be read-only.
+func NewOverlayStorage(base Storage) *OverlayStorage {
+	return &OverlayStorage{
+		base:   base,
+		blocks: make(map[uint64][]byte),
+	}
+}
+
+// Base returns the base storage.
+func (o *OverlayStorage) Base() Storage {
+	return o.base
+}
+
+// BlockSize returns the block size of the base.
+func (o *OverlayStorage) BlockSize() uint32 {
+	return o.base.BlockSize()
+}
+
+// BlockCount returns the number of blocks of the base.
+func (o *OverlayStorage) BlockCount() uint64 {
+	return o.base.BlockCount()
+}
+
+// ChangedBlocks returns the number of blocks written to the overlay and
+// not yet committed or reverted. Discarded blocks are not counted.
+func (o *OverlayStorage) ChangedBlocks() uint64 {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return uint64(len(o.blocks))
+}
+
+// Read reads blocks from the overlay, or from the base where the overlay
+// has no changes.
+func (o *OverlayStorage) Read(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	blockSize := o.base.BlockSize()
+	if lba+uint64(blocks) > o.base.BlockCount() {
+		return 0, io.EOF
+	}
+
+	if uint64(len(buf)) < uint64(blocks)*uint64(blockSize) {
+		return 0, io.ErrShortBuffer
+	}
+
+	// Read runs of unchanged blocks from the base in one call
+	var run uint32
+	for i := uint32(0); i <= blocks; i++ {
+		n := lba + uint64(i)
+		block, written := o.blocks[n]
+		discarded := !written && o.isDiscardedLocked(n)
+		if i < blocks && !written && !discarded {
+			run++
+			continue
+		}
+
+		if run > 0 {
+			start := i - run
+			if _, err := o.base.Read(lba+uint64(start), run,
+				buf[start*blockSize:i*blockSize]); err != nil {
+				return start, err
+			}
+			run = 0
+		}
+
+		if i == blocks {
+			break
+		}
+		dst := buf[i*blockSize : (i+1)*blockSize]
+		if written {
+			copy(dst, block)
+		} else {
+			clear(dst)
+		}
+	}
+	return blocks, nil
+}
+
+// Write writes blocks to the overlay.
+func (o *OverlayStorage) Write(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.readOnly {
+		return 0, os.ErrPermission
+	}
+
+	blockSize := o.base.BlockSize()
+	if lba+uint64(blocks) > o.base.BlockCount() {
+		return 0, io.EOF
+	}
+
+	if uint64(len(buf)) < uint64(blocks)*uint64(blockSize) {
+		return 0, io.ErrShortBuffer
+	}
+
+	for i := uint32(0); i < blocks; i++ {
+		n := lba + uint64(i)
+		block, ok := o.blocks[n]
+		if !ok {
+			block = make([]byte, blockSize)
+			o.blocks[n] = block
+		}
+		copy(block, buf[i*blockSize:(i+1)*blockSize])
+	}
+	return blocks, nil
+}
+
+// Discard discards blocks in the overlay, which then read as zeros.
+func (o *OverlayStorage) Discard(lba uint64, blocks uint64) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.readOnly {
+		return os.ErrPermission
+	}
+
+	blockCount := o.base.BlockCount()
+	if lba > blockCount || blocks > blockCount-lba {
+		return io.EOF
+	}
+	if blocks == 0 {
+		return nil
+	}
+
+	// Walk whichever is smaller: the discarded range or the written blocks
+	if blocks < uint64(len(o.blocks)) {
+		for n := lba; n < lba+blocks; n++ {
+			delete(o.blocks, n)
+		}
+	} else {
+		for n := range o.blocks {
+			if n >= lba && n < lba+blocks {
+				delete(o.blocks, n)
+			}
+		}
+	}
+
+	o.addDiscardedLocked(extent{start: lba, end: lba + blocks})
+	return nil
+}
+
+// isDiscardedLocked reports whether block n lies in a discarded range.
+// The caller must hold o.mutex.
+func (o *OverlayStorage) isDiscardedLocked(n uint64) bool {
+	i := sort.Search(len(o.discarded), func(i int) bool {
+		return o.discarded[i].end > n
+	})
+	return i < len(o.discarded) && o.discarded[i].start <= n
+}
+
+// addDiscardedLocked adds a discarded range, merging it with overlapping
+// and adjacent ranges. The caller must hold o.mutex.
+func (o *OverlayStorage) addDiscardedLocked(e extent) {
+	// First range that ends at or after e.start
+	i := sort.Search(len(o.discarded), func(i int) bool {
+		return o.discarded[i].end >= e.start
+	})
+
+	// Merge ranges that start at or before e.end
+	j := i
+	for j < len(o.discarded) && o.discarded[j].start <= e.end {
+		e.start = min(e.start, o.discarded[j].start)
+		e.end = max(e.end, o.discarded[j].end)
+		j++
+	}
+
+	if i == j {
+		o.discarded = append(o.discarded, extent{})
+		copy(o.discarded[i+1:], o.discarded[i:])
+	} else {
+		o.discarded = append(o.discarded[:i+1], o.discarded[j:]...)
+	}
+	o.discarded[i] = e
+}
+
+// Commit writes the changes in the overlay to the base and clears the
+// overlay. Discarded blocks are discarded in the base if it implements
+// Discarder, or written with zeros otherwise.
+// Returns os.ErrPermission if the base is read-only. If writing fails,
+// the overlay keeps its changes.
+func (o *OverlayStorage) Commit() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.base.IsReadOnly() {
+		return os.ErrPermission
+	}
+
+	blockSize := o.base.BlockSize()
+	if len(o.discarded) > 0 {
+		discarder, ok := o.base.(Discarder)
+		var zeros []byte
+		if !ok {
+			zeros = make([]byte, blockSize)
+		}
+		for _, e := range o.discarded {
+			if ok {
+				if err := discarder.Discard(e.start, e.end-e.start); err != nil {
+					return err
+				}
+				continue
+			}
+			for n := e.start; n < e.end; n++ {
+				if _, err := o.base.Write(n, 1, zeros); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Written blocks take precedence over discarded ranges
+	for n, block := range o.blocks {
+		if _, err := o.base.Write(n, 1, block); err != nil {
+			return err
+		}
+	}
+
+	if err := o.base.Sync(); err != nil {
+		return err
+	}
+
+	o.revertLocked()
+	return nil
+}
+
+// Revert drops the changes in the overlay, so it reads as the base again.
+func (o *OverlayStorage) Revert() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.revertLocked()
+}
+
+// revertLocked drops the changes in the overlay. The caller must hold
+// o.mutex.
+func (o *OverlayStorage) revertLocked() {
+	clear(o.blocks)
+	o.discarded = o.discarded[:0]
+}
+
+// Sync is a no-op, as changes stay in memory until Commit.
+func (o *OverlayStorage) Sync() error {
+	return nil
+}
+
+// IsReadOnly returns whether the overlay is read-only. The overlay is
+// writable even if the base is read-only.
+func (o *OverlayStorage) IsReadOnly() bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return o.readOnly
+}
+
+// SetReadOnly sets the read-only flag.
+func (o *OverlayStorage) SetReadOnly(readOnly bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.readOnly = readOnly
+}
+
+// IsRemovable returns whether the media of the base is removable.
+func (o *OverlayStorage) IsRemovable() bool {
+	return o.base.IsRemovable()
+}
+
+// IsPresent returns whether the media of the base is present.
+func (o *OverlayStorage) IsPresent() bool {
+	return o.base.IsPresent()
+}
+
+// Eject ejects the media of the base.
+func (o *OverlayStorage) Eject() error {
+	return o.base.Eject()
+}
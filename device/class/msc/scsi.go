@@ -77,8 +77,9 @@ func (r *ReadCapacity10Response) MarshalTo(buf []byte) int {
 
 // ReadCapacity16Response represents READ CAPACITY (16) response.
 type ReadCapacity16Response struct {
-	LastLBA     uint64 // Last logical block address
-	BlockLength uint32 // Block length in bytes
+	LastLBA      uint64 // Last logical block address
+	BlockLength  uint32 // Block length in bytes
+	Provisioning uint8  // Logical block provisioning flags (ReadCapacityLBP*)
 }
 
 // MarshalTo writes the response to buf.
@@ -90,7 +91,8 @@ func (r *ReadCapacity16Response) MarshalTo(buf []byte) int {
 
 	binary.BigEndian.PutUint64(buf[0:8], r.LastLBA)
 	binary.BigEndian.PutUint32(buf[8:12], r.BlockLength)
-	// Remaining bytes are reserved/zero
+	clear(buf[12:32])
+	buf[14] = r.Provisioning
 
 	return 32
 }
@@ -141,10 +143,10 @@ func NewRequestSenseResponse(key, asc, ascq uint8) *RequestSenseResponse {
 
 // ModeSense6Response represents MODE SENSE (6) response header.
 type ModeSense6Response struct {
-	ModeDataLength uint8  // Mode data length (excluding this field)
-	MediumType     uint8  // Medium type
-	DeviceParam    uint8  // Device-specific parameter
-	BlockDescLen   uint8  // Block descriptor length
+	ModeDataLength uint8 // Mode data length (excluding this field)
+	MediumType     uint8 // Medium type
+	DeviceParam    uint8 // Device-specific parameter
+	BlockDescLen   uint8 // Block descriptor length
 }
 
 // MarshalTo writes the response header to buf.
@@ -162,6 +164,48 @@ func (r *ModeSense6Response) MarshalTo(buf []byte) int {
 	return 4
 }
 
+// ModeSense10Response represents MODE SENSE (10) response header.
+type ModeSense10Response struct {
+	ModeDataLength uint16 // Mode data length (excluding this field)
+	MediumType     uint8  // Medium type
+	DeviceParam    uint8  // Device-specific parameter
+	BlockDescLen   uint16 // Block descriptor length
+}
+
+// MarshalTo writes the response header to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (r *ModeSense10Response) MarshalTo(buf []byte) int {
+	if len(buf) < 8 {
+		return 0
+	}
+
+	binary.BigEndian.PutUint16(buf[0:2], r.ModeDataLength)
+	buf[2] = r.MediumType
+	buf[3] = r.DeviceParam
+	buf[4] = 0 // LONGLBA = 0
+	buf[5] = 0 // Reserved
+	binary.BigEndian.PutUint16(buf[6:8], r.BlockDescLen)
+
+	return 8
+}
+
+// UnmapDescriptor represents an UNMAP block descriptor.
+type UnmapDescriptor struct {
+	LBA    uint64 // First logical block to unmap
+	Blocks uint32 // Number of logical blocks to unmap
+}
+
+// ParseUnmapDescriptor parses an UNMAP block descriptor.
+// Returns false if data is too short.
+func ParseUnmapDescriptor(data []byte, out *UnmapDescriptor) bool {
+	if len(data) < UnmapDescriptorSize {
+		return false
+	}
+	out.LBA = binary.BigEndian.Uint64(data[0:8])
+	out.Blocks = binary.BigEndian.Uint32(data[8:12])
+	return true
+}
+
 // ReadFormatCapacitiesHeader represents READ FORMAT CAPACITIES response header.
 type ReadFormatCapacitiesHeader struct {
 	Reserved       [3]uint8 // Reserved
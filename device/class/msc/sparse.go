@@ -0,0 +1,211 @@
+package msc
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// SparseStorage implements Storage interface using in-memory blocks that
+// are allocated on first write. Blocks that were never written, or were
+// discarded, read as zeros, so large disks only use memory for the data
+// written to them.
+type SparseStorage struct {
+	blocks     map[uint64][]byte
+	blockSize  uint32
+	blockCount uint64
+	readOnly   bool
+	removable  bool
+	present    bool
+	mutex      sync.RWMutex
+}
+
+// NewSparseStorage creates a sparse in-memory storage with the given size
+// and block size. A block size of 0 gives a storage with no blocks.
+func NewSparseStorage(size uint64, blockSize uint32) *SparseStorage {
+	s := &SparseStorage{
+		blocks:    make(map[uint64][]byte),
+		blockSize: blockSize,
+		present:   true,
+	}
+	if blockSize > 0 {
+		s.blockCount = size / uint64(blockSize)
+	}
+	return s
+}
+
+// BlockSize returns the block size.
+func (s *SparseStorage) BlockSize() uint32 {
+	return s.blockSize
+}
+
+// BlockCount returns the number of blocks.
+func (s *SparseStorage) BlockCount() uint64 {
+	return s.blockCount
+}
+
+// AllocatedBlocks returns the number of blocks holding data.
+func (s *SparseStorage) AllocatedBlocks() uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return uint64(len(s.blocks))
+}
+
+// Read reads blocks from memory.
+func (s *SparseStorage) Read(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.present {
+		return 0, io.EOF
+	}
+
+	if lba+uint64(blocks) > s.blockCount {
+		return 0, io.EOF
+	}
+
+	if uint64(len(buf)) < uint64(blocks)*uint64(s.blockSize) {
+		return 0, io.ErrShortBuffer
+	}
+
+	for i := uint32(0); i < blocks; i++ {
+		dst := buf[i*s.blockSize : (i+1)*s.blockSize]
+		if block, ok := s.blocks[lba+uint64(i)]; ok {
+			copy(dst, block)
+		} else {
+			clear(dst)
+		}
+	}
+	return blocks, nil
+}
+
+// Write writes blocks to memory, allocating blocks as needed. Blocks
+// written with all zeros are released.
+func (s *SparseStorage) Write(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.present {
+		return 0, io.EOF
+	}
+
+	if s.readOnly {
+		return 0, os.ErrPermission
+	}
+
+	if lba+uint64(blocks) > s.blockCount {
+		return 0, io.EOF
+	}
+
+	if uint64(len(buf)) < uint64(blocks)*uint64(s.blockSize) {
+		return 0, io.ErrShortBuffer
+	}
+
+	for i := uint32(0); i < blocks; i++ {
+		src := buf[i*s.blockSize : (i+1)*s.blockSize]
+		n := lba + uint64(i)
+		if isZero(src) {
+			delete(s.blocks, n)
+			continue
+		}
+		block, ok := s.blocks[n]
+		if !ok {
+			block = make([]byte, s.blockSize)
+			s.blocks[n] = block
+		}
+		copy(block, src)
+	}
+	return blocks, nil
+}
+
+// Discard releases blocks, which then read as zeros.
+func (s *SparseStorage) Discard(lba uint64, blocks uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.present {
+		return io.EOF
+	}
+
+	if s.readOnly {
+		return os.ErrPermission
+	}
+
+	if lba > s.blockCount || blocks > s.blockCount-lba {
+		return io.EOF
+	}
+
+	// Walk whichever is smaller: the discarded range or the allocated blocks
+	if blocks < uint64(len(s.blocks)) {
+		for n := lba; n < lba+blocks; n++ {
+			delete(s.blocks, n)
+		}
+	} else {
+		for n := range s.blocks {
+			if n >= lba && n < lba+blocks {
+				delete(s.blocks, n)
+			}
+		}
+	}
+	return nil
+}
+
+// Sync is a no-op for memory storage.
+func (s *SparseStorage) Sync() error {
+	return nil
+}
+
+// IsReadOnly returns whether the storage is read-only.
+func (s *SparseStorage) IsReadOnly() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.readOnly
+}
+
+// SetReadOnly sets the read-only flag.
+func (s *SparseStorage) SetReadOnly(readOnly bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readOnly = readOnly
+}
+
+// IsRemovable returns whether the media is removable.
+func (s *SparseStorage) IsRemovable() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.removable
+}
+
+// SetRemovable sets the removable flag.
+func (s *SparseStorage) SetRemovable(removable bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.removable = removable
+}
+
+// IsPresent returns whether media is present.
+func (s *SparseStorage) IsPresent() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.present
+}
+
+// SetPresent sets the media presence flag.
+func (s *SparseStorage) SetPresent(present bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.present = present
+}
+
+// Eject ejects the media (sets present to false).
+func (s *SparseStorage) Eject() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.removable {
+		return os.ErrPermission
+	}
+
+	s.present = false
+	return nil
+}
@@ -40,6 +40,16 @@ type Storage interface {
 	Eject() error
 }
 
+// Discarder is an optional interface for Storage backends that can discard
+// (unmap) blocks, such as thin-provisioned images. If the Storage of a LUN
+// implements Discarder, the LUN supports UNMAP and WRITE SAME with the
+// UNMAP bit, and reports logical block provisioning to the host.
+type Discarder interface {
+	// Discard discards blocks starting at lba. Discarded blocks must
+	// read as zeros.
+	Discard(lba uint64, blocks uint64) error
+}
+
 // MemoryStorage implements Storage interface using an in-memory buffer.
 type MemoryStorage struct {
 	data      []byte
@@ -123,6 +133,30 @@ func (m *MemoryStorage) Write(lba uint64, blocks uint32, buf []byte) (uint32, er
 	return blocks, nil
 }
 
+// Discard zeros blocks in memory.
+func (m *MemoryStorage) Discard(lba uint64, blocks uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.present {
+		return io.EOF
+	}
+
+	if m.readOnly {
+		return os.ErrPermission
+	}
+
+	offset := lba * uint64(m.blockSize)
+	length := blocks * uint64(m.blockSize)
+
+	if offset+length > uint64(len(m.data)) {
+		return io.EOF
+	}
+
+	clear(m.data[offset : offset+length])
+	return nil
+}
+
 // Sync is a no-op for memory storage.
 func (m *MemoryStorage) Sync() error {
 	return nil
@@ -0,0 +1,459 @@
+package msc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// uasTransport is the state of the USB Attached SCSI transport.
+//
+// A reader goroutine receives IUs from the command pipe, queues commands
+// by tag, and answers task management functions. The Run goroutine
+// executes queued commands one at a time, in queue order, and completes
+// each with a Sense IU on the status pipe. Without bulk streams, every
+// data phase is preceded by a Read Ready or Write Ready IU.
+type uasTransport struct {
+	// Command queue (head at index 0), protected by mutex
+	mutex sync.Mutex
+	queue [UASQueueDepth]CommandIU
+	count int
+
+	// Executing command, protected by mutex
+	current CommandIU
+	active  bool
+	aborted bool
+	cancel  context.CancelFunc
+
+	// Signals that a command was queued
+	queued chan struct{}
+
+	// Set once the data phase of the executing command has started
+	// (executor only)
+	dataReady bool
+
+	// Buffers (zero-allocation pattern)
+	iuBuf [CommandIUSize]byte // Command pipe (reader only)
+
+	statusMutex sync.Mutex           // Serializes status pipe writes
+	statusBuf   [SenseIUMaxSize]byte // Status pipe, protected by statusMutex
+}
+
+// hasTagLocked reports whether a command with the given tag is queued or
+// executing. The caller must hold u.mutex.
+func (u *uasTransport) hasTagLocked(tag uint16) bool {
+	if u.active && u.current.Tag == tag {
+		return true
+	}
+	for i := 0; i < u.count; i++ {
+		if u.queue[i].Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTaskLocked reports whether a command for lun is queued or executing,
+// and if all is false, also has the given tag. The caller must hold u.mutex.
+func (u *uasTransport) hasTaskLocked(lun uint8, tag uint16, all bool) bool {
+	if u.active && lunNumber(u.current.LUN) == lun && (all || u.current.Tag == tag) {
+		return true
+	}
+	for i := 0; i < u.count; i++ {
+		if lunNumber(u.queue[i].LUN) == lun && (all || u.queue[i].Tag == tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// abortLocked aborts the queued and executing commands for lun (or for
+// every LUN if lun is 0xFF), and if all is false, only the one with the
+// given tag. Aborted commands complete without a Sense IU. The caller must
+// hold u.mutex.
+func (u *uasTransport) abortLocked(lun uint8, tag uint16, all bool) {
+	match := func(iu *CommandIU) bool {
+		return (lun == 0xFF || lunNumber(iu.LUN) == lun) && (all || iu.Tag == tag)
+	}
+
+	if u.active && !u.aborted && match(&u.current) {
+		u.aborted = true
+		u.cancel()
+	}
+
+	n := 0
+	for i := 0; i < u.count; i++ {
+		if !match(&u.queue[i]) {
+			u.queue[n] = u.queue[i]
+			n++
+		}
+	}
+	u.count = n
+}
+
+// reset discards all queued commands.
+func (u *uasTransport) reset() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.count = 0
+	u.active = false
+}
+
+// runUAS is the processing loop of the USB Attached SCSI transport.
+// It returns when ctx is cancelled.
+func (m *MSC) runUAS(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.uas.reset()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.readIUs(ctx)
+	}()
+
+	m.executeCommands(ctx)
+	cancel()
+	wg.Wait()
+}
+
+// readIUs receives IUs from the command pipe until ctx is cancelled.
+func (m *MSC) readIUs(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := m.processIU(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			pkg.LogWarn(pkg.ComponentDevice, "IU processing error",
+				"error", err)
+		}
+	}
+}
+
+// processIU reads and processes one IU from the command pipe.
+func (m *MSC) processIU(ctx context.Context) error {
+	m.mutex.RLock()
+	stack := m.stack
+	ep := m.commandEP
+	m.mutex.RUnlock()
+
+	if stack == nil || ep == nil {
+		return pkg.ErrNotConfigured
+	}
+
+	u := &m.uas
+	n, err := stack.Read(ctx, ep, u.iuBuf[:])
+	if err != nil {
+		return err
+	}
+	data := u.iuBuf[:n]
+
+	var cmd CommandIU
+	var tm TaskMgmtIU
+	switch {
+	case ParseCommandIU(data, &cmd):
+		return m.queueCommand(ctx, &cmd)
+
+	case ParseTaskMgmtIU(data, &tm):
+		return m.handleTaskMgmt(ctx, &tm)
+
+	default:
+		pkg.LogWarn(pkg.ComponentDevice, "invalid IU",
+			"length", n)
+		var tag uint16
+		if n >= ReadyIUSize {
+			tag = uint16(data[2])<<8 | uint16(data[3])
+		}
+		return m.sendResponseIU(ctx, tag, ResponseInvalidIU)
+	}
+}
+
+// queueCommand adds a command to the queue. Head of queue commands are
+// queued before all others.
+func (m *MSC) queueCommand(ctx context.Context, cmd *CommandIU) error {
+	pkg.LogDebug(pkg.ComponentDevice, "Command IU received",
+		"tag", cmd.Tag,
+		"lun", lunNumber(cmd.LUN),
+		"attr", cmd.TaskAttribute,
+		"opcode", cmd.CDB[0])
+
+	u := &m.uas
+	u.mutex.Lock()
+	if u.hasTagLocked(cmd.Tag) {
+		u.mutex.Unlock()
+		pkg.LogWarn(pkg.ComponentDevice, "overlapped tag",
+			"tag", cmd.Tag)
+		return m.sendResponseIU(ctx, cmd.Tag, ResponseOverlappedTag)
+	}
+	if u.count == UASQueueDepth {
+		u.mutex.Unlock()
+		return m.sendSenseIU(ctx, &SenseIU{Tag: cmd.Tag, Status: StatusTaskSetFull})
+	}
+
+	if cmd.TaskAttribute == TaskAttrHead {
+		copy(u.queue[1:u.count+1], u.queue[:u.count])
+		u.queue[0] = *cmd
+	} else {
+		u.queue[u.count] = *cmd
+	}
+	u.count++
+	u.mutex.Unlock()
+
+	select {
+	case u.queued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// handleTaskMgmt processes a task management function and sends its
+// Response IU.
+func (m *MSC) handleTaskMgmt(ctx context.Context, tm *TaskMgmtIU) error {
+	lun := lunNumber(tm.LUN)
+
+	pkg.LogDebug(pkg.ComponentDevice, "Task Management IU received",
+		"tag", tm.Tag,
+		"function", tm.Function,
+		"managedTag", tm.ManagedTag,
+		"lun", lun)
+
+	m.mutex.RLock()
+	maxLUN := m.maxLUN
+	m.mutex.RUnlock()
+
+	if tm.Function != TaskMgmtITNexusReset && lun > maxLUN {
+		return m.sendResponseIU(ctx, tm.Tag, ResponseIncorrectLUN)
+	}
+
+	u := &m.uas
+	code := uint8(ResponseComplete)
+	u.mutex.Lock()
+	switch tm.Function {
+	case TaskMgmtAbortTask:
+		u.abortLocked(lun, tm.ManagedTag, false)
+
+	case TaskMgmtAbortTaskSet, TaskMgmtClearTaskSet:
+		u.abortLocked(lun, 0, true)
+
+	case TaskMgmtLUReset:
+		u.abortLocked(lun, 0, true)
+		m.setSense(&m.luns[lun], SenseNoSense, ASCNoAdditionalInfo, 0)
+
+	case TaskMgmtITNexusReset:
+		u.abortLocked(0xFF, 0, true)
+
+	case TaskMgmtQueryTask:
+		if u.hasTaskLocked(lun, tm.ManagedTag, false) {
+			code = ResponseSucceeded
+		}
+
+	case TaskMgmtQueryTaskSet:
+		if u.hasTaskLocked(lun, 0, true) {
+			code = ResponseSucceeded
+		}
+
+	case TaskMgmtQueryAsyncEvent:
+		// No asynchronous events are reported
+
+	default:
+		code = ResponseNotSupported
+	}
+	u.mutex.Unlock()
+
+	return m.sendResponseIU(ctx, tm.Tag, code)
+}
+
+// executeCommands executes queued commands until ctx is cancelled.
+func (m *MSC) executeCommands(ctx context.Context) {
+	for {
+		cmdCtx, tag, ok := m.nextCommand(ctx)
+		if !ok {
+			return
+		}
+
+		if err := m.executeCommand(ctx, cmdCtx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			pkg.LogWarn(pkg.ComponentDevice, "command processing error",
+				"tag", tag,
+				"error", err)
+		}
+	}
+}
+
+// nextCommand waits for a queued command and makes it the executing
+// command. The returned context is cancelled if the command is aborted.
+// Returns the context and tag of the command, or false if ctx is cancelled.
+func (m *MSC) nextCommand(ctx context.Context) (context.Context, uint16, bool) {
+	u := &m.uas
+	for {
+		u.mutex.Lock()
+		if u.count > 0 {
+			u.current = u.queue[0]
+			copy(u.queue[:u.count-1], u.queue[1:u.count])
+			u.count--
+			u.active = true
+			u.aborted = false
+			cmdCtx, cancel := context.WithCancel(ctx)
+			u.cancel = cancel
+			tag := u.current.Tag
+			u.mutex.Unlock()
+			return cmdCtx, tag, true
+		}
+		u.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, false
+		case <-u.queued:
+		}
+	}
+}
+
+// executeCommand executes the current command with cmdCtx and, unless it
+// was aborted, completes it with a Sense IU.
+func (m *MSC) executeCommand(ctx, cmdCtx context.Context) error {
+	u := &m.uas
+	cmd := &u.current
+	lun := lunNumber(cmd.LUN)
+
+	// UAS has no data transfer length, so the residue is not reported
+	m.currentCBW = CommandBlockWrapper{
+		Tag:      uint32(cmd.Tag),
+		LUN:      lun,
+		CBLength: uint8(len(cmd.CDB)),
+		CB:       cmd.CDB,
+	}
+	u.dataReady = false
+
+	status, _ := m.handleSCSICommand(cmdCtx, &m.currentCBW)
+
+	u.mutex.Lock()
+	u.cancel()
+	u.active = false
+	aborted := u.aborted
+	u.mutex.Unlock()
+
+	if aborted {
+		pkg.LogDebug(pkg.ComponentDevice, "command aborted",
+			"tag", cmd.Tag)
+		return nil
+	}
+
+	sense := SenseIU{Tag: cmd.Tag, Status: StatusGood}
+	if status != CSWStatusGood {
+		// Return sense data with the status (autosense)
+		sense.Status = StatusCheckCondition
+		sense.Sense = m.takeSense(lun)
+	}
+
+	return m.sendSenseIU(ctx, &sense)
+}
+
+// startDataPhase sends the Read Ready or Write Ready IU for the executing
+// command before its first data transfer.
+func (m *MSC) startDataPhase(ctx context.Context, id uint8) error {
+	u := &m.uas
+	if u.dataReady {
+		return nil
+	}
+	if err := m.sendReadyIU(ctx, id, u.current.Tag); err != nil {
+		return err
+	}
+	u.dataReady = true
+	return nil
+}
+
+// sendSenseIU sends a Sense IU on the status pipe.
+func (m *MSC) sendSenseIU(ctx context.Context, iu *SenseIU) error {
+	u := &m.uas
+	u.statusMutex.Lock()
+	defer u.statusMutex.Unlock()
+
+	n := iu.MarshalTo(u.statusBuf[:])
+	if err := m.writeStatus(ctx, u.statusBuf[:n]); err != nil {
+		return err
+	}
+
+	pkg.LogDebug(pkg.ComponentDevice, "Sense IU sent",
+		"tag", iu.Tag,
+		"status", iu.Status)
+
+	return nil
+}
+
+// sendResponseIU sends a Response IU on the status pipe.
+func (m *MSC) sendResponseIU(ctx context.Context, tag uint16, code uint8) error {
+	u := &m.uas
+	u.statusMutex.Lock()
+	defer u.statusMutex.Unlock()
+
+	iu := ResponseIU{Tag: tag, Code: code}
+	n := iu.MarshalTo(u.statusBuf[:])
+	if err := m.writeStatus(ctx, u.statusBuf[:n]); err != nil {
+		return err
+	}
+
+	pkg.LogDebug(pkg.ComponentDevice, "Response IU sent",
+		"tag", tag,
+		"code", code)
+
+	return nil
+}
+
+// sendReadyIU sends a Read Ready or Write Ready IU on the status pipe.
+func (m *MSC) sendReadyIU(ctx context.Context, id uint8, tag uint16) error {
+	u := &m.uas
+	u.statusMutex.Lock()
+	defer u.statusMutex.Unlock()
+
+	n := MarshalReadyIU(u.statusBuf[:], id, tag)
+	return m.writeStatus(ctx, u.statusBuf[:n])
+}
+
+// writeStatus writes an IU to the status pipe.
+func (m *MSC) writeStatus(ctx context.Context, data []byte) error {
+	m.mutex.RLock()
+	stack := m.stack
+	ep := m.statusEP
+	m.mutex.RUnlock()
+
+	if stack == nil || ep == nil {
+		return pkg.ErrNotConfigured
+	}
+
+	_, err := stack.Write(ctx, ep, data)
+	return err
+}
+
+// findUASPipes finds the UAS pipes of an alternate setting from the pipe
+// usage descriptor following each endpoint. The caller must hold m.mutex.
+func (m *MSC) findUASPipes(alt *device.Interface) error {
+	for _, ep := range alt.Endpoints() {
+		desc := ep.ClassDescriptors()
+		if !ep.IsBulk() || len(desc) < PipeUsageDescriptorSize ||
+			desc[1] != DescriptorTypePipeUsage {
+			continue
+		}
+		switch desc[2] {
+		case PipeIDCommand:
+			m.commandEP = ep
+		case PipeIDStatus:
+			m.statusEP = ep
+		case PipeIDDataIn:
+			m.dataInEP = ep
+		case PipeIDDataOut:
+			m.dataOutEP = ep
+		}
+	}
+
+	if m.commandEP == nil || m.statusEP == nil || m.dataInEP == nil || m.dataOutEP == nil {
+		return pkg.ErrInvalidEndpoint
+	}
+	return nil
+}
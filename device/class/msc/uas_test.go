@@ -0,0 +1,192 @@
+package msc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// sendCommandIU sends a Command IU on the command pipe.
+func sendCommandIU(t *testing.T, fake *fakeHAL, tag uint16, attr uint8, cdb []byte) {
+	t.Helper()
+	iu := CommandIU{Tag: tag, TaskAttribute: attr, LUN: NewLUN(0)}
+	copy(iu.CDB[:], cdb)
+	var buf [CommandIUSize]byte
+	fake.send(t, testCommandEP, buf[:iu.MarshalTo(buf[:])])
+}
+
+// sendTaskMgmtIU sends a Task Management IU on the command pipe.
+func sendTaskMgmtIU(t *testing.T, fake *fakeHAL, tag uint16, function uint8, managedTag uint16) {
+	t.Helper()
+	iu := TaskMgmtIU{Tag: tag, Function: function, ManagedTag: managedTag, LUN: NewLUN(0)}
+	var buf [TaskMgmtIUSize]byte
+	fake.send(t, testCommandEP, buf[:iu.MarshalTo(buf[:])])
+}
+
+// expectIU waits for an IU on the status pipe and checks its IU ID and tag.
+func expectIU(t *testing.T, fake *fakeHAL, id uint8, tag uint16) []byte {
+	t.Helper()
+	iu := fake.receive(t, testStatusEP)
+	if len(iu) < ReadyIUSize || iu[0] != id || binary.BigEndian.Uint16(iu[2:4]) != tag {
+		t.Fatalf("status IU = % X, want ID %02X tag %d", iu, id, tag)
+	}
+	return iu
+}
+
+// expectSenseIU waits for a Sense IU and checks its status.
+func expectSenseIU(t *testing.T, fake *fakeHAL, tag uint16, status uint8) {
+	t.Helper()
+	if iu := expectIU(t, fake, IUIDSense, tag); len(iu) < SenseIUHeaderSize || iu[6] != status {
+		t.Fatalf("Sense IU = % X, want status %02X", iu, status)
+	}
+}
+
+// expectResponseIU waits for a Response IU and checks its response code.
+func expectResponseIU(t *testing.T, fake *fakeHAL, tag uint16, code uint8) {
+	t.Helper()
+	if iu := expectIU(t, fake, IUIDResponse, tag); len(iu) != ResponseIUSize || iu[7] != code {
+		t.Fatalf("Response IU = % X, want code %02X", iu, code)
+	}
+}
+
+// startBlockedRead starts a READ (10) of one block with the given tag and
+// returns once its data phase has started. The command blocks until the
+// test receives its data on the data-in pipe.
+func startBlockedRead(t *testing.T, fake *fakeHAL, tag uint16) {
+	t.Helper()
+	sendCommandIU(t, fake, tag, TaskAttrSimple, read10(0, 1))
+	expectIU(t, fake, IUIDReadReady, tag)
+}
+
+// testUnitReady is a TEST UNIT READY CDB.
+var testUnitReady = []byte{SCSITestUnitReady, 0, 0, 0, 0, 0}
+
+func TestUASReadWrite(t *testing.T) {
+	m := New(newTestStorage(4, 0xA0), "VENDOR", "PRODUCT")
+	fake := startMSC(t, m, true)
+
+	// READ has no data transfer length to check against
+	startBlockedRead(t, fake, 1)
+	if data := fake.receive(t, testDataInEP); !bytes.Equal(data, bytes.Repeat([]byte{0xA0}, testBlockSize)) {
+		t.Errorf("READ(10) data = % X...", data[:4])
+	}
+	expectSenseIU(t, fake, 1, StatusGood)
+
+	block := bytes.Repeat([]byte{0x5A}, testBlockSize)
+	sendCommandIU(t, fake, 2, TaskAttrSimple, write10(3, 1))
+	expectIU(t, fake, IUIDWriteReady, 2)
+	fake.send(t, testDataOutEP, block)
+	expectSenseIU(t, fake, 2, StatusGood)
+
+	buf := make([]byte, testBlockSize)
+	m.Storage(0).Read(3, 1, buf)
+	if !bytes.Equal(buf, block) {
+		t.Errorf("block 3 = % X..., want 5A", buf[:4])
+	}
+
+	// Failed commands return sense data with the status
+	sendCommandIU(t, fake, 3, TaskAttrSimple, read10(4, 1))
+	iu := expectIU(t, fake, IUIDSense, 3)
+	if len(iu) != SenseIUMaxSize || iu[6] != StatusCheckCondition {
+		t.Fatalf("Sense IU = % X, want CHECK CONDITION with sense data", iu)
+	}
+	checkSense(t, iu[SenseIUHeaderSize:], SenseIllegalRequest, ASCLBAOutOfRange, 0)
+}
+
+func TestUASOverlappedTag(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	fake := startMSC(t, m, true)
+
+	// A tag in use by the executing or a queued command is rejected
+	startBlockedRead(t, fake, 1)
+	sendCommandIU(t, fake, 1, TaskAttrSimple, testUnitReady)
+	expectResponseIU(t, fake, 1, ResponseOverlappedTag)
+	sendCommandIU(t, fake, 2, TaskAttrSimple, testUnitReady)
+	sendCommandIU(t, fake, 2, TaskAttrSimple, testUnitReady)
+	expectResponseIU(t, fake, 2, ResponseOverlappedTag)
+
+	// Both commands still complete, once each
+	fake.receive(t, testDataInEP)
+	expectSenseIU(t, fake, 1, StatusGood)
+	expectSenseIU(t, fake, 2, StatusGood)
+
+	// A completed command's tag can be reused
+	sendCommandIU(t, fake, 1, TaskAttrSimple, testUnitReady)
+	expectSenseIU(t, fake, 1, StatusGood)
+}
+
+func TestUASTaskSetFull(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	fake := startMSC(t, m, true)
+
+	startBlockedRead(t, fake, 1)
+	for tag := uint16(2); tag < 2+UASQueueDepth; tag++ {
+		sendCommandIU(t, fake, tag, TaskAttrSimple, testUnitReady)
+	}
+	sendCommandIU(t, fake, 100, TaskAttrSimple, testUnitReady)
+	expectSenseIU(t, fake, 100, StatusTaskSetFull)
+
+	// The queued commands are unaffected
+	fake.receive(t, testDataInEP)
+	for tag := uint16(1); tag < 2+UASQueueDepth; tag++ {
+		expectSenseIU(t, fake, tag, StatusGood)
+	}
+}
+
+func TestUASHeadOfQueue(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	fake := startMSC(t, m, true)
+
+	startBlockedRead(t, fake, 1)
+	sendCommandIU(t, fake, 2, TaskAttrSimple, testUnitReady)
+	sendCommandIU(t, fake, 3, TaskAttrSimple, testUnitReady)
+	sendCommandIU(t, fake, 4, TaskAttrHead, testUnitReady)
+	sendCommandIU(t, fake, 5, TaskAttrHead, testUnitReady)
+
+	// IUs are processed in order, so the query follows the commands
+	sendTaskMgmtIU(t, fake, 10, TaskMgmtQueryTask, 5)
+	expectResponseIU(t, fake, 10, ResponseSucceeded)
+
+	// The executing command finishes first, then head of queue commands
+	// in reverse order of arrival, then simple commands in order
+	fake.receive(t, testDataInEP)
+	for _, tag := range []uint16{1, 5, 4, 2, 3} {
+		expectSenseIU(t, fake, tag, StatusGood)
+	}
+}
+
+func TestUASAbortTask(t *testing.T) {
+	m := New(newTestStorage(4, 0), "VENDOR", "PRODUCT")
+	fake := startMSC(t, m, true)
+
+	startBlockedRead(t, fake, 1)
+	sendCommandIU(t, fake, 2, TaskAttrSimple, testUnitReady)
+	sendCommandIU(t, fake, 3, TaskAttrSimple, testUnitReady)
+
+	// Aborting a queued command removes it
+	sendTaskMgmtIU(t, fake, 10, TaskMgmtAbortTask, 2)
+	expectResponseIU(t, fake, 10, ResponseComplete)
+	sendTaskMgmtIU(t, fake, 11, TaskMgmtQueryTask, 2)
+	expectResponseIU(t, fake, 11, ResponseComplete)
+
+	// Aborting the executing command cancels its data phase, and it
+	// completes without a Sense IU
+	sendTaskMgmtIU(t, fake, 12, TaskMgmtAbortTask, 1)
+	expectResponseIU(t, fake, 12, ResponseComplete)
+	expectSenseIU(t, fake, 3, StatusGood)
+
+	select {
+	case data := <-fake.pipes[testDataInEP]:
+		t.Errorf("aborted command sent % X", data)
+	case iu := <-fake.pipes[testStatusEP]:
+		t.Errorf("unexpected status IU % X", iu)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Tags of aborted commands are free again
+	sendTaskMgmtIU(t, fake, 13, TaskMgmtQueryTaskSet, 0)
+	expectResponseIU(t, fake, 13, ResponseComplete)
+	sendCommandIU(t, fake, 1, TaskAttrSimple, testUnitReady)
+	expectSenseIU(t, fake, 1, StatusGood)
+}
@@ -0,0 +1,171 @@
+package msc
+
+import (
+	"context"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// handleUnmap processes UNMAP command. The LUN must have a Storage that
+// implements Discarder.
+func (m *MSC) handleUnmap(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	discarder, ok := lu.storage.(Discarder)
+	if !ok {
+		m.setSense(lu, SenseIllegalRequest, ASCInvalidCommand, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	if lu.storage.IsReadOnly() {
+		m.setSense(lu, SenseDataProtect, ASCWriteProtected, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	paramLength := int(parseU16BE(cbw.CB[:], 7))
+	if paramLength == 0 {
+		return CSWStatusGood, cbw.DataTransferLength
+	}
+	if paramLength < UnmapHeaderSize {
+		m.setSense(lu, SenseIllegalRequest, ASCParameterListLength, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	// Receive parameter list from host
+	if err := m.receiveData(ctx, m.dataBuf[:paramLength]); err != nil {
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+	residue := cbw.DataTransferLength - uint32(paramLength)
+
+	descLength := int(parseU16BE(m.dataBuf[:], 2))
+	if descLength > paramLength-UnmapHeaderSize {
+		m.setSense(lu, SenseIllegalRequest, ASCParameterListLength, 0)
+		return CSWStatusFailed, residue
+	}
+
+	params := m.dataBuf[UnmapHeaderSize : UnmapHeaderSize+descLength]
+	blockCount := lu.storage.BlockCount()
+
+	// Check every descriptor before discarding any blocks
+	var desc UnmapDescriptor
+	for off := 0; ParseUnmapDescriptor(params[off:], &desc); off += UnmapDescriptorSize {
+		if desc.LBA > blockCount || uint64(desc.Blocks) > blockCount-desc.LBA {
+			m.setSense(lu, SenseIllegalRequest, ASCLBAOutOfRange, 0)
+			return CSWStatusFailed, residue
+		}
+	}
+
+	for off := 0; ParseUnmapDescriptor(params[off:], &desc); off += UnmapDescriptorSize {
+		if desc.Blocks == 0 {
+			continue
+		}
+
+		pkg.LogDebug(pkg.ComponentDevice, "UNMAP",
+			"lba", desc.LBA,
+			"blocks", desc.Blocks)
+
+		if err := discarder.Discard(desc.LBA, uint64(desc.Blocks)); err != nil {
+			pkg.LogWarn(pkg.ComponentDevice, "discard error", "error", err)
+			m.setSense(lu, SenseMediumError, ASCNoAdditionalInfo, 0)
+			return CSWStatusFailed, residue
+		}
+	}
+
+	return CSWStatusGood, residue
+}
+
+// handleWriteSame10 processes WRITE SAME (10) command.
+func (m *MSC) handleWriteSame10(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	lba := parseU32BE(cbw.CB[:], 2)
+	blocks := parseU16BE(cbw.CB[:], 7)
+	return m.writeSame(ctx, lu, cbw, uint64(lba), uint32(blocks))
+}
+
+// handleWriteSame16 processes WRITE SAME (16) command.
+func (m *MSC) handleWriteSame16(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper) (uint8, uint32) {
+	lba := parseU64BE(cbw.CB[:], 2)
+	blocks := parseU32BE(cbw.CB[:], 10)
+	return m.writeSame(ctx, lu, cbw, lba, blocks)
+}
+
+// writeSame receives one block from the host and writes it to blocks
+// starting at lba. If the UNMAP bit is set, the block is all zeros, and the
+// storage implements Discarder, the blocks are discarded instead.
+func (m *MSC) writeSame(ctx context.Context, lu *logicalUnit, cbw *CommandBlockWrapper, lba uint64, blocks uint32) (uint8, uint32) {
+	if !lu.storage.IsPresent() {
+		m.setSense(lu, SenseNotReady, ASCMediumNotPresent, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	if lu.storage.IsReadOnly() {
+		m.setSense(lu, SenseDataProtect, ASCWriteProtected, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	// Writing to the end of the medium (zero blocks) is not supported
+	if blocks == 0 {
+		m.setSense(lu, SenseIllegalRequest, ASCInvalidFieldInCDB, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	chunk := m.checkRange(lu, lba, uint64(blocks))
+	if chunk == 0 {
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+
+	// Receive the block from host
+	blockSize := lu.storage.BlockSize()
+	if err := m.receiveData(ctx, m.dataBuf[:blockSize]); err != nil {
+		m.setSense(lu, SenseHardwareError, ASCNoAdditionalInfo, 0)
+		return CSWStatusFailed, cbw.DataTransferLength
+	}
+	residue := cbw.DataTransferLength - blockSize
+
+	pkg.LogDebug(pkg.ComponentDevice, "WRITE SAME",
+		"lba", lba,
+		"blocks", blocks,
+		"unmap", cbw.CB[1]&WriteSameUnmap != 0)
+
+	if discarder, ok := lu.storage.(Discarder); ok &&
+		cbw.CB[1]&WriteSameUnmap != 0 && isZero(m.dataBuf[:blockSize]) {
+		if err := discarder.Discard(lba, uint64(blocks)); err != nil {
+			pkg.LogWarn(pkg.ComponentDevice, "discard error", "error", err)
+			m.setSense(lu, SenseMediumError, ASCNoAdditionalInfo, 0)
+			return CSWStatusFailed, residue
+		}
+		return CSWStatusGood, residue
+	}
+
+	// Replicate the block across as much of the buffer as is needed
+	n := min(blocks, chunk)
+	for i := uint32(1); i < n; i++ {
+		copy(m.dataBuf[i*blockSize:(i+1)*blockSize], m.dataBuf[:blockSize])
+	}
+
+	for blocks > 0 {
+		n := min(blocks, chunk)
+		if _, err := lu.storage.Write(lba, n, m.dataBuf[:n*blockSize]); err != nil {
+			pkg.LogWarn(pkg.ComponentDevice, "write error", "error", err)
+			m.setSense(lu, SenseMediumError, ASCNoAdditionalInfo, 0)
+			return CSWStatusFailed, residue
+		}
+		lba += uint64(n)
+		blocks -= n
+	}
+
+	return CSWStatusGood, residue
+}
+
+// isZero reports whether every byte of data is zero.
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
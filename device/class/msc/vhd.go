@@ -0,0 +1,438 @@
+package msc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+var (
+	// ErrInvalidImage is returned when a disk image is malformed or its
+	// checksum does not match.
+	ErrInvalidImage = errors.New("invalid disk image")
+
+	// ErrUnsupportedImage is returned for disk image types that cannot be
+	// read, such as differencing VHDs.
+	ErrUnsupportedImage = errors.New("unsupported disk image type")
+)
+
+// VHD disk types.
+const (
+	VHDTypeFixed        = 2 // Fixed hard disk
+	VHDTypeDynamic      = 3 // Dynamic hard disk
+	VHDTypeDifferencing = 4 // Differencing hard disk
+)
+
+// VHD format constants.
+const (
+	VHDFooterSize        = 512                // Hard disk footer
+	VHDDynamicHeaderSize = 1024               // Dynamic disk header
+	VHDSectorSize        = 512                // Sector size
+	VHDUnallocated       = 0xFFFFFFFF         // BAT entry of an unallocated block
+	VHDNoDataOffset      = 0xFFFFFFFFFFFFFFFF // Footer data offset of a fixed disk
+)
+
+// VHD cookies.
+const (
+	vhdFooterCookie  = "conectix"
+	vhdDynamicCookie = "cxsparse"
+)
+
+// VHDFooter represents the hard disk footer at the end of a VHD image.
+type VHDFooter struct {
+	Features       uint32   // Feature flags
+	FormatVersion  uint32   // File format version (0x00010000)
+	DataOffset     uint64   // Offset of the dynamic disk header, or VHDNoDataOffset
+	Timestamp      uint32   // Seconds since 2000-01-01 00:00:00 UTC
+	CreatorApp     [4]byte  // Creator application
+	CreatorVersion uint32   // Creator version
+	CreatorHostOS  uint32   // Creator host OS
+	OriginalSize   uint64   // Size at creation in bytes
+	CurrentSize    uint64   // Current size in bytes
+	Geometry       uint32   // Cylinders, heads, and sectors per track
+	DiskType       uint32   // Disk type (VHDType*)
+	UniqueID       [16]byte // Unique identifier
+	SavedState     uint8    // Saved state flag
+}
+
+// ParseVHDFooter parses a VHD hard disk footer from raw bytes.
+// Returns false if data is too short, or the cookie or checksum is invalid.
+func ParseVHDFooter(data []byte, out *VHDFooter) bool {
+	if len(data) < VHDFooterSize || string(data[0:8]) != vhdFooterCookie {
+		return false
+	}
+	if binary.BigEndian.Uint32(data[64:68]) != vhdChecksum(data[:VHDFooterSize], 64) {
+		return false
+	}
+
+	out.Features = binary.BigEndian.Uint32(data[8:12])
+	out.FormatVersion = binary.BigEndian.Uint32(data[12:16])
+	out.DataOffset = binary.BigEndian.Uint64(data[16:24])
+	out.Timestamp = binary.BigEndian.Uint32(data[24:28])
+	copy(out.CreatorApp[:], data[28:32])
+	out.CreatorVersion = binary.BigEndian.Uint32(data[32:36])
+	out.CreatorHostOS = binary.BigEndian.Uint32(data[36:40])
+	out.OriginalSize = binary.BigEndian.Uint64(data[40:48])
+	out.CurrentSize = binary.BigEndian.Uint64(data[48:56])
+	out.Geometry = binary.BigEndian.Uint32(data[56:60])
+	out.DiskType = binary.BigEndian.Uint32(data[60:64])
+	copy(out.UniqueID[:], data[68:84])
+	out.SavedState = data[84]
+
+	return true
+}
+
+// MarshalTo writes the VHD hard disk footer, with its cookie and checksum,
+// to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (f *VHDFooter) MarshalTo(buf []byte) int {
+	if len(buf) < VHDFooterSize {
+		return 0
+	}
+
+	clear(buf[:VHDFooterSize])
+	copy(buf[0:8], vhdFooterCookie)
+	binary.BigEndian.PutUint32(buf[8:12], f.Features)
+	binary.BigEndian.PutUint32(buf[12:16], f.FormatVersion)
+	binary.BigEndian.PutUint64(buf[16:24], f.DataOffset)
+	binary.BigEndian.PutUint32(buf[24:28], f.Timestamp)
+	copy(buf[28:32], f.CreatorApp[:])
+	binary.BigEndian.PutUint32(buf[32:36], f.CreatorVersion)
+	binary.BigEndian.PutUint32(buf[36:40], f.CreatorHostOS)
+	binary.BigEndian.PutUint64(buf[40:48], f.OriginalSize)
+	binary.BigEndian.PutUint64(buf[48:56], f.CurrentSize)
+	binary.BigEndian.PutUint32(buf[56:60], f.Geometry)
+	binary.BigEndian.PutUint32(buf[60:64], f.DiskType)
+	copy(buf[68:84], f.UniqueID[:])
+	buf[84] = f.SavedState
+	binary.BigEndian.PutUint32(buf[64:68], vhdChecksum(buf[:VHDFooterSize], 64))
+
+	return VHDFooterSize
+}
+
+// VHDDynamicHeader represents the dynamic disk header of a dynamic or
+// differencing VHD image.
+type VHDDynamicHeader struct {
+	TableOffset     uint64 // Offset of the block allocation table (BAT)
+	HeaderVersion   uint32 // Header version (0x00010000)
+	MaxTableEntries uint32 // Number of BAT entries
+	BlockSize       uint32 // Size of a data block in bytes (2 MB by default)
+}
+
+// ParseVHDDynamicHeader parses a VHD dynamic disk header from raw bytes.
+// Returns false if data is too short, or the cookie or checksum is invalid.
+func ParseVHDDynamicHeader(data []byte, out *VHDDynamicHeader) bool {
+	if len(data) < VHDDynamicHeaderSize || string(data[0:8]) != vhdDynamicCookie {
+		return false
+	}
+	if binary.BigEndian.Uint32(data[36:40]) != vhdChecksum(data[:VHDDynamicHeaderSize], 36) {
+		return false
+	}
+
+	out.TableOffset = binary.BigEndian.Uint64(data[16:24])
+	out.HeaderVersion = binary.BigEndian.Uint32(data[24:28])
+	out.MaxTableEntries = binary.BigEndian.Uint32(data[28:32])
+	out.BlockSize = binary.BigEndian.Uint32(data[32:36])
+
+	return true
+}
+
+// MarshalTo writes the VHD dynamic disk header, with its cookie and
+// checksum, to buf. Parent locators are left empty.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (h *VHDDynamicHeader) MarshalTo(buf []byte) int {
+	if len(buf) < VHDDynamicHeaderSize {
+		return 0
+	}
+
+	clear(buf[:VHDDynamicHeaderSize])
+	copy(buf[0:8], vhdDynamicCookie)
+	binary.BigEndian.PutUint64(buf[8:16], VHDNoDataOffset)
+	binary.BigEndian.PutUint64(buf[16:24], h.TableOffset)
+	binary.BigEndian.PutUint32(buf[24:28], h.HeaderVersion)
+	binary.BigEndian.PutUint32(buf[28:32], h.MaxTableEntries)
+	binary.BigEndian.PutUint32(buf[32:36], h.BlockSize)
+	binary.BigEndian.PutUint32(buf[36:40], vhdChecksum(buf[:VHDDynamicHeaderSize], 36))
+
+	return VHDDynamicHeaderSize
+}
+
+// vhdChecksum returns the one's complement of the sum of all bytes of
+// data, excluding the 4-byte checksum field at offset.
+func vhdChecksum(data []byte, offset int) uint32 {
+	var sum uint32
+	for i, b := range data {
+		if i < offset || i >= offset+4 {
+			sum += uint32(b)
+		}
+	}
+	return ^sum
+}
+
+// VHDStorage implements Storage interface as a read-only reader of fixed
+// and dynamic VHD images. Unallocated blocks of dynamic images read as
+// zeros. Use NewOverlayStorage to make the image writable without
+// modifying it.
+type VHDStorage struct {
+	r         io.ReaderAt
+	closer    io.Closer
+	blockSize uint32
+	size      uint64 // Virtual disk size in bytes
+
+	// Dynamic images
+	dynamic    bool
+	dataBlock  uint64   // Size of a VHD data block in bytes
+	bitmapSize uint64   // Size of the sector bitmap before each data block
+	bat        []uint32 // Block allocation table (sector offsets)
+	bitmap     []byte   // Sector bitmap buffer
+
+	mutex sync.Mutex
+}
+
+// OpenVHD opens a VHD image file read-only, presenting it as blocks of
+// blockSize bytes (a multiple of VHDSectorSize).
+func OpenVHD(path string, blockSize uint32) (*VHDStorage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	v, err := NewVHDStorage(file, stat.Size(), blockSize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	v.closer = file
+	return v, nil
+}
+
+// NewVHDStorage creates a VHD image reader over r, which holds an image of
+// size bytes, presenting it as blocks of blockSize bytes (a multiple of
+// VHDSectorSize).
+// Returns ErrInvalidImage if the image is malformed, or
+// ErrUnsupportedImage if it is not a fixed or dynamic image.
+func NewVHDStorage(r io.ReaderAt, size int64, blockSize uint32) (*VHDStorage, error) {
+	if blockSize == 0 || blockSize%VHDSectorSize != 0 {
+		return nil, pkg.ErrInvalidParameter
+	}
+	if size < VHDFooterSize {
+		return nil, ErrInvalidImage
+	}
+
+	var buf [VHDDynamicHeaderSize]byte
+	if _, err := r.ReadAt(buf[:VHDFooterSize], size-VHDFooterSize); err != nil {
+		return nil, err
+	}
+	var footer VHDFooter
+	if !ParseVHDFooter(buf[:], &footer) {
+		return nil, ErrInvalidImage
+	}
+
+	v := &VHDStorage{
+		r:         r,
+		blockSize: blockSize,
+		size:      footer.CurrentSize,
+	}
+
+	switch footer.DiskType {
+	case VHDTypeFixed:
+		if footer.CurrentSize > uint64(size-VHDFooterSize) {
+			return nil, ErrInvalidImage
+		}
+
+	case VHDTypeDynamic:
+		if err := v.readDynamicHeader(footer.DataOffset, size); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, ErrUnsupportedImage
+	}
+
+	pkg.LogDebug(pkg.ComponentDevice, "VHD image opened",
+		"type", footer.DiskType,
+		"size", footer.CurrentSize)
+
+	return v, nil
+}
+
+// readDynamicHeader reads the dynamic disk header and the block
+// allocation table of a dynamic image.
+func (v *VHDStorage) readDynamicHeader(offset uint64, size int64) error {
+	if size < VHDDynamicHeaderSize || offset > uint64(size)-VHDDynamicHeaderSize {
+		return ErrInvalidImage
+	}
+
+	var buf [VHDDynamicHeaderSize]byte
+	if _, err := v.r.ReadAt(buf[:], int64(offset)); err != nil {
+		return err
+	}
+	var header VHDDynamicHeader
+	if !ParseVHDDynamicHeader(buf[:], &header) {
+		return ErrInvalidImage
+	}
+
+	if header.BlockSize == 0 || header.BlockSize%VHDSectorSize != 0 {
+		return ErrInvalidImage
+	}
+	v.dataBlock = uint64(header.BlockSize)
+	blocks := v.size / v.dataBlock
+	if v.size%v.dataBlock != 0 {
+		blocks++
+	}
+	if blocks > uint64(header.MaxTableEntries) {
+		return ErrInvalidImage
+	}
+
+	tableSize := uint64(header.MaxTableEntries) * 4
+	if header.TableOffset > uint64(size) || tableSize > uint64(size)-header.TableOffset {
+		return ErrInvalidImage
+	}
+	table := make([]byte, tableSize)
+	if _, err := v.r.ReadAt(table, int64(header.TableOffset)); err != nil {
+		return err
+	}
+	v.bat = make([]uint32, header.MaxTableEntries)
+	for i := range v.bat {
+		v.bat[i] = binary.BigEndian.Uint32(table[i*4:])
+	}
+
+	// One bit per sector, padded to a sector boundary
+	sectors := v.dataBlock / VHDSectorSize
+	v.bitmapSize = ((sectors+7)/8 + VHDSectorSize - 1) / VHDSectorSize * VHDSectorSize
+	v.bitmap = make([]byte, v.bitmapSize)
+	v.dynamic = true
+	return nil
+}
+
+// BlockSize returns the block size.
+func (v *VHDStorage) BlockSize() uint32 {
+	return v.blockSize
+}
+
+// BlockCount returns the number of blocks.
+func (v *VHDStorage) BlockCount() uint64 {
+	return v.size / uint64(v.blockSize)
+}
+
+// Read reads blocks from the image.
+func (v *VHDStorage) Read(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.r == nil {
+		return 0, io.EOF
+	}
+
+	offset := lba * uint64(v.blockSize)
+	length := uint64(blocks) * uint64(v.blockSize)
+
+	if offset+length > v.size {
+		return 0, io.EOF
+	}
+
+	if uint64(len(buf)) < length {
+		return 0, io.ErrShortBuffer
+	}
+
+	if !v.dynamic {
+		if _, err := v.r.ReadAt(buf[:length], int64(offset)); err != nil {
+			return 0, err
+		}
+		return blocks, nil
+	}
+
+	// Read each part of the range that lies within one data block
+	for done := uint64(0); done < length; {
+		pos := offset + done
+		index := pos / v.dataBlock
+		within := pos % v.dataBlock
+		n := min(length-done, v.dataBlock-within)
+		if err := v.readDynamic(index, within, buf[done:done+n]); err != nil {
+			return uint32(done / uint64(v.blockSize)), err
+		}
+		done += n
+	}
+	return blocks, nil
+}
+
+// readDynamic reads part of data block index, starting at byte offset
+// within, into dst. Sectors not marked present in the sector bitmap read
+// as zeros.
+func (v *VHDStorage) readDynamic(index, within uint64, dst []byte) error {
+	if v.bat[index] == VHDUnallocated {
+		clear(dst)
+		return nil
+	}
+
+	start := uint64(v.bat[index]) * VHDSectorSize
+	if _, err := v.r.ReadAt(v.bitmap, int64(start)); err != nil {
+		return err
+	}
+	if _, err := v.r.ReadAt(dst, int64(start+v.bitmapSize+within)); err != nil {
+		return err
+	}
+
+	first := within / VHDSectorSize
+	for i := uint64(0); i < uint64(len(dst))/VHDSectorSize; i++ {
+		sector := first + i
+		if v.bitmap[sector/8]&(0x80>>(sector%8)) == 0 {
+			clear(dst[i*VHDSectorSize : (i+1)*VHDSectorSize])
+		}
+	}
+	return nil
+}
+
+// Write is not supported for VHD images.
+func (v *VHDStorage) Write(lba uint64, blocks uint32, buf []byte) (uint32, error) {
+	return 0, os.ErrPermission
+}
+
+// Sync is a no-op for read-only storage.
+func (v *VHDStorage) Sync() error {
+	return nil
+}
+
+// IsReadOnly returns true (VHD images are read-only).
+func (v *VHDStorage) IsReadOnly() bool {
+	return true
+}
+
+// IsRemovable returns false (VHD storage is not removable).
+func (v *VHDStorage) IsRemovable() bool {
+	return false
+}
+
+// IsPresent returns true (VHD storage is always present).
+func (v *VHDStorage) IsPresent() bool {
+	return true
+}
+
+// Eject is not supported for VHD storage.
+func (v *VHDStorage) Eject() error {
+	return os.ErrPermission
+}
+
+// Close closes the image file if it was opened with OpenVHD.
+func (v *VHDStorage) Close() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.r = nil
+	if v.closer != nil {
+		err := v.closer.Close()
+		v.closer = nil
+		return err
+	}
+	return nil
+}
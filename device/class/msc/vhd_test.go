@@ -0,0 +1,278 @@
+package msc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// vhdImage describes a dynamic VHD image to build for tests.
+type vhdImage struct {
+	size       uint64   // Virtual disk size
+	blockSize  uint32   // Data block size
+	entries    uint32   // BAT entries
+	allocated  []uint32 // Indices of allocated data blocks
+	tableAfter int64    // Extra offset of the BAT past the header
+}
+
+// build returns the image bytes. Each allocated block has all sectors
+// present except the second, and its sectors are filled with the
+// sector number within the disk.
+func (img *vhdImage) build() []byte {
+	const headerOffset = VHDFooterSize
+	tableOffset := headerOffset + VHDDynamicHeaderSize + img.tableAfter
+	tableSize := (int64(img.entries)*4 + VHDSectorSize - 1) / VHDSectorSize * VHDSectorSize
+
+	sectors := int64(img.blockSize) / VHDSectorSize
+	bitmapSize := ((sectors+7)/8 + VHDSectorSize - 1) / VHDSectorSize * VHDSectorSize
+	dataStart := tableOffset + tableSize
+	buf := make([]byte, dataStart+int64(len(img.allocated))*(bitmapSize+int64(img.blockSize))+VHDFooterSize)
+
+	footer := VHDFooter{
+		FormatVersion: 0x00010000,
+		DataOffset:    headerOffset,
+		CurrentSize:   img.size,
+		DiskType:      VHDTypeDynamic,
+	}
+	footer.MarshalTo(buf[0:])
+	footer.MarshalTo(buf[len(buf)-VHDFooterSize:])
+
+	header := VHDDynamicHeader{
+		TableOffset:     uint64(tableOffset),
+		HeaderVersion:   0x00010000,
+		MaxTableEntries: img.entries,
+		BlockSize:       img.blockSize,
+	}
+	header.MarshalTo(buf[headerOffset:])
+
+	for i := uint32(0); i < img.entries && int64(i)*4 < tableSize; i++ {
+		binary.BigEndian.PutUint32(buf[tableOffset+int64(i)*4:], VHDUnallocated)
+	}
+	for n, index := range img.allocated {
+		start := dataStart + int64(n)*(bitmapSize+int64(img.blockSize))
+		binary.BigEndian.PutUint32(buf[tableOffset+int64(index)*4:], uint32(start/VHDSectorSize))
+		for s := int64(0); s < sectors; s++ {
+			if s != 1 {
+				buf[start+s/8] |= 0x80 >> (s % 8)
+			}
+			sector := buf[start+bitmapSize+s*VHDSectorSize : start+bitmapSize+(s+1)*VHDSectorSize]
+			for i := range sector {
+				sector[i] = byte(int64(index)*sectors + s)
+			}
+		}
+	}
+	return buf
+}
+
+func TestVHDFooter(t *testing.T) {
+	footer := VHDFooter{
+		FormatVersion: 0x00010000,
+		DataOffset:    VHDNoDataOffset,
+		CreatorApp:    [4]byte{'t', 'e', 's', 't'},
+		CurrentSize:   1 << 20,
+		DiskType:      VHDTypeFixed,
+	}
+	var buf [VHDFooterSize]byte
+	if n := footer.MarshalTo(buf[:]); n != VHDFooterSize {
+		t.Fatalf("MarshalTo() = %d, want %d", n, VHDFooterSize)
+	}
+	if n := footer.MarshalTo(buf[:VHDFooterSize-1]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+
+	var out VHDFooter
+	if !ParseVHDFooter(buf[:], &out) {
+		t.Fatal("ParseVHDFooter() failed")
+	}
+	if out != footer {
+		t.Errorf("parsed %+v, want %+v", out, footer)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{"short", func(b []byte) []byte { return b[:VHDFooterSize-1] }},
+		{"cookie", func(b []byte) []byte { b[0] = 'x'; return b }},
+		{"checksum", func(b []byte) []byte { b[48]++; return b }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(append([]byte{}, buf[:]...))
+			if ParseVHDFooter(data, &out) {
+				t.Error("ParseVHDFooter() should fail")
+			}
+		})
+	}
+}
+
+func TestVHDDynamicHeader(t *testing.T) {
+	header := VHDDynamicHeader{
+		TableOffset:     1536,
+		HeaderVersion:   0x00010000,
+		MaxTableEntries: 16,
+		BlockSize:       2 << 20,
+	}
+	var buf [VHDDynamicHeaderSize]byte
+	if n := header.MarshalTo(buf[:]); n != VHDDynamicHeaderSize {
+		t.Fatalf("MarshalTo() = %d, want %d", n, VHDDynamicHeaderSize)
+	}
+
+	var out VHDDynamicHeader
+	if !ParseVHDDynamicHeader(buf[:], &out) {
+		t.Fatal("ParseVHDDynamicHeader() failed")
+	}
+	if out != header {
+		t.Errorf("parsed %+v, want %+v", out, header)
+	}
+
+	if ParseVHDDynamicHeader(buf[:VHDDynamicHeaderSize-1], &out) {
+		t.Error("ParseVHDDynamicHeader(short) should fail")
+	}
+	buf[30]++
+	if ParseVHDDynamicHeader(buf[:], &out) {
+		t.Error("ParseVHDDynamicHeader(bad checksum) should fail")
+	}
+}
+
+func TestVHDStorageFixed(t *testing.T) {
+	image := make([]byte, 4096+VHDFooterSize)
+	for i := 0; i < 4096; i++ {
+		image[i] = byte(i / VHDSectorSize)
+	}
+	footer := VHDFooter{DataOffset: VHDNoDataOffset, CurrentSize: 4096, DiskType: VHDTypeFixed}
+	footer.MarshalTo(image[4096:])
+
+	v, err := NewVHDStorage(bytes.NewReader(image), int64(len(image)), 512)
+	if err != nil {
+		t.Fatalf("NewVHDStorage() error = %v", err)
+	}
+	if v.BlockCount() != 8 {
+		t.Errorf("BlockCount() = %d, want 8", v.BlockCount())
+	}
+	buf := make([]byte, 1024)
+	if n, err := v.Read(6, 2, buf); n != 2 || err != nil {
+		t.Fatalf("Read() = %d, %v", n, err)
+	}
+	if buf[0] != 6 || buf[512] != 7 {
+		t.Errorf("read sectors %d, %d, want 6, 7", buf[0], buf[512])
+	}
+	if _, err := v.Read(7, 2, buf); err == nil {
+		t.Error("Read() past the end should fail")
+	}
+
+	// The footer claims more data than the image holds
+	footer.CurrentSize = 8192
+	footer.MarshalTo(image[4096:])
+	if _, err := NewVHDStorage(bytes.NewReader(image), int64(len(image)), 512); err != ErrInvalidImage {
+		t.Errorf("NewVHDStorage(oversized) error = %v, want %v", err, ErrInvalidImage)
+	}
+}
+
+func TestVHDStorageDynamic(t *testing.T) {
+	for _, blockSize := range []uint32{512, 1024, 4096, 64 << 10} {
+		img := vhdImage{
+			size:      uint64(blockSize) * 4,
+			blockSize: blockSize,
+			entries:   4,
+			allocated: []uint32{1, 3},
+		}
+		image := img.build()
+
+		v, err := NewVHDStorage(bytes.NewReader(image), int64(len(image)), 512)
+		if err != nil {
+			t.Fatalf("block size %d: NewVHDStorage() error = %v", blockSize, err)
+		}
+
+		sectors := uint64(blockSize) / VHDSectorSize
+		buf := make([]byte, img.size)
+		if n, err := v.Read(0, uint32(v.BlockCount()), buf); n != uint32(v.BlockCount()) || err != nil {
+			t.Fatalf("block size %d: Read() = %d, %v", blockSize, n, err)
+		}
+		for s := uint64(0); s < v.BlockCount(); s++ {
+			index, within := s/sectors, s%sectors
+			want := byte(s)
+			if (index != 1 && index != 3) || within == 1 {
+				want = 0 // Unallocated block or sector not present
+			}
+			if got := buf[s*VHDSectorSize]; got != want {
+				t.Errorf("block size %d: sector %d = %d, want %d", blockSize, s, got, want)
+			}
+		}
+	}
+}
+
+func TestVHDStorageInvalidDynamicHeader(t *testing.T) {
+	valid := vhdImage{size: 8192, blockSize: 4096, entries: 2, allocated: []uint32{0}}
+
+	tests := []struct {
+		name   string
+		img    vhdImage
+		mutate func([]byte)
+		want   error
+	}{
+		{"zero block size", vhdImage{size: 8192, entries: 2}, nil, ErrInvalidImage},
+		{"unaligned block size", vhdImage{size: 8192, blockSize: 1000, entries: 9}, nil, ErrInvalidImage},
+		{"too few entries", vhdImage{size: 8192, blockSize: 4096, entries: 1}, nil, ErrInvalidImage},
+		{"partial block without entry", vhdImage{size: 8704, blockSize: 4096, entries: 2}, nil, ErrInvalidImage},
+		{"huge block size", vhdImage{size: 1 << 62, blockSize: 0xFFFFFE00, entries: 2}, nil, ErrInvalidImage},
+		{"table past end", valid, func(b []byte) {
+			var h VHDDynamicHeader
+			ParseVHDDynamicHeader(b[VHDFooterSize:], &h)
+			h.TableOffset = uint64(len(b))
+			h.MarshalTo(b[VHDFooterSize:])
+		}, ErrInvalidImage},
+		{"many table entries", valid, func(b []byte) {
+			var h VHDDynamicHeader
+			ParseVHDDynamicHeader(b[VHDFooterSize:], &h)
+			h.MaxTableEntries = 0xFFFFFFFF
+			h.MarshalTo(b[VHDFooterSize:])
+		}, ErrInvalidImage},
+		{"header past end", valid, func(b []byte) {
+			var f VHDFooter
+			ParseVHDFooter(b[len(b)-VHDFooterSize:], &f)
+			f.DataOffset = uint64(len(b)) - VHDDynamicHeaderSize + 1
+			f.MarshalTo(b[len(b)-VHDFooterSize:])
+		}, ErrInvalidImage},
+		{"bad header cookie", valid, func(b []byte) { b[VHDFooterSize] = 'x' }, ErrInvalidImage},
+		{"differencing", valid, func(b []byte) {
+			var f VHDFooter
+			ParseVHDFooter(b[len(b)-VHDFooterSize:], &f)
+			f.DiskType = VHDTypeDifferencing
+			f.MarshalTo(b[len(b)-VHDFooterSize:])
+		}, ErrUnsupportedImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := tt.img.build()
+			if tt.mutate != nil {
+				tt.mutate(image)
+			}
+			_, err := NewVHDStorage(bytes.NewReader(image), int64(len(image)), 512)
+			if err != tt.want {
+				t.Errorf("NewVHDStorage() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	image := valid.build()
+	if _, err := NewVHDStorage(bytes.NewReader(image), VHDFooterSize-1, 512); err != ErrInvalidImage {
+		t.Errorf("NewVHDStorage(short) error = %v, want %v", err, ErrInvalidImage)
+	}
+	if _, err := NewVHDStorage(bytes.NewReader(image), int64(len(image)), 0); err != pkg.ErrInvalidParameter {
+		t.Errorf("NewVHDStorage(block size 0) error = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+}
+
+func TestSparseStorageZeroBlockSize(t *testing.T) {
+	s := NewSparseStorage(4096, 0)
+	if s.BlockCount() != 0 {
+		t.Errorf("BlockCount() = %d, want 0", s.BlockCount())
+	}
+	if _, err := s.Read(0, 1, make([]byte, 512)); err == nil {
+		t.Error("Read() should fail")
+	}
+}
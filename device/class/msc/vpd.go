@@ -0,0 +1,130 @@
+package msc
+
+import "encoding/binary"
+
+// vpdPages lists the VPD pages supported by every LUN, in ascending order.
+var vpdPages = [...]uint8{
+	VPDSupportedPages,
+	VPDUnitSerialNumber,
+	VPDDeviceIdentification,
+	VPDBlockLimits,
+	VPDLogicalBlockProvisioning,
+}
+
+// VPD page sizes.
+const (
+	vpdHeaderSize       = 4
+	vpdBlockLimitsSize  = 64
+	vpdProvisioningSize = 8
+	vpdDesignatorHeader = 4
+)
+
+// Device identification designator fields.
+const (
+	vpdCodeSetASCII     = 0x02 // Designator is ASCII
+	vpdDesignatorT10    = 0x01 // T10 vendor ID based, associated with the LUN
+	vpdMaxSerialLength  = 64   // Longest serial number reported
+	vpdProvisioningThin = 0x02 // Thin provisioned
+)
+
+// Logical block provisioning VPD page flags (byte 5).
+const (
+	vpdLBPU    = 0x80 // UNMAP supported
+	vpdLBPWS   = 0x40 // WRITE SAME (16) with UNMAP supported
+	vpdLBPWS10 = 0x20 // WRITE SAME (10) with UNMAP supported
+	vpdLBPRZ   = 0x04 // Unmapped blocks read as zeros
+)
+
+// marshalVPD writes the VPD page with the given page code to buf.
+// Returns the number of bytes written, or 0 if the page is not supported
+// or buf is too small.
+func (lu *logicalUnit) marshalVPD(page uint8, buf []byte) int {
+	if len(buf) < vpdHeaderSize {
+		return 0
+	}
+
+	var n int
+	body := buf[vpdHeaderSize:]
+	switch page {
+	case VPDSupportedPages:
+		n = copy(body, vpdPages[:])
+		if n < len(vpdPages) {
+			return 0
+		}
+
+	case VPDUnitSerialNumber:
+		serial := lu.serialNumber()
+		if len(body) < len(serial) {
+			return 0
+		}
+		n = copy(body, serial)
+
+	case VPDDeviceIdentification:
+		n = lu.marshalDesignator(body)
+		if n == 0 {
+			return 0
+		}
+
+	case VPDBlockLimits:
+		n = vpdBlockLimitsSize - vpdHeaderSize
+		if len(body) < n {
+			return 0
+		}
+		clear(body[:n])
+		body[0] = 0x01 // WSNZ: WRITE SAME of zero blocks is not supported
+		if _, ok := lu.storage.(Discarder); ok {
+			binary.BigEndian.PutUint32(body[16:20], 0xFFFFFFFF)          // Maximum unmap LBA count
+			binary.BigEndian.PutUint32(body[20:24], MaxUnmapDescriptors) // Maximum unmap descriptor count
+			binary.BigEndian.PutUint32(body[24:28], 1)                   // Optimal unmap granularity
+		}
+
+	case VPDLogicalBlockProvisioning:
+		n = vpdProvisioningSize - vpdHeaderSize
+		if len(body) < n {
+			return 0
+		}
+		clear(body[:n])
+		if _, ok := lu.storage.(Discarder); ok {
+			body[1] = vpdLBPU | vpdLBPWS | vpdLBPWS10 | vpdLBPRZ
+			body[2] = vpdProvisioningThin
+		}
+
+	default:
+		return 0
+	}
+
+	buf[0] = lu.inquiry.DeviceType
+	buf[1] = page
+	binary.BigEndian.PutUint16(buf[2:4], uint16(n))
+	return vpdHeaderSize + n
+}
+
+// marshalDesignator writes a T10 vendor ID based designator, made of the
+// INQUIRY vendor and product identification and the serial number, to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (lu *logicalUnit) marshalDesignator(buf []byte) int {
+	serial := lu.serialNumber()
+	length := len(lu.inquiry.VendorID) + len(lu.inquiry.ProductID) + len(serial)
+	if len(buf) < vpdDesignatorHeader+length {
+		return 0
+	}
+
+	buf[0] = vpdCodeSetASCII
+	buf[1] = vpdDesignatorT10
+	buf[2] = 0 // Reserved
+	buf[3] = uint8(length)
+	n := vpdDesignatorHeader
+	n += copy(buf[n:], lu.inquiry.VendorID[:])
+	n += copy(buf[n:], lu.inquiry.ProductID[:])
+	n += copy(buf[n:], serial)
+	return n
+}
+
+// serialNumber returns the serial number of the LUN, truncated to the
+// length reported in VPD pages.
+func (lu *logicalUnit) serialNumber() string {
+	if len(lu.serial) > vpdMaxSerialLength {
+		return lu.serial[:vpdMaxSerialLength]
+	}
+	return lu.serial
+}
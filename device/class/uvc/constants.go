@@ -0,0 +1,459 @@
+package uvc
+
+// Video class codes.
+const (
+	ClassVideo = 0x0E // Video Class
+)
+
+// Video subclass codes.
+const (
+	SubclassUndefined                = 0x00 // Undefined
+	SubclassVideoControl             = 0x01 // VideoControl Interface
+	SubclassVideoStreaming           = 0x02 // VideoStreaming Interface
+	SubclassVideoInterfaceCollection = 0x03 // Video Interface Collection (IAD)
+)
+
+// Video protocol codes.
+const (
+	ProtocolUndefined = 0x00 // No protocol
+)
+
+// Video class-specific descriptor types.
+const (
+	DescriptorTypeCSInterface = 0x24 // Class-specific Interface
+	DescriptorTypeCSEndpoint  = 0x25 // Class-specific Endpoint
+)
+
+// VideoControl interface descriptor subtypes (UVC 1.1 Table A-5).
+const (
+	SubtypeVCHeader       = 0x01 // VC Interface Header
+	SubtypeInputTerminal  = 0x02 // Input Terminal
+	SubtypeOutputTerminal = 0x03 // Output Terminal
+	SubtypeSelectorUnit   = 0x04 // Selector Unit
+	SubtypeProcessingUnit = 0x05 // Processing Unit
+	SubtypeExtensionUnit  = 0x06 // Extension Unit
+	SubtypeEncodingUnit   = 0x07 // Encoding Unit (UVC 1.5)
+)
+
+// Class-specific endpoint descriptor subtypes (UVC 1.1 Table A-7).
+const (
+	SubtypeEndpointGeneral   = 0x01 // EP General
+	SubtypeEndpointEndpoint  = 0x02 // EP Endpoint
+	SubtypeEndpointInterrupt = 0x03 // EP Interrupt
+)
+
+// VideoStreaming interface descriptor subtypes (UVC 1.1 Table A-6).
+const (
+	SubtypeVSInputHeader        = 0x01 // Input Header
+	SubtypeVSOutputHeader       = 0x02 // Output Header
+	SubtypeVSStillImageFrame    = 0x03 // Still Image Frame
+	SubtypeVSFormatUncompressed = 0x04 // Uncompressed Video Format
+	SubtypeVSFrameUncompressed  = 0x05 // Uncompressed Video Frame
+	SubtypeVSFormatMJPEG        = 0x06 // MJPEG Video Format
+	SubtypeVSFrameMJPEG         = 0x07 // MJPEG Video Frame
+	SubtypeVSColorFormat        = 0x0D // Color Matching
+	SubtypeVSFormatFrameBased   = 0x10 // Frame-based Video Format
+	SubtypeVSFrameFrameBased    = 0x11 // Frame-based Video Frame
+	SubtypeVSFormatStreamBased  = 0x12 // Stream-based Video Format
+)
+
+// Terminal types (UVC 1.1 Appendix B).
+const (
+	TerminalUSBStreaming = 0x0101 // USB streaming
+	TerminalCamera       = 0x0201 // Camera sensor
+)
+
+// Video class request codes (UVC 1.1 Table A-8).
+const (
+	RequestSetCur  = 0x01
+	RequestGetCur  = 0x81
+	RequestGetMin  = 0x82
+	RequestGetMax  = 0x83
+	RequestGetRes  = 0x84
+	RequestGetLen  = 0x85
+	RequestGetInfo = 0x86
+	RequestGetDef  = 0x87
+)
+
+// VideoControl interface control selectors (UVC 1.1 Table A-10).
+const (
+	ControlVCVideoPowerMode   = 0x01
+	ControlVCRequestErrorCode = 0x02
+)
+
+// VideoStreaming interface control selectors (UVC 1.1 Table A-16).
+const (
+	ControlVSProbe              = 0x01
+	ControlVSCommit             = 0x02
+	ControlVSStillProbe         = 0x03
+	ControlVSStillCommit        = 0x04
+	ControlVSStillImageTrigger  = 0x05
+	ControlVSStreamErrorCode    = 0x06
+	ControlVSGenerateKeyFrame   = 0x07
+	ControlVSUpdateFrameSegment = 0x08
+	ControlVSSynchDelay         = 0x09
+)
+
+// GET_INFO capability bits.
+const (
+	InfoSupportsGet = 1 << 0
+	InfoSupportsSet = 1 << 1
+)
+
+// Request error codes (UVC 1.1 Table 4-7).
+const (
+	ErrorCodeNone           = 0x00 // No error
+	ErrorCodeNotReady       = 0x01 // Not ready
+	ErrorCodeWrongState     = 0x02 // Wrong state
+	ErrorCodePower          = 0x03 // Power
+	ErrorCodeOutOfRange     = 0x04 // Out of range
+	ErrorCodeInvalidUnit    = 0x05 // Invalid unit
+	ErrorCodeInvalidControl = 0x06 // Invalid control
+	ErrorCodeInvalidRequest = 0x07 // Invalid request
+	ErrorCodeUnknown        = 0xFF // Unknown
+)
+
+// Payload header bmHeaderInfo bits (UVC 1.1 Table 2-5).
+const (
+	HeaderFID = 1 << 0 // Frame identifier, toggles at each frame boundary
+	HeaderEOF = 1 << 1 // End of frame
+	HeaderPTS = 1 << 2 // Presentation time stamp present
+	HeaderSCR = 1 << 3 // Source clock reference present
+	HeaderSTI = 1 << 5 // Still image
+	HeaderERR = 1 << 6 // Payload error
+	HeaderEOH = 1 << 7 // End of header
+)
+
+// Probe and commit bmFramingInfo bits.
+const (
+	FramingFID = 1 << 0 // FID field is required in the payload header
+	FramingEOF = 1 << 1 // EOF field may be present in the payload header
+)
+
+// Format kinds supported by this driver.
+const (
+	FormatMJPEG = 1 // Motion JPEG
+	FormatYUY2  = 2 // Uncompressed YUY2 (4:2:2, 16 bits per pixel)
+)
+
+// GUIDYUY2 is the uncompressed format GUID for YUY2.
+var GUIDYUY2 = [16]byte{
+	'Y', 'U', 'Y', '2', 0x00, 0x00, 0x10, 0x00,
+	0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71,
+}
+
+// Terminal IDs used in the VideoControl topology (camera -> USB streaming).
+const (
+	IDCamera = 1
+	IDOutput = 2
+)
+
+// Defaults.
+const (
+	// UVCRelease is the Video Device Class release number (UVC 1.1).
+	UVCRelease = 0x0110
+
+	// ClockFrequency is the device clock frequency reported in the VC
+	// header and in probe and commit, in Hz.
+	ClockFrequency = 48000000
+
+	// PayloadHeaderSize is the size of the payload header sent with each
+	// payload. PTS and SCR are not sent.
+	PayloadHeaderSize = 2
+
+	// BulkPayloadSize is the maximum payload transfer size for bulk streams.
+	BulkPayloadSize = 16384
+
+	// BulkMaxPacketSize is the maximum packet size of the bulk endpoint.
+	BulkMaxPacketSize = 64
+
+	// IsoMaxPacketSize is the maximum packet size of the isochronous
+	// endpoint (the full-speed limit).
+	IsoMaxPacketSize = 1023
+)
+
+// Limits.
+const (
+	// MaxFormats is the maximum number of video formats.
+	MaxFormats = 2
+
+	// MaxFrames is the maximum number of frame sizes per format.
+	MaxFrames = 4
+
+	// MaxFrameIntervals is the maximum number of discrete frame intervals
+	// per frame size.
+	MaxFrameIntervals = 4
+
+	// MaxPacketsPerTransfer is the maximum number of isochronous packets
+	// sent per transfer.
+	MaxPacketsPerTransfer = 32
+
+	// MaxControlDescriptorSize is the maximum size of the VideoControl
+	// class-specific descriptors.
+	MaxControlDescriptorSize = 64
+
+	// MaxStreamingDescriptorSize is the maximum size of the VideoStreaming
+	// class-specific descriptors.
+	MaxStreamingDescriptorSize = 1024
+)
+
+// Descriptor sizes.
+const (
+	VCHeaderSize            = 13 // VC header with one streaming interface
+	CameraTerminalSize      = 18 // Camera terminal with 3-byte bmControls
+	OutputTerminalSize      = 9
+	MJPEGFormatSize         = 11
+	UncompressedFormatSize  = 27
+	ColorMatchingSize       = 6
+	frameBaseSize           = 26
+	inputHeaderBaseSize     = 13
+	bytesPerPixelYUY2       = 2
+	frameIntervalsPerSecond = 10000000 // Frame intervals are in 100 ns units
+)
+
+// VCHeaderDescriptor is the class-specific VideoControl interface header.
+type VCHeaderDescriptor struct {
+	UVCRelease     uint16 // Video Device Class release number
+	TotalLength    uint16 // Total length of the VC class-specific descriptors
+	ClockFrequency uint32 // Device clock frequency in Hz
+	StreamingIface uint8  // VideoStreaming interface number
+}
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *VCHeaderDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < VCHeaderSize {
+		return 0
+	}
+	buf[0] = VCHeaderSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeVCHeader
+	putUint16(buf[3:], d.UVCRelease)
+	putUint16(buf[5:], d.TotalLength)
+	putUint32(buf[7:], d.ClockFrequency)
+	buf[11] = 1 // bInCollection
+	buf[12] = d.StreamingIface
+	return VCHeaderSize
+}
+
+// CameraTerminalDescriptor describes the camera input terminal.
+type CameraTerminalDescriptor struct {
+	TerminalID uint8  // Unique terminal ID
+	Terminal   uint8  // String index of the terminal name
+	Controls   uint32 // bmControls (24 bits)
+}
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *CameraTerminalDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < CameraTerminalSize {
+		return 0
+	}
+	buf[0] = CameraTerminalSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeInputTerminal
+	buf[3] = d.TerminalID
+	putUint16(buf[4:], TerminalCamera)
+	buf[6] = 0 // bAssocTerminal
+	buf[7] = d.Terminal
+	putUint16(buf[8:], 0)  // wObjectiveFocalLengthMin
+	putUint16(buf[10:], 0) // wObjectiveFocalLengthMax
+	putUint16(buf[12:], 0) // wOcularFocalLength
+	buf[14] = 3            // bControlSize
+	buf[15] = byte(d.Controls)
+	buf[16] = byte(d.Controls >> 8)
+	buf[17] = byte(d.Controls >> 16)
+	return CameraTerminalSize
+}
+
+// OutputTerminalDescriptor describes an output terminal.
+type OutputTerminalDescriptor struct {
+	TerminalID   uint8  // Unique terminal ID
+	TerminalType uint16 // Terminal type (TerminalUSBStreaming)
+	SourceID     uint8  // ID of the unit or terminal connected to this terminal
+	Terminal     uint8  // String index of the terminal name
+}
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *OutputTerminalDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < OutputTerminalSize {
+		return 0
+	}
+	buf[0] = OutputTerminalSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeOutputTerminal
+	buf[3] = d.TerminalID
+	putUint16(buf[4:], d.TerminalType)
+	buf[6] = 0 // bAssocTerminal
+	buf[7] = d.SourceID
+	buf[8] = d.Terminal
+	return OutputTerminalSize
+}
+
+// InputHeaderDescriptor is the VideoStreaming input header.
+type InputHeaderDescriptor struct {
+	NumFormats      uint8  // Number of format descriptors
+	TotalLength     uint16 // Total length of the VS class-specific descriptors
+	EndpointAddress uint8  // Video data endpoint address
+	TerminalLink    uint8  // ID of the output terminal connected to the endpoint
+}
+
+// Size returns the size of the input header descriptor in bytes.
+func (d *InputHeaderDescriptor) Size() int {
+	return inputHeaderBaseSize + int(d.NumFormats)
+}
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *InputHeaderDescriptor) MarshalTo(buf []byte) int {
+	size := d.Size()
+	if len(buf) < size {
+		return 0
+	}
+	buf[0] = byte(size)
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeVSInputHeader
+	buf[3] = d.NumFormats
+	putUint16(buf[4:], d.TotalLength)
+	buf[6] = d.EndpointAddress
+	buf[7] = 0 // bmInfo: no dynamic format change
+	buf[8] = d.TerminalLink
+	buf[9] = 0  // bStillCaptureMethod: none
+	buf[10] = 0 // bTriggerSupport
+	buf[11] = 0 // bTriggerUsage
+	buf[12] = 1 // bControlSize
+	for i := 0; i < int(d.NumFormats); i++ {
+		buf[13+i] = 0 // bmaControls: none
+	}
+	return size
+}
+
+// ColorMatchingDescriptor describes the color space of the video data.
+// The zero value describes sRGB primaries with BT.601 matrix coefficients.
+type ColorMatchingDescriptor struct {
+	ColorPrimaries          uint8 // 1 = BT.709, sRGB
+	TransferCharacteristics uint8 // 1 = BT.709
+	MatrixCoefficients      uint8 // 4 = SMPTE 170M (BT.601)
+}
+
+// MarshalTo writes the descriptor to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (d *ColorMatchingDescriptor) MarshalTo(buf []byte) int {
+	if len(buf) < ColorMatchingSize {
+		return 0
+	}
+	buf[0] = ColorMatchingSize
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = SubtypeVSColorFormat
+	buf[3] = d.ColorPrimaries
+	buf[4] = d.TransferCharacteristics
+	buf[5] = d.MatrixCoefficients
+	return ColorMatchingSize
+}
+
+// ProbeCommit is the video probe and commit control structure
+// (UVC 1.1 Table 4-47), used to negotiate the stream parameters.
+type ProbeCommit struct {
+	Hint                   uint16 // bmHint
+	FormatIndex            uint8  // bFormatIndex (1-based)
+	FrameIndex             uint8  // bFrameIndex (1-based)
+	FrameInterval          uint32 // dwFrameInterval, in 100 ns units
+	KeyFrameRate           uint16 // wKeyFrameRate
+	PFrameRate             uint16 // wPFrameRate
+	CompQuality            uint16 // wCompQuality
+	CompWindowSize         uint16 // wCompWindowSize
+	Delay                  uint16 // wDelay, in ms
+	MaxVideoFrameSize      uint32 // dwMaxVideoFrameSize
+	MaxPayloadTransferSize uint32 // dwMaxPayloadTransferSize
+	ClockFrequency         uint32 // dwClockFrequency (UVC 1.1)
+	FramingInfo            uint8  // bmFramingInfo (UVC 1.1)
+	PreferedVersion        uint8  // bPreferedVersion (UVC 1.1)
+	MinVersion             uint8  // bMinVersion (UVC 1.1)
+	MaxVersion             uint8  // bMaxVersion (UVC 1.1)
+}
+
+// ProbeCommitSize is the size of the UVC 1.1 probe and commit structure.
+const ProbeCommitSize = 34
+
+// ProbeCommitSize10 is the size of the UVC 1.0 probe and commit structure.
+const ProbeCommitSize10 = 26
+
+// MarshalTo writes the probe and commit structure to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (p *ProbeCommit) MarshalTo(buf []byte) int {
+	if len(buf) < ProbeCommitSize {
+		return 0
+	}
+	putUint16(buf[0:], p.Hint)
+	buf[2] = p.FormatIndex
+	buf[3] = p.FrameIndex
+	putUint32(buf[4:], p.FrameInterval)
+	putUint16(buf[8:], p.KeyFrameRate)
+	putUint16(buf[10:], p.PFrameRate)
+	putUint16(buf[12:], p.CompQuality)
+	putUint16(buf[14:], p.CompWindowSize)
+	putUint16(buf[16:], p.Delay)
+	putUint32(buf[18:], p.MaxVideoFrameSize)
+	putUint32(buf[22:], p.MaxPayloadTransferSize)
+	putUint32(buf[26:], p.ClockFrequency)
+	buf[30] = p.FramingInfo
+	buf[31] = p.PreferedVersion
+	buf[32] = p.MinVersion
+	buf[33] = p.MaxVersion
+	return ProbeCommitSize
+}
+
+// ParseProbeCommit parses a probe and commit structure from data.
+// Both the UVC 1.0 (26-byte) and UVC 1.1 (34-byte) layouts are accepted.
+// Returns false if data is too short.
+func ParseProbeCommit(data []byte, out *ProbeCommit) bool {
+	if len(data) < ProbeCommitSize10 {
+		return false
+	}
+	*out = ProbeCommit{
+		Hint:                   getUint16(data[0:]),
+		FormatIndex:            data[2],
+		FrameIndex:             data[3],
+		FrameInterval:          getUint32(data[4:]),
+		KeyFrameRate:           getUint16(data[8:]),
+		PFrameRate:             getUint16(data[10:]),
+		CompQuality:            getUint16(data[12:]),
+		CompWindowSize:         getUint16(data[14:]),
+		Delay:                  getUint16(data[16:]),
+		MaxVideoFrameSize:      getUint32(data[18:]),
+		MaxPayloadTransferSize: getUint32(data[22:]),
+	}
+	if len(data) >= ProbeCommitSize {
+		out.ClockFrequency = getUint32(data[26:])
+		out.FramingInfo = data[30]
+		out.PreferedVersion = data[31]
+		out.MinVersion = data[32]
+		out.MaxVersion = data[33]
+	}
+	return true
+}
+
+// putUint16 writes a little-endian uint16 to buf.
+func putUint16(buf []byte, v uint16) {
+	buf[0] = byte(v)
+	buf[1] = byte(v >> 8)
+}
+
+// putUint32 writes a little-endian uint32 to buf.
+func putUint32(buf []byte, v uint32) {
+	buf[0] = byte(v)
+	buf[1] = byte(v >> 8)
+	buf[2] = byte(v >> 16)
+	buf[3] = byte(v >> 24)
+}
+
+// getUint16 reads a little-endian uint16 from data.
+func getUint16(data []byte) uint16 {
+	return uint16(data[0]) | uint16(data[1])<<8
+}
+
+// getUint32 reads a little-endian uint32 from data.
+func getUint32(data []byte) uint32 {
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+}
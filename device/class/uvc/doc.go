@@ -0,0 +1,70 @@
+// Package uvc implements the USB Video Class 1.1 (UVC) for the softusb
+// device stack.
+//
+// This package provides a camera that streams MJPEG or uncompressed YUY2
+// video over a bulk or isochronous endpoint. Frames come from a
+// [FrameSource], so a device can serve a file, a generator, or fixed test
+// content.
+//
+// # Architecture
+//
+// A video function consists of an interface association followed by two
+// interfaces:
+//
+//   - VideoControl Interface: Class-specific header, camera input terminal
+//     (1), and USB streaming output terminal (2) fed by the camera
+//   - VideoStreaming Interface: Input header followed by each format with
+//     its frame descriptors and a color matching descriptor
+//
+// With a bulk endpoint, the endpoint is in alternate setting 0. With an
+// isochronous endpoint, alternate setting 0 has no endpoints (zero
+// bandwidth) and alternate setting 1 carries the endpoint.
+//
+// # Probe and Commit
+//
+// The host negotiates stream parameters with the VS_PROBE_CONTROL and
+// VS_COMMIT_CONTROL requests. SET_CUR proposes a format, frame size, and
+// frame interval; the device answers GET_CUR with the nearest parameters it
+// supports, including the maximum frame size and payload size. Both UVC 1.0
+// (26-byte) and UVC 1.1 (34-byte) structures are accepted. The stack
+// delivers these requests, with their data stage, to [UVC.HandleSetup].
+//
+// A bulk stream starts when the host commits and stops when the host clears
+// the endpoint halt feature, which the stack reports through
+// [device.Endpoint.SetOnClearHalt]. An isochronous stream starts when the host
+// selects alternate setting 1 and stops when it selects alternate setting 0.
+//
+// # Payloads
+//
+// Each frame is sent as a series of payloads, each beginning with a 2-byte
+// payload header. The frame identifier bit toggles at each frame and the
+// last payload of a frame sets the end of frame bit. Bulk payloads are up
+// to [BulkPayloadSize] bytes; isochronous payloads are one packet each.
+//
+// # Usage
+//
+// To create a webcam serving an MJPEG file:
+//
+//	u := uvc.New(
+//	    uvc.NewFormat(uvc.FormatMJPEG, uvc.NewFrame(640, 480, 30, 15)),
+//	    uvc.NewFormat(uvc.FormatYUY2, uvc.NewFrame(320, 240, 15)),
+//	)
+//
+//	builder := device.NewDeviceBuilder().
+//	    WithVendorProduct(0xCAFE, 0xBABE).
+//	    WithStrings("Manufacturer", "USB Camera", "12345").
+//	    AddConfiguration(1)
+//
+//	// VideoControl interface 0, bulk IN EP 0x81
+//	u.ConfigureDevice(builder, 0, 0x81, device.EndpointTypeBulk)
+//
+//	dev, _ := builder.Build(ctx)
+//	u.AttachToInterfaces(dev, 1, 0)
+//
+//	stack := device.NewStack(dev, hal)
+//	u.SetStack(stack)
+//	stack.Start(ctx)
+//
+//	// Send frames whenever the host is streaming
+//	u.Serve(ctx, uvc.NewJPEGSource(file))
+package uvc
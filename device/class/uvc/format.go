@@ -0,0 +1,218 @@
+package uvc
+
+// Frame describes a frame size and its supported frame intervals.
+type Frame struct {
+	Width        uint16                    // Width in pixels
+	Height       uint16                    // Height in pixels
+	Intervals    [MaxFrameIntervals]uint32 // Frame intervals in 100 ns units
+	NumIntervals int                       // Number of frame intervals
+}
+
+// NewFrame returns a frame size supporting the given frame rates in frames
+// per second. The first rate is the default. Rates beyond MaxFrameIntervals
+// and zero rates are ignored.
+func NewFrame(width, height uint16, fps ...uint32) Frame {
+	f := Frame{Width: width, Height: height}
+	for _, rate := range fps {
+		if f.NumIntervals >= MaxFrameIntervals {
+			break
+		}
+		if rate == 0 {
+			continue
+		}
+		f.Intervals[f.NumIntervals] = frameIntervalsPerSecond / rate
+		f.NumIntervals++
+	}
+	return f
+}
+
+// MaxFrameSize returns the largest size of a frame in bytes. Both supported
+// formats use at most 16 bits per pixel.
+func (f *Frame) MaxFrameSize() uint32 {
+	return uint32(f.Width) * uint32(f.Height) * bytesPerPixelYUY2
+}
+
+// nearestInterval returns the supported frame interval closest to interval.
+func (f *Frame) nearestInterval(interval uint32) uint32 {
+	if f.NumIntervals == 0 {
+		return 0
+	}
+	best := f.Intervals[0]
+	bestDiff := diff(best, interval)
+	for i := 1; i < f.NumIntervals; i++ {
+		if d := diff(f.Intervals[i], interval); d < bestDiff {
+			best, bestDiff = f.Intervals[i], d
+		}
+	}
+	return best
+}
+
+// intervalRange returns the shortest and longest supported frame intervals.
+func (f *Frame) intervalRange() (min, max uint32) {
+	for i := 0; i < f.NumIntervals; i++ {
+		if min == 0 || f.Intervals[i] < min {
+			min = f.Intervals[i]
+		}
+		if f.Intervals[i] > max {
+			max = f.Intervals[i]
+		}
+	}
+	return min, max
+}
+
+// descriptorSize returns the size of the frame descriptor in bytes.
+func (f *Frame) descriptorSize() int {
+	return frameBaseSize + 4*f.NumIntervals
+}
+
+// marshalTo writes the frame descriptor with the given subtype and
+// 1-based frame index to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (f *Frame) marshalTo(buf []byte, subtype, index uint8) int {
+	size := f.descriptorSize()
+	if len(buf) < size || f.NumIntervals > MaxFrameIntervals {
+		return 0
+	}
+	frameSize := f.MaxFrameSize()
+	minInterval, maxInterval := f.intervalRange()
+
+	buf[0] = byte(size)
+	buf[1] = DescriptorTypeCSInterface
+	buf[2] = subtype
+	buf[3] = index
+	buf[4] = 0 // bmCapabilities: no still image, fixed frame rate not required
+	putUint16(buf[5:], f.Width)
+	putUint16(buf[7:], f.Height)
+	putUint32(buf[9:], bitRate(frameSize, maxInterval))
+	putUint32(buf[13:], bitRate(frameSize, minInterval))
+	putUint32(buf[17:], frameSize)
+	putUint32(buf[21:], f.Intervals[0])
+	buf[25] = uint8(f.NumIntervals)
+	for i := 0; i < f.NumIntervals; i++ {
+		putUint32(buf[26+4*i:], f.Intervals[i])
+	}
+	return size
+}
+
+// Format describes a video format and its frame sizes.
+type Format struct {
+	Kind      uint8            // FormatMJPEG or FormatYUY2
+	Frames    [MaxFrames]Frame // Frame sizes
+	NumFrames int              // Number of frame sizes
+}
+
+// NewFormat returns a format of the given kind with the given frame sizes.
+// The first frame size is the default. Frames beyond MaxFrames are ignored.
+func NewFormat(kind uint8, frames ...Frame) Format {
+	f := Format{Kind: kind}
+	for _, frame := range frames {
+		if f.NumFrames >= MaxFrames {
+			break
+		}
+		f.Frames[f.NumFrames] = frame
+		f.NumFrames++
+	}
+	return f
+}
+
+// Frame returns the frame size with the given 1-based index, or nil if the
+// index is out of range.
+func (f *Format) Frame(index uint8) *Frame {
+	if index == 0 || int(index) > f.NumFrames {
+		return nil
+	}
+	return &f.Frames[index-1]
+}
+
+// descriptorSize returns the size of the format descriptor together with
+// its frame descriptors and color matching descriptor.
+func (f *Format) descriptorSize() int {
+	size := MJPEGFormatSize
+	if f.Kind == FormatYUY2 {
+		size = UncompressedFormatSize
+	}
+	for i := 0; i < f.NumFrames; i++ {
+		size += f.Frames[i].descriptorSize()
+	}
+	return size + ColorMatchingSize
+}
+
+// MarshalTo writes the format descriptor with the given 1-based format
+// index, followed by its frame descriptors and a color matching descriptor.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (f *Format) MarshalTo(buf []byte, index uint8) int {
+	if len(buf) < f.descriptorSize() || f.NumFrames > MaxFrames {
+		return 0
+	}
+
+	var offset int
+	var frameSubtype uint8
+	switch f.Kind {
+	case FormatMJPEG:
+		buf[0] = MJPEGFormatSize
+		buf[1] = DescriptorTypeCSInterface
+		buf[2] = SubtypeVSFormatMJPEG
+		buf[3] = index
+		buf[4] = uint8(f.NumFrames)
+		buf[5] = 0x01 // bmFlags: fixed size samples
+		buf[6] = 1    // bDefaultFrameIndex
+		buf[7] = 0    // bAspectRatioX
+		buf[8] = 0    // bAspectRatioY
+		buf[9] = 0    // bmInterlaceFlags
+		buf[10] = 0   // bCopyProtect
+		offset = MJPEGFormatSize
+		frameSubtype = SubtypeVSFrameMJPEG
+
+	case FormatYUY2:
+		buf[0] = UncompressedFormatSize
+		buf[1] = DescriptorTypeCSInterface
+		buf[2] = SubtypeVSFormatUncompressed
+		buf[3] = index
+		buf[4] = uint8(f.NumFrames)
+		copy(buf[5:21], GUIDYUY2[:])
+		buf[21] = bytesPerPixelYUY2 * 8 // bBitsPerPixel
+		buf[22] = 1                     // bDefaultFrameIndex
+		buf[23] = 0                     // bAspectRatioX
+		buf[24] = 0                     // bAspectRatioY
+		buf[25] = 0                     // bmInterlaceFlags
+		buf[26] = 0                     // bCopyProtect
+		offset = UncompressedFormatSize
+		frameSubtype = SubtypeVSFrameUncompressed
+
+	default:
+		return 0
+	}
+
+	for i := 0; i < f.NumFrames; i++ {
+		n := f.Frames[i].marshalTo(buf[offset:], frameSubtype, uint8(i+1))
+		if n == 0 {
+			return 0
+		}
+		offset += n
+	}
+
+	color := ColorMatchingDescriptor{
+		ColorPrimaries:          1,
+		TransferCharacteristics: 1,
+		MatrixCoefficients:      4,
+	}
+	offset += color.MarshalTo(buf[offset:])
+	return offset
+}
+
+// bitRate returns the bit rate in bits per second of frames of the given
+// size sent at the given frame interval.
+func bitRate(frameSize, interval uint32) uint32 {
+	if interval == 0 {
+		return 0
+	}
+	return uint32(uint64(frameSize) * 8 * frameIntervalsPerSecond / uint64(interval))
+}
+
+// diff returns the absolute difference of a and b.
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,180 @@
+package uvc
+
+import (
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// isVideoRequest returns true for class requests directed at the
+// VideoControl or VideoStreaming interface.
+func (u *UVC) isVideoRequest(setup *device.SetupPacket) bool {
+	if !setup.IsClass() || !setup.IsInterfaceRecipient() {
+		return false
+	}
+
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+
+	if !u.configured {
+		return false
+	}
+	iface := setup.InterfaceNumber()
+	return iface == u.controlIfaceNum || iface == u.streamIfaceNum
+}
+
+// handleRequest processes a video class request. data holds the OUT data
+// stage. For IN requests, the response is written to responseBuf and its
+// length returned. The request error code is updated for every request.
+func (u *UVC) handleRequest(setup *device.SetupPacket, data []byte) (int, error) {
+	u.mutex.Lock()
+
+	var n int
+	var code uint8
+	var commit *ProbeCommit
+	if setup.InterfaceNumber() == u.controlIfaceNum {
+		n, code = u.handleControlLocked(setup)
+	} else {
+		n, code, commit = u.handleStreamingLocked(setup, data)
+	}
+
+	// Successful VideoControl requests only read the error code, so they
+	// leave it unchanged
+	if !(setup.InterfaceNumber() == u.controlIfaceNum && code == ErrorCodeNone) {
+		u.errorCode = code
+	}
+
+	var cb func(ProbeCommit)
+	var streamCb func(bool)
+	changed := false
+	if commit != nil {
+		cb = u.onCommit
+		if u.epType == device.EndpointTypeBulk {
+			changed = u.setStreamingLocked(true)
+			streamCb = u.onStreamingChange
+		}
+	}
+	u.mutex.Unlock()
+
+	if code != ErrorCodeNone {
+		return 0, pkg.ErrInvalidRequest
+	}
+
+	if commit != nil {
+		pkg.LogDebug(pkg.ComponentDevice, "UVC commit",
+			"format", commit.FormatIndex,
+			"frame", commit.FrameIndex,
+			"interval", commit.FrameInterval)
+		if cb != nil {
+			cb(*commit)
+		}
+		if changed && streamCb != nil {
+			streamCb(true)
+		}
+	}
+
+	return n, nil
+}
+
+// handleControlLocked handles a VideoControl interface request. Only the
+// request error code control is supported (caller must hold u.mutex).
+// Returns the response length and the request error code.
+func (u *UVC) handleControlLocked(setup *device.SetupPacket) (int, uint8) {
+	entity := uint8(setup.Index >> 8)
+	selector := uint8(setup.Value >> 8)
+
+	if entity != 0 {
+		return 0, ErrorCodeInvalidControl
+	}
+	if selector != ControlVCRequestErrorCode {
+		return 0, ErrorCodeInvalidControl
+	}
+
+	switch setup.Request {
+	case RequestGetCur:
+		u.responseBuf[0] = u.errorCode
+		return 1, ErrorCodeNone
+	case RequestGetInfo:
+		u.responseBuf[0] = InfoSupportsGet
+		return 1, ErrorCodeNone
+	default:
+		return 0, ErrorCodeInvalidRequest
+	}
+}
+
+// handleStreamingLocked handles a probe or commit request on the
+// VideoStreaming interface (caller must hold u.mutex).
+// Returns the response length, the request error code, and the committed
+// parameters if this request committed them.
+func (u *UVC) handleStreamingLocked(setup *device.SetupPacket, data []byte) (int, uint8, *ProbeCommit) {
+	selector := uint8(setup.Value >> 8)
+	if selector != ControlVSProbe && selector != ControlVSCommit {
+		return 0, ErrorCodeInvalidControl, nil
+	}
+	if u.numFormats == 0 {
+		return 0, ErrorCodeNotReady, nil
+	}
+
+	var pc ProbeCommit
+	switch setup.Request {
+	case RequestSetCur:
+		var in ProbeCommit
+		if !ParseProbeCommit(data, &in) {
+			return 0, ErrorCodeOutOfRange, nil
+		}
+		if int(in.FormatIndex) > u.numFormats {
+			return 0, ErrorCodeOutOfRange, nil
+		}
+		neg := u.negotiateLocked(&in)
+		if selector == ControlVSProbe {
+			u.probe = neg
+			return 0, ErrorCodeNone, nil
+		}
+		u.commit = neg
+		u.committed = true
+		return 0, ErrorCodeNone, &neg
+
+	case RequestGetCur:
+		pc = u.probe
+		if selector == ControlVSCommit {
+			pc = u.commit
+		}
+
+	case RequestGetMin, RequestGetMax:
+		if selector != ControlVSProbe {
+			return 0, ErrorCodeInvalidRequest, nil
+		}
+		pc = u.probe
+		if frame := u.formats[pc.FormatIndex-1].Frame(pc.FrameIndex); frame != nil {
+			minInterval, maxInterval := frame.intervalRange()
+			pc.FrameInterval = minInterval
+			if setup.Request == RequestGetMax {
+				pc.FrameInterval = maxInterval
+			}
+		}
+
+	case RequestGetDef:
+		if selector != ControlVSProbe {
+			return 0, ErrorCodeInvalidRequest, nil
+		}
+		pc = u.defaultLocked()
+
+	case RequestGetRes:
+		if selector != ControlVSProbe {
+			return 0, ErrorCodeInvalidRequest, nil
+		}
+		// pc is zero: no field has a resolution
+
+	case RequestGetLen:
+		putUint16(u.responseBuf[:], ProbeCommitSize)
+		return 2, ErrorCodeNone, nil
+
+	case RequestGetInfo:
+		u.responseBuf[0] = InfoSupportsGet | InfoSupportsSet
+		return 1, ErrorCodeNone, nil
+
+	default:
+		return 0, ErrorCodeInvalidRequest, nil
+	}
+
+	return pc.MarshalTo(u.responseBuf[:]), ErrorCodeNone, nil
+}
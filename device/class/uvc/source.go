@@ -0,0 +1,110 @@
+package uvc
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// FrameSource supplies video frames to [UVC.Serve].
+type FrameSource interface {
+	// ReadFrame reads the next complete frame into buf and returns its
+	// length. It returns io.EOF when no frames remain.
+	ReadFrame(buf []byte) (int, error)
+}
+
+// FrameSourceFunc adapts a function to a FrameSource.
+type FrameSourceFunc func(buf []byte) (int, error)
+
+// ReadFrame calls f(buf).
+func (f FrameSourceFunc) ReadFrame(buf []byte) (int, error) {
+	return f(buf)
+}
+
+// readerSource reads fixed-size frames from an io.Reader.
+type readerSource struct {
+	r         io.Reader
+	frameSize int
+}
+
+// NewReaderSource returns a FrameSource that reads frames of frameSize bytes
+// from r, such as raw YUY2 video. A trailing partial frame is discarded.
+func NewReaderSource(r io.Reader, frameSize int) FrameSource {
+	return &readerSource{r: r, frameSize: frameSize}
+}
+
+// ReadFrame reads the next frame.
+func (s *readerSource) ReadFrame(buf []byte) (int, error) {
+	if len(buf) < s.frameSize {
+		return 0, pkg.ErrBufferTooSmall
+	}
+	n, err := io.ReadFull(s.r, buf[:s.frameSize])
+	if err == io.ErrUnexpectedEOF {
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+// jpegSource splits a stream of concatenated JPEG images into frames.
+type jpegSource struct {
+	r *bufio.Reader
+}
+
+// JPEG markers.
+const (
+	jpegMarker = 0xFF
+	jpegSOI    = 0xD8 // Start of image
+	jpegEOI    = 0xD9 // End of image
+)
+
+// NewJPEGSource returns a FrameSource that reads concatenated JPEG images
+// from r, such as an MJPEG file, one image per frame. Images are delimited
+// by their start and end of image markers, so they must not contain
+// embedded thumbnails.
+func NewJPEGSource(r io.Reader) FrameSource {
+	return &jpegSource{r: bufio.NewReader(r)}
+}
+
+// ReadFrame reads the next JPEG image.
+func (s *jpegSource) ReadFrame(buf []byte) (int, error) {
+	// Skip to the start of image marker
+	var prev byte
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if prev == jpegMarker && b == jpegSOI {
+			break
+		}
+		prev = b
+	}
+
+	if len(buf) < 2 {
+		return 0, pkg.ErrBufferTooSmall
+	}
+	buf[0], buf[1] = jpegMarker, jpegSOI
+	n := 2
+
+	// Copy through the end of image marker
+	prev = 0
+	for {
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			return 0, io.EOF // Truncated image
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n >= len(buf) {
+			return 0, pkg.ErrBufferTooSmall
+		}
+		buf[n] = b
+		n++
+		if prev == jpegMarker && b == jpegEOI {
+			return n, nil
+		}
+		prev = b
+	}
+}
@@ -0,0 +1,634 @@
+package uvc
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// UVC implements a USB Video Class 1.1 class driver for a camera that
+// streams MJPEG or uncompressed YUY2 video over a bulk or isochronous
+// endpoint.
+type UVC struct {
+	// Interfaces
+	controlIface    *device.Interface
+	streamIface     *device.Interface
+	controlIfaceNum uint8
+	streamIfaceNum  uint8
+
+	// Video data endpoint
+	ep     *device.Endpoint
+	epAddr uint8
+	epType uint8
+
+	// Stack reference for data transfer
+	stack *device.Stack
+
+	// Formats (1-indexed by bFormatIndex, stored 0-indexed)
+	formats    [MaxFormats]Format
+	numFormats int
+
+	// Negotiation state
+	probe     ProbeCommit
+	commit    ProbeCommit
+	committed bool
+	errorCode uint8
+
+	// Streaming state
+	streaming   bool
+	fid         uint8
+	stateChange chan struct{}
+
+	// Callbacks
+	onStreamingChange func(active bool)
+	onCommit          func(commit ProbeCommit)
+
+	// Isochronous transfer, reused by each frame
+	xfer      *device.Transfer
+	done      chan struct{}
+	sizes     [MaxPacketsPerTransfer]int
+	xferMutex sync.Mutex
+
+	// Buffers
+	controlDesc [MaxControlDescriptorSize]byte
+	streamDesc  [MaxStreamingDescriptorSize]byte
+	responseBuf [ProbeCommitSize]byte
+	payloadBuf  []byte // Sized for the transport in Init
+	frameBuf    []byte // Sized for the largest frame by Serve
+
+	// State
+	mutex      sync.RWMutex
+	configured bool
+}
+
+// New creates a new video class driver with the given formats.
+// The first format and its first frame size and interval are the defaults.
+// Formats beyond MaxFormats are ignored.
+func New(formats ...Format) *UVC {
+	u := &UVC{
+		stateChange: make(chan struct{}, 1),
+	}
+	for _, f := range formats {
+		if u.numFormats >= MaxFormats {
+			break
+		}
+		u.formats[u.numFormats] = f
+		u.numFormats++
+	}
+	u.probe = u.defaultLocked()
+	return u
+}
+
+// SetStack sets the device stack reference for data transfer.
+func (u *UVC) SetStack(stack *device.Stack) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.stack = stack
+}
+
+// SetOnStreamingChange sets the callback invoked when streaming starts or
+// stops.
+func (u *UVC) SetOnStreamingChange(cb func(active bool)) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.onStreamingChange = cb
+}
+
+// SetOnCommit sets the callback invoked when the host commits stream
+// parameters.
+func (u *UVC) SetOnCommit(cb func(commit ProbeCommit)) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.onCommit = cb
+}
+
+// Streaming returns true if the host has started the video stream.
+func (u *UVC) Streaming() bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.streaming
+}
+
+// Commit returns the committed stream parameters and true, or false if the
+// host has not committed parameters.
+func (u *UVC) Commit() (ProbeCommit, bool) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.commit, u.committed
+}
+
+// Format returns the format with the given 1-based index, or nil if the
+// index is out of range.
+func (u *UVC) Format(index uint8) *Format {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	if index == 0 || int(index) > u.numFormats {
+		return nil
+	}
+	return &u.formats[index-1]
+}
+
+// Init initializes the class driver for the given interface.
+// This is called by the device stack when the class driver is attached.
+func (u *UVC) Init(iface *device.Interface) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	switch iface.SubClass {
+	case SubclassVideoControl:
+		u.controlIface = iface
+
+	case SubclassVideoStreaming:
+		// Bulk endpoints live in alternate setting 0, isochronous
+		// endpoints in the streaming alternate setting
+		alt := iface
+		if u.epType == device.EndpointTypeIsochronous {
+			alt = iface.Alternate(1)
+			if alt == nil {
+				return pkg.ErrInvalidEndpoint
+			}
+		}
+		u.ep = alt.GetEndpoint(u.epAddr)
+		if u.ep == nil {
+			return pkg.ErrInvalidEndpoint
+		}
+		u.streamIface = iface
+
+		if u.epType == device.EndpointTypeIsochronous {
+			if u.payloadBuf == nil {
+				u.payloadBuf = make([]byte, MaxPacketsPerTransfer*int(u.ep.MaxPacketSize))
+			}
+			u.done = make(chan struct{}, 1)
+			u.xfer = device.NewIsochronousTransfer(u.ep, nil, 0)
+			u.xfer.WithCallback(func(*device.Transfer) {
+				u.done <- struct{}{}
+			})
+		} else {
+			if u.payloadBuf == nil {
+				u.payloadBuf = make([]byte, BulkPayloadSize)
+			}
+			// Hosts end bulk streams by clearing the endpoint halt
+			u.ep.SetOnClearHalt(u.stopBulk)
+		}
+	}
+
+	if u.controlIface != nil && u.streamIface != nil {
+		u.configured = true
+		pkg.LogDebug(pkg.ComponentDevice, "UVC configured",
+			"endpoint", u.ep.Address,
+			"formats", u.numFormats)
+	}
+
+	return nil
+}
+
+// HandleSetup processes class-specific SETUP requests: probe and commit on
+// the VideoStreaming interface, and request error code on the VideoControl
+// interface.
+func (u *UVC) HandleSetup(iface *device.Interface, setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+	if !u.isVideoRequest(setup) {
+		return nil, false, nil
+	}
+
+	n, err := u.handleRequest(setup, data)
+	if err != nil {
+		return nil, true, err
+	}
+	return u.responseBuf[:n], true, nil
+}
+
+// SetAlternate handles alternate setting changes. For isochronous streams,
+// alternate setting 1 starts streaming and setting 0 stops it. For bulk
+// streams, selecting setting 0 stops streaming.
+func (u *UVC) SetAlternate(iface *device.Interface, alt uint8) error {
+	u.mutex.Lock()
+	if iface.Number != u.streamIfaceNum || iface.SubClass != SubclassVideoStreaming {
+		u.mutex.Unlock()
+		return nil
+	}
+	active := alt != 0 && u.epType == device.EndpointTypeIsochronous
+	if !active {
+		u.committed = false
+	}
+	changed := u.setStreamingLocked(active)
+	cb := u.onStreamingChange
+	u.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentDevice, "UVC alternate setting",
+		"interface", iface.Number,
+		"alt", alt)
+
+	if changed && cb != nil {
+		cb(active)
+	}
+	return nil
+}
+
+// Close releases resources held by the class driver.
+func (u *UVC) Close() error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.ep != nil {
+		u.ep.SetOnClearHalt(nil)
+	}
+	u.controlIface = nil
+	u.streamIface = nil
+	u.ep = nil
+	u.stack = nil
+	u.streaming = false
+	u.committed = false
+	u.configured = false
+
+	return nil
+}
+
+// WriteFrame sends one video frame to the host (blocking). The frame is
+// divided into payloads of the committed maximum payload transfer size,
+// each with a payload header; the last payload carries the end of frame
+// bit. Returns pkg.ErrInvalidState if the stream is not running.
+func (u *UVC) WriteFrame(ctx context.Context, frame []byte) error {
+	u.xferMutex.Lock()
+	defer u.xferMutex.Unlock()
+
+	u.mutex.RLock()
+	stack := u.stack
+	ep := u.ep
+	configured := u.configured
+	streaming := u.streaming
+	fid := u.fid
+	payloadSize := int(u.commit.MaxPayloadTransferSize)
+	u.mutex.RUnlock()
+
+	if !configured || stack == nil || ep == nil {
+		return pkg.ErrNotConfigured
+	}
+	if !streaming {
+		return pkg.ErrInvalidState
+	}
+	if payloadSize <= PayloadHeaderSize || payloadSize > len(u.payloadBuf) {
+		payloadSize = len(u.payloadBuf)
+	}
+
+	var err error
+	if ep.IsIsochronous() {
+		if payloadSize > int(ep.MaxPacketSize) {
+			payloadSize = int(ep.MaxPacketSize)
+		}
+		err = u.writeIsochronous(ctx, stack, ep, frame, fid, payloadSize)
+	} else {
+		err = u.writeBulk(ctx, stack, ep, frame, fid, payloadSize)
+	}
+	if err != nil {
+		return err
+	}
+
+	u.mutex.Lock()
+	u.fid ^= HeaderFID
+	u.mutex.Unlock()
+	return nil
+}
+
+// Serve reads frames from src and sends them to the host whenever the
+// stream is running, until src returns io.EOF or ctx is cancelled.
+// Frames are sent as fast as the host accepts them.
+// Returns nil when src is exhausted.
+func (u *UVC) Serve(ctx context.Context, src FrameSource) error {
+	u.mutex.Lock()
+	if u.frameBuf == nil {
+		u.frameBuf = make([]byte, u.maxFrameSizeLocked())
+	}
+	buf := u.frameBuf
+	u.mutex.Unlock()
+
+	for {
+		for !u.Streaming() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-u.stateChange:
+			}
+		}
+
+		n, err := src.ReadFrame(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := u.WriteFrame(ctx, buf[:n]); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == pkg.ErrInvalidState {
+				continue // Stream stopped mid-frame
+			}
+			return err
+		}
+	}
+}
+
+// ConfigureDevice adds an interface association, the VideoControl
+// interface, and the VideoStreaming interface to a device builder.
+// Call this after AddConfiguration.
+//
+// controlIfaceNum is the number the builder assigns to the VideoControl
+// interface (the number of interfaces already in the configuration); the
+// VideoStreaming interface follows it. epType selects the transport:
+// device.EndpointTypeBulk or device.EndpointTypeIsochronous.
+func (u *UVC) ConfigureDevice(builder *device.DeviceBuilder, controlIfaceNum, epAddr, epType uint8) *device.DeviceBuilder {
+	u.mutex.Lock()
+	u.controlIfaceNum = controlIfaceNum
+	u.streamIfaceNum = controlIfaceNum + 1
+	u.epAddr = epAddr | device.EndpointDirectionIn
+	u.epType = epType
+	u.probe = u.defaultLocked()
+	vc := u.marshalControlLocked(u.controlDesc[:])
+	vs := u.marshalStreamingLocked(u.streamDesc[:])
+	u.mutex.Unlock()
+
+	builder.AddInterfaceAssociation(2, ClassVideo, SubclassVideoInterfaceCollection, ProtocolUndefined)
+
+	// VideoControl interface with the camera -> USB streaming topology
+	builder.AddInterface(ClassVideo, SubclassVideoControl, ProtocolUndefined)
+	builder.AddClassDescriptor(u.controlDesc[:vc])
+
+	// VideoStreaming interface with the formats and frame sizes
+	builder.AddInterface(ClassVideo, SubclassVideoStreaming, ProtocolUndefined)
+	builder.AddClassDescriptor(u.streamDesc[:vs])
+
+	if epType == device.EndpointTypeIsochronous {
+		// Alternate setting 0 has no endpoints; setting 1 streams
+		builder.AddAlternateSetting()
+		builder.AddEndpointDescriptor(&device.EndpointDescriptor{
+			Length:          device.EndpointDescriptorSize,
+			DescriptorType:  device.DescriptorTypeEndpoint,
+			EndpointAddress: u.epAddr,
+			Attributes:      device.EndpointTypeIsochronous | device.IsoSyncAsync,
+			MaxPacketSize:   IsoMaxPacketSize,
+			Interval:        1,
+		})
+	} else {
+		builder.AddEndpoint(u.epAddr, device.EndpointTypeBulk, BulkMaxPacketSize)
+	}
+
+	return builder
+}
+
+// AttachToInterfaces attaches this class driver to the video interfaces and
+// sets the device class to use interface association descriptors.
+// configValue is the configuration value (e.g., 1), controlIfaceNum is the
+// VideoControl interface number given to ConfigureDevice.
+func (u *UVC) AttachToInterfaces(dev *device.Device, configValue, controlIfaceNum uint8) error {
+	config := dev.GetConfiguration(configValue)
+	if config == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	controlIface := config.GetInterface(controlIfaceNum)
+	if controlIface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	streamIface := config.GetInterface(controlIfaceNum + 1)
+	if streamIface == nil {
+		return pkg.ErrInvalidRequest
+	}
+
+	dev.Descriptor.DeviceClass = device.ClassMisc
+	dev.Descriptor.DeviceSubClass = 0x02 // Common Class
+	dev.Descriptor.DeviceProtocol = 0x01 // Interface Association Descriptor
+
+	if err := controlIface.SetClassDriver(u); err != nil {
+		return err
+	}
+	return streamIface.SetClassDriver(u)
+}
+
+// writeBulk sends a frame as bulk payloads.
+func (u *UVC) writeBulk(ctx context.Context, stack *device.Stack, ep *device.Endpoint, frame []byte, fid uint8, payloadSize int) error {
+	offset := 0
+	for {
+		n := putPayload(u.payloadBuf[:payloadSize], frame[offset:], fid)
+		offset += n - PayloadHeaderSize
+		if _, err := stack.Write(ctx, ep, u.payloadBuf[:n]); err != nil {
+			return err
+		}
+		if offset >= len(frame) {
+			return nil
+		}
+	}
+}
+
+// writeIsochronous sends a frame as isochronous packets, one payload per
+// packet, in transfers of up to MaxPacketsPerTransfer packets.
+func (u *UVC) writeIsochronous(ctx context.Context, stack *device.Stack, ep *device.Endpoint, frame []byte, fid uint8, payloadSize int) error {
+	offset := 0
+	for {
+		numPackets := 0
+		used := 0
+		for numPackets < MaxPacketsPerTransfer {
+			n := putPayload(u.payloadBuf[used:used+payloadSize], frame[offset:], fid)
+			offset += n - PayloadHeaderSize
+			u.sizes[numPackets] = n
+			used += n
+			numPackets++
+			if offset >= len(frame) {
+				break
+			}
+		}
+
+		t := u.xfer
+		t.Reset()
+		t.Buffer = u.payloadBuf[:used]
+		t.NumIsoPackets = numPackets
+		t.WithContext(ctx)
+		t.SetupIsoPacketsVariable(u.sizes[:numPackets])
+
+		if err := stack.SubmitTransfer(t); err != nil {
+			return err
+		}
+		<-u.done
+		if t.Error != nil {
+			return t.Error
+		}
+
+		if offset >= len(frame) {
+			return nil
+		}
+	}
+}
+
+// putPayload writes a payload header followed by as much of data as fits in
+// buf. The end of frame bit is set when all of data fits.
+// Returns the payload length.
+func putPayload(buf, data []byte, fid uint8) int {
+	n := copy(buf[PayloadHeaderSize:], data)
+	buf[0] = PayloadHeaderSize
+	buf[1] = HeaderEOH | fid
+	if n == len(data) {
+		buf[1] |= HeaderEOF
+	}
+	return PayloadHeaderSize + n
+}
+
+// setStreamingLocked updates the streaming state and wakes Serve
+// (caller must hold u.mutex). Returns true if the state changed.
+func (u *UVC) setStreamingLocked(active bool) bool {
+	if u.streaming == active {
+		return false
+	}
+	u.streaming = active
+	select {
+	case u.stateChange <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// stopBulk stops a bulk stream after the host clears the endpoint halt
+// feature, which is how hosts end bulk video streams.
+func (u *UVC) stopBulk() {
+	u.mutex.Lock()
+	if u.epType != device.EndpointTypeBulk {
+		u.mutex.Unlock()
+		return
+	}
+	u.committed = false
+	changed := u.setStreamingLocked(false)
+	cb := u.onStreamingChange
+	u.mutex.Unlock()
+
+	if changed && cb != nil {
+		cb(false)
+	}
+}
+
+// defaultLocked returns the default stream parameters
+// (caller must hold u.mutex).
+func (u *UVC) defaultLocked() ProbeCommit {
+	return u.negotiateLocked(&ProbeCommit{FormatIndex: 1, FrameIndex: 1})
+}
+
+// negotiateLocked returns the stream parameters the device supports that
+// are closest to those requested (caller must hold u.mutex).
+func (u *UVC) negotiateLocked(in *ProbeCommit) ProbeCommit {
+	out := *in
+	if u.numFormats == 0 {
+		return out
+	}
+
+	if out.FormatIndex == 0 || int(out.FormatIndex) > u.numFormats {
+		out.FormatIndex = 1
+	}
+	format := &u.formats[out.FormatIndex-1]
+
+	frame := format.Frame(out.FrameIndex)
+	if frame == nil {
+		out.FrameIndex = 1
+		frame = format.Frame(1)
+	}
+	if frame == nil {
+		return out
+	}
+
+	if out.FrameInterval == 0 {
+		out.FrameInterval = frame.Intervals[0]
+	} else {
+		out.FrameInterval = frame.nearestInterval(out.FrameInterval)
+	}
+
+	out.MaxVideoFrameSize = frame.MaxFrameSize()
+	out.MaxPayloadTransferSize = BulkPayloadSize
+	if u.epType == device.EndpointTypeIsochronous {
+		out.MaxPayloadTransferSize = IsoMaxPacketSize
+	}
+	out.ClockFrequency = ClockFrequency
+	out.FramingInfo = FramingFID | FramingEOF
+	out.PreferedVersion = 1
+	out.MinVersion = 1
+	out.MaxVersion = 1
+	return out
+}
+
+// maxFrameSizeLocked returns the largest frame size of any format
+// (caller must hold u.mutex).
+func (u *UVC) maxFrameSizeLocked() int {
+	size := 0
+	for i := 0; i < u.numFormats; i++ {
+		for j := 0; j < u.formats[i].NumFrames; j++ {
+			if n := int(u.formats[i].Frames[j].MaxFrameSize()); n > size {
+				size = n
+			}
+		}
+	}
+	return size
+}
+
+// marshalControlLocked writes the VideoControl class-specific descriptors
+// (caller must hold u.mutex). Returns the number of bytes written.
+func (u *UVC) marshalControlLocked(buf []byte) int {
+	header := VCHeaderDescriptor{
+		UVCRelease:     UVCRelease,
+		TotalLength:    VCHeaderSize + CameraTerminalSize + OutputTerminalSize,
+		ClockFrequency: ClockFrequency,
+		StreamingIface: u.streamIfaceNum,
+	}
+	camera := CameraTerminalDescriptor{TerminalID: IDCamera}
+	output := OutputTerminalDescriptor{
+		TerminalID:   IDOutput,
+		TerminalType: TerminalUSBStreaming,
+		SourceID:     IDCamera,
+	}
+
+	offset := header.MarshalTo(buf)
+	if offset == 0 {
+		return 0
+	}
+	n := camera.MarshalTo(buf[offset:])
+	if n == 0 {
+		return 0
+	}
+	offset += n
+	n = output.MarshalTo(buf[offset:])
+	if n == 0 {
+		return 0
+	}
+	return offset + n
+}
+
+// marshalStreamingLocked writes the VideoStreaming class-specific
+// descriptors (caller must hold u.mutex). Returns the number of bytes written.
+func (u *UVC) marshalStreamingLocked(buf []byte) int {
+	header := InputHeaderDescriptor{
+		NumFormats:      uint8(u.numFormats),
+		EndpointAddress: u.epAddr,
+		TerminalLink:    IDOutput,
+	}
+	total := header.Size()
+	for i := 0; i < u.numFormats; i++ {
+		total += u.formats[i].descriptorSize()
+	}
+	header.TotalLength = uint16(total)
+
+	offset := header.MarshalTo(buf)
+	if offset == 0 {
+		return 0
+	}
+	for i := 0; i < u.numFormats; i++ {
+		n := u.formats[i].MarshalTo(buf[offset:], uint8(i+1))
+		if n == 0 {
+			return 0
+		}
+		offset += n
+	}
+	return offset
+}
+
+// Compile-time interface check
+var _ device.ClassDriver = (*UVC)(nil)
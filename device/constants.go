@@ -11,12 +11,34 @@ const (
 	// MaxInterfacesPerConfiguration is the maximum number of interfaces per configuration.
 	MaxInterfacesPerConfiguration = 8
 
+	// MaxAlternateSettings is the maximum number of alternate settings per interface,
+	// not counting the default setting 0.
+	MaxAlternateSettings = 4
+
 	// MaxConfigurations is the maximum number of configurations per device.
 	MaxConfigurations = 4
 
 	// MaxStrings is the maximum number of string descriptors per device.
 	MaxStrings = 16
 
+	// MaxRequestHandlers is the maximum number of device-level request handlers.
+	MaxRequestHandlers = 8
+
+	// MaxDeviceCapabilities is the maximum number of device capabilities in
+	// the BOS descriptor.
+	MaxDeviceCapabilities = 4
+
+	// MaxMSOSFunctions is the maximum number of function subsets in an MS OS
+	// descriptor set.
+	MaxMSOSFunctions = 8
+
+	// MaxMSOSProperties is the maximum number of registry properties of the
+	// device or a function in an MS OS descriptor set.
+	MaxMSOSProperties = 4
+
+	// MaxMSOSDescriptorSetSize is the maximum size of an MS OS descriptor set.
+	MaxMSOSDescriptorSetSize = 2048
+
 	// MaxPendingTransfersPerEndpoint is the maximum pending transfers per endpoint.
 	MaxPendingTransfersPerEndpoint = 8
 
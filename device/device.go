@@ -32,6 +32,14 @@ type Device struct {
 	// Remote wakeup enabled
 	remoteWakeupEnabled bool
 
+	// Device-level request handlers - fixed-size array for zero allocation
+	requestHandlers     [MaxRequestHandlers]requestHandlerEntry
+	requestHandlerCount int
+
+	// Device capabilities reported in the BOS descriptor
+	capabilities    [MaxDeviceCapabilities]DeviceCapability
+	capabilityCount int
+
 	// Synchronization
 	mutex sync.RWMutex
 
@@ -200,13 +208,44 @@ func (d *Device) Speed() Speed {
 	return d.speed
 }
 
-// SetSpeed sets the device speed.
+// SetSpeed sets the device speed and applies the endpoint parameters set
+// for it with [Endpoint.SetSpeedParameters] to all configurations.
 func (d *Device) SetSpeed(speed Speed) {
+	d.mutex.RLock()
+	configs := d.configurations
+	count := d.configurationCount
+	d.mutex.RUnlock()
+
+	for idx := 0; idx < count; idx++ {
+		configs[idx].applySpeed(speed)
+	}
+
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	d.speed = speed
 }
 
+// IsDualSpeed reports whether the device operates at both full and high
+// speed: it runs at high speed, or has endpoints with per-speed
+// parameters.
+func (d *Device) IsDualSpeed() bool {
+	d.mutex.RLock()
+	speed := d.speed
+	configs := d.configurations
+	count := d.configurationCount
+	d.mutex.RUnlock()
+
+	if speed == SpeedHigh {
+		return true
+	}
+	for idx := 0; idx < count; idx++ {
+		if configs[idx].hasSpeedParameters() {
+			return true
+		}
+	}
+	return false
+}
+
 // ControlEndpoint returns the control endpoint (EP0).
 func (d *Device) ControlEndpoint() *Endpoint {
 	d.mutex.RLock()
@@ -380,26 +419,51 @@ func (d *Device) GetInterface(number uint8) *Interface {
 	return config.GetInterface(number)
 }
 
-// GetEndpoint returns an endpoint from the active configuration.
+// GetEndpoint returns an endpoint from the active configuration. If several
+// alternate settings declare the address, the endpoint of the active one is
+// returned.
 func (d *Device) GetEndpoint(address uint8) *Endpoint {
 	if address == 0 || address == 0x80 {
 		return d.ControlEndpoint()
 	}
 
+	_, ep := d.findEndpoint(address)
+	return ep
+}
+
+// endpointInterface returns the interface of the active configuration that
+// owns the endpoint with the given address, in any alternate setting.
+func (d *Device) endpointInterface(address uint8) *Interface {
+	iface, _ := d.findEndpoint(address)
+	return iface
+}
+
+// findEndpoint returns the endpoint with the given address in the active
+// configuration and the interface owning it. The active alternate setting
+// of each interface is searched before the others, as alternate settings
+// may reuse an address with different parameters (e.g. the isochronous
+// settings of audio and video streaming interfaces).
+func (d *Device) findEndpoint(address uint8) (*Interface, *Endpoint) {
 	d.mutex.RLock()
 	config := d.activeConfig
 	d.mutex.RUnlock()
 
 	if config == nil {
-		return nil
+		return nil, nil
 	}
 
-	for _, iface := range config.Interfaces() {
-		if ep := iface.GetEndpoint(address); ep != nil {
-			return ep
+	interfaces := config.Interfaces()
+	for _, iface := range interfaces {
+		if ep := iface.activeEndpoint(address); ep != nil {
+			return iface, ep
 		}
 	}
-	return nil
+	for _, iface := range interfaces {
+		if ep := iface.findEndpoint(address); ep != nil {
+			return iface, ep
+		}
+	}
+	return nil, nil
 }
 
 // SetEndpointStall sets or clears the stall condition on an endpoint.
@@ -497,10 +561,12 @@ func (d *Device) GetStatus() DeviceStatus {
 
 // DeviceBuilder provides a fluent API for building devices.
 type DeviceBuilder struct {
-	device *Device
-	config *Configuration
-	iface  *Interface
-	errors []error
+	device  *Device
+	config  *Configuration
+	primary *Interface // Interface holding alternate settings (setting 0)
+	iface   *Interface // Current interface or alternate setting
+	ep      *Endpoint  // Most recently added endpoint of the current interface
+	errors  []error
 
 	// Pre-allocated string buffers
 	stringBufs [MaxStrings][256]byte
@@ -554,6 +620,77 @@ func (b *DeviceBuilder) WithStrings(manufacturer, product, serial string) *Devic
 	return b
 }
 
+// WithRequestHandler registers a device-level request handler.
+// See [Device.AddRequestHandler].
+func (b *DeviceBuilder) WithRequestHandler(requestType, recipient uint8, h RequestHandler) *DeviceBuilder {
+	if b.device == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	if err := b.device.AddRequestHandler(requestType, recipient, h); err != nil {
+		b.errors = append(b.errors, err)
+	}
+	return b
+}
+
+// WithDeviceCapability adds a device capability to the BOS descriptor.
+// The USB version is raised to 2.1 if lower, since hosts only request the
+// BOS descriptor from USB 2.1 and later devices.
+func (b *DeviceBuilder) WithDeviceCapability(c DeviceCapability) *DeviceBuilder {
+	if b.device == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	if err := b.device.AddDeviceCapability(c); err != nil {
+		b.errors = append(b.errors, err)
+		return b
+	}
+	if b.device.Descriptor.USBVersion < 0x0210 {
+		b.device.Descriptor.USBVersion = 0x0210
+	}
+	return b
+}
+
+// WithMSOS20Descriptors advertises the MS OS 2.0 descriptor set in the BOS
+// descriptor and answers the vendor request that retrieves it, along with
+// the MS OS 1.0 requests if enabled on the set.
+func (b *DeviceBuilder) WithMSOS20Descriptors(set *MSOS20DescriptorSet) *DeviceBuilder {
+	if set == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidParameter)
+		return b
+	}
+	if err := set.Err(); err != nil {
+		b.errors = append(b.errors, err)
+		return b
+	}
+	if set.MarshalTo(set.buf[:]) == 0 {
+		b.errors = append(b.errors, pkg.ErrBufferTooSmall)
+		return b
+	}
+	b.WithDeviceCapability(set.Capability())
+	b.WithRequestHandler(RequestTypeVendor, RequestRecipientDevice, set)
+	if set.MSOS10() {
+		// OS string descriptor and per-interface extended properties
+		b.WithRequestHandler(RequestTypeStandard, RequestRecipientDevice, set)
+		b.WithRequestHandler(RequestTypeVendor, RequestRecipientInterface, set)
+	}
+	return b
+}
+
+// WithWebUSB advertises the WebUSB platform capability in the BOS
+// descriptor and answers the GET_URL request, with the given vendor
+// request code, for the landing page URL (none if empty).
+func (b *DeviceBuilder) WithWebUSB(vendorCode uint8, landingPage string) *DeviceBuilder {
+	w, err := NewWebUSB(vendorCode, landingPage)
+	if err != nil {
+		b.errors = append(b.errors, err)
+		return b
+	}
+	b.WithDeviceCapability(w)
+	b.WithRequestHandler(RequestTypeVendor, RequestRecipientDevice, w)
+	return b
+}
+
 // AddConfiguration adds a new configuration.
 func (b *DeviceBuilder) AddConfiguration(value uint8) *DeviceBuilder {
 	if b.device == nil {
@@ -586,6 +723,81 @@ func (b *DeviceBuilder) AddInterface(class, subClass, protocol uint8) *DeviceBui
 	if err := b.config.AddInterface(b.iface); err != nil {
 		b.errors = append(b.errors, err)
 	}
+	b.primary = b.iface
+	b.ep = nil
+	return b
+}
+
+// AddInterfaceAssociation adds an interface association grouping the next
+// count interfaces into one function. Call this before adding the
+// function's first interface.
+func (b *DeviceBuilder) AddInterfaceAssociation(count, class, subClass, protocol uint8) *DeviceBuilder {
+	if b.config == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	err := b.config.AddAssociation(&InterfaceAssociation{
+		FirstInterface:   uint8(b.config.NumInterfaces()),
+		InterfaceCount:   count,
+		FunctionClass:    class,
+		FunctionSubClass: subClass,
+		FunctionProtocol: protocol,
+	})
+	if err != nil {
+		b.errors = append(b.errors, err)
+	}
+	return b
+}
+
+// AddAlternateSetting adds an alternate setting to the current interface,
+// with the same class, subclass, and protocol. Subsequent endpoints and
+// class-specific descriptors are added to the new alternate setting.
+func (b *DeviceBuilder) AddAlternateSetting() *DeviceBuilder {
+	if b.primary == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	return b.AddAlternateInterface(b.primary.Class, b.primary.SubClass, b.primary.Protocol)
+}
+
+// AddAlternateInterface adds an alternate setting to the current interface
+// with its own class, subclass, and protocol, such as a transport protocol
+// offered alongside the one in setting 0. Subsequent endpoints and
+// class-specific descriptors are added to the new alternate setting.
+func (b *DeviceBuilder) AddAlternateInterface(class, subClass, protocol uint8) *DeviceBuilder {
+	if b.primary == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	alt := NewInterface(&InterfaceDescriptor{
+		Length:            InterfaceDescriptorSize,
+		DescriptorType:    DescriptorTypeInterface,
+		InterfaceNumber:   b.primary.Number,
+		InterfaceClass:    class,
+		InterfaceSubClass: subClass,
+		InterfaceProtocol: protocol,
+	})
+	if err := b.primary.AddAlternate(alt); err != nil {
+		b.errors = append(b.errors, err)
+		return b
+	}
+	b.iface = alt
+	b.ep = nil
+	return b
+}
+
+// AddClassDescriptor adds class-specific descriptors after the most recently
+// added endpoint, or after the current interface if it has no endpoints yet.
+// The data is appended to any class-specific descriptors already present.
+func (b *DeviceBuilder) AddClassDescriptor(data []byte) *DeviceBuilder {
+	switch {
+	case b.ep != nil:
+		b.ep.SetClassDescriptors(append(b.ep.ClassDescriptors(), data...))
+	case b.iface != nil:
+		b.iface.SetClassDescriptors(append(b.iface.ClassDescriptors(), data...))
+	default:
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+	}
 	return b
 }
 
@@ -602,6 +814,55 @@ func (b *DeviceBuilder) AddEndpoint(address uint8, transferType uint8, maxPacket
 	}
 	if err := b.iface.AddEndpoint(ep); err != nil {
 		b.errors = append(b.errors, err)
+		return b
+	}
+	b.ep = ep
+	return b
+}
+
+// AddEndpointDescriptor adds an endpoint described by desc to the current
+// interface. Use this instead of AddEndpoint when the polling interval must
+// be set.
+func (b *DeviceBuilder) AddEndpointDescriptor(desc *EndpointDescriptor) *DeviceBuilder {
+	if b.iface == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	ep := NewEndpoint(desc)
+	if err := b.iface.AddEndpoint(ep); err != nil {
+		b.errors = append(b.errors, err)
+		return b
+	}
+	b.ep = ep
+	return b
+}
+
+// WithEndpointExtension appends class-defined fields to the descriptor of
+// the current endpoint, such as bRefresh and bSynchAddress of USB Audio 1.0
+// endpoints. See [Endpoint.SetDescriptorExtension].
+func (b *DeviceBuilder) WithEndpointExtension(data []byte) *DeviceBuilder {
+	if b.ep == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	if len(data) > 255-EndpointDescriptorSize {
+		b.errors = append(b.errors, pkg.ErrInvalidParameter)
+		return b
+	}
+	b.ep.SetDescriptorExtension(data)
+	return b
+}
+
+// WithEndpointSpeed sets the maximum packet size and polling interval of
+// the current endpoint at speed (SpeedFull or SpeedHigh), for devices that
+// operate at both speeds. See [Endpoint.SetSpeedParameters].
+func (b *DeviceBuilder) WithEndpointSpeed(speed Speed, maxPacketSize uint16, interval uint8) *DeviceBuilder {
+	if b.ep == nil {
+		b.errors = append(b.errors, pkg.ErrInvalidState)
+		return b
+	}
+	if err := b.ep.SetSpeedParameters(speed, maxPacketSize, interval); err != nil {
+		b.errors = append(b.errors, err)
 	}
 	return b
 }
@@ -327,6 +327,42 @@ func TestDeviceGetEndpoint(t *testing.T) {
 	}
 }
 
+func TestDeviceGetEndpointActiveAlternate(t *testing.T) {
+	// Streaming alternates sharing an isochronous address with different
+	// packet sizes
+	builder := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		AddInterface(0x01, 0x02, 0x00)
+	for _, size := range []uint16{96, 192, 288} {
+		builder.AddAlternateSetting().
+			AddEndpoint(0x01, EndpointTypeIsochronous|IsoSyncAdaptive, size)
+	}
+	dev, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	dev.Reset()
+	dev.SetAddress(1)
+	dev.SetConfiguration(1)
+
+	iface := dev.GetInterface(0)
+	if ep := dev.GetEndpoint(0x01); ep == nil || ep.MaxPacketSize != 96 {
+		t.Fatalf("GetEndpoint() in alt 0 = %+v, want the first alternate's endpoint", ep)
+	}
+	for alt, size := range []uint16{96, 192, 288} {
+		if err := iface.SetAlternate(uint8(alt + 1)); err != nil {
+			t.Fatalf("SetAlternate(%d) error = %v", alt+1, err)
+		}
+		if ep := dev.GetEndpoint(0x01); ep == nil || ep.MaxPacketSize != size {
+			t.Errorf("GetEndpoint() in alt %d = %+v, want max packet size %d", alt+1, ep, size)
+		}
+		if got := dev.endpointInterface(0x01); got != iface {
+			t.Errorf("endpointInterface() in alt %d = %v, want interface 0", alt+1, got)
+		}
+	}
+}
+
 func TestDeviceSetEndpointStall(t *testing.T) {
 	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
 	config := NewConfiguration(1)
@@ -444,6 +480,140 @@ func TestDeviceBuilder(t *testing.T) {
 	}
 }
 
+func TestDeviceBuilderAlternateSetting(t *testing.T) {
+	header := []byte{0x09, 0x24, 0x01, 0x00, 0x01, 0x09, 0x00, 0x01, 0x01}
+	general := []byte{0x07, 0x24, 0x01, 0x01, 0x01, 0x01, 0x00}
+	epGeneral := []byte{0x07, 0x25, 0x01, 0x01, 0x00, 0x00, 0x00}
+
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		AddInterface(0x01, 0x01, 0x00).
+		AddClassDescriptor(header).
+		AddInterface(0x01, 0x02, 0x00).
+		AddAlternateSetting().
+		AddClassDescriptor(general).
+		AddEndpointDescriptor(&EndpointDescriptor{
+			Length:          EndpointDescriptorSize,
+			EndpointAddress: 0x01,
+			Attributes:      EndpointTypeIsochronous | IsoSyncAdaptive,
+			MaxPacketSize:   192,
+			Interval:        1,
+		}).
+		WithEndpointExtension([]byte{0x00, 0x00}).
+		AddClassDescriptor(epGeneral).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	config := dev.GetConfiguration(1)
+	control := config.GetInterface(0)
+	if string(control.ClassDescriptors()) != string(header) {
+		t.Error("control interface class descriptors not set")
+	}
+
+	streaming := config.GetInterface(1)
+	if streaming.NumEndpoints() != 0 {
+		t.Errorf("alt 0 has %d endpoints, want 0", streaming.NumEndpoints())
+	}
+	if streaming.NumAlternates() != 2 {
+		t.Fatalf("NumAlternates() = %d, want 2", streaming.NumAlternates())
+	}
+
+	alt := streaming.Alternate(1)
+	if alt.SubClass != 0x02 {
+		t.Errorf("alt 1 subclass = 0x%02X, want 0x02", alt.SubClass)
+	}
+	if string(alt.ClassDescriptors()) != string(general) {
+		t.Error("alt 1 class descriptors not set")
+	}
+
+	dev.Reset()
+	dev.SetAddress(1)
+	dev.SetConfiguration(1)
+	ep := dev.GetEndpoint(0x01)
+	if ep == nil {
+		t.Fatal("GetEndpoint() did not find endpoint in alternate setting")
+	}
+	if len(ep.DescriptorExtension()) != 2 || ep.Interval != 1 {
+		t.Errorf("endpoint = (extension % X, interval %d), want (00 00, 1)", ep.DescriptorExtension(), ep.Interval)
+	}
+	if string(ep.ClassDescriptors()) != string(epGeneral) {
+		t.Error("endpoint class descriptors not set")
+	}
+}
+
+func TestDeviceBuilderAlternateSettingNoInterface(t *testing.T) {
+	_, err := NewDeviceBuilder().
+		AddConfiguration(1).
+		AddAlternateSetting().
+		Build(context.Background())
+	if err == nil {
+		t.Error("Build() should fail when AddAlternateSetting precedes AddInterface")
+	}
+}
+
+func TestDeviceBuilderAlternateInterface(t *testing.T) {
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		AddInterface(0x08, 0x06, 0x50).
+		AddEndpoint(0x81, EndpointTypeBulk, 512).
+		AddEndpoint(0x02, EndpointTypeBulk, 512).
+		AddAlternateInterface(0x08, 0x06, 0x62).
+		AddEndpoint(0x83, EndpointTypeBulk, 512).
+		AddEndpoint(0x04, EndpointTypeBulk, 512).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	iface := dev.GetConfiguration(1).GetInterface(0)
+	if iface.Protocol != 0x50 {
+		t.Errorf("alt 0 protocol = 0x%02X, want 0x50", iface.Protocol)
+	}
+	alt := iface.Alternate(1)
+	if alt == nil {
+		t.Fatal("Alternate(1) = nil")
+	}
+	if alt.Number != 0 || alt.AlternateSetting != 1 {
+		t.Errorf("alt = (interface %d, setting %d), want (0, 1)", alt.Number, alt.AlternateSetting)
+	}
+	if alt.Protocol != 0x62 {
+		t.Errorf("alt 1 protocol = 0x%02X, want 0x62", alt.Protocol)
+	}
+	if alt.NumEndpoints() != 2 || iface.NumEndpoints() != 2 {
+		t.Errorf("endpoints = (%d, %d), want (2, 2)", iface.NumEndpoints(), alt.NumEndpoints())
+	}
+}
+
+func TestDeviceBuilderInterfaceAssociation(t *testing.T) {
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		AddInterface(0xFF, 0x00, 0x00).
+		AddInterfaceAssociation(2, ClassVideo, 0x03, 0x00).
+		AddInterface(ClassVideo, 0x01, 0x00).
+		AddInterface(ClassVideo, 0x02, 0x00).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	assocs := dev.GetConfiguration(1).Associations()
+	if len(assocs) != 1 {
+		t.Fatalf("got %d associations, want 1", len(assocs))
+	}
+	if assocs[0].FirstInterface != 1 || assocs[0].InterfaceCount != 2 {
+		t.Errorf("association = (first %d, count %d), want (1, 2)",
+			assocs[0].FirstInterface, assocs[0].InterfaceCount)
+	}
+	if assocs[0].FunctionClass != ClassVideo {
+		t.Errorf("FunctionClass = 0x%02X, want 0x%02X", assocs[0].FunctionClass, ClassVideo)
+	}
+}
+
 func TestDeviceBuilderNoDevice(t *testing.T) {
 	_, err := NewDeviceBuilder().
 		AddConfiguration(1).
@@ -1311,3 +1481,73 @@ func BenchmarkDeviceClose(b *testing.B) {
 		_ = dev.Close()
 	}
 }
+
+func TestDeviceSetSpeed(t *testing.T) {
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		AddInterface(ClassMassStorage, 0x06, 0x50).
+		AddEndpoint(0x81, EndpointTypeBulk, 512).
+		WithEndpointSpeed(SpeedFull, 64, 0).
+		AddEndpoint(0x02, EndpointTypeBulk, 512).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if !dev.IsDualSpeed() {
+		t.Error("IsDualSpeed() should be true")
+	}
+
+	iface := dev.GetConfiguration(1).GetInterface(0)
+	dev.SetSpeed(SpeedFull)
+	if dev.Speed() != SpeedFull {
+		t.Errorf("Speed() = %v, want %v", dev.Speed(), SpeedFull)
+	}
+	if mps := iface.GetEndpoint(0x81).MaxPacketSize; mps != 64 {
+		t.Errorf("0x81 MaxPacketSize at full speed = %d, want 64", mps)
+	}
+	if mps := iface.GetEndpoint(0x02).MaxPacketSize; mps != 512 {
+		t.Errorf("0x02 MaxPacketSize at full speed = %d, want 512", mps)
+	}
+
+	dev.SetSpeed(SpeedHigh)
+	if mps := iface.GetEndpoint(0x81).MaxPacketSize; mps != 512 {
+		t.Errorf("0x81 MaxPacketSize at high speed = %d, want 512", mps)
+	}
+}
+
+func TestDeviceIsDualSpeed(t *testing.T) {
+	dev := setupTestDevice()
+	dev.SetSpeed(SpeedFull)
+	if dev.IsDualSpeed() {
+		t.Error("full speed device without speed parameters should not be dual-speed")
+	}
+	dev.SetSpeed(SpeedHigh)
+	if !dev.IsDualSpeed() {
+		t.Error("high speed device should be dual-speed")
+	}
+}
+
+func TestDeviceBuilderWithEndpointSpeed(t *testing.T) {
+	_, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		AddInterface(ClassMassStorage, 0x06, 0x50).
+		WithEndpointSpeed(SpeedFull, 64, 0).
+		Build(context.Background())
+	if err != pkg.ErrInvalidState {
+		t.Errorf("Build() without endpoint error = %v, want %v", err, pkg.ErrInvalidState)
+	}
+
+	_, err = NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		AddConfiguration(1).
+		AddInterface(ClassMassStorage, 0x06, 0x50).
+		AddEndpoint(0x81, EndpointTypeBulk, 512).
+		WithEndpointSpeed(SpeedLow, 8, 0).
+		Build(context.Background())
+	if err != pkg.ErrInvalidParameter {
+		t.Errorf("Build() with low speed error = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+}
@@ -47,18 +47,78 @@
 //
 //	type ClassDriver interface {
 //	    Init(iface *Interface) error
-//	    HandleSetup(iface *Interface, setup *SetupPacket, data []byte) (bool, error)
+//	    HandleSetup(iface *Interface, setup *SetupPacket, data []byte) ([]byte, bool, error)
 //	    SetAlternate(iface *Interface, alt uint8) error
 //	    Close() error
 //	}
 //
+// The stack reads the OUT data stage of a control request before calling
+// HandleSetup, and sends the returned slice as the IN data stage.
+//
 // Built-in support includes:
 //
 //   - [github.com/ardnew/softusb/device/class/hid] - Human Interface Device
-//   - [github.com/ardnew/softusb/device/class/cdc] - Communications Device Class (CDC-ACM)
+//   - [github.com/ardnew/softusb/device/class/cdc] - Communications Device Class (CDC-ACM, CDC-ECM, CDC-NCM, RNDIS)
 //   - [github.com/ardnew/softusb/device/class/msc] - Mass Storage Class (Bulk-Only Transport)
+//   - [github.com/ardnew/softusb/device/class/hub] - Hub Class
+//   - [github.com/ardnew/softusb/device/class/audio] - USB Audio Class 1.0
+//   - [github.com/ardnew/softusb/device/class/uvc] - USB Video Class
+//
+// Additional classes (CDC-ETM) can be implemented via this interface.
+//
+// # Request Routing
+//
+// Control requests not answered by the standard request handler go to the
+// class driver of the addressed interface, or of the interface owning the
+// addressed endpoint, and then to the device-level [RequestHandler]s
+// registered for the request type and recipient, such as a vendor
+// firmware update protocol:
+//
+//	builder.WithRequestHandler(device.RequestTypeVendor, device.RequestRecipientDevice,
+//	    device.RequestHandlerFunc(func(setup *device.SetupPacket, data []byte) ([]byte, bool, error) {
+//	        return fw.handle(setup, data)
+//	    }))
+//
+// Request handlers receive data stages in the same way as class drivers.
+//
+// Requests that no handler answers are stalled.
+//
+// # BOS and Microsoft OS Descriptors
+//
+// Device capabilities such as [USB20ExtensionDescriptor] and
+// [PlatformCapabilityDescriptor] are reported in the BOS descriptor.
+// [MSOS20DescriptorSet] builds Microsoft OS 2.0 descriptors, which let
+// Windows bind WinUSB to the device or to single functions of a composite
+// device without an INF file:
+//
+//	set := device.NewMSOS20DescriptorSet(0x20).
+//	    WithMSOS10(). // Also answer Windows 7 and earlier
+//	    CompatibleID("WINUSB", "").
+//	    DeviceInterfaceGUIDs("{88BAE032-5A81-49F0-BC3D-A4FF138216D6}")
+//	builder.WithMSOS20Descriptors(set)
+//
+// The builder adds the MS OS 2.0 platform capability to the BOS descriptor,
+// raises the USB version to 2.1, and registers the set as a request handler.
+//
+// [DeviceBuilder.WithWebUSB] likewise advertises the WebUSB platform
+// capability and answers the GET_URL request for a landing page, so
+// browsers can offer the device to web pages through the WebUSB API:
+//
+//	builder.WithWebUSB(0x01, "https://example.com/gadget")
+//
+// # Dual-Speed Devices
+//
+// Endpoints whose maximum packet size or polling interval differs between
+// full and high speed set both with [Endpoint.SetSpeedParameters] or
+// [DeviceBuilder.WithEndpointSpeed]:
+//
+//	builder.AddEndpoint(0x81, device.EndpointTypeBulk, 512).
+//	    WithEndpointSpeed(device.SpeedFull, 64, 0)
 //
-// Additional classes (USB Audio, CDC-ETM) can be implemented via this interface.
+// The stack reads the negotiated speed from the HAL after each bus reset
+// and applies the matching parameters. The device qualifier and other
+// speed configuration descriptors describe the device at the speed it is
+// not operating at.
 //
 // # Example
 //
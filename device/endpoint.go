@@ -44,15 +44,50 @@ type Endpoint struct {
 	MaxPacketSize uint16 // Maximum packet size
 	Interval      uint8  // Polling interval (interrupt/isochronous)
 
+	// Class-defined fields appended to the endpoint descriptor
+	extension []byte
+
+	// Class-specific descriptors following the endpoint descriptor
+	classDescriptors []byte
+
+	// Per-speed parameters, indexed by speedIndex
+	speeds    [2]endpointSpeed
+	hasSpeeds bool
+
 	// Runtime state
-	stalled    bool // Endpoint is stalled
-	dataToggle bool // DATA0/DATA1 toggle
-	mutex      sync.Mutex
+	stalled     bool   // Endpoint is stalled
+	dataToggle  bool   // DATA0/DATA1 toggle
+	onClearHalt func() // Called when the host clears the halt feature
+	mutex       sync.Mutex
 
 	// Isochronous-specific
 	frameNumber uint16 // Current frame number for scheduling
 }
 
+// endpointSpeed holds the endpoint parameters used at one speed.
+type endpointSpeed struct {
+	maxPacketSize uint16
+	interval      uint8
+}
+
+// speedCurrent selects the endpoint parameters currently in effect when
+// marshaling descriptors.
+const speedCurrent Speed = 0xFF
+
+// Largest maximum packet sizes allowed at full speed.
+const (
+	fullSpeedMaxPacketSize    = 64
+	fullSpeedMaxIsoPacketSize = 1023
+)
+
+// speedIndex returns the index of the per-speed parameters used at speed.
+func speedIndex(speed Speed) int {
+	if speed == SpeedHigh {
+		return 1
+	}
+	return 0
+}
+
 // NewEndpoint creates a new endpoint from a descriptor.
 func NewEndpoint(desc *EndpointDescriptor) *Endpoint {
 	return &Endpoint{
@@ -132,6 +167,32 @@ func (e *Endpoint) SetStall(stalled bool) {
 	}
 }
 
+// SetOnClearHalt sets the callback invoked after the host clears the
+// endpoint halt feature with CLEAR_FEATURE(ENDPOINT_HALT).
+func (e *Endpoint) SetOnClearHalt(cb func()) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.onClearHalt = cb
+}
+
+// clearHalt clears the stall condition and data toggle on a host
+// CLEAR_FEATURE(ENDPOINT_HALT) request and invokes the OnClearHalt
+// callback.
+func (e *Endpoint) clearHalt() {
+	e.mutex.Lock()
+	e.stalled = false
+	e.dataToggle = false
+	cb := e.onClearHalt
+	e.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentEndpoint, "endpoint halt cleared",
+		"address", fmt.Sprintf("0x%02X", e.Address))
+
+	if cb != nil {
+		cb()
+	}
+}
+
 // IsStalled returns true if the endpoint is stalled.
 func (e *Endpoint) IsStalled() bool {
 	e.mutex.Lock()
@@ -191,7 +252,7 @@ func (e *Endpoint) IncrementFrame() {
 // Descriptor returns the endpoint descriptor.
 func (e *Endpoint) Descriptor() *EndpointDescriptor {
 	return &EndpointDescriptor{
-		Length:          7,
+		Length:          EndpointDescriptorSize,
 		DescriptorType:  DescriptorTypeEndpoint,
 		EndpointAddress: e.Address,
 		Attributes:      e.Attributes,
@@ -200,6 +261,154 @@ func (e *Endpoint) Descriptor() *EndpointDescriptor {
 	}
 }
 
+// SetDescriptorExtension sets class-defined fields appended to the standard
+// endpoint descriptor and counted in its bLength, such as bRefresh and
+// bSynchAddress of USB Audio 1.0 endpoints. The slice is referenced, not
+// copied.
+func (e *Endpoint) SetDescriptorExtension(data []byte) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.extension = data
+}
+
+// DescriptorExtension returns the fields appended to the endpoint
+// descriptor.
+func (e *Endpoint) DescriptorExtension() []byte {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.extension
+}
+
+// SetClassDescriptors sets the class-specific descriptors emitted after the
+// endpoint descriptor in the configuration descriptor. The slice is
+// referenced, not copied.
+func (e *Endpoint) SetClassDescriptors(data []byte) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.classDescriptors = data
+}
+
+// ClassDescriptors returns the class-specific endpoint descriptors.
+func (e *Endpoint) ClassDescriptors() []byte {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.classDescriptors
+}
+
+// SetSpeedParameters sets the maximum packet size and polling interval of
+// the endpoint at speed (SpeedFull or SpeedHigh), e.g. 64 bytes at full
+// speed and 512 bytes at high speed for a bulk endpoint. A speed without
+// parameters keeps the values the endpoint was created with.
+// [Device.SetSpeed] applies the parameters of the negotiated speed.
+func (e *Endpoint) SetSpeedParameters(speed Speed, maxPacketSize uint16, interval uint8) error {
+	if speed != SpeedFull && speed != SpeedHigh {
+		return pkg.ErrInvalidParameter
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.hasSpeeds {
+		e.speeds[0] = endpointSpeed{maxPacketSize: e.fullSpeedPacketSize(), interval: e.Interval}
+		e.speeds[1] = endpointSpeed{maxPacketSize: e.MaxPacketSize, interval: e.Interval}
+		e.hasSpeeds = true
+	}
+	e.speeds[speedIndex(speed)] = endpointSpeed{maxPacketSize: maxPacketSize, interval: interval}
+	return nil
+}
+
+// SpeedParameters returns the maximum packet size and polling interval of
+// the endpoint at speed. Without per-speed parameters, the maximum packet
+// size at full speed is limited to the largest the transfer type allows.
+func (e *Endpoint) SpeedParameters(speed Speed) (maxPacketSize uint16, interval uint8) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.hasSpeeds {
+		if speed == SpeedFull {
+			return e.fullSpeedPacketSize(), e.Interval
+		}
+		return e.MaxPacketSize, e.Interval
+	}
+	params := e.speeds[speedIndex(speed)]
+	return params.maxPacketSize, params.interval
+}
+
+// fullSpeedPacketSize returns the maximum packet size limited to the
+// largest allowed at full speed. High-bandwidth transactions are not
+// available at full speed, so the additional transaction bits are dropped.
+// The caller must hold e.mutex.
+func (e *Endpoint) fullSpeedPacketSize() uint16 {
+	size := e.MaxPacketSize & 0x07FF
+	limit := uint16(fullSpeedMaxPacketSize)
+	if e.TransferType() == EndpointTypeIsochronous {
+		limit = fullSpeedMaxIsoPacketSize
+	}
+	if size > limit {
+		return limit
+	}
+	return size
+}
+
+// HasSpeedParameters reports whether per-speed parameters are set.
+func (e *Endpoint) HasSpeedParameters() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.hasSpeeds
+}
+
+// applySpeed sets MaxPacketSize and Interval to the parameters of speed.
+func (e *Endpoint) applySpeed(speed Speed) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.hasSpeeds {
+		return
+	}
+	params := e.speeds[speedIndex(speed)]
+	e.MaxPacketSize = params.maxPacketSize
+	e.Interval = params.interval
+}
+
+// TotalLength returns the length of the endpoint descriptor together with
+// its class-specific descriptors.
+func (e *Endpoint) TotalLength() int {
+	return EndpointDescriptorSize + len(e.DescriptorExtension()) + len(e.ClassDescriptors())
+}
+
+// MarshalTo writes the endpoint descriptor followed by its class-specific
+// descriptors to buf. Returns the number of bytes written, or 0 if buf is
+// too small.
+func (e *Endpoint) MarshalTo(buf []byte) int {
+	return e.marshalTo(buf, speedCurrent)
+}
+
+// marshalTo writes the endpoint descriptor with the parameters of speed,
+// or those in effect if speedCurrent, followed by its class-specific
+// descriptors to buf.
+func (e *Endpoint) marshalTo(buf []byte, speed Speed) int {
+	desc := e.Descriptor()
+	if speed != speedCurrent {
+		desc.MaxPacketSize, desc.Interval = e.SpeedParameters(speed)
+	}
+	n := desc.MarshalTo(buf)
+	if n == 0 {
+		return 0
+	}
+	if ext := e.DescriptorExtension(); len(ext) > 0 {
+		if len(buf[n:]) < len(ext) || n+len(ext) > 255 {
+			return 0
+		}
+		n += copy(buf[n:], ext)
+		buf[0] = uint8(n)
+	}
+	class := e.ClassDescriptors()
+	if len(buf[n:]) < len(class) {
+		return 0
+	}
+	return n + copy(buf[n:], class)
+}
+
 // TransferTypeName returns a human-readable transfer type name.
 func TransferTypeName(t uint8) string {
 	switch t & 0x03 {
@@ -273,6 +273,62 @@ func TestEndpointDescriptor(t *testing.T) {
 	}
 }
 
+func TestEndpointDescriptorExtension(t *testing.T) {
+	ep := NewEndpoint(&EndpointDescriptor{
+		EndpointAddress: 0x01,
+		Attributes:      EndpointTypeIsochronous | IsoSyncAdaptive,
+		MaxPacketSize:   192,
+		Interval:        1,
+	})
+	// bRefresh and bSynchAddress of a USB Audio 1.0 endpoint
+	ep.SetDescriptorExtension([]byte{0x00, 0x82})
+	class := []byte{0x07, 0x25, 0x01, 0x01, 0x00, 0x00, 0x00}
+	ep.SetClassDescriptors(class)
+
+	if got := ep.TotalLength(); got != 9+len(class) {
+		t.Errorf("TotalLength() = %d, want %d", got, 9+len(class))
+	}
+
+	var buf [32]byte
+	n := ep.MarshalTo(buf[:])
+	if n != 9+len(class) {
+		t.Fatalf("MarshalTo() = %d, want %d", n, 9+len(class))
+	}
+	if buf[0] != 9 || buf[7] != 0x00 || buf[8] != 0x82 {
+		t.Errorf("endpoint descriptor = % X, want bLength 9 ending 00 82", buf[:9])
+	}
+	if buf[9] != class[0] || buf[10] != class[1] {
+		t.Errorf("class descriptor = % X, want % X", buf[9:n], class)
+	}
+	if n := ep.MarshalTo(buf[:8]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
+func TestEndpointClassDescriptors(t *testing.T) {
+	ep := &Endpoint{Address: 0x81, Attributes: EndpointTypeIsochronous, MaxPacketSize: 96}
+	class := []byte{0x07, 0x25, 0x01, 0x01, 0x00, 0x00, 0x00}
+	ep.SetClassDescriptors(class)
+
+	want := EndpointDescriptorSize + len(class)
+	if got := ep.TotalLength(); got != want {
+		t.Errorf("TotalLength() = %d, want %d", got, want)
+	}
+
+	var buf [32]byte
+	n := ep.MarshalTo(buf[:])
+	if n != want {
+		t.Fatalf("MarshalTo() = %d, want %d", n, want)
+	}
+	if buf[EndpointDescriptorSize+1] != 0x25 {
+		t.Errorf("class descriptor type = 0x%02X, want 0x25", buf[EndpointDescriptorSize+1])
+	}
+
+	if n := ep.MarshalTo(buf[:EndpointDescriptorSize]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
 func TestTransferTypeName(t *testing.T) {
 	tests := []struct {
 		t    uint8
@@ -990,3 +1046,89 @@ func BenchmarkDirectionName(b *testing.B) {
 		}
 	})
 }
+
+func TestEndpointSpeedParameters(t *testing.T) {
+	ep := &Endpoint{Address: 0x81, Attributes: EndpointTypeBulk, MaxPacketSize: 512}
+
+	// Without per-speed parameters, the current values apply at any speed
+	// within the limits of full speed
+	if mps, _ := ep.SpeedParameters(SpeedHigh); mps != 512 {
+		t.Errorf("SpeedParameters(High) = %d, want 512", mps)
+	}
+	if mps, _ := ep.SpeedParameters(SpeedFull); mps != 64 {
+		t.Errorf("SpeedParameters(Full) = %d, want 64", mps)
+	}
+	if ep.HasSpeedParameters() {
+		t.Error("HasSpeedParameters() should be false")
+	}
+
+	if err := ep.SetSpeedParameters(SpeedFull, 64, 0); err != nil {
+		t.Fatalf("SetSpeedParameters() error = %v", err)
+	}
+	if err := ep.SetSpeedParameters(SpeedLow, 8, 0); err == nil {
+		t.Error("SetSpeedParameters(Low) should fail")
+	}
+
+	// The speed without parameters keeps the original values
+	if mps, _ := ep.SpeedParameters(SpeedHigh); mps != 512 {
+		t.Errorf("SpeedParameters(High) = %d, want 512", mps)
+	}
+	if mps, _ := ep.SpeedParameters(SpeedFull); mps != 64 {
+		t.Errorf("SpeedParameters(Full) = %d, want 64", mps)
+	}
+
+	ep.applySpeed(SpeedFull)
+	if ep.MaxPacketSize != 64 {
+		t.Errorf("MaxPacketSize at full speed = %d, want 64", ep.MaxPacketSize)
+	}
+	ep.applySpeed(SpeedHigh)
+	if ep.MaxPacketSize != 512 {
+		t.Errorf("MaxPacketSize at high speed = %d, want 512", ep.MaxPacketSize)
+	}
+
+	// Descriptors for another speed use its parameters
+	ep.SetSpeedParameters(SpeedHigh, 512, 4)
+	var buf [EndpointDescriptorSize]byte
+	ep.marshalTo(buf[:], SpeedFull)
+	if buf[4] != 64 || buf[5] != 0 || buf[6] != 0 {
+		t.Errorf("full speed descriptor = % X, want max packet size 64, interval 0", buf[:])
+	}
+	ep.MarshalTo(buf[:])
+	if buf[4] != 0x00 || buf[5] != 0x02 || buf[6] != 0 {
+		t.Errorf("current descriptor = % X, want max packet size 512, interval 0", buf[:])
+	}
+}
+
+func TestEndpointFullSpeedPacketSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes uint8
+		size       uint16
+		want       uint16
+	}{
+		{"bulk", EndpointTypeBulk, 512, 64},
+		{"bulk full speed", EndpointTypeBulk, 32, 32},
+		{"interrupt", EndpointTypeInterrupt, 1024, 64},
+		{"interrupt high bandwidth", EndpointTypeInterrupt, 2<<11 | 1024, 64},
+		{"isochronous", EndpointTypeIsochronous, 1024, 1023},
+		{"isochronous high bandwidth", EndpointTypeIsochronous, 1<<11 | 192, 192},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep := &Endpoint{Address: 0x81, Attributes: tt.attributes, MaxPacketSize: tt.size}
+			if mps, _ := ep.SpeedParameters(SpeedFull); mps != tt.want {
+				t.Errorf("SpeedParameters(Full) = %d, want %d", mps, tt.want)
+			}
+
+			// Setting only the high speed parameters keeps a legal full
+			// speed size
+			if err := ep.SetSpeedParameters(SpeedHigh, tt.size, 1); err != nil {
+				t.Fatalf("SetSpeedParameters() error = %v", err)
+			}
+			if mps, _ := ep.SpeedParameters(SpeedFull); mps != tt.want {
+				t.Errorf("SpeedParameters(Full) after SetSpeedParameters(High) = %d, want %d", mps, tt.want)
+			}
+		})
+	}
+}
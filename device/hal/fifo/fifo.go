@@ -539,10 +539,19 @@ func (h *HAL) Write(ctx context.Context, address uint8, data []byte) (int, error
 		return 0, pkg.ErrInvalidEndpoint
 	}
 
-	if err := h.writePacket(ctx, f, data); err != nil {
-		return 0, err
+	// Data longer than MaxPacketSize is sent as several messages, as a
+	// transfer is split into packets on the bus.
+	sent := 0
+	for {
+		n := min(len(data)-sent, MaxPacketSize)
+		if err := h.writePacket(ctx, f, data[sent:sent+n]); err != nil {
+			return sent, err
+		}
+		sent += n
+		if sent == len(data) {
+			return sent, nil
+		}
 	}
-	return len(data), nil
 }
 
 // Stall stalls the specified endpoint.
@@ -25,6 +25,13 @@ type Interface struct {
 
 	// String descriptor index
 	StringIndex uint8
+
+	// Class-specific descriptors following the interface descriptor
+	classDescriptors []byte
+
+	// Alternate settings 1..N (setting 0 is this interface) - fixed-size array
+	alternates     [MaxAlternateSettings]*Interface
+	alternateCount int
 }
 
 // ClassDriver defines the interface for USB class-specific handling.
@@ -32,9 +39,16 @@ type ClassDriver interface {
 	// Init initializes the class driver for the interface.
 	Init(iface *Interface) error
 
-	// HandleSetup processes class-specific SETUP requests.
-	// Returns true if the request was handled, false otherwise.
-	HandleSetup(iface *Interface, setup *SetupPacket, data []byte) (bool, error)
+	// HandleSetup processes class and vendor SETUP requests to the
+	// interface or to one of its endpoints, and standard requests to the
+	// interface that the stack does not handle (such as HID
+	// GET_DESCRIPTOR). For OUT requests, data holds the data stage, which
+	// the stack reads before calling HandleSetup. For IN requests, the
+	// returned slice is sent as the data stage, truncated to the requested
+	// length; it may reference a buffer owned by the driver.
+	// Returns true if the request was handled, false otherwise. Handled
+	// requests that return an error are stalled.
+	HandleSetup(iface *Interface, setup *SetupPacket, data []byte) ([]byte, bool, error)
 
 	// SetAlternate is called when the alternate setting changes.
 	SetAlternate(iface *Interface, alt uint8) error
@@ -140,6 +154,94 @@ func (i *Interface) GetOutEndpoint(num uint8) *Endpoint {
 	return i.GetEndpoint(num & 0x0F)
 }
 
+// SetClassDescriptors sets the class-specific descriptors emitted after the
+// interface descriptor in the configuration descriptor. The slice is
+// referenced, not copied.
+func (i *Interface) SetClassDescriptors(data []byte) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.classDescriptors = data
+}
+
+// ClassDescriptors returns the class-specific interface descriptors.
+func (i *Interface) ClassDescriptors() []byte {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.classDescriptors
+}
+
+// AddAlternate adds an alternate setting to the interface.
+// The alternate takes this interface's number and the next setting value (1, 2, ...).
+// Alternate settings carry their own endpoints and class-specific descriptors;
+// class requests and SET_INTERFACE are handled by this interface's class driver.
+func (i *Interface) AddAlternate(alt *Interface) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.alternateCount >= MaxAlternateSettings {
+		return pkg.ErrNoMemory
+	}
+
+	alt.Number = i.Number
+	alt.AlternateSetting = uint8(i.alternateCount + 1)
+	i.alternates[i.alternateCount] = alt
+	i.alternateCount++
+	return nil
+}
+
+// Alternate returns the interface for the given alternate setting.
+// Setting 0 returns the interface itself. Returns nil if the setting does not exist.
+func (i *Interface) Alternate(setting uint8) *Interface {
+	if setting == 0 {
+		return i
+	}
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if int(setting) > i.alternateCount {
+		return nil
+	}
+	return i.alternates[setting-1]
+}
+
+// NumAlternates returns the number of alternate settings, including setting 0.
+func (i *Interface) NumAlternates() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.alternateCount + 1
+}
+
+// activeEndpoint returns the endpoint with the given address in the current
+// alternate setting.
+func (i *Interface) activeEndpoint(address uint8) *Endpoint {
+	i.mutex.RLock()
+	setting := i.AlternateSetting
+	i.mutex.RUnlock()
+
+	if alt := i.Alternate(setting); alt != nil {
+		return alt.GetEndpoint(address)
+	}
+	return nil
+}
+
+// findEndpoint returns the endpoint with the given address in any alternate setting.
+func (i *Interface) findEndpoint(address uint8) *Endpoint {
+	if ep := i.GetEndpoint(address); ep != nil {
+		return ep
+	}
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for idx := 0; idx < i.alternateCount; idx++ {
+		if ep := i.alternates[idx].GetEndpoint(address); ep != nil {
+			return ep
+		}
+	}
+	return nil
+}
+
 // SetClassDriver sets the class driver for this interface.
 func (i *Interface) SetClassDriver(driver ClassDriver) error {
 	i.mutex.Lock()
@@ -170,21 +272,28 @@ func (i *Interface) ClassDriver() ClassDriver {
 	return i.classDriver
 }
 
-// HandleSetup processes a class-specific SETUP request.
-func (i *Interface) HandleSetup(setup *SetupPacket, data []byte) (bool, error) {
+// HandleSetup processes a class-specific SETUP request with the OUT data
+// stage in data. Returns the IN data stage and whether the request was
+// handled.
+func (i *Interface) HandleSetup(setup *SetupPacket, data []byte) ([]byte, bool, error) {
 	i.mutex.RLock()
 	driver := i.classDriver
 	i.mutex.RUnlock()
 
 	if driver == nil {
-		return false, nil
+		return nil, false, nil
 	}
 	return driver.HandleSetup(i, setup, data)
 }
 
 // SetAlternate changes the alternate setting.
+// If the interface declares alternate settings, alt must be one of them.
 func (i *Interface) SetAlternate(alt uint8) error {
 	i.mutex.Lock()
+	if i.alternateCount > 0 && int(alt) > i.alternateCount {
+		i.mutex.Unlock()
+		return pkg.ErrInvalidRequest
+	}
 	i.AlternateSetting = alt
 	driver := i.classDriver
 	i.mutex.Unlock()
@@ -200,6 +309,11 @@ func (i *Interface) Descriptor() *InterfaceDescriptor {
 	i.mutex.RLock()
 	defer i.mutex.RUnlock()
 
+	return i.descriptorLocked()
+}
+
+// descriptorLocked returns the interface descriptor (caller must hold i.mutex).
+func (i *Interface) descriptorLocked() *InterfaceDescriptor {
 	return &InterfaceDescriptor{
 		Length:            InterfaceDescriptorSize,
 		DescriptorType:    DescriptorTypeInterface,
@@ -213,6 +327,130 @@ func (i *Interface) Descriptor() *InterfaceDescriptor {
 	}
 }
 
+// TotalLength returns the length of the interface descriptor together with
+// its class-specific descriptors, endpoints, and alternate settings.
+func (i *Interface) TotalLength() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	length := InterfaceDescriptorSize + len(i.classDescriptors)
+	for idx := 0; idx < i.endpointCount; idx++ {
+		length += i.endpoints[idx].TotalLength()
+	}
+	for idx := 0; idx < i.alternateCount; idx++ {
+		length += i.alternates[idx].TotalLength()
+	}
+	return length
+}
+
+// MarshalTo writes the interface descriptor followed by its class-specific
+// descriptors, endpoint descriptors, and alternate settings to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (i *Interface) MarshalTo(buf []byte) int {
+	return i.marshalTo(buf, speedCurrent)
+}
+
+// marshalTo writes the interface descriptors to buf, with the endpoint
+// parameters of speed, or those in effect if speedCurrent.
+func (i *Interface) marshalTo(buf []byte, speed Speed) int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	desc := i.descriptorLocked()
+	if i.alternateCount > 0 {
+		// The primary interface is always described as setting 0,
+		// whichever setting is currently selected
+		desc.AlternateSetting = 0
+	}
+
+	offset := desc.MarshalTo(buf)
+	if offset == 0 {
+		return 0
+	}
+
+	if len(buf[offset:]) < len(i.classDescriptors) {
+		return 0
+	}
+	offset += copy(buf[offset:], i.classDescriptors)
+
+	for idx := 0; idx < i.endpointCount; idx++ {
+		n := i.endpoints[idx].marshalTo(buf[offset:], speed)
+		if n == 0 {
+			return 0
+		}
+		offset += n
+	}
+
+	for idx := 0; idx < i.alternateCount; idx++ {
+		n := i.alternates[idx].marshalTo(buf[offset:], speed)
+		if n == 0 {
+			return 0
+		}
+		offset += n
+	}
+
+	return offset
+}
+
+// applySpeed applies the endpoint parameters of speed to the endpoints of
+// the interface and its alternate settings.
+func (i *Interface) applySpeed(speed Speed) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for idx := 0; idx < i.endpointCount; idx++ {
+		i.endpoints[idx].applySpeed(speed)
+	}
+	for idx := 0; idx < i.alternateCount; idx++ {
+		i.alternates[idx].applySpeed(speed)
+	}
+}
+
+// setEndpointSpeedParameters sets the per-speed parameters of the endpoint
+// with the given address in the interface and its alternate settings.
+// Returns true if the endpoint was found.
+func (i *Interface) setEndpointSpeedParameters(address uint8, speed Speed, maxPacketSize uint16, interval uint8) (bool, error) {
+	found := false
+	if ep := i.GetEndpoint(address); ep != nil {
+		if err := ep.SetSpeedParameters(speed, maxPacketSize, interval); err != nil {
+			return false, err
+		}
+		found = true
+	}
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for idx := 0; idx < i.alternateCount; idx++ {
+		if ep := i.alternates[idx].GetEndpoint(address); ep != nil {
+			if err := ep.SetSpeedParameters(speed, maxPacketSize, interval); err != nil {
+				return false, err
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// hasSpeedParameters reports whether any endpoint of the interface or its
+// alternate settings has per-speed parameters.
+func (i *Interface) hasSpeedParameters() bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for idx := 0; idx < i.endpointCount; idx++ {
+		if i.endpoints[idx].HasSpeedParameters() {
+			return true
+		}
+	}
+	for idx := 0; idx < i.alternateCount; idx++ {
+		if i.alternates[idx].hasSpeedParameters() {
+			return true
+		}
+	}
+	return false
+}
+
 // Close releases resources held by the interface.
 func (i *Interface) Close() error {
 	i.mutex.Lock()
@@ -385,11 +623,9 @@ func (c *Configuration) calculateTotalLength() uint16 {
 	// Add IAD lengths
 	length += uint16(c.associationCount) * IADSize
 
-	// Add interface and endpoint lengths
+	// Add interface, class-specific, and endpoint lengths
 	for idx := 0; idx < c.interfaceCount; idx++ {
-		iface := c.interfaces[idx]
-		length += InterfaceDescriptorSize                               // Interface descriptor
-		length += uint16(iface.NumEndpoints()) * EndpointDescriptorSize // Endpoint descriptors
+		length += uint16(c.interfaces[idx].TotalLength())
 	}
 
 	return length
@@ -398,6 +634,21 @@ func (c *Configuration) calculateTotalLength() uint16 {
 // MarshalTo writes the full configuration descriptor including all sub-descriptors to buf.
 // Returns the number of bytes written.
 func (c *Configuration) MarshalTo(buf []byte) int {
+	return c.marshalTo(buf, DescriptorTypeConfiguration, speedCurrent)
+}
+
+// MarshalOtherSpeedTo writes the other speed configuration descriptor,
+// which describes the configuration as it operates at speed, including all
+// sub-descriptors to buf.
+// Returns the number of bytes written.
+func (c *Configuration) MarshalOtherSpeedTo(buf []byte, speed Speed) int {
+	return c.marshalTo(buf, DescriptorTypeOtherSpeedConfig, speed)
+}
+
+// marshalTo writes the configuration descriptor of type descType, with
+// the endpoint parameters of speed, or those in effect if speedCurrent,
+// to buf.
+func (c *Configuration) marshalTo(buf []byte, descType uint8, speed Speed) int {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
@@ -408,6 +659,7 @@ func (c *Configuration) MarshalTo(buf []byte) int {
 	if n == 0 {
 		return 0
 	}
+	buf[1] = descType
 	offset += n
 
 	// Interface associations (must come before interfaces)
@@ -432,23 +684,60 @@ func (c *Configuration) MarshalTo(buf []byte) int {
 
 	// Interfaces and their endpoints
 	for idx := 0; idx < c.interfaceCount; idx++ {
-		iface := c.interfaces[idx]
-		n = iface.Descriptor().MarshalTo(buf[offset:])
+		n = c.interfaces[idx].marshalTo(buf[offset:], speed)
 		if n == 0 {
 			return 0
 		}
 		offset += n
+	}
 
-		for _, ep := range iface.Endpoints() {
-			n = ep.Descriptor().MarshalTo(buf[offset:])
-			if n == 0 {
-				return 0
-			}
-			offset += n
+	return offset
+}
+
+// SetEndpointSpeedParameters sets the maximum packet size and polling
+// interval of the endpoint with the given address at speed. See
+// [Endpoint.SetSpeedParameters].
+// Returns pkg.ErrInvalidEndpoint if no interface has the endpoint.
+func (c *Configuration) SetEndpointSpeedParameters(address uint8, speed Speed, maxPacketSize uint16, interval uint8) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	found := false
+	for idx := 0; idx < c.interfaceCount; idx++ {
+		ok, err := c.interfaces[idx].setEndpointSpeedParameters(address, speed, maxPacketSize, interval)
+		if err != nil {
+			return err
 		}
+		found = found || ok
+	}
+	if !found {
+		return pkg.ErrInvalidEndpoint
 	}
+	return nil
+}
 
-	return offset
+// applySpeed applies the endpoint parameters of speed to all endpoints.
+func (c *Configuration) applySpeed(speed Speed) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for idx := 0; idx < c.interfaceCount; idx++ {
+		c.interfaces[idx].applySpeed(speed)
+	}
+}
+
+// hasSpeedParameters reports whether any endpoint has per-speed
+// parameters.
+func (c *Configuration) hasSpeedParameters() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for idx := 0; idx < c.interfaceCount; idx++ {
+		if c.interfaces[idx].hasSpeedParameters() {
+			return true
+		}
+	}
+	return false
 }
 
 // SetSelfPowered sets or clears the self-powered attribute.
@@ -1,7 +1,11 @@
 package device
 
 import (
+	"bytes"
+	"encoding/binary"
 	"testing"
+
+	"github.com/ardnew/softusb/pkg"
 )
 
 func TestNewInterface(t *testing.T) {
@@ -124,6 +128,8 @@ type mockClassDriver struct {
 	altCalled       bool
 	closeCalled     bool
 	handleSetupResp bool
+	response        []byte
+	data            []byte
 }
 
 func (m *mockClassDriver) Init(iface *Interface) error {
@@ -131,9 +137,10 @@ func (m *mockClassDriver) Init(iface *Interface) error {
 	return nil
 }
 
-func (m *mockClassDriver) HandleSetup(iface *Interface, setup *SetupPacket, data []byte) (bool, error) {
+func (m *mockClassDriver) HandleSetup(iface *Interface, setup *SetupPacket, data []byte) ([]byte, bool, error) {
 	m.setupCalled = true
-	return m.handleSetupResp, nil
+	m.data = append(m.data[:0], data...)
+	return m.response, m.handleSetupResp, nil
 }
 
 func (m *mockClassDriver) SetAlternate(iface *Interface, alt uint8) error {
@@ -160,7 +167,7 @@ func TestInterfaceClassDriver(t *testing.T) {
 	}
 
 	// Test HandleSetup
-	handled, err := iface.HandleSetup(&SetupPacket{}, nil)
+	_, handled, err := iface.HandleSetup(&SetupPacket{}, nil)
 	if err != nil {
 		t.Fatalf("HandleSetup() error = %v", err)
 	}
@@ -193,7 +200,7 @@ func TestInterfaceClassDriver(t *testing.T) {
 func TestInterfaceHandleSetupNoDriver(t *testing.T) {
 	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0})
 
-	handled, err := iface.HandleSetup(&SetupPacket{}, nil)
+	_, handled, err := iface.HandleSetup(&SetupPacket{}, nil)
 	if err != nil {
 		t.Fatalf("HandleSetup() error = %v", err)
 	}
@@ -725,6 +732,103 @@ func TestConfigurationMarshalToEdgeCases(t *testing.T) {
 	})
 }
 
+func TestInterfaceAlternates(t *testing.T) {
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 2, InterfaceClass: 0x01, InterfaceSubClass: 0x02})
+
+	if iface.NumAlternates() != 1 {
+		t.Errorf("NumAlternates() = %d, want 1", iface.NumAlternates())
+	}
+	if iface.Alternate(0) != iface {
+		t.Error("Alternate(0) should return the interface itself")
+	}
+
+	alt := NewInterface(&InterfaceDescriptor{InterfaceClass: 0x01, InterfaceSubClass: 0x02})
+	alt.AddEndpoint(&Endpoint{Address: 0x81, Attributes: EndpointTypeIsochronous, MaxPacketSize: 96})
+	if err := iface.AddAlternate(alt); err != nil {
+		t.Fatalf("AddAlternate() error = %v", err)
+	}
+
+	if alt.Number != 2 || alt.AlternateSetting != 1 {
+		t.Errorf("alternate = (%d, %d), want (2, 1)", alt.Number, alt.AlternateSetting)
+	}
+	if iface.Alternate(1) != alt {
+		t.Error("Alternate(1) did not return the added alternate")
+	}
+	if iface.Alternate(2) != nil {
+		t.Error("Alternate(2) should be nil")
+	}
+	if iface.findEndpoint(0x81) == nil {
+		t.Error("findEndpoint() did not find endpoint in alternate setting")
+	}
+
+	if err := iface.SetAlternate(1); err != nil {
+		t.Errorf("SetAlternate(1) error = %v", err)
+	}
+	if err := iface.SetAlternate(2); err != pkg.ErrInvalidRequest {
+		t.Errorf("SetAlternate(2) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+
+	for i := 1; i < MaxAlternateSettings; i++ {
+		iface.AddAlternate(NewInterface(&InterfaceDescriptor{}))
+	}
+	if err := iface.AddAlternate(NewInterface(&InterfaceDescriptor{})); err != pkg.ErrNoMemory {
+		t.Errorf("AddAlternate() when full error = %v, want %v", err, pkg.ErrNoMemory)
+	}
+}
+
+func TestConfigurationMarshalToAlternates(t *testing.T) {
+	config := NewConfiguration(1)
+
+	class := []byte{0x07, 0x24, 0x01, 0x01, 0x01, 0x01, 0x00}
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0, InterfaceClass: 0x01})
+	alt := NewInterface(&InterfaceDescriptor{InterfaceClass: 0x01})
+	alt.SetClassDescriptors(class)
+	ep := NewEndpoint(&EndpointDescriptor{
+		EndpointAddress: 0x01,
+		Attributes:      EndpointTypeIsochronous,
+		MaxPacketSize:   192,
+		Interval:        1,
+	})
+	ep.SetDescriptorExtension([]byte{0x00, 0x00})
+	alt.AddEndpoint(ep)
+	iface.AddAlternate(alt)
+	config.AddInterface(iface)
+
+	// A selected alternate must not change the primary descriptor
+	iface.SetAlternate(1)
+
+	var buf [128]byte
+	n := config.MarshalTo(buf[:])
+	// 9 (config) + 9 (alt 0) + 9 (alt 1) + 7 (class) + 9 (audio ep) = 43
+	expected := 9 + 9 + 9 + len(class) + 9
+	if n != expected {
+		t.Fatalf("MarshalTo() = %d, want %d", n, expected)
+	}
+
+	totalLen := int(buf[2]) | int(buf[3])<<8
+	if totalLen != expected {
+		t.Errorf("TotalLength = %d, want %d", totalLen, expected)
+	}
+	if buf[4] != 1 {
+		t.Errorf("NumInterfaces = %d, want 1", buf[4])
+	}
+
+	// alt 0 interface descriptor
+	if buf[9+3] != 0 || buf[9+4] != 0 {
+		t.Errorf("alt 0 = (setting %d, %d endpoints), want (0, 0)", buf[9+3], buf[9+4])
+	}
+	// alt 1 interface descriptor
+	if buf[18+3] != 1 || buf[18+4] != 1 {
+		t.Errorf("alt 1 = (setting %d, %d endpoints), want (1, 1)", buf[18+3], buf[18+4])
+	}
+	if buf[27+1] != 0x24 {
+		t.Errorf("class descriptor type = 0x%02X, want 0x24", buf[27+1])
+	}
+	if buf[34] != 9 {
+		t.Errorf("endpoint bLength = %d, want 9", buf[34])
+	}
+}
+
 func TestInterfaceConcurrentAccess(t *testing.T) {
 	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0})
 	for i := uint8(1); i <= 4; i++ {
@@ -894,7 +998,7 @@ func BenchmarkInterfaceHandleSetup(b *testing.B) {
 		b.ReportAllocs()
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
-			_, _ = iface.HandleSetup(setup, nil)
+			_, _, _ = iface.HandleSetup(setup, nil)
 		}
 	})
 
@@ -905,7 +1009,7 @@ func BenchmarkInterfaceHandleSetup(b *testing.B) {
 		b.ReportAllocs()
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
-			_, _ = iface.HandleSetup(setup, nil)
+			_, _, _ = iface.HandleSetup(setup, nil)
 		}
 	})
 }
@@ -1117,3 +1221,97 @@ func BenchmarkConfigurationClose(b *testing.B) {
 		_ = config.Close()
 	}
 }
+
+func TestConfigurationMarshalOtherSpeedTo(t *testing.T) {
+	config := NewConfiguration(1)
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0, InterfaceClass: ClassMassStorage})
+	iface.AddEndpoint(&Endpoint{Address: 0x81, Attributes: EndpointTypeBulk, MaxPacketSize: 512})
+	iface.AddEndpoint(&Endpoint{Address: 0x02, Attributes: EndpointTypeBulk, MaxPacketSize: 512})
+	config.AddInterface(iface)
+
+	alt := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0, AlternateSetting: 1})
+	alt.AddEndpoint(&Endpoint{Address: 0x81, Attributes: EndpointTypeBulk, MaxPacketSize: 512})
+	if err := iface.AddAlternate(alt); err != nil {
+		t.Fatalf("AddAlternate() error = %v", err)
+	}
+
+	for _, addr := range []uint8{0x81, 0x02} {
+		if err := config.SetEndpointSpeedParameters(addr, SpeedFull, 64, 0); err != nil {
+			t.Fatalf("SetEndpointSpeedParameters(0x%02X) error = %v", addr, err)
+		}
+	}
+	if err := config.SetEndpointSpeedParameters(0x83, SpeedFull, 64, 0); err != pkg.ErrInvalidEndpoint {
+		t.Errorf("SetEndpointSpeedParameters(unknown) error = %v, want %v", err, pkg.ErrInvalidEndpoint)
+	}
+	if !config.hasSpeedParameters() {
+		t.Error("hasSpeedParameters() should be true")
+	}
+
+	var current, other [512]byte
+	n := config.MarshalTo(current[:])
+	if m := config.MarshalOtherSpeedTo(other[:], SpeedFull); m != n {
+		t.Fatalf("MarshalOtherSpeedTo() = %d, want %d", m, n)
+	}
+	if other[1] != DescriptorTypeOtherSpeedConfig {
+		t.Errorf("descriptor type = 0x%02X, want 0x%02X", other[1], DescriptorTypeOtherSpeedConfig)
+	}
+
+	// Endpoint descriptors (including the alternate setting's) carry the
+	// full speed packet size; everything else is unchanged
+	endpoints := 0
+	for off := 0; off < n; off += int(other[off]) {
+		if other[off+1] != DescriptorTypeEndpoint {
+			if off > 0 && !bytes.Equal(current[off:off+int(current[off])], other[off:off+int(other[off])]) {
+				t.Errorf("descriptor at %d differs: % X vs % X", off, current[off:off+int(current[off])], other[off:off+int(other[off])])
+			}
+			continue
+		}
+		endpoints++
+		if mps := binary.LittleEndian.Uint16(current[off+4:]); mps != 512 {
+			t.Errorf("current max packet size at %d = %d, want 512", off, mps)
+		}
+		if mps := binary.LittleEndian.Uint16(other[off+4:]); mps != 64 {
+			t.Errorf("other speed max packet size at %d = %d, want 64", off, mps)
+		}
+	}
+	if endpoints != 3 {
+		t.Errorf("endpoint descriptors = %d, want 3", endpoints)
+	}
+
+	config.applySpeed(SpeedFull)
+	if ep := iface.GetEndpoint(0x81); ep.MaxPacketSize != 64 {
+		t.Errorf("MaxPacketSize after applySpeed(Full) = %d, want 64", ep.MaxPacketSize)
+	}
+	if ep := alt.GetEndpoint(0x81); ep.MaxPacketSize != 64 {
+		t.Errorf("alternate MaxPacketSize after applySpeed(Full) = %d, want 64", ep.MaxPacketSize)
+	}
+}
+
+func TestConfigurationMarshalOtherSpeedToWithoutParameters(t *testing.T) {
+	config := NewConfiguration(1)
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0})
+	iface.AddEndpoint(&Endpoint{Address: 0x81, Attributes: EndpointTypeBulk, MaxPacketSize: 512})
+	iface.AddEndpoint(&Endpoint{Address: 0x82, Attributes: EndpointTypeInterrupt, MaxPacketSize: 1024, Interval: 4})
+	config.AddInterface(iface)
+
+	// A high speed configuration without full speed parameters still
+	// describes full speed packet sizes at the other speed
+	var buf [128]byte
+	n := config.MarshalOtherSpeedTo(buf[:], SpeedFull)
+	if n == 0 {
+		t.Fatal("MarshalOtherSpeedTo() = 0")
+	}
+	endpoints := 0
+	for off := 0; off < n; off += int(buf[off]) {
+		if buf[off+1] != DescriptorTypeEndpoint {
+			continue
+		}
+		endpoints++
+		if mps := binary.LittleEndian.Uint16(buf[off+4:]); mps != 64 {
+			t.Errorf("endpoint 0x%02X max packet size = %d, want 64", buf[off+2], mps)
+		}
+	}
+	if endpoints != 2 {
+		t.Errorf("endpoint descriptors = %d, want 2", endpoints)
+	}
+}
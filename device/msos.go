@@ -0,0 +1,506 @@
+package device
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// MS OS 2.0 descriptor types.
+const (
+	MSOS20SetHeader             = 0x00 // Descriptor set header
+	MSOS20SubsetConfiguration   = 0x01 // Configuration subset header
+	MSOS20SubsetFunction        = 0x02 // Function subset header
+	MSOS20FeatureCompatibleID   = 0x03 // Compatible ID
+	MSOS20FeatureRegProperty    = 0x04 // Registry property
+	MSOS20FeatureMinResumeTime  = 0x05 // Minimum USB resume time
+	MSOS20FeatureModelID        = 0x06 // Model ID
+	MSOS20FeatureCCGPDevice     = 0x07 // CCGP device
+	MSOS20FeatureVendorRevision = 0x08 // Vendor revision
+)
+
+// MS OS descriptor request indexes (wIndex of the vendor request).
+const (
+	MSOS20DescriptorIndex    = 0x07 // MS OS 2.0 descriptor set
+	MSOS20SetAltEnumeration  = 0x08 // MS OS 2.0 alternate enumeration
+	MSOS10ExtendedCompatID   = 0x04 // MS OS 1.0 extended compat ID descriptor
+	MSOS10ExtendedProperties = 0x05 // MS OS 1.0 extended properties descriptor
+)
+
+// MSOS10StringIndex is the index of the MS OS 1.0 string descriptor.
+const MSOS10StringIndex = 0xEE
+
+// MSOSWindowsVersion81 is the NTDDI version of Windows 8.1, the first
+// version that reads MS OS 2.0 descriptors.
+const MSOSWindowsVersion81 = 0x06030000
+
+// MS OS descriptor sizes.
+const (
+	msos20SetHeaderSize        = 10 // Descriptor set header
+	msos20SubsetHeaderSize     = 8  // Configuration or function subset header
+	msos20CompatibleIDSize     = 20 // Compatible ID feature
+	msos20PropertyHeaderSize   = 10 // Registry property, excluding name and data
+	msos10StringSize           = 18 // OS string descriptor
+	msos10CompatIDHeaderSize   = 16 // Extended compat ID header
+	msos10CompatIDFunctionSize = 24 // Extended compat ID function section
+	msos10PropertiesHeaderSize = 10 // Extended properties header
+	msos10PropertyHeaderSize   = 14 // Property section, excluding name and data
+)
+
+// Registry property data types.
+const (
+	RegSZ                = 1 // NUL-terminated Unicode string
+	RegExpandSZ          = 2 // NUL-terminated Unicode string with environment variables
+	RegBinary            = 3 // Binary data
+	RegDWordLittleEndian = 4 // Little-endian 32-bit integer
+	RegDWordBigEndian    = 5 // Big-endian 32-bit integer
+	RegLink              = 6 // NUL-terminated Unicode symbolic link
+	RegMultiSZ           = 7 // Multiple NUL-terminated Unicode strings
+)
+
+// MSOS20PlatformUUID identifies the MS OS 2.0 platform capability
+// ({D8DD60DF-4589-4CC7-9CD2-659D9E648A9F}).
+var MSOS20PlatformUUID = [16]byte{
+	0xDF, 0x60, 0xDD, 0xD8, 0x89, 0x45, 0xC7, 0x4C,
+	0x9C, 0xD2, 0x65, 0x9D, 0x9E, 0x64, 0x8A, 0x9F,
+}
+
+// msosProperty is a registry property.
+type msosProperty struct {
+	dataType uint16
+	name     []byte // UTF-16LE, NUL-terminated
+	data     []byte
+}
+
+// msosFunction holds the features of the whole device or of a function.
+type msosFunction struct {
+	firstInterface  uint8
+	compatibleID    [8]byte
+	subCompatibleID [8]byte
+	hasCompatibleID bool
+	properties      [MaxMSOSProperties]msosProperty
+	propertyCount   int
+}
+
+// MSOS20DescriptorSet builds Microsoft OS 2.0 descriptors, which let
+// Windows load a driver such as WinUSB without an INF file, and answers
+// the vendor request that retrieves them.
+//
+// Features added before the first call to Function apply to the whole
+// device. Composite devices describe each function in a function subset
+// instead:
+//
+//	set := device.NewMSOS20DescriptorSet(0x20).
+//	    Function(2).
+//	    CompatibleID("WINUSB", "").
+//	    DeviceInterfaceGUIDs("{88BAE032-5A81-49F0-BC3D-A4FF138216D6}")
+//
+// Configure the set before the device starts, and register it with
+// [DeviceBuilder.WithMSOS20Descriptors].
+type MSOS20DescriptorSet struct {
+	vendorCode     uint8
+	windowsVersion uint32
+	msos10         bool
+
+	device        msosFunction
+	functions     [MaxMSOSFunctions]msosFunction
+	functionCount int
+	current       *msosFunction
+
+	errors []error
+
+	// Response buffer
+	buf [MaxMSOSDescriptorSetSize]byte
+}
+
+// NewMSOS20DescriptorSet creates an MS OS 2.0 descriptor set retrieved with
+// the given vendor request code, for Windows 8.1 and later.
+func NewMSOS20DescriptorSet(vendorCode uint8) *MSOS20DescriptorSet {
+	s := &MSOS20DescriptorSet{
+		vendorCode:     vendorCode,
+		windowsVersion: MSOSWindowsVersion81,
+	}
+	s.current = &s.device
+	return s
+}
+
+// WithWindowsVersion sets the minimum Windows version (NTDDI_*) the
+// descriptor set applies to.
+func (s *MSOS20DescriptorSet) WithWindowsVersion(version uint32) *MSOS20DescriptorSet {
+	s.windowsVersion = version
+	return s
+}
+
+// WithMSOS10 also answers the MS OS 1.0 string descriptor, extended compat
+// ID, and extended properties requests with the same features, for
+// Windows versions that do not read the BOS descriptor.
+func (s *MSOS20DescriptorSet) WithMSOS10() *MSOS20DescriptorSet {
+	s.msos10 = true
+	return s
+}
+
+// Function starts a function subset for the function whose first
+// interface is firstInterface. Following features apply to that function.
+func (s *MSOS20DescriptorSet) Function(firstInterface uint8) *MSOS20DescriptorSet {
+	if s.functionCount >= MaxMSOSFunctions {
+		s.errors = append(s.errors, pkg.ErrNoResources)
+		return s
+	}
+	s.current = &s.functions[s.functionCount]
+	s.current.firstInterface = firstInterface
+	s.functionCount++
+	return s
+}
+
+// CompatibleID sets the compatible ID (e.g. "WINUSB") and sub-compatible
+// ID of the device or the current function. Each ID has at most 8 ASCII
+// characters.
+func (s *MSOS20DescriptorSet) CompatibleID(compatibleID, subCompatibleID string) *MSOS20DescriptorSet {
+	if !msosValidID(compatibleID) || !msosValidID(subCompatibleID) || s.current.hasCompatibleID {
+		s.errors = append(s.errors, pkg.ErrInvalidParameter)
+		return s
+	}
+	copy(s.current.compatibleID[:], compatibleID)
+	copy(s.current.subCompatibleID[:], subCompatibleID)
+	s.current.hasCompatibleID = true
+	return s
+}
+
+// RegistryProperty adds a registry property of the device or the current
+// function. dataType is one of the Reg* constants, and data is stored by
+// reference (not copied).
+func (s *MSOS20DescriptorSet) RegistryProperty(dataType uint16, name string, data []byte) *MSOS20DescriptorSet {
+	if dataType < RegSZ || dataType > RegMultiSZ || name == "" {
+		s.errors = append(s.errors, pkg.ErrInvalidParameter)
+		return s
+	}
+	f := s.current
+	if f.propertyCount >= MaxMSOSProperties {
+		s.errors = append(s.errors, pkg.ErrNoResources)
+		return s
+	}
+	f.properties[f.propertyCount] = msosProperty{
+		dataType: dataType,
+		name:     msosUTF16(name),
+		data:     data,
+	}
+	f.propertyCount++
+	return s
+}
+
+// DeviceInterfaceGUIDs adds the DeviceInterfaceGUIDs registry property,
+// through which applications find a WinUSB device, to the device or the
+// current function. Each GUID is given in registry format, including
+// braces.
+func (s *MSOS20DescriptorSet) DeviceInterfaceGUIDs(guids ...string) *MSOS20DescriptorSet {
+	var data []byte
+	for _, guid := range guids {
+		data = append(data, msosUTF16(guid)...)
+	}
+	data = append(data, 0, 0)
+	return s.RegistryProperty(RegMultiSZ, "DeviceInterfaceGUIDs", data)
+}
+
+// Err returns the first error encountered while building the set.
+func (s *MSOS20DescriptorSet) Err() error {
+	if len(s.errors) > 0 {
+		return s.errors[0]
+	}
+	return nil
+}
+
+// VendorCode returns the vendor request code that retrieves the set.
+func (s *MSOS20DescriptorSet) VendorCode() uint8 {
+	return s.vendorCode
+}
+
+// MSOS10 reports whether MS OS 1.0 requests are answered.
+func (s *MSOS20DescriptorSet) MSOS10() bool {
+	return s.msos10
+}
+
+// Capability returns the MS OS 2.0 platform capability that advertises
+// the set in the BOS descriptor.
+func (s *MSOS20DescriptorSet) Capability() DeviceCapability {
+	return msos20Capability{set: s}
+}
+
+// size returns the size of the MS OS 2.0 descriptor set in bytes.
+func (s *MSOS20DescriptorSet) size() int {
+	n := msos20SetHeaderSize + s.device.size20()
+	if s.functionCount > 0 {
+		n += msos20SubsetHeaderSize
+		for idx := 0; idx < s.functionCount; idx++ {
+			n += msos20SubsetHeaderSize + s.functions[idx].size20()
+		}
+	}
+	return n
+}
+
+// MarshalTo serializes the MS OS 2.0 descriptor set to buf. Functions are
+// described in a subset of the first configuration.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (s *MSOS20DescriptorSet) MarshalTo(buf []byte) int {
+	total := s.size()
+	if total > 0xFFFF || len(buf) < total {
+		return 0
+	}
+
+	binary.LittleEndian.PutUint16(buf[0:2], msos20SetHeaderSize)
+	binary.LittleEndian.PutUint16(buf[2:4], MSOS20SetHeader)
+	binary.LittleEndian.PutUint32(buf[4:8], s.windowsVersion)
+	binary.LittleEndian.PutUint16(buf[8:10], uint16(total))
+	offset := msos20SetHeaderSize
+	offset += s.device.marshal20To(buf[offset:])
+
+	if s.functionCount == 0 {
+		return offset
+	}
+
+	binary.LittleEndian.PutUint16(buf[offset:], msos20SubsetHeaderSize)
+	binary.LittleEndian.PutUint16(buf[offset+2:], MSOS20SubsetConfiguration)
+	buf[offset+4] = 0 // Configuration index
+	buf[offset+5] = 0 // Reserved
+	binary.LittleEndian.PutUint16(buf[offset+6:], uint16(total-offset))
+	offset += msos20SubsetHeaderSize
+
+	for idx := 0; idx < s.functionCount; idx++ {
+		f := &s.functions[idx]
+		binary.LittleEndian.PutUint16(buf[offset:], msos20SubsetHeaderSize)
+		binary.LittleEndian.PutUint16(buf[offset+2:], MSOS20SubsetFunction)
+		buf[offset+4] = f.firstInterface
+		buf[offset+5] = 0 // Reserved
+		binary.LittleEndian.PutUint16(buf[offset+6:], uint16(msos20SubsetHeaderSize+f.size20()))
+		offset += msos20SubsetHeaderSize
+		offset += f.marshal20To(buf[offset:])
+	}
+	return offset
+}
+
+// HandleSetup answers the vendor request for the MS OS 2.0 descriptor set
+// and, if enabled, the MS OS 1.0 requests.
+func (s *MSOS20DescriptorSet) HandleSetup(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+	var n int
+
+	switch {
+	case setup.IsVendor() && setup.IsDeviceToHost() && setup.Request == s.vendorCode:
+		switch {
+		case setup.Index == MSOS20DescriptorIndex && setup.IsDeviceRecipient():
+			n = s.MarshalTo(s.buf[:])
+		case setup.Index == MSOS10ExtendedCompatID && s.msos10:
+			n = s.compatIDTo(s.buf[:])
+		case setup.Index == MSOS10ExtendedProperties && s.msos10:
+			// wValue holds the interface number and page (always 0)
+			if setup.Value>>8 != 0 {
+				return nil, true, pkg.ErrInvalidRequest
+			}
+			n = s.propertiesTo(s.buf[:], uint8(setup.Value))
+		default:
+			return nil, false, nil
+		}
+
+	case s.msos10 && setup.IsStandard() && setup.IsDeviceRecipient() &&
+		setup.Request == RequestGetDescriptor &&
+		setup.DescriptorType() == DescriptorTypeString &&
+		setup.DescriptorIndex() == MSOS10StringIndex:
+		n = s.stringTo(s.buf[:])
+
+	default:
+		return nil, false, nil
+	}
+
+	if n == 0 {
+		return nil, true, pkg.ErrInvalidRequest
+	}
+
+	pkg.LogDebug(pkg.ComponentDevice, "MS OS descriptor sent",
+		"index", setup.Index,
+		"length", n)
+
+	return s.buf[:n], true, nil
+}
+
+// stringTo writes the MS OS 1.0 string descriptor ("MSFT100" and the
+// vendor code) to buf.
+func (s *MSOS20DescriptorSet) stringTo(buf []byte) int {
+	if len(buf) < msos10StringSize {
+		return 0
+	}
+	buf[0] = msos10StringSize
+	buf[1] = DescriptorTypeString
+	for i, c := range "MSFT100" {
+		binary.LittleEndian.PutUint16(buf[2+i*2:], uint16(c))
+	}
+	buf[16] = s.vendorCode
+	buf[17] = 0 // Pad
+	return msos10StringSize
+}
+
+// compatIDTo writes the MS OS 1.0 extended compat ID descriptor to buf.
+// Features of the whole device are reported for interface 0.
+func (s *MSOS20DescriptorSet) compatIDTo(buf []byte) int {
+	count := 0
+	if s.device.hasCompatibleID {
+		count++
+	}
+	for idx := 0; idx < s.functionCount; idx++ {
+		if s.functions[idx].hasCompatibleID {
+			count++
+		}
+	}
+	total := msos10CompatIDHeaderSize + count*msos10CompatIDFunctionSize
+	if count == 0 || len(buf) < total {
+		return 0
+	}
+
+	clear(buf[:total])
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(total))
+	binary.LittleEndian.PutUint16(buf[4:6], 0x0100) // bcdVersion
+	binary.LittleEndian.PutUint16(buf[6:8], MSOS10ExtendedCompatID)
+	buf[8] = uint8(count)
+	offset := msos10CompatIDHeaderSize
+
+	put := func(f *msosFunction) {
+		if !f.hasCompatibleID {
+			return
+		}
+		buf[offset] = f.firstInterface
+		buf[offset+1] = 0x01 // Reserved
+		copy(buf[offset+2:offset+10], f.compatibleID[:])
+		copy(buf[offset+10:offset+18], f.subCompatibleID[:])
+		offset += msos10CompatIDFunctionSize
+	}
+	put(&s.device)
+	for idx := 0; idx < s.functionCount; idx++ {
+		put(&s.functions[idx])
+	}
+	return offset
+}
+
+// propertiesTo writes the MS OS 1.0 extended properties descriptor of the
+// function whose first interface is iface to buf. Properties of the whole
+// device are reported for interface 0 if no function starts there.
+func (s *MSOS20DescriptorSet) propertiesTo(buf []byte, iface uint8) int {
+	var f *msosFunction
+	for idx := 0; idx < s.functionCount; idx++ {
+		if s.functions[idx].firstInterface == iface {
+			f = &s.functions[idx]
+			break
+		}
+	}
+	if f == nil && iface == 0 {
+		f = &s.device
+	}
+	if f == nil || f.propertyCount == 0 {
+		return 0
+	}
+
+	total := msos10PropertiesHeaderSize
+	for idx := 0; idx < f.propertyCount; idx++ {
+		p := &f.properties[idx]
+		total += msos10PropertyHeaderSize + len(p.name) + len(p.data)
+	}
+	if len(buf) < total {
+		return 0
+	}
+
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(total))
+	binary.LittleEndian.PutUint16(buf[4:6], 0x0100) // bcdVersion
+	binary.LittleEndian.PutUint16(buf[6:8], MSOS10ExtendedProperties)
+	binary.LittleEndian.PutUint16(buf[8:10], uint16(f.propertyCount))
+	offset := msos10PropertiesHeaderSize
+
+	for idx := 0; idx < f.propertyCount; idx++ {
+		p := &f.properties[idx]
+		size := msos10PropertyHeaderSize + len(p.name) + len(p.data)
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(size))
+		binary.LittleEndian.PutUint32(buf[offset+4:], uint32(p.dataType))
+		binary.LittleEndian.PutUint16(buf[offset+8:], uint16(len(p.name)))
+		n := offset + 10 + copy(buf[offset+10:], p.name)
+		binary.LittleEndian.PutUint32(buf[n:], uint32(len(p.data)))
+		copy(buf[n+4:], p.data)
+		offset += size
+	}
+	return offset
+}
+
+// size20 returns the size of the MS OS 2.0 feature descriptors of f.
+func (f *msosFunction) size20() int {
+	n := 0
+	if f.hasCompatibleID {
+		n += msos20CompatibleIDSize
+	}
+	for idx := 0; idx < f.propertyCount; idx++ {
+		n += msos20PropertyHeaderSize + len(f.properties[idx].name) + len(f.properties[idx].data)
+	}
+	return n
+}
+
+// marshal20To writes the MS OS 2.0 feature descriptors of f to buf, which
+// must hold size20 bytes.
+// Returns the number of bytes written.
+func (f *msosFunction) marshal20To(buf []byte) int {
+	offset := 0
+	if f.hasCompatibleID {
+		binary.LittleEndian.PutUint16(buf[0:2], msos20CompatibleIDSize)
+		binary.LittleEndian.PutUint16(buf[2:4], MSOS20FeatureCompatibleID)
+		copy(buf[4:12], f.compatibleID[:])
+		copy(buf[12:20], f.subCompatibleID[:])
+		offset = msos20CompatibleIDSize
+	}
+	for idx := 0; idx < f.propertyCount; idx++ {
+		p := &f.properties[idx]
+		size := msos20PropertyHeaderSize + len(p.name) + len(p.data)
+		binary.LittleEndian.PutUint16(buf[offset:], uint16(size))
+		binary.LittleEndian.PutUint16(buf[offset+2:], MSOS20FeatureRegProperty)
+		binary.LittleEndian.PutUint16(buf[offset+4:], p.dataType)
+		binary.LittleEndian.PutUint16(buf[offset+6:], uint16(len(p.name)))
+		n := offset + 8 + copy(buf[offset+8:], p.name)
+		binary.LittleEndian.PutUint16(buf[n:], uint16(len(p.data)))
+		copy(buf[n+2:], p.data)
+		offset += size
+	}
+	return offset
+}
+
+// msos20Capability is the MS OS 2.0 platform capability of a descriptor
+// set.
+type msos20Capability struct {
+	set *MSOS20DescriptorSet
+}
+
+// MarshalTo serializes the MS OS 2.0 platform capability to buf.
+func (c msos20Capability) MarshalTo(buf []byte) int {
+	var data [8]byte
+	binary.LittleEndian.PutUint32(data[0:4], c.set.windowsVersion)
+	binary.LittleEndian.PutUint16(data[4:6], uint16(c.set.size()))
+	data[6] = c.set.vendorCode
+	data[7] = 0 // No alternate enumeration
+	desc := PlatformCapabilityDescriptor{UUID: MSOS20PlatformUUID, Data: data[:]}
+	return desc.MarshalTo(buf)
+}
+
+// msosValidID reports whether id is a valid compatible or sub-compatible
+// ID.
+func msosValidID(id string) bool {
+	if len(id) > 8 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x20 || id[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// msosUTF16 encodes s as a NUL-terminated UTF-16LE string.
+func msosUTF16(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	buf := make([]byte, (len(units)+1)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[i*2:], u)
+	}
+	return buf
+}
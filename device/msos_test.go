@@ -0,0 +1,347 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+const testInterfaceGUID = "{88BAE032-5A81-49F0-BC3D-A4FF138216D6}"
+
+// msosSetup returns an MS OS vendor request.
+func msosSetup(vendorCode uint8, recipient uint8, value, index, length uint16) *SetupPacket {
+	return &SetupPacket{
+		RequestType: RequestDirectionDeviceToHost | RequestTypeVendor | recipient,
+		Request:     vendorCode,
+		Value:       value,
+		Index:       index,
+		Length:      length,
+	}
+}
+
+func TestMSOS20DescriptorSetDevice(t *testing.T) {
+	set := NewMSOS20DescriptorSet(0x20).
+		CompatibleID("WINUSB", "").
+		DeviceInterfaceGUIDs(testInterfaceGUID)
+	if err := set.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	var buf [512]byte
+	n := set.MarshalTo(buf[:])
+	if n != 0xA2 {
+		t.Fatalf("MarshalTo() = %d, want %d", n, 0xA2)
+	}
+
+	// Descriptor set header
+	want := []byte{0x0A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x06, 0xA2, 0x00}
+	if !bytes.Equal(buf[:10], want) {
+		t.Errorf("set header = % X, want % X", buf[:10], want)
+	}
+
+	// Compatible ID feature
+	want = []byte{0x14, 0x00, 0x03, 0x00, 'W', 'I', 'N', 'U', 'S', 'B', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(buf[10:30], want) {
+		t.Errorf("compatible ID = % X, want % X", buf[10:30], want)
+	}
+
+	// Registry property feature
+	prop := buf[30:n]
+	if got := binary.LittleEndian.Uint16(prop[0:2]); got != 0x84 {
+		t.Errorf("property wLength = %d, want %d", got, 0x84)
+	}
+	if got := binary.LittleEndian.Uint16(prop[2:4]); got != MSOS20FeatureRegProperty {
+		t.Errorf("property type = %d, want %d", got, MSOS20FeatureRegProperty)
+	}
+	if got := binary.LittleEndian.Uint16(prop[4:6]); got != RegMultiSZ {
+		t.Errorf("property data type = %d, want %d", got, RegMultiSZ)
+	}
+	if got := binary.LittleEndian.Uint16(prop[6:8]); got != 0x2A {
+		t.Errorf("property name length = %d, want %d", got, 0x2A)
+	}
+	if !bytes.Equal(prop[8:8+0x2A], msosUTF16("DeviceInterfaceGUIDs")) {
+		t.Errorf("property name = % X", prop[8:8+0x2A])
+	}
+	if got := binary.LittleEndian.Uint16(prop[0x32:0x34]); got != 0x50 {
+		t.Errorf("property data length = %d, want %d", got, 0x50)
+	}
+	if !bytes.Equal(prop[0x34:0x34+0x4E], msosUTF16(testInterfaceGUID)) || prop[0x82] != 0 || prop[0x83] != 0 {
+		t.Errorf("property data = % X", prop[0x34:])
+	}
+
+	if n := set.MarshalTo(buf[:0xA1]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
+func TestMSOS20DescriptorSetComposite(t *testing.T) {
+	set := NewMSOS20DescriptorSet(0x20).
+		Function(2).
+		CompatibleID("WINUSB", "").
+		DeviceInterfaceGUIDs(testInterfaceGUID)
+
+	var buf [512]byte
+	n := set.MarshalTo(buf[:])
+	if n != 0xB2 {
+		t.Fatalf("MarshalTo() = %d, want %d", n, 0xB2)
+	}
+
+	// Configuration subset header
+	want := []byte{0x08, 0x00, 0x01, 0x00, 0x00, 0x00, 0xA8, 0x00}
+	if !bytes.Equal(buf[10:18], want) {
+		t.Errorf("configuration subset = % X, want % X", buf[10:18], want)
+	}
+
+	// Function subset header
+	want = []byte{0x08, 0x00, 0x02, 0x00, 0x02, 0x00, 0xA0, 0x00}
+	if !bytes.Equal(buf[18:26], want) {
+		t.Errorf("function subset = % X, want % X", buf[18:26], want)
+	}
+
+	if got := binary.LittleEndian.Uint16(buf[26+2:]); got != MSOS20FeatureCompatibleID {
+		t.Errorf("first feature = %d, want %d", got, MSOS20FeatureCompatibleID)
+	}
+}
+
+func TestMSOS20DescriptorSetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     *MSOS20DescriptorSet
+		wantErr error
+	}{
+		{
+			"long compatible ID",
+			NewMSOS20DescriptorSet(0x20).CompatibleID("WINUSB123", ""),
+			pkg.ErrInvalidParameter,
+		},
+		{
+			"duplicate compatible ID",
+			NewMSOS20DescriptorSet(0x20).CompatibleID("WINUSB", "").CompatibleID("WINUSB", ""),
+			pkg.ErrInvalidParameter,
+		},
+		{
+			"invalid data type",
+			NewMSOS20DescriptorSet(0x20).RegistryProperty(0, "Name", nil),
+			pkg.ErrInvalidParameter,
+		},
+		{
+			"empty name",
+			NewMSOS20DescriptorSet(0x20).RegistryProperty(RegSZ, "", nil),
+			pkg.ErrInvalidParameter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.set.Err(); err != tt.wantErr {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	set := NewMSOS20DescriptorSet(0x20)
+	for i := 0; i <= MaxMSOSFunctions; i++ {
+		set.Function(uint8(i))
+	}
+	if err := set.Err(); err != pkg.ErrNoResources {
+		t.Errorf("Err(functions) = %v, want %v", err, pkg.ErrNoResources)
+	}
+
+	set = NewMSOS20DescriptorSet(0x20)
+	for i := 0; i <= MaxMSOSProperties; i++ {
+		set.RegistryProperty(RegDWordLittleEndian, "Value", []byte{1, 0, 0, 0})
+	}
+	if err := set.Err(); err != pkg.ErrNoResources {
+		t.Errorf("Err(properties) = %v, want %v", err, pkg.ErrNoResources)
+	}
+}
+
+func TestMSOS20Capability(t *testing.T) {
+	set := NewMSOS20DescriptorSet(0x20).CompatibleID("WINUSB", "")
+
+	var buf [64]byte
+	n := set.Capability().MarshalTo(buf[:])
+	if n != PlatformCapabilityHeaderSize+8 {
+		t.Fatalf("MarshalTo() = %d, want %d", n, PlatformCapabilityHeaderSize+8)
+	}
+
+	var desc PlatformCapabilityDescriptor
+	if err := ParsePlatformCapabilityDescriptor(buf[:n], &desc); err != nil {
+		t.Fatalf("ParsePlatformCapabilityDescriptor() error = %v", err)
+	}
+	if desc.UUID != MSOS20PlatformUUID {
+		t.Errorf("UUID = % X, want % X", desc.UUID, MSOS20PlatformUUID)
+	}
+	want := []byte{0x00, 0x00, 0x03, 0x06, 0x1E, 0x00, 0x20, 0x00}
+	if !bytes.Equal(desc.Data, want) {
+		t.Errorf("data = % X, want % X", desc.Data, want)
+	}
+}
+
+func TestMSOS20HandleSetup(t *testing.T) {
+	set := NewMSOS20DescriptorSet(0x20).CompatibleID("WINUSB", "")
+
+	data, handled, err := set.HandleSetup(msosSetup(0x20, RequestRecipientDevice, 0, MSOS20DescriptorIndex, 0xFF), nil)
+	if err != nil || !handled {
+		t.Fatalf("HandleSetup() = %v, %v, want handled", handled, err)
+	}
+	if len(data) != msos20SetHeaderSize+msos20CompatibleIDSize {
+		t.Errorf("response length = %d, want %d", len(data), msos20SetHeaderSize+msos20CompatibleIDSize)
+	}
+
+	tests := []struct {
+		name  string
+		setup *SetupPacket
+	}{
+		{"other vendor code", msosSetup(0x21, RequestRecipientDevice, 0, MSOS20DescriptorIndex, 0xFF)},
+		{"other index", msosSetup(0x20, RequestRecipientDevice, 0, 0x09, 0xFF)},
+		{"MS OS 1.0 disabled", msosSetup(0x20, RequestRecipientDevice, 0, MSOS10ExtendedCompatID, 0xFF)},
+		{"OUT request", &SetupPacket{RequestType: RequestTypeVendor, Request: 0x20, Index: MSOS20DescriptorIndex}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, handled, _ := set.HandleSetup(tt.setup, nil); handled {
+				t.Error("HandleSetup() should not handle the request")
+			}
+		})
+	}
+
+	var setup SetupPacket
+	GetDescriptorSetup(&setup, DescriptorTypeString, MSOS10StringIndex, 0xFF)
+	if _, handled, _ := set.HandleSetup(&setup, nil); handled {
+		t.Error("HandleSetup() should not answer the OS string descriptor")
+	}
+}
+
+func TestMSOS10Descriptors(t *testing.T) {
+	set := NewMSOS20DescriptorSet(0x20).
+		WithMSOS10().
+		Function(2).
+		CompatibleID("WINUSB", "").
+		DeviceInterfaceGUIDs(testInterfaceGUID)
+
+	// OS string descriptor
+	var setup SetupPacket
+	GetDescriptorSetup(&setup, DescriptorTypeString, MSOS10StringIndex, 0xFF)
+	data, handled, err := set.HandleSetup(&setup, nil)
+	if err != nil || !handled {
+		t.Fatalf("HandleSetup(string) = %v, %v, want handled", handled, err)
+	}
+	want := []byte{0x12, 0x03, 'M', 0, 'S', 0, 'F', 0, 'T', 0, '1', 0, '0', 0, '0', 0, 0x20, 0x00}
+	if !bytes.Equal(data, want) {
+		t.Errorf("OS string = % X, want % X", data, want)
+	}
+
+	// Extended compat ID descriptor
+	data, handled, err = set.HandleSetup(msosSetup(0x20, RequestRecipientDevice, 0, MSOS10ExtendedCompatID, 0xFF), nil)
+	if err != nil || !handled {
+		t.Fatalf("HandleSetup(compat ID) = %v, %v, want handled", handled, err)
+	}
+	want = []byte{
+		0x28, 0x00, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0,
+		0x02, 0x01, 'W', 'I', 'N', 'U', 'S', 'B', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("compat ID = % X, want % X", data, want)
+	}
+
+	// Extended properties descriptor of interface 2
+	data, handled, err = set.HandleSetup(msosSetup(0x20, RequestRecipientInterface, 2, MSOS10ExtendedProperties, 0xFFFF), nil)
+	if err != nil || !handled {
+		t.Fatalf("HandleSetup(properties) = %v, %v, want handled", handled, err)
+	}
+	if got := binary.LittleEndian.Uint32(data[0:4]); int(got) != len(data) || len(data) != 0x92 {
+		t.Errorf("properties dwLength = %d, length = %d, want %d", got, len(data), 0x92)
+	}
+	if got := binary.LittleEndian.Uint16(data[8:10]); got != 1 {
+		t.Errorf("properties wCount = %d, want 1", got)
+	}
+	if got := binary.LittleEndian.Uint32(data[14:18]); got != RegMultiSZ {
+		t.Errorf("property data type = %d, want %d", got, RegMultiSZ)
+	}
+	if got := binary.LittleEndian.Uint32(data[20+0x2A:]); got != 0x50 {
+		t.Errorf("property data length = %d, want %d", got, 0x50)
+	}
+
+	// Interfaces without properties and nonzero pages are rejected
+	if _, handled, err := set.HandleSetup(msosSetup(0x20, RequestRecipientInterface, 0, MSOS10ExtendedProperties, 0xFF), nil); !handled || err != pkg.ErrInvalidRequest {
+		t.Errorf("HandleSetup(interface 0) = %v, %v, want handled with %v", handled, err, pkg.ErrInvalidRequest)
+	}
+	if _, handled, err := set.HandleSetup(msosSetup(0x20, RequestRecipientInterface, 0x0102, MSOS10ExtendedProperties, 0xFF), nil); !handled || err != pkg.ErrInvalidRequest {
+		t.Errorf("HandleSetup(page 1) = %v, %v, want handled with %v", handled, err, pkg.ErrInvalidRequest)
+	}
+}
+
+func TestStackMSOS20Descriptors(t *testing.T) {
+	set := NewMSOS20DescriptorSet(0x20).
+		WithMSOS10().
+		CompatibleID("WINUSB", "").
+		DeviceInterfaceGUIDs(testInterfaceGUID)
+
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		WithMSOS20Descriptors(set).
+		AddConfiguration(1).
+		AddInterface(ClassVendor, 0, 0).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if dev.Descriptor.USBVersion != 0x0210 {
+		t.Errorf("USBVersion = 0x%04X, want 0x0210", dev.Descriptor.USBVersion)
+	}
+	dev.Reset()
+	dev.SetAddress(1)
+
+	hal := newMockHAL()
+	stack := NewStack(dev, hal)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	inData := func() []byte {
+		hal.mutex.Lock()
+		defer hal.mutex.Unlock()
+		return hal.ep0InData
+	}
+
+	// BOS descriptor with the MS OS 2.0 platform capability
+	var setup SetupPacket
+	GetDescriptorSetup(&setup, DescriptorTypeBOS, 0, 0xFF)
+	if err := stack.handleSetup(&setup); err != nil {
+		t.Fatalf("handleSetup(BOS) error = %v", err)
+	}
+	bos := inData()
+	if len(bos) != BOSDescriptorSize+PlatformCapabilityHeaderSize+8 {
+		t.Fatalf("BOS length = %d, want %d", len(bos), BOSDescriptorSize+PlatformCapabilityHeaderSize+8)
+	}
+	var desc PlatformCapabilityDescriptor
+	if err := ParsePlatformCapabilityDescriptor(bos[BOSDescriptorSize:], &desc); err != nil {
+		t.Fatalf("ParsePlatformCapabilityDescriptor() error = %v", err)
+	}
+	total := binary.LittleEndian.Uint16(desc.Data[4:6])
+
+	// Descriptor set through the vendor request
+	if err := stack.handleSetup(msosSetup(desc.Data[6], RequestRecipientDevice, 0, MSOS20DescriptorIndex, total)); err != nil {
+		t.Fatalf("handleSetup(descriptor set) error = %v", err)
+	}
+	if got := inData(); len(got) != int(total) || binary.LittleEndian.Uint16(got[8:10]) != total {
+		t.Errorf("descriptor set length = %d, want %d", len(got), total)
+	}
+
+	// OS string descriptor through the standard request fallback
+	GetDescriptorSetup(&setup, DescriptorTypeString, MSOS10StringIndex, 0xFF)
+	if err := stack.handleSetup(&setup); err != nil {
+		t.Fatalf("handleSetup(OS string) error = %v", err)
+	}
+	if got := inData(); len(got) != msos10StringSize || got[16] != 0x20 {
+		t.Errorf("OS string = % X", got)
+	}
+
+	// Other missing strings are still rejected
+	GetDescriptorSetup(&setup, DescriptorTypeString, 0xED, 0xFF)
+	if err := stack.handleSetup(&setup); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(string 0xED) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+}
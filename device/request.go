@@ -0,0 +1,98 @@
+package device
+
+import "github.com/ardnew/softusb/pkg"
+
+// RequestHandler handles control requests at the device level, such as
+// vendor requests, that neither the standard request handler nor a class
+// driver handles.
+type RequestHandler interface {
+	// HandleSetup processes a SETUP request. For OUT requests, data holds
+	// the data stage, which the stack reads before the call. For IN
+	// requests, the returned slice is sent as the data stage, truncated to
+	// wLength.
+	// Returns true if the request was handled. If a handled request
+	// returns an error, the request is stalled.
+	HandleSetup(setup *SetupPacket, data []byte) ([]byte, bool, error)
+}
+
+// RequestHandlerFunc adapts a function to the RequestHandler interface.
+type RequestHandlerFunc func(setup *SetupPacket, data []byte) ([]byte, bool, error)
+
+// HandleSetup calls f(setup, data).
+func (f RequestHandlerFunc) HandleSetup(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+	return f(setup, data)
+}
+
+// requestHandlerEntry is a request handler registered for a request type
+// and recipient.
+type requestHandlerEntry struct {
+	requestType uint8 // RequestTypeStandard, RequestTypeClass, or RequestTypeVendor
+	recipient   uint8 // RequestRecipient*
+	handler     RequestHandler
+}
+
+// matches reports whether the entry is registered for setup.
+func (e *requestHandlerEntry) matches(setup *SetupPacket) bool {
+	return setup.Type() == e.requestType && setup.Recipient() == e.recipient
+}
+
+// AddRequestHandler registers a handler for control requests of the given
+// type (RequestTypeStandard, RequestTypeClass, or RequestTypeVendor) and
+// recipient (RequestRecipient*).
+//
+// The stack tries the standard request handler first, then the class
+// driver of the addressed interface or of the interface owning the
+// addressed endpoint, then the registered handlers in the order they were
+// added until one handles the request.
+func (d *Device) AddRequestHandler(requestType, recipient uint8, h RequestHandler) error {
+	if h == nil || requestType&^RequestTypeTypeMask != 0 ||
+		requestType == RequestTypeTypeMask || recipient > RequestRecipientOther {
+		return pkg.ErrInvalidParameter
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.requestHandlerCount >= MaxRequestHandlers {
+		return pkg.ErrNoResources
+	}
+	d.requestHandlers[d.requestHandlerCount] = requestHandlerEntry{
+		requestType: requestType,
+		recipient:   recipient,
+		handler:     h,
+	}
+	d.requestHandlerCount++
+	return nil
+}
+
+// hasRequestHandler reports whether a handler is registered for setup.
+func (d *Device) hasRequestHandler(setup *SetupPacket) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	for idx := 0; idx < d.requestHandlerCount; idx++ {
+		if d.requestHandlers[idx].matches(setup) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRequest passes a SETUP request to the registered handlers.
+// Returns the response of the first handler that handles it.
+func (d *Device) handleRequest(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+	d.mutex.RLock()
+	entries := d.requestHandlers
+	count := d.requestHandlerCount
+	d.mutex.RUnlock()
+
+	for idx := 0; idx < count; idx++ {
+		if !entries[idx].matches(setup) {
+			continue
+		}
+		if response, handled, err := entries[idx].handler.HandleSetup(setup, data); handled {
+			return response, true, err
+		}
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,112 @@
+package device
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+func TestDeviceAddRequestHandler(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	h := RequestHandlerFunc(func(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+		return nil, true, nil
+	})
+
+	tests := []struct {
+		name        string
+		requestType uint8
+		recipient   uint8
+		handler     RequestHandler
+		wantErr     error
+	}{
+		{"vendor device", RequestTypeVendor, RequestRecipientDevice, h, nil},
+		{"class endpoint", RequestTypeClass, RequestRecipientEndpoint, h, nil},
+		{"nil handler", RequestTypeVendor, RequestRecipientDevice, nil, pkg.ErrInvalidParameter},
+		{"reserved type", RequestTypeTypeMask, RequestRecipientDevice, h, pkg.ErrInvalidParameter},
+		{"direction bit", RequestDirectionDeviceToHost | RequestTypeVendor, RequestRecipientDevice, h, pkg.ErrInvalidParameter},
+		{"reserved recipient", RequestTypeVendor, 0x04, h, pkg.ErrInvalidParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := dev.AddRequestHandler(tt.requestType, tt.recipient, tt.handler); err != tt.wantErr {
+				t.Errorf("AddRequestHandler() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeviceAddRequestHandlerFull(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	h := RequestHandlerFunc(func(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+		return nil, false, nil
+	})
+	for i := 0; i < MaxRequestHandlers; i++ {
+		if err := dev.AddRequestHandler(RequestTypeVendor, RequestRecipientDevice, h); err != nil {
+			t.Fatalf("AddRequestHandler(%d) error = %v", i, err)
+		}
+	}
+	if err := dev.AddRequestHandler(RequestTypeVendor, RequestRecipientDevice, h); err != pkg.ErrNoResources {
+		t.Errorf("AddRequestHandler() error = %v, want %v", err, pkg.ErrNoResources)
+	}
+}
+
+func TestDeviceHandleRequestOrder(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	var calls []string
+	handler := func(name string, handled bool) RequestHandler {
+		return RequestHandlerFunc(func(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+			calls = append(calls, name)
+			return []byte(name), handled, nil
+		})
+	}
+	dev.AddRequestHandler(RequestTypeVendor, RequestRecipientInterface, handler("interface", true))
+	dev.AddRequestHandler(RequestTypeVendor, RequestRecipientDevice, handler("first", false))
+	dev.AddRequestHandler(RequestTypeVendor, RequestRecipientDevice, handler("second", true))
+	dev.AddRequestHandler(RequestTypeVendor, RequestRecipientDevice, handler("third", true))
+
+	setup := &SetupPacket{RequestType: RequestDirectionDeviceToHost | RequestTypeVendor | RequestRecipientDevice}
+	response, handled, err := dev.handleRequest(setup, nil)
+	if err != nil || !handled {
+		t.Fatalf("handleRequest() = %v, %v, want handled", handled, err)
+	}
+	if string(response) != "second" {
+		t.Errorf("response = %q, want %q", response, "second")
+	}
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Errorf("calls = %v, want [first second]", calls)
+	}
+
+	setup.RequestType = RequestDirectionDeviceToHost | RequestTypeClass | RequestRecipientDevice
+	if _, handled, _ := dev.handleRequest(setup, nil); handled {
+		t.Error("handleRequest() should not handle class requests")
+	}
+}
+
+func TestDeviceBuilderWithRequestHandler(t *testing.T) {
+	h := RequestHandlerFunc(func(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+		return nil, true, nil
+	})
+
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		WithRequestHandler(RequestTypeVendor, RequestRecipientDevice, h).
+		AddConfiguration(1).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	setup := &SetupPacket{RequestType: RequestTypeVendor | RequestRecipientDevice}
+	if !dev.hasRequestHandler(setup) {
+		t.Error("request handler not registered")
+	}
+
+	_, err = NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		WithRequestHandler(RequestTypeVendor, RequestRecipientDevice, nil).
+		AddConfiguration(1).
+		Build(context.Background())
+	if err != pkg.ErrInvalidParameter {
+		t.Errorf("Build() error = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+}
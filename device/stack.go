@@ -147,6 +147,9 @@ func (s *Stack) Device() *Device {
 
 // controlLoop handles control transfers on EP0.
 func (s *Stack) controlLoop() {
+	// Speed not yet read since start or the last bus reset
+	speedPending := true
+
 	for {
 		select {
 		case <-s.ctx.Done():
@@ -161,6 +164,7 @@ func (s *Stack) controlLoop() {
 			// Handle bus reset
 			if err == pkg.ErrReset {
 				s.device.Reset()
+				speedPending = true
 				continue
 			}
 			pkg.LogWarn(pkg.ComponentStack, "error reading setup",
@@ -168,6 +172,12 @@ func (s *Stack) controlLoop() {
 			continue
 		}
 
+		// Speed is negotiated during reset, before the first SETUP
+		if speedPending {
+			speedPending = false
+			s.updateSpeed()
+		}
+
 		// Convert HAL setup packet to device setup packet
 		var setup SetupPacket
 		setup.RequestType = s.setupBuf.RequestType
@@ -185,6 +195,16 @@ func (s *Stack) controlLoop() {
 	}
 }
 
+// updateSpeed sets the device speed to the speed negotiated by the HAL,
+// selecting the endpoint parameters of that speed.
+func (s *Stack) updateSpeed() {
+	speed := halSpeedToDeviceSpeed(s.hal.GetSpeed())
+	s.device.SetSpeed(speed)
+
+	pkg.LogDebug(pkg.ComponentStack, "speed negotiated",
+		"speed", speed.String())
+}
+
 // handleSetup processes a single SETUP transaction.
 func (s *Stack) handleSetup(setup *SetupPacket) error {
 	pkg.LogDebug(pkg.ComponentStack, "setup received",
@@ -201,18 +221,22 @@ func (s *Stack) handleSetup(setup *SetupPacket) error {
 		}
 	}
 
-	// Try class-specific handler
-	if setup.IsClass() && setup.IsInterfaceRecipient() {
-		iface := s.device.GetInterface(setup.InterfaceNumber())
-		if iface != nil {
-			handled, classErr := iface.HandleSetup(setup, nil)
-			if handled {
-				if classErr != nil {
-					return classErr
-				}
-				return s.completeSetup(setup, nil)
-			}
-		}
+	// Find the class driver of the addressed interface, or of the interface
+	// owning the addressed endpoint. It also receives standard requests the
+	// standard handler rejected (e.g. HID report descriptor requests).
+	var iface *Interface
+	switch {
+	case setup.IsInterfaceRecipient():
+		iface = s.device.GetInterface(setup.InterfaceNumber())
+	case setup.IsEndpointRecipient():
+		iface = s.device.endpointInterface(setup.EndpointAddress())
+	}
+	if iface != nil && iface.ClassDriver() == nil {
+		iface = nil
+	}
+
+	if iface != nil || s.device.hasRequestHandler(setup) {
+		return s.dispatchSetup(iface, setup, err)
 	}
 
 	// Request not handled
@@ -222,6 +246,51 @@ func (s *Stack) handleSetup(setup *SetupPacket) error {
 	return pkg.ErrInvalidRequest
 }
 
+// dispatchSetup completes a control transfer through the class driver of
+// iface, if not nil, or else the device-level request handlers. The OUT
+// data stage is read before the handlers are called, and the response of
+// the handler is sent as the IN data stage. stdErr is returned if no
+// handler handles the request.
+func (s *Stack) dispatchSetup(iface *Interface, setup *SetupPacket, stdErr error) error {
+	var data []byte
+	if setup.IsHostToDevice() && setup.Length > 0 {
+		maxLen := min(int(setup.Length), MaxControlDataSize)
+		n, err := s.hal.ReadEP0(s.ctx, s.ep0ReadBuf[:maxLen])
+		if err != nil {
+			return err
+		}
+		data = s.ep0ReadBuf[:n]
+	}
+
+	var response []byte
+	var handled bool
+	var err error
+	if iface != nil {
+		response, handled, err = iface.HandleSetup(setup, data)
+	}
+	if !handled {
+		response, handled, err = s.device.handleRequest(setup, data)
+	}
+	if !handled {
+		if stdErr != nil {
+			return stdErr
+		}
+		return pkg.ErrInvalidRequest
+	}
+	if err != nil {
+		return err
+	}
+
+	if setup.IsHostToDevice() {
+		// Data stage already read; send status stage
+		return s.hal.AckEP0()
+	}
+	if len(response) > int(setup.Length) {
+		response = response[:setup.Length]
+	}
+	return s.completeSetup(setup, response)
+}
+
 // completeSetup completes the control transfer.
 func (s *Stack) completeSetup(setup *SetupPacket, data []byte) error {
 	if setup.IsDeviceToHost() {
@@ -299,6 +368,11 @@ func (s *Stack) processTransfer(t *Transfer) {
 	default:
 	}
 
+	if t.Type == EndpointTypeIsochronous && t.NumIsoPackets > 0 {
+		s.processIsoTransfer(ctx, t)
+		return
+	}
+
 	var n int
 	var err error
 
@@ -322,6 +396,48 @@ func (s *Stack) processTransfer(t *Transfer) {
 	t.Complete(pkg.TransferStatusSuccess, n, nil)
 }
 
+// processIsoTransfer processes an isochronous transfer one packet at a time.
+// Each packet occupies one frame; a failed packet is recorded in its status
+// and does not stop the transfer.
+func (s *Stack) processIsoTransfer(ctx context.Context, t *Transfer) {
+	total := 0
+	for i := 0; i < t.NumIsoPackets; i++ {
+		p := t.IsoPacket(i)
+
+		if ctx.Err() != nil {
+			for j := i; j < t.NumIsoPackets; j++ {
+				t.IsoPacket(j).Status = pkg.TransferStatusCancelled
+			}
+			s.removeTransfer(t)
+			t.Complete(pkg.TransferStatusCancelled, total, pkg.ErrCancelled)
+			return
+		}
+
+		end := p.Offset + p.Length
+		if p.Offset < 0 || end > len(t.Buffer) {
+			p.Status = pkg.TransferStatusError
+			t.Endpoint.IncrementFrame()
+			continue
+		}
+
+		var n int
+		var err error
+		if t.IsIn() {
+			n, err = s.hal.Write(ctx, t.Endpoint.Address, t.Buffer[p.Offset:end])
+		} else {
+			n, err = s.hal.Read(ctx, t.Endpoint.Address, t.Buffer[p.Offset:end])
+		}
+
+		p.ActualLength = n
+		p.Status = errorToStatus(err)
+		total += n
+		t.Endpoint.IncrementFrame()
+	}
+
+	s.removeTransfer(t)
+	t.Complete(pkg.TransferStatusSuccess, total, nil)
+}
+
 // removeTransfer removes a transfer from the pending list.
 func (s *Stack) removeTransfer(t *Transfer) {
 	s.transferMutex.Lock()
@@ -25,6 +25,9 @@ type mockHAL struct {
 	mutex        sync.Mutex
 	readData     map[uint8][]byte
 	writeData    map[uint8][]byte
+	ep0OutData   []byte // Returned by ReadEP0 for OUT data stages
+	ep0InData    []byte // Last IN data stage written by WriteEP0
+	ep0Acked     bool
 
 	// Channels for connect/disconnect signaling
 	connectChan    chan struct{}
@@ -80,11 +83,16 @@ func (m *mockHAL) ReadSetup(ctx context.Context, out *hal.SetupPacket) error {
 }
 
 func (m *mockHAL) WriteEP0(ctx context.Context, data []byte) error {
+	m.mutex.Lock()
+	m.ep0InData = append([]byte{}, data...)
+	m.mutex.Unlock()
 	return nil
 }
 
 func (m *mockHAL) ReadEP0(ctx context.Context, buf []byte) (int, error) {
-	return 0, nil
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return copy(buf, m.ep0OutData), nil
 }
 
 func (m *mockHAL) StallEP0() error {
@@ -95,6 +103,9 @@ func (m *mockHAL) StallEP0() error {
 }
 
 func (m *mockHAL) AckEP0() error {
+	m.mutex.Lock()
+	m.ep0Acked = true
+	m.mutex.Unlock()
 	return nil
 }
 
@@ -315,6 +326,72 @@ func TestStackSubmitTransfer(t *testing.T) {
 	}
 }
 
+func TestStackSubmitIsochronousTransfer(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	config := NewConfiguration(1)
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0})
+	ep := &Endpoint{Address: 0x81, Attributes: EndpointTypeIsochronous, MaxPacketSize: 64}
+	iface.AddEndpoint(ep)
+	config.AddInterface(iface)
+	dev.AddConfiguration(config)
+	dev.Reset()
+	dev.SetAddress(1)
+	dev.SetConfiguration(1)
+
+	hal := newMockHAL()
+	stack := NewStack(dev, hal)
+
+	ctx := context.Background()
+	stack.Start(ctx)
+	defer stack.Stop()
+
+	data := make([]byte, 48)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	transfer := NewIsochronousTransfer(ep, data, 3)
+	transfer.SetupIsoPackets(16)
+
+	done := make(chan struct{})
+	transfer.WithCallback(func(t *Transfer) {
+		close(done)
+	})
+
+	if err := stack.SubmitTransfer(transfer); err != nil {
+		t.Fatalf("SubmitTransfer() error = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("transfer did not complete")
+	}
+
+	if !transfer.IsSuccess() {
+		t.Errorf("transfer status = %v, want success", transfer.Status)
+	}
+	if transfer.Length != len(data) {
+		t.Errorf("Length = %d, want %d", transfer.Length, len(data))
+	}
+	for i := 0; i < 3; i++ {
+		p := transfer.IsoPacket(i)
+		if p.ActualLength != 16 || p.Status != pkg.TransferStatusSuccess {
+			t.Errorf("packet %d = (%d, %v), want (16, success)", i, p.ActualLength, p.Status)
+		}
+	}
+	if ep.FrameNumber() != 3 {
+		t.Errorf("FrameNumber() = %d, want 3", ep.FrameNumber())
+	}
+
+	// The mock keeps the last write; each packet is written separately
+	hal.mutex.Lock()
+	last := hal.writeData[0x81]
+	hal.mutex.Unlock()
+	if len(last) != 16 || last[0] != 32 {
+		t.Errorf("last write = %v, want third packet", last)
+	}
+}
+
 func TestStackRead(t *testing.T) {
 	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
 	config := NewConfiguration(1)
@@ -385,6 +462,349 @@ func TestStackWrite(t *testing.T) {
 	}
 }
 
+func TestStackClassSetupDataStages(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	config := NewConfiguration(1)
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0})
+	config.AddInterface(iface)
+	dev.AddConfiguration(config)
+	dev.Reset()
+	dev.SetAddress(1)
+	dev.SetConfiguration(1)
+
+	driver := &mockClassDriver{handleSetupResp: true, response: []byte{1, 2, 3, 4}}
+	iface.SetClassDriver(driver)
+
+	hal := newMockHAL()
+	stack := NewStack(dev, hal)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	// IN request: response is sent, truncated to wLength
+	in := &SetupPacket{
+		RequestType: RequestDirectionDeviceToHost | RequestTypeClass | RequestRecipientInterface,
+		Request:     0x01,
+		Length:      3,
+	}
+	if err := stack.handleSetup(in); err != nil {
+		t.Fatalf("handleSetup(IN) error = %v", err)
+	}
+	hal.mutex.Lock()
+	got := hal.ep0InData
+	hal.mutex.Unlock()
+	if string(got) != "\x01\x02\x03" {
+		t.Errorf("IN data stage = %v, want [1 2 3]", got)
+	}
+
+	// OUT request: data stage is delivered to the driver
+	hal.mutex.Lock()
+	hal.ep0OutData = []byte{0xAA, 0xBB}
+	hal.mutex.Unlock()
+	out := &SetupPacket{
+		RequestType: RequestDirectionHostToDevice | RequestTypeClass | RequestRecipientInterface,
+		Request:     0x09,
+		Length:      2,
+	}
+	if err := stack.handleSetup(out); err != nil {
+		t.Fatalf("handleSetup(OUT) error = %v", err)
+	}
+	if string(driver.data) != "\xAA\xBB" {
+		t.Errorf("OUT data stage = %v, want [170 187]", driver.data)
+	}
+	hal.mutex.Lock()
+	acked := hal.ep0Acked
+	hal.mutex.Unlock()
+	if !acked {
+		t.Error("status stage not sent")
+	}
+
+	// Unhandled requests are rejected
+	driver.handleSetupResp = false
+	if err := stack.handleSetup(in); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(unhandled) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+}
+
+func TestStackStandardInterfaceSetupToDriver(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	config := NewConfiguration(1)
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0})
+	config.AddInterface(iface)
+	dev.AddConfiguration(config)
+	dev.Reset()
+	dev.SetAddress(1)
+	dev.SetConfiguration(1)
+
+	driver := &mockClassDriver{handleSetupResp: true, response: []byte{0x05, 0x01}}
+	iface.SetClassDriver(driver)
+
+	hal := newMockHAL()
+	stack := NewStack(dev, hal)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	// GET_DESCRIPTOR to an interface (e.g. HID report descriptor)
+	setup := &SetupPacket{
+		RequestType: RequestDirectionDeviceToHost | RequestTypeStandard | RequestRecipientInterface,
+		Request:     RequestGetDescriptor,
+		Value:       0x2200,
+		Length:      64,
+	}
+	if err := stack.handleSetup(setup); err != nil {
+		t.Fatalf("handleSetup() error = %v", err)
+	}
+	if !driver.setupCalled {
+		t.Error("driver HandleSetup() should be called")
+	}
+	hal.mutex.Lock()
+	got := hal.ep0InData
+	hal.mutex.Unlock()
+	if string(got) != "\x05\x01" {
+		t.Errorf("IN data stage = %v, want [5 1]", got)
+	}
+}
+
+func TestStackEndpointSetupToDriver(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	config := NewConfiguration(1)
+	config.AddInterface(NewInterface(&InterfaceDescriptor{InterfaceNumber: 0}))
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 1})
+	iface.AddEndpoint(&Endpoint{Address: 0x01, Attributes: EndpointTypeIsochronous, MaxPacketSize: 192})
+	config.AddInterface(iface)
+	dev.AddConfiguration(config)
+	dev.Reset()
+	dev.SetAddress(1)
+	dev.SetConfiguration(1)
+
+	driver := &mockClassDriver{handleSetupResp: true}
+	iface.SetClassDriver(driver)
+
+	hal := newMockHAL()
+	hal.ep0OutData = []byte{0x80, 0xBB, 0x00}
+	stack := NewStack(dev, hal)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	// Class request to the endpoint (e.g. UAC SET_CUR sampling frequency)
+	setup := &SetupPacket{
+		RequestType: RequestDirectionHostToDevice | RequestTypeClass | RequestRecipientEndpoint,
+		Request:     0x01,
+		Value:       0x0100,
+		Index:       0x0001,
+		Length:      3,
+	}
+	if err := stack.handleSetup(setup); err != nil {
+		t.Fatalf("handleSetup() error = %v", err)
+	}
+	if !driver.setupCalled {
+		t.Error("owning interface driver HandleSetup() should be called")
+	}
+	if string(driver.data) != "\x80\xBB\x00" {
+		t.Errorf("OUT data stage = %v, want [128 187 0]", driver.data)
+	}
+
+	// Unknown endpoints are rejected
+	setup.Index = 0x0002
+	if err := stack.handleSetup(setup); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(unknown endpoint) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+}
+
+func TestStackRequestHandler(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	config := NewConfiguration(1)
+	config.AddInterface(NewInterface(&InterfaceDescriptor{InterfaceNumber: 0}))
+	dev.AddConfiguration(config)
+	dev.Reset()
+	dev.SetAddress(1)
+	dev.SetConfiguration(1)
+
+	var received []byte
+	err := dev.AddRequestHandler(RequestTypeClass, RequestRecipientOther,
+		RequestHandlerFunc(func(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+			switch setup.Request {
+			case 0x00: // Port status
+				return []byte{0x03, 0x01, 0x01, 0x00}, true, nil
+			case 0x03: // Port feature with data
+				received = append(received[:0], data...)
+				return nil, true, nil
+			case 0x05: // Failed request
+				return nil, true, pkg.ErrInvalidRequest
+			}
+			return nil, false, nil
+		}))
+	if err != nil {
+		t.Fatalf("AddRequestHandler() error = %v", err)
+	}
+
+	hal := newMockHAL()
+	hal.ep0OutData = []byte{0x01, 0x02}
+	stack := NewStack(dev, hal)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	// IN request: response is sent, truncated to wLength
+	in := &SetupPacket{
+		RequestType: RequestDirectionDeviceToHost | RequestTypeClass | RequestRecipientOther,
+		Request:     0x00,
+		Index:       1,
+		Length:      2,
+	}
+	if err := stack.handleSetup(in); err != nil {
+		t.Fatalf("handleSetup(IN) error = %v", err)
+	}
+	hal.mutex.Lock()
+	got := hal.ep0InData
+	hal.mutex.Unlock()
+	if string(got) != "\x03\x01" {
+		t.Errorf("IN data stage = %v, want [3 1]", got)
+	}
+
+	// OUT request: data stage is delivered to the handler
+	out := &SetupPacket{
+		RequestType: RequestDirectionHostToDevice | RequestTypeClass | RequestRecipientOther,
+		Request:     0x03,
+		Index:       1,
+		Length:      2,
+	}
+	if err := stack.handleSetup(out); err != nil {
+		t.Fatalf("handleSetup(OUT) error = %v", err)
+	}
+	if string(received) != "\x01\x02" {
+		t.Errorf("OUT data stage = %v, want [1 2]", received)
+	}
+	hal.mutex.Lock()
+	acked := hal.ep0Acked
+	hal.mutex.Unlock()
+	if !acked {
+		t.Error("status stage not sent")
+	}
+
+	// Handler errors stall the request
+	in.Request = 0x05
+	if err := stack.handleSetup(in); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(failed) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+
+	// Unhandled requests and other recipients are rejected
+	in.Request = 0x06
+	if err := stack.handleSetup(in); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(unhandled) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+	in.Request = 0x00
+	in.RequestType = RequestDirectionDeviceToHost | RequestTypeClass | RequestRecipientDevice
+	if err := stack.handleSetup(in); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(device recipient) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+}
+
+
+func TestStackVendorRequestHandler(t *testing.T) {
+	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
+	config := NewConfiguration(1)
+	iface := NewInterface(&InterfaceDescriptor{InterfaceNumber: 0})
+	config.AddInterface(iface)
+	dev.AddConfiguration(config)
+	dev.Reset()
+	dev.SetAddress(1)
+
+	var received []byte
+	err := dev.AddRequestHandler(RequestTypeVendor, RequestRecipientDevice,
+		RequestHandlerFunc(func(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+			switch setup.Request {
+			case 0x01: // Write block
+				received = append(received[:0], data...)
+				return nil, true, nil
+			case 0x02: // Read status
+				return []byte{0x00, 0x10, 0x20}, true, nil
+			case 0x03: // Failed command
+				return nil, true, pkg.ErrNotSupported
+			}
+			return nil, false, nil
+		}))
+	if err != nil {
+		t.Fatalf("AddRequestHandler() error = %v", err)
+	}
+
+	hal := newMockHAL()
+	hal.ep0OutData = []byte{0xDE, 0xAD, 0xBE, 0xEF}
+	stack := NewStack(dev, hal)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	// Vendor requests reach the handler in the addressed state
+	out := &SetupPacket{
+		RequestType: RequestDirectionHostToDevice | RequestTypeVendor | RequestRecipientDevice,
+		Request:     0x01,
+		Length:      4,
+	}
+	if err := stack.handleSetup(out); err != nil {
+		t.Fatalf("handleSetup(OUT) error = %v", err)
+	}
+	if string(received) != "\xDE\xAD\xBE\xEF" {
+		t.Errorf("OUT data stage = %v, want [222 173 190 239]", received)
+	}
+
+	in := &SetupPacket{
+		RequestType: RequestDirectionDeviceToHost | RequestTypeVendor | RequestRecipientDevice,
+		Request:     0x02,
+		Length:      2,
+	}
+	if err := stack.handleSetup(in); err != nil {
+		t.Fatalf("handleSetup(IN) error = %v", err)
+	}
+	hal.mutex.Lock()
+	got := hal.ep0InData
+	hal.mutex.Unlock()
+	if string(got) != "\x00\x10" {
+		t.Errorf("IN data stage = %v, want [0 16]", got)
+	}
+
+	// Handler errors stall the request
+	in.Request = 0x03
+	if err := stack.handleSetup(in); err != pkg.ErrNotSupported {
+		t.Errorf("handleSetup(failed) error = %v, want %v", err, pkg.ErrNotSupported)
+	}
+
+	// Unhandled requests and other recipients are rejected
+	in.Request = 0x04
+	if err := stack.handleSetup(in); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(unhandled) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+	in.Request = 0x02
+	in.RequestType = RequestDirectionDeviceToHost | RequestTypeVendor | RequestRecipientOther
+	if err := stack.handleSetup(in); err != pkg.ErrInvalidRequest {
+		t.Errorf("handleSetup(other recipient) error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+}
+
+func TestStackNegotiatedSpeed(t *testing.T) {
+	dev := setupTestDevice()
+	dev.GetConfiguration(1).SetEndpointSpeedParameters(0x81, SpeedFull, 64, 0)
+	dev.SetSpeed(SpeedFull)
+
+	mock := newMockHAL()
+	mock.speed = hal.SpeedHigh
+	stack := NewStack(dev, mock)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	var setup SetupPacket
+	GetDescriptorSetup(&setup, DescriptorTypeDevice, 0, 18)
+	mock.sendSetup(&setup)
+
+	deadline := time.Now().Add(time.Second)
+	for dev.Speed() != SpeedHigh {
+		if time.Now().After(deadline) {
+			t.Fatalf("Speed() = %v, want %v", dev.Speed(), SpeedHigh)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if mps := dev.GetEndpoint(0x81).MaxPacketSize; mps != 512 {
+		t.Errorf("MaxPacketSize = %d, want 512", mps)
+	}
+}
+
 func TestStackReadNotConfigured(t *testing.T) {
 	dev := NewDevice(&DeviceDescriptor{MaxPacketSize0: 64})
 	hal := newMockHAL()
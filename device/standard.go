@@ -182,9 +182,22 @@ func (h *StandardRequestHandler) getDescriptor(setup *SetupPacket) ([]byte, erro
 			return nil, pkg.ErrNotSupported
 		}
 
+	case DescriptorTypeBOS:
+		n = h.device.BOSTo(h.responseBuf[:])
+		if n == 0 {
+			return nil, pkg.ErrInvalidRequest
+		}
+
 	case DescriptorTypeOtherSpeedConfig:
-		// Other speed configuration
-		return nil, pkg.ErrNotSupported
+		// Configuration as it operates at the other speed
+		if !h.device.IsDualSpeed() {
+			return nil, pkg.ErrNotSupported
+		}
+		config := h.device.GetConfiguration(descIndex + 1)
+		if config == nil {
+			return nil, pkg.ErrInvalidRequest
+		}
+		n = config.MarshalOtherSpeedTo(h.responseBuf[:], otherSpeed(h.device.Speed()))
 
 	default:
 		return nil, pkg.ErrInvalidRequest
@@ -200,27 +213,42 @@ func (h *StandardRequestHandler) getDescriptor(setup *SetupPacket) ([]byte, erro
 	return h.responseBuf[:n], nil
 }
 
-// getDeviceQualifier writes the device qualifier descriptor to responseBuf.
+// getDeviceQualifier writes the device qualifier descriptor, which
+// describes the device at the other speed, to responseBuf.
 // Returns the number of bytes written, or 0 if not supported.
 func (h *StandardRequestHandler) getDeviceQualifier() int {
 	// Only required for high-speed capable devices
-	if h.device.Speed() != SpeedHigh {
+	if !h.device.IsDualSpeed() {
 		return 0
 	}
 
 	desc := h.device.Descriptor
+	maxPacketSize0 := desc.MaxPacketSize0
+	if otherSpeed(h.device.Speed()) == SpeedHigh {
+		// EP0 always has 64-byte packets at high speed
+		maxPacketSize0 = 64
+	}
+
 	h.responseBuf[0] = 10 // Length
 	h.responseBuf[1] = DescriptorTypeDeviceQualifier
 	binary.LittleEndian.PutUint16(h.responseBuf[2:4], desc.USBVersion)
 	h.responseBuf[4] = desc.DeviceClass
 	h.responseBuf[5] = desc.DeviceSubClass
 	h.responseBuf[6] = desc.DeviceProtocol
-	h.responseBuf[7] = desc.MaxPacketSize0
+	h.responseBuf[7] = maxPacketSize0
 	h.responseBuf[8] = desc.NumConfigurations
 	h.responseBuf[9] = 0 // Reserved
 	return 10
 }
 
+// otherSpeed returns the speed a dual-speed device is not operating at.
+func otherSpeed(speed Speed) Speed {
+	if speed == SpeedHigh {
+		return SpeedFull
+	}
+	return SpeedHigh
+}
+
 // getConfiguration handles GET_CONFIGURATION request.
 func (h *StandardRequestHandler) getConfiguration(setup *SetupPacket) ([]byte, error) {
 	config := h.device.ActiveConfiguration()
@@ -312,8 +340,7 @@ func (h *StandardRequestHandler) clearEndpointFeature(setup *SetupPacket) ([]byt
 		return nil, pkg.ErrInvalidEndpoint
 	}
 
-	ep.SetStall(false)
-	ep.ResetDataToggle()
+	ep.clearHalt()
 	return nil, nil
 }
 
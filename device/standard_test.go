@@ -222,6 +222,104 @@ func TestHandleGetDescriptorInvalid(t *testing.T) {
 	}
 }
 
+func TestHandleGetDescriptorBOS(t *testing.T) {
+	dev := setupTestDevice()
+	handler := NewStandardRequestHandler(dev)
+
+	// No device capabilities: no BOS descriptor
+	var setup SetupPacket
+	GetDescriptorSetup(&setup, DescriptorTypeBOS, 0, 255)
+	if _, err := handler.HandleSetup(&setup, nil); err != pkg.ErrInvalidRequest {
+		t.Errorf("error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+
+	dev.AddDeviceCapability(&USB20ExtensionDescriptor{Attributes: USB20ExtensionLPM})
+	data, err := handler.HandleSetup(&setup, nil)
+	if err != nil {
+		t.Fatalf("HandleSetup() error = %v", err)
+	}
+	if len(data) != BOSDescriptorSize+USB20ExtensionSize {
+		t.Errorf("response length = %d, want %d", len(data), BOSDescriptorSize+USB20ExtensionSize)
+	}
+	if data[1] != DescriptorTypeBOS {
+		t.Errorf("descriptor type = 0x%02X, want 0x%02X", data[1], DescriptorTypeBOS)
+	}
+
+	// Hosts read the header first to learn the total length
+	GetDescriptorSetup(&setup, DescriptorTypeBOS, 0, BOSDescriptorSize)
+	data, err = handler.HandleSetup(&setup, nil)
+	if err != nil {
+		t.Fatalf("HandleSetup() error = %v", err)
+	}
+	if len(data) != BOSDescriptorSize || data[2] != BOSDescriptorSize+USB20ExtensionSize {
+		t.Errorf("header = % X", data)
+	}
+}
+
+func TestHandleGetDescriptorOtherSpeed(t *testing.T) {
+	dev := setupTestDevice()
+	dev.SetSpeed(SpeedFull)
+	handler := NewStandardRequestHandler(dev)
+
+	// A full speed only device has neither descriptor
+	var setup SetupPacket
+	GetDescriptorSetup(&setup, DescriptorTypeDeviceQualifier, 0, 255)
+	if _, err := handler.HandleSetup(&setup, nil); err == nil {
+		t.Error("device qualifier should not be supported")
+	}
+	GetDescriptorSetup(&setup, DescriptorTypeOtherSpeedConfig, 0, 255)
+	if _, err := handler.HandleSetup(&setup, nil); err != pkg.ErrNotSupported {
+		t.Errorf("other speed configuration error = %v, want %v", err, pkg.ErrNotSupported)
+	}
+
+	config := dev.GetConfiguration(1)
+	config.SetEndpointSpeedParameters(0x81, SpeedFull, 64, 0)
+	config.SetEndpointSpeedParameters(0x02, SpeedFull, 64, 0)
+	dev.SetSpeed(SpeedFull)
+
+	dev.Descriptor.MaxPacketSize0 = 8
+	GetDescriptorSetup(&setup, DescriptorTypeDeviceQualifier, 0, 255)
+	data, err := handler.HandleSetup(&setup, nil)
+	if err != nil {
+		t.Fatalf("device qualifier error = %v", err)
+	}
+	if len(data) != 10 || data[1] != DescriptorTypeDeviceQualifier {
+		t.Fatalf("device qualifier = % X", data)
+	}
+	if data[7] != 64 {
+		t.Errorf("qualifier MaxPacketSize0 = %d, want 64", data[7])
+	}
+
+	// At full speed, the other speed configuration describes high speed
+	GetDescriptorSetup(&setup, DescriptorTypeOtherSpeedConfig, 0, 255)
+	data, err = handler.HandleSetup(&setup, nil)
+	if err != nil {
+		t.Fatalf("other speed configuration error = %v", err)
+	}
+	if data[1] != DescriptorTypeOtherSpeedConfig {
+		t.Errorf("descriptor type = 0x%02X, want 0x%02X", data[1], DescriptorTypeOtherSpeedConfig)
+	}
+	// Configuration (9) + interface (9), then endpoint 0x81
+	if mps := uint16(data[22]) | uint16(data[23])<<8; data[20] != 0x81 || mps != 512 {
+		t.Errorf("endpoint 0x%02X max packet size = %d, want 0x81 with 512", data[20], mps)
+	}
+
+	// And the reverse at high speed
+	dev.SetSpeed(SpeedHigh)
+	data, err = handler.HandleSetup(&setup, nil)
+	if err != nil {
+		t.Fatalf("other speed configuration error = %v", err)
+	}
+	if mps := uint16(data[22]) | uint16(data[23])<<8; mps != 64 {
+		t.Errorf("endpoint 0x81 max packet size = %d, want 64", mps)
+	}
+
+	GetDescriptorSetup(&setup, DescriptorTypeOtherSpeedConfig, 1, 255)
+	if _, err := handler.HandleSetup(&setup, nil); err != pkg.ErrInvalidRequest {
+		t.Errorf("invalid index error = %v, want %v", err, pkg.ErrInvalidRequest)
+	}
+}
+
 func TestHandleGetConfiguration(t *testing.T) {
 	dev := setupTestDevice()
 	handler := NewStandardRequestHandler(dev)
@@ -354,6 +452,11 @@ func TestHandleClearEndpointFeature(t *testing.T) {
 	dev.SetEndpointStall(0x81, true)
 	handler := NewStandardRequestHandler(dev)
 
+	ep := dev.GetEndpoint(0x81)
+	ep.SetDataToggle(true)
+	cleared := false
+	ep.SetOnClearHalt(func() { cleared = true })
+
 	var setup SetupPacket
 	GetClearFeatureSetup(&setup, RequestRecipientEndpoint, FeatureEndpointHalt, 0x81)
 	_, err := handler.HandleSetup(&setup, nil)
@@ -361,10 +464,15 @@ func TestHandleClearEndpointFeature(t *testing.T) {
 		t.Fatalf("HandleSetup() error = %v", err)
 	}
 
-	ep := dev.GetEndpoint(0x81)
 	if ep.IsStalled() {
 		t.Error("endpoint should not be stalled")
 	}
+	if ep.DataToggle() {
+		t.Error("data toggle should be reset to DATA0")
+	}
+	if !cleared {
+		t.Error("OnClearHalt callback should be called")
+	}
 }
 
 func TestHandleSetEndpointFeature(t *testing.T) {
@@ -0,0 +1,114 @@
+package device
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// WebUSB request and descriptor values.
+const (
+	WebUSBRequestGetURL     = 0x02 // GET_URL request (wIndex)
+	DescriptorTypeWebUSBURL = 0x03 // URL descriptor type
+	WebUSBLandingPageIndex  = 1    // URL index of the landing page
+	WebUSBCapabilitySize    = PlatformCapabilityHeaderSize + 4
+)
+
+// WebUSB URL schemes.
+const (
+	WebUSBSchemeHTTP  = 0x00 // http://
+	WebUSBSchemeHTTPS = 0x01 // https://
+	WebUSBSchemeNone  = 0xFF // Scheme included in the URL
+)
+
+// WebUSBPlatformUUID identifies the WebUSB platform capability
+// ({3408B638-09A9-47A0-8BFD-A0768815B665}).
+var WebUSBPlatformUUID = [16]byte{
+	0x38, 0xB6, 0x08, 0x34, 0xA9, 0x09, 0xA0, 0x47,
+	0x8B, 0xFD, 0xA0, 0x76, 0x88, 0x15, 0xB6, 0x65,
+}
+
+// URLDescriptorTo writes a WebUSB URL descriptor for url to buf. An
+// "http://" or "https://" prefix is encoded as the scheme.
+// Returns the number of bytes written, or 0 if buf is too small or url is
+// too long.
+func URLDescriptorTo(buf []byte, url string) int {
+	scheme := uint8(WebUSBSchemeNone)
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		scheme, url = WebUSBSchemeHTTPS, url[len("https://"):]
+	case strings.HasPrefix(url, "http://"):
+		scheme, url = WebUSBSchemeHTTP, url[len("http://"):]
+	}
+	length := 3 + len(url)
+	if length > 255 || len(buf) < length {
+		return 0
+	}
+	buf[0] = uint8(length)
+	buf[1] = DescriptorTypeWebUSBURL
+	buf[2] = scheme
+	copy(buf[3:], url)
+	return length
+}
+
+// WebUSB advertises the WebUSB platform capability, which lets browsers
+// access the device through the WebUSB API, and answers the GET_URL
+// request for its landing page.
+type WebUSB struct {
+	vendorCode uint8
+
+	// Landing page URL descriptor, empty if none
+	landingPage    [255]byte
+	landingPageLen int
+}
+
+// NewWebUSB creates a WebUSB capability answering requests with the given
+// vendor request code. Browsers may suggest landingPage, if not empty, when
+// the device is connected.
+// Returns pkg.ErrInvalidParameter if landingPage is too long.
+func NewWebUSB(vendorCode uint8, landingPage string) (*WebUSB, error) {
+	w := &WebUSB{vendorCode: vendorCode}
+	if landingPage != "" {
+		w.landingPageLen = URLDescriptorTo(w.landingPage[:], landingPage)
+		if w.landingPageLen == 0 {
+			return nil, pkg.ErrInvalidParameter
+		}
+	}
+	return w, nil
+}
+
+// VendorCode returns the vendor request code of WebUSB requests.
+func (w *WebUSB) VendorCode() uint8 {
+	return w.vendorCode
+}
+
+// MarshalTo serializes the WebUSB platform capability to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (w *WebUSB) MarshalTo(buf []byte) int {
+	var data [4]byte
+	binary.LittleEndian.PutUint16(data[0:2], 0x0100) // bcdVersion
+	data[2] = w.vendorCode
+	if w.landingPageLen > 0 {
+		data[3] = WebUSBLandingPageIndex
+	}
+	desc := PlatformCapabilityDescriptor{UUID: WebUSBPlatformUUID, Data: data[:]}
+	return desc.MarshalTo(buf)
+}
+
+// HandleSetup answers the GET_URL request.
+func (w *WebUSB) HandleSetup(setup *SetupPacket, data []byte) ([]byte, bool, error) {
+	if !setup.IsVendor() || !setup.IsDeviceToHost() || !setup.IsDeviceRecipient() ||
+		setup.Request != w.vendorCode || setup.Index != WebUSBRequestGetURL {
+		return nil, false, nil
+	}
+
+	if setup.Value != WebUSBLandingPageIndex || w.landingPageLen == 0 {
+		return nil, true, pkg.ErrInvalidRequest
+	}
+
+	pkg.LogDebug(pkg.ComponentDevice, "WebUSB URL sent",
+		"index", setup.Value)
+
+	return w.landingPage[:w.landingPageLen], true, nil
+}
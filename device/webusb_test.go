@@ -0,0 +1,159 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+func TestURLDescriptorTo(t *testing.T) {
+	tests := []struct {
+		url  string
+		want []byte
+	}{
+		{"https://a.io", []byte{0x07, DescriptorTypeWebUSBURL, WebUSBSchemeHTTPS, 'a', '.', 'i', 'o'}},
+		{"http://a.io", []byte{0x07, DescriptorTypeWebUSBURL, WebUSBSchemeHTTP, 'a', '.', 'i', 'o'}},
+		{"ws://a.io", []byte{0x0C, DescriptorTypeWebUSBURL, WebUSBSchemeNone, 'w', 's', ':', '/', '/', 'a', '.', 'i', 'o'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			var buf [255]byte
+			n := URLDescriptorTo(buf[:], tt.url)
+			if !bytes.Equal(buf[:n], tt.want) {
+				t.Errorf("URLDescriptorTo() = % X, want % X", buf[:n], tt.want)
+			}
+		})
+	}
+
+	var buf [255]byte
+	if n := URLDescriptorTo(buf[:], "https://"+strings.Repeat("a", 253)); n != 0 {
+		t.Errorf("URLDescriptorTo(long) = %d, want 0", n)
+	}
+	if n := URLDescriptorTo(buf[:6], "https://a.io"); n != 0 {
+		t.Errorf("URLDescriptorTo(short buffer) = %d, want 0", n)
+	}
+}
+
+func TestWebUSBCapability(t *testing.T) {
+	w, err := NewWebUSB(0x01, "https://example.com")
+	if err != nil {
+		t.Fatalf("NewWebUSB() error = %v", err)
+	}
+
+	var buf [64]byte
+	n := w.MarshalTo(buf[:])
+	if n != WebUSBCapabilitySize {
+		t.Fatalf("MarshalTo() = %d, want %d", n, WebUSBCapabilitySize)
+	}
+	var desc PlatformCapabilityDescriptor
+	if err := ParsePlatformCapabilityDescriptor(buf[:n], &desc); err != nil {
+		t.Fatalf("ParsePlatformCapabilityDescriptor() error = %v", err)
+	}
+	if desc.UUID != WebUSBPlatformUUID {
+		t.Errorf("UUID = % X, want % X", desc.UUID, WebUSBPlatformUUID)
+	}
+	want := []byte{0x00, 0x01, 0x01, WebUSBLandingPageIndex}
+	if !bytes.Equal(desc.Data, want) {
+		t.Errorf("data = % X, want % X", desc.Data, want)
+	}
+
+	// No landing page
+	w, _ = NewWebUSB(0x01, "")
+	w.MarshalTo(buf[:])
+	if buf[PlatformCapabilityHeaderSize+3] != 0 {
+		t.Errorf("iLandingPage = %d, want 0", buf[PlatformCapabilityHeaderSize+3])
+	}
+
+	if _, err := NewWebUSB(0x01, strings.Repeat("a", 253)); err != pkg.ErrInvalidParameter {
+		t.Errorf("NewWebUSB(long) error = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+}
+
+func TestWebUSBHandleSetup(t *testing.T) {
+	w, _ := NewWebUSB(0x01, "https://example.com")
+
+	getURL := &SetupPacket{
+		RequestType: RequestDirectionDeviceToHost | RequestTypeVendor | RequestRecipientDevice,
+		Request:     0x01,
+		Value:       WebUSBLandingPageIndex,
+		Index:       WebUSBRequestGetURL,
+		Length:      255,
+	}
+	data, handled, err := w.HandleSetup(getURL, nil)
+	if err != nil || !handled {
+		t.Fatalf("HandleSetup() = %v, %v, want handled", handled, err)
+	}
+	want := append([]byte{0x0E, DescriptorTypeWebUSBURL, WebUSBSchemeHTTPS}, "example.com"...)
+	if !bytes.Equal(data, want) {
+		t.Errorf("URL descriptor = % X, want % X", data, want)
+	}
+
+	// Unknown URL indexes are rejected
+	getURL.Value = 2
+	if _, handled, err := w.HandleSetup(getURL, nil); !handled || err != pkg.ErrInvalidRequest {
+		t.Errorf("HandleSetup(index 2) = %v, %v, want handled with %v", handled, err, pkg.ErrInvalidRequest)
+	}
+
+	// Other requests are left to other handlers
+	getURL.Value = WebUSBLandingPageIndex
+	getURL.Index = MSOS20DescriptorIndex
+	if _, handled, _ := w.HandleSetup(getURL, nil); handled {
+		t.Error("HandleSetup() should not handle other vendor requests")
+	}
+}
+
+func TestStackWebUSB(t *testing.T) {
+	set := NewMSOS20DescriptorSet(0x02).CompatibleID("WINUSB", "")
+	dev, err := NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		WithWebUSB(0x01, "https://example.com").
+		WithMSOS20Descriptors(set).
+		AddConfiguration(1).
+		AddInterface(ClassVendor, 0, 0).
+		Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if dev.Descriptor.USBVersion != 0x0210 {
+		t.Errorf("USBVersion = 0x%04X, want 0x0210", dev.Descriptor.USBVersion)
+	}
+	dev.Reset()
+	dev.SetAddress(1)
+
+	hal := newMockHAL()
+	stack := NewStack(dev, hal)
+	stack.Start(context.Background())
+	defer stack.Stop()
+
+	var setup SetupPacket
+	GetDescriptorSetup(&setup, DescriptorTypeBOS, 0, 0xFF)
+	if err := stack.handleSetup(&setup); err != nil {
+		t.Fatalf("handleSetup(BOS) error = %v", err)
+	}
+	hal.mutex.Lock()
+	bos := hal.ep0InData
+	hal.mutex.Unlock()
+	if len(bos) != BOSDescriptorSize+WebUSBCapabilitySize+PlatformCapabilityHeaderSize+8 || bos[4] != 2 {
+		t.Errorf("BOS = % X", bos)
+	}
+
+	getURL := &SetupPacket{
+		RequestType: RequestDirectionDeviceToHost | RequestTypeVendor | RequestRecipientDevice,
+		Request:     0x01,
+		Value:       WebUSBLandingPageIndex,
+		Index:       WebUSBRequestGetURL,
+		Length:      255,
+	}
+	if err := stack.handleSetup(getURL); err != nil {
+		t.Fatalf("handleSetup(GET_URL) error = %v", err)
+	}
+	hal.mutex.Lock()
+	url := hal.ep0InData
+	hal.mutex.Unlock()
+	if string(url[3:]) != "example.com" {
+		t.Errorf("URL = %q, want %q", url[3:], "example.com")
+	}
+}
@@ -2,7 +2,8 @@
 //
 // This example creates a USB host that communicates with a CDC-ACM device
 // (virtual serial port). It uses the FIFO-based HAL to communicate with
-// a device process running in parallel.
+// a device process running in parallel, and the CDC-ACM class driver from
+// github.com/ardnew/softusb/host/class/cdc to talk to the device.
 //
 // Usage:
 //
@@ -31,8 +32,8 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/ardnew/softusb/device/class/cdc"
 	"github.com/ardnew/softusb/host"
+	hostcdc "github.com/ardnew/softusb/host/class/cdc"
 	"github.com/ardnew/softusb/host/hal/fifo"
 	"github.com/ardnew/softusb/pkg"
 )
@@ -42,6 +43,7 @@ const component = pkg.ComponentHost
 
 // Error types for this executable.
 var (
+	errControlFailed = errors.New("SET_CONTROL_LINE_STATE failed")
 	errBulkOutFailed = errors.New("bulk OUT failed")
 	errBulkInFailed  = errors.New("bulk IN failed")
 )
@@ -124,29 +126,22 @@ func main() {
 			"product", dev.Product(),
 			"serial", dev.SerialNumber())
 
-		// Check if this is a CDC-ACM device
-		if !isCDCDevice(dev) {
-			pkg.LogInfo(component, "not a CDC device, skipping")
-			continue
-		}
-
-		pkg.LogInfo(component, "CDC-ACM device detected!")
-
-		// Find bulk endpoints
-		bulkIn, bulkOut := findBulkEndpoints(dev)
-		if bulkIn == 0 || bulkOut == 0 {
-			pkg.LogWarn(component, "could not find bulk endpoints")
+		// Bind the CDC-ACM driver
+		acm, err := hostcdc.NewACM(dev)
+		if err != nil {
+			pkg.LogInfo(component, "not a CDC-ACM device, skipping", "error", err)
 			continue
 		}
 
-		pkg.LogInfo(component, "found bulk endpoints",
-			"bulkIn", bulkIn,
-			"bulkOut", bulkOut)
+		pkg.LogInfo(component, "CDC-ACM device detected!",
+			"control", acm.ControlInterface(),
+			"data", acm.DataInterface())
 
 		// Communicate with device using transfer timeout
-		if err := communicateWithDevice(ctx, dev, bulkIn, bulkOut, *transferTimeout); err != nil {
+		if err := communicateWithDevice(ctx, acm, *transferTimeout); err != nil {
 			pkg.LogError(component, "communication error", "error", err)
 		}
+		acm.Close()
 
 		devicesServiced++
 	}
@@ -154,47 +149,24 @@ func main() {
 	pkg.LogInfo(component, "Serviced devices", "count", devicesServiced)
 }
 
-// isCDCDevice checks if the device is a CDC device.
-func isCDCDevice(dev *host.Device) bool {
-	// Check device class
-	if dev.DeviceClass() == cdc.ClassCDC {
-		return true
-	}
-
-	// Check interface classes
-	for _, iface := range dev.Interfaces() {
-		if iface.InterfaceClass == cdc.ClassCDC ||
-			iface.InterfaceClass == cdc.ClassCDCData {
-			return true
-		}
+// communicateWithDevice sends test data to the device and reads the response.
+func communicateWithDevice(ctx context.Context, acm *hostcdc.ACM, timeout time.Duration) error {
+	// Assert DTR and RTS to signal the terminal is ready
+	controlCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := acm.SetControlLineState(controlCtx, true, true)
+	cancel()
+	if err != nil {
+		return errors.Join(errControlFailed, err)
 	}
 
-	return false
-}
+	acm.SetReadTimeout(timeout)
+	acm.SetWriteTimeout(timeout)
 
-// findBulkEndpoints finds the bulk IN and OUT endpoints.
-func findBulkEndpoints(dev *host.Device) (in, out uint8) {
-	for _, ep := range dev.Endpoints() {
-		if ep.IsBulk() {
-			if ep.IsIn() {
-				in = ep.EndpointAddress
-			} else {
-				out = ep.EndpointAddress
-			}
-		}
-	}
-	return
-}
-
-// communicateWithDevice sends test data to the device and reads the response.
-func communicateWithDevice(ctx context.Context, dev *host.Device, bulkIn, bulkOut uint8, timeout time.Duration) error {
-	// Send test message with timeout
+	// Send test message
 	testMessage := []byte("Hello from USB Host!")
 	pkg.LogInfo(component, "sending data", "data", string(testMessage))
 
-	transferCtx, cancel := context.WithTimeout(ctx, timeout)
-	n, err := dev.BulkTransfer(transferCtx, bulkOut, testMessage)
-	cancel()
+	n, err := acm.Write(testMessage)
 	if err != nil {
 		return errors.Join(errBulkOutFailed, err)
 	}
@@ -203,11 +175,9 @@ func communicateWithDevice(ctx context.Context, dev *host.Device, bulkIn, bulkOu
 	// Wait a bit for device to process
 	time.Sleep(100 * time.Millisecond)
 
-	// Read response with timeout
+	// Read response
 	var buf [64]byte
-	transferCtx, cancel = context.WithTimeout(ctx, timeout)
-	n, err = dev.BulkTransfer(transferCtx, bulkIn, buf[:])
-	cancel()
+	n, err = acm.Read(buf[:])
 	if err != nil {
 		return errors.Join(errBulkInFailed, err)
 	}
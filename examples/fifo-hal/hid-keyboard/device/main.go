@@ -142,15 +142,16 @@ func main() {
 	pkg.LogInfo(component, "Host connected!")
 
 	pkg.LogInfo(component, "typing 'Hello' every 2 seconds")
-	typeString := []byte("Hello\n")
+	typeString := []rune("Hello\n")
 	idx := 0
 
+	// The typist sends a key press and release report for each character
+	typist := hid.NewTypist(keyboard, hid.LayoutUS)
+	typist.SetInterval(50 * time.Millisecond)
+
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 
-	// Boot keyboard report format: [modifiers, reserved, key1, key2, key3, key4, key5, key6]
-	var report [8]byte
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -158,42 +159,12 @@ func main() {
 		case <-ticker.C:
 			if idx < len(typeString) {
 				ch := typeString[idx]
-				keycode := charToKeycode(ch)
-				shift := needsShift(ch)
-
-				// Key press report
-				if shift {
-					report[0] = 0x02 // Left Shift
-				} else {
-					report[0] = 0x00
-				}
-				report[1] = 0x00    // Reserved
-				report[2] = keycode // First key
-				report[3] = 0x00    // Remaining keys
-				report[4] = 0x00
-				report[5] = 0x00
-				report[6] = 0x00
-				report[7] = 0x00
-
-				sendCtx, sendCancel := context.WithTimeout(ctx, *transferTimeout)
-				if err := keyboard.SendReport(sendCtx, report[:]); err != nil {
-					sendCancel()
-					pkg.LogError(component, "SendReport error", "error", err)
-				}
-				sendCancel()
-
-				time.Sleep(50 * time.Millisecond)
 
-				// Key release report (all zeros)
-				for i := range report {
-					report[i] = 0
-				}
-				releaseCtx, releaseCancel := context.WithTimeout(ctx, *transferTimeout)
-				if err := keyboard.SendReport(releaseCtx, report[:]); err != nil {
-					releaseCancel()
-					pkg.LogError(component, "SendReport error", "error", err)
+				typeCtx, typeCancel := context.WithTimeout(ctx, *transferTimeout)
+				if err := typist.TypeRune(typeCtx, ch); err != nil {
+					pkg.LogError(component, "TypeRune error", "error", err)
 				}
-				releaseCancel()
+				typeCancel()
 
 				pkg.LogInfo(component, "Typed:", "char", string(ch))
 				idx++
@@ -204,26 +175,3 @@ func main() {
 		}
 	}
 }
-
-func charToKeycode(ch byte) uint8 {
-	switch {
-	case ch >= 'a' && ch <= 'z':
-		return hid.KeyA + (ch - 'a')
-	case ch >= 'A' && ch <= 'Z':
-		return hid.KeyA + (ch - 'A')
-	case ch >= '1' && ch <= '9':
-		return hid.Key1 + (ch - '1')
-	case ch == '0':
-		return hid.Key0
-	case ch == '\n' || ch == '\r':
-		return hid.KeyEnter
-	case ch == ' ':
-		return hid.KeySpace
-	default:
-		return 0
-	}
-}
-
-func needsShift(ch byte) bool {
-	return ch >= 'A' && ch <= 'Z'
-}
@@ -26,11 +26,12 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/ardnew/softusb/device/class/hid"
 	"github.com/ardnew/softusb/host"
+	"github.com/ardnew/softusb/host/class/hid"
 	"github.com/ardnew/softusb/host/hal/fifo"
 	"github.com/ardnew/softusb/pkg"
 )
@@ -124,19 +125,27 @@ func main() {
 
 		pkg.LogInfo(component, "HID device detected!")
 
-		// Find interrupt IN endpoint
-		intIn := findInterruptInEndpoint(dev)
-		if intIn == 0 {
-			pkg.LogWarn(component, "could not find interrupt IN endpoint")
+		// Bind the HID class driver (reads the report descriptor)
+		bindCtx, bindCancel := context.WithTimeout(ctx, *transferTimeout)
+		kbd, err := hid.New(bindCtx, dev)
+		bindCancel()
+		if err != nil {
+			pkg.LogError(component, "failed to bind HID driver", "error", err)
 			continue
 		}
 
-		pkg.LogInfo(component, "found interrupt endpoint", "interruptIn", intIn)
+		desc := kbd.ReportDescriptor()
+		pkg.LogInfo(component, "HID interface bound",
+			"interface", kbd.Interface(),
+			"bootProtocol", kbd.BootProtocol(),
+			"reportDescriptorLength", len(kbd.RawReportDescriptor()),
+			"inputReports", countReports(desc, hid.ReportTypeInput))
 
 		// Read HID reports from device
-		if err := readHIDReports(ctx, dev, intIn, *transferTimeout); err != nil {
+		if err := readHIDReports(ctx, kbd, *transferTimeout); err != nil {
 			pkg.LogError(component, "read error", "error", err)
 		}
+		kbd.Close()
 
 		devicesServiced++
 	}
@@ -155,21 +164,22 @@ func isHIDDevice(dev *host.Device) bool {
 	return false
 }
 
-// findInterruptInEndpoint finds the interrupt IN endpoint.
-func findInterruptInEndpoint(dev *host.Device) uint8 {
-	for _, ep := range dev.Endpoints() {
-		if ep.IsInterrupt() && ep.IsIn() {
-			return ep.EndpointAddress
+// countReports returns the number of reports of type typ in desc.
+func countReports(desc *hid.ReportDescriptor, typ hid.ReportType) int {
+	n := 0
+	for _, r := range desc.Reports {
+		if r.Type == typ {
+			n++
 		}
 	}
-	return 0
+	return n
 }
 
-// readHIDReports reads and displays HID reports from the device.
-func readHIDReports(ctx context.Context, dev *host.Device, endpoint uint8, timeout time.Duration) error {
+// readHIDReports reads, decodes, and displays HID reports from the device.
+func readHIDReports(ctx context.Context, kbd *hid.HID, timeout time.Duration) error {
 	pkg.LogInfo(component, "reading HID reports")
 
-	var buf [8]byte // Boot keyboard report is 8 bytes
+	var buf [64]byte
 	reportCount := 0
 
 	for {
@@ -181,113 +191,98 @@ func readHIDReports(ctx context.Context, dev *host.Device, endpoint uint8, timeo
 
 		// Create timeout context for this transfer
 		transferCtx, cancel := context.WithTimeout(ctx, timeout)
-
-		// Read interrupt transfer
-		n, err := dev.InterruptTransfer(transferCtx, endpoint, buf[:])
+		n, err := kbd.ReadReport(transferCtx, buf[:])
 		cancel()
 		if err != nil {
-			// Timeout or NAK is normal for interrupt endpoints
+			// Timeout is normal while no key is pressed
 			time.Sleep(10 * time.Millisecond)
 			continue
 		}
 
-		if n > 0 {
-			reportCount++
-
-			// Parse boot keyboard report
-			if n >= 8 {
-				modifiers := buf[0]
-				keycodes := buf[2:8]
-
-				// Build list of modifier names
-				var modNames []string
-				if modifiers&0x01 != 0 {
-					modNames = append(modNames, "LCtrl")
-				}
-				if modifiers&0x02 != 0 {
-					modNames = append(modNames, "LShift")
-				}
-				if modifiers&0x04 != 0 {
-					modNames = append(modNames, "LAlt")
-				}
-				if modifiers&0x08 != 0 {
-					modNames = append(modNames, "LWin")
-				}
-				if modifiers&0x10 != 0 {
-					modNames = append(modNames, "RCtrl")
-				}
-				if modifiers&0x20 != 0 {
-					modNames = append(modNames, "RShift")
-				}
-				if modifiers&0x40 != 0 {
-					modNames = append(modNames, "RAlt")
-				}
-				if modifiers&0x80 != 0 {
-					modNames = append(modNames, "RWin")
-				}
-
-				// Build list of pressed keys
-				var keys []any
-				for _, kc := range keycodes {
-					if kc != 0 {
-						ch := keycodeToChar(kc, modifiers&0x22 != 0) // Check shift
-						if ch != 0 {
-							keys = append(keys, "keycode", kc, "char", string(ch))
-						} else {
-							keys = append(keys, "keycode", kc)
-						}
-					}
-				}
-
-				logArgs := []any{
-					"reportNum", reportCount,
-					"rawData", buf[:n],
-					"modifiers", modifiers,
-				}
-				if len(modNames) > 0 {
-					logArgs = append(logArgs, "modifierNames", modNames)
-				}
-				if len(keys) > 0 {
-					logArgs = append(logArgs, keys...)
-				}
-				pkg.LogInfo(component, "Report", logArgs...)
-			} else {
-				pkg.LogInfo(component, "Report",
-					"reportNum", reportCount,
-					"rawData", buf[:n])
+		reportCount++
+
+		_, values, err := kbd.Decode(buf[:n])
+		if err != nil {
+			pkg.LogInfo(component, "Report",
+				"reportNum", reportCount,
+				"rawData", buf[:n],
+				"error", err)
+			continue
+		}
+
+		// Collect pressed modifiers, then keys
+		var modNames []string
+		shift := false
+		for _, v := range values {
+			if v.Usage.Page() == hid.UsagePageKeyboard && isModifier(v.Usage) && v.Value != 0 {
+				modNames = append(modNames, hid.UsageName(v.Usage))
+				shift = shift || v.Usage.ID() == usageLeftShift || v.Usage.ID() == usageRightShift
 			}
+		}
 
-			// Stop after 20 reports for demo purposes
-			if reportCount >= 20 {
-				pkg.LogInfo(component, "Received 20 reports, stopping")
-				return nil
+		var keys []any
+		for _, v := range values {
+			if v.Usage.Page() != hid.UsagePageKeyboard || isModifier(v.Usage) || v.Value == 0 {
+				continue
+			}
+			if ch := usageToChar(v.Usage, shift); ch != "" {
+				keys = append(keys, "key", hid.UsageName(v.Usage), "char", ch)
+			} else {
+				keys = append(keys, "key", hid.UsageName(v.Usage))
 			}
 		}
-	}
-}
 
-// keycodeToChar converts a HID keycode to a character.
-func keycodeToChar(kc uint8, shift bool) byte {
-	if kc >= hid.KeyA && kc <= hid.KeyZ {
-		ch := 'a' + (kc - hid.KeyA)
-		if shift {
-			ch = ch - 32 // Convert to uppercase
+		logArgs := []any{
+			"reportNum", reportCount,
+			"rawData", buf[:n],
 		}
-		return ch
-	}
+		if len(modNames) > 0 {
+			logArgs = append(logArgs, "modifierNames", modNames)
+		}
+		if len(keys) > 0 {
+			logArgs = append(logArgs, keys...)
+		}
+		pkg.LogInfo(component, "Report", logArgs...)
 
-	if kc >= hid.Key1 && kc <= hid.Key9 {
-		return '1' + (kc - hid.Key1)
+		// Stop after 20 reports for demo purposes
+		if reportCount >= 20 {
+			pkg.LogInfo(component, "Received 20 reports, stopping")
+			return nil
+		}
 	}
+}
 
-	switch kc {
-	case hid.Key0:
-		return '0'
-	case hid.KeySpace:
-		return ' '
-	case hid.KeyEnter:
-		return '\n'
-	}
+// Keyboard/Keypad page usage IDs used to interpret reports.
+const (
+	usageKeyA        = 0x04
+	usageKey0        = 0x27
+	usageKeyEnter    = 0x28
+	usageKeySpace    = 0x2C
+	usageLeftControl = 0xE0
+	usageLeftShift   = 0xE1
+	usageRightShift  = 0xE5
+	usageRightGUI    = 0xE7
+)
+
+// isModifier returns true for the modifier key usages.
+func isModifier(u hid.Usage) bool {
+	return u.ID() >= usageLeftControl && u.ID() <= usageRightGUI
+}
 
-	return 0
+// usageToChar converts a letter, digit, space, or enter key usage to the
+// character it types, or returns an empty string.
+func usageToChar(u hid.Usage, shift bool) string {
+	switch id := u.ID(); {
+	case id >= usageKeyA && id <= usageKey0:
+		name := hid.UsageName(u) // "A" through "Z", "1" through "0"
+		if !shift {
+			return strings.ToLower(name)
+		}
+		return name
+	case id == usageKeySpace:
+		return " "
+	case id == usageKeyEnter:
+		return "\n"
+	}
+	return ""
 }
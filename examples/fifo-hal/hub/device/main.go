@@ -0,0 +1,200 @@
+// Package main provides a USB hub device example using the FIFO HAL.
+//
+// This example creates a 4-port USB hub with a CDC-ACM device attached to
+// downstream port 1. It uses the FIFO-based HAL to communicate with a host
+// process running in parallel.
+//
+// Usage:
+//
+//	go run . [options] /path/to/bus-dir
+//
+// The bus directory is shared with the host process. The hub creates its
+// own subdirectory (device-{uuid}/) for USB communication via named pipes.
+// The downstream device creates its subdirectory inside the hub's, under
+// port1/, where the host connects to it once the hub reports it attached.
+//
+// Options:
+//
+//	-v                         Enable verbose (debug) logging
+//	-json                      Use JSON log format
+//	-enum-timeout duration     Timeout for enumeration (default: 10s)
+//	-transfer-timeout duration Timeout for data transfers (default: 5s)
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ardnew/softusb/device"
+	"github.com/ardnew/softusb/device/class/cdc"
+	"github.com/ardnew/softusb/device/class/hub"
+	"github.com/ardnew/softusb/device/hal/fifo"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// component identifies this executable for structured logging.
+const component = pkg.ComponentDevice
+
+// Hub configuration.
+const (
+	numPorts       = 4    // Number of downstream ports
+	statusEndpoint = 0x81 // Status change interrupt IN endpoint
+	childPort      = 1    // Port the CDC-ACM device is attached to
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "enable verbose (debug) logging")
+	jsonLog := flag.Bool("json", false, "use JSON log format")
+	enumTimeout := flag.Duration("enum-timeout", 10*time.Second, "timeout for enumeration")
+	transferTimeout := flag.Duration("transfer-timeout", 5*time.Second, "timeout for data transfers")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		pkg.LogError(component, "missing bus directory argument",
+			"usage", "device [options] <bus-dir>")
+		os.Exit(1)
+	}
+
+	busDir := flag.Arg(0)
+
+	// Set up logging
+	if *verbose {
+		pkg.SetLogLevel(slog.LevelDebug)
+	}
+	if *jsonLog {
+		pkg.SetLogFormat(pkg.LogFormatJSON)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle signals
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		pkg.LogInfo(component, "shutting down")
+		cancel()
+	}()
+
+	// Create the hub class driver
+	hubDriver := hub.New(numPorts)
+	hubDriver.SetOnPortReset(func(port int) {
+		pkg.LogInfo(component, "port reset", "port", port)
+	})
+
+	builder := device.NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5680).
+		WithStrings("softusb example", "FIFO Hub", "HUB00001").
+		AddConfiguration(1)
+	hubDriver.ConfigureDevice(builder, statusEndpoint)
+
+	hubDev, err := builder.Build(ctx)
+	if err != nil {
+		pkg.LogError(component, "failed to build hub", "error", err)
+		os.Exit(1)
+	}
+	if err := hubDriver.AttachToInterface(hubDev, 1, 0); err != nil {
+		pkg.LogError(component, "failed to attach hub driver", "error", err)
+		os.Exit(1)
+	}
+
+	hubHAL := fifo.New(busDir)
+	hubStack := device.NewStack(hubDev, hubHAL)
+	hubDriver.SetStack(hubStack)
+
+	pkg.LogInfo(component, "starting hub device", "busDir", busDir, "ports", numPorts)
+	if err := hubStack.Start(ctx); err != nil {
+		pkg.LogError(component, "failed to start hub", "error", err)
+		os.Exit(1)
+	}
+	defer hubStack.Stop()
+
+	// Create the downstream CDC-ACM device in the hub's port directory
+	childHAL := fifo.New(filepath.Join(hubHAL.DeviceDir(), fmt.Sprintf("port%d", childPort)))
+
+	builder = device.NewDeviceBuilder().
+		WithVendorProduct(0x1234, 0x5678).
+		WithStrings("softusb example", "CDC-ACM Behind Hub", "12345678").
+		AddConfiguration(1)
+
+	acm := cdc.NewACM()
+	acm.ConfigureDevice(builder, 0x81, 0x82, 0x02)
+
+	childDev, err := builder.Build(ctx)
+	if err != nil {
+		pkg.LogError(component, "failed to build downstream device", "error", err)
+		os.Exit(1)
+	}
+	if err := acm.AttachToInterfaces(childDev, 1, 0, 1); err != nil {
+		pkg.LogError(component, "failed to attach ACM driver", "error", err)
+		os.Exit(1)
+	}
+
+	childStack := device.NewStack(childDev, childHAL)
+	acm.SetStack(childStack)
+
+	if err := childStack.Start(ctx); err != nil {
+		pkg.LogError(component, "failed to start downstream device", "error", err)
+		os.Exit(1)
+	}
+	defer childStack.Stop()
+
+	if err := hubDriver.AttachPort(childPort, childStack); err != nil {
+		pkg.LogError(component, "failed to attach downstream device", "error", err)
+		os.Exit(1)
+	}
+	pkg.LogInfo(component, "downstream device attached",
+		"port", childPort,
+		"deviceDir", childHAL.DeviceDir())
+
+	// Wait for connection with enumeration timeout
+	pkg.LogInfo(component, "waiting for host connection")
+	enumCtx, enumCancel := context.WithTimeout(ctx, *enumTimeout)
+	defer enumCancel()
+
+	if err := hubStack.WaitConnect(enumCtx); err != nil {
+		pkg.LogError(component, "connection failed", "error", err)
+		os.Exit(1)
+	}
+	pkg.LogInfo(component, "Host connected!")
+
+	// Main loop - echo any data received by the downstream device
+	var buf [64]byte
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		transferCtx, transferCancel := context.WithTimeout(ctx, *transferTimeout)
+		n, err := acm.Read(transferCtx, buf[:])
+		transferCancel()
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		if n > 0 {
+			pkg.LogInfo(component, "received data",
+				"port", childPort,
+				"bytes", n,
+				"data", string(buf[:n]))
+
+			transferCtx, transferCancel = context.WithTimeout(ctx, *transferTimeout)
+			_, err = acm.Write(transferCtx, buf[:n])
+			transferCancel()
+			if err != nil {
+				pkg.LogError(component, "write error", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package main provides integration tests for the hub FIFO HAL example.
+//
+// This package contains integration tests that verify the host stack
+// enumerates an external USB hub and the device attached to one of its
+// downstream ports, using the FIFO-based HAL.
+//
+// # Running Tests
+//
+// Run the integration tests with:
+//
+//	go test -v ./examples/fifo-hal/hub/
+//
+// Override default timeouts with test flags:
+//
+//	go test -v ./examples/fifo-hal/hub/ -args \
+//	    -enum-timeout=15s \
+//	    -transfer-timeout=10s
+//
+// # Test Flags
+//
+// The following flags are forwarded to the host and device subprocesses:
+//
+//   - -enum-timeout: Timeout for enumeration (default 10s)
+//   - -transfer-timeout: Timeout for data transfers (default 5s)
+//   - -json: Use JSON log format
+//
+// # Test Cases
+//
+// TestHubIntegration verifies hub enumeration, downstream port reset,
+// enumeration of the downstream device at tier 2, and data exchange with it.
+//
+// # Structure
+//
+// The actual device and host implementations are in subdirectories:
+//
+//   - device/: 4-port hub with a CDC-ACM echo device on port 1
+//   - host/: host that enumerates the hub and talks to the downstream device
+//
+// Both support command-line flags for timeout configuration:
+//
+//   - -v: Enable verbose (debug) logging
+//   - -json: Use JSON log format
+//   - -enum-timeout: Timeout for enumeration (default 10s)
+//   - -transfer-timeout: Timeout for data transfers (default 5s)
+//   - -hotplug-limit: Number of downstream devices to service (host only, default 1)
+package main
+
+// main is a stub function to make this a valid main package.
+// The actual functionality is in the device/ and host/ subdirectories.
+// This package only contains integration tests.
+func main() {}
@@ -0,0 +1,169 @@
+// Package main provides integration tests for the hub FIFO HAL example.
+//
+// These tests verify that the host enumerates an external hub and the
+// device attached to its downstream port, and exchanges data with the
+// downstream device, using the FIFO-based HAL.
+//
+// Run with: go test -v ./examples/fifo-hal/hub/
+//
+// The tests support overriding timeouts via flags:
+//
+//	go test -v ./examples/fifo-hal/hub/ -args \
+//	    -enum-timeout=15s \
+//	    -transfer-timeout=10s
+//
+// Note: Use -args to pass flags to the test binary (after the test flags).
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test flags that are forwarded to host and device commands.
+var (
+	enumTimeout     = flag.Duration("enum-timeout", 10*time.Second, "timeout for enumeration")
+	transferTimeout = flag.Duration("transfer-timeout", 5*time.Second, "timeout for data transfers")
+	jsonLog         = flag.Bool("json", false, "use JSON log format")
+)
+
+// TestHubIntegration tests enumeration of a device behind a hub.
+func TestHubIntegration(t *testing.T) {
+	// Create temporary bus directory
+	busDir, err := os.MkdirTemp("", "softusb-hub-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(busDir)
+
+	// Build host and device executables
+	hostBin := filepath.Join(busDir, "host")
+	deviceBin := filepath.Join(busDir, "device")
+
+	if err := buildExecutable("./host", hostBin); err != nil {
+		t.Fatalf("Failed to build host: %v", err)
+	}
+
+	if err := buildExecutable("./device", deviceBin); err != nil {
+		t.Fatalf("Failed to build device: %v", err)
+	}
+
+	// Create context with overall test timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Start host expecting 1 downstream device
+	// Always pass -v to ensure info-level logs are captured for test assertions
+	hostArgs := []string{
+		"-v",
+		"-hotplug-limit", "1",
+		"-enum-timeout", enumTimeout.String(),
+		"-transfer-timeout", transferTimeout.String(),
+	}
+	if *jsonLog {
+		hostArgs = append(hostArgs, "-json")
+	}
+	hostArgs = append(hostArgs, busDir)
+
+	var hostBuf bytes.Buffer
+	hostCmd := exec.CommandContext(ctx, hostBin, hostArgs...)
+	hostCmd.Stdout = &hostBuf
+	hostCmd.Stderr = &hostBuf // Merge stderr into stdout
+
+	if err := hostCmd.Start(); err != nil {
+		t.Fatalf("Failed to start host: %v", err)
+	}
+
+	// Give host time to start
+	time.Sleep(500 * time.Millisecond)
+
+	// Start hub device
+	deviceArgs := []string{
+		"-v",
+		"-enum-timeout", enumTimeout.String(),
+		"-transfer-timeout", transferTimeout.String(),
+	}
+	if *jsonLog {
+		deviceArgs = append(deviceArgs, "-json")
+	}
+	deviceArgs = append(deviceArgs, busDir)
+
+	var deviceBuf bytes.Buffer
+	deviceCmd := exec.CommandContext(ctx, deviceBin, deviceArgs...)
+	deviceCmd.Stdout = &deviceBuf
+	deviceCmd.Stderr = &deviceBuf // Merge stderr into stdout
+
+	if err := deviceCmd.Start(); err != nil {
+		t.Fatalf("Failed to start device: %v", err)
+	}
+
+	// Wait for host to complete
+	done := make(chan error, 1)
+	go func() {
+		done <- hostCmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil && ctx.Err() == nil {
+			t.Logf("Host exited with error: %v", err)
+		}
+	case <-time.After(25 * time.Second):
+		t.Logf("Test timeout waiting for host")
+	}
+
+	// Cleanup
+	cancel()
+	if deviceCmd.Process != nil {
+		_ = deviceCmd.Process.Kill()
+		_ = deviceCmd.Wait()
+	}
+
+	hostOutput := hostBuf.String()
+	deviceOutput := deviceBuf.String()
+	t.Logf("Host output:\n%s", hostOutput)
+	t.Logf("Device output:\n%s", deviceOutput)
+
+	// Check for expected output
+	if !strings.Contains(hostOutput, "Hub connected") {
+		t.Error("Host did not detect hub")
+	}
+
+	if !strings.Contains(hostOutput, "Downstream device connected") {
+		t.Error("Host did not enumerate downstream device")
+	}
+
+	if !strings.Contains(hostOutput, "tier=2") {
+		t.Error("Downstream device not reported at tier 2")
+	}
+
+	if !strings.Contains(hostOutput, "Received data") {
+		t.Error("Host did not receive echoed data from downstream device")
+	}
+
+	if !strings.Contains(deviceOutput, "port reset") {
+		t.Error("Hub did not receive a port reset")
+	}
+
+	if !strings.Contains(deviceOutput, "received data") {
+		t.Error("Downstream device did not receive data from host")
+	}
+}
+
+// buildExecutable builds a Go executable from source.
+func buildExecutable(srcDir, output string) error {
+	cmd := exec.Command("go", "build", "-o", output, srcDir)
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	cmd.Dir = wd
+	return cmd.Run()
+}
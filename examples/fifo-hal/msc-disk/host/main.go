@@ -2,7 +2,9 @@
 //
 // This example creates a USB host that communicates with an MSC device.
 // It uses the FIFO-based HAL to communicate with a device process running
-// in parallel.
+// in parallel, and the Bulk-Only Transport driver from
+// github.com/ardnew/softusb/host/class/msc to identify the disk and verify
+// a block read/write round trip.
 //
 // Usage:
 //
@@ -19,7 +21,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -28,8 +32,8 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/ardnew/softusb/device/class/msc"
 	"github.com/ardnew/softusb/host"
+	hostmsc "github.com/ardnew/softusb/host/class/msc"
 	"github.com/ardnew/softusb/host/hal/fifo"
 	"github.com/ardnew/softusb/pkg"
 )
@@ -105,18 +109,22 @@ func main() {
 		fmt.Printf("  Product: %s\n", dev.Product())
 		fmt.Printf("  Serial: %s\n", dev.SerialNumber())
 
-		// Check if this is an MSC device
-		if !isMSCDevice(dev) {
-			fmt.Println("Not an MSC device, skipping...")
+		// Bind the mass storage driver
+		bindCtx, bindCancel := context.WithTimeout(ctx, *enumTimeout)
+		disk, err := hostmsc.New(bindCtx, dev)
+		bindCancel()
+		if err != nil {
+			fmt.Printf("Not an MSC device, skipping... (%v)\n", err)
 			continue
 		}
 
 		fmt.Println("MSC device detected!")
 
 		// Test basic operations
-		if err := testMSCDevice(ctx, dev, *transferTimeout); err != nil {
+		if err := testMSCDevice(ctx, disk, *transferTimeout); err != nil {
 			fmt.Fprintf(os.Stderr, "MSC test error: %v\n", err)
 		}
+		disk.Close()
 
 		devicesServiced++
 	}
@@ -124,27 +132,44 @@ func main() {
 	fmt.Printf("Serviced %d device(s)\n", devicesServiced)
 }
 
-// isMSCDevice checks if the device is an MSC device.
-func isMSCDevice(dev *host.Device) bool {
-	for _, iface := range dev.Interfaces() {
-		if iface.InterfaceClass == msc.ClassMSC {
-			return true
-		}
+// testMSCDevice identifies LUN 0, then writes a test pattern across a
+// block boundary and reads it back.
+func testMSCDevice(ctx context.Context, disk *hostmsc.MSC, timeout time.Duration) error {
+	fmt.Println("\nTesting MSC device...")
+
+	openCtx, cancel := context.WithTimeout(ctx, timeout)
+	lun, err := disk.Open(openCtx, 0)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("open LUN 0: %w", err)
 	}
-	return false
-}
 
-// testMSCDevice performs basic MSC operations.
-func testMSCDevice(ctx context.Context, dev *host.Device, timeout time.Duration) error {
-	fmt.Println("\nTesting MSC device...")
+	inq := lun.Inquiry()
+	fmt.Printf("✓ INQUIRY: %s %s %s\n", inq.VendorID, inq.ProductID, inq.ProductRev)
+	fmt.Printf("✓ READ CAPACITY: %d blocks of %d bytes (%d bytes)\n",
+		lun.BlockCount(), lun.BlockSize(), lun.Size())
 
-	// For now, just print that we detected it
-	// Full SCSI command implementation would be in host/class/msc package
-	fmt.Println("✓ Device enumerated successfully")
-	fmt.Println("✓ MSC interface detected")
+	disk.SetTimeout(timeout)
 
-	fmt.Println("\nNote: Full SCSI command testing requires host MSC class driver")
-	fmt.Println("This example demonstrates device enumeration and class detection")
+	pattern := make([]byte, 2*lun.BlockSize())
+	for i := range pattern {
+		pattern[i] = byte(i * 7)
+	}
+	off := int64(lun.BlockSize()) / 2
+
+	if _, err := lun.WriteAt(pattern, off); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	fmt.Printf("✓ WRITE: %d bytes at offset %d\n", len(pattern), off)
+
+	readBack := make([]byte, len(pattern))
+	if _, err := lun.ReadAt(readBack, off); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if !bytes.Equal(readBack, pattern) {
+		return errors.New("read data does not match written data")
+	}
+	fmt.Printf("✓ READ: %d bytes verified\n", len(readBack))
 
 	return nil
 }
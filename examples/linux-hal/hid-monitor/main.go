@@ -15,6 +15,8 @@ import (
 	"syscall"
 	"unsafe"
 
+	"github.com/ardnew/softusb/host"
+	"github.com/ardnew/softusb/host/class/hid"
 	"github.com/ardnew/softusb/host/hal"
 	"github.com/ardnew/softusb/host/hal/linux"
 	"github.com/ardnew/softusb/pkg"
@@ -124,14 +126,56 @@ type hidReportEvent struct {
 	ifaceNum  uint8
 	reportLen int
 	data      []byte
+	reportID  uint8
+	values    []hid.Value // nil if the report could not be decoded
 }
 
 func (e hidReportEvent) log() {
-	pkg.LogInfo(componentMonitor, "hid report",
+	attrs := []any{
 		"port", e.port,
 		"interface", e.ifaceNum,
 		"length", e.reportLen,
-		"data", hex.EncodeToString(e.data))
+		"data", hex.EncodeToString(e.data),
+	}
+	if e.values != nil {
+		if e.reportID != 0 {
+			attrs = append(attrs, "report_id", e.reportID)
+		}
+		fields := make([]string, len(e.values))
+		for i, v := range e.values {
+			fields[i] = v.String()
+		}
+		attrs = append(attrs, "fields", fields)
+	}
+	pkg.LogInfo(componentMonitor, "hid report", attrs...)
+}
+
+// reportDescriptorEvent is sent when an HID report descriptor is parsed.
+type reportDescriptorEvent struct {
+	port     int
+	ifaceNum uint8
+	length   int
+	desc     *hid.ReportDescriptor
+}
+
+func (e reportDescriptorEvent) log() {
+	attrs := []any{
+		"port", e.port,
+		"interface", e.ifaceNum,
+		"length", e.length,
+		"report_ids", e.desc.HasReportIDs(),
+	}
+	for _, c := range e.desc.Collections {
+		attrs = append(attrs, "collection", c.Usage.String())
+	}
+	for _, r := range e.desc.Reports {
+		attrs = append(attrs, slog.Group("report",
+			"type", r.Type.String(),
+			"id", r.ID,
+			"size", r.Size(),
+			"fields", len(r.Fields)))
+	}
+	pkg.LogInfo(componentMonitor, "hid report descriptor", attrs...)
 }
 
 // errorEvent is sent when an error occurs.
@@ -656,12 +700,31 @@ func handleDevice(ctx context.Context, halImpl hal.HostHAL, addr hal.DeviceAddre
 		return
 	}
 
-	// Claim interfaces and start reading HID reports
+	// Claim interfaces, parse report descriptors, and start reading HID reports
 	for _, iface := range hidInterfaces {
 		if err := halImpl.ClaimInterface(addr, iface.number); err != nil {
 			outputCh <- interfaceClaimErrorEvent{port: port, ifaceNum: iface.number, err: err}
 			continue
 		}
+
+		raw, err := readReportDescriptor(ctx, halImpl, addr, iface)
+		if err == nil {
+			iface.reportDesc, err = hid.ParseReportDescriptor(raw)
+		}
+		if err != nil {
+			outputCh <- errorEvent{
+				message: "failed to read report descriptor",
+				err:     fmt.Errorf("port %d interface %d: %w", port, iface.number, err),
+			}
+		} else {
+			outputCh <- reportDescriptorEvent{
+				port:     port,
+				ifaceNum: iface.number,
+				length:   len(raw),
+				desc:     iface.reportDesc,
+			}
+		}
+
 		go readHIDReports(ctx, halImpl, addr, port, iface)
 	}
 }
@@ -754,11 +817,13 @@ func getSupportedLanguageID(ctx context.Context, halImpl hal.HostHAL, addr hal.D
 
 // hidInterface describes an HID interface with its interrupt endpoint.
 type hidInterface struct {
-	number    uint8
-	subclass  uint8
-	protocol  uint8
-	epAddr    uint8
-	maxPacket uint16
+	number        uint8
+	subclass      uint8
+	protocol      uint8
+	epAddr        uint8
+	maxPacket     uint16
+	reportDescLen uint16
+	reportDesc    *hid.ReportDescriptor // nil if unavailable
 }
 
 // parseHIDInterfaces parses configuration descriptor to find HID interfaces.
@@ -787,7 +852,7 @@ func parseHIDInterfaces(data []byte) []hidInterface {
 				ifaceSubclass := data[i+6]
 				ifaceProtocol := data[i+7]
 
-				if ifaceClass == 0x03 { // HID class
+				if ifaceClass == hid.ClassHID {
 					interfaces = append(interfaces, hidInterface{
 						number:   ifaceNum,
 						subclass: ifaceSubclass,
@@ -799,6 +864,12 @@ func parseHIDInterfaces(data []byte) []hidInterface {
 				}
 			}
 
+		case hid.DescriptorTypeHID:
+			var hidDesc hid.HIDDescriptor
+			if currentIface != nil && hid.ParseHIDDescriptor(data[i:i+length], &hidDesc) {
+				currentIface.reportDescLen = hidDesc.ReportDescLen
+			}
+
 		case 0x05: // Endpoint descriptor
 			if length >= 7 && currentIface != nil {
 				epAddr := data[i+2]
@@ -819,6 +890,29 @@ func parseHIDInterfaces(data []byte) []hidInterface {
 	return interfaces
 }
 
+// readReportDescriptor reads the report descriptor of an HID interface.
+func readReportDescriptor(ctx context.Context, halImpl hal.HostHAL, addr hal.DeviceAddress, iface hidInterface) ([]byte, error) {
+	length := iface.reportDescLen
+	if length == 0 || length > hid.MaxReportDescriptorSize {
+		length = hid.MaxReportDescriptorSize
+	}
+
+	setup := &hal.SetupPacket{
+		RequestType: host.RequestTypeIn | host.RequestTypeStandard | host.RequestTypeInterface,
+		Request:     host.RequestGetDescriptor,
+		Value:       uint16(hid.DescriptorTypeReport) << 8,
+		Index:       uint16(iface.number),
+		Length:      length,
+	}
+
+	buf := make([]byte, length)
+	n, err := halImpl.ControlTransfer(ctx, addr, setup, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
 // readHIDReports continuously reads HID reports from an interrupt endpoint.
 func readHIDReports(ctx context.Context, halImpl hal.HostHAL, addr hal.DeviceAddress, port int, iface hidInterface) {
 	reportBuf := make([]byte, iface.maxPacket)
@@ -850,12 +944,22 @@ func readHIDReports(ctx context.Context, halImpl hal.HostHAL, addr hal.DeviceAdd
 			// Make a copy of the data for the event
 			dataCopy := make([]byte, n)
 			copy(dataCopy, reportBuf[:n])
-			outputCh <- hidReportEvent{
+			event := hidReportEvent{
 				port:      port,
 				ifaceNum:  iface.number,
 				reportLen: n,
 				data:      dataCopy,
 			}
+			if iface.reportDesc != nil {
+				if report, values, err := iface.reportDesc.Decode(hid.ReportTypeInput, dataCopy); err == nil {
+					event.reportID = report.ID
+					event.values = values
+					if values == nil {
+						event.values = []hid.Value{}
+					}
+				}
+			}
+			outputCh <- event
 		}
 	}
 }
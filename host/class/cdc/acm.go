@@ -0,0 +1,536 @@
+package cdc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ardnew/softusb/host"
+	"github.com/ardnew/softusb/host/hal"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// MaxRxBufferSize is the size of the bulk IN transfer buffer.
+const MaxRxBufferSize = 4096
+
+// anyInterface selects the first CDC-ACM function of a device.
+const anyInterface = -1
+
+// ACM implements a host-side CDC-ACM (Abstract Control Model) class driver.
+// It binds to one CDC-ACM function of an enumerated device and exposes its
+// data interface as an [io.ReadWriteCloser].
+type ACM struct {
+	dev *host.Device
+
+	// Interfaces
+	controlIface uint8
+	dataIface    uint8
+
+	// Endpoints (notifyEP is 0 if the function has none)
+	notifyEP      uint8
+	dataInEP      uint8
+	dataOutEP     uint8
+	maxPacketSize int
+
+	// Line state
+	lineCoding   LineCoding
+	controlState uint16
+	serialState  uint16
+
+	// Callbacks
+	onSerialState func(state uint16)
+
+	// Timeouts for Read and Write (0 means none)
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// Receive state (guarded by rxMutex)
+	rxBuf   [MaxRxBufferSize]byte
+	rxPos   int
+	rxLen   int
+	rxMutex sync.Mutex
+
+	// Serializes writes
+	txMutex sync.Mutex
+
+	// Cancels transfers in progress on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewACM binds a CDC-ACM class driver to the first CDC-ACM function of dev
+// and claims its interfaces. dev must be configured.
+//
+// The control and data interfaces are paired by the Union Functional
+// Descriptor of the communications interface. Functions without one are
+// paired with the first data class interface that follows.
+// Returns pkg.ErrNotSupported if dev has no CDC-ACM function.
+func NewACM(dev *host.Device) (*ACM, error) {
+	return newACM(dev, anyInterface)
+}
+
+// NewACMInterface binds a CDC-ACM class driver to the CDC-ACM function of
+// dev whose communications interface is controlIfaceNum, for devices that
+// provide several serial ports. See [NewACM].
+func NewACMInterface(dev *host.Device, controlIfaceNum uint8) (*ACM, error) {
+	return newACM(dev, int(controlIfaceNum))
+}
+
+// newACM binds to the CDC-ACM function with the given communications
+// interface number, or the first one if controlIfaceNum is anyInterface.
+func newACM(dev *host.Device, controlIfaceNum int) (*ACM, error) {
+	a := &ACM{
+		dev:        dev,
+		lineCoding: DefaultLineCoding,
+	}
+	if !a.bind(controlIfaceNum) {
+		return nil, pkg.ErrNotSupported
+	}
+
+	if err := dev.ClaimInterface(a.controlIface); err != nil {
+		return nil, err
+	}
+	if err := dev.ClaimInterface(a.dataIface); err != nil {
+		dev.ReleaseInterface(a.controlIface)
+		return nil, err
+	}
+
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	pkg.LogDebug(pkg.ComponentHost, "CDC-ACM bound",
+		"control", a.controlIface,
+		"data", a.dataIface,
+		"notify", a.notifyEP,
+		"in", a.dataInEP,
+		"out", a.dataOutEP)
+
+	return a, nil
+}
+
+// bind locates the interfaces and endpoints of a CDC-ACM function.
+// Returns false if no matching function was found.
+func (a *ACM) bind(controlIfaceNum int) bool {
+	ifaces := a.dev.Interfaces()
+	for i := range ifaces {
+		ctrl := &ifaces[i]
+		if ctrl.InterfaceClass != ClassCDC || ctrl.InterfaceSubClass != SubclassACM ||
+			ctrl.AlternateSetting != 0 {
+			continue
+		}
+		if controlIfaceNum != anyInterface && int(ctrl.InterfaceNumber) != controlIfaceNum {
+			continue
+		}
+
+		dataNum, ok := unionSubordinate(a.dev.ClassDescriptors(i))
+		if !ok {
+			// No union descriptor: assume the next data interface
+			for j := i + 1; j < len(ifaces); j++ {
+				if ifaces[j].InterfaceClass == ClassCDCData {
+					dataNum, ok = ifaces[j].InterfaceNumber, true
+					break
+				}
+			}
+		}
+		if !ok || !a.bindData(dataNum) {
+			continue
+		}
+
+		a.controlIface = ctrl.InterfaceNumber
+		a.notifyEP = 0
+		for _, ep := range a.dev.InterfaceEndpoints(i) {
+			if ep.IsInterrupt() && ep.IsIn() {
+				a.notifyEP = ep.EndpointAddress
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// bindData locates the bulk endpoints of the data interface.
+// Returns false if the interface has no alternate setting 0 with a bulk IN
+// and a bulk OUT endpoint.
+func (a *ACM) bindData(dataNum uint8) bool {
+	ifaces := a.dev.Interfaces()
+	for i := range ifaces {
+		if ifaces[i].InterfaceNumber != dataNum || ifaces[i].AlternateSetting != 0 {
+			continue
+		}
+		var in, out *host.EndpointDescriptor
+		eps := a.dev.InterfaceEndpoints(i)
+		for j := range eps {
+			if !eps[j].IsBulk() {
+				continue
+			}
+			if eps[j].IsIn() {
+				in = &eps[j]
+			} else {
+				out = &eps[j]
+			}
+		}
+		if in == nil || out == nil {
+			return false
+		}
+		a.dataIface = dataNum
+		a.dataInEP = in.EndpointAddress
+		a.dataOutEP = out.EndpointAddress
+		a.maxPacketSize = int(out.MaxPacketSize)
+		if a.maxPacketSize == 0 {
+			a.maxPacketSize = 64
+		}
+		return true
+	}
+	return false
+}
+
+// unionSubordinate returns the first subordinate interface named by a Union
+// Functional Descriptor in descs.
+func unionSubordinate(descs [][]byte) (uint8, bool) {
+	for _, d := range descs {
+		if len(d) >= UnionDescriptorMinSize && int(d[0]) >= UnionDescriptorMinSize &&
+			d[1] == DescriptorTypeCSInterface && d[2] == SubtypeUnion {
+			return d[4], true
+		}
+	}
+	return 0, false
+}
+
+// Device returns the device this driver is bound to.
+func (a *ACM) Device() *host.Device {
+	return a.dev
+}
+
+// ControlInterface returns the communications interface number.
+func (a *ACM) ControlInterface() uint8 {
+	return a.controlIface
+}
+
+// DataInterface returns the data interface number.
+func (a *ACM) DataInterface() uint8 {
+	return a.dataIface
+}
+
+// SetReadTimeout sets the timeout for each Read. Zero means no timeout.
+func (a *ACM) SetReadTimeout(d time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.readTimeout = d
+}
+
+// SetWriteTimeout sets the timeout for each Write. Zero means no timeout.
+func (a *ACM) SetWriteTimeout(d time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.writeTimeout = d
+}
+
+// SetOnSerialState sets the callback for SERIAL_STATE notifications
+// received by Listen.
+func (a *ACM) SetOnSerialState(cb func(state uint16)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.onSerialState = cb
+}
+
+// LineCoding returns the line coding last set or read.
+func (a *ACM) LineCoding() LineCoding {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.lineCoding
+}
+
+// DTR returns the Data Terminal Ready state last set.
+func (a *ACM) DTR() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.controlState&ControlLineDTR != 0
+}
+
+// RTS returns the Request To Send state last set.
+func (a *ACM) RTS() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.controlState&ControlLineRTS != 0
+}
+
+// SerialState returns the UART state bitmap of the last SERIAL_STATE
+// notification (see SerialState* constants).
+func (a *ACM) SerialState() uint16 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.serialState
+}
+
+// SetLineCoding sends SET_LINE_CODING to the device.
+func (a *ACM) SetLineCoding(ctx context.Context, lc LineCoding) error {
+	var buf [LineCodingSize]byte
+	lc.MarshalTo(buf[:])
+
+	if _, err := a.classRequest(ctx, host.RequestTypeOut, RequestSetLineCoding, 0, buf[:]); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	a.lineCoding = lc
+	a.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentHost, "line coding set",
+		"baud", lc.DTERate,
+		"dataBits", lc.DataBits,
+		"parity", lc.ParityType,
+		"stopBits", lc.CharFormat)
+	return nil
+}
+
+// GetLineCoding reads the current line coding from the device with
+// GET_LINE_CODING.
+func (a *ACM) GetLineCoding(ctx context.Context) (LineCoding, error) {
+	var buf [LineCodingSize]byte
+	var lc LineCoding
+
+	n, err := a.classRequest(ctx, host.RequestTypeIn, RequestGetLineCoding, 0, buf[:])
+	if err != nil {
+		return lc, err
+	}
+	if !ParseLineCoding(buf[:n], &lc) {
+		return lc, pkg.ErrProtocol
+	}
+
+	a.mutex.Lock()
+	a.lineCoding = lc
+	a.mutex.Unlock()
+	return lc, nil
+}
+
+// SetControlLineState sends SET_CONTROL_LINE_STATE to the device.
+func (a *ACM) SetControlLineState(ctx context.Context, dtr, rts bool) error {
+	var state uint16
+	if dtr {
+		state |= ControlLineDTR
+	}
+	if rts {
+		state |= ControlLineRTS
+	}
+
+	if _, err := a.classRequest(ctx, host.RequestTypeOut, RequestSetControlLineState, state, nil); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	a.controlState = state
+	a.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentHost, "control line state set",
+		"dtr", dtr,
+		"rts", rts)
+	return nil
+}
+
+// SendBreak sends SEND_BREAK to the device. millis is the break duration
+// in milliseconds; 0xFFFF starts a break that lasts until SendBreak is
+// called with 0.
+func (a *ACM) SendBreak(ctx context.Context, millis uint16) error {
+	_, err := a.classRequest(ctx, host.RequestTypeOut, RequestSendBreak, millis, nil)
+	return err
+}
+
+// classRequest performs a class request to the communications interface.
+func (a *ACM) classRequest(ctx context.Context, dir, request uint8, value uint16, data []byte) (int, error) {
+	if a.isClosed() {
+		return 0, pkg.ErrNotConfigured
+	}
+	setup := hal.SetupPacket{
+		RequestType: dir | host.RequestTypeClass | host.RequestTypeInterface,
+		Request:     request,
+		Value:       value,
+		Index:       uint16(a.controlIface),
+		Length:      uint16(len(data)),
+	}
+	return a.dev.ControlTransfer(ctx, &setup, data)
+}
+
+// Listen receives notifications from the device until ctx is cancelled or
+// the driver is closed, updating SerialState and invoking the
+// SetOnSerialState callback for each SERIAL_STATE notification.
+// Returns pkg.ErrNotSupported if the function has no notification endpoint.
+//
+// Listen is typically run in its own goroutine. On HALs that serialize the
+// transfers to a device, such as the FIFO HAL, a pending notification
+// transfer delays Read and Write.
+func (a *ACM) Listen(ctx context.Context) error {
+	if a.notifyEP == 0 {
+		return pkg.ErrNotSupported
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(a.ctx, cancel)
+	defer stop()
+
+	var buf [NotificationSerialStateSize]byte
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n, err := a.dev.InterruptTransfer(ctx, a.notifyEP, buf[:])
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if isIdle(err) {
+				continue
+			}
+			return err
+		}
+
+		a.handleNotification(buf[:n])
+	}
+}
+
+// handleNotification processes a notification received from the device.
+func (a *ACM) handleNotification(data []byte) {
+	if len(data) < NotificationSerialStateSize || data[1] != NotificationSerialState {
+		return
+	}
+	state := uint16(data[8]) | uint16(data[9])<<8
+
+	a.mutex.Lock()
+	a.serialState = state
+	cb := a.onSerialState
+	a.mutex.Unlock()
+
+	pkg.LogDebug(pkg.ComponentHost, "serial state received",
+		"state", state)
+
+	if cb != nil {
+		cb(state)
+	}
+}
+
+// isIdle returns true for transfer errors that mean the device had no data
+// to send.
+func isIdle(err error) bool {
+	return errors.Is(err, pkg.ErrTimeout) || errors.Is(err, pkg.ErrNAK) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// Read reads data received from the device (blocking). Zero-length
+// transfers are skipped; data beyond len(p) is returned by later calls.
+// Returns pkg.ErrNotConfigured after Close.
+func (a *ACM) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	a.rxMutex.Lock()
+	defer a.rxMutex.Unlock()
+
+	for a.rxPos >= a.rxLen {
+		if a.isClosed() {
+			return 0, pkg.ErrNotConfigured
+		}
+		ctx, cancel := a.transferContext(false)
+		n, err := a.dev.BulkTransfer(ctx, a.dataInEP, a.rxBuf[:])
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		a.rxPos = 0
+		a.rxLen = n
+	}
+
+	n := copy(p, a.rxBuf[a.rxPos:a.rxLen])
+	a.rxPos += n
+	return n, nil
+}
+
+// Write sends data to the device (blocking), one transfer per maximum
+// packet size of the data OUT endpoint.
+// Returns pkg.ErrNotConfigured after Close.
+func (a *ACM) Write(p []byte) (int, error) {
+	a.txMutex.Lock()
+	defer a.txMutex.Unlock()
+
+	total := 0
+	for len(p) > 0 {
+		if a.isClosed() {
+			return total, pkg.ErrNotConfigured
+		}
+		n := len(p)
+		if n > a.maxPacketSize {
+			n = a.maxPacketSize
+		}
+
+		ctx, cancel := a.transferContext(true)
+		written, err := a.dev.BulkTransfer(ctx, a.dataOutEP, p[:n])
+		cancel()
+		total += written
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Close cancels transfers in progress and releases the interfaces.
+// If DTR or RTS is asserted, it is first deasserted on a best-effort basis.
+func (a *ACM) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	state := a.controlState
+	a.mutex.Unlock()
+
+	if state != 0 {
+		ctx, cancel := context.WithTimeout(a.ctx, time.Second)
+		a.SetControlLineState(ctx, false, false)
+		cancel()
+	}
+
+	a.mutex.Lock()
+	a.closed = true
+	a.mutex.Unlock()
+	a.cancel()
+
+	err := a.dev.ReleaseInterface(a.dataIface)
+	if e := a.dev.ReleaseInterface(a.controlIface); err == nil {
+		err = e
+	}
+	return err
+}
+
+// isClosed returns true after Close.
+func (a *ACM) isClosed() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.closed
+}
+
+// transferContext returns the context for a data transfer, limited by the
+// read or write timeout and cancelled on Close.
+func (a *ACM) transferContext(write bool) (context.Context, context.CancelFunc) {
+	a.mutex.RLock()
+	timeout := a.readTimeout
+	if write {
+		timeout = a.writeTimeout
+	}
+	a.mutex.RUnlock()
+	if timeout > 0 {
+		return context.WithTimeout(a.ctx, timeout)
+	}
+	return context.WithCancel(a.ctx)
+}
+
+// Compile-time interface check
+var _ io.ReadWriteCloser = (*ACM)(nil)
@@ -0,0 +1,345 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardnew/softusb/host"
+	"github.com/ardnew/softusb/host/hal"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// =============================================================================
+// Mock HAL for Testing
+// =============================================================================
+
+// testDeviceDescriptor is the device descriptor of the test device.
+var testDeviceDescriptor = []byte{
+	0x12, 0x01, 0x00, 0x02, 0xEF, 0x02, 0x01, 0x40,
+	0x34, 0x12, 0x78, 0x56, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+}
+
+// Interface and endpoint descriptors of the test CDC-ACM function.
+var (
+	testControlInterface = []byte{0x09, 0x04, 0x00, 0x00, 0x01, ClassCDC, SubclassACM, 0x01, 0x00}
+	testHeader           = []byte{0x05, DescriptorTypeCSInterface, SubtypeHeader, 0x10, 0x01}
+	testNotifyEndpoint   = []byte{0x07, 0x05, 0x83, 0x03, 0x10, 0x00, 0x10}
+	testDataEndpoints    = []byte{
+		0x07, 0x05, 0x81, 0x02, 0x40, 0x00, 0x00,
+		0x07, 0x05, 0x02, 0x02, 0x40, 0x00, 0x00,
+	}
+)
+
+// testDataInterface returns an interface descriptor of the data class.
+func testDataInterface(num uint8) []byte {
+	return []byte{0x09, 0x04, num, 0x00, 0x02, ClassCDCData, 0x00, 0x00, 0x00}
+}
+
+// testUnion returns a Union Functional Descriptor.
+func testUnion(control, subordinate uint8) []byte {
+	return []byte{0x05, DescriptorTypeCSInterface, SubtypeUnion, control, subordinate}
+}
+
+// testConfiguration returns a configuration descriptor holding descs.
+func testConfiguration(numInterfaces uint8, descs ...[]byte) []byte {
+	config := []byte{0x09, 0x02, 0x00, 0x00, numInterfaces, 0x01, 0x00, 0x80, 0x32}
+	for _, d := range descs {
+		config = append(config, d...)
+	}
+	config[2] = byte(len(config))
+	config[3] = byte(len(config) >> 8)
+	return config
+}
+
+// mockHAL implements hal.HostHAL for a single device answering standard
+// requests with fixed descriptors.
+type mockHAL struct {
+	config []byte
+
+	// Connection simulation
+	connectCh chan int
+
+	// Recorded requests
+	setups  []hal.SetupPacket
+	outData [][]byte
+	events  []string // Interface claims and releases
+	mu      sync.Mutex
+}
+
+func newMockHAL(config []byte) *mockHAL {
+	return &mockHAL{
+		config:    config,
+		connectCh: make(chan int, 1),
+	}
+}
+
+func (m *mockHAL) Init(ctx context.Context) error         { return nil }
+func (m *mockHAL) Start() error                           { return nil }
+func (m *mockHAL) Stop() error                            { return nil }
+func (m *mockHAL) Close() error                           { return nil }
+func (m *mockHAL) NumPorts() int                          { return 1 }
+func (m *mockHAL) PortSpeed(port int) hal.Speed           { return hal.SpeedFull }
+func (m *mockHAL) ResetPort(port int) error               { return nil }
+func (m *mockHAL) EnablePort(port int, enable bool) error { return nil }
+
+func (m *mockHAL) GetPortStatus(port int) (hal.PortStatus, error) {
+	return hal.PortStatus{}, nil
+}
+
+func (m *mockHAL) ControlTransfer(ctx context.Context, addr hal.DeviceAddress, setup *hal.SetupPacket, data []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if setup.RequestType == host.RequestTypeIn|host.RequestTypeStandard|host.RequestTypeDevice &&
+		setup.Request == host.RequestGetDescriptor {
+		switch setup.Value >> 8 {
+		case host.DescriptorTypeDevice:
+			return copy(data, testDeviceDescriptor), nil
+		case host.DescriptorTypeConfiguration:
+			return copy(data, m.config), nil
+		}
+		return 0, pkg.ErrStall
+	}
+
+	m.setups = append(m.setups, *setup)
+	m.outData = append(m.outData, append([]byte{}, data...))
+	return len(data), nil
+}
+
+func (m *mockHAL) BulkTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (m *mockHAL) InterruptTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
+	return 0, pkg.ErrTimeout
+}
+
+func (m *mockHAL) IsochronousTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte, xfer *hal.IsoTransfer) (int, error) {
+	return 0, pkg.ErrNotSupported
+}
+
+func (m *mockHAL) SetDeviceAddress(ctx context.Context, newAddr hal.DeviceAddress) error {
+	return nil
+}
+
+func (m *mockHAL) ClaimInterface(addr hal.DeviceAddress, iface uint8) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, fmt.Sprintf("claim %d", iface))
+	return nil
+}
+
+func (m *mockHAL) ReleaseInterface(addr hal.DeviceAddress, iface uint8) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, fmt.Sprintf("release %d", iface))
+	return nil
+}
+
+func (m *mockHAL) WaitForConnection(ctx context.Context) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case port := <-m.connectCh:
+		return port, nil
+	}
+}
+
+func (m *mockHAL) WaitForDisconnection(ctx context.Context) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+// classRequests returns the class requests sent since the last call and
+// their OUT data stages.
+func (m *mockHAL) classRequests() ([]hal.SetupPacket, [][]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var setups []hal.SetupPacket
+	var data [][]byte
+	for i, s := range m.setups {
+		if s.RequestType&host.RequestTypeClass != 0 {
+			setups = append(setups, s)
+			data = append(data, m.outData[i])
+		}
+	}
+	m.setups, m.outData = nil, nil
+	return setups, data
+}
+
+// Ensure mockHAL implements hal.HostHAL
+var _ hal.HostHAL = (*mockHAL)(nil)
+
+// enumerate starts a host on mock and returns the enumerated device.
+func enumerate(t *testing.T, mock *mockHAL) *host.Device {
+	t.Helper()
+
+	h := host.New(mock)
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { h.Stop() })
+
+	mock.connectCh <- 1
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	dev, err := h.WaitDevice(ctx)
+	if err != nil {
+		t.Fatalf("WaitDevice() error = %v", err)
+	}
+	mock.classRequests()
+	return dev
+}
+
+// =============================================================================
+// ACM Tests
+// =============================================================================
+
+func TestNewACM_Binding(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  []byte
+		control uint8
+		data    uint8
+		notify  uint8
+	}{
+		{
+			// The union names the second data interface
+			name: "union",
+			config: testConfiguration(3,
+				testControlInterface, testHeader, testUnion(0, 2), testNotifyEndpoint,
+				testDataInterface(1), testDataEndpoints,
+				testDataInterface(2), testDataEndpoints),
+			control: 0, data: 2, notify: 0x83,
+		},
+		{
+			name: "no union",
+			config: testConfiguration(3,
+				testControlInterface, testHeader, testNotifyEndpoint,
+				testDataInterface(1), testDataEndpoints,
+				testDataInterface(2), testDataEndpoints),
+			control: 0, data: 1, notify: 0x83,
+		},
+		{
+			// A union naming an interface without bulk endpoints
+			name: "union without data endpoints",
+			config: testConfiguration(2,
+				testControlInterface, testHeader, testUnion(0, 1),
+				[]byte{0x09, 0x04, 0x01, 0x00, 0x00, ClassCDCData, 0x00, 0x00, 0x00}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockHAL(tt.config)
+			acm, err := NewACM(enumerate(t, mock))
+			if tt.data == 0 {
+				if err != pkg.ErrNotSupported {
+					t.Fatalf("NewACM() error = %v, want %v", err, pkg.ErrNotSupported)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewACM() error = %v", err)
+			}
+			if acm.ControlInterface() != tt.control || acm.DataInterface() != tt.data || acm.notifyEP != tt.notify {
+				t.Errorf("bound control %d, data %d, notify 0x%02X, want %d, %d, 0x%02X",
+					acm.ControlInterface(), acm.DataInterface(), acm.notifyEP, tt.control, tt.data, tt.notify)
+			}
+			want := []string{fmt.Sprintf("claim %d", tt.control), fmt.Sprintf("claim %d", tt.data)}
+			if fmt.Sprint(mock.events) != fmt.Sprint(want) {
+				t.Errorf("interface events = %v, want %v", mock.events, want)
+			}
+		})
+	}
+}
+
+func TestACM_ClassRequests(t *testing.T) {
+	mock := newMockHAL(testConfiguration(2,
+		testControlInterface, testHeader, testUnion(0, 1), testNotifyEndpoint,
+		testDataInterface(1), testDataEndpoints))
+	acm, err := NewACM(enumerate(t, mock))
+	if err != nil {
+		t.Fatalf("NewACM() error = %v", err)
+	}
+	ctx := context.Background()
+
+	lc := LineCoding{DTERate: 9600, CharFormat: StopBits2, ParityType: ParityEven, DataBits: 7}
+	if err := acm.SetLineCoding(ctx, lc); err != nil {
+		t.Fatalf("SetLineCoding() error = %v", err)
+	}
+	if err := acm.SetControlLineState(ctx, true, false); err != nil {
+		t.Fatalf("SetControlLineState() error = %v", err)
+	}
+
+	setups, data := mock.classRequests()
+	want := []hal.SetupPacket{
+		{RequestType: 0x21, Request: RequestSetLineCoding, Value: 0, Index: 0, Length: LineCodingSize},
+		{RequestType: 0x21, Request: RequestSetControlLineState, Value: ControlLineDTR, Index: 0, Length: 0},
+	}
+	if len(setups) != len(want) {
+		t.Fatalf("class requests = %+v, want %+v", setups, want)
+	}
+	for i := range want {
+		if setups[i] != want[i] {
+			t.Errorf("request %d = %+v, want %+v", i, setups[i], want[i])
+		}
+	}
+	if wantData := []byte{0x80, 0x25, 0x00, 0x00, StopBits2, ParityEven, 7}; !bytes.Equal(data[0], wantData) {
+		t.Errorf("line coding = % X, want % X", data[0], wantData)
+	}
+
+	if acm.LineCoding() != lc || !acm.DTR() || acm.RTS() {
+		t.Errorf("state = %+v, DTR %v, RTS %v", acm.LineCoding(), acm.DTR(), acm.RTS())
+	}
+}
+
+func TestACM_Close(t *testing.T) {
+	mock := newMockHAL(testConfiguration(2,
+		testControlInterface, testHeader, testUnion(0, 1), testNotifyEndpoint,
+		testDataInterface(1), testDataEndpoints))
+	acm, err := NewACM(enumerate(t, mock))
+	if err != nil {
+		t.Fatalf("NewACM() error = %v", err)
+	}
+	ctx := context.Background()
+
+	if err := acm.SetControlLineState(ctx, true, true); err != nil {
+		t.Fatalf("SetControlLineState() error = %v", err)
+	}
+	mock.classRequests()
+
+	// Close deasserts DTR and RTS and releases both interfaces
+	if err := acm.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	setups, _ := mock.classRequests()
+	if len(setups) != 1 || setups[0].Request != RequestSetControlLineState || setups[0].Value != 0 {
+		t.Errorf("requests on Close = %+v, want SET_CONTROL_LINE_STATE(0)", setups)
+	}
+	want := []string{"claim 0", "claim 1", "release 1", "release 0"}
+	if fmt.Sprint(mock.events) != fmt.Sprint(want) {
+		t.Errorf("interface events = %v, want %v", mock.events, want)
+	}
+
+	if err := acm.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if _, err := acm.Write([]byte{1}); err != pkg.ErrNotConfigured {
+		t.Errorf("Write() after Close error = %v, want %v", err, pkg.ErrNotConfigured)
+	}
+	if _, err := acm.Read(make([]byte, 1)); err != pkg.ErrNotConfigured {
+		t.Errorf("Read() after Close error = %v, want %v", err, pkg.ErrNotConfigured)
+	}
+	if err := acm.SetLineCoding(ctx, DefaultLineCoding); err != pkg.ErrNotConfigured {
+		t.Errorf("SetLineCoding() after Close error = %v, want %v", err, pkg.ErrNotConfigured)
+	}
+	if len(mock.events) != len(want) {
+		t.Errorf("interface events after second Close = %v", mock.events)
+	}
+}
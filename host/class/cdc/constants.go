@@ -0,0 +1,131 @@
+package cdc
+
+// CDC class codes.
+const (
+	ClassCDC     = 0x02 // Communications Device Class
+	ClassCDCData = 0x0A // CDC Data Interface Class
+)
+
+// CDC subclass codes.
+const (
+	SubclassACM = 0x02 // Abstract Control Model
+)
+
+// CDC class-specific descriptor types.
+const (
+	DescriptorTypeCSInterface = 0x24 // Class-specific Interface
+)
+
+// CDC functional descriptor subtypes.
+const (
+	SubtypeHeader         = 0x00 // Header Functional Descriptor
+	SubtypeCallManagement = 0x01 // Call Management Functional Descriptor
+	SubtypeACM            = 0x02 // Abstract Control Model Functional Descriptor
+	SubtypeUnion          = 0x06 // Union Functional Descriptor
+)
+
+// UnionDescriptorMinSize is the size of a Union Functional Descriptor with
+// a single subordinate interface.
+const UnionDescriptorMinSize = 5
+
+// CDC-ACM request codes.
+const (
+	RequestSetLineCoding       = 0x20
+	RequestGetLineCoding       = 0x21
+	RequestSetControlLineState = 0x22
+	RequestSendBreak           = 0x23
+)
+
+// CDC notification codes.
+const (
+	NotificationNetworkConnection = 0x00
+	NotificationResponseAvailable = 0x01
+	NotificationSerialState       = 0x20
+)
+
+// Notification sizes.
+const (
+	NotificationHeaderSize      = 8                          // Notification header without data
+	NotificationSerialStateSize = NotificationHeaderSize + 2 // SERIAL_STATE with UART state bitmap
+)
+
+// LineCoding represents the serial line configuration.
+type LineCoding struct {
+	DTERate    uint32 // Data terminal rate (baud rate)
+	CharFormat uint8  // Stop bits: 0=1, 1=1.5, 2=2
+	ParityType uint8  // Parity: 0=None, 1=Odd, 2=Even, 3=Mark, 4=Space
+	DataBits   uint8  // Data bits: 5, 6, 7, 8, or 16
+}
+
+// LineCodingSize is the size of LineCoding in bytes.
+const LineCodingSize = 7
+
+// Stop bit values.
+const (
+	StopBits1   = 0 // 1 stop bit
+	StopBits1_5 = 1 // 1.5 stop bits
+	StopBits2   = 2 // 2 stop bits
+)
+
+// Parity values.
+const (
+	ParityNone  = 0
+	ParityOdd   = 1
+	ParityEven  = 2
+	ParityMark  = 3
+	ParitySpace = 4
+)
+
+// Control line state bits (for SET_CONTROL_LINE_STATE).
+const (
+	ControlLineDTR = 1 << 0 // Data Terminal Ready
+	ControlLineRTS = 1 << 1 // Request To Send
+)
+
+// Serial state bits (for SERIAL_STATE notification).
+const (
+	SerialStateRxCarrier  = 1 << 0 // DCD (Data Carrier Detect)
+	SerialStateTxCarrier  = 1 << 1 // DSR (Data Set Ready)
+	SerialStateBreak      = 1 << 2 // Break detected
+	SerialStateRingSignal = 1 << 3 // Ring signal detected
+	SerialStateFraming    = 1 << 4 // Framing error
+	SerialStateParity     = 1 << 5 // Parity error
+	SerialStateOverrun    = 1 << 6 // Overrun error
+)
+
+// DefaultLineCoding provides sensible defaults (115200 8N1).
+var DefaultLineCoding = LineCoding{
+	DTERate:    115200,
+	CharFormat: StopBits1,
+	ParityType: ParityNone,
+	DataBits:   8,
+}
+
+// MarshalTo writes the LineCoding to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (lc *LineCoding) MarshalTo(buf []byte) int {
+	if len(buf) < LineCodingSize {
+		return 0
+	}
+	buf[0] = byte(lc.DTERate)
+	buf[1] = byte(lc.DTERate >> 8)
+	buf[2] = byte(lc.DTERate >> 16)
+	buf[3] = byte(lc.DTERate >> 24)
+	buf[4] = lc.CharFormat
+	buf[5] = lc.ParityType
+	buf[6] = lc.DataBits
+	return LineCodingSize
+}
+
+// ParseLineCoding parses LineCoding from data.
+// Returns false if data is too short.
+func ParseLineCoding(data []byte, out *LineCoding) bool {
+	if len(data) < LineCodingSize {
+		return false
+	}
+	out.DTERate = uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+	out.CharFormat = data[4]
+	out.ParityType = data[5]
+	out.DataBits = data[6]
+	return true
+}
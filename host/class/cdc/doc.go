@@ -0,0 +1,55 @@
+// Package cdc implements host-side drivers for the USB Communications Device
+// Class (CDC) on the softusb host stack.
+//
+// This package provides a CDC-ACM (Abstract Control Model) driver for
+// talking to USB serial devices such as virtual COM port gadgets and
+// USB-to-serial adapters.
+//
+// # Binding
+//
+// [NewACM] binds to the first CDC-ACM function of an enumerated
+// [host.Device]; [NewACMInterface] selects one by its communications
+// interface number on devices with several ports. The communications
+// interface (class 0x02, subclass 0x02) is paired with its data interface
+// (class 0x0A) through the Union Functional Descriptor, or, if the device
+// omits it, with the next data interface in the configuration. Both
+// interfaces are claimed until Close.
+//
+// # Line Control
+//
+// SetLineCoding, GetLineCoding, SetControlLineState, and SendBreak issue the
+// corresponding class requests to the communications interface:
+//
+//	acm.SetLineCoding(ctx, cdc.LineCoding{
+//	    DTERate:  9600,
+//	    DataBits: 8,
+//	})
+//	acm.SetControlLineState(ctx, true, true) // Assert DTR and RTS
+//
+// # Notifications
+//
+// Listen reads the interrupt IN notification endpoint until its context is
+// cancelled, recording the UART state of each SERIAL_STATE notification:
+//
+//	acm.SetOnSerialState(func(state uint16) {
+//	    if state&cdc.SerialStateRxCarrier != 0 {
+//	        // Carrier detected
+//	    }
+//	})
+//	go acm.Listen(ctx)
+//
+// # Data Transfer
+//
+// ACM implements [io.ReadWriteCloser] over the bulk endpoints of the data
+// interface, so it can be used wherever a serial port stream is expected:
+//
+//	acm, err := cdc.NewACM(dev)
+//	if err != nil {
+//	    return err
+//	}
+//	defer acm.Close()
+//
+//	acm.SetReadTimeout(5 * time.Second)
+//	io.WriteString(acm, "AT\r\n")
+//	n, err := acm.Read(buf)
+package cdc
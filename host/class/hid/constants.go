@@ -0,0 +1,325 @@
+package hid
+
+// HID class codes.
+const (
+	ClassHID = 0x03 // Human Interface Device Class
+)
+
+// HID subclass codes.
+const (
+	SubclassNone = 0x00 // No subclass
+	SubclassBoot = 0x01 // Boot Interface Subclass
+)
+
+// HID interface protocol codes (for boot interface).
+const (
+	ProtocolNone     = 0x00 // No protocol
+	ProtocolKeyboard = 0x01 // Keyboard boot protocol
+	ProtocolMouse    = 0x02 // Mouse boot protocol
+)
+
+// HID descriptor types.
+const (
+	DescriptorTypeHID      = 0x21 // HID descriptor
+	DescriptorTypeReport   = 0x22 // Report descriptor
+	DescriptorTypePhysical = 0x23 // Physical descriptor
+)
+
+// HID request codes.
+const (
+	RequestGetReport   = 0x01
+	RequestGetIdle     = 0x02
+	RequestGetProtocol = 0x03
+	RequestSetReport   = 0x09
+	RequestSetIdle     = 0x0A
+	RequestSetProtocol = 0x0B
+)
+
+// ReportType identifies the kind of a report (high byte of wValue in
+// GET_REPORT/SET_REPORT).
+type ReportType uint8
+
+// Report types.
+const (
+	ReportTypeInput   ReportType = 0x01
+	ReportTypeOutput  ReportType = 0x02
+	ReportTypeFeature ReportType = 0x03
+)
+
+// String returns the name of the report type.
+func (t ReportType) String() string {
+	switch t {
+	case ReportTypeInput:
+		return "Input"
+	case ReportTypeOutput:
+		return "Output"
+	case ReportTypeFeature:
+		return "Feature"
+	default:
+		return "Unknown"
+	}
+}
+
+// Protocol values for GET_PROTOCOL/SET_PROTOCOL.
+const (
+	ProtocolBoot   = 0x00 // Boot protocol
+	ProtocolReport = 0x01 // Report protocol
+)
+
+// MaxReportDescriptorSize is the largest report descriptor the driver
+// will fetch.
+const MaxReportDescriptorSize = 4096
+
+// HIDDescriptor is the HID class descriptor.
+type HIDDescriptor struct {
+	Length         uint8  // Size of this descriptor
+	DescriptorType uint8  // HID (0x21)
+	HIDVersion     uint16 // HID specification release number (0x0111 for 1.11)
+	CountryCode    uint8  // Country code
+	NumDescriptors uint8  // Number of class descriptors (at least 1)
+	ReportDescType uint8  // Report descriptor type (0x22)
+	ReportDescLen  uint16 // Total size of report descriptor
+}
+
+// HIDDescriptorSize is the size of the HID descriptor with one class
+// descriptor.
+const HIDDescriptorSize = 9
+
+// ParseHIDDescriptor parses a HID descriptor from data.
+// Only the first class descriptor entry is decoded.
+// Returns false if data is too short or is not a HID descriptor.
+func ParseHIDDescriptor(data []byte, out *HIDDescriptor) bool {
+	if len(data) < HIDDescriptorSize || data[1] != DescriptorTypeHID {
+		return false
+	}
+	out.Length = data[0]
+	out.DescriptorType = data[1]
+	out.HIDVersion = uint16(data[2]) | uint16(data[3])<<8
+	out.CountryCode = data[4]
+	out.NumDescriptors = data[5]
+	out.ReportDescType = data[6]
+	out.ReportDescLen = uint16(data[7]) | uint16(data[8])<<8
+	return true
+}
+
+// Item types (bits 3-2 of the item prefix).
+const (
+	ItemTypeMain     = 0x00
+	ItemTypeGlobal   = 0x01
+	ItemTypeLocal    = 0x02
+	ItemTypeReserved = 0x03
+)
+
+// Main item tags.
+const (
+	TagInput         = 0x08
+	TagOutput        = 0x09
+	TagCollection    = 0x0A
+	TagFeature       = 0x0B
+	TagEndCollection = 0x0C
+)
+
+// Global item tags.
+const (
+	TagUsagePage       = 0x00
+	TagLogicalMinimum  = 0x01
+	TagLogicalMaximum  = 0x02
+	TagPhysicalMinimum = 0x03
+	TagPhysicalMaximum = 0x04
+	TagUnitExponent    = 0x05
+	TagUnit            = 0x06
+	TagReportSize      = 0x07
+	TagReportID        = 0x08
+	TagReportCount     = 0x09
+	TagPush            = 0x0A
+	TagPop             = 0x0B
+)
+
+// Local item tags.
+const (
+	TagUsage             = 0x00
+	TagUsageMinimum      = 0x01
+	TagUsageMaximum      = 0x02
+	TagDesignatorIndex   = 0x03
+	TagDesignatorMinimum = 0x04
+	TagDesignatorMaximum = 0x05
+	TagStringIndex       = 0x07
+	TagStringMinimum     = 0x08
+	TagStringMaximum     = 0x09
+	TagDelimiter         = 0x0A
+)
+
+// ItemPrefixLong is the prefix byte of a long item.
+const ItemPrefixLong = 0xFE
+
+// Input, Output, and Feature item flags.
+const (
+	FlagConstant      = 1 << 0 // Constant (vs. Data)
+	FlagVariable      = 1 << 1 // Variable (vs. Array)
+	FlagRelative      = 1 << 2 // Relative (vs. Absolute)
+	FlagWrap          = 1 << 3 // Wrap
+	FlagNonLinear     = 1 << 4 // Non-linear
+	FlagNoPreferred   = 1 << 5 // No preferred state
+	FlagNullState     = 1 << 6 // Null state
+	FlagVolatile      = 1 << 7 // Volatile (Output and Feature only)
+	FlagBufferedBytes = 1 << 8 // Buffered bytes (vs. Bit field)
+)
+
+// Collection types.
+const (
+	CollectionPhysical      = 0x00
+	CollectionApplication   = 0x01
+	CollectionLogical       = 0x02
+	CollectionReport        = 0x03
+	CollectionNamedArray    = 0x04
+	CollectionUsageSwitch   = 0x05
+	CollectionUsageModifier = 0x06
+)
+
+// Usage pages (HID Usage Tables).
+const (
+	UsagePageGenericDesktop  = 0x01
+	UsagePageSimulation      = 0x02
+	UsagePageVR              = 0x03
+	UsagePageSport           = 0x04
+	UsagePageGame            = 0x05
+	UsagePageGenericDevice   = 0x06
+	UsagePageKeyboard        = 0x07
+	UsagePageLED             = 0x08
+	UsagePageButton          = 0x09
+	UsagePageOrdinal         = 0x0A
+	UsagePageTelephony       = 0x0B
+	UsagePageConsumer        = 0x0C
+	UsagePageDigitizer       = 0x0D
+	UsagePageHaptics         = 0x0E
+	UsagePagePID             = 0x0F
+	UsagePageUnicode         = 0x10
+	UsagePageEyeHeadTracker  = 0x12
+	UsagePageAlphanumeric    = 0x14
+	UsagePageSensors         = 0x20
+	UsagePageMedical         = 0x40
+	UsagePageBraille         = 0x41
+	UsagePageLighting        = 0x59
+	UsagePageMonitor         = 0x80
+	UsagePagePower           = 0x84
+	UsagePageBatterySystem   = 0x85
+	UsagePageBarcodeScanner  = 0x8C
+	UsagePageScale           = 0x8D
+	UsagePageMagneticStripe  = 0x8E
+	UsagePageCamera          = 0x90
+	UsagePageArcade          = 0x91
+	UsagePageFIDO            = 0xF1D0
+	UsagePageVendorDefined   = 0xFF00 // First vendor-defined page
+	UsagePageVendorDefinedHi = 0xFFFF // Last vendor-defined page
+)
+
+// Generic Desktop page usages.
+const (
+	UsagePointer         = 0x01
+	UsageMouse           = 0x02
+	UsageJoystick        = 0x04
+	UsageGamepad         = 0x05
+	UsageKeyboard        = 0x06
+	UsageKeypad          = 0x07
+	UsageMultiAxis       = 0x08
+	UsageX               = 0x30
+	UsageY               = 0x31
+	UsageZ               = 0x32
+	UsageRx              = 0x33
+	UsageRy              = 0x34
+	UsageRz              = 0x35
+	UsageSlider          = 0x36
+	UsageDial            = 0x37
+	UsageWheel           = 0x38
+	UsageHatSwitch       = 0x39
+	UsageSystemControl   = 0x80
+	UsageSystemPowerDown = 0x81
+	UsageSystemSleep     = 0x82
+	UsageSystemWakeUp    = 0x83
+	UsageDpadUp          = 0x90
+	UsageDpadDown        = 0x91
+	UsageDpadRight       = 0x92
+	UsageDpadLeft        = 0x93
+)
+
+// Consumer page usages.
+const (
+	UsageConsumerControl = 0x01
+	UsagePlayPause       = 0xCD
+	UsageMute            = 0xE2
+	UsageVolumeIncrement = 0xE9
+	UsageVolumeDecrement = 0xEA
+	UsageACPan           = 0x238
+)
+
+// BootKeyboardReportDescriptor describes the boot protocol keyboard report
+// (HID 1.11 Appendix B.1). It is used to decode reports from boot keyboards
+// whose report descriptor cannot be read.
+// Report format: [modifiers, reserved, key1, key2, key3, key4, key5, key6]
+var BootKeyboardReportDescriptor = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x06, // Usage (Keyboard)
+	0xA1, 0x01, // Collection (Application)
+	0x05, 0x07, //   Usage Page (Keyboard/Keypad)
+	0x19, 0xE0, //   Usage Minimum (Left Control)
+	0x29, 0xE7, //   Usage Maximum (Right GUI)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x75, 0x01, //   Report Size (1)
+	0x95, 0x08, //   Report Count (8)
+	0x81, 0x02, //   Input (Data, Variable, Absolute) - Modifier byte
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x08, //   Report Size (8)
+	0x81, 0x01, //   Input (Constant) - Reserved byte
+	0x95, 0x05, //   Report Count (5)
+	0x75, 0x01, //   Report Size (1)
+	0x05, 0x08, //   Usage Page (LEDs)
+	0x19, 0x01, //   Usage Minimum (Num Lock)
+	0x29, 0x05, //   Usage Maximum (Kana)
+	0x91, 0x02, //   Output (Data, Variable, Absolute) - LED report
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x03, //   Report Size (3)
+	0x91, 0x01, //   Output (Constant) - Padding
+	0x95, 0x06, //   Report Count (6)
+	0x75, 0x08, //   Report Size (8)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x26, 0xFF, 0x00, // Logical Maximum (255)
+	0x05, 0x07, //   Usage Page (Keyboard/Keypad)
+	0x19, 0x00, //   Usage Minimum (0)
+	0x2A, 0xFF, 0x00, // Usage Maximum (255)
+	0x81, 0x00, //   Input (Data, Array) - Key array
+	0xC0, // End Collection
+}
+
+// BootMouseReportDescriptor describes the boot protocol mouse report
+// (HID 1.11 Appendix B.2). Bytes following Y are device-specific and are
+// ignored when decoding.
+// Report format: [buttons, X, Y]
+var BootMouseReportDescriptor = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x02, // Usage (Mouse)
+	0xA1, 0x01, // Collection (Application)
+	0x09, 0x01, //   Usage (Pointer)
+	0xA1, 0x00, //   Collection (Physical)
+	0x05, 0x09, //     Usage Page (Button)
+	0x19, 0x01, //     Usage Minimum (Button 1)
+	0x29, 0x03, //     Usage Maximum (Button 3)
+	0x15, 0x00, //     Logical Minimum (0)
+	0x25, 0x01, //     Logical Maximum (1)
+	0x95, 0x03, //     Report Count (3)
+	0x75, 0x01, //     Report Size (1)
+	0x81, 0x02, //     Input (Data, Variable, Absolute) - Button bits
+	0x95, 0x01, //     Report Count (1)
+	0x75, 0x05, //     Report Size (5)
+	0x81, 0x01, //     Input (Constant) - Padding
+	0x05, 0x01, //     Usage Page (Generic Desktop)
+	0x09, 0x30, //     Usage (X)
+	0x09, 0x31, //     Usage (Y)
+	0x15, 0x81, //     Logical Minimum (-127)
+	0x25, 0x7F, //     Logical Maximum (127)
+	0x75, 0x08, //     Report Size (8)
+	0x95, 0x02, //     Report Count (2)
+	0x81, 0x06, //     Input (Data, Variable, Relative) - X, Y
+	0xC0, //   End Collection
+	0xC0, // End Collection
+}
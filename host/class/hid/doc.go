@@ -0,0 +1,65 @@
+// Package hid implements a host-side driver for the USB Human Interface
+// Device (HID) class on the softusb host stack.
+//
+// This package reads and parses the report descriptor of a HID interface
+// and decodes the reports of keyboards, mice, game controllers, and other
+// HID devices into named field values.
+//
+// # Report Descriptors
+//
+// [ParseReportDescriptor] parses a report descriptor into a
+// [ReportDescriptor]: a tree of [Collection] values and the layout of each
+// [Report], keyed by type (Input, Output, Feature) and report ID. Each
+// [Field] records its bit offset and size within the report, its element
+// count, its logical and physical ranges, its units, and the usages it
+// reports. Global items (including Push and Pop) and local items (including
+// Usage Minimum/Maximum ranges, extended 32-bit usages, and delimited usage
+// sets) are resolved while parsing. [ParseItem] decodes individual items
+// for callers that need the raw item stream.
+//
+// # Decoding Reports
+//
+// [ReportDescriptor.Decode] looks up a report by its report ID and decodes
+// its data into [Value] elements. Variable fields yield one value per
+// usage; array fields yield the usages they select:
+//
+//	report, values, err := desc.Decode(hid.ReportTypeInput, data)
+//	for _, v := range values {
+//	    fmt.Println(v) // "Generic Desktop.X=-3", "Keyboard.A=1", ...
+//	}
+//
+// [Field.SetValue] encodes elements into Output and Feature reports.
+//
+// # Binding
+//
+// [New] binds to the first HID interface of an enumerated [host.Device]
+// and [NewInterface] selects one by interface number. Both claim the
+// interface and read its report descriptor with GET_DESCRIPTOR. If a boot
+// keyboard or mouse does not return its report descriptor, the driver
+// selects the boot protocol and decodes reports with the standard boot
+// report format.
+//
+// # Requests
+//
+// GetReport, SetReport, GetIdle, SetIdle, GetProtocol, and SetProtocol
+// issue the corresponding class requests. WriteReport sends output reports
+// on the interrupt OUT endpoint, or with SET_REPORT if the interface has
+// none.
+//
+// # Input Reports
+//
+// ReadReport reads one report from the interrupt IN endpoint and Decode
+// decodes it; Listen does both until its context is cancelled:
+//
+//	h, err := hid.New(ctx, dev)
+//	if err != nil {
+//	    return err
+//	}
+//	defer h.Close()
+//
+//	h.SetIdle(ctx, 0, 0) // Report only on change
+//	h.SetOnReport(func(r *hid.Report, values []hid.Value) {
+//	    // Handle decoded values
+//	})
+//	go h.Listen(ctx)
+package hid
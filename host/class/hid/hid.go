@@ -0,0 +1,467 @@
+package hid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/ardnew/softusb/host"
+	"github.com/ardnew/softusb/host/hal"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// MaxReportSize is the size of the interrupt IN transfer buffer used by
+// Listen.
+const MaxReportSize = 1024
+
+// anyInterface selects the first HID interface of a device.
+const anyInterface = -1
+
+// HID implements a host-side Human Interface Device class driver. It binds
+// to one HID interface of an enumerated device, parses its report
+// descriptor, and decodes the reports it sends.
+type HID struct {
+	dev *host.Device
+
+	// Interface
+	iface    uint8
+	subclass uint8
+	protocol uint8 // Interface protocol (Protocol* boot codes)
+	hidDesc  HIDDescriptor
+
+	// Endpoints (outEP is 0 if the interface has none)
+	inEP         uint8
+	outEP        uint8
+	inPacketSize int
+
+	// Report descriptors. bootDesc is nil unless the interface supports the
+	// boot protocol; desc is the one in effect for the current protocol.
+	rawDesc    []byte
+	reportDesc *ReportDescriptor
+	bootDesc   *ReportDescriptor
+	desc       *ReportDescriptor
+
+	// Callbacks
+	onReport func(report *Report, values []Value)
+
+	// Cancels transfers in progress on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// New binds a HID class driver to the first HID interface of dev, claims
+// it, and reads and parses its report descriptor. dev must be configured.
+//
+// If a boot interface does not return its report descriptor, the driver
+// selects the boot protocol with SET_PROTOCOL and decodes reports with the
+// standard boot report format.
+// Returns pkg.ErrNotSupported if dev has no HID interface.
+func New(ctx context.Context, dev *host.Device) (*HID, error) {
+	return newHID(ctx, dev, anyInterface)
+}
+
+// NewInterface binds a HID class driver to the HID interface of dev with
+// the given interface number, for devices with several HID interfaces.
+// See [New].
+func NewInterface(ctx context.Context, dev *host.Device, ifaceNum uint8) (*HID, error) {
+	return newHID(ctx, dev, int(ifaceNum))
+}
+
+// newHID binds to the HID interface with the given number, or the first
+// one if ifaceNum is anyInterface.
+func newHID(ctx context.Context, dev *host.Device, ifaceNum int) (*HID, error) {
+	h := &HID{dev: dev}
+	if !h.bind(ifaceNum) {
+		return nil, pkg.ErrNotSupported
+	}
+
+	if err := dev.ClaimInterface(h.iface); err != nil {
+		return nil, err
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	if err := h.loadDescriptors(ctx); err != nil {
+		h.cancel()
+		dev.ReleaseInterface(h.iface)
+		return nil, err
+	}
+
+	pkg.LogDebug(pkg.ComponentHost, "HID bound",
+		"interface", h.iface,
+		"subclass", h.subclass,
+		"protocol", h.protocol,
+		"in", h.inEP,
+		"out", h.outEP,
+		"reports", len(h.desc.Reports))
+
+	return h, nil
+}
+
+// bind locates the HID descriptor and endpoints of a HID interface.
+// Returns false if no matching interface was found.
+func (h *HID) bind(ifaceNum int) bool {
+	ifaces := h.dev.Interfaces()
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.InterfaceClass != ClassHID || iface.AlternateSetting != 0 {
+			continue
+		}
+		if ifaceNum != anyInterface && int(iface.InterfaceNumber) != ifaceNum {
+			continue
+		}
+
+		var in, out *host.EndpointDescriptor
+		eps := h.dev.InterfaceEndpoints(i)
+		for j := range eps {
+			if !eps[j].IsInterrupt() {
+				continue
+			}
+			if eps[j].IsIn() {
+				in = &eps[j]
+			} else {
+				out = &eps[j]
+			}
+		}
+		if in == nil {
+			continue
+		}
+
+		for _, d := range h.dev.ClassDescriptors(i) {
+			if ParseHIDDescriptor(d, &h.hidDesc) {
+				break
+			}
+		}
+
+		h.iface = iface.InterfaceNumber
+		h.subclass = iface.InterfaceSubClass
+		h.protocol = iface.InterfaceProtocol
+		h.inEP = in.EndpointAddress
+		h.inPacketSize = int(in.MaxPacketSize)
+		if out != nil {
+			h.outEP = out.EndpointAddress
+		}
+		return true
+	}
+	return false
+}
+
+// loadDescriptors reads and parses the report descriptor, falling back to
+// the boot protocol for boot interfaces.
+func (h *HID) loadDescriptors(ctx context.Context) error {
+	if h.subclass == SubclassBoot {
+		switch h.protocol {
+		case ProtocolKeyboard:
+			h.bootDesc, _ = ParseReportDescriptor(BootKeyboardReportDescriptor)
+		case ProtocolMouse:
+			h.bootDesc, _ = ParseReportDescriptor(BootMouseReportDescriptor)
+		}
+	}
+
+	raw, err := h.readReportDescriptor(ctx)
+	if err == nil {
+		h.reportDesc, err = ParseReportDescriptor(raw)
+	}
+	if err == nil {
+		h.rawDesc = raw
+		h.desc = h.reportDesc
+		return nil
+	}
+	if h.bootDesc == nil || ctx.Err() != nil {
+		return err
+	}
+
+	pkg.LogDebug(pkg.ComponentHost, "report descriptor unavailable, using boot protocol",
+		"interface", h.iface,
+		"error", err)
+
+	if err := h.SetProtocol(ctx, ProtocolBoot); err != nil {
+		pkg.LogDebug(pkg.ComponentHost, "SET_PROTOCOL failed",
+			"interface", h.iface,
+			"error", err)
+	}
+	h.desc = h.bootDesc
+	return nil
+}
+
+// readReportDescriptor reads the report descriptor with GET_DESCRIPTOR.
+func (h *HID) readReportDescriptor(ctx context.Context) ([]byte, error) {
+	length := int(h.hidDesc.ReportDescLen)
+	if length == 0 || length > MaxReportDescriptorSize {
+		length = MaxReportDescriptorSize
+	}
+
+	buf := make([]byte, length)
+	setup := hal.SetupPacket{
+		RequestType: host.RequestTypeIn | host.RequestTypeStandard | host.RequestTypeInterface,
+		Request:     host.RequestGetDescriptor,
+		Value:       uint16(DescriptorTypeReport) << 8,
+		Index:       uint16(h.iface),
+		Length:      uint16(length),
+	}
+	n, err := h.dev.ControlTransfer(ctx, &setup, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, pkg.ErrProtocol
+	}
+	return buf[:n], nil
+}
+
+// Device returns the device this driver is bound to.
+func (h *HID) Device() *host.Device {
+	return h.dev
+}
+
+// Interface returns the HID interface number.
+func (h *HID) Interface() uint8 {
+	return h.iface
+}
+
+// BootProtocol returns the boot protocol of the interface (ProtocolKeyboard,
+// ProtocolMouse), or ProtocolNone if it is not a boot interface.
+func (h *HID) BootProtocol() uint8 {
+	if h.subclass != SubclassBoot {
+		return ProtocolNone
+	}
+	return h.protocol
+}
+
+// HIDDescriptor returns the HID class descriptor of the interface.
+func (h *HID) HIDDescriptor() HIDDescriptor {
+	return h.hidDesc
+}
+
+// RawReportDescriptor returns the report descriptor read from the device,
+// or nil if it could not be read.
+func (h *HID) RawReportDescriptor() []byte {
+	return h.rawDesc
+}
+
+// ReportDescriptor returns the parsed report descriptor in effect: the
+// boot report format while the boot protocol is selected, otherwise the
+// device's report descriptor.
+func (h *HID) ReportDescriptor() *ReportDescriptor {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.desc
+}
+
+// SetOnReport sets the callback for input reports received by Listen.
+func (h *HID) SetOnReport(cb func(report *Report, values []Value)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.onReport = cb
+}
+
+// GetReport reads a report with GET_REPORT. The report ID is 0 if the
+// descriptor uses no report IDs; otherwise the data returned in buf begins
+// with it.
+// Returns the number of bytes read.
+func (h *HID) GetReport(ctx context.Context, typ ReportType, id uint8, buf []byte) (int, error) {
+	return h.classRequest(ctx, host.RequestTypeIn, RequestGetReport,
+		uint16(typ)<<8|uint16(id), buf)
+}
+
+// SetReport sends a report with SET_REPORT. data is the complete report,
+// beginning with the report ID if the descriptor uses report IDs.
+func (h *HID) SetReport(ctx context.Context, typ ReportType, data []byte) error {
+	var id uint8
+	if h.ReportDescriptor().HasReportIDs() && len(data) > 0 {
+		id = data[0]
+	}
+	_, err := h.classRequest(ctx, host.RequestTypeOut, RequestSetReport,
+		uint16(typ)<<8|uint16(id), data)
+	return err
+}
+
+// WriteReport sends an output report on the interrupt OUT endpoint, or
+// with SET_REPORT if the interface has none. data is the complete report,
+// beginning with the report ID if the descriptor uses report IDs.
+func (h *HID) WriteReport(ctx context.Context, data []byte) error {
+	if h.outEP == 0 {
+		return h.SetReport(ctx, ReportTypeOutput, data)
+	}
+	if h.isClosed() {
+		return pkg.ErrNotConfigured
+	}
+	_, err := h.dev.InterruptTransfer(ctx, h.outEP, data)
+	return err
+}
+
+// GetIdle reads the idle rate of a report with GET_IDLE, in units of 4 ms.
+func (h *HID) GetIdle(ctx context.Context, id uint8) (uint8, error) {
+	var buf [1]byte
+	n, err := h.classRequest(ctx, host.RequestTypeIn, RequestGetIdle, uint16(id), buf[:])
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, pkg.ErrProtocol
+	}
+	return buf[0], nil
+}
+
+// SetIdle sets the idle rate of a report with SET_IDLE, in units of 4 ms.
+// A rate of 0 makes the device report only when data changes; an id of 0
+// applies to all input reports.
+func (h *HID) SetIdle(ctx context.Context, rate, id uint8) error {
+	_, err := h.classRequest(ctx, host.RequestTypeOut, RequestSetIdle,
+		uint16(rate)<<8|uint16(id), nil)
+	return err
+}
+
+// GetProtocol reads the current protocol (ProtocolBoot or ProtocolReport)
+// with GET_PROTOCOL.
+func (h *HID) GetProtocol(ctx context.Context) (uint8, error) {
+	var buf [1]byte
+	n, err := h.classRequest(ctx, host.RequestTypeIn, RequestGetProtocol, 0, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, pkg.ErrProtocol
+	}
+	return buf[0], nil
+}
+
+// SetProtocol selects the boot or report protocol with SET_PROTOCOL, and
+// decodes subsequent reports with the matching report format.
+// Returns pkg.ErrNotSupported if the boot protocol is requested from an
+// interface that does not support it.
+func (h *HID) SetProtocol(ctx context.Context, protocol uint8) error {
+	if protocol == ProtocolBoot && h.bootDesc == nil {
+		return pkg.ErrNotSupported
+	}
+	if _, err := h.classRequest(ctx, host.RequestTypeOut, RequestSetProtocol, uint16(protocol), nil); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	if protocol == ProtocolBoot {
+		h.desc = h.bootDesc
+	} else if h.reportDesc != nil {
+		h.desc = h.reportDesc
+	}
+	h.mutex.Unlock()
+	return nil
+}
+
+// ReadReport reads one input report from the interrupt IN endpoint into
+// buf, waiting until one arrives or ctx is done. buf should hold at least
+// the endpoint's maximum packet size.
+// Returns the number of bytes read.
+func (h *HID) ReadReport(ctx context.Context, buf []byte) (int, error) {
+	if h.isClosed() {
+		return 0, pkg.ErrNotConfigured
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(h.ctx, cancel)
+	defer stop()
+
+	for {
+		n, err := h.dev.InterruptTransfer(ctx, h.inEP, buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				if h.isClosed() {
+					return 0, pkg.ErrNotConfigured
+				}
+				return 0, ctx.Err()
+			}
+			if isIdle(err) {
+				continue
+			}
+			return 0, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Decode decodes an input report read by ReadReport using the report
+// descriptor in effect. See [ReportDescriptor.Decode].
+func (h *HID) Decode(data []byte) (*Report, []Value, error) {
+	return h.ReportDescriptor().Decode(ReportTypeInput, data)
+}
+
+// Listen reads input reports until ctx is cancelled or the driver is
+// closed, passing each decoded report to the callback set with
+// SetOnReport. Reports that cannot be decoded are skipped.
+func (h *HID) Listen(ctx context.Context) error {
+	buf := make([]byte, max(h.inPacketSize, MaxReportSize))
+	for {
+		n, err := h.ReadReport(ctx, buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, pkg.ErrNotConfigured) {
+				return nil
+			}
+			return err
+		}
+
+		report, values, err := h.Decode(buf[:n])
+		if err != nil {
+			pkg.LogDebug(pkg.ComponentHost, "HID report not decoded",
+				"interface", h.iface,
+				"length", n,
+				"error", err)
+			continue
+		}
+
+		h.mutex.RLock()
+		cb := h.onReport
+		h.mutex.RUnlock()
+		if cb != nil {
+			cb(report, values)
+		}
+	}
+}
+
+// Close cancels transfers in progress and releases the interface.
+func (h *HID) Close() error {
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		return nil
+	}
+	h.closed = true
+	h.mutex.Unlock()
+
+	h.cancel()
+	return h.dev.ReleaseInterface(h.iface)
+}
+
+// classRequest issues a class-specific control request to the interface.
+func (h *HID) classRequest(ctx context.Context, dir, request uint8, value uint16, data []byte) (int, error) {
+	if h.isClosed() {
+		return 0, pkg.ErrNotConfigured
+	}
+	setup := hal.SetupPacket{
+		RequestType: dir | host.RequestTypeClass | host.RequestTypeInterface,
+		Request:     request,
+		Value:       value,
+		Index:       uint16(h.iface),
+		Length:      uint16(len(data)),
+	}
+	return h.dev.ControlTransfer(ctx, &setup, data)
+}
+
+// isClosed returns true after Close.
+func (h *HID) isClosed() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.closed
+}
+
+// isIdle returns true for transfer errors that mean the device had no
+// report to send.
+func isIdle(err error) bool {
+	return errors.Is(err, pkg.ErrTimeout) || errors.Is(err, pkg.ErrNAK) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,65 @@
+package hid
+
+// Item is a single item of a report descriptor.
+type Item struct {
+	Type uint8  // Item type (ItemType*)
+	Tag  uint8  // Item tag (Tag*), or the long item tag
+	Data []byte // Item data (0, 1, 2, or 4 bytes for short items)
+	Long bool   // Long item
+}
+
+// ParseItem parses the item at the start of data. out.Data aliases data.
+// Returns the encoded size of the item, or 0 if data is truncated.
+func ParseItem(data []byte, out *Item) int {
+	if len(data) == 0 {
+		return 0
+	}
+
+	prefix := data[0]
+	if prefix == ItemPrefixLong {
+		// Long item: prefix, bDataSize, bLongItemTag, data
+		if len(data) < 3 || len(data) < 3+int(data[1]) {
+			return 0
+		}
+		size := int(data[1])
+		out.Type = ItemTypeReserved
+		out.Tag = data[2]
+		out.Data = data[3 : 3+size]
+		out.Long = true
+		return 3 + size
+	}
+
+	size := int(prefix & 0x03)
+	if size == 3 {
+		size = 4
+	}
+	if len(data) < 1+size {
+		return 0
+	}
+	out.Type = (prefix >> 2) & 0x03
+	out.Tag = prefix >> 4
+	out.Data = data[1 : 1+size]
+	out.Long = false
+	return 1 + size
+}
+
+// Unsigned returns the item data as an unsigned little-endian value.
+func (it *Item) Unsigned() uint32 {
+	var v uint32
+	for i := min(len(it.Data), 4) - 1; i >= 0; i-- {
+		v = v<<8 | uint32(it.Data[i])
+	}
+	return v
+}
+
+// Signed returns the item data as a sign-extended little-endian value.
+func (it *Item) Signed() int32 {
+	switch len(it.Data) {
+	case 1:
+		return int32(int8(it.Data[0]))
+	case 2:
+		return int32(int16(uint16(it.Data[0]) | uint16(it.Data[1])<<8))
+	default:
+		return int32(it.Unsigned())
+	}
+}
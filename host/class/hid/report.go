@@ -0,0 +1,565 @@
+package hid
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Report descriptor errors.
+var (
+	// ErrInvalidDescriptor is returned when a report descriptor is
+	// malformed: unbalanced collections or delimiters, a global item stack
+	// overflow or underflow, an invalid report ID or size, an invalid usage
+	// range, or reports both with and without report IDs.
+	ErrInvalidDescriptor = errors.New("invalid report descriptor")
+
+	// ErrUnknownReport is returned when a report does not match any report
+	// declared by the report descriptor.
+	ErrUnknownReport = errors.New("unknown report")
+)
+
+// Parser limits.
+const (
+	maxGlobalStack = 16      // Depth of the Push/Pop global item stack
+	maxReportBits  = 1 << 16 // Size of a single report in bits
+)
+
+// ReportDescriptor is a parsed report descriptor: the collection tree and
+// the layout of every report it declares.
+type ReportDescriptor struct {
+	// Collections holds the top-level collections in descriptor order.
+	Collections []*Collection
+
+	// Reports holds every report in order of first declaration.
+	Reports []*Report
+
+	reportIDs bool
+}
+
+// Collection is a Collection item and the items it encloses.
+type Collection struct {
+	Type     uint8       // Collection type (Collection*)
+	Usage    Usage       // First usage declared for the collection
+	Parent   *Collection // Enclosing collection, or nil at top level
+	Children []*Collection
+	Fields   []*Field // Fields declared directly within the collection
+}
+
+// Report is the layout of one report: its fields in transmission order.
+type Report struct {
+	ID     uint8      // Report ID, or 0 if the descriptor uses none
+	Type   ReportType // Input, Output, or Feature
+	Fields []*Field
+	Bits   int // Size of the report data in bits, excluding the report ID
+}
+
+// Field is the set of report elements declared by one Input, Output, or
+// Feature item.
+type Field struct {
+	Report     *Report
+	Collection *Collection // Enclosing collection, or nil
+	Flags      uint32      // Main item flags (Flag*)
+
+	BitOffset int // Offset of the first element in the report data, excluding the report ID
+	BitSize   int // Size of each element in bits (Report Size)
+	Count     int // Number of elements (Report Count)
+
+	LogicalMinimum  int32
+	LogicalMaximum  int32
+	PhysicalMinimum int32
+	PhysicalMaximum int32
+	UnitExponent    int32
+	Unit            uint32
+
+	usages     []usageRange
+	usageCount int
+}
+
+// usageRange is an inclusive range of usages declared by a Usage item or a
+// Usage Minimum/Maximum pair.
+type usageRange struct {
+	min, max Usage
+}
+
+// Value is a decoded report element.
+type Value struct {
+	Field *Field
+	Usage Usage
+	Index int   // Element index within the field
+	Value int32 // Element value; 1 for a usage selected by an array field
+}
+
+// String returns the value as "usage=value".
+func (v Value) String() string {
+	return v.Usage.String() + "=" + strconv.Itoa(int(v.Value))
+}
+
+// ParseReportDescriptor parses a report descriptor.
+// Returns ErrInvalidDescriptor if data is truncated or malformed.
+func ParseReportDescriptor(data []byte) (*ReportDescriptor, error) {
+	p := parser{desc: &ReportDescriptor{}}
+
+	var it Item
+	for len(data) > 0 {
+		n := ParseItem(data, &it)
+		if n == 0 {
+			return nil, ErrInvalidDescriptor
+		}
+		data = data[n:]
+
+		if it.Long {
+			continue // No long items are defined
+		}
+
+		var err error
+		switch it.Type {
+		case ItemTypeMain:
+			err = p.main(&it)
+		case ItemTypeGlobal:
+			err = p.global(&it)
+		case ItemTypeLocal:
+			err = p.local(&it)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.collection != nil || p.delimiter {
+		return nil, ErrInvalidDescriptor
+	}
+	if p.desc.reportIDs {
+		for _, r := range p.desc.Reports {
+			if r.ID == 0 {
+				return nil, ErrInvalidDescriptor
+			}
+		}
+	}
+	return p.desc, nil
+}
+
+// HasReportIDs returns true if reports are prefixed with a report ID.
+func (d *ReportDescriptor) HasReportIDs() bool {
+	return d.reportIDs
+}
+
+// Report returns the report of type typ with the given ID, or nil if the
+// descriptor declares no such report. id is 0 if the descriptor uses no
+// report IDs.
+func (d *ReportDescriptor) Report(typ ReportType, id uint8) *Report {
+	for _, r := range d.Reports {
+		if r.Type == typ && r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// Decode decodes a report of type typ. If the descriptor uses report IDs,
+// data begins with the report ID. See [Report.Decode].
+// Returns ErrUnknownReport if the report is not declared.
+func (d *ReportDescriptor) Decode(typ ReportType, data []byte) (*Report, []Value, error) {
+	var id uint8
+	if d.reportIDs {
+		if len(data) == 0 {
+			return nil, nil, pkg.ErrProtocol
+		}
+		id, data = data[0], data[1:]
+	}
+
+	r := d.Report(typ, id)
+	if r == nil {
+		return nil, nil, ErrUnknownReport
+	}
+	values, err := r.Decode(data, nil)
+	return r, values, err
+}
+
+// Size returns the size of the report in bytes, including the report ID
+// if there is one.
+func (r *Report) Size() int {
+	n := (r.Bits + 7) / 8
+	if r.ID != 0 {
+		n++
+	}
+	return n
+}
+
+// Decode decodes report data, excluding the report ID, appending a Value
+// to values for each element of each data field. Constant fields are
+// skipped.
+//
+// Variable field elements are decoded to their usage and value. Array
+// field elements are decoded to the usage they select, with value 1;
+// elements that select no usage (outside the logical range, or usage ID 0)
+// are omitted.
+// Returns pkg.ErrProtocol if data is shorter than the report.
+func (r *Report) Decode(data []byte, values []Value) ([]Value, error) {
+	if len(data)*8 < r.Bits {
+		return values, pkg.ErrProtocol
+	}
+
+	for _, f := range r.Fields {
+		if f.IsConstant() {
+			continue
+		}
+		for i := 0; i < f.Count; i++ {
+			v := f.Value(data, i)
+			if f.IsVariable() {
+				u, _ := f.Usage(min(i, f.usageCount-1))
+				values = append(values, Value{Field: f, Usage: u, Index: i, Value: v})
+				continue
+			}
+			index := int64(v) - int64(f.LogicalMinimum)
+			if v > f.LogicalMaximum || index < 0 || index >= int64(f.usageCount) {
+				continue
+			}
+			u, ok := f.Usage(int(index))
+			if !ok || u.ID() == 0 {
+				continue
+			}
+			values = append(values, Value{Field: f, Usage: u, Index: i, Value: 1})
+		}
+	}
+	return values, nil
+}
+
+// IsConstant returns true for constant (padding) fields.
+func (f *Field) IsConstant() bool {
+	return f.Flags&FlagConstant != 0
+}
+
+// IsVariable returns true if each element reports the value of one usage.
+func (f *Field) IsVariable() bool {
+	return f.Flags&FlagVariable != 0
+}
+
+// IsArray returns true if each element holds the index of a selected usage.
+func (f *Field) IsArray() bool {
+	return f.Flags&FlagVariable == 0
+}
+
+// IsRelative returns true if values are relative to the previous report.
+func (f *Field) IsRelative() bool {
+	return f.Flags&FlagRelative != 0
+}
+
+// UsageCount returns the number of usages declared for the field.
+func (f *Field) UsageCount() int {
+	return f.usageCount
+}
+
+// Usage returns the i-th usage declared for the field, counting each usage
+// of a Usage Minimum/Maximum range.
+// Returns false if i is out of range.
+func (f *Field) Usage(i int) (Usage, bool) {
+	if i < 0 {
+		return 0, false
+	}
+	for _, ur := range f.usages {
+		n := int(ur.max) - int(ur.min) + 1
+		if i < n {
+			return ur.min + Usage(i), true
+		}
+		i -= n
+	}
+	return 0, false
+}
+
+// Value returns element i of the field from report data, excluding the
+// report ID. Values are sign-extended if LogicalMinimum is negative.
+// Returns 0 if i is out of range or data is too short.
+func (f *Field) Value(data []byte, i int) int32 {
+	off := f.BitOffset + i*f.BitSize
+	if i < 0 || i >= f.Count || f.BitSize == 0 || f.BitSize > 32 ||
+		off+f.BitSize > len(data)*8 {
+		return 0
+	}
+
+	v := getBits(data, off, f.BitSize)
+	if f.LogicalMinimum < 0 && f.BitSize < 32 && v&(1<<(f.BitSize-1)) != 0 {
+		v |= ^uint32(0) << f.BitSize
+	}
+	return int32(v)
+}
+
+// SetValue stores v as element i of the field in report data, excluding
+// the report ID, for building Output and Feature reports.
+// Returns false if i is out of range or data is too short.
+func (f *Field) SetValue(data []byte, i int, v int32) bool {
+	off := f.BitOffset + i*f.BitSize
+	if i < 0 || i >= f.Count || f.BitSize == 0 || f.BitSize > 32 ||
+		off+f.BitSize > len(data)*8 {
+		return false
+	}
+	putBits(data, off, f.BitSize, uint32(v))
+	return true
+}
+
+// getBits extracts size bits (at most 32) at bit offset off from
+// little-endian data.
+func getBits(data []byte, off, size int) uint32 {
+	first := off / 8
+	shift := off % 8
+	var v uint64
+	for i := 0; i < (shift+size+7)/8; i++ {
+		v |= uint64(data[first+i]) << (8 * i)
+	}
+	return uint32(v >> shift & (1<<size - 1))
+}
+
+// putBits stores the low size bits (at most 32) of v at bit offset off in
+// little-endian data.
+func putBits(data []byte, off, size int, v uint32) {
+	first := off / 8
+	shift := off % 8
+	mask := uint64(1<<size-1) << shift
+	bits := uint64(v) << shift & mask
+	for i := 0; i < (shift+size+7)/8; i++ {
+		m := byte(mask >> (8 * i))
+		data[first+i] = data[first+i]&^m | byte(bits>>(8*i))
+	}
+}
+
+// globalState holds the global items in effect.
+type globalState struct {
+	usagePage    uint16
+	logicalMin   int32
+	logicalMax   int32
+	logicalMaxU  uint32
+	physicalMin  int32
+	physicalMax  int32
+	physicalMaxU uint32
+	unitExponent int32
+	unit         uint32
+	reportSize   int
+	reportCount  int
+	reportID     uint8
+}
+
+// parser holds the state of ParseReportDescriptor.
+type parser struct {
+	desc       *ReportDescriptor
+	state      globalState
+	stack      []globalState
+	collection *Collection
+
+	// Local items, cleared after each main item
+	usages         []usageRange
+	usageMin       Usage
+	usageMax       Usage
+	haveUsageMin   bool
+	haveUsageMax   bool
+	delimiter      bool
+	delimiterUsage bool
+}
+
+// main handles a main item.
+func (p *parser) main(it *Item) error {
+	defer p.clearLocals()
+
+	switch it.Tag {
+	case TagInput:
+		return p.addField(ReportTypeInput, it.Unsigned())
+	case TagOutput:
+		return p.addField(ReportTypeOutput, it.Unsigned())
+	case TagFeature:
+		return p.addField(ReportTypeFeature, it.Unsigned())
+
+	case TagCollection:
+		c := &Collection{Type: uint8(it.Unsigned()), Parent: p.collection}
+		if len(p.usages) > 0 {
+			c.Usage = p.usages[0].min
+		}
+		if p.collection != nil {
+			p.collection.Children = append(p.collection.Children, c)
+		} else {
+			p.desc.Collections = append(p.desc.Collections, c)
+		}
+		p.collection = c
+
+	case TagEndCollection:
+		if p.collection == nil {
+			return ErrInvalidDescriptor
+		}
+		p.collection = p.collection.Parent
+	}
+	return nil
+}
+
+// global handles a global item.
+func (p *parser) global(it *Item) error {
+	s := &p.state
+	switch it.Tag {
+	case TagUsagePage:
+		s.usagePage = uint16(it.Unsigned())
+	case TagLogicalMinimum:
+		s.logicalMin = it.Signed()
+	case TagLogicalMaximum:
+		s.logicalMax = it.Signed()
+		s.logicalMaxU = it.Unsigned()
+	case TagPhysicalMinimum:
+		s.physicalMin = it.Signed()
+	case TagPhysicalMaximum:
+		s.physicalMax = it.Signed()
+		s.physicalMaxU = it.Unsigned()
+	case TagUnitExponent:
+		// A 4-bit two's complement value
+		s.unitExponent = it.Signed()
+		if s.unitExponent >= 8 && s.unitExponent <= 15 {
+			s.unitExponent -= 16
+		}
+	case TagUnit:
+		s.unit = it.Unsigned()
+	case TagReportSize:
+		if it.Unsigned() > maxReportBits {
+			return ErrInvalidDescriptor
+		}
+		s.reportSize = int(it.Unsigned())
+	case TagReportCount:
+		if it.Unsigned() > maxReportBits {
+			return ErrInvalidDescriptor
+		}
+		s.reportCount = int(it.Unsigned())
+	case TagReportID:
+		id := it.Unsigned()
+		if id == 0 || id > 0xFF {
+			return ErrInvalidDescriptor
+		}
+		s.reportID = uint8(id)
+		p.desc.reportIDs = true
+	case TagPush:
+		if len(p.stack) == maxGlobalStack {
+			return ErrInvalidDescriptor
+		}
+		p.stack = append(p.stack, *s)
+	case TagPop:
+		if len(p.stack) == 0 {
+			return ErrInvalidDescriptor
+		}
+		*s = p.stack[len(p.stack)-1]
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	return nil
+}
+
+// local handles a local item.
+func (p *parser) local(it *Item) error {
+	switch it.Tag {
+	case TagUsage:
+		u := p.usage(it)
+		p.addUsages(u, u)
+	case TagUsageMinimum:
+		p.usageMin, p.haveUsageMin = p.usage(it), true
+	case TagUsageMaximum:
+		p.usageMax, p.haveUsageMax = p.usage(it), true
+	case TagDelimiter:
+		open := it.Unsigned() == 1
+		if open == p.delimiter {
+			return ErrInvalidDescriptor
+		}
+		p.delimiter = open
+		p.delimiterUsage = false
+	}
+
+	if p.haveUsageMin && p.haveUsageMax {
+		// Extended usages of a range must share a usage page
+		if p.usageMin > p.usageMax || p.usageMin.Page() != p.usageMax.Page() {
+			return ErrInvalidDescriptor
+		}
+		p.addUsages(p.usageMin, p.usageMax)
+		p.haveUsageMin, p.haveUsageMax = false, false
+	}
+	return nil
+}
+
+// usage returns the extended usage of a Usage, Usage Minimum, or Usage
+// Maximum item. Items of fewer than 4 bytes use the current usage page.
+func (p *parser) usage(it *Item) Usage {
+	if len(it.Data) == 4 {
+		return Usage(it.Unsigned())
+	}
+	return NewUsage(p.state.usagePage, uint16(it.Unsigned()))
+}
+
+// addUsages appends a usage range. Within a delimited set only the first
+// usage is kept.
+func (p *parser) addUsages(lo, hi Usage) {
+	if p.delimiter {
+		if p.delimiterUsage {
+			return
+		}
+		p.delimiterUsage = true
+	}
+	p.usages = append(p.usages, usageRange{min: lo, max: hi})
+}
+
+// clearLocals resets the local items after a main item.
+func (p *parser) clearLocals() {
+	p.usages = nil
+	p.haveUsageMin, p.haveUsageMax = false, false
+}
+
+// addField adds the field declared by an Input, Output, or Feature item to
+// its report.
+func (p *parser) addField(typ ReportType, flags uint32) error {
+	s := &p.state
+	if s.reportSize == 0 || s.reportCount == 0 {
+		return nil
+	}
+	// Divide rather than multiply so that the size cannot overflow int
+	if s.reportCount > maxReportBits/s.reportSize || (flags&FlagConstant == 0 && s.reportSize > 32) {
+		return ErrInvalidDescriptor
+	}
+	bits := s.reportSize * s.reportCount
+
+	r := p.desc.Report(typ, s.reportID)
+	if r == nil {
+		r = &Report{ID: s.reportID, Type: typ}
+		p.desc.Reports = append(p.desc.Reports, r)
+	}
+	if r.Bits+bits > maxReportBits {
+		return ErrInvalidDescriptor
+	}
+
+	f := &Field{
+		Report:          r,
+		Collection:      p.collection,
+		Flags:           flags,
+		BitOffset:       r.Bits,
+		BitSize:         s.reportSize,
+		Count:           s.reportCount,
+		LogicalMinimum:  s.logicalMin,
+		LogicalMaximum:  extentMaximum(s.logicalMin, s.logicalMax, s.logicalMaxU),
+		PhysicalMinimum: s.physicalMin,
+		PhysicalMaximum: extentMaximum(s.physicalMin, s.physicalMax, s.physicalMaxU),
+		UnitExponent:    s.unitExponent,
+		Unit:            s.unit,
+		usages:          p.usages,
+	}
+	if f.PhysicalMinimum == 0 && f.PhysicalMaximum == 0 {
+		f.PhysicalMinimum, f.PhysicalMaximum = f.LogicalMinimum, f.LogicalMaximum
+	}
+	for _, ur := range f.usages {
+		f.usageCount += int(ur.max) - int(ur.min) + 1
+	}
+
+	r.Bits += bits
+	r.Fields = append(r.Fields, f)
+	if p.collection != nil {
+		p.collection.Fields = append(p.collection.Fields, f)
+	}
+	return nil
+}
+
+// extentMaximum resolves a Logical or Physical Maximum: when the minimum is
+// not negative, a maximum encoded with its sign bit set (such as 0x25 0xFF
+// for 255) is unsigned.
+func extentMaximum(minimum, maximum int32, unsigned uint32) int32 {
+	if minimum >= 0 && maximum < minimum && unsigned <= 1<<31-1 {
+		return int32(unsigned)
+	}
+	return maximum
+}
@@ -0,0 +1,265 @@
+package hid
+
+import (
+	"testing"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// join concatenates report descriptor fragments.
+func join(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// testInput declares one 8-bit variable input field.
+var testInput = []byte{
+	0x75, 0x08, // Report Size (8)
+	0x95, 0x01, // Report Count (1)
+	0x81, 0x02, // Input (Data, Variable, Absolute)
+}
+
+func TestParseItem(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		n    int
+		item Item
+	}{
+		{"no data", []byte{0xC0}, 1, Item{Type: ItemTypeMain, Tag: TagEndCollection, Data: []byte{}}},
+		{"one byte", []byte{0x05, 0x07}, 2, Item{Type: ItemTypeGlobal, Tag: TagUsagePage, Data: []byte{0x07}}},
+		{"four bytes", []byte{0x0B, 1, 2, 3, 4, 0xFF}, 5, Item{Type: ItemTypeLocal, Tag: TagUsage, Data: []byte{1, 2, 3, 4}}},
+		{"long", []byte{ItemPrefixLong, 2, 0xF0, 0xAA, 0xBB}, 5, Item{Type: ItemTypeReserved, Tag: 0xF0, Data: []byte{0xAA, 0xBB}, Long: true}},
+		{"empty", nil, 0, Item{}},
+		{"truncated one byte", []byte{0x05}, 0, Item{}},
+		{"truncated four bytes", []byte{0x0B, 1, 2, 3}, 0, Item{}},
+		{"truncated long header", []byte{ItemPrefixLong, 0}, 0, Item{}},
+		{"truncated long data", []byte{ItemPrefixLong, 3, 0xF0, 0xAA, 0xBB}, 0, Item{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var it Item
+			n := ParseItem(tt.data, &it)
+			if n != tt.n {
+				t.Fatalf("ParseItem() = %d, want %d", n, tt.n)
+			}
+			if n == 0 {
+				return
+			}
+			if it.Type != tt.item.Type || it.Tag != tt.item.Tag || it.Long != tt.item.Long ||
+				string(it.Data) != string(tt.item.Data) {
+				t.Errorf("ParseItem() item = %+v, want %+v", it, tt.item)
+			}
+		})
+	}
+}
+
+func TestItemSigned(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want int32
+	}{
+		{nil, 0},
+		{[]byte{0x81}, -127},
+		{[]byte{0x00, 0x80}, -32768},
+		{[]byte{0xFF, 0xFF, 0xFF, 0x7F}, 1<<31 - 1},
+		{[]byte{0x00, 0x00, 0x00, 0x80}, -1 << 31},
+	}
+	for _, tt := range tests {
+		it := Item{Data: tt.data}
+		if got := it.Signed(); got != tt.want {
+			t.Errorf("Signed(% X) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseBootKeyboard(t *testing.T) {
+	d, err := ParseReportDescriptor(BootKeyboardReportDescriptor)
+	if err != nil {
+		t.Fatalf("ParseReportDescriptor() error = %v", err)
+	}
+	if d.HasReportIDs() || len(d.Collections) != 1 {
+		t.Fatalf("report IDs = %v, collections = %d", d.HasReportIDs(), len(d.Collections))
+	}
+	in := d.Report(ReportTypeInput, 0)
+	out := d.Report(ReportTypeOutput, 0)
+	if in == nil || in.Size() != 8 || out == nil || out.Size() != 1 {
+		t.Fatalf("input %+v, output %+v", in, out)
+	}
+
+	// Left Shift, then keys a and b with the other key slots empty
+	report := []byte{0x02, 0x00, 0x04, 0x05, 0x00, 0x00, 0x00, 0x00}
+	_, values, err := d.Decode(ReportTypeInput, report)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	var pressed []Usage
+	for _, v := range values {
+		if v.Value != 0 {
+			pressed = append(pressed, v.Usage)
+		}
+	}
+	want := []Usage{NewUsage(UsagePageKeyboard, 0xE1), NewUsage(UsagePageKeyboard, 0x04), NewUsage(UsagePageKeyboard, 0x05)}
+	if len(pressed) != len(want) {
+		t.Fatalf("pressed %v, want %v", pressed, want)
+	}
+	for i := range want {
+		if pressed[i] != want[i] {
+			t.Errorf("pressed[%d] = %v, want %v", i, pressed[i], want[i])
+		}
+	}
+
+	if _, _, err := d.Decode(ReportTypeInput, report[:7]); err != pkg.ErrProtocol {
+		t.Errorf("Decode(short) error = %v, want %v", err, pkg.ErrProtocol)
+	}
+	if _, _, err := d.Decode(ReportTypeFeature, report); err != ErrUnknownReport {
+		t.Errorf("Decode(feature) error = %v, want %v", err, ErrUnknownReport)
+	}
+}
+
+func TestParseReportDescriptorMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated item", []byte{0x05}},
+		{"truncated long item", []byte{ItemPrefixLong, 4, 0}},
+		{"end without collection", []byte{0xC0}},
+		{"unclosed collection", []byte{0xA1, 0x01}},
+		{"unclosed delimiter", []byte{0xA9, 0x01}},
+		{"close without delimiter", []byte{0xA9, 0x00}},
+		{"push overflow", []byte{
+			0xA4, 0xA4, 0xA4, 0xA4, 0xA4, 0xA4, 0xA4, 0xA4,
+			0xA4, 0xA4, 0xA4, 0xA4, 0xA4, 0xA4, 0xA4, 0xA4,
+			0xA4,
+		}},
+		{"pop underflow", []byte{0xB4}},
+		{"report ID 0", []byte{0x85, 0x00}},
+		{"report ID above 255", []byte{0x86, 0x00, 0x01}},
+		{"report size too large", []byte{0x77, 0x01, 0x00, 0x01, 0x00}},
+		{"report count too large", []byte{0x97, 0x01, 0x00, 0x01, 0x00}},
+		{"report too large", []byte{
+			0x77, 0x00, 0x00, 0x01, 0x00, // Report Size (65536)
+			0x97, 0x00, 0x00, 0x01, 0x00, // Report Count (65536)
+			0x81, 0x01, // Input (Constant)
+		}},
+		{"reports too large", join(
+			[]byte{0x76, 0x00, 0x80, 0x95, 0x01, 0x81, 0x01}, // 32768 bits
+			[]byte{0x81, 0x01, 0x81, 0x01},
+		)},
+		{"data element above 32 bits", []byte{0x75, 0x21, 0x95, 0x01, 0x81, 0x02}},
+		{"usage range reversed", []byte{0x19, 0x05, 0x29, 0x01}},
+		{"usage range across pages", []byte{
+			0x1B, 0x00, 0x00, 0x01, 0x00, // Usage Minimum (0x0001:0000)
+			0x2B, 0xFF, 0xFF, 0xFF, 0xFF, // Usage Maximum (0xFFFF:FFFF)
+		}},
+		{"reports with and without IDs", join(testInput, []byte{0x85, 0x01}, testInput)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d, err := ParseReportDescriptor(tt.data); err != ErrInvalidDescriptor {
+				t.Errorf("ParseReportDescriptor() = %v, %v, want %v", d, err, ErrInvalidDescriptor)
+			}
+		})
+	}
+}
+
+func TestDecodeReportIDs(t *testing.T) {
+	d, err := ParseReportDescriptor(join([]byte{0x85, 0x01}, testInput, []byte{0x85, 0x02}, testInput, testInput))
+	if err != nil {
+		t.Fatalf("ParseReportDescriptor() error = %v", err)
+	}
+	if r := d.Report(ReportTypeInput, 2); r == nil || r.Size() != 3 {
+		t.Fatalf("report 2 = %+v, want 3 bytes", r)
+	}
+
+	r, values, err := d.Decode(ReportTypeInput, []byte{0x02, 0x11, 0x22})
+	if err != nil || r.ID != 2 || len(values) != 2 || values[1].Value != 0x22 {
+		t.Errorf("Decode() = %+v, %v, %v", r, values, err)
+	}
+	if _, _, err := d.Decode(ReportTypeInput, nil); err != pkg.ErrProtocol {
+		t.Errorf("Decode(empty) error = %v, want %v", err, pkg.ErrProtocol)
+	}
+	if _, _, err := d.Decode(ReportTypeInput, []byte{0x03, 0x00}); err != ErrUnknownReport {
+		t.Errorf("Decode(unknown ID) error = %v, want %v", err, ErrUnknownReport)
+	}
+	if _, _, err := d.Decode(ReportTypeInput, []byte{0x02, 0x11}); err != pkg.ErrProtocol {
+		t.Errorf("Decode(short) error = %v, want %v", err, pkg.ErrProtocol)
+	}
+}
+
+func TestDecodeArrayLogicalRange(t *testing.T) {
+	// An array whose logical range spans all 32-bit values but declares
+	// only two usages
+	d, err := ParseReportDescriptor([]byte{
+		0x05, 0x07, // Usage Page (Keyboard/Keypad)
+		0x19, 0x04, // Usage Minimum (a)
+		0x29, 0x05, // Usage Maximum (b)
+		0x17, 0x00, 0x00, 0x00, 0x80, // Logical Minimum (-2147483648)
+		0x27, 0xFF, 0xFF, 0xFF, 0x7F, // Logical Maximum (2147483647)
+		0x75, 0x20, // Report Size (32)
+		0x95, 0x03, // Report Count (3)
+		0x81, 0x00, // Input (Data, Array)
+	})
+	if err != nil {
+		t.Fatalf("ParseReportDescriptor() error = %v", err)
+	}
+
+	// Elements selecting the first usage, past the last usage, and at the
+	// top of the logical range
+	report := []byte{
+		0x00, 0x00, 0x00, 0x80,
+		0x02, 0x00, 0x00, 0x80,
+		0xFF, 0xFF, 0xFF, 0x7F,
+	}
+	_, values, err := d.Decode(ReportTypeInput, report)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if len(values) != 1 || values[0].Usage != NewUsage(UsagePageKeyboard, 0x04) {
+		t.Errorf("Decode() values = %v, want [a]", values)
+	}
+}
+
+func TestFieldValueBounds(t *testing.T) {
+	f := &Field{BitOffset: 4, BitSize: 12, Count: 2, LogicalMinimum: -2048}
+	data := make([]byte, 4)
+
+	if !f.SetValue(data, 0, -1) || !f.SetValue(data, 1, 0x7FF) {
+		t.Fatal("SetValue() failed")
+	}
+	if data[0]&0x0F != 0 {
+		t.Errorf("SetValue() changed bits before the field: % X", data)
+	}
+	if v := f.Value(data, 0); v != -1 {
+		t.Errorf("Value(0) = %d, want -1", v)
+	}
+	if v := f.Value(data, 1); v != 0x7FF {
+		t.Errorf("Value(1) = %d, want %d", v, 0x7FF)
+	}
+
+	for _, i := range []int{-1, 2} {
+		if v := f.Value(data, i); v != 0 {
+			t.Errorf("Value(%d) = %d, want 0", i, v)
+		}
+		if f.SetValue(data, i, 1) {
+			t.Errorf("SetValue(%d) should fail", i)
+		}
+	}
+	if v := f.Value(data[:3], 1); v != 0 {
+		t.Errorf("Value(short) = %d, want 0", v)
+	}
+	if f.SetValue(data[:3], 1, 1) {
+		t.Error("SetValue(short) should fail")
+	}
+
+	wide := &Field{BitSize: 40, Count: 1}
+	if v := wide.Value(make([]byte, 8), 0); v != 0 {
+		t.Errorf("Value(40 bits) = %d, want 0", v)
+	}
+}
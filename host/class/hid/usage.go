@@ -0,0 +1,222 @@
+package hid
+
+import "strconv"
+
+// Usage is an extended usage: the usage page in the high 16 bits and the
+// usage ID in the low 16 bits.
+type Usage uint32
+
+// NewUsage returns the extended usage for a usage page and usage ID.
+func NewUsage(page, id uint16) Usage {
+	return Usage(page)<<16 | Usage(id)
+}
+
+// Page returns the usage page.
+func (u Usage) Page() uint16 {
+	return uint16(u >> 16)
+}
+
+// ID returns the usage ID within its page.
+func (u Usage) ID() uint16 {
+	return uint16(u)
+}
+
+// String returns a readable name for the usage, such as "Generic Desktop.X"
+// or "Button.3". Usages without a known name are shown by ID.
+func (u Usage) String() string {
+	return UsagePageName(u.Page()) + "." + UsageName(u)
+}
+
+// UsagePageName returns the name of a usage page, or its hexadecimal value
+// if the page is not known.
+func UsagePageName(page uint16) string {
+	if name, ok := usagePageNames[page]; ok {
+		return name
+	}
+	if page >= UsagePageVendorDefined {
+		return "Vendor " + hex16(page)
+	}
+	return "Page " + hex16(page)
+}
+
+// UsageName returns the name of a usage within its page, or its
+// hexadecimal ID if the usage is not known.
+func UsageName(u Usage) string {
+	id := u.ID()
+	switch u.Page() {
+	case UsagePageGenericDesktop:
+		if name, ok := genericDesktopNames[id]; ok {
+			return name
+		}
+	case UsagePageKeyboard:
+		if name := keyboardName(id); name != "" {
+			return name
+		}
+	case UsagePageLED:
+		if name, ok := ledNames[id]; ok {
+			return name
+		}
+	case UsagePageButton:
+		if id == 0 {
+			return "No Button"
+		}
+		return strconv.Itoa(int(id))
+	case UsagePageOrdinal:
+		return strconv.Itoa(int(id))
+	case UsagePageConsumer:
+		if name, ok := consumerNames[id]; ok {
+			return name
+		}
+	}
+	return hex16(id)
+}
+
+// hex16 formats v as a 4-digit hexadecimal number with a 0x prefix.
+func hex16(v uint16) string {
+	const digits = "0123456789ABCDEF"
+	return string([]byte{'0', 'x',
+		digits[v>>12&0xF], digits[v>>8&0xF], digits[v>>4&0xF], digits[v&0xF]})
+}
+
+// keyboardName returns the name of a Keyboard/Keypad page usage, or an
+// empty string if it is not known.
+func keyboardName(id uint16) string {
+	switch {
+	case id >= 0x04 && id <= 0x1D:
+		return string(rune('A' + id - 0x04))
+	case id >= 0x1E && id <= 0x26:
+		return string(rune('1' + id - 0x1E))
+	case id == 0x27:
+		return "0"
+	case id >= 0x3A && id <= 0x45:
+		return "F" + strconv.Itoa(int(id-0x3A+1))
+	}
+	return keyboardNames[id]
+}
+
+var usagePageNames = map[uint16]string{
+	UsagePageGenericDesktop: "Generic Desktop",
+	UsagePageSimulation:     "Simulation",
+	UsagePageVR:             "VR",
+	UsagePageSport:          "Sport",
+	UsagePageGame:           "Game",
+	UsagePageGenericDevice:  "Generic Device",
+	UsagePageKeyboard:       "Keyboard",
+	UsagePageLED:            "LED",
+	UsagePageButton:         "Button",
+	UsagePageOrdinal:        "Ordinal",
+	UsagePageTelephony:      "Telephony",
+	UsagePageConsumer:       "Consumer",
+	UsagePageDigitizer:      "Digitizer",
+	UsagePageHaptics:        "Haptics",
+	UsagePagePID:            "PID",
+	UsagePageUnicode:        "Unicode",
+	UsagePageEyeHeadTracker: "Eye and Head Tracker",
+	UsagePageAlphanumeric:   "Alphanumeric Display",
+	UsagePageSensors:        "Sensors",
+	UsagePageMedical:        "Medical Instrument",
+	UsagePageBraille:        "Braille Display",
+	UsagePageLighting:       "Lighting and Illumination",
+	UsagePageMonitor:        "Monitor",
+	UsagePagePower:          "Power",
+	UsagePageBatterySystem:  "Battery System",
+	UsagePageBarcodeScanner: "Barcode Scanner",
+	UsagePageScale:          "Scale",
+	UsagePageMagneticStripe: "Magnetic Stripe Reader",
+	UsagePageCamera:         "Camera Control",
+	UsagePageArcade:         "Arcade",
+	UsagePageFIDO:           "FIDO Alliance",
+}
+
+var genericDesktopNames = map[uint16]string{
+	UsagePointer:         "Pointer",
+	UsageMouse:           "Mouse",
+	UsageJoystick:        "Joystick",
+	UsageGamepad:         "Gamepad",
+	UsageKeyboard:        "Keyboard",
+	UsageKeypad:          "Keypad",
+	UsageMultiAxis:       "Multi-axis Controller",
+	UsageX:               "X",
+	UsageY:               "Y",
+	UsageZ:               "Z",
+	UsageRx:              "Rx",
+	UsageRy:              "Ry",
+	UsageRz:              "Rz",
+	UsageSlider:          "Slider",
+	UsageDial:            "Dial",
+	UsageWheel:           "Wheel",
+	UsageHatSwitch:       "Hat Switch",
+	UsageSystemControl:   "System Control",
+	UsageSystemPowerDown: "System Power Down",
+	UsageSystemSleep:     "System Sleep",
+	UsageSystemWakeUp:    "System Wake Up",
+	UsageDpadUp:          "D-pad Up",
+	UsageDpadDown:        "D-pad Down",
+	UsageDpadRight:       "D-pad Right",
+	UsageDpadLeft:        "D-pad Left",
+}
+
+var keyboardNames = map[uint16]string{
+	0x01: "ErrorRollOver",
+	0x02: "POSTFail",
+	0x03: "ErrorUndefined",
+	0x28: "Enter",
+	0x29: "Escape",
+	0x2A: "Backspace",
+	0x2B: "Tab",
+	0x2C: "Space",
+	0x2D: "Minus",
+	0x2E: "Equal",
+	0x2F: "Left Brace",
+	0x30: "Right Brace",
+	0x31: "Backslash",
+	0x33: "Semicolon",
+	0x34: "Quote",
+	0x35: "Grave",
+	0x36: "Comma",
+	0x37: "Dot",
+	0x38: "Slash",
+	0x39: "Caps Lock",
+	0x46: "Print Screen",
+	0x47: "Scroll Lock",
+	0x48: "Pause",
+	0x49: "Insert",
+	0x4A: "Home",
+	0x4B: "Page Up",
+	0x4C: "Delete",
+	0x4D: "End",
+	0x4E: "Page Down",
+	0x4F: "Right Arrow",
+	0x50: "Left Arrow",
+	0x51: "Down Arrow",
+	0x52: "Up Arrow",
+	0x53: "Num Lock",
+	0xE0: "Left Control",
+	0xE1: "Left Shift",
+	0xE2: "Left Alt",
+	0xE3: "Left GUI",
+	0xE4: "Right Control",
+	0xE5: "Right Shift",
+	0xE6: "Right Alt",
+	0xE7: "Right GUI",
+}
+
+var ledNames = map[uint16]string{
+	0x01: "Num Lock",
+	0x02: "Caps Lock",
+	0x03: "Scroll Lock",
+	0x04: "Compose",
+	0x05: "Kana",
+}
+
+var consumerNames = map[uint16]string{
+	UsageConsumerControl: "Consumer Control",
+	0xB5:                 "Scan Next Track",
+	0xB6:                 "Scan Previous Track",
+	0xB7:                 "Stop",
+	UsagePlayPause:       "Play/Pause",
+	UsageMute:            "Mute",
+	UsageVolumeIncrement: "Volume Increment",
+	UsageVolumeDecrement: "Volume Decrement",
+	UsageACPan:           "AC Pan",
+}
@@ -0,0 +1,69 @@
+package msc
+
+import "encoding/binary"
+
+// CommandBlockWrapper represents a Command Block Wrapper in Bulk-Only Transport.
+type CommandBlockWrapper struct {
+	Signature          uint32   // Must be CBWSignature (0x43425355)
+	Tag                uint32   // Command block tag
+	DataTransferLength uint32   // Number of bytes to transfer in data phase
+	Flags              uint8    // Direction flag (bit 7: 0=Out, 1=In)
+	LUN                uint8    // Logical Unit Number (bits 0-3)
+	CBLength           uint8    // Command block length (1-16)
+	CB                 [16]byte // Command block (SCSI CDB)
+}
+
+// MarshalTo writes the Command Block Wrapper to buf.
+// Returns the number of bytes written, or 0 if buf is too small.
+func (cbw *CommandBlockWrapper) MarshalTo(buf []byte) int {
+	if len(buf) < CBWSize {
+		return 0
+	}
+
+	binary.LittleEndian.PutUint32(buf[0:4], cbw.Signature)
+	binary.LittleEndian.PutUint32(buf[4:8], cbw.Tag)
+	binary.LittleEndian.PutUint32(buf[8:12], cbw.DataTransferLength)
+	buf[12] = cbw.Flags
+	buf[13] = cbw.LUN & 0x0F
+	buf[14] = cbw.CBLength & 0x1F
+	copy(buf[15:31], cbw.CB[:])
+
+	return CBWSize
+}
+
+// CommandStatusWrapper represents a Command Status Wrapper in Bulk-Only Transport.
+type CommandStatusWrapper struct {
+	Signature   uint32 // Must be CSWSignature (0x53425355)
+	Tag         uint32 // Must match the CBW tag
+	DataResidue uint32 // Difference between expected and actual data transfer
+	Status      uint8  // Command status (CSWStatus*)
+}
+
+// ParseCSW parses a Command Status Wrapper from raw bytes.
+// Returns false if data is not CSWSize bytes or the signature is invalid.
+func ParseCSW(data []byte, out *CommandStatusWrapper) bool {
+	if len(data) != CSWSize {
+		return false
+	}
+
+	out.Signature = binary.LittleEndian.Uint32(data[0:4])
+	if out.Signature != CSWSignature {
+		return false
+	}
+
+	out.Tag = binary.LittleEndian.Uint32(data[4:8])
+	out.DataResidue = binary.LittleEndian.Uint32(data[8:12])
+	out.Status = data[12]
+
+	return true
+}
+
+// checkCSW reports whether csw is valid and meaningful for cbw: its tag
+// matches, and unless it reports a phase error, its residue does not
+// exceed the data transfer length.
+func checkCSW(csw *CommandStatusWrapper, cbw *CommandBlockWrapper) bool {
+	if csw.Tag != cbw.Tag {
+		return false
+	}
+	return csw.Status == CSWStatusPhaseError || csw.DataResidue <= cbw.DataTransferLength
+}
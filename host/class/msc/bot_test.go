@@ -0,0 +1,100 @@
+package msc
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testCSW returns the bytes of a CSW.
+func testCSW(tag, residue uint32, status uint8) []byte {
+	buf := make([]byte, CSWSize)
+	binary.LittleEndian.PutUint32(buf[0:], CSWSignature)
+	binary.LittleEndian.PutUint32(buf[4:], tag)
+	binary.LittleEndian.PutUint32(buf[8:], residue)
+	buf[12] = status
+	return buf
+}
+
+func TestCommandBlockWrapperMarshalTo(t *testing.T) {
+	cbw := CommandBlockWrapper{
+		Signature:          CBWSignature,
+		Tag:                0x01020304,
+		DataTransferLength: 512,
+		Flags:              CBWFlagDataIn,
+		LUN:                0xF3, // Reserved bits are dropped
+		CBLength:           10,
+		CB:                 [16]byte{SCSIRead10},
+	}
+
+	var buf [CBWSize]byte
+	if n := cbw.MarshalTo(buf[:]); n != CBWSize {
+		t.Fatalf("MarshalTo() = %d, want %d", n, CBWSize)
+	}
+	if sig := binary.LittleEndian.Uint32(buf[0:]); sig != CBWSignature {
+		t.Errorf("signature = 0x%08X, want 0x%08X", sig, CBWSignature)
+	}
+	if buf[12] != CBWFlagDataIn || buf[13] != 0x03 || buf[14] != 10 || buf[15] != SCSIRead10 {
+		t.Errorf("CBW = % X", buf[:])
+	}
+	if n := cbw.MarshalTo(buf[:CBWSize-1]); n != 0 {
+		t.Errorf("MarshalTo(short) = %d, want 0", n)
+	}
+}
+
+func TestParseCSW(t *testing.T) {
+	var csw CommandStatusWrapper
+	if !ParseCSW(testCSW(7, 12, CSWStatusFailed), &csw) {
+		t.Fatal("ParseCSW() failed")
+	}
+	if csw.Tag != 7 || csw.DataResidue != 12 || csw.Status != CSWStatusFailed {
+		t.Errorf("parsed %+v", csw)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", testCSW(7, 0, CSWStatusGood)[:CSWSize-1]},
+		{"long", append(testCSW(7, 0, CSWStatusGood), 0)},
+		{"signature", func() []byte {
+			b := testCSW(7, 0, CSWStatusGood)
+			b[0] ^= 0xFF
+			return b
+		}()},
+		{"data block", make([]byte, CSWSize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ParseCSW(tt.data, &csw) {
+				t.Error("ParseCSW() should fail")
+			}
+		})
+	}
+}
+
+func TestCheckCSW(t *testing.T) {
+	cbw := CommandBlockWrapper{Signature: CBWSignature, Tag: 7, DataTransferLength: 512}
+
+	tests := []struct {
+		name string
+		csw  CommandStatusWrapper
+		want bool
+	}{
+		{"good", CommandStatusWrapper{Tag: 7, Status: CSWStatusGood}, true},
+		{"failed with residue", CommandStatusWrapper{Tag: 7, DataResidue: 512, Status: CSWStatusFailed}, true},
+		{"tag mismatch", CommandStatusWrapper{Tag: 8, Status: CSWStatusGood}, false},
+		{"residue past length", CommandStatusWrapper{Tag: 7, DataResidue: 513, Status: CSWStatusGood}, false},
+		{"residue overflow", CommandStatusWrapper{Tag: 7, DataResidue: 0xFFFFFFFF, Status: CSWStatusFailed}, false},
+		{"phase error", CommandStatusWrapper{Tag: 7, DataResidue: 0xFFFFFFFF, Status: CSWStatusPhaseError}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkCSW(&tt.csw, &cbw); got != tt.want {
+				t.Errorf("checkCSW() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package msc
+
+// USB Mass Storage Class codes.
+const (
+	ClassMSC = 0x08 // Mass Storage Class
+)
+
+// MSC Subclass codes.
+const (
+	SubclassSCSI = 0x06 // SCSI Transparent Command Set
+)
+
+// MSC Protocol codes.
+const (
+	ProtocolBulkOnly = 0x50 // Bulk-Only Transport (BOT)
+)
+
+// Bulk-Only Transport request codes.
+const (
+	RequestBulkOnlyMassStorageReset = 0xFF // Reset the MSC device
+	RequestGetMaxLUN                = 0xFE // Get maximum Logical Unit Number
+)
+
+// Command Block Wrapper (CBW) constants.
+const (
+	CBWSignature   = 0x43425355 // "USBC" signature
+	CBWSize        = 31         // Fixed CBW size in bytes
+	CBWFlagDataOut = 0x00       // Data transfer: host to device
+	CBWFlagDataIn  = 0x80       // Data transfer: device to host
+)
+
+// Command Status Wrapper (CSW) constants.
+const (
+	CSWSignature        = 0x53425355 // "USBS" signature
+	CSWSize             = 13         // Fixed CSW size in bytes
+	CSWStatusGood       = 0x00       // Command passed
+	CSWStatusFailed     = 0x01       // Command failed
+	CSWStatusPhaseError = 0x02       // Phase error occurred
+)
+
+// SCSI operation codes used by the driver.
+const (
+	SCSITestUnitReady  = 0x00 // Test if unit is ready
+	SCSIRequestSense   = 0x03 // Request sense data
+	SCSIInquiry        = 0x12 // Get device information
+	SCSIReadCapacity10 = 0x25 // Read capacity (10-byte)
+	SCSIRead10         = 0x28 // Read blocks (10-byte)
+	SCSIWrite10        = 0x2A // Write blocks (10-byte)
+)
+
+// SCSI sense keys.
+const (
+	SenseNoSense        = 0x00 // No error
+	SenseRecoveredError = 0x01 // Recovered error
+	SenseNotReady       = 0x02 // Device not ready
+	SenseMediumError    = 0x03 // Medium error
+	SenseHardwareError  = 0x04 // Hardware error
+	SenseIllegalRequest = 0x05 // Illegal request
+	SenseUnitAttention  = 0x06 // Unit attention
+	SenseDataProtect    = 0x07 // Data protect
+	SenseBlankCheck     = 0x08 // Blank check
+	SenseAbortedCommand = 0x0B // Aborted command
+)
+
+// SCSI command and response sizes.
+const (
+	CDB6Size             = 6  // 6-byte command descriptor block
+	CDB10Size            = 10 // 10-byte command descriptor block
+	InquiryStandardSize  = 36 // Standard INQUIRY data length
+	ReadCapacity10Size   = 8  // READ CAPACITY (10) response length
+	SenseFixedFormatSize = 18 // Fixed format sense data length
+)
+
+// SCSI peripheral device types.
+const (
+	DeviceTypeDisk  = 0x00 // Direct access block device (disk)
+	DeviceTypeCDROM = 0x05 // CD-ROM device
+)
+
+// MaxTransferSize is the largest data phase of a single READ (10) or
+// WRITE (10) command issued by the driver.
+const MaxTransferSize = 65536
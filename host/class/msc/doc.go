@@ -0,0 +1,49 @@
+// Package msc implements a host-side driver for the USB Mass Storage Class
+// (MSC) on the softusb host stack.
+//
+// This package drives SCSI devices over Bulk-Only Transport (BOT), the
+// protocol used by USB flash drives and card readers, and presents each
+// logical unit (LUN) as a block device.
+//
+// # Bulk-Only Transport
+//
+// Each command is a Command Block Wrapper (CBW) sent to the bulk OUT
+// endpoint, an optional data stage, and a Command Status Wrapper (CSW) read
+// from the bulk IN endpoint. The driver checks the CSW signature and tag
+// against the CBW, clears stalled endpoints during the data and status
+// stages, and performs reset recovery (Bulk-Only Mass Storage Reset and
+// CLEAR_FEATURE(ENDPOINT_HALT) on both endpoints) after a phase error or a
+// malformed CSW. When the device reports a command failure, the driver
+// reads its sense data with REQUEST SENSE; see [MSC.Sense].
+//
+// # SCSI Commands
+//
+// [MSC] issues TEST UNIT READY, INQUIRY, READ CAPACITY (10), REQUEST SENSE,
+// READ (10), and WRITE (10) directly; [MSC.Command] issues any other
+// command descriptor block.
+//
+// # Block Devices
+//
+// [MSC.Open] identifies a LUN and reads its capacity. The returned [LUN]
+// implements [io.ReaderAt] and [io.WriterAt] at byte granularity,
+// splitting large transfers into several commands and reading, modifying,
+// and writing back partial blocks:
+//
+//	disk, err := msc.New(ctx, dev)
+//	if err != nil {
+//	    return err
+//	}
+//	defer disk.Close()
+//
+//	lun, err := disk.Open(ctx, 0)
+//	if err != nil {
+//	    return err
+//	}
+//
+//	disk.SetTimeout(5 * time.Second)
+//	var mbr [512]byte
+//	_, err = lun.ReadAt(mbr[:], 0)
+//
+// ReadBlocks and WriteBlocks transfer whole blocks with a caller-provided
+// context.
+package msc
@@ -0,0 +1,259 @@
+package msc
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/ardnew/softusb/pkg"
+)
+
+// maxUnitAttentions is the number of UNIT ATTENTION conditions cleared by
+// Open before TEST UNIT READY is considered failed.
+const maxUnitAttentions = 3
+
+// LUN is a logical unit of a mass storage device, presented as a block
+// device. It implements [io.ReaderAt] and [io.WriterAt]; offsets and
+// lengths need not be block aligned.
+type LUN struct {
+	msc *MSC
+	lun uint8
+
+	inquiry    InquiryData
+	blockSize  uint32
+	blockCount uint64
+
+	// Partial block buffer (guarded by mutex)
+	bounce []byte
+	mutex  sync.Mutex
+}
+
+// Open prepares a logical unit for block access: it reads the INQUIRY data,
+// waits for the unit to report ready, and reads its capacity.
+func (m *MSC) Open(ctx context.Context, lun uint8) (*LUN, error) {
+	if lun > m.maxLUN {
+		return nil, pkg.ErrInvalidParameter
+	}
+
+	l := &LUN{msc: m, lun: lun}
+
+	var err error
+	if l.inquiry, err = m.Inquiry(ctx, lun); err != nil {
+		return nil, err
+	}
+
+	// A unit reports UNIT ATTENTION after reset or medium change
+	for i := 0; ; i++ {
+		err = m.TestUnitReady(ctx, lun)
+		if err != ErrCommandFailed || i == maxUnitAttentions ||
+			m.Sense().Key != SenseUnitAttention {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	capacity, err := m.ReadCapacity(ctx, lun)
+	if err != nil {
+		return nil, err
+	}
+	if capacity.BlockLength == 0 || capacity.BlockLength > MaxTransferSize {
+		return nil, pkg.ErrProtocol
+	}
+	l.blockSize = capacity.BlockLength
+	l.blockCount = uint64(capacity.LastLBA) + 1
+	l.bounce = make([]byte, l.blockSize)
+
+	pkg.LogDebug(pkg.ComponentHost, "MSC LUN opened",
+		"lun", lun,
+		"vendor", l.inquiry.VendorID,
+		"product", l.inquiry.ProductID,
+		"blockSize", l.blockSize,
+		"blockCount", l.blockCount)
+
+	return l, nil
+}
+
+// Number returns the logical unit number.
+func (l *LUN) Number() uint8 {
+	return l.lun
+}
+
+// Inquiry returns the INQUIRY data read by Open.
+func (l *LUN) Inquiry() InquiryData {
+	return l.inquiry
+}
+
+// BlockSize returns the logical block size in bytes.
+func (l *LUN) BlockSize() uint32 {
+	return l.blockSize
+}
+
+// BlockCount returns the number of logical blocks.
+func (l *LUN) BlockCount() uint64 {
+	return l.blockCount
+}
+
+// Size returns the capacity in bytes.
+func (l *LUN) Size() int64 {
+	return int64(l.blockCount) * int64(l.blockSize)
+}
+
+// ReadBlocks reads whole blocks starting at lba into buf, whose length must
+// be a multiple of the block size. Large reads are split into several
+// READ (10) commands.
+// Returns the number of bytes read.
+func (l *LUN) ReadBlocks(ctx context.Context, lba uint64, buf []byte) (int, error) {
+	return l.blocks(ctx, lba, buf, true)
+}
+
+// WriteBlocks writes whole blocks starting at lba from data, whose length
+// must be a multiple of the block size. Large writes are split into several
+// WRITE (10) commands.
+// Returns the number of bytes written.
+func (l *LUN) WriteBlocks(ctx context.Context, lba uint64, data []byte) (int, error) {
+	return l.blocks(ctx, lba, data, false)
+}
+
+// blocks transfers whole blocks with READ (10) or WRITE (10).
+func (l *LUN) blocks(ctx context.Context, lba uint64, buf []byte, read bool) (int, error) {
+	bs := int(l.blockSize)
+	count := uint64(len(buf) / bs)
+	if len(buf)%bs != 0 || lba+count > l.blockCount || lba+count > 1<<32 {
+		return 0, pkg.ErrInvalidParameter
+	}
+
+	maxBlocks := MaxTransferSize / bs
+	total := 0
+	for total < len(buf) {
+		blocks := min((len(buf)-total)/bs, maxBlocks)
+		chunk := buf[total : total+blocks*bs]
+
+		var n int
+		var err error
+		if read {
+			n, err = l.msc.Read10(ctx, l.lun, uint32(lba), uint16(blocks), chunk)
+		} else {
+			n, err = l.msc.Write10(ctx, l.lun, uint32(lba), uint16(blocks), chunk)
+		}
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < len(chunk) {
+			return total, io.ErrUnexpectedEOF
+		}
+		lba += uint64(blocks)
+	}
+	return total, nil
+}
+
+// ReadAt reads len(p) bytes starting at byte offset off. Partial blocks at
+// either end are read through an internal buffer.
+// Returns io.EOF if the read extends past the end of the unit.
+func (l *LUN) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, pkg.ErrInvalidParameter
+	}
+	size := l.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), size)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ctx, cancel := l.msc.transferContext()
+	defer cancel()
+
+	bs := int64(l.blockSize)
+	n := 0
+	for pos := off; pos < end; {
+		lba := uint64(pos / bs)
+		within := pos % bs
+
+		if within != 0 || end-pos < bs {
+			if _, err := l.ReadBlocks(ctx, lba, l.bounce); err != nil {
+				return n, err
+			}
+			c := copy(p[n:end-off], l.bounce[within:])
+			n += c
+			pos += int64(c)
+			continue
+		}
+
+		length := int((end - pos) / bs * bs)
+		c, err := l.ReadBlocks(ctx, lba, p[n:n+length])
+		n += c
+		if err != nil {
+			return n, err
+		}
+		pos += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes len(p) bytes starting at byte offset off. Partial blocks
+// at either end are read, modified, and written back.
+// Returns io.ErrShortWrite if the write extends past the end of the unit.
+func (l *LUN) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, pkg.ErrInvalidParameter
+	}
+	size := l.Size()
+	if off > size {
+		return 0, io.ErrShortWrite
+	}
+	end := min(off+int64(len(p)), size)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ctx, cancel := l.msc.transferContext()
+	defer cancel()
+
+	bs := int64(l.blockSize)
+	n := 0
+	for pos := off; pos < end; {
+		lba := uint64(pos / bs)
+		within := pos % bs
+
+		if within != 0 || end-pos < bs {
+			if _, err := l.ReadBlocks(ctx, lba, l.bounce); err != nil {
+				return n, err
+			}
+			c := copy(l.bounce[within:], p[n:end-off])
+			if _, err := l.WriteBlocks(ctx, lba, l.bounce); err != nil {
+				return n, err
+			}
+			n += c
+			pos += int64(c)
+			continue
+		}
+
+		length := int((end - pos) / bs * bs)
+		c, err := l.WriteBlocks(ctx, lba, p[n:n+length])
+		n += c
+		if err != nil {
+			return n, err
+		}
+		pos += int64(c)
+	}
+
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Compile-time interface checks
+var (
+	_ io.ReaderAt = (*LUN)(nil)
+	_ io.WriterAt = (*LUN)(nil)
+)
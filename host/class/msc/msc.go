@@ -0,0 +1,474 @@
+package msc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ardnew/softusb/host"
+	"github.com/ardnew/softusb/host/hal"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Bulk-Only Transport errors.
+var (
+	// ErrCommandFailed is returned when the device reports a command failure
+	// in the CSW. The sense data it returned is available from Sense.
+	ErrCommandFailed = errors.New("command failed")
+
+	// ErrPhaseError is returned when the device reports a phase error.
+	ErrPhaseError = errors.New("phase error")
+
+	// ErrInvalidCSW is returned when the CSW is malformed, its tag does not
+	// match the CBW, or its residue exceeds the data transfer length.
+	ErrInvalidCSW = errors.New("invalid command status wrapper")
+)
+
+// MSC implements a host-side USB Mass Storage Class driver for SCSI devices
+// using Bulk-Only Transport (BOT).
+//
+// Commands are serialized: BOT allows only one command in flight per
+// interface.
+type MSC struct {
+	dev *host.Device
+
+	// Interface and endpoints
+	iface         uint8
+	bulkInEP      uint8
+	bulkOutEP     uint8
+	maxPacketSize int
+
+	// Device state
+	maxLUN uint8
+	tag    uint32
+	sense  SenseData
+
+	// Timeout for LUN ReadAt and WriteAt (0 means none)
+	timeout time.Duration
+
+	// Buffers (zero-allocation)
+	cbwBuf  [CBWSize]byte
+	cswBuf  [CSWSize]byte
+	respBuf [InquiryStandardSize]byte
+
+	mutex sync.Mutex
+}
+
+// New binds a Mass Storage class driver to the first SCSI Bulk-Only
+// interface of dev, claims it, and reads the maximum LUN with GET_MAX_LUN.
+// dev must be configured.
+//
+// Devices that stall GET_MAX_LUN are treated as having a single LUN.
+// Returns pkg.ErrNotSupported if dev has no SCSI Bulk-Only interface.
+func New(ctx context.Context, dev *host.Device) (*MSC, error) {
+	m := &MSC{dev: dev}
+	if !m.bind() {
+		return nil, pkg.ErrNotSupported
+	}
+
+	if err := dev.ClaimInterface(m.iface); err != nil {
+		return nil, err
+	}
+
+	maxLUN, err := m.getMaxLUN(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			dev.ReleaseInterface(m.iface)
+			return nil, ctx.Err()
+		}
+		pkg.LogDebug(pkg.ComponentHost, "GET_MAX_LUN failed, assuming one LUN",
+			"error", err)
+		maxLUN = 0
+	}
+	m.maxLUN = maxLUN
+
+	pkg.LogDebug(pkg.ComponentHost, "MSC bound",
+		"interface", m.iface,
+		"in", m.bulkInEP,
+		"out", m.bulkOutEP,
+		"maxLUN", m.maxLUN)
+
+	return m, nil
+}
+
+// bind locates the SCSI Bulk-Only interface and its bulk endpoints.
+// Returns false if none was found.
+func (m *MSC) bind() bool {
+	ifaces := m.dev.Interfaces()
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.InterfaceClass != ClassMSC || iface.InterfaceSubClass != SubclassSCSI ||
+			iface.InterfaceProtocol != ProtocolBulkOnly || iface.AlternateSetting != 0 {
+			continue
+		}
+
+		var in, out *host.EndpointDescriptor
+		eps := m.dev.InterfaceEndpoints(i)
+		for j := range eps {
+			if !eps[j].IsBulk() {
+				continue
+			}
+			if eps[j].IsIn() {
+				in = &eps[j]
+			} else {
+				out = &eps[j]
+			}
+		}
+		if in == nil || out == nil {
+			continue
+		}
+
+		m.iface = iface.InterfaceNumber
+		m.bulkInEP = in.EndpointAddress
+		m.bulkOutEP = out.EndpointAddress
+		m.maxPacketSize = int(in.MaxPacketSize)
+		if m.maxPacketSize == 0 {
+			m.maxPacketSize = 64
+		}
+		return true
+	}
+	return false
+}
+
+// Device returns the device this driver is bound to.
+func (m *MSC) Device() *host.Device {
+	return m.dev
+}
+
+// Interface returns the mass storage interface number.
+func (m *MSC) Interface() uint8 {
+	return m.iface
+}
+
+// MaxLUN returns the highest logical unit number of the device.
+func (m *MSC) MaxLUN() uint8 {
+	return m.maxLUN
+}
+
+// SetTimeout sets the timeout for each ReadAt and WriteAt of a [LUN].
+// Zero means no timeout.
+func (m *MSC) SetTimeout(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.timeout = d
+}
+
+// Sense returns the sense data read after the last failed command.
+func (m *MSC) Sense() SenseData {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.sense
+}
+
+// Close releases the mass storage interface.
+func (m *MSC) Close() error {
+	return m.dev.ReleaseInterface(m.iface)
+}
+
+// getMaxLUN issues GET_MAX_LUN.
+func (m *MSC) getMaxLUN(ctx context.Context) (uint8, error) {
+	var buf [1]byte
+	setup := hal.SetupPacket{
+		RequestType: host.RequestTypeIn | host.RequestTypeClass | host.RequestTypeInterface,
+		Request:     RequestGetMaxLUN,
+		Index:       uint16(m.iface),
+		Length:      1,
+	}
+	n, err := m.dev.ControlTransfer(ctx, &setup, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, pkg.ErrProtocol
+	}
+	return buf[0] & 0x0F, nil
+}
+
+// Reset performs BOT reset recovery: a Bulk-Only Mass Storage Reset
+// followed by clearing the halt condition on both bulk endpoints.
+func (m *MSC) Reset(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.resetRecovery(ctx)
+}
+
+// resetRecovery performs BOT reset recovery (caller must hold mutex).
+func (m *MSC) resetRecovery(ctx context.Context) error {
+	pkg.LogDebug(pkg.ComponentHost, "MSC reset recovery",
+		"interface", m.iface)
+
+	setup := hal.SetupPacket{
+		RequestType: host.RequestTypeOut | host.RequestTypeClass | host.RequestTypeInterface,
+		Request:     RequestBulkOnlyMassStorageReset,
+		Index:       uint16(m.iface),
+	}
+	if _, err := m.dev.ControlTransfer(ctx, &setup, nil); err != nil {
+		return err
+	}
+	if err := m.dev.ClearEndpointHalt(ctx, m.bulkInEP); err != nil {
+		return err
+	}
+	return m.dev.ClearEndpointHalt(ctx, m.bulkOutEP)
+}
+
+// Command executes a SCSI command on the given LUN. data holds the data
+// phase: it is filled by the device if in is true, and sent to the device
+// otherwise.
+// Returns the number of data bytes transferred. If the device reports a
+// command failure, the sense data is read (see Sense) and ErrCommandFailed
+// returned. Transport errors trigger reset recovery before returning.
+func (m *MSC) Command(ctx context.Context, lun uint8, cdb []byte, data []byte, in bool) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.command(ctx, lun, cdb, data, in)
+}
+
+// command executes a SCSI command, reading sense data if it fails
+// (caller must hold mutex).
+func (m *MSC) command(ctx context.Context, lun uint8, cdb []byte, data []byte, in bool) (int, error) {
+	if lun > m.maxLUN || len(cdb) == 0 || len(cdb) > 16 {
+		return 0, pkg.ErrInvalidParameter
+	}
+
+	n, err := m.transport(ctx, lun, cdb, data, in)
+	if err == ErrCommandFailed && cdb[0] != SCSIRequestSense {
+		var cb [CDB6Size]byte
+		cb[0] = SCSIRequestSense
+		cb[4] = SenseFixedFormatSize
+		buf := m.respBuf[:SenseFixedFormatSize]
+		if sn, serr := m.transport(ctx, lun, cb[:], buf, true); serr == nil {
+			ParseSense(buf[:sn], &m.sense)
+			pkg.LogDebug(pkg.ComponentHost, "SCSI command failed",
+				"opcode", cdb[0],
+				"lun", lun,
+				"key", m.sense.Key,
+				"asc", m.sense.ASC,
+				"ascq", m.sense.ASCQ)
+		}
+	}
+	return n, err
+}
+
+// transport performs the CBW, data, and CSW stages of a command
+// (caller must hold mutex).
+func (m *MSC) transport(ctx context.Context, lun uint8, cdb []byte, data []byte, in bool) (int, error) {
+	m.tag++
+	cbw := CommandBlockWrapper{
+		Signature:          CBWSignature,
+		Tag:                m.tag,
+		DataTransferLength: uint32(len(data)),
+		LUN:                lun,
+		CBLength:           uint8(len(cdb)),
+	}
+	if in && len(data) > 0 {
+		cbw.Flags = CBWFlagDataIn
+	}
+	copy(cbw.CB[:], cdb)
+
+	n := cbw.MarshalTo(m.cbwBuf[:])
+	if _, err := m.dev.BulkTransfer(ctx, m.bulkOutEP, m.cbwBuf[:n]); err != nil {
+		return 0, m.recover(ctx, err)
+	}
+
+	// Data stage; a stalled endpoint ends it early but still has a CSW
+	transferred := 0
+	if len(data) > 0 {
+		var err error
+		ep := m.bulkOutEP
+		if in {
+			ep = m.bulkInEP
+			transferred, err = m.receive(ctx, data)
+		} else {
+			transferred, err = m.send(ctx, data)
+		}
+		if err != nil {
+			if !errors.Is(err, pkg.ErrStall) {
+				return transferred, m.recover(ctx, err)
+			}
+			if err := m.dev.ClearEndpointHalt(ctx, ep); err != nil {
+				return transferred, m.recover(ctx, err)
+			}
+		}
+	}
+
+	var csw CommandStatusWrapper
+	if err := m.readCSW(ctx, &csw); err != nil {
+		return transferred, m.recover(ctx, err)
+	}
+	if !checkCSW(&csw, &cbw) {
+		return transferred, m.recover(ctx, ErrInvalidCSW)
+	}
+
+	if !in && csw.Status != CSWStatusPhaseError {
+		transferred = len(data) - int(csw.DataResidue)
+	}
+
+	switch csw.Status {
+	case CSWStatusGood:
+		return transferred, nil
+	case CSWStatusFailed:
+		return transferred, ErrCommandFailed
+	default:
+		return transferred, m.recover(ctx, ErrPhaseError)
+	}
+}
+
+// recover performs reset recovery after a transport error and returns err.
+func (m *MSC) recover(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	if rerr := m.resetRecovery(ctx); rerr != nil {
+		pkg.LogDebug(pkg.ComponentHost, "MSC reset recovery failed",
+			"error", rerr)
+	}
+	return err
+}
+
+// receive reads the IN data stage until buf is full or the device ends
+// the transfer with a short packet.
+func (m *MSC) receive(ctx context.Context, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := m.dev.BulkTransfer(ctx, m.bulkInEP, buf[total:])
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n == 0 || n%m.maxPacketSize != 0 {
+			break
+		}
+	}
+	return total, nil
+}
+
+// send writes the OUT data stage, one transfer per maximum packet size.
+func (m *MSC) send(ctx context.Context, data []byte) (int, error) {
+	total := 0
+	for total < len(data) {
+		n := min(len(data)-total, m.maxPacketSize)
+		written, err := m.dev.BulkTransfer(ctx, m.bulkOutEP, data[total:total+n])
+		total += written
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// readCSW reads the CSW, retrying once after clearing a stalled IN
+// endpoint.
+func (m *MSC) readCSW(ctx context.Context, out *CommandStatusWrapper) error {
+	n, err := m.dev.BulkTransfer(ctx, m.bulkInEP, m.cswBuf[:])
+	if errors.Is(err, pkg.ErrStall) {
+		if err := m.dev.ClearEndpointHalt(ctx, m.bulkInEP); err != nil {
+			return err
+		}
+		n, err = m.dev.BulkTransfer(ctx, m.bulkInEP, m.cswBuf[:])
+	}
+	if err != nil {
+		return err
+	}
+	if !ParseCSW(m.cswBuf[:n], out) {
+		return ErrInvalidCSW
+	}
+	return nil
+}
+
+// TestUnitReady issues TEST UNIT READY.
+func (m *MSC) TestUnitReady(ctx context.Context, lun uint8) error {
+	var cb [CDB6Size]byte
+	cb[0] = SCSITestUnitReady
+	_, err := m.Command(ctx, lun, cb[:], nil, false)
+	return err
+}
+
+// Inquiry issues INQUIRY and returns the standard INQUIRY data.
+func (m *MSC) Inquiry(ctx context.Context, lun uint8) (InquiryData, error) {
+	var cb [CDB6Size]byte
+	var out InquiryData
+	cb[0] = SCSIInquiry
+	cb[4] = InquiryStandardSize
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	n, err := m.command(ctx, lun, cb[:], m.respBuf[:InquiryStandardSize], true)
+	if err != nil {
+		return out, err
+	}
+	if !ParseInquiry(m.respBuf[:n], &out) {
+		return out, pkg.ErrProtocol
+	}
+	return out, nil
+}
+
+// ReadCapacity issues READ CAPACITY (10).
+func (m *MSC) ReadCapacity(ctx context.Context, lun uint8) (Capacity, error) {
+	var cb [CDB10Size]byte
+	var out Capacity
+	cb[0] = SCSIReadCapacity10
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	n, err := m.command(ctx, lun, cb[:], m.respBuf[:ReadCapacity10Size], true)
+	if err != nil {
+		return out, err
+	}
+	if !ParseCapacity10(m.respBuf[:n], &out) {
+		return out, pkg.ErrProtocol
+	}
+	return out, nil
+}
+
+// RequestSense issues REQUEST SENSE and returns the fixed format sense data.
+func (m *MSC) RequestSense(ctx context.Context, lun uint8) (SenseData, error) {
+	var cb [CDB6Size]byte
+	var out SenseData
+	cb[0] = SCSIRequestSense
+	cb[4] = SenseFixedFormatSize
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	n, err := m.command(ctx, lun, cb[:], m.respBuf[:SenseFixedFormatSize], true)
+	if err != nil {
+		return out, err
+	}
+	if !ParseSense(m.respBuf[:n], &out) {
+		return out, pkg.ErrProtocol
+	}
+	return out, nil
+}
+
+// Read10 issues READ (10) for blocks logical blocks starting at lba.
+// buf must hold exactly the requested blocks.
+// Returns the number of bytes read.
+func (m *MSC) Read10(ctx context.Context, lun uint8, lba uint32, blocks uint16, buf []byte) (int, error) {
+	var cb [CDB10Size]byte
+	putCDB10(cb[:], SCSIRead10, lba, blocks)
+	return m.Command(ctx, lun, cb[:], buf, true)
+}
+
+// Write10 issues WRITE (10) for blocks logical blocks starting at lba.
+// data must hold exactly the given blocks.
+// Returns the number of bytes written.
+func (m *MSC) Write10(ctx context.Context, lun uint8, lba uint32, blocks uint16, data []byte) (int, error) {
+	var cb [CDB10Size]byte
+	putCDB10(cb[:], SCSIWrite10, lba, blocks)
+	return m.Command(ctx, lun, cb[:], data, false)
+}
+
+// transferContext returns the context for a LUN ReadAt or WriteAt, limited
+// by the timeout.
+func (m *MSC) transferContext() (context.Context, context.CancelFunc) {
+	m.mutex.Lock()
+	timeout := m.timeout
+	m.mutex.Unlock()
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
@@ -0,0 +1,84 @@
+package msc
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// InquiryData represents standard INQUIRY data.
+type InquiryData struct {
+	DeviceType uint8  // Peripheral device type
+	Removable  bool   // Removable medium
+	Version    uint8  // SCSI version
+	VendorID   string // Vendor identification, trailing spaces removed
+	ProductID  string // Product identification, trailing spaces removed
+	ProductRev string // Product revision, trailing spaces removed
+}
+
+// ParseInquiry parses standard INQUIRY data.
+// Returns false if data is too short.
+func ParseInquiry(data []byte, out *InquiryData) bool {
+	if len(data) < InquiryStandardSize {
+		return false
+	}
+
+	out.DeviceType = data[0] & 0x1F
+	out.Removable = data[1]&0x80 != 0
+	out.Version = data[2]
+	out.VendorID = strings.TrimRight(string(data[8:16]), " \x00")
+	out.ProductID = strings.TrimRight(string(data[16:32]), " \x00")
+	out.ProductRev = strings.TrimRight(string(data[32:36]), " \x00")
+
+	return true
+}
+
+// Capacity represents READ CAPACITY data.
+type Capacity struct {
+	LastLBA     uint32 // Last logical block address
+	BlockLength uint32 // Block length in bytes
+}
+
+// ParseCapacity10 parses READ CAPACITY (10) data.
+// Returns false if data is too short.
+func ParseCapacity10(data []byte, out *Capacity) bool {
+	if len(data) < ReadCapacity10Size {
+		return false
+	}
+
+	out.LastLBA = binary.BigEndian.Uint32(data[0:4])
+	out.BlockLength = binary.BigEndian.Uint32(data[4:8])
+
+	return true
+}
+
+// SenseData represents fixed format sense data.
+type SenseData struct {
+	ResponseCode uint8  // Response code (0x70 = current, 0x71 = deferred)
+	Key          uint8  // Sense key (Sense*)
+	Information  uint32 // Information field
+	ASC          uint8  // Additional sense code
+	ASCQ         uint8  // Additional sense code qualifier
+}
+
+// ParseSense parses fixed format sense data.
+// Returns false if data is too short.
+func ParseSense(data []byte, out *SenseData) bool {
+	if len(data) < 14 {
+		return false
+	}
+
+	out.ResponseCode = data[0] & 0x7F
+	out.Key = data[2] & 0x0F
+	out.Information = binary.BigEndian.Uint32(data[3:7])
+	out.ASC = data[12]
+	out.ASCQ = data[13]
+
+	return true
+}
+
+// putCDB10 writes a 10-byte CDB addressing count blocks at lba to cb.
+func putCDB10(cb []byte, opcode uint8, lba uint32, count uint16) {
+	cb[0] = opcode
+	binary.BigEndian.PutUint32(cb[2:6], lba)
+	binary.BigEndian.PutUint16(cb[7:9], count)
+}
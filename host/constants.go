@@ -1,6 +1,11 @@
 package host
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/ardnew/softusb/host/hal"
+)
 
 // USB Speeds as defined in USB 2.0 specification.
 const (
@@ -100,6 +105,13 @@ const (
 
 	// MaxControlDataSize is the maximum data size for control transfers.
 	MaxControlDataSize = 512
+
+	// MaxHubPorts is the maximum number of downstream ports tracked per hub.
+	MaxHubPorts = 15
+
+	// MaxHubDepth is the maximum number of external hubs between the root
+	// port and a device (USB 2.0 Section 4.1.1).
+	MaxHubDepth = 5
 )
 
 // Endpoint transfer types.
@@ -129,8 +141,12 @@ const (
 	DescriptorTypeOTG                  = 0x09
 	DescriptorTypeDebug                = 0x0A
 	DescriptorTypeInterfaceAssociation = 0x0B
+	DescriptorTypeHub                  = 0x29
 )
 
+// ClassHub is the hub device and interface class code.
+const ClassHub = 0x09
+
 // Standard request codes.
 const (
 	RequestGetStatus        = 0x00
@@ -340,3 +356,163 @@ func (e *EndpointDescriptor) IsInterrupt() bool {
 func (e *EndpointDescriptor) IsIsochronous() bool {
 	return e.TransferType() == EndpointTypeIsochronous
 }
+
+// Hub feature selectors (USB 2.0 Table 11-17).
+const (
+	HubFeatureCLocalPower   = 0  // C_HUB_LOCAL_POWER
+	HubFeatureCOverCurrent  = 1  // C_HUB_OVER_CURRENT
+	PortFeatureConnection   = 0  // PORT_CONNECTION
+	PortFeatureEnable       = 1  // PORT_ENABLE
+	PortFeatureSuspend      = 2  // PORT_SUSPEND
+	PortFeatureOverCurrent  = 3  // PORT_OVER_CURRENT
+	PortFeatureReset        = 4  // PORT_RESET
+	PortFeaturePower        = 8  // PORT_POWER
+	PortFeatureLowSpeed     = 9  // PORT_LOW_SPEED
+	PortFeatureCConnection  = 16 // C_PORT_CONNECTION
+	PortFeatureCEnable      = 17 // C_PORT_ENABLE
+	PortFeatureCSuspend     = 18 // C_PORT_SUSPEND
+	PortFeatureCOverCurrent = 19 // C_PORT_OVER_CURRENT
+	PortFeatureCReset       = 20 // C_PORT_RESET
+	PortFeatureTest         = 21 // PORT_TEST
+	PortFeatureIndicator    = 22 // PORT_INDICATOR
+)
+
+// Hub port status bits (wPortStatus, USB 2.0 Table 11-21).
+const (
+	PortStatusConnection  = 1 << 0
+	PortStatusEnable      = 1 << 1
+	PortStatusSuspend     = 1 << 2
+	PortStatusOverCurrent = 1 << 3
+	PortStatusReset       = 1 << 4
+	PortStatusPower       = 1 << 8
+	PortStatusLowSpeed    = 1 << 9
+	PortStatusHighSpeed   = 1 << 10
+	PortStatusTest        = 1 << 11
+	PortStatusIndicator   = 1 << 12
+)
+
+// Hub port change bits (wPortChange, USB 2.0 Table 11-22).
+const (
+	PortChangeConnection  = 1 << 0
+	PortChangeEnable      = 1 << 1
+	PortChangeSuspend     = 1 << 2
+	PortChangeOverCurrent = 1 << 3
+	PortChangeReset       = 1 << 4
+)
+
+// Hub status and change bits (wHubStatus/wHubChange, USB 2.0 Tables 11-19 and 11-20).
+const (
+	HubStatusLocalPower  = 1 << 0
+	HubStatusOverCurrent = 1 << 1
+)
+
+// HubDescriptor represents a USB 2.0 hub class descriptor.
+type HubDescriptor struct {
+	Length             uint8
+	DescriptorType     uint8
+	NumPorts           uint8
+	Characteristics    uint16
+	PowerOnToPowerGood uint8  // Time in 2 ms units
+	ControllerCurrent  uint8  // Maximum current in mA
+	DeviceRemovable    uint16 // Bit N set if the device on port N is non-removable
+}
+
+// Hub descriptor sizes.
+const (
+	// HubDescriptorMinSize is the size of a hub descriptor for a hub with
+	// at most 7 ports.
+	HubDescriptorMinSize = 9
+
+	// HubDescriptorMaxSize is the size of a hub descriptor for a hub with
+	// MaxHubPorts ports.
+	HubDescriptorMaxSize = 7 + 2*((MaxHubPorts+8)/8)
+)
+
+// ParseHubDescriptor parses a hub descriptor from data.
+func ParseHubDescriptor(data []byte, out *HubDescriptor) bool {
+	if len(data) < 7 || data[1] != DescriptorTypeHub {
+		return false
+	}
+	out.Length = data[0]
+	out.DescriptorType = data[1]
+	out.NumPorts = data[2]
+	out.Characteristics = uint16(data[3]) | uint16(data[4])<<8
+	out.PowerOnToPowerGood = data[5]
+	out.ControllerCurrent = data[6]
+	out.DeviceRemovable = 0
+	if len(data) > 7 {
+		out.DeviceRemovable = uint16(data[7])
+	}
+	if len(data) > 8 && out.NumPorts > 7 {
+		out.DeviceRemovable |= uint16(data[8]) << 8
+	}
+	return true
+}
+
+// PowerOnDelay returns the time to wait after powering a port before
+// its status is valid.
+func (d *HubDescriptor) PowerOnDelay() time.Duration {
+	return time.Duration(d.PowerOnToPowerGood) * 2 * time.Millisecond
+}
+
+// IsRemovable returns true if the device attached to port (1-indexed) is removable.
+func (d *HubDescriptor) IsRemovable(port int) bool {
+	if port < 1 || port > MaxHubPorts {
+		return true
+	}
+	return d.DeviceRemovable&(1<<port) == 0
+}
+
+// HubPortStatus holds the status and change bits reported by GET_STATUS
+// on a hub port.
+type HubPortStatus struct {
+	Status uint16 // wPortStatus
+	Change uint16 // wPortChange
+}
+
+// ParseHubPortStatus parses a 4-byte port status response from data.
+func ParseHubPortStatus(data []byte, out *HubPortStatus) bool {
+	if len(data) < 4 {
+		return false
+	}
+	out.Status = uint16(data[0]) | uint16(data[1])<<8
+	out.Change = uint16(data[2]) | uint16(data[3])<<8
+	return true
+}
+
+// Connected returns true if a device is present on the port.
+func (s HubPortStatus) Connected() bool {
+	return s.Status&PortStatusConnection != 0
+}
+
+// Enabled returns true if the port is enabled.
+func (s HubPortStatus) Enabled() bool {
+	return s.Status&PortStatusEnable != 0
+}
+
+// PowerOn returns true if the port is powered.
+func (s HubPortStatus) PowerOn() bool {
+	return s.Status&PortStatusPower != 0
+}
+
+// OverCurrent returns true if an over-current condition exists on the port.
+func (s HubPortStatus) OverCurrent() bool {
+	return s.Status&PortStatusOverCurrent != 0
+}
+
+// Resetting returns true if the port is being reset.
+func (s HubPortStatus) Resetting() bool {
+	return s.Status&PortStatusReset != 0
+}
+
+// Speed returns the speed of the attached device.
+func (s HubPortStatus) Speed() hal.Speed {
+	switch {
+	case s.Status&PortStatusLowSpeed != 0:
+		return hal.SpeedLow
+	case s.Status&PortStatusHighSpeed != 0:
+		return hal.SpeedHigh
+	default:
+		return hal.SpeedFull
+	}
+}
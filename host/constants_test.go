@@ -2,6 +2,9 @@ package host
 
 import (
 	"testing"
+	"time"
+
+	"github.com/ardnew/softusb/host/hal"
 )
 
 // =============================================================================
@@ -312,6 +315,140 @@ func TestEndpointDescriptor_Methods(t *testing.T) {
 	}
 }
 
+// =============================================================================
+// Hub Descriptor Tests
+// =============================================================================
+
+func TestParseHubDescriptor(t *testing.T) {
+	data := []byte{
+		9, DescriptorTypeHub, // Length, Type
+		4,          // NumPorts
+		0x09, 0x00, // Characteristics
+		50,   // PowerOnToPowerGood (100 ms)
+		100,  // ControllerCurrent
+		0x04, // DeviceRemovable (port 2 non-removable)
+		0xFF, // PortPwrCtrlMask
+	}
+
+	var desc HubDescriptor
+	if !ParseHubDescriptor(data, &desc) {
+		t.Fatal("ParseHubDescriptor returned false")
+	}
+
+	if desc.NumPorts != 4 {
+		t.Errorf("NumPorts = %d, want 4", desc.NumPorts)
+	}
+	if desc.Characteristics != 0x0009 {
+		t.Errorf("Characteristics = 0x%04X, want 0x0009", desc.Characteristics)
+	}
+	if got := desc.PowerOnDelay(); got != 100*time.Millisecond {
+		t.Errorf("PowerOnDelay() = %v, want 100ms", got)
+	}
+	if desc.IsRemovable(2) {
+		t.Error("IsRemovable(2) = true, want false")
+	}
+	if !desc.IsRemovable(1) {
+		t.Error("IsRemovable(1) = false, want true")
+	}
+}
+
+func TestParseHubDescriptor_ManyPorts(t *testing.T) {
+	data := []byte{
+		11, DescriptorTypeHub,
+		10,         // NumPorts
+		0x00, 0x00, // Characteristics
+		10, 0,
+		0x00, 0x04, // DeviceRemovable (port 10 non-removable)
+		0xFF, 0xFF,
+	}
+
+	var desc HubDescriptor
+	if !ParseHubDescriptor(data, &desc) {
+		t.Fatal("ParseHubDescriptor returned false")
+	}
+	if desc.IsRemovable(10) {
+		t.Error("IsRemovable(10) = true, want false")
+	}
+}
+
+func TestParseHubDescriptor_Invalid(t *testing.T) {
+	var desc HubDescriptor
+	if ParseHubDescriptor([]byte{9, DescriptorTypeHub, 4}, &desc) {
+		t.Error("ParseHubDescriptor should return false for short data")
+	}
+	if ParseHubDescriptor([]byte{9, DescriptorTypeDevice, 4, 0, 0, 0, 0, 0, 0}, &desc) {
+		t.Error("ParseHubDescriptor should return false for wrong descriptor type")
+	}
+}
+
+func TestParseHubPortStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		connected bool
+		enabled   bool
+		powerOn   bool
+		speed     hal.Speed
+		change    uint16
+	}{
+		{
+			name:    "PoweredEmpty",
+			data:    []byte{0x00, 0x01, 0x00, 0x00},
+			powerOn: true,
+			speed:   hal.SpeedFull,
+		},
+		{
+			name:      "ConnectChange",
+			data:      []byte{0x01, 0x01, 0x01, 0x00},
+			connected: true, powerOn: true,
+			speed:  hal.SpeedFull,
+			change: PortChangeConnection,
+		},
+		{
+			name:      "EnabledLowSpeed",
+			data:      []byte{0x03, 0x03, 0x10, 0x00},
+			connected: true, enabled: true, powerOn: true,
+			speed:  hal.SpeedLow,
+			change: PortChangeReset,
+		},
+		{
+			name:      "EnabledHighSpeed",
+			data:      []byte{0x03, 0x05, 0x00, 0x00},
+			connected: true, enabled: true, powerOn: true,
+			speed: hal.SpeedHigh,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status HubPortStatus
+			if !ParseHubPortStatus(tt.data, &status) {
+				t.Fatal("ParseHubPortStatus returned false")
+			}
+			if got := status.Connected(); got != tt.connected {
+				t.Errorf("Connected() = %v, want %v", got, tt.connected)
+			}
+			if got := status.Enabled(); got != tt.enabled {
+				t.Errorf("Enabled() = %v, want %v", got, tt.enabled)
+			}
+			if got := status.PowerOn(); got != tt.powerOn {
+				t.Errorf("PowerOn() = %v, want %v", got, tt.powerOn)
+			}
+			if got := status.Speed(); got != tt.speed {
+				t.Errorf("Speed() = %v, want %v", got, tt.speed)
+			}
+			if status.Change != tt.change {
+				t.Errorf("Change = 0x%04X, want 0x%04X", status.Change, tt.change)
+			}
+		})
+	}
+
+	var status HubPortStatus
+	if ParseHubPortStatus([]byte{0, 0, 0}, &status) {
+		t.Error("ParseHubPortStatus should return false for short data")
+	}
+}
+
 // =============================================================================
 // Benchmarks
 // =============================================================================
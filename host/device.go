@@ -14,6 +14,12 @@ type Device struct {
 	port    int
 	speed   hal.Speed
 
+	// Topology (parent is nil for devices on a root port)
+	parent   *Device
+	rootPort int
+	tier     int
+	route    uint32
+
 	// Device descriptor
 	descriptor DeviceDescriptor
 
@@ -38,29 +44,85 @@ type Device struct {
 
 	// Class-specific descriptors per interface
 	classDescriptors [MaxInterfacesPerConfiguration][][]byte
+
+	// Range of endpoints (start, end) in endpoints per interface
+	interfaceEndpoints [MaxInterfacesPerConfiguration][2]int
 }
 
 // newDevice creates a new device instance.
 func newDevice(host *Host, port int, address uint8, speed hal.Speed) *Device {
 	return &Device{
-		host:    host,
-		address: address,
-		port:    port,
-		speed:   speed,
-		state:   DeviceStateDefault,
+		host:     host,
+		address:  address,
+		port:     port,
+		speed:    speed,
+		rootPort: port,
+		tier:     1,
+		state:    DeviceStateDefault,
 	}
 }
 
+// newChildDevice creates a device instance for a downstream port of the
+// given parent hub. The route string encodes the hub port at each tier
+// below the root port, four bits per tier, as in USB 3.x.
+func newChildDevice(host *Host, parent *Device, port int, speed hal.Speed) *Device {
+	dev := newDevice(host, port, 0, speed)
+	dev.parent = parent
+	dev.rootPort = parent.rootPort
+	dev.tier = parent.tier + 1
+	dev.route = parent.route | uint32(port&0x0F)<<(4*(parent.tier-1))
+	return dev
+}
+
 // Address returns the device address.
 func (d *Device) Address() uint8 {
 	return d.address
 }
 
 // Port returns the port number the device is connected to.
+// For devices behind an external hub, this is the hub's downstream port.
 func (d *Device) Port() int {
 	return d.port
 }
 
+// RootPort returns the root hub port through which the device is connected.
+func (d *Device) RootPort() int {
+	return d.rootPort
+}
+
+// Parent returns the hub the device is attached to, or nil if the device
+// is attached to a root port.
+func (d *Device) Parent() *Device {
+	return d.parent
+}
+
+// Tier returns the device's depth in the bus topology.
+// Devices attached to a root port are at tier 1; each external hub
+// between the root port and the device adds one.
+func (d *Device) Tier() int {
+	return d.tier
+}
+
+// Route returns the device's route string: the downstream hub port at each
+// tier below the root port, four bits per tier, least significant first.
+// The route string of a device attached to a root port is 0.
+func (d *Device) Route() uint32 {
+	return d.route
+}
+
+// IsHub returns true if the device is a USB hub.
+func (d *Device) IsHub() bool {
+	if d.descriptor.DeviceClass == ClassHub {
+		return true
+	}
+	for i := range d.interfaces {
+		if d.interfaces[i].InterfaceClass == ClassHub {
+			return true
+		}
+	}
+	return false
+}
+
 // Speed returns the device speed.
 func (d *Device) Speed() hal.Speed {
 	return d.speed
@@ -113,6 +175,28 @@ func (d *Device) Endpoints() []EndpointDescriptor {
 	return d.endpoints
 }
 
+// InterfaceEndpoints returns the endpoint descriptors that follow the
+// interface descriptor at the given index in Interfaces.
+// The returned slice references internal storage; do not modify.
+func (d *Device) InterfaceEndpoints(index int) []EndpointDescriptor {
+	if index < 0 || index >= len(d.interfaces) || index >= MaxInterfacesPerConfiguration {
+		return nil
+	}
+	r := d.interfaceEndpoints[index]
+	return d.endpoints[r[0]:r[1]]
+}
+
+// ClassDescriptors returns the class-specific descriptors that follow the
+// interface descriptor at the given index in Interfaces, each including its
+// length and type bytes.
+// The returned slice references internal storage; do not modify.
+func (d *Device) ClassDescriptors(index int) [][]byte {
+	if index < 0 || index >= len(d.interfaces) || index >= MaxInterfacesPerConfiguration {
+		return nil
+	}
+	return d.classDescriptors[index]
+}
+
 // GetInterface returns the interface descriptor for the given interface number.
 func (d *Device) GetInterface(num uint8) *InterfaceDescriptor {
 	for i := range d.interfaces {
@@ -212,6 +296,23 @@ func (d *Device) InterruptTransfer(ctx context.Context, endpoint uint8, data []b
 	return d.host.hal.InterruptTransfer(ctx, hal.DeviceAddress(d.address), endpoint, data)
 }
 
+// IsochronousTransfer performs a packet-oriented isochronous transfer.
+// Per-packet results are stored in xfer; see hal.HostHAL.IsochronousTransfer.
+func (d *Device) IsochronousTransfer(ctx context.Context, endpoint uint8, data []byte, xfer *hal.IsoTransfer) (int, error) {
+	return d.host.hal.IsochronousTransfer(ctx, hal.DeviceAddress(d.address), endpoint, data, xfer)
+}
+
+// ClaimInterface claims exclusive access to an interface of the device.
+// See hal.HostHAL.ClaimInterface.
+func (d *Device) ClaimInterface(iface uint8) error {
+	return d.host.hal.ClaimInterface(hal.DeviceAddress(d.address), iface)
+}
+
+// ReleaseInterface releases an interface claimed with ClaimInterface.
+func (d *Device) ReleaseInterface(iface uint8) error {
+	return d.host.hal.ReleaseInterface(hal.DeviceAddress(d.address), iface)
+}
+
 // Close closes the device.
 func (d *Device) Close() error {
 	d.mutex.Lock()
@@ -240,6 +341,8 @@ func (d *Device) parseConfigurationTree(data []byte) {
 	// Allocate space for interfaces and endpoints
 	d.interfaces = make([]InterfaceDescriptor, 0, d.config.NumInterfaces)
 	d.endpoints = make([]EndpointDescriptor, 0, MaxEndpointsPerInterface)
+	d.classDescriptors = [MaxInterfacesPerConfiguration][][]byte{}
+	d.interfaceEndpoints = [MaxInterfacesPerConfiguration][2]int{}
 
 	// Parse child descriptors
 	offset := ConfigurationDescriptorSize
@@ -263,12 +366,19 @@ func (d *Device) parseConfigurationTree(data []byte) {
 			if ParseInterfaceDescriptor(data[offset:], &iface) {
 				d.interfaces = append(d.interfaces, iface)
 				currentIfaceIdx = len(d.interfaces) - 1
+				if currentIfaceIdx < MaxInterfacesPerConfiguration {
+					n := len(d.endpoints)
+					d.interfaceEndpoints[currentIfaceIdx] = [2]int{n, n}
+				}
 			}
 
 		case DescriptorTypeEndpoint:
 			var ep EndpointDescriptor
 			if ParseEndpointDescriptor(data[offset:], &ep) {
 				d.endpoints = append(d.endpoints, ep)
+				if currentIfaceIdx >= 0 && currentIfaceIdx < MaxInterfacesPerConfiguration {
+					d.interfaceEndpoints[currentIfaceIdx][1] = len(d.endpoints)
+				}
 			}
 
 		default:
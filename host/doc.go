@@ -32,6 +32,7 @@
 //   - Bus enumeration and address assignment
 //   - Descriptor retrieval and parsing
 //   - Configuration selection
+//   - External hub port management and downstream enumeration
 //
 // # Zero-Allocation Design
 //
@@ -60,6 +61,15 @@
 //	buf := make([]byte, 64)
 //	n, err := dev.BulkTransfer(ctx, 0x81, buf)
 //
+// # Class Drivers
+//
+// Host-side class drivers bind to an enumerated Device and build on its
+// descriptors and transfer methods:
+//
+//   - [github.com/ardnew/softusb/host/class/cdc]: CDC-ACM serial ports
+//   - [github.com/ardnew/softusb/host/class/msc]: Bulk-Only mass storage
+//   - [github.com/ardnew/softusb/host/class/hid]: HID report descriptors and reports
+//
 // A FIFO-based HAL for testing is available in
 // [github.com/ardnew/softusb/host/hal/fifo].
 package host
@@ -14,6 +14,8 @@ var (
 )
 
 // enumerateDevice performs the USB enumeration sequence for a new device.
+// Only one device may be at the default address at a time, so the caller
+// must hold enumMutex.
 func (h *Host) enumerateDevice(port int) (*Device, error) {
 	pkg.LogDebug(pkg.ComponentHost, "starting enumeration", "port", port)
 
@@ -30,7 +32,18 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 
 	// Create device at address 0
 	dev := newDevice(h, port, 0, speed)
+	if err := h.enumerate(dev); err != nil {
+		return nil, err
+	}
 
+	return dev, nil
+}
+
+// enumerate addresses and configures a device that has just been reset and
+// is responding at the default address. If enumeration fails after the
+// device is addressed, the device is released. The caller must hold
+// enumMutex.
+func (h *Host) enumerate(dev *Device) (err error) {
 	// Read initial device descriptor (just the first 8 bytes to get bMaxPacketSize0)
 	var buf [MaxDescriptorSize]byte
 	setup := hal.SetupPacket{
@@ -43,10 +56,10 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 
 	n, err := h.hal.ControlTransfer(h.ctx, hal.DeviceAddress(0), &setup, buf[:8])
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if n < 8 {
-		return nil, ErrEnumerationFailed
+		return ErrEnumerationFailed
 	}
 
 	// Get max packet size from partial descriptor
@@ -60,7 +73,7 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 	// Allocate address
 	address := h.allocateAddress()
 	if address == 0 {
-		return nil, ErrNoAddress
+		return ErrNoAddress
 	}
 
 	// Set address
@@ -74,7 +87,8 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 
 	_, err = h.hal.ControlTransfer(h.ctx, hal.DeviceAddress(0), &setup, nil)
 	if err != nil {
-		return nil, err
+		h.freeAddress(address)
+		return err
 	}
 
 	pkg.LogDebug(pkg.ComponentHost, "assigned address", "address", address)
@@ -82,6 +96,11 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 	// Update device address
 	dev.address = address
 	dev.state = DeviceStateAddress
+	defer func() {
+		if err != nil {
+			h.releaseDevice(dev)
+		}
+	}()
 
 	// Now read full device descriptor using the new address
 	setup = hal.SetupPacket{
@@ -94,10 +113,10 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 
 	n, err = h.hal.ControlTransfer(h.ctx, hal.DeviceAddress(address), &setup, buf[:DeviceDescriptorSize])
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if n < DeviceDescriptorSize {
-		return nil, ErrEnumerationFailed
+		return ErrEnumerationFailed
 	}
 
 	// Parse device descriptor
@@ -119,10 +138,10 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 
 	n, err = h.hal.ControlTransfer(h.ctx, hal.DeviceAddress(address), &setup, buf[:ConfigurationDescriptorSize])
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if n < ConfigurationDescriptorSize {
-		return nil, ErrEnumerationFailed
+		return ErrEnumerationFailed
 	}
 
 	// Get total length
@@ -135,7 +154,7 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 	setup.Length = totalLength
 	n, err = h.hal.ControlTransfer(h.ctx, hal.DeviceAddress(address), &setup, buf[:totalLength])
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Parse configuration tree
@@ -154,11 +173,11 @@ func (h *Host) enumerateDevice(port int) (*Device, error) {
 	// Set configuration (use the first configuration)
 	if dev.config.ConfigurationValue > 0 {
 		if err := dev.SetConfiguration(h.ctx, dev.config.ConfigurationValue); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return dev, nil
+	return nil
 }
 
 // readStringDescriptors reads and caches string descriptors for a device.
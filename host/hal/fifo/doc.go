@@ -54,6 +54,16 @@
 //	data := make([]byte, 64)
 //	n, err := dev.BulkTransfer(ctx, 0x81, data)
 //
+// # Isochronous Transfers
+//
+// Isochronous packets are exchanged on the same endpoint FIFOs as bulk data,
+// one DATA message per packet, but are scheduled against a 1 ms frame clock.
+// Each packet is exchanged only within its own frame: a packet whose frame
+// has already passed, or an IN packet the device does not supply before its
+// frame ends, is reported through the packet status instead of being retried.
+// This exposes device-side timing problems that a bulk-style transfer would
+// hide.
+//
 // # Zero-Allocation
 //
 // The implementation uses fixed-size internal buffers and avoids allocations
@@ -34,6 +34,9 @@ const (
 	sigDisconnect = 0x00 // Device disconnected
 )
 
+// requestSetAddress is the standard SET_ADDRESS request code.
+const requestSetAddress = 0x05
+
 // Buffer sizes.
 const (
 	maxPacketSize   = 512  // Maximum USB packet size
@@ -42,9 +45,17 @@ const (
 	setupPacketSize = 8    // USB SETUP packet size
 )
 
+// Address limits.
+const (
+	maxAddresses  = 128 // USB device addresses 0-127
+	maxDownstream = 32  // Maximum devices attached behind external hubs
+)
+
 // Timing constants.
 const (
-	pollInterval = 50 * time.Millisecond // Directory polling interval
+	pollInterval  = 50 * time.Millisecond // Directory polling interval
+	frameInterval = time.Millisecond      // Bus frame period (one isochronous packet per frame)
+	frameMask     = 0x7FF                 // Frame numbers are 11 bits
 )
 
 // FIFO file names (inside each device subdirectory).
@@ -61,6 +72,7 @@ var (
 	ErrFIFOCreate   = errors.New("failed to create FIFO")
 	ErrFIFOOpen     = errors.New("failed to open FIFO")
 	ErrNoDevice     = errors.New("no device available")
+	ErrNoHubDevice  = errors.New("no device attached to hub port")
 )
 
 // deviceConn represents a connected device.
@@ -75,6 +87,17 @@ type deviceConn struct {
 	epOut [MaxEndpoints]*os.File // Host writes to device (OUT endpoints)
 	speed hal.Speed
 	port  int
+
+	// Topology for devices attached behind an external hub
+	hubAddr hal.DeviceAddress
+	hubPort int
+
+	// Serializes transfers to this device
+	mu sync.Mutex
+
+	// Internal buffers (zero-allocation pattern)
+	txBuf [maxMessageSize]byte
+	rxBuf [maxMessageSize]byte
 }
 
 // MaxEndpoints is the maximum number of data endpoints (1-15).
@@ -83,21 +106,34 @@ const MaxEndpoints = 15
 // HostHAL implements the hal.HostHAL interface using named pipes.
 // It monitors a bus directory for device subdirectories and manages
 // connections to multiple devices.
+//
+// The device in a top-level subdirectory is attached to the single root
+// port. A device emulating an external hub exposes the devices attached to
+// its downstream ports as nested subdirectories
+// (device-{uuid}/port{N}/device-{uuid}/), which are connected when the host
+// stack calls AttachHubPort. Transfers are routed by device address; the
+// address of each connection is learned from the SET_ADDRESS request that
+// moves it out of the default state.
 type HostHAL struct {
 	busDir string // Root bus directory
 
-	// Active device connection (single device for now)
+	// Active root port device connection
 	device   *deviceConn
 	deviceMu sync.RWMutex
 
-	// Internal buffers (zero-allocation pattern)
-	txBuf [maxMessageSize]byte
-	rxBuf [maxMessageSize]byte
+	// Connections by assigned address, the connection currently at the
+	// default address, and connections attached behind external hubs
+	conns       [maxAddresses]*deviceConn
+	defaultConn *deviceConn
+	downstream  [maxDownstream]*deviceConn
 
 	// Channels for connection events
 	connectCh    chan *deviceConn
 	disconnectCh chan int
 
+	// Time of frame 0, used to schedule isochronous packets
+	epoch time.Time
+
 	// Context for cancellation
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -112,6 +148,7 @@ func NewHostHAL(busDir string) *HostHAL {
 		busDir:       busDir,
 		connectCh:    make(chan *deviceConn, 8),
 		disconnectCh: make(chan int, 8),
+		epoch:        time.Now(),
 	}
 }
 
@@ -147,12 +184,9 @@ func (h *HostHAL) Stop() error {
 	// Wait for goroutines to finish
 	h.wg.Wait()
 
-	// Close any active device connection
+	// Close any active device connections
 	h.deviceMu.Lock()
-	if h.device != nil {
-		h.closeDevice(h.device)
-		h.device = nil
-	}
+	h.closeAllLocked()
 	h.deviceMu.Unlock()
 
 	pkg.LogDebug(pkg.ComponentHAL, "host FIFO HAL stopped")
@@ -210,33 +244,49 @@ func (h *HostHAL) ResetPort(port int) error {
 	}
 
 	h.deviceMu.Lock()
-	defer h.deviceMu.Unlock()
+	dev := h.device
+	if dev != nil {
+		h.unbindLocked(dev)
+		h.defaultConn = dev
+	}
+	h.deviceMu.Unlock()
 
-	if h.device == nil {
+	if dev == nil {
 		return ErrNotConnected
 	}
 
+	if err := h.resetConn(dev); err != nil {
+		return err
+	}
+
+	pkg.LogDebug(pkg.ComponentHAL, "port reset complete", "port", port)
+	return nil
+}
+
+// resetConn sends a reset message to a device and waits for acknowledgment.
+func (h *HostHAL) resetConn(dev *deviceConn) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
 	// Send reset message to device
-	h.txBuf[0] = msgReset
-	h.txBuf[1] = 0
-	h.txBuf[2] = 0
-	_, err := h.device.hostToDevice.Write(h.txBuf[:headerSize])
+	dev.txBuf[0] = msgReset
+	dev.txBuf[1] = 0
+	dev.txBuf[2] = 0
+	_, err := dev.hostToDevice.Write(dev.txBuf[:headerSize])
 	if err != nil {
 		return err
 	}
 
 	// Wait for acknowledgment with timeout
-	h.device.deviceToHost.SetReadDeadline(time.Now().Add(5 * time.Second))
-	n, err := h.device.deviceToHost.Read(h.rxBuf[:])
-	h.device.deviceToHost.SetReadDeadline(time.Time{})
+	dev.deviceToHost.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := dev.deviceToHost.Read(dev.rxBuf[:])
+	dev.deviceToHost.SetReadDeadline(time.Time{})
 	if err != nil {
 		return err
 	}
-	if n < headerSize || h.rxBuf[0] != msgAck {
+	if n < headerSize || dev.rxBuf[0] != msgAck {
 		return pkg.ErrProtocol
 	}
-
-	pkg.LogDebug(pkg.ComponentHAL, "port reset complete", "port", port)
 	return nil
 }
 
@@ -251,39 +301,57 @@ func (h *HostHAL) EnablePort(port int, enable bool) error {
 
 // ControlTransfer performs a control transfer.
 func (h *HostHAL) ControlTransfer(ctx context.Context, addr hal.DeviceAddress, setup *hal.SetupPacket, data []byte) (int, error) {
-	h.deviceMu.Lock()
-	defer h.deviceMu.Unlock()
-
-	if h.device == nil {
+	dev := h.conn(addr)
+	if dev == nil {
 		return 0, ErrNotConnected
 	}
 
+	n, err := h.controlTransfer(dev, addr, setup, data)
+	if err != nil {
+		return n, err
+	}
+
+	// Track the address assigned to a device leaving the default state
+	if addr == 0 && setup.RequestType == 0x00 && setup.Request == requestSetAddress {
+		h.deviceMu.Lock()
+		h.bindLocked(dev, hal.DeviceAddress(setup.Value))
+		h.deviceMu.Unlock()
+	}
+
+	return n, nil
+}
+
+// controlTransfer performs a control transfer on a device connection.
+func (h *HostHAL) controlTransfer(dev *deviceConn, addr hal.DeviceAddress, setup *hal.SetupPacket, data []byte) (int, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
 	isIn := (setup.RequestType & 0x80) != 0
 
 	// Build message: header + address + setup packet (+ data for OUT transfers)
-	h.txBuf[0] = msgSetup
-	h.txBuf[3] = byte(addr)
-	setup.MarshalTo(h.txBuf[4:12])
+	dev.txBuf[0] = msgSetup
+	dev.txBuf[3] = byte(addr)
+	setup.MarshalTo(dev.txBuf[4:12])
 
 	// Calculate payload length: address (1) + setup (8) + data (for OUT only)
 	payloadLen := 1 + setupPacketSize
 	if !isIn && len(data) > 0 {
-		copy(h.txBuf[12:], data)
+		copy(dev.txBuf[12:], data)
 		payloadLen += len(data)
 	}
-	binary.LittleEndian.PutUint16(h.txBuf[1:3], uint16(payloadLen))
+	binary.LittleEndian.PutUint16(dev.txBuf[1:3], uint16(payloadLen))
 
 	// Write to FIFO
 	msgLen := headerSize + payloadLen
-	_, err := h.device.hostToDevice.Write(h.txBuf[:msgLen])
+	_, err := dev.hostToDevice.Write(dev.txBuf[:msgLen])
 	if err != nil {
 		return 0, err
 	}
 
 	// Read response with timeout
-	h.device.deviceToHost.SetReadDeadline(time.Now().Add(5 * time.Second))
-	n, err := h.device.deviceToHost.Read(h.rxBuf[:])
-	h.device.deviceToHost.SetReadDeadline(time.Time{})
+	dev.deviceToHost.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := dev.deviceToHost.Read(dev.rxBuf[:])
+	dev.deviceToHost.SetReadDeadline(time.Time{})
 	if err != nil {
 		return 0, err
 	}
@@ -292,12 +360,12 @@ func (h *HostHAL) ControlTransfer(ctx context.Context, addr hal.DeviceAddress, s
 		return 0, pkg.ErrProtocol
 	}
 
-	switch h.rxBuf[0] {
+	switch dev.rxBuf[0] {
 	case msgData:
 		// Data phase response
-		respLen := int(binary.LittleEndian.Uint16(h.rxBuf[1:3]))
+		respLen := int(binary.LittleEndian.Uint16(dev.rxBuf[1:3]))
 		if respLen > 0 && isIn && len(data) > 0 {
-			copied := copy(data, h.rxBuf[headerSize:headerSize+respLen])
+			copied := copy(data, dev.rxBuf[headerSize:headerSize+respLen])
 			return copied, nil
 		}
 		return respLen, nil
@@ -324,54 +392,254 @@ func (h *HostHAL) BulkTransfer(ctx context.Context, addr hal.DeviceAddress, endp
 
 // InterruptTransfer performs an interrupt transfer.
 func (h *HostHAL) InterruptTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
-	h.deviceMu.Lock()
-	defer h.deviceMu.Unlock()
-
 	// Use the same endpoint FIFOs as bulk transfers
 	// (the device writes interrupt data to epN_in and reads from epN_out)
-	return h.dataTransferLocked(addr, endpoint, data)
+	return h.dataTransfer(ctx, addr, endpoint, data)
 }
 
-// IsochronousTransfer performs an isochronous transfer.
-func (h *HostHAL) IsochronousTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
-	// FIFO HAL doesn't fully support isochronous - treat as bulk
-	return h.dataTransfer(ctx, addr, endpoint, data)
+// IsochronousTransfer performs a packet-oriented isochronous transfer.
+//
+// Packets are scheduled one per 1 ms bus frame, starting at xfer.StartFrame
+// or, if xfer.ASAP is set, at the next frame. A packet is exchanged only
+// within its frame: a packet whose frame has already passed, or an IN packet
+// the device does not supply before its frame ends, completes with an error
+// status rather than being retried. If xfer is nil, data is transferred as a
+// single packet scheduled ASAP.
+func (h *HostHAL) IsochronousTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte, xfer *hal.IsoTransfer) (int, error) {
+	dev := h.conn(addr)
+	if dev == nil {
+		return 0, ErrNotConnected
+	}
+
+	if xfer == nil {
+		var packets [1]hal.IsoPacket
+		packets[0].Length = len(data)
+		xfer = &hal.IsoTransfer{Packets: packets[:], ASAP: true}
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	return h.isoTransferLocked(ctx, dev, endpoint, data, xfer)
 }
 
 // SetDeviceAddress sets the device address after reset.
 func (h *HostHAL) SetDeviceAddress(ctx context.Context, newAddr hal.DeviceAddress) error {
-	h.deviceMu.Lock()
-	defer h.deviceMu.Unlock()
-
-	if h.device == nil {
+	dev := h.conn(0)
+	if dev == nil {
 		return ErrNotConnected
 	}
 
+	dev.mu.Lock()
+
 	// Send address assignment message
-	h.txBuf[0] = msgAddress
-	binary.LittleEndian.PutUint16(h.txBuf[1:3], 1)
-	h.txBuf[3] = byte(newAddr)
+	dev.txBuf[0] = msgAddress
+	binary.LittleEndian.PutUint16(dev.txBuf[1:3], 1)
+	dev.txBuf[3] = byte(newAddr)
 
-	_, err := h.device.hostToDevice.Write(h.txBuf[:headerSize+1])
+	_, err := dev.hostToDevice.Write(dev.txBuf[:headerSize+1])
 	if err != nil {
+		dev.mu.Unlock()
 		return err
 	}
 
 	// Wait for acknowledgment
-	h.device.deviceToHost.SetReadDeadline(time.Now().Add(5 * time.Second))
-	n, err := h.device.deviceToHost.Read(h.rxBuf[:])
-	h.device.deviceToHost.SetReadDeadline(time.Time{})
+	dev.deviceToHost.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := dev.deviceToHost.Read(dev.rxBuf[:])
+	dev.deviceToHost.SetReadDeadline(time.Time{})
+	ok := n >= headerSize && dev.rxBuf[0] == msgAck
+	dev.mu.Unlock()
+
 	if err != nil {
 		return err
 	}
-	if n < headerSize || h.rxBuf[0] != msgAck {
+	if !ok {
 		return pkg.ErrProtocol
 	}
 
+	h.deviceMu.Lock()
+	h.bindLocked(dev, newAddr)
+	h.deviceMu.Unlock()
+
 	pkg.LogDebug(pkg.ComponentHAL, "device address set", "address", newAddr)
 	return nil
 }
 
+// AttachHubPort connects to the device attached to a downstream port of the
+// hub at hubAddr and resets it, routing transfers to address 0 to it until
+// it is assigned an address.
+// It implements hal.HubHAL.
+func (h *HostHAL) AttachHubPort(ctx context.Context, hubAddr hal.DeviceAddress, port int, speed hal.Speed) error {
+	h.deviceMu.Lock()
+	hub := h.connLocked(hubAddr)
+	if hub == nil {
+		h.deviceMu.Unlock()
+		return ErrNotConnected
+	}
+	h.detachHubPortLocked(hubAddr, port)
+	hubDir := hub.dir
+	rootPort := hub.port
+	h.deviceMu.Unlock()
+
+	// Downstream devices create their subdirectories under port{N}/
+	matches, _ := filepath.Glob(filepath.Join(hubDir, fmt.Sprintf("port%d", port), "device-*"))
+	var dirPath string
+	for _, m := range matches {
+		if _, err := os.Stat(filepath.Join(m, fifoConnection)); err == nil {
+			dirPath = m
+			break
+		}
+	}
+	if dirPath == "" {
+		return ErrNoHubDevice
+	}
+
+	dev, err := h.openDeviceFIFOs(dirPath)
+	if err != nil {
+		return err
+	}
+	dev.speed = speed
+	dev.port = rootPort
+	dev.hubAddr = hubAddr
+	dev.hubPort = port
+
+	if err := h.resetConn(dev); err != nil {
+		h.closeDevice(dev)
+		return err
+	}
+
+	h.deviceMu.Lock()
+	defer h.deviceMu.Unlock()
+
+	slot := -1
+	for i := range h.downstream {
+		if h.downstream[i] == nil {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		h.closeDevice(dev)
+		return pkg.ErrNoResources
+	}
+	h.downstream[slot] = dev
+	h.defaultConn = dev
+
+	pkg.LogDebug(pkg.ComponentHAL, "hub port device connected",
+		"hub", hubAddr,
+		"port", port,
+		"speed", speed,
+		"dir", dirPath)
+	return nil
+}
+
+// DetachHubPort closes the connection to the device attached to a
+// downstream port of the hub at hubAddr.
+// It implements hal.HubHAL.
+func (h *HostHAL) DetachHubPort(hubAddr hal.DeviceAddress, port int) error {
+	h.deviceMu.Lock()
+	defer h.deviceMu.Unlock()
+
+	h.detachHubPortLocked(hubAddr, port)
+	return nil
+}
+
+// detachHubPortLocked closes the connection attached to a hub port, along
+// with any connections attached behind it (caller must hold deviceMu).
+func (h *HostHAL) detachHubPortLocked(hubAddr hal.DeviceAddress, port int) {
+	for i, dev := range h.downstream {
+		if dev == nil || dev.hubAddr != hubAddr || dev.hubPort != port {
+			continue
+		}
+		h.downstream[i] = nil
+		for a := 1; a < maxAddresses; a++ {
+			if h.conns[a] == dev {
+				h.detachHubLocked(hal.DeviceAddress(a))
+			}
+		}
+		h.unbindLocked(dev)
+		h.closeDevice(dev)
+		pkg.LogDebug(pkg.ComponentHAL, "hub port device disconnected",
+			"hub", hubAddr,
+			"port", port)
+	}
+}
+
+// detachHubLocked closes the connections attached to every downstream port
+// of the hub at hubAddr (caller must hold deviceMu).
+func (h *HostHAL) detachHubLocked(hubAddr hal.DeviceAddress) {
+	for _, dev := range h.downstream {
+		if dev != nil && dev.hubAddr == hubAddr {
+			h.detachHubPortLocked(hubAddr, dev.hubPort)
+		}
+	}
+}
+
+// conn returns the connection for a device address.
+func (h *HostHAL) conn(addr hal.DeviceAddress) *deviceConn {
+	h.deviceMu.RLock()
+	defer h.deviceMu.RUnlock()
+	return h.connLocked(addr)
+}
+
+// connLocked returns the connection for a device address (caller must hold
+// deviceMu). Addresses not learned from SET_ADDRESS, and the default address
+// outside of hub port enumeration, route to the root port device.
+func (h *HostHAL) connLocked(addr hal.DeviceAddress) *deviceConn {
+	if addr == 0 {
+		if h.defaultConn != nil {
+			return h.defaultConn
+		}
+		return h.device
+	}
+	if int(addr) < maxAddresses && h.conns[addr] != nil {
+		return h.conns[addr]
+	}
+	return h.device
+}
+
+// bindLocked records the address assigned to a connection
+// (caller must hold deviceMu).
+func (h *HostHAL) bindLocked(dev *deviceConn, addr hal.DeviceAddress) {
+	h.unbindLocked(dev)
+	if addr > 0 && int(addr) < maxAddresses {
+		h.conns[addr] = dev
+	}
+	if h.defaultConn == dev {
+		h.defaultConn = nil
+	}
+}
+
+// unbindLocked removes any address binding for a connection
+// (caller must hold deviceMu).
+func (h *HostHAL) unbindLocked(dev *deviceConn) {
+	for i := range h.conns {
+		if h.conns[i] == dev {
+			h.conns[i] = nil
+		}
+	}
+	if h.defaultConn == dev {
+		h.defaultConn = nil
+	}
+}
+
+// closeAllLocked closes the root port connection and all downstream
+// connections (caller must hold deviceMu).
+func (h *HostHAL) closeAllLocked() {
+	for i, dev := range h.downstream {
+		if dev != nil {
+			h.closeDevice(dev)
+			h.downstream[i] = nil
+		}
+	}
+	if h.device != nil {
+		h.closeDevice(h.device)
+		h.device = nil
+	}
+	h.conns = [maxAddresses]*deviceConn{}
+	h.defaultConn = nil
+}
+
 // ClaimInterface claims exclusive access to an interface on a device.
 // FIFO HAL does not require interface claiming - this is a no-op.
 func (h *HostHAL) ClaimInterface(addr hal.DeviceAddress, iface uint8) error {
@@ -396,8 +664,8 @@ func (h *HostHAL) WaitForConnection(ctx context.Context) (int, error) {
 			// Set as active device
 			h.deviceMu.Lock()
 			if h.device != nil {
-				// Close previous device
-				h.closeDevice(h.device)
+				// Close previous device and anything attached behind it
+				h.closeAllLocked()
 			}
 			h.device = dev
 			h.deviceMu.Unlock()
@@ -419,8 +687,7 @@ func (h *HostHAL) WaitForDisconnection(ctx context.Context) (int, error) {
 		case port := <-h.disconnectCh:
 			h.deviceMu.Lock()
 			if h.device != nil && h.device.port == port {
-				h.closeDevice(h.device)
-				h.device = nil
+				h.closeAllLocked()
 			}
 			h.deviceMu.Unlock()
 
@@ -689,17 +956,19 @@ func (h *HostHAL) closeDevice(dev *deviceConn) {
 
 // dataTransfer performs a bulk/interrupt data transfer.
 func (h *HostHAL) dataTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
-	h.deviceMu.Lock()
-	defer h.deviceMu.Unlock()
+	dev := h.conn(addr)
+	if dev == nil {
+		return 0, ErrNotConnected
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
 
-	return h.dataTransferLocked(addr, endpoint, data)
+	return h.dataTransferLocked(dev, endpoint, data)
 }
 
-// dataTransferLocked performs a data transfer (caller must hold lock).
-func (h *HostHAL) dataTransferLocked(addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
-	if h.device == nil {
-		return 0, ErrNotConnected
-	}
+// dataTransferLocked performs a data transfer (caller must hold dev.mu).
+func (h *HostHAL) dataTransferLocked(dev *deviceConn, endpoint uint8, data []byte) (int, error) {
 
 	epNum := int(endpoint & 0x0F)
 	if epNum == 0 || epNum > MaxEndpoints {
@@ -712,15 +981,13 @@ func (h *HostHAL) dataTransferLocked(addr hal.DeviceAddress, endpoint uint8, dat
 	if isIn {
 		// IN transfer - read from device's IN endpoint FIFO
 		// The device writes DATA messages to epN_in
-		epFile := h.device.epIn[idx]
+		epFile := dev.epIn[idx]
 		if epFile == nil {
 			return 0, pkg.ErrInvalidEndpoint
 		}
 
 		// Read with timeout
-		epFile.SetReadDeadline(time.Now().Add(5 * time.Second))
-		n, err := epFile.Read(h.rxBuf[:])
-		epFile.SetReadDeadline(time.Time{})
+		n, err := readMessage(epFile, dev.rxBuf[:], time.Now().Add(5*time.Second))
 		if err != nil {
 			return 0, err
 		}
@@ -729,12 +996,12 @@ func (h *HostHAL) dataTransferLocked(addr hal.DeviceAddress, endpoint uint8, dat
 		if n < headerSize {
 			return 0, pkg.ErrProtocol
 		}
-		if h.rxBuf[0] != msgData {
+		if dev.rxBuf[0] != msgData {
 			return 0, pkg.ErrProtocol
 		}
-		respLen := int(binary.LittleEndian.Uint16(h.rxBuf[1:3]))
+		respLen := int(binary.LittleEndian.Uint16(dev.rxBuf[1:3]))
 		if respLen > 0 {
-			copied := copy(data, h.rxBuf[headerSize:headerSize+respLen])
+			copied := copy(data, dev.rxBuf[headerSize:headerSize+respLen])
 			return copied, nil
 		}
 		return 0, nil
@@ -742,19 +1009,19 @@ func (h *HostHAL) dataTransferLocked(addr hal.DeviceAddress, endpoint uint8, dat
 
 	// OUT transfer - write to device's OUT endpoint FIFO
 	// The device reads DATA messages from epN_out
-	epFile := h.device.epOut[idx]
+	epFile := dev.epOut[idx]
 	if epFile == nil {
 		return 0, pkg.ErrInvalidEndpoint
 	}
 
 	// Build DATA message: [type, len_lo, len_hi, data...]
-	h.txBuf[0] = msgData
-	binary.LittleEndian.PutUint16(h.txBuf[1:3], uint16(len(data)))
-	copy(h.txBuf[headerSize:], data)
+	dev.txBuf[0] = msgData
+	binary.LittleEndian.PutUint16(dev.txBuf[1:3], uint16(len(data)))
+	copy(dev.txBuf[headerSize:], data)
 
 	// Write message
 	total := headerSize + len(data)
-	_, err := epFile.Write(h.txBuf[:total])
+	_, err := epFile.Write(dev.txBuf[:total])
 	if err != nil {
 		return 0, err
 	}
@@ -764,5 +1031,157 @@ func (h *HostHAL) dataTransferLocked(addr hal.DeviceAddress, endpoint uint8, dat
 	return len(data), nil
 }
 
-// Ensure HostHAL implements hal.HostHAL.
-var _ hal.HostHAL = (*HostHAL)(nil)
+// readMessage reads one message from an endpoint FIFO into buf before
+// deadline. Messages written back to back may arrive in a single read, so
+// the header is read first and then exactly the payload it announces.
+// Returns the message length including the header.
+func readMessage(f *os.File, buf []byte, deadline time.Time) (int, error) {
+	f.SetReadDeadline(deadline)
+	defer f.SetReadDeadline(time.Time{})
+
+	if _, err := io.ReadFull(f, buf[:headerSize]); err != nil {
+		return 0, err
+	}
+	length := int(binary.LittleEndian.Uint16(buf[1:3]))
+	if headerSize+length > len(buf) {
+		return 0, pkg.ErrProtocol
+	}
+	if _, err := io.ReadFull(f, buf[headerSize:headerSize+length]); err != nil {
+		return 0, err
+	}
+	return headerSize + length, nil
+}
+
+// frame returns the bus frame number in progress at time t.
+func (h *HostHAL) frame(t time.Time) int {
+	return int(t.Sub(h.epoch)/frameInterval) & frameMask
+}
+
+// isoTransferLocked performs an isochronous transfer (caller must hold dev.mu).
+func (h *HostHAL) isoTransferLocked(ctx context.Context, dev *deviceConn, endpoint uint8, data []byte, xfer *hal.IsoTransfer) (int, error) {
+	epNum := int(endpoint & 0x0F)
+	if epNum == 0 || epNum > MaxEndpoints {
+		return 0, pkg.ErrInvalidEndpoint
+	}
+	idx := epNum - 1
+
+	isIn := (endpoint & 0x80) != 0
+
+	epFile := dev.epOut[idx]
+	if isIn {
+		epFile = dev.epIn[idx]
+	}
+	if epFile == nil {
+		return 0, pkg.ErrInvalidEndpoint
+	}
+
+	if len(xfer.Packets) == 0 || len(xfer.Packets) > hal.MaxIsoPackets {
+		return 0, pkg.ErrInvalidParameter
+	}
+	for i := range xfer.Packets {
+		if xfer.Packets[i].Length < 0 || xfer.Packets[i].Length > maxMessageSize-headerSize {
+			return 0, pkg.ErrInvalidParameter
+		}
+	}
+	if xfer.Layout() > len(data) {
+		return 0, pkg.ErrBufferTooSmall
+	}
+
+	// Locate the start of the first packet's frame
+	now := time.Now()
+	elapsed := now.Sub(h.epoch) / frameInterval
+	current := int(elapsed) & frameMask
+	delta := 1
+	if !xfer.ASAP {
+		// Frames more than half the frame space ahead are in the past
+		delta = (xfer.StartFrame - current) & frameMask
+		if delta > frameMask/2 {
+			delta -= frameMask + 1
+		}
+	}
+	start := h.epoch.Add((elapsed + time.Duration(delta)) * frameInterval)
+	xfer.StartFrame = h.frame(start)
+
+	total := 0
+	for i := range xfer.Packets {
+		p := &xfer.Packets[i]
+		begin := start.Add(time.Duration(i) * frameInterval)
+		end := begin.Add(frameInterval)
+
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(xfer.Packets); j++ {
+				xfer.Packets[j].Status = pkg.TransferStatusCancelled
+			}
+			xfer.ErrorCount += len(xfer.Packets) - i
+			return total, err
+		}
+
+		// Wait for the packet's frame; a frame already over is missed
+		if wait := time.Until(begin); wait > 0 {
+			time.Sleep(wait)
+		} else if !time.Now().Before(end) {
+			p.Status = pkg.TransferStatusError
+			xfer.ErrorCount++
+			continue
+		}
+
+		if !isIn {
+			dev.txBuf[0] = msgData
+			binary.LittleEndian.PutUint16(dev.txBuf[1:3], uint16(p.Length))
+			copy(dev.txBuf[headerSize:], data[p.Offset:p.Offset+p.Length])
+			if _, err := epFile.Write(dev.txBuf[:headerSize+p.Length]); err != nil {
+				return total, err
+			}
+			p.ActualLength = p.Length
+			total += p.Length
+			continue
+		}
+
+		// The device must supply the packet before its frame ends
+		n, err := readMessage(epFile, dev.rxBuf[:], end)
+		if err != nil {
+			if !os.IsTimeout(err) {
+				return total, err
+			}
+			p.Status = pkg.TransferStatusError
+			xfer.ErrorCount++
+			continue
+		}
+
+		if n < headerSize || dev.rxBuf[0] != msgData {
+			p.Status = pkg.TransferStatusError
+			xfer.ErrorCount++
+			continue
+		}
+
+		respLen := int(binary.LittleEndian.Uint16(dev.rxBuf[1:3]))
+		if respLen > n-headerSize {
+			respLen = n - headerSize
+		}
+		if respLen > p.Length {
+			// Babble: keep what fits and flag the packet
+			respLen = p.Length
+			p.Status = pkg.TransferStatusOverrun
+			xfer.ErrorCount++
+		}
+		copy(data[p.Offset:], dev.rxBuf[headerSize:headerSize+respLen])
+		p.ActualLength = respLen
+		total += respLen
+	}
+
+	if xfer.ErrorCount > 0 {
+		pkg.LogDebug(pkg.ComponentHAL, "isochronous packets failed",
+			"endpoint", endpoint,
+			"startFrame", xfer.StartFrame,
+			"packets", len(xfer.Packets),
+			"errors", xfer.ErrorCount)
+	}
+
+	return total, nil
+}
+
+// Ensure HostHAL implements hal.HostHAL and hal.HubHAL.
+var (
+	_ hal.HostHAL = (*HostHAL)(nil)
+	_ hal.HubHAL  = (*HostHAL)(nil)
+)
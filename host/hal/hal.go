@@ -3,6 +3,8 @@ package hal
 import (
 	"context"
 	"strconv"
+
+	"github.com/ardnew/softusb/pkg"
 )
 
 // Speed represents the USB connection speed.
@@ -124,6 +126,77 @@ func (e *EndpointDescriptor) TransferType() TransferType {
 // DeviceAddress represents a USB device address (1-127).
 type DeviceAddress uint8
 
+// MaxIsoPackets is the maximum number of packets in an isochronous transfer.
+const MaxIsoPackets = 128
+
+// IsoPacket describes a single packet within an isochronous transfer.
+type IsoPacket struct {
+	Offset       int                // Offset in transfer buffer
+	Length       int                // Expected length
+	ActualLength int                // Actual bytes transferred
+	Status       pkg.TransferStatus // Completion status of this packet
+}
+
+// IsoTransfer describes a packet-oriented isochronous transfer.
+//
+// Packets are laid out back to back in the transfer buffer in the order given.
+// Each packet occupies one (micro)frame. On completion, the HAL fills in each
+// packet's Offset, ActualLength and Status, the frame in which the transfer
+// started, and the number of packets that failed.
+type IsoTransfer struct {
+	Packets    []IsoPacket // Packet descriptors (at most MaxIsoPackets)
+	StartFrame int         // Requested start frame; actual start frame on completion
+	ASAP       bool        // Schedule in the next available frame, ignoring StartFrame
+	ErrorCount int         // Number of packets completed with an error status
+}
+
+// SetupPackets sets every packet to the same length and lays them out
+// back to back. Returns the total buffer length required.
+func (t *IsoTransfer) SetupPackets(packetSize int) int {
+	for i := range t.Packets {
+		t.Packets[i].Length = packetSize
+	}
+	return t.Layout()
+}
+
+// Layout assigns packet offsets back to back and clears completion state.
+// Returns the total buffer length required.
+func (t *IsoTransfer) Layout() int {
+	offset := 0
+	for i := range t.Packets {
+		p := &t.Packets[i]
+		p.Offset = offset
+		p.ActualLength = 0
+		p.Status = pkg.TransferStatusSuccess
+		offset += p.Length
+	}
+	t.ErrorCount = 0
+	return offset
+}
+
+// ActualLength returns the total number of bytes transferred by all packets.
+func (t *IsoTransfer) ActualLength() int {
+	total := 0
+	for i := range t.Packets {
+		total += t.Packets[i].ActualLength
+	}
+	return total
+}
+
+// Packet returns the data of the completed packet at index within buf.
+// Returns nil if the index is out of range.
+func (t *IsoTransfer) Packet(buf []byte, index int) []byte {
+	if index < 0 || index >= len(t.Packets) {
+		return nil
+	}
+	p := &t.Packets[index]
+	end := p.Offset + p.ActualLength
+	if p.Offset < 0 || end > len(buf) {
+		return nil
+	}
+	return buf[p.Offset:end]
+}
+
 // HostHAL defines the Hardware Abstraction Layer interface for USB host stacks.
 //
 // The HAL provides the low-level operations needed by the host stack to
@@ -188,9 +261,14 @@ type HostHAL interface {
 	// Returns the number of bytes transferred.
 	InterruptTransfer(ctx context.Context, addr DeviceAddress, endpoint uint8, data []byte) (int, error)
 
-	// IsochronousTransfer performs an isochronous transfer.
-	// Returns the number of bytes transferred.
-	IsochronousTransfer(ctx context.Context, addr DeviceAddress, endpoint uint8, data []byte) (int, error)
+	// IsochronousTransfer performs a packet-oriented isochronous transfer.
+	// The packets described by xfer are laid out back to back in data; each
+	// packet's completion status and actual length are stored in xfer.
+	// If xfer is nil, data is transferred as a single packet scheduled ASAP.
+	// Returns the total number of bytes transferred. An error is returned
+	// only if the transfer as a whole failed; lost or damaged packets are
+	// reported through the packet Status and xfer.ErrorCount.
+	IsochronousTransfer(ctx context.Context, addr DeviceAddress, endpoint uint8, data []byte, xfer *IsoTransfer) (int, error)
 
 	// Device Management
 
@@ -218,3 +296,19 @@ type HostHAL interface {
 	// Returns the port number (1-indexed) where the device disconnected.
 	WaitForDisconnection(ctx context.Context) (int, error)
 }
+
+// HubHAL is an optional interface implemented by HALs that must be told when
+// a device attached to a downstream port of an external hub is about to be
+// enumerated. Controllers that route transactions by topology (for example,
+// by route string or transaction translator) use it to direct default-address
+// transfers to the correct device.
+type HubHAL interface {
+	// AttachHubPort is called after the hub at hubAddr has completed a reset
+	// of the given downstream port (1-indexed). Until the attached device is
+	// assigned an address, transfers to address 0 are routed to it.
+	AttachHubPort(ctx context.Context, hubAddr DeviceAddress, port int, speed Speed) error
+
+	// DetachHubPort is called after the device on the given downstream port
+	// has been disconnected or has failed enumeration.
+	DetachHubPort(hubAddr DeviceAddress, port int) error
+}
@@ -2,6 +2,8 @@ package hal
 
 import (
 	"testing"
+
+	"github.com/ardnew/softusb/pkg"
 )
 
 // =============================================================================
@@ -268,6 +270,75 @@ func TestDeviceAddress_Range(t *testing.T) {
 	}
 }
 
+// =============================================================================
+// IsoTransfer Tests
+// =============================================================================
+
+func TestIsoTransfer_SetupPackets(t *testing.T) {
+	var packets [3]IsoPacket
+	xfer := IsoTransfer{Packets: packets[:]}
+
+	if total := xfer.SetupPackets(192); total != 576 {
+		t.Errorf("SetupPackets() = %d, want 576", total)
+	}
+	for i, p := range packets {
+		if p.Offset != i*192 || p.Length != 192 {
+			t.Errorf("packets[%d] = {Offset: %d, Length: %d}, want {%d, 192}",
+				i, p.Offset, p.Length, i*192)
+		}
+	}
+}
+
+func TestIsoTransfer_Layout(t *testing.T) {
+	packets := []IsoPacket{
+		{Length: 10, ActualLength: 5, Status: pkg.TransferStatusError},
+		{Length: 20},
+		{Length: 0},
+		{Length: 30},
+	}
+	xfer := IsoTransfer{Packets: packets, ErrorCount: 1}
+
+	if total := xfer.Layout(); total != 60 {
+		t.Errorf("Layout() = %d, want 60", total)
+	}
+
+	wantOffsets := []int{0, 10, 30, 30}
+	for i, want := range wantOffsets {
+		if packets[i].Offset != want {
+			t.Errorf("packets[%d].Offset = %d, want %d", i, packets[i].Offset, want)
+		}
+	}
+	if packets[0].ActualLength != 0 || packets[0].Status != pkg.TransferStatusSuccess {
+		t.Error("Layout() did not clear completion state")
+	}
+	if xfer.ErrorCount != 0 {
+		t.Errorf("ErrorCount = %d, want 0", xfer.ErrorCount)
+	}
+}
+
+func TestIsoTransfer_Packet(t *testing.T) {
+	var packets [2]IsoPacket
+	xfer := IsoTransfer{Packets: packets[:]}
+	buf := make([]byte, xfer.SetupPackets(4))
+	copy(buf, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	packets[0].ActualLength = 4
+	packets[1].ActualLength = 2
+
+	if got := xfer.ActualLength(); got != 6 {
+		t.Errorf("ActualLength() = %d, want 6", got)
+	}
+	if got := xfer.Packet(buf, 1); len(got) != 2 || got[0] != 5 || got[1] != 6 {
+		t.Errorf("Packet(1) = %v, want [5 6]", got)
+	}
+	if got := xfer.Packet(buf, 2); got != nil {
+		t.Errorf("Packet(2) = %v, want nil", got)
+	}
+	if got := xfer.Packet(buf[:3], 0); got != nil {
+		t.Errorf("Packet(0) on short buffer = %v, want nil", got)
+	}
+}
+
 // =============================================================================
 // Benchmarks
 // =============================================================================
@@ -30,6 +30,13 @@ const URBBufferSize = 1024
 // MaxControlTransferSize is the maximum size for control transfer data phase.
 const MaxControlTransferSize = 4096
 
+// MaxISOPacketsPerURB is the maximum number of packets in an isochronous URB.
+// This matches the usbfs limit for a single isochronous URB.
+const MaxISOPacketsPerURB = 128
+
+// MaxISOURBsPerDevice is the maximum number of pending isochronous URBs per device.
+const MaxISOURBsPerDevice = 4
+
 // =============================================================================
 // Path Length Limits
 // =============================================================================
@@ -56,24 +63,28 @@ const DevfsUSBPath = "/dev/bus/usb"
 
 // Common errno values returned by usbfs operations.
 const (
-	EPERM   = 1  // Operation not permitted
-	ENOENT  = 2  // No such file or directory
-	EIO     = 5  // I/O error
-	ENXIO   = 6  // No such device or address
-	EBADF   = 9  // Bad file descriptor
-	EAGAIN  = 11 // Resource temporarily unavailable
-	ENOMEM  = 12 // Cannot allocate memory
-	EACCES  = 13 // Permission denied
-	EFAULT  = 14 // Bad address
-	EBUSY   = 16 // Device or resource busy
-	ENODEV  = 19 // No such device
-	EINVAL  = 22 // Invalid argument
-	ENOSPC  = 28 // No space left on device
-	EPIPE   = 32 // Broken pipe
-	ENODATA = 61 // No data available
-	ETIME   = 62 // Timer expired
-	ENOSR   = 63 // Out of streams resources
-	EPROTO  = 71 // Protocol error
+	EPERM      = 1   // Operation not permitted
+	ENOENT     = 2   // No such file or directory
+	EIO        = 5   // I/O error
+	ENXIO      = 6   // No such device or address
+	EBADF      = 9   // Bad file descriptor
+	EAGAIN     = 11  // Resource temporarily unavailable
+	ENOMEM     = 12  // Cannot allocate memory
+	EACCES     = 13  // Permission denied
+	EFAULT     = 14  // Bad address
+	EBUSY      = 16  // Device or resource busy
+	ENODEV     = 19  // No such device
+	EINVAL     = 22  // Invalid argument
+	ENOSPC     = 28  // No space left on device
+	EPIPE      = 32  // Broken pipe
+	ENODATA    = 61  // No data available
+	ETIME      = 62  // Timer expired
+	ENOSR      = 63  // Out of streams resources
+	ECOMM      = 70  // Communication error on send
+	EPROTO     = 71  // Protocol error
+	EOVERFLOW  = 75  // Value too large for defined data type
+	ECONNRESET = 104 // Connection reset by peer
+	ETIMEDOUT  = 110 // Connection timed out
 )
 
 // =============================================================================
@@ -43,6 +43,19 @@ func TestMaxControlTransferSize(t *testing.T) {
 	}
 }
 
+func TestMaxISOPacketsPerURB(t *testing.T) {
+	// usbfs rejects ISO URBs with more than 128 packets
+	if MaxISOPacketsPerURB < 1 || MaxISOPacketsPerURB > 128 {
+		t.Errorf("MaxISOPacketsPerURB = %d, should be in [1, 128]", MaxISOPacketsPerURB)
+	}
+}
+
+func TestMaxISOURBsPerDevice(t *testing.T) {
+	if MaxISOURBsPerDevice < 1 {
+		t.Errorf("MaxISOURBsPerDevice = %d, should be at least 1", MaxISOURBsPerDevice)
+	}
+}
+
 // =============================================================================
 // Path Constant Tests
 // =============================================================================
@@ -88,7 +101,11 @@ func TestErrnoConstants(t *testing.T) {
 		{"ENODATA", ENODATA, 61},
 		{"ETIME", ETIME, 62},
 		{"ENOSR", ENOSR, 63},
+		{"ECOMM", ECOMM, 70},
 		{"EPROTO", EPROTO, 71},
+		{"EOVERFLOW", EOVERFLOW, 75},
+		{"ECONNRESET", ECONNRESET, 104},
+		{"ETIMEDOUT", ETIMEDOUT, 110},
 	}
 
 	for _, tt := range tests {
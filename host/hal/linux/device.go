@@ -3,8 +3,11 @@
 package linux
 
 import (
+	"context"
+	"runtime"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ardnew/softusb/host/hal"
 	"github.com/ardnew/softusb/pkg"
@@ -93,6 +96,31 @@ func (e *endpointState) getSlot(idx int) *urbSlot {
 	return &e.slots[idx]
 }
 
+// =============================================================================
+// ISO URB Slot Management
+// =============================================================================
+
+// isoSlot represents a slot in the per-device isochronous URB pool.
+// ISO URBs carry their packet descriptors inline and transfer directly to
+// and from the caller's buffer, so they are pooled separately from the
+// per-endpoint URB slots. The slot keeps the buffer pinned until the URB is
+// reaped, even if its waiter gives up first.
+type isoSlot struct {
+	urb      isoURB         // The URB and its packet descriptors
+	pinner   runtime.Pinner // Pins the caller's buffer while the kernel owns it
+	inUse    bool           // Whether this slot is in use
+	orphaned bool           // Abandoned by its waiter; freed when reaped
+	complete chan struct{}  // Signaled when the URB is reaped
+}
+
+// URB user context encoding. ISO URBs set urbContextISO and store the ISO
+// slot index; other URBs store the endpoint index and slot index.
+const (
+	urbContextISO       = 1 << 16
+	urbContextSlotMask  = 0xFF
+	urbContextEndpShift = 8
+)
+
 // =============================================================================
 // Device Connection
 // =============================================================================
@@ -106,6 +134,10 @@ type deviceConn struct {
 	// Endpoint state with URB pools
 	endpoints [MaxEndpointsPerDevice]endpointState
 
+	// Isochronous URB pool
+	isoSlots [MaxISOURBsPerDevice]isoSlot
+	isoMu    sync.Mutex // Protects isoSlots allocation
+
 	// Interface claiming
 	claimedMask uint16     // Bitmask of claimed interfaces
 	claimMu     sync.Mutex // Protects claimedMask
@@ -132,6 +164,7 @@ func newDeviceConn(info usbDeviceInfo) (*deviceConn, error) {
 	for i := range conn.endpoints {
 		conn.endpoints[i].init()
 	}
+	conn.initISOSlots()
 
 	return conn, nil
 }
@@ -278,6 +311,7 @@ func (d *deviceConn) submitAsyncURB(endpoint uint8, urbType uint8, data []byte)
 	if endpoint&0x80 == 0 {
 		copy(slot.buffer[:], data)
 	}
+	u.userContext = uintptr(epIdx)<<urbContextEndpShift | uintptr(slotIdx)
 
 	// Submit the URB
 	if err := submitURB(d.fd, u); err != nil {
@@ -293,6 +327,204 @@ func (d *deviceConn) reapAsyncURB() (*urb, error) {
 	return reapURBNDelay(d.fd)
 }
 
+// completeURB notifies the waiter of a reaped URB.
+func (d *deviceConn) completeURB(u *urb) {
+	idx := int(u.userContext & urbContextSlotMask)
+
+	if u.userContext&urbContextISO != 0 {
+		if idx < MaxISOURBsPerDevice {
+			d.completeISOSlot(idx)
+		}
+		return
+	}
+
+	epIdx := int(u.userContext>>urbContextEndpShift) & urbContextSlotMask
+	if epIdx >= MaxEndpointsPerDevice {
+		return
+	}
+	if slot := d.endpoints[epIdx].getSlot(idx); slot != nil {
+		select {
+		case slot.complete <- urbStatus(u.status).Error():
+		default:
+		}
+	}
+}
+
+// initISOSlots initializes the ISO URB pool with all slots free.
+func (d *deviceConn) initISOSlots() {
+	d.isoMu.Lock()
+	defer d.isoMu.Unlock()
+
+	for i := range d.isoSlots {
+		d.isoSlots[i].inUse = false
+		d.isoSlots[i].orphaned = false
+		d.isoSlots[i].complete = make(chan struct{}, 1)
+	}
+}
+
+// allocISOSlot allocates an ISO URB slot.
+// Returns the slot index, or -1 if no slots are available.
+func (d *deviceConn) allocISOSlot() int {
+	d.isoMu.Lock()
+	defer d.isoMu.Unlock()
+
+	for i := range d.isoSlots {
+		if !d.isoSlots[i].inUse {
+			d.isoSlots[i].inUse = true
+			// Drain any stale completion
+			select {
+			case <-d.isoSlots[i].complete:
+			default:
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// freeISOSlot unpins the buffer of an ISO URB slot and returns the slot to
+// the pool.
+func (d *deviceConn) freeISOSlot(idx int) {
+	d.isoMu.Lock()
+	defer d.isoMu.Unlock()
+
+	if idx >= 0 && idx < MaxISOURBsPerDevice {
+		d.isoSlots[idx].pinner.Unpin()
+		d.isoSlots[idx].inUse = false
+	}
+}
+
+// orphanISOSlot hands an ISO URB slot whose URB has not been reaped over to
+// completeISOSlot, which frees it when the URB is reaped. Returns false if
+// the URB was reaped in the meantime, in which case the caller still owns
+// the slot.
+func (d *deviceConn) orphanISOSlot(idx int) bool {
+	d.isoMu.Lock()
+	defer d.isoMu.Unlock()
+
+	slot := &d.isoSlots[idx]
+	select {
+	case <-slot.complete:
+		return false
+	default:
+	}
+	slot.orphaned = true
+	return true
+}
+
+// completeISOSlot notifies the waiter of a reaped ISO URB, or frees the slot
+// if its waiter has given up on it.
+func (d *deviceConn) completeISOSlot(idx int) {
+	d.isoMu.Lock()
+	defer d.isoMu.Unlock()
+
+	slot := &d.isoSlots[idx]
+	if slot.orphaned {
+		slot.pinner.Unpin()
+		slot.orphaned = false
+		slot.inUse = false
+		return
+	}
+	select {
+	case slot.complete <- struct{}{}:
+	default:
+	}
+}
+
+// submitISOURB submits an isochronous URB transferring directly to or from
+// data, which stays pinned until the URB is reaped. Returns the ISO slot
+// index, which is released by waitISOURB.
+func (d *deviceConn) submitISOURB(endpoint uint8, data []byte, xfer *hal.IsoTransfer) (int, error) {
+	n := len(xfer.Packets)
+	if n == 0 || n > MaxISOPacketsPerURB {
+		return -1, pkg.ErrInvalidParameter
+	}
+
+	total := xfer.Layout()
+	if total > len(data) {
+		return -1, pkg.ErrBufferTooSmall
+	}
+
+	idx := d.allocISOSlot()
+	if idx < 0 {
+		return -1, pkg.ErrNoResources
+	}
+
+	slot := &d.isoSlots[idx]
+	if total > 0 {
+		slot.pinner.Pin(&data[0])
+	}
+
+	u := &slot.urb
+	initISOURB(u, endpoint, data[:total], xfer.Packets, xfer.StartFrame, xfer.ASAP)
+	u.urb.userContext = urbContextISO | uintptr(idx)
+
+	if err := submitURB(d.fd, &u.urb); err != nil {
+		d.freeISOSlot(idx)
+		return -1, err
+	}
+	return idx, nil
+}
+
+// waitISOURB waits for a submitted ISO URB to be reaped, discarding it if
+// the context is cancelled or the timeout expires. Per-packet results are
+// copied to xfer. Returns the total number of bytes transferred. If the
+// discarded URB is not reaped in time, the slot and its pinned buffer are
+// released when it is.
+func (d *deviceConn) waitISOURB(ctx context.Context, idx int, xfer *hal.IsoTransfer, timeout time.Duration) (int, error) {
+	slot := &d.isoSlots[idx]
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-slot.complete:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = pkg.ErrTimeout
+	}
+
+	if err != nil {
+		// The kernel owns the URB until it is reaped
+		discardURB(d.fd, &slot.urb.urb)
+		select {
+		case <-slot.complete:
+		case <-time.After(timeout):
+			if d.orphanISOSlot(idx) {
+				pkg.LogWarn(pkg.ComponentHAL, "ISO URB not reaped after discard",
+					"endpoint", slot.urb.urb.endpoint)
+				return 0, err
+			}
+		}
+	}
+
+	u := &slot.urb
+	total := 0
+	for i := range xfer.Packets {
+		desc := &u.packets[i]
+		xfer.Packets[i].ActualLength = int(desc.actualLength)
+		xfer.Packets[i].Status = urbStatus(int32(desc.status))
+		total += int(desc.actualLength)
+	}
+	xfer.StartFrame = int(u.urb.startFrame)
+	xfer.ErrorCount = int(u.urb.errorCount)
+	d.freeISOSlot(idx)
+
+	if err != nil {
+		return total, err
+	}
+	if u.urb.status == -ENODEV {
+		d.handleENODEV()
+		return total, pkg.ErrNoDevice
+	}
+	if u.urb.status != 0 {
+		return total, urbStatus(u.urb.status).Error()
+	}
+	return total, nil
+}
+
 // discardAllURBs cancels all pending URBs (used during ENODEV recovery).
 func (d *deviceConn) discardAllURBs() {
 	for epIdx := range d.endpoints {
@@ -306,12 +538,23 @@ func (d *deviceConn) discardAllURBs() {
 		ep.mu.Unlock()
 	}
 
+	d.isoMu.Lock()
+	for i := range d.isoSlots {
+		if d.isoSlots[i].inUse {
+			discardURB(d.fd, &d.isoSlots[i].urb.urb)
+		}
+	}
+	d.isoMu.Unlock()
+
 	// Reap all discarded URBs
 	for {
-		_, err := reapURBNDelay(d.fd)
+		u, err := reapURBNDelay(d.fd)
 		if err != nil {
 			break
 		}
+		if u != nil {
+			d.completeURB(u)
+		}
 	}
 
 	// Reset all endpoint states
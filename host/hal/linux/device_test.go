@@ -3,9 +3,14 @@
 package linux
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/ardnew/softusb/host/hal"
+	"github.com/ardnew/softusb/pkg"
 )
 
 // =============================================================================
@@ -391,6 +396,192 @@ func TestURBSlot_Complete(t *testing.T) {
 	}
 }
 
+func TestDeviceConn_CompleteURB(t *testing.T) {
+	conn := &deviceConn{}
+	conn.endpoints[2].init()
+
+	u := &conn.endpoints[2].getSlot(1).urb
+	u.userContext = 2<<urbContextEndpShift | 1
+	u.status = -EPIPE
+	conn.completeURB(u)
+
+	select {
+	case err := <-conn.endpoints[2].getSlot(1).complete:
+		if !errors.Is(err, pkg.ErrStall) {
+			t.Errorf("completion error = %v, want %v", err, pkg.ErrStall)
+		}
+	default:
+		t.Error("endpoint slot was not notified")
+	}
+}
+
+// =============================================================================
+// ISO URB Tests
+// =============================================================================
+
+func TestISOURB_Layout(t *testing.T) {
+	// Packet descriptors must immediately follow struct usbdevfs_urb
+	if off := unsafe.Offsetof(isoURB{}.packets); off != unsafe.Sizeof(urb{}) {
+		t.Errorf("packets offset = %d, want %d", off, unsafe.Sizeof(urb{}))
+	}
+	if unsafe.Sizeof(urb{}) != sizeofURB {
+		t.Errorf("sizeof(urb) = %d, want %d", unsafe.Sizeof(urb{}), sizeofURB)
+	}
+	// struct usbdevfs_iso_packet_desc is 12 bytes
+	if size := unsafe.Sizeof(isoPacketDesc{}); size != 12 {
+		t.Errorf("sizeof(isoPacketDesc) = %d, want 12", size)
+	}
+}
+
+func TestInitISOURB(t *testing.T) {
+	var u isoURB
+	data := make([]byte, 96)
+	packets := []hal.IsoPacket{{Length: 32}, {Length: 64}}
+
+	initISOURB(&u, 0x81, data, packets, 10, true)
+
+	if u.urb.typ != URBTypeISO {
+		t.Errorf("typ = %d, want %d", u.urb.typ, URBTypeISO)
+	}
+	if u.urb.flags&URBISOAsap == 0 {
+		t.Error("ASAP flag not set")
+	}
+	if u.urb.numPackets != 2 {
+		t.Errorf("numPackets = %d, want 2", u.urb.numPackets)
+	}
+	if u.urb.bufferLength != 96 {
+		t.Errorf("bufferLength = %d, want 96", u.urb.bufferLength)
+	}
+	if u.packets[0].length != 32 || u.packets[1].length != 64 {
+		t.Errorf("packet lengths = %d, %d, want 32, 64",
+			u.packets[0].length, u.packets[1].length)
+	}
+}
+
+func TestURBStatus(t *testing.T) {
+	tests := []struct {
+		status int32
+		want   pkg.TransferStatus
+	}{
+		{0, pkg.TransferStatusSuccess},
+		{-EPIPE, pkg.TransferStatusStall},
+		{-ETIME, pkg.TransferStatusTimeout},
+		{-ETIMEDOUT, pkg.TransferStatusTimeout},
+		{-ENOENT, pkg.TransferStatusCancelled},
+		{-ECONNRESET, pkg.TransferStatusCancelled},
+		{-EOVERFLOW, pkg.TransferStatusOverrun},
+		{-ECOMM, pkg.TransferStatusOverrun},
+		{-ENOSR, pkg.TransferStatusUnderrun},
+		{-EPROTO, pkg.TransferStatusError},
+	}
+
+	for _, tt := range tests {
+		if got := urbStatus(tt.status); got != tt.want {
+			t.Errorf("urbStatus(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceConn_ISOSlots(t *testing.T) {
+	conn := &deviceConn{fd: -1}
+	conn.initISOSlots()
+
+	var indices [MaxISOURBsPerDevice]int
+	for i := range indices {
+		indices[i] = conn.allocISOSlot()
+		if indices[i] < 0 {
+			t.Fatalf("allocISOSlot() failed at iteration %d", i)
+		}
+	}
+
+	if idx := conn.allocISOSlot(); idx >= 0 {
+		t.Errorf("allocISOSlot() = %d, want -1 when pool exhausted", idx)
+	}
+
+	conn.freeISOSlot(indices[1])
+	if idx := conn.allocISOSlot(); idx != indices[1] {
+		t.Errorf("allocISOSlot() = %d, want %d", idx, indices[1])
+	}
+
+	// ISO completions are routed by slot index
+	u := &conn.isoSlots[indices[2]].urb.urb
+	u.userContext = urbContextISO | uintptr(indices[2])
+	conn.completeURB(u)
+	select {
+	case <-conn.isoSlots[indices[2]].complete:
+	default:
+		t.Error("ISO slot was not notified")
+	}
+}
+
+func TestDeviceConn_WaitISOURB_Orphaned(t *testing.T) {
+	conn := &deviceConn{fd: -1}
+	conn.initISOSlots()
+
+	data := make([]byte, 64)
+	idx := conn.allocISOSlot()
+	conn.isoSlots[idx].pinner.Pin(&data[0])
+	u := &conn.isoSlots[idx].urb.urb
+	u.userContext = urbContextISO | uintptr(idx)
+
+	// A cancelled URB that is not reaped keeps its slot reserved
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	xfer := hal.IsoTransfer{Packets: []hal.IsoPacket{{Length: len(data)}}}
+	if _, err := conn.waitISOURB(ctx, idx, &xfer, time.Millisecond); err != context.Canceled {
+		t.Fatalf("waitISOURB() error = %v, want %v", err, context.Canceled)
+	}
+	if !conn.isoSlots[idx].inUse || !conn.isoSlots[idx].orphaned {
+		t.Fatal("slot released before the URB was reaped")
+	}
+
+	// Reaping the URB releases the slot
+	conn.completeURB(u)
+	if conn.isoSlots[idx].inUse || conn.isoSlots[idx].orphaned {
+		t.Error("slot not released when the URB was reaped")
+	}
+
+	// A URB reaped before the slot is orphaned stays with the waiter
+	idx = conn.allocISOSlot()
+	conn.completeURB(u)
+	if conn.orphanISOSlot(idx) {
+		t.Error("orphanISOSlot() = true for a reaped URB")
+	}
+	conn.freeISOSlot(idx)
+}
+
+func TestDeviceConn_SubmitISOURB_Invalid(t *testing.T) {
+	conn := &deviceConn{fd: -1}
+	conn.initISOSlots()
+
+	data := make([]byte, 64)
+
+	xfer := hal.IsoTransfer{}
+	if _, err := conn.submitISOURB(0x81, data, &xfer); err != pkg.ErrInvalidParameter {
+		t.Errorf("no packets: err = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+
+	xfer.Packets = make([]hal.IsoPacket, MaxISOPacketsPerURB+1)
+	if _, err := conn.submitISOURB(0x81, data, &xfer); err != pkg.ErrInvalidParameter {
+		t.Errorf("too many packets: err = %v, want %v", err, pkg.ErrInvalidParameter)
+	}
+
+	xfer.Packets = []hal.IsoPacket{{Length: 48}, {Length: 48}}
+	if _, err := conn.submitISOURB(0x81, data, &xfer); err != pkg.ErrBufferTooSmall {
+		t.Errorf("short buffer: err = %v, want %v", err, pkg.ErrBufferTooSmall)
+	}
+	if xfer.Packets[1].Offset != 48 {
+		t.Errorf("Packets[1].Offset = %d, want 48", xfer.Packets[1].Offset)
+	}
+
+	// No slot may be leaked by a failed submit
+	for i := range conn.isoSlots {
+		if conn.isoSlots[i].inUse {
+			t.Errorf("isoSlots[%d] leaked", i)
+		}
+	}
+}
+
 // =============================================================================
 // Benchmarks
 // =============================================================================
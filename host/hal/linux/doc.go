@@ -26,6 +26,7 @@
 // # Supported Features
 //
 //   - Control, bulk, and interrupt transfers
+//   - Isochronous transfers with per-packet lengths and status
 //   - Device hotplug detection via netlink
 //   - Interface claiming with kernel driver detachment
 //   - USB 1.1 and USB 2.0 speeds (Low, Full, High)
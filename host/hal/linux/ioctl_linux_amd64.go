@@ -84,6 +84,7 @@ const (
 	sizeofBulkTransfer = 24 // struct usbdevfs_bulktransfer (with padding)
 	sizeofInt          = 4
 	sizeofPointer      = 8
+	sizeofURB          = 56 // struct usbdevfs_urb (without ISO frame descriptors)
 )
 
 // Usbdevfs ioctl numbers for amd64.
@@ -95,7 +96,7 @@ var (
 	ioctlUsbdevfsSetInterface     = ior(usbdevfsType, ioctlSetInterface, 8)
 	ioctlUsbdevfsSetConfiguration = ior(usbdevfsType, ioctlSetConfiguration, sizeofInt)
 	ioctlUsbdevfsGetDriver        = iow(usbdevfsType, ioctlGetDriver, 264)
-	ioctlUsbdevfsSubmitURB        = ior(usbdevfsType, ioctlSubmitURB, sizeofPointer)
+	ioctlUsbdevfsSubmitURB        = ior(usbdevfsType, ioctlSubmitURB, sizeofURB)
 	ioctlUsbdevfsDiscardURB       = ioctl(usbdevfsType, ioctlDiscardURB)
 	ioctlUsbdevfsReapURB          = iow(usbdevfsType, ioctlReapURB, sizeofPointer)
 	ioctlUsbdevfsReapURBNDelay    = iow(usbdevfsType, ioctlReapURBNDelay, sizeofPointer)
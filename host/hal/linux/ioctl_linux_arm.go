@@ -85,6 +85,7 @@ const (
 	sizeofBulkTransfer = 16 // struct usbdevfs_bulktransfer (32-bit)
 	sizeofInt          = 4
 	sizeofPointer      = 4
+	sizeofURB          = 44 // struct usbdevfs_urb (without ISO frame descriptors)
 )
 
 // Usbdevfs ioctl numbers for arm (32-bit).
@@ -96,7 +97,7 @@ var (
 	ioctlUsbdevfsSetInterface     = ior(usbdevfsType, ioctlSetInterface, 8)
 	ioctlUsbdevfsSetConfiguration = ior(usbdevfsType, ioctlSetConfiguration, sizeofInt)
 	ioctlUsbdevfsGetDriver        = iow(usbdevfsType, ioctlGetDriver, 264)
-	ioctlUsbdevfsSubmitURB        = ior(usbdevfsType, ioctlSubmitURB, sizeofPointer)
+	ioctlUsbdevfsSubmitURB        = ior(usbdevfsType, ioctlSubmitURB, sizeofURB)
 	ioctlUsbdevfsDiscardURB       = ioctl(usbdevfsType, ioctlDiscardURB)
 	ioctlUsbdevfsReapURB          = iow(usbdevfsType, ioctlReapURB, sizeofPointer)
 	ioctlUsbdevfsReapURBNDelay    = iow(usbdevfsType, ioctlReapURBNDelay, sizeofPointer)
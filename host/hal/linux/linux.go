@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ardnew/softusb/host/hal"
 	"github.com/ardnew/softusb/pkg"
@@ -317,10 +318,43 @@ func (h *HostHAL) InterruptTransfer(ctx context.Context, addr hal.DeviceAddress,
 	return h.BulkTransfer(ctx, addr, endpoint, data)
 }
 
-// IsochronousTransfer performs an isochronous transfer.
-func (h *HostHAL) IsochronousTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
-	// Isochronous transfers are not fully implemented
-	return 0, pkg.ErrNotSupported
+// IsochronousTransfer performs a packet-oriented isochronous transfer.
+// The transfer is submitted as a single ISO URB of at most
+// MaxISOPacketsPerURB packets; if xfer is nil, data is sent as one packet.
+// Each packet's Offset, ActualLength and Status are filled in on return,
+// along with the transfer's StartFrame and ErrorCount.
+func (h *HostHAL) IsochronousTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte, xfer *hal.IsoTransfer) (int, error) {
+	if xfer == nil {
+		var packets [1]hal.IsoPacket
+		packets[0].Length = len(data)
+		xfer = &hal.IsoTransfer{Packets: packets[:], ASAP: true}
+	}
+
+	conn := h.devices.findByAddress(addr)
+	if conn == nil {
+		return 0, pkg.ErrNoDevice
+	}
+
+	if conn.isDisconnected() {
+		return 0, pkg.ErrNoDevice
+	}
+
+	idx, err := conn.submitISOURB(endpoint, data, xfer)
+	if err == nil {
+		timeout := time.Duration(h.transferTimeout) * time.Millisecond
+		return conn.waitISOURB(ctx, idx, xfer, timeout)
+	}
+
+	if isNoDevice(err) {
+		conn.handleENODEV()
+		return 0, pkg.ErrNoDevice
+	}
+
+	if isPipe(err) {
+		return 0, pkg.ErrStall
+	}
+
+	return 0, err
 }
 
 // =============================================================================
@@ -518,7 +552,8 @@ func (h *HostHAL) handleDeviceAdd(info usbDeviceInfo) {
 	h.devices.set(slotIdx, conn)
 
 	// Add device fd to poller for URB completion
-	if err := h.poller.addFD(conn.fd, EPOLLIN, func(events uint32) {
+	// usbfs signals reapable URBs as writable
+	if err := h.poller.addFD(conn.fd, EPOLLOUT, func(events uint32) {
 		h.onDeviceEvent(conn, events)
 	}); err != nil {
 		pkg.LogWarn(pkg.ComponentHAL, "failed to add device to poller", "error", err)
@@ -577,7 +612,7 @@ func (h *HostHAL) onDeviceEvent(conn *deviceConn, events uint32) {
 		return
 	}
 
-	if events&EPOLLIN != 0 {
+	if events&EPOLLOUT != 0 {
 		// URB completed - try to reap it
 		for {
 			u, err := conn.reapAsyncURB()
@@ -594,7 +629,7 @@ func (h *HostHAL) onDeviceEvent(conn *deviceConn, events uint32) {
 				break
 			}
 			// URB completed - notify waiters
-			// This is handled by the endpoint slot's complete channel
+			conn.completeURB(u)
 		}
 	}
 }
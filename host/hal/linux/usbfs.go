@@ -5,6 +5,9 @@ package linux
 import (
 	"syscall"
 	"unsafe"
+
+	"github.com/ardnew/softusb/host/hal"
+	"github.com/ardnew/softusb/pkg"
 )
 
 // =============================================================================
@@ -12,28 +15,36 @@ import (
 // =============================================================================
 
 // urb represents a USB Request Block for async I/O.
-// This must match the kernel's struct usbdevfs_urb layout.
+// This must match the kernel's struct usbdevfs_urb layout, excluding the
+// trailing variable-length ISO frame descriptor array (see isoURB).
 type urb struct {
-	typ          uint8            // URB type (control, bulk, interrupt, iso)
-	endpoint     uint8            // Endpoint address
-	status       int32            // URB status after completion
-	flags        uint32           // URB flags
-	buffer       uintptr          // Pointer to data buffer
-	bufferLength int32            // Length of data buffer
-	actualLength int32            // Actual bytes transferred
-	startFrame   int32            // Start frame for ISO transfers
-	streamID     uint32           // Stream ID for USB 3.0 bulk streams
-	errorCount   int32            // Error count for ISO transfers
-	signr        uint32           // Signal number for async notification
-	userContext  uintptr          // User context pointer
-	isoFrameDesc [0]isoPacketDesc // ISO frame descriptors (variable length)
+	typ          uint8   // URB type (control, bulk, interrupt, iso)
+	endpoint     uint8   // Endpoint address
+	status       int32   // URB status after completion
+	flags        uint32  // URB flags
+	buffer       uintptr // Pointer to data buffer
+	bufferLength int32   // Length of data buffer
+	actualLength int32   // Actual bytes transferred
+	startFrame   int32   // Start frame for ISO transfers
+	numPackets   uint32  // Number of ISO packets (union with USB 3.0 stream ID)
+	errorCount   int32   // Error count for ISO transfers
+	signr        uint32  // Signal number for async notification
+	userContext  uintptr // User context pointer
 }
 
 // isoPacketDesc describes an isochronous packet.
+// This must match the kernel's struct usbdevfs_iso_packet_desc layout.
 type isoPacketDesc struct {
 	length       uint32 // Expected length
 	actualLength uint32 // Actual length
-	status       uint32 // Status
+	status       uint32 // Status (negative errno)
+}
+
+// isoURB is a URB immediately followed by its ISO frame descriptors, laid
+// out contiguously as usbfs expects for isochronous URBs.
+type isoURB struct {
+	urb     urb
+	packets [MaxISOPacketsPerURB]isoPacketDesc
 }
 
 // ctrlTransfer represents a control transfer request.
@@ -287,10 +298,55 @@ func initInterruptURB(u *urb, endpoint uint8, data []byte) {
 	}
 }
 
+// initISOURB initializes an ISO URB for the given packet lengths.
+// The packets of an ISO URB are laid out back to back in data.
+func initISOURB(u *isoURB, endpoint uint8, data []byte, packets []hal.IsoPacket, startFrame int, asap bool) {
+	u.urb.typ = URBTypeISO
+	u.urb.endpoint = endpoint
+	u.urb.status = 0
+	u.urb.flags = 0
+	if asap {
+		u.urb.flags = URBISOAsap
+	}
+	u.urb.bufferLength = int32(len(data))
+	u.urb.buffer = 0
+	if len(data) > 0 {
+		u.urb.buffer = uintptr(unsafe.Pointer(&data[0]))
+	}
+	u.urb.actualLength = 0
+	u.urb.startFrame = int32(startFrame)
+	u.urb.numPackets = uint32(len(packets))
+	u.urb.errorCount = 0
+	for i := range packets {
+		u.packets[i] = isoPacketDesc{length: uint32(packets[i].Length)}
+	}
+}
+
 // =============================================================================
 // Error Helpers
 // =============================================================================
 
+// urbStatus converts a URB or ISO packet status (negative errno) to a
+// transfer status.
+func urbStatus(status int32) pkg.TransferStatus {
+	switch -status {
+	case 0:
+		return pkg.TransferStatusSuccess
+	case EPIPE:
+		return pkg.TransferStatusStall
+	case ETIME, ETIMEDOUT:
+		return pkg.TransferStatusTimeout
+	case ENOENT, ECONNRESET:
+		return pkg.TransferStatusCancelled
+	case EOVERFLOW, ECOMM:
+		return pkg.TransferStatusOverrun
+	case ENOSR:
+		return pkg.TransferStatusUnderrun
+	default:
+		return pkg.TransferStatusError
+	}
+}
+
 // isNoDevice returns true if the error indicates the device was disconnected.
 func isNoDevice(err error) bool {
 	if errno, ok := err.(syscall.Errno); ok {
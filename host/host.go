@@ -16,6 +16,12 @@ type Host struct {
 	devices     [MaxDevices]*Device
 	deviceCount int
 
+	// Hub drivers for connected hubs (indexed by address - 1)
+	hubs [MaxDevices]*Hub
+
+	// Serializes enumeration so only one device is at the default address
+	enumMutex sync.Mutex
+
 	// Next available address
 	nextAddress uint8
 
@@ -91,8 +97,12 @@ func (h *Host) Stop() error {
 	}
 	h.mutex.Unlock()
 
-	// Close all devices
+	// Close all hubs and devices
 	for i := 0; i < MaxDevices; i++ {
+		if h.hubs[i] != nil {
+			h.hubs[i].close()
+			h.hubs[i] = nil
+		}
 		if h.devices[i] != nil {
 			h.devices[i].Close()
 			h.devices[i] = nil
@@ -188,40 +198,26 @@ func (h *Host) monitorDevices() {
 
 		pkg.LogDebug(pkg.ComponentHost, "device connected", "port", port)
 
-		// Enumerate the device
+		// Enumerate and attach the device. The address assigned during
+		// enumeration is not reserved until the device is attached, so
+		// enumMutex is held across both.
+		h.enumMutex.Lock()
 		dev, err := h.enumerateDevice(port)
+		attached := err == nil && h.attachDevice(dev)
+		if err == nil && !attached {
+			h.releaseDevice(dev)
+		}
+		h.enumMutex.Unlock()
+
 		if err != nil {
 			pkg.LogWarn(pkg.ComponentHost, "enumeration failed",
 				"port", port,
 				"error", err)
 			continue
 		}
-
-		// Add device to list
-		h.mutex.Lock()
-		if h.deviceCount < MaxDevices {
-			h.devices[dev.address-1] = dev
-			h.deviceCount++
-			cb := h.onDeviceConnect
-			h.mutex.Unlock()
-
-			// Notify
-			select {
-			case h.deviceConnected <- dev:
-			default:
-			}
-
-			if cb != nil {
-				cb(dev)
-			}
-
-			pkg.LogDebug(pkg.ComponentHost, "device enumerated",
-				"address", dev.address,
-				"vendor", dev.descriptor.VendorID,
-				"product", dev.descriptor.ProductID)
-		} else {
-			h.mutex.Unlock()
-			pkg.LogWarn(pkg.ComponentHost, "max devices reached")
+		if !attached {
+			h.hal.EnablePort(port, false)
+			continue
 		}
 
 		// Start monitoring for disconnection in a separate goroutine
@@ -229,24 +225,64 @@ func (h *Host) monitorDevices() {
 	}
 }
 
-// monitorDisconnection monitors for device disconnection.
-func (h *Host) monitorDisconnection(port int, dev *Device) {
-	// Wait for disconnection
-	_, err := h.hal.WaitForDisconnection(h.ctx)
-	if err != nil {
-		if h.ctx.Err() != nil {
-			return
-		}
-		return
+// attachDevice adds an enumerated device to the device list, notifies
+// listeners, and starts the hub driver if the device is a hub. The caller
+// must hold enumMutex from enumeration through attachment so that the
+// device's address is not allocated to another device in between.
+func (h *Host) attachDevice(dev *Device) bool {
+	h.mutex.Lock()
+	if h.deviceCount >= MaxDevices {
+		h.mutex.Unlock()
+		pkg.LogWarn(pkg.ComponentHost, "max devices reached")
+		return false
+	}
+	h.devices[dev.address-1] = dev
+	h.deviceCount++
+	cb := h.onDeviceConnect
+	h.mutex.Unlock()
+
+	// Notify
+	select {
+	case h.deviceConnected <- dev:
+	default:
 	}
 
-	pkg.LogDebug(pkg.ComponentHost, "device disconnected",
-		"port", port,
-		"address", dev.address)
+	if cb != nil {
+		cb(dev)
+	}
+
+	pkg.LogDebug(pkg.ComponentHost, "device enumerated",
+		"address", dev.address,
+		"vendor", dev.descriptor.VendorID,
+		"product", dev.descriptor.ProductID,
+		"tier", dev.tier,
+		"route", dev.route)
 
-	// Remove device from list
+	if dev.IsHub() {
+		h.startHub(dev)
+	}
+	return true
+}
+
+// detachDevice removes a device from the device list and notifies
+// listeners. If the device is a hub, its downstream devices are
+// detached first.
+func (h *Host) detachDevice(dev *Device) {
 	h.mutex.Lock()
+	var hub *Hub
 	if dev.address > 0 && dev.address <= MaxDevices {
+		hub = h.hubs[dev.address-1]
+		h.hubs[dev.address-1] = nil
+	}
+	h.mutex.Unlock()
+
+	if hub != nil {
+		hub.close()
+		hub.detachAll()
+	}
+
+	h.mutex.Lock()
+	if dev.address > 0 && dev.address <= MaxDevices && h.devices[dev.address-1] == dev {
 		h.devices[dev.address-1] = nil
 		h.deviceCount--
 	}
@@ -266,6 +302,42 @@ func (h *Host) monitorDisconnection(port int, dev *Device) {
 	}
 }
 
+// startHub creates and starts the hub driver for a newly enumerated hub.
+func (h *Host) startHub(dev *Device) {
+	if dev.tier > MaxHubDepth {
+		pkg.LogWarn(pkg.ComponentHost, "hub exceeds maximum depth, ignoring",
+			"address", dev.address,
+			"tier", dev.tier)
+		return
+	}
+
+	hub := newHub(h, dev)
+
+	h.mutex.Lock()
+	h.hubs[dev.address-1] = hub
+	h.mutex.Unlock()
+
+	go hub.run()
+}
+
+// monitorDisconnection monitors for device disconnection.
+func (h *Host) monitorDisconnection(port int, dev *Device) {
+	// Wait for disconnection
+	_, err := h.hal.WaitForDisconnection(h.ctx)
+	if err != nil {
+		if h.ctx.Err() != nil {
+			return
+		}
+		return
+	}
+
+	pkg.LogDebug(pkg.ComponentHost, "device disconnected",
+		"port", port,
+		"address", dev.address)
+
+	h.detachDevice(dev)
+}
+
 // allocateAddress allocates a new device address.
 func (h *Host) allocateAddress() uint8 {
 	h.mutex.Lock()
@@ -286,6 +358,49 @@ func (h *Host) allocateAddress() uint8 {
 	return 0 // No address available
 }
 
+// freeAddress returns an address that was allocated but never attached so
+// that it is handed out again by the next allocation.
+func (h *Host) freeAddress(address uint8) {
+	if address == 0 || address > MaxDevices {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.devices[address-1] == nil {
+		h.nextAddress = address
+	}
+}
+
+// releaseDevice tears down a device that was addressed during enumeration
+// but is not attached to the host: the device is deconfigured and closed,
+// and its address is freed.
+func (h *Host) releaseDevice(dev *Device) {
+	if dev.GetConfiguration() > 0 {
+		if err := dev.SetConfiguration(h.ctx, 0); err != nil {
+			pkg.LogDebug(pkg.ComponentHost, "deconfigure failed",
+				"address", dev.address,
+				"error", err)
+		}
+	}
+	dev.Close()
+
+	h.freeAddress(dev.address)
+	dev.address = 0
+}
+
+// GetHub returns the hub driver for the hub at the given address,
+// or nil if no hub is connected at that address.
+func (h *Host) GetHub(address uint8) *Hub {
+	if address == 0 || address > MaxDevices {
+		return nil
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.hubs[address-1]
+}
+
 // NumPorts returns the number of root hub ports.
 func (h *Host) NumPorts() int {
 	return h.hal.NumPorts()
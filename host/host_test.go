@@ -34,6 +34,7 @@ type mockHAL struct {
 	bulkErr       error
 	interruptErr  error
 	isoErr        error
+	setups        []hal.SetupPacket
 
 	// State tracking
 	running bool
@@ -92,6 +93,9 @@ func (m *mockHAL) EnablePort(port int, enable bool) error {
 }
 
 func (m *mockHAL) ControlTransfer(ctx context.Context, addr hal.DeviceAddress, setup *hal.SetupPacket, data []byte) (int, error) {
+	m.mu.Lock()
+	m.setups = append(m.setups, *setup)
+	m.mu.Unlock()
 	return m.controlResult, m.controlErr
 }
 
@@ -103,8 +107,16 @@ func (m *mockHAL) InterruptTransfer(ctx context.Context, addr hal.DeviceAddress,
 	return 0, m.interruptErr
 }
 
-func (m *mockHAL) IsochronousTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte) (int, error) {
-	return 0, m.isoErr
+func (m *mockHAL) IsochronousTransfer(ctx context.Context, addr hal.DeviceAddress, endpoint uint8, data []byte, xfer *hal.IsoTransfer) (int, error) {
+	if m.isoErr != nil || xfer == nil {
+		return 0, m.isoErr
+	}
+	// Complete every packet in full
+	xfer.Layout()
+	for i := range xfer.Packets {
+		xfer.Packets[i].ActualLength = xfer.Packets[i].Length
+	}
+	return xfer.ActualLength(), nil
 }
 
 func (m *mockHAL) SetDeviceAddress(ctx context.Context, newAddr hal.DeviceAddress) error {
@@ -318,6 +330,59 @@ func TestHost_AllocateAddress(t *testing.T) {
 	}
 }
 
+func TestHost_EnumerateFailureFreesAddress(t *testing.T) {
+	mock := newMockHAL()
+	h := New(mock)
+
+	// Every transfer returns 8 bytes, so enumeration fails on the full
+	// device descriptor after the device is addressed
+	mock.controlResult = 8
+	dev := newDevice(h, 1, 0, hal.SpeedFull)
+	if err := h.enumerate(dev); err != ErrEnumerationFailed {
+		t.Fatalf("enumerate() error = %v, want %v", err, ErrEnumerationFailed)
+	}
+
+	if dev.Address() != 0 {
+		t.Errorf("Address() = %d, want 0", dev.Address())
+	}
+	if dev.State() != DeviceStateDetached {
+		t.Errorf("State() = %v, want DeviceStateDetached", dev.State())
+	}
+	if addr := h.allocateAddress(); addr != 1 {
+		t.Errorf("allocateAddress() = %d after failed enumeration, want 1", addr)
+	}
+}
+
+func TestHost_ReleaseDevice(t *testing.T) {
+	mock := newMockHAL()
+	h := New(mock)
+
+	addr := h.allocateAddress()
+	dev := newDevice(h, 1, addr, hal.SpeedFull)
+	dev.configurationValue = 1
+	dev.state = DeviceStateConfigured
+
+	// A full device table leaves the enumerated device unattached
+	h.deviceCount = MaxDevices
+	if h.attachDevice(dev) {
+		t.Fatal("attachDevice() should fail with the device table full")
+	}
+	h.releaseDevice(dev)
+
+	if len(mock.setups) != 1 {
+		t.Fatalf("sent %d control requests, want 1", len(mock.setups))
+	}
+	if setup := mock.setups[0]; setup.Request != RequestSetConfiguration || setup.Value != 0 {
+		t.Errorf("sent request 0x%02X value %d, want SET_CONFIGURATION 0", setup.Request, setup.Value)
+	}
+	if dev.State() != DeviceStateDetached || dev.Address() != 0 {
+		t.Errorf("device state %v, address %d, want detached at 0", dev.State(), dev.Address())
+	}
+	if h.nextAddress != addr {
+		t.Errorf("nextAddress = %d, want %d", h.nextAddress, addr)
+	}
+}
+
 func TestHost_SetCallbacks(t *testing.T) {
 	mock := newMockHAL()
 	h := New(mock)
@@ -460,6 +525,47 @@ func TestDevice_GetEndpoint(t *testing.T) {
 	}
 }
 
+func TestDevice_Topology(t *testing.T) {
+	root := newDevice(nil, 3, 1, hal.SpeedHigh)
+	root.descriptor.DeviceClass = ClassHub
+
+	if !root.IsHub() {
+		t.Error("IsHub() = false for hub class device")
+	}
+	if root.Parent() != nil {
+		t.Error("Parent() should be nil for root device")
+	}
+	if got := root.Tier(); got != 1 {
+		t.Errorf("Tier() = %d, want 1", got)
+	}
+
+	hub := newChildDevice(nil, root, 1, hal.SpeedHigh)
+	hub.interfaces = []InterfaceDescriptor{{InterfaceClass: ClassHub}}
+	if !hub.IsHub() {
+		t.Error("IsHub() = false for hub class interface")
+	}
+
+	child := newChildDevice(nil, hub, 2, hal.SpeedFull)
+	if child.IsHub() {
+		t.Error("IsHub() = true for non-hub device")
+	}
+	if child.Parent() != hub {
+		t.Error("Parent() did not return the attached hub")
+	}
+	if got := child.Port(); got != 2 {
+		t.Errorf("Port() = %d, want 2", got)
+	}
+	if got := child.RootPort(); got != 3 {
+		t.Errorf("RootPort() = %d, want 3", got)
+	}
+	if got := child.Tier(); got != 3 {
+		t.Errorf("Tier() = %d, want 3", got)
+	}
+	if got := child.Route(); got != 0x21 {
+		t.Errorf("Route() = 0x%X, want 0x21", got)
+	}
+}
+
 func TestDevice_State(t *testing.T) {
 	dev := &Device{state: DeviceStateConfigured}
 
@@ -558,6 +664,51 @@ func TestDevice_ParseConfigurationTree(t *testing.T) {
 	}
 }
 
+func TestDevice_InterfaceEndpointsAndClassDescriptors(t *testing.T) {
+	dev := &Device{}
+
+	// Communications interface with a union descriptor and a notification
+	// endpoint, followed by a data interface with two bulk endpoints
+	data := []byte{
+		9, 0x02, 53, 0x00, 2, 1, 0, 0x80, 50,
+		9, 0x04, 0, 0, 1, 0x02, 0x02, 0x01, 0,
+		5, 0x24, 0x06, 0, 1, // Union: control 0, subordinate 1
+		7, 0x05, 0x83, 0x03, 0x08, 0x00, 16,
+		9, 0x04, 1, 0, 2, 0x0A, 0x00, 0x00, 0,
+		7, 0x05, 0x81, 0x02, 0x40, 0x00, 0,
+		7, 0x05, 0x02, 0x02, 0x40, 0x00, 0,
+	}
+
+	dev.parseConfigurationTree(data)
+
+	if len(dev.Interfaces()) != 2 {
+		t.Fatalf("len(Interfaces()) = %d, want 2", len(dev.Interfaces()))
+	}
+
+	eps := dev.InterfaceEndpoints(0)
+	if len(eps) != 1 || eps[0].EndpointAddress != 0x83 {
+		t.Errorf("InterfaceEndpoints(0) = %+v, want [0x83]", eps)
+	}
+	eps = dev.InterfaceEndpoints(1)
+	if len(eps) != 2 || eps[0].EndpointAddress != 0x81 || eps[1].EndpointAddress != 0x02 {
+		t.Errorf("InterfaceEndpoints(1) = %+v, want [0x81 0x02]", eps)
+	}
+	if eps := dev.InterfaceEndpoints(2); eps != nil {
+		t.Errorf("InterfaceEndpoints(2) = %+v, want nil", eps)
+	}
+
+	descs := dev.ClassDescriptors(0)
+	if len(descs) != 1 {
+		t.Fatalf("len(ClassDescriptors(0)) = %d, want 1", len(descs))
+	}
+	if descs[0][1] != 0x24 || descs[0][2] != 0x06 || descs[0][4] != 1 {
+		t.Errorf("ClassDescriptors(0)[0] = %v, want union descriptor", descs[0])
+	}
+	if descs := dev.ClassDescriptors(1); len(descs) != 0 {
+		t.Errorf("len(ClassDescriptors(1)) = %d, want 0", len(descs))
+	}
+}
+
 // =============================================================================
 // Transfer Tests
 // =============================================================================
@@ -628,6 +779,54 @@ func TestTransferManager_PendingCount(t *testing.T) {
 	}
 }
 
+func TestTransferManager_Isochronous(t *testing.T) {
+	mock := newMockHAL()
+	h := New(mock)
+	tm := NewTransferManager(h, 1)
+
+	if err := tm.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer tm.Stop()
+
+	var packets [4]hal.IsoPacket
+	iso := &hal.IsoTransfer{Packets: packets[:], ASAP: true}
+	data := make([]byte, iso.SetupPackets(48))
+
+	done := make(chan struct{})
+	tr := &Transfer{
+		Address:  1,
+		Endpoint: 0x81,
+		Type:     hal.TransferIsochronous,
+		Data:     data,
+		Iso:      iso,
+		Callback: func(*Transfer, int, error) { close(done) },
+	}
+
+	if _, err := tm.Submit(tr); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("transfer did not complete")
+	}
+
+	n, err := tr.Result()
+	if err != nil {
+		t.Fatalf("Result() err = %v", err)
+	}
+	if n != 192 {
+		t.Errorf("Result() n = %d, want 192", n)
+	}
+	for i := range packets {
+		if packets[i].Offset != i*48 || packets[i].ActualLength != 48 {
+			t.Errorf("packets[%d] = %+v, want offset %d length 48", i, packets[i], i*48)
+		}
+	}
+}
+
 // =============================================================================
 // Pipe Tests
 // =============================================================================
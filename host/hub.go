@@ -0,0 +1,513 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ardnew/softusb/host/hal"
+	"github.com/ardnew/softusb/pkg"
+)
+
+// Hub errors.
+var (
+	ErrNoStatusEndpoint = errors.New("hub has no status change endpoint")
+	ErrInvalidHubPort   = errors.New("invalid hub port")
+	ErrPortResetTimeout = errors.New("hub port reset timed out")
+)
+
+// Hub timing (USB 2.0 Sections 7.1.7.3 and 11.5.1.5).
+const (
+	hubDebounceInterval  = 100 * time.Millisecond // Connect debounce (TATTDB)
+	hubResetTimeout      = 500 * time.Millisecond // Maximum time to wait for reset completion
+	hubResetPollInterval = 10 * time.Millisecond  // Port status poll interval during reset
+	hubResetRecovery     = 10 * time.Millisecond  // Reset recovery time (TRSTRCY)
+	hubRetryInterval     = 50 * time.Millisecond  // Delay after a failed status poll
+)
+
+// Hub is the host-side class driver for an external USB hub.
+//
+// A Hub is created automatically when a device with the hub class is
+// enumerated. It reads the hub descriptor, powers the downstream ports,
+// and polls the status change endpoint. Devices connected to downstream
+// ports are enumerated and reported through the same [Host] callbacks and
+// channels as devices on root ports; hubs connected downstream get their
+// own Hub, up to [MaxHubDepth] tiers.
+type Hub struct {
+	host   *Host
+	device *Device
+
+	descriptor     HubDescriptor
+	numPorts       int
+	statusEndpoint uint8
+
+	// Devices attached to downstream ports (indexed by port - 1)
+	children [MaxHubPorts]*Device
+	mutex    sync.RWMutex
+
+	// Context for cancellation
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newHub creates a hub driver for an enumerated hub device.
+func newHub(h *Host, dev *Device) *Hub {
+	hub := &Hub{
+		host:   h,
+		device: dev,
+	}
+	hub.ctx, hub.cancel = context.WithCancel(h.ctx)
+	return hub
+}
+
+// Device returns the hub device.
+func (hub *Hub) Device() *Device {
+	return hub.device
+}
+
+// Descriptor returns the hub descriptor.
+func (hub *Hub) Descriptor() HubDescriptor {
+	return hub.descriptor
+}
+
+// NumPorts returns the number of downstream ports managed by the driver.
+func (hub *Hub) NumPorts() int {
+	return hub.numPorts
+}
+
+// Child returns the device attached to the given downstream port (1-indexed),
+// or nil if no device is attached.
+func (hub *Hub) Child(port int) *Device {
+	if port < 1 || port > MaxHubPorts {
+		return nil
+	}
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return hub.children[port-1]
+}
+
+// GetPortStatus performs a GET_STATUS request on a downstream port.
+func (hub *Hub) GetPortStatus(ctx context.Context, port int) (HubPortStatus, error) {
+	var status HubPortStatus
+	if port < 1 || port > hub.numPorts {
+		return status, ErrInvalidHubPort
+	}
+
+	var buf [4]byte
+	setup := hal.SetupPacket{
+		RequestType: RequestTypeIn | RequestTypeClass | RequestTypeOther,
+		Request:     RequestGetStatus,
+		Value:       0,
+		Index:       uint16(port),
+		Length:      4,
+	}
+
+	n, err := hub.device.ControlTransfer(ctx, &setup, buf[:])
+	if err != nil {
+		return status, err
+	}
+	if !ParseHubPortStatus(buf[:n], &status) {
+		return status, pkg.ErrProtocol
+	}
+	return status, nil
+}
+
+// SetPortFeature performs a SET_FEATURE request on a downstream port.
+func (hub *Hub) SetPortFeature(ctx context.Context, port int, feature uint16) error {
+	return hub.portFeature(ctx, RequestSetFeature, port, feature)
+}
+
+// ClearPortFeature performs a CLEAR_FEATURE request on a downstream port.
+func (hub *Hub) ClearPortFeature(ctx context.Context, port int, feature uint16) error {
+	return hub.portFeature(ctx, RequestClearFeature, port, feature)
+}
+
+// portFeature performs a SET_FEATURE or CLEAR_FEATURE request on a port.
+func (hub *Hub) portFeature(ctx context.Context, request uint8, port int, feature uint16) error {
+	if port < 1 || port > hub.numPorts {
+		return ErrInvalidHubPort
+	}
+
+	setup := hal.SetupPacket{
+		RequestType: RequestTypeOut | RequestTypeClass | RequestTypeOther,
+		Request:     request,
+		Value:       feature,
+		Index:       uint16(port),
+		Length:      0,
+	}
+
+	_, err := hub.device.ControlTransfer(ctx, &setup, nil)
+	return err
+}
+
+// GetHubStatus performs a GET_STATUS request on the hub itself.
+// Returns the wHubStatus and wHubChange fields.
+func (hub *Hub) GetHubStatus(ctx context.Context) (status, change uint16, err error) {
+	var buf [4]byte
+	setup := hal.SetupPacket{
+		RequestType: RequestTypeIn | RequestTypeClass | RequestTypeDevice,
+		Request:     RequestGetStatus,
+		Value:       0,
+		Index:       0,
+		Length:      4,
+	}
+
+	n, err := hub.device.ControlTransfer(ctx, &setup, buf[:])
+	if err != nil {
+		return 0, 0, err
+	}
+	if n < 4 {
+		return 0, 0, pkg.ErrProtocol
+	}
+	status = uint16(buf[0]) | uint16(buf[1])<<8
+	change = uint16(buf[2]) | uint16(buf[3])<<8
+	return status, change, nil
+}
+
+// ClearHubFeature performs a CLEAR_FEATURE request on the hub itself.
+func (hub *Hub) ClearHubFeature(ctx context.Context, feature uint16) error {
+	setup := hal.SetupPacket{
+		RequestType: RequestTypeOut | RequestTypeClass | RequestTypeDevice,
+		Request:     RequestClearFeature,
+		Value:       feature,
+		Index:       0,
+		Length:      0,
+	}
+
+	_, err := hub.device.ControlTransfer(ctx, &setup, nil)
+	return err
+}
+
+// close stops the hub driver. Devices attached to downstream ports are
+// left in place; use detachAll to remove them.
+func (hub *Hub) close() {
+	hub.cancel()
+}
+
+// detachAll detaches every device attached to a downstream port.
+func (hub *Hub) detachAll() {
+	for port := 1; port <= MaxHubPorts; port++ {
+		hub.detachPort(port)
+	}
+}
+
+// run configures the hub and services status change notifications until
+// the hub is closed.
+func (hub *Hub) run() {
+	if err := hub.configure(); err != nil {
+		if hub.ctx.Err() == nil {
+			pkg.LogWarn(pkg.ComponentHost, "hub configuration failed",
+				"address", hub.device.address,
+				"error", err)
+		}
+		return
+	}
+
+	// Devices already present when power was applied may not have a
+	// pending change notification, so check every port once.
+	for port := 1; port <= hub.numPorts; port++ {
+		hub.handlePortChange(port)
+	}
+
+	// Status change bitmap: bit 0 is the hub, bit N is port N
+	var bitmap [(MaxHubPorts + 8) / 8]byte
+	size := (hub.numPorts + 8) / 8
+
+	for {
+		if hub.ctx.Err() != nil {
+			return
+		}
+
+		n, err := hub.device.InterruptTransfer(hub.ctx, hub.statusEndpoint, bitmap[:size])
+		if err != nil {
+			if hub.ctx.Err() != nil {
+				return
+			}
+			pkg.LogDebug(pkg.ComponentHost, "hub status poll failed",
+				"address", hub.device.address,
+				"error", err)
+			if !hub.sleep(hubRetryInterval) {
+				return
+			}
+			continue
+		}
+
+		if n > 0 && bitmap[0]&0x01 != 0 {
+			hub.handleHubChange()
+		}
+		for port := 1; port <= hub.numPorts; port++ {
+			if port/8 < n && bitmap[port/8]&(1<<(port%8)) != 0 {
+				hub.handlePortChange(port)
+			}
+		}
+	}
+}
+
+// configure reads the hub descriptor, locates the status change endpoint,
+// and powers the downstream ports.
+func (hub *Hub) configure() error {
+	for i := range hub.device.endpoints {
+		ep := &hub.device.endpoints[i]
+		if ep.IsInterrupt() && ep.IsIn() {
+			hub.statusEndpoint = ep.EndpointAddress
+			break
+		}
+	}
+	if hub.statusEndpoint == 0 {
+		return ErrNoStatusEndpoint
+	}
+
+	var buf [HubDescriptorMaxSize]byte
+	setup := hal.SetupPacket{
+		RequestType: RequestTypeIn | RequestTypeClass | RequestTypeDevice,
+		Request:     RequestGetDescriptor,
+		Value:       uint16(DescriptorTypeHub) << 8,
+		Index:       0,
+		Length:      HubDescriptorMaxSize,
+	}
+
+	n, err := hub.device.ControlTransfer(hub.ctx, &setup, buf[:])
+	if err != nil {
+		return err
+	}
+	if !ParseHubDescriptor(buf[:n], &hub.descriptor) {
+		return ErrEnumerationFailed
+	}
+
+	hub.numPorts = int(hub.descriptor.NumPorts)
+	if hub.numPorts > MaxHubPorts {
+		pkg.LogWarn(pkg.ComponentHost, "hub has more ports than supported",
+			"address", hub.device.address,
+			"ports", hub.numPorts,
+			"max", MaxHubPorts)
+		hub.numPorts = MaxHubPorts
+	}
+
+	pkg.LogDebug(pkg.ComponentHost, "hub descriptor",
+		"address", hub.device.address,
+		"ports", hub.numPorts,
+		"characteristics", hub.descriptor.Characteristics,
+		"powerOnDelay", hub.descriptor.PowerOnDelay())
+
+	for port := 1; port <= hub.numPorts; port++ {
+		if err := hub.SetPortFeature(hub.ctx, port, PortFeaturePower); err != nil {
+			return err
+		}
+	}
+
+	if !hub.sleep(hub.descriptor.PowerOnDelay()) {
+		return pkg.ErrCancelled
+	}
+	return nil
+}
+
+// handleHubChange acknowledges hub-level status changes.
+func (hub *Hub) handleHubChange() {
+	status, change, err := hub.GetHubStatus(hub.ctx)
+	if err != nil {
+		pkg.LogDebug(pkg.ComponentHost, "hub status read failed",
+			"address", hub.device.address,
+			"error", err)
+		return
+	}
+
+	if change&HubStatusLocalPower != 0 {
+		hub.ClearHubFeature(hub.ctx, HubFeatureCLocalPower)
+	}
+	if change&HubStatusOverCurrent != 0 {
+		hub.ClearHubFeature(hub.ctx, HubFeatureCOverCurrent)
+		if status&HubStatusOverCurrent != 0 {
+			pkg.LogWarn(pkg.ComponentHost, "hub over-current",
+				"address", hub.device.address)
+		}
+	}
+}
+
+// handlePortChange reads a port's status, acknowledges its change bits,
+// and attaches or detaches the downstream device as needed.
+func (hub *Hub) handlePortChange(port int) {
+	status, err := hub.GetPortStatus(hub.ctx, port)
+	if err != nil {
+		pkg.LogDebug(pkg.ComponentHost, "port status read failed",
+			"address", hub.device.address,
+			"port", port,
+			"error", err)
+		return
+	}
+
+	if status.Change&PortChangeConnection != 0 {
+		hub.ClearPortFeature(hub.ctx, port, PortFeatureCConnection)
+	}
+	if status.Change&PortChangeEnable != 0 {
+		hub.ClearPortFeature(hub.ctx, port, PortFeatureCEnable)
+	}
+	if status.Change&PortChangeSuspend != 0 {
+		hub.ClearPortFeature(hub.ctx, port, PortFeatureCSuspend)
+	}
+	if status.Change&PortChangeReset != 0 {
+		hub.ClearPortFeature(hub.ctx, port, PortFeatureCReset)
+	}
+	if status.Change&PortChangeOverCurrent != 0 {
+		hub.ClearPortFeature(hub.ctx, port, PortFeatureCOverCurrent)
+		if status.OverCurrent() {
+			pkg.LogWarn(pkg.ComponentHost, "hub port over-current",
+				"address", hub.device.address,
+				"port", port)
+		}
+	}
+
+	// A connection change on an occupied port means the device was
+	// replaced, even if a device is present now.
+	if hub.Child(port) != nil && (!status.Connected() || status.Change&PortChangeConnection != 0) {
+		hub.detachPort(port)
+	}
+
+	if status.Connected() && hub.Child(port) == nil {
+		hub.attachPort(port)
+	}
+}
+
+// attachPort resets a downstream port and enumerates the attached device.
+func (hub *Hub) attachPort(port int) {
+	if !hub.sleep(hubDebounceInterval) {
+		return
+	}
+
+	h := hub.host
+	hubAddr := hal.DeviceAddress(hub.device.address)
+
+	h.enumMutex.Lock()
+	status, err := hub.resetPort(port)
+	if err == nil {
+		if hh, ok := h.hal.(hal.HubHAL); ok {
+			err = hh.AttachHubPort(hub.ctx, hubAddr, port, status.Speed())
+		}
+	}
+	var dev *Device
+	if err == nil {
+		pkg.LogDebug(pkg.ComponentHost, "starting enumeration",
+			"hub", hub.device.address,
+			"port", port,
+			"speed", status.Speed())
+		dev = newChildDevice(h, hub.device, port, status.Speed())
+		err = h.enumerate(dev)
+	}
+
+	// The address assigned during enumeration is not reserved until the
+	// device is attached
+	attached := false
+	if err == nil {
+		hub.mutex.Lock()
+		hub.children[port-1] = dev
+		hub.mutex.Unlock()
+
+		attached = h.attachDevice(dev)
+		if !attached {
+			hub.mutex.Lock()
+			hub.children[port-1] = nil
+			hub.mutex.Unlock()
+			h.releaseDevice(dev)
+		}
+	}
+	h.enumMutex.Unlock()
+
+	if attached {
+		return
+	}
+	if err != nil && hub.ctx.Err() == nil {
+		pkg.LogWarn(pkg.ComponentHost, "enumeration failed",
+			"hub", hub.device.address,
+			"port", port,
+			"error", err)
+	}
+
+	hub.ClearPortFeature(hub.ctx, port, PortFeatureEnable)
+	if hh, ok := h.hal.(hal.HubHAL); ok {
+		hh.DetachHubPort(hubAddr, port)
+	}
+}
+
+// detachPort detaches the device on a downstream port, if any.
+func (hub *Hub) detachPort(port int) {
+	if port < 1 || port > MaxHubPorts {
+		return
+	}
+
+	hub.mutex.Lock()
+	dev := hub.children[port-1]
+	hub.children[port-1] = nil
+	hub.mutex.Unlock()
+
+	if dev == nil {
+		return
+	}
+
+	pkg.LogDebug(pkg.ComponentHost, "device disconnected",
+		"hub", hub.device.address,
+		"port", port,
+		"address", dev.address)
+
+	hub.host.detachDevice(dev)
+
+	if hh, ok := hub.host.hal.(hal.HubHAL); ok {
+		hh.DetachHubPort(hal.DeviceAddress(hub.device.address), port)
+	}
+}
+
+// resetPort drives reset on a downstream port and waits for it to complete.
+// Returns the port status after reset.
+func (hub *Hub) resetPort(port int) (HubPortStatus, error) {
+	var status HubPortStatus
+
+	if err := hub.SetPortFeature(hub.ctx, port, PortFeatureReset); err != nil {
+		return status, err
+	}
+
+	deadline := time.Now().Add(hubResetTimeout)
+	for {
+		if !hub.sleep(hubResetPollInterval) {
+			return status, pkg.ErrCancelled
+		}
+
+		var err error
+		status, err = hub.GetPortStatus(hub.ctx, port)
+		if err != nil {
+			return status, err
+		}
+		if status.Change&PortChangeReset != 0 && !status.Resetting() {
+			break
+		}
+		if time.Now().After(deadline) {
+			return status, ErrPortResetTimeout
+		}
+	}
+
+	if err := hub.ClearPortFeature(hub.ctx, port, PortFeatureCReset); err != nil {
+		return status, err
+	}
+	if !status.Connected() || !status.Enabled() {
+		return status, ErrEnumerationFailed
+	}
+
+	if !hub.sleep(hubResetRecovery) {
+		return status, pkg.ErrCancelled
+	}
+	return status, nil
+}
+
+// sleep waits for d or until the hub is closed.
+// Returns false if the hub was closed.
+func (hub *Hub) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return hub.ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-hub.ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -26,6 +26,10 @@ type Transfer struct {
 	// Setup packet (for control transfers only)
 	Setup *hal.SetupPacket
 
+	// Packet descriptors (for isochronous transfers only)
+	// If nil, Data is transferred as a single packet.
+	Iso *hal.IsoTransfer
+
 	// Callback when transfer completes
 	Callback func(*Transfer, int, error)
 
@@ -194,7 +198,7 @@ func (tm *TransferManager) executeTransfer(t *Transfer) {
 		n, err = tm.host.hal.InterruptTransfer(ctx, hal.DeviceAddress(t.Address), t.Endpoint, t.Data)
 
 	case hal.TransferIsochronous:
-		n, err = tm.host.hal.IsochronousTransfer(ctx, hal.DeviceAddress(t.Address), t.Endpoint, t.Data)
+		n, err = tm.host.hal.IsochronousTransfer(ctx, hal.DeviceAddress(t.Address), t.Endpoint, t.Data, t.Iso)
 
 	default:
 		err = pkg.ErrInvalidParameter